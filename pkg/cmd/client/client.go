@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"net"
 
 	"github.com/creachadair/jrpc2"
@@ -12,6 +13,9 @@ import (
 )
 
 var serverAddr = flag.String("server", "", "Server address")
+var storageStatsFlag = flag.Bool("storage-stats", false, "Print the etcd storage statistics of the databases and exit")
+var dbName = flag.String("db", "", "Database of the storage statistics, all the databases if empty")
+var token = flag.String("token", "", "Token of an admin identity, the storage statistics are served to admins only")
 
 func listDbs(ctx context.Context, cli *jrpc2.Client) (result []string, err error) {
 	err = cli.CallResult(ctx, "list_dbs", nil, &result)
@@ -38,6 +42,20 @@ func unlock(ctx context.Context, cli *jrpc2.Client, id string) (result interface
 	return
 }
 
+func authenticate(ctx context.Context, cli *jrpc2.Client, token string) (result interface{}, err error) {
+	err = cli.CallResult(ctx, "authenticate", []string{token}, &result)
+	return
+}
+
+func storageStats(ctx context.Context, cli *jrpc2.Client, dbName string) (result interface{}, err error) {
+	params := []string{}
+	if dbName != "" {
+		params = append(params, dbName)
+	}
+	err = cli.CallResult(ctx, "storage_stats", params, &result)
+	return
+}
+
 func transact(ctx context.Context, cli *jrpc2.Client) (result interface{}, err error) {
 	req := []interface{}{
 		"db1",
@@ -75,6 +93,27 @@ func main() {
 	defer cli.Close()
 	ctx := context.Background()
 
+	if *token != "" {
+		if identity, err := authenticate(ctx, cli, *token); err != nil {
+			klog.Fatalln("authenticate:", err)
+		} else {
+			klog.Infof("authenticate result=%v", identity)
+		}
+	}
+
+	if *storageStatsFlag {
+		stats, err := storageStats(ctx, cli, *dbName)
+		if err != nil {
+			klog.Fatalln("storageStats:", err)
+		}
+		buf, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			klog.Fatalln("storageStats:", err)
+		}
+		fmt.Println(string(buf))
+		return
+	}
+
 	klog.Info("\n-- Sending some individual requests...")
 
 	if dbs, err := listDbs(ctx, cli); err != nil {
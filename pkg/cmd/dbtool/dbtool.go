@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	klog "k8s.io/klog/v2"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+const ETCD_LOCALHOST = "localhost:2379"
+
+var (
+	etcdMembers    = flag.String("etcd-members", ETCD_LOCALHOST, "ETCD service addresses, separated by ',' ")
+	databasePrefix = flag.String("database-prefix", "ovsdb", "Database prefix")
+	serviceName    = flag.String("service-name", "", "Deployment service name, e.g. 'nbdb' or 'sbdb'")
+	schemaFile     = flag.String("schema", "", "The schema file of the backed up or dumped database, or the served schema the imported rows are converted to")
+	output         = flag.String("output", "", "The backup file, the standard output if empty")
+	input          = flag.String("input", "", "The restored or imported database file")
+	force          = flag.Bool("force", false, "Restore into a non empty database, its rows are replaced")
+	format         = flag.String("format", ovsdb.DUMP_FORMAT_TABLE, "The dump format, 'table' or 'json'")
+)
+
+// The dbtool backs up the databases of an ovsdb-etcd deployment into files of the ovsdb-tool standalone database
+// format, and restores them, e.g. for disaster recovery. It also imports the database files of ovsdb-server, to migrate
+// a deployment to ovsdb-etcd, and dumps the databases as ovsdb-client dump does:
+//
+//	dbtool -service-name nbdb -schema schemas/ovn-nb.ovsschema -output nb.db backup
+//	dbtool -service-name nbdb -input nb.db restore
+//	dbtool -service-name nbdb -schema schemas/ovn-nb.ovsschema -input /etc/ovn/ovnnb_db.db import
+//	dbtool -service-name nbdb -schema schemas/ovn-nb.ovsschema dump
+func main() {
+	klog.InitFlags(nil)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] backup|restore|import|dump\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	defer klog.Flush()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *serviceName == "" || strings.Contains(*serviceName, common.KEY_DELIMETER) {
+		klog.Fatal("You must provide a valid -service-name")
+	}
+	common.SetPrefix(*databasePrefix + common.KEY_DELIMETER + *serviceName)
+	cli, err := ovsdb.NewEtcdClient(strings.Split(*etcdMembers, ","))
+	if err != nil {
+		klog.Fatalf("failed creating an etcd client: %v", err)
+	}
+	defer cli.Close()
+	ctx := context.Background()
+
+	switch flag.Arg(0) {
+	case "backup":
+		if *schemaFile == "" {
+			klog.Fatal("You must provide the -schema of the backed up database")
+		}
+		schema, err := ioutil.ReadFile(*schemaFile)
+		if err != nil {
+			klog.Fatalf("read %s: %v", *schemaFile, err)
+		}
+		out := os.Stdout
+		if *output != "" {
+			if out, err = os.Create(*output); err != nil {
+				klog.Fatalf("create %s: %v", *output, err)
+			}
+		}
+		revision, err := ovsdb.BackupDatabase(ctx, cli, schema, out)
+		if err == nil && out != os.Stdout {
+			err = out.Close()
+		}
+		if err != nil {
+			klog.Fatalf("backup failed: %v", err)
+		}
+		klog.Infof("database backed up at etcd revision %d", revision)
+	case "restore":
+		if *input == "" {
+			klog.Fatal("You must provide the -input database file")
+		}
+		f, err := os.Open(*input)
+		if err != nil {
+			klog.Fatalf("open %s: %v", *input, err)
+		}
+		defer f.Close()
+		restored, err := ovsdb.RestoreDatabase(ctx, cli, f, *force)
+		if err != nil {
+			klog.Fatalf("restore failed: %v", err)
+		}
+		klog.Infof("restored %d rows from %s", restored, *input)
+	case "import":
+		if *input == "" {
+			klog.Fatal("You must provide the -input database file")
+		}
+		var served *libovsdb.DatabaseSchema
+		if *schemaFile != "" {
+			served = &libovsdb.DatabaseSchema{}
+			data, err := ioutil.ReadFile(*schemaFile)
+			if err == nil {
+				err = json.Unmarshal(data, served)
+			}
+			if err != nil {
+				klog.Fatalf("read %s: %v", *schemaFile, err)
+			}
+		}
+		f, err := os.Open(*input)
+		if err != nil {
+			klog.Fatalf("open %s: %v", *input, err)
+		}
+		defer f.Close()
+		imported, err := ovsdb.ImportDatabase(ctx, cli, f, served, *force)
+		if err != nil {
+			klog.Fatalf("import failed: %v", err)
+		}
+		klog.Infof("imported %d rows from %s", imported, *input)
+	case "dump":
+		if *schemaFile == "" {
+			klog.Fatal("You must provide the -schema of the dumped database")
+		}
+		dbSchema := &libovsdb.DatabaseSchema{}
+		data, err := ioutil.ReadFile(*schemaFile)
+		if err == nil {
+			err = json.Unmarshal(data, dbSchema)
+		}
+		if err != nil {
+			klog.Fatalf("read %s: %v", *schemaFile, err)
+		}
+		if _, err := ovsdb.DumpDatabase(ctx, cli, dbSchema, *format, os.Stdout); err != nil {
+			klog.Fatalf("dump failed: %v", err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+}
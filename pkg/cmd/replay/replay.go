@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strings"
+
+	klog "k8s.io/klog/v2"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/replay"
+)
+
+const ETCD_LOCALHOST = "localhost:2379"
+
+var (
+	etcdMembers   = flag.String("etcd-members", ETCD_LOCALHOST, "ETCD service addresses, separated by ',' ")
+	fromPrefix    = flag.String("from-prefix", "", "The prefix of the replayed deployment, <database-prefix>/<service-name>, e.g. 'ovsdb/nb'")
+	toPrefix      = flag.String("to-prefix", "", "The prefix of the target deployment, the history is replayed into it")
+	fromDB        = flag.String("from-db", "", "Replay only the keys of this database")
+	toDB          = flag.String("to-db", "", "The target database of the from-db keys, from-db if empty")
+	startRevision = flag.Int64("start-revision", 1, "The first replayed etcd revision, the keys state before it is copied")
+	endRevision   = flag.Int64("end-revision", 0, "The last replayed etcd revision, 0 for the current revision")
+	input         = flag.String("input", "", "Replay the events of the export file, instead of the etcd history")
+	export        = flag.String("export", "", "Write the etcd history into the export file, instead of replaying it")
+	force         = flag.Bool("force", false, "Replay into a non empty target")
+)
+
+// The replay tool reads a range of etcd revisions of an ovsdb-etcd deployment, or an export file, and replays the
+// history into a new prefix or database, e.g. to reproduce bugs from production history or to migrate prefixes:
+//
+//	replay -from-prefix ovsdb/nb -to-prefix debug/nb -start-revision 1000 -end-revision 2000
+//	replay -from-prefix ovsdb/nb -start-revision 1000 -export history.jsonl
+//	replay -input history.jsonl -from-prefix ovsdb/nb -to-prefix debug/nb
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+	defer klog.Flush()
+
+	if *fromPrefix == "" {
+		klog.Fatal("You must provide -from-prefix")
+	}
+	if *export == "" && *toPrefix == "" {
+		klog.Fatal("You must provide -to-prefix or -export")
+	}
+	if *toDB == "" {
+		*toDB = *fromDB
+	}
+	if *export == "" && *fromPrefix == *toPrefix && *fromDB == *toDB {
+		klog.Fatal("The source and the target are the same")
+	}
+	cli, err := ovsdb.NewEtcdClient(strings.Split(*etcdMembers, ","))
+	if err != nil {
+		klog.Fatalf("failed creating an etcd client: %v", err)
+	}
+	defer cli.Close()
+	ctx := context.Background()
+
+	if *export != "" {
+		f, err := os.Create(*export)
+		if err != nil {
+			klog.Fatalf("create %s: %v", *export, err)
+		}
+		defer f.Close()
+		err = replay.ReadHistory(ctx, cli, *fromPrefix+common.KEY_DELIMETER, *startRevision, *endRevision, replay.Exporter(f))
+		if err != nil {
+			klog.Fatalf("export failed: %v", err)
+		}
+		klog.Infof("history exported to %s", *export)
+		return
+	}
+
+	replayer := replay.NewReplayer(cli, &replay.Rewriter{FromPrefix: *fromPrefix, ToPrefix: *toPrefix, FromDB: *fromDB, ToDB: *toDB})
+	if !*force {
+		if err := replayer.CheckTarget(ctx); err != nil {
+			klog.Fatalf("%v, use -force to replay into it", err)
+		}
+	}
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			klog.Fatalf("open %s: %v", *input, err)
+		}
+		defer f.Close()
+		err = replay.ReadExport(f, replayer.Apply(ctx))
+	} else {
+		err = replay.ReadHistory(ctx, cli, *fromPrefix+common.KEY_DELIMETER, *startRevision, *endRevision, replayer.Apply(ctx))
+	}
+	if err != nil {
+		klog.Fatalf("replay failed after %d transactions: %v", replayer.Batches, err)
+	}
+	klog.Infof("replayed %d transactions, %d events", replayer.Batches, replayer.Events)
+}
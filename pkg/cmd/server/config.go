@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/server"
+)
+
+// loadConfig applies the configuration file to the flags, which were not set on the command line, and returns the
+// configuration and the names of the command line flags
+func loadConfig(file string) (server.Config, map[string]bool, error) {
+	cmdLine := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { cmdLine[f.Name] = true })
+	config, err := server.ReadConfigFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := config.Apply(flag.CommandLine, cmdLine); err != nil {
+		return nil, nil, fmt.Errorf("config file %s: %v", file, err)
+	}
+	return config, cmdLine, nil
+}
+
+// reloadConfig reads the configuration file again, e.g. on SIGHUP, and applies the changed values, which are safe to
+// change while the server runs: the log verbosity, the static remotes, and the read-only and serializable databases.
+// The other changes are logged and take effect after a restart. It returns the applied configuration.
+func reloadConfig(srv *server.Server, file string, previous server.Config, cmdLine map[string]bool) server.Config {
+	config, err := server.ReadConfigFile(file)
+	if err != nil {
+		log.Error(err, "failed to reload the config file")
+		return previous
+	}
+	for _, key := range config.Keys() {
+		if flag.Lookup(key) == nil {
+			log.Error(fmt.Errorf("unknown config key %s", key), "failed to reload the config file", "file", file)
+			return previous
+		}
+	}
+	for _, key := range config.Changed(previous) {
+		if cmdLine[key] {
+			log.Info("the changed config key is overridden by the command line", "key", key)
+			continue
+		}
+		values := config[key]
+		var err error
+		switch key {
+		case "v":
+			err = reloadVerbosity(values)
+		case "remote":
+			err = reloadRemotes(srv, values)
+		case "readonly":
+			err = reloadDatabases(srv.Database(), values, &readOnlyFlags, srv.Database().SetReadOnly)
+		case "serializable":
+			err = reloadDatabases(srv.Database(), values, &serializableFlags, srv.Database().SetSerializable)
+		default:
+			log.Info("the changed config key takes effect after a restart", "key", key)
+			continue
+		}
+		if err != nil {
+			log.Error(err, "failed to reload the config key", "key", key, "value", values)
+			// the key is reloaded again on the next reload
+			if previousValues, ok := previous[key]; ok {
+				config[key] = previousValues
+			} else {
+				delete(config, key)
+			}
+			continue
+		}
+		log.Info("reloaded the config key", "key", key, "value", values)
+	}
+	return config
+}
+
+// reloadVerbosity sets the klog verbosity, the default verbosity if the key was removed
+func reloadVerbosity(values []string) error {
+	value := flag.Lookup("v").DefValue
+	if len(values) > 0 {
+		value = values[len(values)-1]
+	}
+	return flag.Set("v", value)
+}
+
+// reloadRemotes updates the listeners of the static remotes, the db remotes are configured on the server startup
+func reloadRemotes(srv *server.Server, values []string) error {
+	var targets remotes
+	for _, value := range values {
+		if err := targets.Set(value); err != nil {
+			return err
+		}
+	}
+	static := []string{}
+	dbTargets := map[string]bool{}
+	for _, target := range targets {
+		if ovsdb.IsDbRemote(target) {
+			dbTargets[target] = true
+		} else {
+			static = append(static, target)
+		}
+	}
+	dbRemotes := 0
+	for _, target := range remoteFlags {
+		if ovsdb.IsDbRemote(target) {
+			if !dbTargets[target] {
+				return fmt.Errorf("the db remote %s is removed after a restart", target)
+			}
+			dbRemotes++
+		}
+	}
+	if dbRemotes != len(dbTargets) {
+		return fmt.Errorf("the db remotes are added after a restart")
+	}
+	if err := srv.SetRemotes(static); err != nil {
+		return err
+	}
+	remoteFlags = targets
+	return nil
+}
+
+// reloadDatabases sets the mode of the databases, which were added to or removed from the list
+func reloadDatabases(db ovsdb.Databaser, values []string, current *listFlag, set func(dbName string, on bool) error) error {
+	var wanted listFlag
+	for _, value := range values {
+		wanted.Set(value)
+	}
+	wantedSet := map[string]bool{}
+	for _, dbName := range wanted {
+		if _, ok := db.GetSchemas()[dbName]; !ok {
+			return fmt.Errorf("unknown database %s", dbName)
+		}
+		wantedSet[dbName] = true
+	}
+	currentSet := map[string]bool{}
+	for _, dbName := range *current {
+		currentSet[dbName] = true
+		if !wantedSet[dbName] {
+			if err := set(dbName, false); err != nil {
+				return err
+			}
+		}
+	}
+	for _, dbName := range wanted {
+		if !currentSet[dbName] {
+			if err := set(dbName, true); err != nil {
+				return err
+			}
+		}
+	}
+	*current = wanted
+	return nil
+}
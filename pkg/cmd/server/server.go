@@ -2,21 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"os"
 	"os/signal"
 	"path"
-	"runtime"
+	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/creachadair/jrpc2"
-	"github.com/creachadair/jrpc2/channel"
-	"github.com/creachadair/jrpc2/handler"
 	"github.com/creachadair/jrpc2/metrics"
 
 	"github.com/go-logr/logr"
@@ -24,26 +22,126 @@ import (
 	"k8s.io/klog/v2/klogr"
 
 	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
 	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/server"
 )
 
 const UNIX_SOCKET = "/tmp/ovsdb-etcd.sock"
 const ETCD_LOCALHOST = "localhost:2379"
+const SERVER_SCHEMA_FILE = "_server.ovsschema"
+const SCHEMA_FILE_SUFFIX = ".ovsschema"
 
 var (
-	tcpAddress         = flag.String("tcp-address", "", "TCP service address")
-	unixAddress        = flag.String("unix-address", "", "UNIX service address")
+	configFile         = flag.String("config", "", "Configuration file in the YAML, TOML or JSON format by its extension, whose keys are the flag names, e.g. 'remote' or 'etcd-members', the command line flags override it, and its safe to change values are reloaded on SIGHUP")
+	sslCert            = flag.String("ssl-cert", "", "Certificate file of the pssl remotes")
+	sslKey             = flag.String("ssl-key", "", "Private key file of the TLS certificate")
+	sslCA              = flag.String("ssl-ca", "", "CA certificate file, which verifies the client certificates, the client certificates are not required if it's empty")
 	etcdMembers        = flag.String("etcd-members", ETCD_LOCALHOST, "ETCD service addresses, separated by ',' ")
-	schemaBasedir      = flag.String("schema-basedir", ".", "Schema base dir")
-	maxTasks           = flag.Int("max", 1, "Maximum concurrent tasks")
+	schemaBasedir      = flag.String("schema-basedir", ".", "Directory of the schema files, including _server.ovsschema, the served schema files are validated on startup")
+	maxTasks           = flag.Int("max", 1, "Maximum concurrent transactions of a connection")
+	maxControlTasks    = flag.Int("max-control", 1, "Maximum concurrent non transaction requests of a connection, e.g. echo and monitor, which are served while the connection transactions run")
 	databasePrefix     = flag.String("database-prefix", "ovsdb", "Database prefix")
 	serviceName        = flag.String("service-name", "", "Deployment service name, e.g. 'nbdb' or 'sbdb'")
-	schemaFile         = flag.String("schema-file", "", "schema-file")
 	loadServerDataFlag = flag.Bool("load-server-data", false, "load-server-data")
 	pidfile            = flag.String("pid-file", "", "Name of file that will hold the pid")
+	lockSweepInterval  = flag.Duration("lock-sweep-interval", time.Minute, "Interval between stale locks cleanups, 0 disables the cleanup")
+	remoteStatus       = flag.Duration("remote-status-interval", ovsdb.RemoteStatusInterval, "Interval between the writes of the db remotes status into their tables")
+	lockLeaseTTL       = flag.Duration("lock-lease-ttl", ovsdb.LockLeaseTTL, "Time to live of the client locks, after which the locks of a client are released if its server failed")
+	tableStatsInterval = flag.Duration("table-stats-interval", time.Minute, "Interval between tables row counts collections, 0 disables the collection")
+	leaderElection     = flag.Bool("leader-election", false, "Run for the leadership of the served databases with the other replicas, the leadership is reported in the _Server.Database rows")
+	leaderOnlyTransact = flag.Bool("leader-only-transact", false, "Refuse the write transactions of the databases, which are led by other replicas, requires --leader-election")
+	advertisedRemote   = flag.String("advertised-remote", "", "Remote of the server, which the clients refused by other replicas are referred to, e.g. 'tcp:10.0.0.1:6641'")
+	dbStatusInterval   = flag.Duration("db-status-interval", 5*time.Second, "Interval between the updates of the connected, leader and index columns of the _Server.Database rows, 0 disables the updates")
+	inactivityProbe    = flag.Duration("inactivity-probe", 5*time.Second, "Idle time of a client connection before it's probed by an echo request, 0 disables the probes")
+	inactivityTimeout  = flag.Duration("inactivity-timeout", 0, "Time to wait for the response of the inactivity probe before the connection is closed, 0 for the probe interval")
+	httpAddress        = flag.String("http-address", "", "Address of the HTTP listener, which exports the metrics in the Prometheus format on /metrics, and serves the /healthz and /readyz probes, e.g. ':9310', empty disables the listener")
+	unixctl            = flag.String("unixctl", "", "Path of the control socket, which serves the runtime commands of ovs-appctl, e.g. '/run/ovsdb-etcd.ctl', empty disables the control socket")
+	latencyTracing     = flag.Bool("latency-tracing", false, "Trace the notifications latency from the etcd event to the client socket, and export it as metrics")
+	allocAuditInterval = flag.Duration("alloc-audit-interval", 0, "Interval between the notification path allocation summaries, 0 disables the audit, requires the 'allocaudit' build tag")
+	suppressTables     = flag.String("suppress-tables", "", "Comma separated list of <db-name>.<table>@<remote>#<role> tables, whose changes are not sent to clients of the remote with the role, e.g. 'OVN_Northbound.ACL@tcp#reader'")
+	redactColumns      = flag.String("redact-columns", "", "Comma separated list of <db-name>.<table>.<column>@<role> columns, which are hidden from clients of the role, e.g. 'OVN_Southbound.Encap.options@read-only'")
+	commutativeColumns = flag.String("commutative-columns", "", "Comma separated list of <db-name>.<table>.<column> set and map columns, whose concurrent insert and delete mutations are merged, e.g. 'OVN_Northbound.Logical_Switch.ports'")
+	watchShards        = flag.Int("watch-shards", 1, "Number of goroutines, which process the events of a database watch, the events are assigned to the goroutines by their table hash, 1 disables the sharding")
+	notificationQueue  = flag.Int("notification-queue", 256, "Number of notifications of a connection, which can wait for the connection writer")
+	notificationBatch  = flag.Int("notification-batch", 64, "Maximum number of queued notifications of a connection, which are written in one batch")
+	snapshotPageSize   = flag.Int("monitor-snapshot-page-size", ovsdb.MonitorSnapshotPageSize, "Maximum number of rows of a table, which are read from etcd by a single request of the initial monitor data, 0 reads every table by a single request")
+	monitorQueue       = flag.Int("monitor-queue", 64, "Number of notifications of a monitor, which can wait for the monitor notifier, after the connection writer queue is full")
+	slowClientPolicy   = flag.String("slow-client-policy", ovsdb.SLOW_CLIENT_BLOCK, "Handling of a full monitor queue: 'block' waits for the client, 'coalesce' merges the new updates with the queued ones per row, 'disconnect' closes the connection after the slow-client-timeout")
+	slowClientTimeout  = flag.Duration("slow-client-timeout", 10*time.Second, "Time a full monitor queue waits for the client, before the connection is closed by the 'disconnect' slow client policy")
+	coalesceWindow     = flag.Duration("coalesce-window", 0, "Time the monitor notifier waits for more updates, which are merged into a single notification, 0 disables the coalescing")
+	rawRowEncoding     = flag.Bool("raw-row-encoding", false, "Splice the inserted, initial and deleted rows of the monitor notifications from their stored values, filtered by the monitored columns without decoding them, to save the allocations and CPU of the notifications")
+	concurrentTxns     = flag.Bool("concurrent-transactions", false, "Execute the write transactions of a database, which modify disjoint tables, concurrently, the transactions, which conflict in etcd, are executed again")
+	txnRowCache        = flag.Bool("transaction-row-cache", false, "Read the rows of the transactions from an in-memory cache of the databases, which is fed by their etcd watches, so only the commits of the transactions hit etcd")
+	rowCacheWait       = flag.Duration("row-cache-wait", ovsdb.RowCacheWait, "Maximum time a transaction waits for the row cache to observe the previous commit of the server, before its rows are read from etcd")
+	disableMonitorV1   = flag.Bool("disable-monitor-v1", false, "Refuse the legacy monitor requests, only monitor_cond and monitor_cond_since are served")
+	durableMonitors    = flag.Bool("durable-monitors", true, "Keep the watched state of the databases per monitor, so the monitors are resumed after an etcd compaction of the missed events")
+	deterministicOrder = flag.Bool("deterministic-order", false, "Order the rows of the select results by their uuids, so the responses are reproducible")
+	dbAliases          = flag.String("db-aliases", "", "Comma separated list of <alias>=<db-name> database name aliases, e.g. 'nbdb=OVN_Northbound'")
+	caseInsensitiveDBs = flag.Bool("case-insensitive-dbs", false, "Lookup the database names and aliases of the client requests case-insensitively")
+	authMethod         = flag.String("auth-method", ovsdb.AUTH_METHOD_NONE, "Client authentication method, one of "+strings.Join(ovsdb.AuthMethods(), ", "))
+	authRole           = flag.String("auth-role", "", "Role assigned to the authenticated clients")
+	authAllowedNames   = flag.String("auth-allowed-names", "", "Comma separated list of the certificate common names of the clients, which are accepted by the 'tls' authentication method, empty for any")
+	authTokensFile     = flag.String("auth-tokens-file", "", "JSON file of the {\"<client-name>\": \"<token>\"} tokens, which are accepted by the 'token' authentication method")
+	maxMonitors        = flag.Int("max-monitors", 0, "Maximum number of monitors per client connection, 0 for unlimited")
+	maxLocks           = flag.Int("max-locks", 0, "Maximum number of locks per client connection, 0 for unlimited")
+	identityMonitors   = flag.Int("max-identity-monitors", 0, "Maximum number of monitors of all the connections of a client identity, 0 for unlimited")
+	identityLocks      = flag.Int("max-identity-locks", 0, "Maximum number of locks of all the connections of a client identity, 0 for unlimited")
+	storageMigration   = flag.Bool("storage-migration", true, "Upgrade the storage format of the databases on startup, if false the server refuses to serve databases of an old storage format")
+	checkSchemaFile    = flag.String("check-schema", "", "Check the given schema file against the served schema and the stored data, print a report and exit")
 )
 
+// remotes is a repeated flag
+type remotes []string
+
+func (r *remotes) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *remotes) Set(value string) error {
+	// the db remotes are validated against the schemas by the server
+	if ovsdb.IsDbRemote(value) {
+		*r = append(*r, value)
+		return nil
+	}
+	if _, err := server.ParseRemote(value); err != nil {
+		return err
+	}
+	*r = append(*r, value)
+	return nil
+}
+
+var remoteFlags remotes
+
+// listFlag is a repeated flag, every value is a comma separated list
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*f = append(*f, item)
+		}
+	}
+	return nil
+}
+
+var schemaFileFlags listFlag
+var readOnlyFlags listFlag
+var serializableFlags listFlag
+
+func init() {
+	flag.Var(&remoteFlags, "remote", "Remote to listen on, one of ptcp:<port>[:<ip>], pssl:<port>[:<ip>], punix:<path> or db:<db-name>,<table>,<column>, can be repeated")
+	flag.Var(&schemaFileFlags, "schema-file", "Schema file of a served database in the schema-basedir, can be repeated or comma separated, all the schema files of the schema-basedir are served if it's not set")
+	flag.Var(&readOnlyFlags, "readonly", "Database, whose client transactions cannot modify it, while its monitors are served, e.g. on a standby server, can be repeated or comma separated")
+	flag.Var(&serializableFlags, "serializable", "Database, whose write transactions are serialized with the transactions of the other server replicas, which serve the same etcd prefix, can be repeated or comma separated")
+}
+
 var GitCommit string
+var Version string
 
 var log logr.Logger
 
@@ -53,15 +151,42 @@ func main() {
 	defer klog.Flush()
 	log = klogr.New()
 
-	log.V(3).Info("start the ovsdb-etcd server", "git-commit", GitCommit,
-		"tcp-address", tcpAddress, "unix-address", unixAddress, "etcd-members",
-		etcdMembers, "schema-basedir", schemaBasedir, "max-tasks", maxTasks,
-		"database-prefix", databasePrefix, "service-name", serviceName,
-		"schema-file", schemaFile, "load-server-data-flag", loadServerDataFlag,
-		"pidfile", pidfile)
+	var config server.Config
+	var cmdLineFlags map[string]bool
+	if len(*configFile) > 0 {
+		var err error
+		if config, cmdLineFlags, err = loadConfig(*configFile); err != nil {
+			log.Error(err, "wrong config")
+			os.Exit(1)
+		}
+	}
 
-	if len(*tcpAddress) == 0 && len(*unixAddress) == 0 {
-		log.Info("You must provide a network-address (TCP and/or UNIX) to listen on")
+	log.V(3).Info("start the ovsdb-etcd server", "version", Version, "git-commit", GitCommit,
+		"config", configFile, "remotes", remoteFlags,
+		"ssl-cert", sslCert, "ssl-key", sslKey, "ssl-ca", sslCA, "etcd-members",
+		etcdMembers, "schema-basedir", schemaBasedir, "max-tasks", maxTasks, "max-control-tasks", maxControlTasks,
+		"database-prefix", databasePrefix, "service-name", serviceName,
+		"schema-file", schemaFileFlags, "load-server-data-flag", loadServerDataFlag,
+		"pidfile", pidfile, "lock-sweep-interval", lockSweepInterval,
+		"table-stats-interval", tableStatsInterval, "db-status-interval", dbStatusInterval,
+		"leader-election", leaderElection, "leader-only-transact", leaderOnlyTransact, "advertised-remote", advertisedRemote,
+		"inactivity-probe", inactivityProbe, "inactivity-timeout", inactivityTimeout,
+		"latency-tracing", latencyTracing, "alloc-audit-interval", allocAuditInterval, "suppress-tables", suppressTables,
+		"redact-columns", redactColumns,
+		"commutative-columns", commutativeColumns, "watch-shards", watchShards,
+		"notification-queue", notificationQueue, "notification-batch", notificationBatch,
+		"monitor-queue", monitorQueue, "slow-client-policy", slowClientPolicy, "slow-client-timeout", slowClientTimeout,
+		"coalesce-window", coalesceWindow, "disable-monitor-v1", disableMonitorV1,
+		"concurrent-transactions", concurrentTxns, "transaction-row-cache", txnRowCache, "row-cache-wait", rowCacheWait,
+		"durable-monitors", durableMonitors,
+		"deterministic-order", deterministicOrder,
+		"auth-method", authMethod, "auth-role", authRole,
+		"auth-allowed-names", authAllowedNames, "auth-tokens-file", authTokensFile, "max-monitors", maxMonitors, "max-locks", maxLocks,
+		"max-identity-monitors", identityMonitors, "max-identity-locks", identityLocks,
+		"readonly", readOnlyFlags, "serializable", serializableFlags, "check-schema", checkSchemaFile)
+
+	if len(*checkSchemaFile) == 0 && len(remoteFlags) == 0 {
+		log.Info("You must provide a remote to listen on")
 		os.Exit(1)
 	}
 
@@ -74,7 +199,104 @@ func main() {
 		os.Exit(1)
 	}
 
-	if *pidfile != "" {
+	authenticator, err := ovsdb.NewAuthenticator(*authMethod, *authRole)
+	if err != nil {
+		log.Error(err, "wrong auth-method")
+		os.Exit(1)
+	}
+	switch auth := authenticator.(type) {
+	case *ovsdb.TLSAuthenticator:
+		if *authAllowedNames != "" {
+			auth.AllowedNames = strings.Split(*authAllowedNames, ",")
+		}
+	case *ovsdb.StaticTokenAuthenticator:
+		if *authTokensFile == "" {
+			log.Info("The token authentication method requires auth-tokens-file")
+			os.Exit(1)
+		}
+		if auth.Tokens, err = ovsdb.LoadTokens(*authTokensFile); err != nil {
+			log.Error(err, "wrong auth-tokens-file")
+			os.Exit(1)
+		}
+	}
+	suppressionRules, err := ovsdb.ParseSuppressionRules(*suppressTables)
+	if err != nil {
+		log.Error(err, "wrong suppress-tables")
+		os.Exit(1)
+	}
+	redactionPolicy, err := ovsdb.ParseRedactionPolicy(*redactColumns)
+	if err != nil {
+		log.Error(err, "wrong redact-columns")
+		os.Exit(1)
+	}
+
+	if len(*commutativeColumns) > 0 {
+		columns, err := ovsdb.ParseCommutativeColumns(*commutativeColumns)
+		if err != nil {
+			log.Error(err, "wrong commutative-columns")
+			os.Exit(1)
+		}
+		ovsdb.SetConflictPolicy(columns)
+	}
+
+	if len(*dbAliases) > 0 {
+		aliases, err := ovsdb.ParseDatabaseAliases(*dbAliases)
+		if err != nil {
+			log.Error(err, "wrong db-aliases")
+			os.Exit(1)
+		}
+		ovsdb.SetDatabaseAliases(aliases)
+	}
+	ovsdb.CaseInsensitiveDBNames = *caseInsensitiveDBs
+
+	if *maxTasks < 1 || *maxControlTasks < 1 {
+		log.Info("Illegal max concurrent tasks", "max", *maxTasks, "max-control", *maxControlTasks)
+		os.Exit(1)
+	}
+	if *watchShards < 1 {
+		log.Info("Illegal watch-shards", "watch-shards", *watchShards)
+		os.Exit(1)
+	}
+	ovsdb.WatchShards = *watchShards
+	if *notificationQueue < 0 || *notificationBatch < 1 {
+		log.Info("Illegal notification writer parameters", "notification-queue", *notificationQueue, "notification-batch", *notificationBatch)
+		os.Exit(1)
+	}
+	ovsdb.NotificationQueueSize = *notificationQueue
+	ovsdb.NotificationBatchSize = *notificationBatch
+	if *monitorQueue < 1 || !ovsdb.IsSlowClientPolicy(*slowClientPolicy) || *slowClientTimeout <= 0 {
+		log.Info("Illegal slow client parameters", "monitor-queue", *monitorQueue, "slow-client-policy", *slowClientPolicy,
+			"slow-client-timeout", *slowClientTimeout)
+		os.Exit(1)
+	}
+	ovsdb.MonitorQueueSize = *monitorQueue
+	ovsdb.SlowClientPolicy = *slowClientPolicy
+	ovsdb.SlowClientTimeout = *slowClientTimeout
+	if *coalesceWindow < 0 {
+		log.Info("Illegal coalesce-window", "coalesce-window", *coalesceWindow)
+		os.Exit(1)
+	}
+	ovsdb.CoalesceWindow = *coalesceWindow
+	if *snapshotPageSize < 0 {
+		log.Info("Illegal monitor-snapshot-page-size", "monitor-snapshot-page-size", *snapshotPageSize)
+		os.Exit(1)
+	}
+	ovsdb.MonitorSnapshotPageSize = *snapshotPageSize
+	ovsdb.DisableMonitorV1 = *disableMonitorV1
+	ovsdb.RawRowEncoding = *rawRowEncoding
+	if *rowCacheWait < 0 {
+		log.Info("Illegal row-cache-wait", "row-cache-wait", *rowCacheWait)
+		os.Exit(1)
+	}
+	ovsdb.ConcurrentTransactions = *concurrentTxns
+	ovsdb.TransactionRowCache = *txnRowCache
+	ovsdb.RowCacheWait = *rowCacheWait
+	ovsdb.DurableMonitors = *durableMonitors
+	ovsdb.LockLeaseTTL = *lockLeaseTTL
+	ovsdb.RemoteStatusInterval = *remoteStatus
+	ovsdb.DeterministicOrder = *deterministicOrder
+
+	if *pidfile != "" && len(*checkSchemaFile) == 0 {
 		defer delPidfile(*pidfile)
 		if err := setupPIDFile(*pidfile); err != nil {
 			klog.Fatal(err)
@@ -90,30 +312,67 @@ func main() {
 		os.Exit(1)
 	}
 	etcdServers := strings.Split(*etcdMembers, ",")
+	ovsdb.SetBuildInfo(Version, GitCommit)
 
-	cli, err := ovsdb.NewEtcdClient(etcdServers)
+	servedSchemas, err := servedSchemaFiles(*schemaBasedir, schemaFileFlags)
 	if err != nil {
-		log.Error(err, "failed creating an etcd client")
+		log.Error(err, "failed to find the schema files")
 		os.Exit(1)
 	}
-	defer cli.Close()
-
-	db, _ := ovsdb.NewDatabaseEtcd(cli)
-
-	err = db.AddSchema(path.Join(*schemaBasedir, "_server.ovsschema"))
-	if err != nil {
-		log.Error(err, "failed to add schema")
+	// a misconfigured deployment fails before it connects to etcd
+	if err := ovsdb.ValidateSchemaFiles(servedSchemas); err != nil {
+		log.Error(err, "wrong schema files", "schema-basedir", *schemaBasedir)
 		os.Exit(1)
 	}
+	if len(*checkSchemaFile) > 0 {
+		cli, err := ovsdb.NewEtcdClient(etcdServers)
+		if err != nil {
+			log.Error(err, "failed creating an etcd client")
+			os.Exit(1)
+		}
+		db, _ := ovsdb.NewDatabaseEtcd(cli)
+		code := checkSchema(db, servedSchemas, *checkSchemaFile)
+		cli.Close()
+		os.Exit(code)
+	}
 
-	err = db.AddSchema(path.Join(*schemaBasedir, *schemaFile))
+	serverMetrics := metrics.New()
+	srv, err := server.NewServer(server.Options{
+		Remotes:                remoteFlags,
+		SSLCert:                *sslCert,
+		SSLKey:                 *sslKey,
+		SSLCA:                  *sslCA,
+		EtcdMembers:            etcdServers,
+		SchemaFiles:            servedSchemas,
+		MaxTasks:               *maxTasks,
+		MaxControlTasks:        *maxControlTasks,
+		StorageMigration:       *storageMigration,
+		ReadOnlyDatabases:      readOnlyFlags,
+		SerializableDatabases:  serializableFlags,
+		LockSweepInterval:      *lockSweepInterval,
+		TableStatsInterval:     *tableStatsInterval,
+		DatabaseStatusInterval: *dbStatusInterval,
+		LeaderElection:         *leaderElection,
+		LeaderOnlyTransactions: *leaderOnlyTransact,
+		AdvertisedRemote:       *advertisedRemote,
+		InactivityProbe:        *inactivityProbe,
+		InactivityTimeout:      *inactivityTimeout,
+		Authenticator:          authenticator,
+		SuppressionRules:       suppressionRules,
+		RedactionPolicy:        redactionPolicy,
+		Quota:                  ovsdb.NewResourceQuota(*maxMonitors, *maxLocks, *identityMonitors, *identityLocks),
+		Metrics:                serverMetrics,
+		HTTPAddress:            *httpAddress,
+		ControlSocket:          *unixctl,
+		Log:                    log,
+	})
 	if err != nil {
-		log.Error(err, "failed to add schema")
+		log.Error(err, "failed to create the server")
 		os.Exit(1)
 	}
 	// TODO for development only, will be remove later
 	if *loadServerDataFlag {
-		err = loadServerData(db.(*ovsdb.DatabaseEtcd))
+		err = loadServerData(srv.Database().(*ovsdb.DatabaseEtcd))
 		if err != nil {
 			log.Error(err, "failed to load server data")
 			os.Exit(1)
@@ -133,100 +392,112 @@ func main() {
 		cancel()
 	}()
 
-	servOptions := &jrpc2.ServerOptions{
-		Concurrency: *maxTasks,
-		Metrics:     metrics.New(),
-		AllowPush:   true,
-		AllowV1:     true,
-	}
-	service := ovsdb.NewService(db)
-
-	loop := func(lst net.Listener) error {
-		for {
-			conn, err := lst.Accept()
-			conn = ConnWrapper{intConn: conn}
-			if err != nil {
-				log.V(5).Info("connection", "from", conn.RemoteAddr(), "error", err, "is-closing", channel.IsErrClosing(err))
-				if channel.IsErrClosing(err) {
-					err = nil
-				} else {
-					log.Error(err, "failed accepting new connection")
+	if *latencyTracing {
+		ovsdb.EnableLatencyTracing(serverMetrics)
+	}
+	if *allocAuditInterval > 0 {
+		if err := ovsdb.EnableAllocAudit(ctx, *allocAuditInterval, log); err != nil {
+			log.Error(err, "wrong alloc-audit-interval")
+			os.Exit(1)
+		}
+	}
+	if err := srv.Start(); err != nil {
+		log.Error(err, "failed listen")
+		os.Exit(1)
+	}
+	for ctx.Err() == nil {
+		select {
+		case s := <-exitCh:
+			if s == syscall.SIGHUP && (len(*configFile) > 0 || srv.TLSEnabled()) {
+				if len(*configFile) > 0 {
+					config = reloadConfig(srv, *configFile, config, cmdLineFlags)
 				}
-				return err
-			}
-			ch := channel.RawJSON(conn, conn)
-			go func() {
-				tctx, cancel := context.WithCancel(context.Background())
-				handler := ovsdb.NewHandler(tctx, db, cli, log)
-				log.V(5).Info("new connection", "from", conn.RemoteAddr())
-				assigner := createServicesMap(service, handler)
-				srv := jrpc2.NewServer(assigner, servOptions)
-				handler.SetConnection(srv, conn)
-				srv.Start(ch)
-				stat := srv.WaitStatus()
-				log.V(5).Info("connection", "from", conn.RemoteAddr(), "stopped", stat.Stopped(), "closed", stat.Closed(), "success", stat.Success(), "err", stat.Err)
-				if stat.Err != nil {
-					log.Error(err, "Server exit")
+				// the certificates are rotated without restarting the server
+				if srv.TLSEnabled() {
+					if err := srv.ReloadCertificates(); err != nil {
+						log.Error(err, "failed to reload the TLS certificates")
+					}
 				}
-				handler.Cleanup()
-				cancel()
-			}()
+				continue
+			}
+			log.Info("Received signal shutting down", "signal", s)
+			cancel()
+		case <-ctx.Done():
 		}
 	}
-	if len(*tcpAddress) > 0 {
-		lst, err := net.Listen(jrpc2.Network(*tcpAddress), *tcpAddress)
+	sctx, scancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer scancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		log.Error(err, "shutdown")
+	}
+}
+
+// servedSchemaFiles returns the paths of the schema files of the served databases, the _Server schema is the first.
+// If no files are given, all the schema files of the base directory are served.
+func servedSchemaFiles(basedir string, files []string) ([]string, error) {
+	if len(files) == 0 {
+		matches, err := filepath.Glob(filepath.Join(basedir, "*"+SCHEMA_FILE_SUFFIX))
 		if err != nil {
-			log.Error(err, "failed listen")
+			return nil, err
 		}
-		log.Info("listening", "on", lst.Addr())
-		//servOptions.Logger = log.New(os.Stderr, "[TCP.Server] ", log.LstdFlags|log.Lshortfile)
-
-		go loop(lst)
-	}
-	if runtime.GOOS == "linux" && len(*unixAddress) > 0 {
-		if err := os.RemoveAll(*unixAddress); err != nil {
-			log.Error(err, "failed to remove all address")
-			os.Exit(1)
+		sort.Strings(matches)
+		for _, match := range matches {
+			files = append(files, filepath.Base(match))
 		}
-		lst, err := net.Listen(jrpc2.Network(*unixAddress), *unixAddress)
-		if err != nil {
-			log.Error(err, "failed listen")
-			os.Exit(1)
+	}
+	served := []string{path.Join(basedir, SERVER_SCHEMA_FILE)}
+	for _, file := range files {
+		if file == SERVER_SCHEMA_FILE {
+			continue
 		}
-		log.Info("listening", "on", lst.Addr())
-		//servOptions.Logger = log.New(os.Stderr, "[UNIX.Server] ", log.LstdFlags|log.Lshortfile)
-		go loop(lst)
+		served = append(served, path.Join(basedir, file))
 	}
-	select {
-	case s := <-exitCh:
-		log.Info("Received signal shutting down", "signal", s)
-		cancel()
-	case <-ctx.Done():
+	if len(served) == 1 {
+		return nil, fmt.Errorf("no schema files in %s", basedir)
 	}
-
+	return served, nil
 }
 
-// we pass handlerMap by value, so the function gets a proprietary copy of it.
-func createServicesMap(sharedService *ovsdb.Service, clientHandler *ovsdb.Handler) *handler.Map {
-	handlerMap := make(handler.Map)
-	handlerMap["list_dbs"] = handler.New(sharedService.ListDbs)
-	handlerMap["get_schema"] = handler.New(sharedService.GetSchema)
-	handlerMap["get_server_id"] = handler.New(sharedService.GetServerId)
-	handlerMap["convert"] = handler.New(sharedService.Convert)
-
-	handlerMap["transact"] = handler.New(clientHandler.Transact)
-	handlerMap["cancel"] = handler.New(clientHandler.Cancel)
-	handlerMap["monitor"] = handler.New(clientHandler.Monitor)
-	handlerMap["monitor_cancel"] = handler.New(clientHandler.MonitorCancel)
-	handlerMap["lock"] = handler.New(clientHandler.Lock)
-	handlerMap["steal"] = handler.New(clientHandler.Steal)
-	handlerMap["unlock"] = handler.New(clientHandler.Unlock)
-	handlerMap["monitor_cond"] = handler.New(clientHandler.MonitorCond)
-	handlerMap["monitor_cond_since"] = handler.New(clientHandler.MonitorCondSince)
-	handlerMap["monitor_cond_change"] = handler.New(clientHandler.MonitorCondChange)
-	handlerMap["set_db_change_aware"] = handler.New(clientHandler.SetDbChangeAware)
-	handlerMap["echo"] = handler.New(clientHandler.Echo)
-	return &handlerMap
+// checkSchema prints the compatibility report of the new schema, and returns the process exit code, which is 0 only if
+// the stored data can be converted to the new schema. The new schema is checked against the served schema of the same
+// database.
+func checkSchema(db ovsdb.Databaser, servedFiles []string, newFile string) int {
+	newSchemas := libovsdb.Schemas{}
+	if err := newSchemas.AddFromFile(newFile); err != nil {
+		log.Error(err, "failed to read schema", "file", newFile)
+		return 1
+	}
+	var proposed *libovsdb.DatabaseSchema
+	for _, s := range newSchemas {
+		proposed = s
+	}
+	schemas := libovsdb.Schemas{}
+	for _, file := range servedFiles {
+		if err := schemas.AddFromFile(file); err != nil {
+			log.Error(err, "failed to read schema", "file", file)
+			return 1
+		}
+	}
+	current, ok := schemas[proposed.Name]
+	if !ok {
+		log.Info("the database of the schema is not served", "dbName", proposed.Name)
+		return 1
+	}
+	report, err := ovsdb.CheckSchema(db, current, proposed)
+	if err != nil {
+		log.Error(err, "schema check failed")
+		return 1
+	}
+	buf, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Error(err, "failed to marshal report")
+		return 1
+	}
+	fmt.Println(string(buf))
+	if !report.Compatible {
+		return 2
+	}
+	return 0
 }
 
 func delPidfile(pidfile string) {
@@ -267,45 +538,3 @@ func setupPIDFile(pidfile string) error {
 
 	return nil
 }
-
-// temporary for development purpose wrapper
-type ConnWrapper struct {
-	intConn net.Conn
-}
-
-func (cw ConnWrapper) Read(b []byte) (n int, err error) {
-	n, err = cw.intConn.Read(b)
-	log.V(7).Info("read", "from", cw.intConn.RemoteAddr(), "bytes", n, "error", err)
-	return
-}
-
-func (cw ConnWrapper) Write(b []byte) (n int, err error) {
-	n, err = cw.intConn.Write(b)
-	log.V(7).Info("write", "from", cw.intConn.RemoteAddr(), "bytes", n, "error", err)
-	return
-}
-
-func (cw ConnWrapper) Close() error {
-	log.V(5).Info("close", "from", cw.intConn.RemoteAddr())
-	return cw.intConn.Close()
-}
-
-func (cw ConnWrapper) LocalAddr() net.Addr {
-	return cw.intConn.LocalAddr()
-}
-
-func (cw ConnWrapper) RemoteAddr() net.Addr {
-	return cw.intConn.RemoteAddr()
-}
-
-func (cw ConnWrapper) SetDeadline(t time.Time) error {
-	return cw.intConn.SetDeadline(t)
-}
-
-func (cw ConnWrapper) SetReadDeadline(t time.Time) error {
-	return cw.intConn.SetReadDeadline(t)
-}
-
-func (cw ConnWrapper) SetWriteDeadline(t time.Time) error {
-	return cw.intConn.SetWriteDeadline(t)
-}
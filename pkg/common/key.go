@@ -12,6 +12,23 @@ const (
 	LOCKS         = "_locks"
 	COMMENTS      = "_comments"
 	INTERNAL_DB   = "_"
+	// storage format markers of the databases
+	STORAGE_FORMAT = "_storage_format"
+	// undo records of the running storage migrations
+	STORAGE_UNDO = "_storage_undo"
+	// epochs of the transaction ids of the databases
+	TXN_EPOCH = "_txn_epoch"
+	// intents of the commits, which are split into several etcd transactions
+	JOURNAL    = "_journal"
+	JOURNAL_ID = "intent"
+	// entries of the unique indexes of the tables
+	INDEXES = "_indexes"
+	// candidates of the leader elections of the databases
+	ELECTIONS = "_elections"
+	// counters of the commits of the serializable databases
+	COMMIT_COUNTERS = "_commit_counters"
+	// canonical schemas of the databases, which are served by all the replicas
+	SCHEMAS = "_schemas"
 )
 
 var prefix string
@@ -101,15 +118,93 @@ func NewDataKey(dbName, tableName, uuid string) Key {
 // Returns a new Comment key. If the given commentID is an empty string, the return key will point to the entire
 // comments table, and the this function call is equals to call `NewCommentTableKey`.
 func NewCommentKey(commentID string) Key {
-	return NewDataKey(INTERNAL_DB, COMMENTS, commentID)
+	return NewDataKey(INTERNAL_DB, COMMENTS, EscapeKeyID(commentID))
 }
 
 // Returns a new Lock key. If the given lockID is an empty string, the return key will point to the entire
-// locks table, and the this function call is equals to call `NewLockTableKey`.
+// locks table, and the this function call is equals to call `NewLockTableKey`. The lock id is an arbitrary client
+// string, it is escaped, so ids containing the key delimiter don't collide with other locks.
 func NewLockKey(lockID string) Key {
-	return NewDataKey(INTERNAL_DB, LOCKS, lockID)
+	return NewDataKey(INTERNAL_DB, LOCKS, EscapeKeyID(lockID))
 }
 
+// Returns the key of the storage format marker of the given database
+func NewStorageFormatKey(dbName string) Key {
+	return NewDataKey(INTERNAL_DB, STORAGE_FORMAT, EscapeKeyID(dbName))
+}
+
+// Returns the key of an undo record of the storage migration of the given database, the batch identifies the
+// migrated batch of keys
+func NewStorageUndoKey(dbName string, batch int) Key {
+	return NewDataKey(INTERNAL_DB, STORAGE_UNDO, EscapeKeyID(fmt.Sprintf("%s%s%d", dbName, KEY_DELIMETER, batch)))
+}
+
+// Returns the prefix of the undo records of the storage migration of the given database
+func NewStorageUndoPrefix(dbName string) string {
+	return NewDataKey(INTERNAL_DB, STORAGE_UNDO, EscapeKeyID(dbName+KEY_DELIMETER)).String()
+}
+
+// Returns the key of the transaction ids epoch of the given database
+func NewTxnEpochKey(dbName string) Key {
+	return NewDataKey(INTERNAL_DB, TXN_EPOCH, EscapeKeyID(dbName))
+}
+
+// Returns the prefix key of the leader election of the given database, the candidates are keyed under it by their
+// leases
+func NewElectionKey(dbName string) Key {
+	return NewDataKey(INTERNAL_DB, ELECTIONS, EscapeKeyID(dbName))
+}
+
+// Returns the key of the commit counter of the given database, it's modified by every write transaction of a
+// serializable database
+func NewCommitCounterKey(dbName string) Key {
+	return NewDataKey(INTERNAL_DB, COMMIT_COUNTERS, EscapeKeyID(dbName))
+}
+
+// Returns the key of the canonical schema of the given database
+func NewSchemaKey(dbName string) Key {
+	return NewDataKey(INTERNAL_DB, SCHEMAS, EscapeKeyID(dbName))
+}
+
+// Returns the key of an entry of a unique index of the given table, the index id identifies the index and the values
+// of its columns
+func NewIndexKey(dbName, tableName, indexID string) Key {
+	return NewDataKey(INTERNAL_DB, INDEXES, EscapeKeyID(dbName+KEY_DELIMETER+tableName+KEY_DELIMETER+indexID))
+}
+
+// Returns the prefix of the index entries of the given table
+func NewIndexTablePrefix(dbName, tableName string) string {
+	return NewIndexKey(dbName, tableName, "").String()
+}
+
+// Returns the key of the commit journal of the given database. Unlike the other internal keys, the journal is stored
+// under the database prefix, so the database watchers receive its changes in order with the changes of the rows.
+func NewJournalKey(dbName string) Key {
+	return NewDataKey(dbName, JOURNAL, JOURNAL_ID)
+}
+
+// EscapeKeyID escapes the key delimiter in an arbitrary id, so the id is a single key part. Ids without '/' and '%'
+// are not changed.
+func EscapeKeyID(id string) string {
+	if !strings.ContainsAny(id, "/%") {
+		return id
+	}
+	return keyIDEscaper.Replace(id)
+}
+
+// UnescapeKeyID returns the original id of a key part escaped by EscapeKeyID
+func UnescapeKeyID(id string) string {
+	if !strings.Contains(id, "%") {
+		return id
+	}
+	return keyIDUnescaper.Replace(id)
+}
+
+var (
+	keyIDEscaper   = strings.NewReplacer("%", "%25", KEY_DELIMETER, "%2F")
+	keyIDUnescaper = strings.NewReplacer("%25", "%", "%2F", KEY_DELIMETER)
+)
+
 // Helper function, which returns a key to entire table
 func NewTableKey(dbName, tableName string) Key {
 	return NewDataKey(dbName, tableName, "")
@@ -2,7 +2,11 @@ package common
 
 import (
 	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/quick"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -32,3 +36,32 @@ func TestParseKey(t *testing.T) {
 		}
 	}
 }
+
+func TestLockKeyEscaping(t *testing.T) {
+	SetPrefix("ovsdb/nb")
+	// the lock of "a/b" must not share the prefix of the lock of "a"
+	assert.False(t, strings.HasPrefix(NewLockKey("a/b").String(), NewLockKey("a").String()+KEY_DELIMETER))
+	assert.Equal(t, "lock1", NewLockKey("lock1").UUID)
+
+	property := func(id string) bool {
+		key := NewLockKey(id)
+		if id != "" && strings.Contains(key.UUID, KEY_DELIMETER) {
+			return false
+		}
+		if id != "" {
+			parsed, err := ParseKey(key.String())
+			if err != nil || parsed.UUID != key.UUID {
+				return false
+			}
+		}
+		return UnescapeKeyID(key.UUID) == id
+	}
+	assert.Nil(t, quick.Check(property, &quick.Config{MaxCount: 1000, Values: func(values []reflect.Value, r *rand.Rand) {
+		runes := []rune{'a', '/', '%', '2', 'F', '5', ' ', '\x00', '\n', 'é', '中', '😀'}
+		id := make([]rune, r.Intn(12))
+		for i := range id {
+			id[i] = runes[r.Intn(len(runes))]
+		}
+		values[0] = reflect.ValueOf(string(id))
+	}}))
+}
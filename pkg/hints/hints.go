@@ -0,0 +1,105 @@
+// Package hints defines the structured hints, which are sent with the JSON-RPC error, when the server rejects a
+// request, which can succeed later or on another server. Client SDKs can use them to choose between retry, backoff and
+// reconnect to another server, instead of parsing the error messages.
+//
+// The JSON-RPC v1 errors of OVSDB are error objects, {"error": <message>, "details": <string>}, and the jrpc2 fork
+// writes only their message, so the server adds the hint as the JSON encoded details of the error object (see Details
+// and FromDetails). The hint is carried in the data of the jrpc2 error until the response is written.
+package hints
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/code"
+)
+
+// JSON-RPC error codes of the rejected requests, from the range reserved for implementation-defined server errors.
+const (
+	// the server cannot serve the request now, the client should retry later or connect to another server
+	CODE_UNAVAILABLE code.Code = -32001
+	// the server or the client exceeded its limits, the client should backoff
+	CODE_OVERLOADED code.Code = -32002
+)
+
+// Reasons of the request rejections
+const (
+	// the server is shutting down, the client should reconnect to another server
+	REASON_DRAINING = "draining"
+	// the server is not the leader, the client should reconnect to the leader
+	REASON_NOT_LEADER = "not-leader"
+	// the server is overloaded, the client should retry after the given time
+	REASON_OVERLOADED = "overloaded"
+	// the client exceeded its monitors or locks quota, the request can succeed after the client releases some of them
+	REASON_QUOTA_EXCEEDED = "quota-exceeded"
+	// the database is temporarily frozen for writes, the client should retry after the given time
+	REASON_FROZEN = "frozen"
+)
+
+// Hint is the data of the JSON-RPC error of a rejected request
+type Hint struct {
+	Reason string `json:"reason"`
+	// the time in milliseconds the client should wait before retrying the request, 0 if unknown
+	RetryAfter int64 `json:"retry-after,omitempty"`
+	// the address of the leader, if known
+	Leader string `json:"leader,omitempty"`
+}
+
+// NewHint returns a hint with the given reason and retry after duration
+func NewHint(reason string, retryAfter time.Duration) *Hint {
+	return &Hint{Reason: reason, RetryAfter: retryAfter.Milliseconds()}
+}
+
+// Code returns the JSON-RPC error code of the hint reason
+func (h *Hint) Code() code.Code {
+	switch h.Reason {
+	case REASON_OVERLOADED, REASON_QUOTA_EXCEEDED:
+		return CODE_OVERLOADED
+	default:
+		return CODE_UNAVAILABLE
+	}
+}
+
+// RetryAfterDuration returns the time the client should wait before retrying the request
+func (h *Hint) RetryAfterDuration() time.Duration {
+	return time.Duration(h.RetryAfter) * time.Millisecond
+}
+
+// Error returns a JSON-RPC error with the given message, which carries the hint in its data field
+func (h *Hint) Error(message string) error {
+	return jrpc2.DataErrorf(h.Code(), h, "%s", message)
+}
+
+// FromError returns the hint of a JSON-RPC error, it returns false if the error doesn't carry a hint
+func FromError(err error) (*Hint, bool) {
+	var rpcErr *jrpc2.Error
+	if !errors.As(err, &rpcErr) || !rpcErr.HasData() {
+		return nil, false
+	}
+	hint := &Hint{}
+	if err := rpcErr.UnmarshalData(hint); err != nil || hint.Reason == "" {
+		return nil, false
+	}
+	return hint, true
+}
+
+// Details returns the hint encoded as the details of an OVSDB error object
+func (h *Hint) Details() string {
+	buf, err := json.Marshal(h)
+	if err != nil {
+		return ""
+	}
+	return string(buf)
+}
+
+// FromDetails returns the hint of the details of an OVSDB error object, it returns false if the details don't carry
+// a hint
+func FromDetails(details string) (*Hint, bool) {
+	hint := &Hint{}
+	if err := json.Unmarshal([]byte(details), hint); err != nil || hint.Reason == "" {
+		return nil, false
+	}
+	return hint, true
+}
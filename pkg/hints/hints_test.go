@@ -0,0 +1,40 @@
+package hints
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHintError(t *testing.T) {
+	err := NewHint(REASON_FROZEN, 1500*time.Millisecond).Error("database frozen")
+	rpcErr, ok := err.(*jrpc2.Error)
+	assert.True(t, ok)
+	assert.Equal(t, CODE_UNAVAILABLE, rpcErr.Code())
+	assert.Equal(t, "database frozen", rpcErr.Message())
+
+	hint, ok := FromError(fmt.Errorf("transact: %w", err))
+	assert.True(t, ok)
+	assert.Equal(t, REASON_FROZEN, hint.Reason)
+	assert.Equal(t, 1500*time.Millisecond, hint.RetryAfterDuration())
+
+	err = (&Hint{Reason: REASON_NOT_LEADER, Leader: "tcp:10.0.0.1:6641"}).Error("not leader")
+	var data map[string]interface{}
+	assert.Nil(t, err.(*jrpc2.Error).UnmarshalData(&data))
+	assert.Equal(t, map[string]interface{}{"reason": REASON_NOT_LEADER, "leader": "tcp:10.0.0.1:6641"}, data)
+	assert.Equal(t, CODE_OVERLOADED, NewHint(REASON_OVERLOADED, 0).Code())
+
+	_, ok = FromError(fmt.Errorf("database frozen"))
+	assert.False(t, ok)
+	_, ok = FromError(jrpc2.Errorf(CODE_UNAVAILABLE, "no data"))
+	assert.False(t, ok)
+
+	hint, ok = FromDetails(NewHint(REASON_FROZEN, time.Second).Details())
+	assert.True(t, ok)
+	assert.Equal(t, &Hint{Reason: REASON_FROZEN, RetryAfter: 1000}, hint)
+	_, ok = FromDetails("syntax error")
+	assert.False(t, ok)
+}
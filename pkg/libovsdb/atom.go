@@ -0,0 +1,75 @@
+package libovsdb
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// CompareAtoms defines the canonical order of OVSDB atoms: numbers, booleans, strings and UUIDs are compared by
+// value, atoms of different types by their type, and unknown types by their JSON encoding. It returns a negative
+// number, zero or a positive number, if a is less, equal or greater than b.
+func CompareAtoms(a, b interface{}) int {
+	ra, rb := atomRank(a), atomRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+	switch va := a.(type) {
+	case bool:
+		vb := b.(bool)
+		if va == vb {
+			return 0
+		}
+		if !va {
+			return -1
+		}
+		return 1
+	case string:
+		return strings.Compare(va, b.(string))
+	case UUID:
+		return strings.Compare(strings.ToLower(va.GoUUID), strings.ToLower(b.(UUID).GoUUID))
+	}
+	if fa, ok := atomNumber(a); ok {
+		fb, _ := atomNumber(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return strings.Compare(string(ja), string(jb))
+}
+
+func atomRank(a interface{}) int {
+	switch a.(type) {
+	case bool:
+		return 1
+	case string:
+		return 2
+	case UUID:
+		return 3
+	}
+	if _, ok := atomNumber(a); ok {
+		return 0
+	}
+	return 4
+}
+
+func atomNumber(a interface{}) (float64, bool) {
+	switch v := a.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
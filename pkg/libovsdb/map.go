@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"reflect"
+	"sort"
 )
 
 // OvsMap is the JSON map structure used for OVSDB
@@ -22,10 +23,16 @@ func (o OvsMap) MarshalJSON() ([]byte, error) {
 	if len(o.GoMap) > 0 {
 		var ovsMap, innerMap []interface{}
 		ovsMap = append(ovsMap, "map")
-		for key, val := range o.GoMap {
+		keys := make([]interface{}, 0, len(o.GoMap))
+		for key := range o.GoMap {
+			keys = append(keys, key)
+		}
+		// the pairs are sorted by their keys, so equal maps have equal encodings
+		sort.Slice(keys, func(i, j int) bool { return CompareAtoms(keys[i], keys[j]) < 0 })
+		for _, key := range keys {
 			var mapSeg []interface{}
 			mapSeg = append(mapSeg, key)
-			mapSeg = append(mapSeg, val)
+			mapSeg = append(mapSeg, o.GoMap[key])
 			innerMap = append(innerMap, mapSeg)
 		}
 		ovsMap = append(ovsMap, innerMap)
@@ -42,7 +49,20 @@ func (o *OvsMap) UnmarshalJSON(b []byte) (err error) {
 		innerSlice := oMap[1].([]interface{})
 		for _, val := range innerSlice {
 			f := val.([]interface{})
-			o.GoMap[f[0]] = f[1]
+			key := f[0]
+			if pair, ok := key.([]interface{}); ok {
+				// a uuid or a named-uuid key, the JSON arrays cannot be keys of a Go map
+				var uuid UUID
+				data, err := json.Marshal(pair)
+				if err != nil {
+					return err
+				}
+				if err := json.Unmarshal(data, &uuid); err != nil {
+					return err
+				}
+				key = uuid
+			}
+			o.GoMap[key] = f[1]
 		}
 	}
 	return err
@@ -21,6 +21,10 @@ type Operation struct {
 	UUID      *UUID                     `json:"uuid,omitempty"`
 	Comment   *string                   `json:"comment,omitempty"`
 	Durable   *bool                     `json:"durable,omitempty"`
+	Lock      *string                   `json:"lock,omitempty"`
+	// Paging extension of select, not a part of RFC7047
+	PageSize  *int    `json:"page-size,omitempty"`
+	PageToken *string `json:"page-token,omitempty"`
 }
 
 // String, serialize Transact
@@ -174,6 +178,28 @@ type TransactResponse struct {
 	Error  *string           `json:"error,omitempty"`
 }
 
+// Results returns the result of the transact method (RFC 7047, section 4.1.3): the results of the operations executed
+// before a failed operation, the error object of the failed operation, and nulls for the following operations. A
+// failure of the transaction after all its operations were executed is reported by an additional error object.
+func (res TransactResponse) Results(operations int) []interface{} {
+	results := make([]interface{}, 0, len(res.Result)+1)
+	failed := false
+	for i, result := range res.Result {
+		if failed && i < operations {
+			results = append(results, nil)
+			continue
+		}
+		results = append(results, result)
+		if result.Error != nil {
+			failed = true
+		}
+	}
+	if res.Error != nil && !failed {
+		results = append(results, OperationResult{Error: res.Error})
+	}
+	return results
+}
+
 // OperationResult is the result of an Operation
 type OperationResult struct {
 	Count   *int         `json:"count,omitempty"`
@@ -181,6 +207,8 @@ type OperationResult struct {
 	Details *string      `json:"details,omitempty"`
 	UUID    *UUID        `json:"uuid,omitempty"`
 	Rows    *[]ResultRow `json:"rows,omitempty"`
+	// the continuation token of a paged select, nil on the last page
+	NextPageToken *string `json:"next-page-token,omitempty"`
 }
 
 func (res *OperationResult) SetError(err string) {
@@ -188,6 +216,7 @@ func (res *OperationResult) SetError(err string) {
 	res.Count = nil
 	res.UUID = nil
 	res.Rows = nil
+	res.NextPageToken = nil
 }
 
 // String, serialize TransactResponse
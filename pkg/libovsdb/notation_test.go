@@ -177,3 +177,26 @@ func TestNewMutation(t *testing.T) {
 		t.Error("mutation is not correctly formatted")
 	}
 }
+
+func TestTransactResponseResults(t *testing.T) {
+	count := 1
+	errStr := "constraint violation"
+	details := "details"
+	res := TransactResponse{
+		Result: []OperationResult{{Count: &count}, {Error: &errStr, Details: &details}, {}},
+		Error:  &errStr,
+	}
+	str, _ := json.Marshal(res.Results(3))
+	expected := `[{"count":1},{"error":"constraint violation","details":"details"},null]`
+	if string(str) != expected {
+		t.Error("Expected: ", expected, "Got", string(str))
+	}
+
+	// a failure after the execution of the operations
+	res = TransactResponse{Result: []OperationResult{{Count: &count}}, Error: &errStr}
+	str, _ = json.Marshal(res.Results(1))
+	expected = `[{"count":1},{"error":"constraint violation"}]`
+	if string(str) != expected {
+		t.Error("Expected: ", expected, "Got", string(str))
+	}
+}
@@ -0,0 +1,442 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/go-logr/logr"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+// Admin provides administrative jrpc methods, which are not part of the OVSDB protocol. The adminMethods are served
+// to the authenticated clients of the ADMIN_ROLE only.
+type Admin struct {
+	log logr.Logger
+	db  Databaser
+
+	mu sync.Mutex
+	// handlers of the connected clients
+	handlers map[*Handler]struct{}
+	// the locks of the connected clients
+	locks *LockRegistry
+}
+
+func NewAdmin(db Databaser, log logr.Logger) *Admin {
+	return &Admin{
+		log:      log.WithName("admin"),
+		db:       db,
+		handlers: map[*Handler]struct{}{},
+		locks:    NewLockRegistry(),
+	}
+}
+
+// LockRegistry returns the registry of the locks of the connected clients
+func (a *Admin) LockRegistry() *LockRegistry {
+	return a.locks
+}
+
+// AddHandler registers a client connection handler, so administrative methods can act on it.
+func (a *Admin) AddHandler(ch *Handler) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.handlers[ch] = struct{}{}
+}
+
+func (a *Admin) RemoveHandler(ch *Handler) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.handlers, ch)
+}
+
+func (a *Admin) getHandlers() []*Handler {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	handlers := make([]*Handler, 0, len(a.handlers))
+	for ch := range a.handlers {
+		handlers = append(handlers, ch)
+	}
+	return handlers
+}
+
+// Freeze makes the database temporarily read-only, transactions that modify the database are rejected with the
+// E_FROZEN error, while monitors are not affected. The call returns after all the in-flight transactions of the
+// database are completed.
+// "params": [<db-name>, <boolean>]
+// Returns: "result": {"frozen": boolean}
+func (a *Admin) Freeze(ctx context.Context, params []interface{}) (interface{}, error) {
+	a.log.V(5).Info("freeze request", "params", params)
+	if len(params) != 2 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(params))
+	}
+	dbName, ok := params[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong database name %v", params[0])
+	}
+	frozen, ok := params[1].(bool)
+	if !ok {
+		return nil, fmt.Errorf("wrong freeze flag %v", params[1])
+	}
+	if err := a.db.SetFrozen(dbName, frozen); err != nil {
+		a.log.Error(err, "freeze failed", "dbName", dbName, "frozen", frozen)
+		return nil, err
+	}
+	a.log.Info("database freeze state changed", "dbName", dbName, "frozen", frozen)
+	return map[string]bool{"frozen": frozen}, nil
+}
+
+// ReadOnly sets the read-only mode of the database, the transactions that modify the database fail with the "not
+// allowed" error, while the monitors and the read-only operations are served. The call returns after all the in-flight
+// transactions of the database are completed.
+// "params": [<db-name>, <boolean>]
+// Returns: "result": {"read-only": boolean}
+func (a *Admin) ReadOnly(ctx context.Context, params []interface{}) (interface{}, error) {
+	a.log.V(5).Info("read-only request", "params", params)
+	if len(params) != 2 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(params))
+	}
+	dbName, ok := params[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong database name %v", params[0])
+	}
+	readOnly, ok := params[1].(bool)
+	if !ok {
+		return nil, fmt.Errorf("wrong read-only flag %v", params[1])
+	}
+	if err := a.db.SetReadOnly(dbName, readOnly); err != nil {
+		a.log.Error(err, "read-only failed", "dbName", dbName, "read-only", readOnly)
+		return nil, err
+	}
+	a.log.Info("database read-only mode changed", "dbName", dbName, "read-only", readOnly)
+	return map[string]bool{"read-only": readOnly}, nil
+}
+
+// Resync sends to the update3 monitors of the database a delete of every row they have delivered, followed by a full
+// snapshot of the monitored data, it allows to recover clients suspected of state divergence without reconnecting
+// them. If the client address is
+// provided, only the monitors of this client are resynced, otherwise all the clients of the database.
+// "params": [<db-name>, <client-address>]  <client-address> is optional
+// Returns: "result": {"monitors": <number of resynced monitors>}
+func (a *Admin) Resync(ctx context.Context, params []interface{}) (interface{}, error) {
+	a.log.V(5).Info("resync request", "params", params)
+	if len(params) != 1 && len(params) != 2 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(params))
+	}
+	dbName, ok := params[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong database name %v", params[0])
+	}
+	client := ""
+	if len(params) == 2 {
+		client, ok = params[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("wrong client address %v", params[1])
+		}
+	}
+	if a.db.GetSchema(dbName) == nil {
+		return nil, fmt.Errorf("unknown database")
+	}
+	monitors := 0
+	for _, ch := range a.getHandlers() {
+		if client != "" && ch.GetClientAddress() != client {
+			continue
+		}
+		n, err := ch.resync(dbName)
+		if err != nil {
+			a.log.Error(err, "resync failed", "dbName", dbName, "client", ch.GetClientAddress())
+			return nil, err
+		}
+		monitors += n
+	}
+	a.log.Info("resync completed", "dbName", dbName, "client", client, "monitors", monitors)
+	return map[string]int{"monitors": monitors}, nil
+}
+
+// CancelMonitor cancels a monitor of a specific client, the client is notified by the monitor_canceled notification
+// with the "admin-cancel" reason, while its connection and other monitors are not affected.
+// "params": [<client-address>, <json-value>]
+// Returns: "result": {"canceled": true}
+func (a *Admin) CancelMonitor(ctx context.Context, params []interface{}) (interface{}, error) {
+	a.log.V(5).Info("cancel monitor request", "params", params)
+	if len(params) != 2 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(params))
+	}
+	client, ok := params[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong client address %v", params[0])
+	}
+	for _, ch := range a.getHandlers() {
+		if ch.GetClientAddress() != client {
+			continue
+		}
+		if err := ch.removeMonitor(params[1], CANCEL_REASON_ADMIN_CANCEL); err != nil {
+			a.log.Error(err, "cancel monitor failed", "client", client, "jsonValue", params[1])
+			return nil, err
+		}
+		a.log.Info("monitor canceled", "client", client, "jsonValue", params[1])
+		return map[string]bool{"canceled": true}, nil
+	}
+	return nil, fmt.Errorf("unknown client %s", client)
+}
+
+// requester returns the handler of the client, which sent the request of the context, nil if the context is not of
+// an inbound request.
+func (a *Admin) requester(ctx context.Context) *Handler {
+	if jrpc2.InboundRequest(ctx) == nil {
+		return nil
+	}
+	server := jrpc2.ServerFromContext(ctx)
+	for _, ch := range a.getHandlers() {
+		if ch.jrpcServer == server {
+			return ch
+		}
+	}
+	return nil
+}
+
+// DatabaseChanged notifies the clients about the conversion of the database to a new schema, the context is of the
+// convert request. The db change aware clients get the monitor_canceled notifications of the database monitors, and
+// the update of its row by their _Server monitors, while the connections of the other clients are closed, except the
+// connection of the convert request.
+func (a *Admin) DatabaseChanged(ctx context.Context, dbName string) {
+	a.log.Info("database changed", "dbName", dbName)
+	var requester JrpcServer
+	if jrpc2.InboundRequest(ctx) != nil {
+		requester = jrpc2.ServerFromContext(ctx)
+	}
+	for _, ch := range a.getHandlers() {
+		ch.databaseChanged(dbName, requester != nil && ch.jrpcServer == requester)
+	}
+}
+
+// Quarantine lists the stored rows, which failed to unmarshal or to pass the schema validation, and are skipped by
+// select and monitors.
+// "params": []
+// Returns: "result": [{"key": <etcd key>, "reason": <error>, "mod-revision": <revision>, "since": <time>}, ...]
+func (a *Admin) Quarantine(ctx context.Context, params []interface{}) (interface{}, error) {
+	a.log.V(5).Info("quarantine request", "params", params)
+	if len(params) != 0 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(params))
+	}
+	return quarantine.List(), nil
+}
+
+// Repair resolves a quarantined row. The "delete" action removes the row from etcd, if it was not modified since it
+// was read and it is still invalid. The "release" action returns the row to service, if it passes the validation,
+// e.g. after it was fixed manually or the schema was upgraded.
+// "params": [<etcd key>, "delete" | "release"]
+// Returns: "result": {"deleted": boolean, "released": boolean}
+func (a *Admin) Repair(ctx context.Context, params []interface{}) (interface{}, error) {
+	a.log.V(5).Info("repair request", "params", params)
+	if len(params) != 2 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(params))
+	}
+	strKey, ok := params[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong key %v", params[0])
+	}
+	action, ok := params[1].(string)
+	if !ok || (action != "delete" && action != "release") {
+		return nil, fmt.Errorf("wrong repair action %v", params[1])
+	}
+	if _, ok := quarantine.Get(strKey); !ok {
+		return nil, fmt.Errorf("key %s is not quarantined", strKey)
+	}
+	key, err := common.ParseKey(strKey)
+	if err != nil {
+		// rows with malformed keys are not accessible by the database API, they should be removed directly from etcd
+		return nil, err
+	}
+	resp, err := a.db.GetKeyData(*key, false)
+	if err != nil {
+		return nil, err
+	}
+	var kv *mvccpb.KeyValue
+	for _, k := range resp.Kvs {
+		if string(k.Key) == strKey {
+			kv = k
+		}
+	}
+	if kv == nil {
+		// the row was deleted meanwhile
+		quarantine.Remove(strKey)
+		a.log.Info("quarantined row does not exist", "key", strKey)
+		return map[string]bool{"deleted": false, "released": true}, nil
+	}
+	validationErr := validateStoredRow(a.db.GetSchemas(), key, kv.Value)
+	if action == "release" {
+		if validationErr != nil {
+			return nil, validationErr
+		}
+		quarantine.Remove(strKey)
+		a.log.Info("quarantined row released", "key", strKey)
+		return map[string]bool{"deleted": false, "released": true}, nil
+	}
+	if validationErr == nil {
+		return nil, fmt.Errorf("row %s is valid, it should be released", strKey)
+	}
+	deleted, err := a.db.DeleteData(ctx, *key, kv.ModRevision)
+	if err != nil {
+		a.log.Error(err, "quarantined row delete failed", "key", strKey)
+		return nil, err
+	}
+	if !deleted {
+		return nil, fmt.Errorf("row %s was modified concurrently", strKey)
+	}
+	quarantine.Remove(strKey)
+	a.log.Info("quarantined row deleted", "key", strKey, "mod-revision", kv.ModRevision)
+	return map[string]bool{"deleted": true, "released": false}, nil
+}
+
+// StorageStatsLargestRows is the number of the largest rows, which are reported by the storage statistics
+var StorageStatsLargestRows = 10
+
+// DatabaseStorageStats summarizes the etcd storage of a database, the sizes are the sizes of the keys and the values
+type DatabaseStorageStats struct {
+	// the etcd revision, at which the statistics were collected
+	Revision int64 `json:"revision"`
+	// the highest modification revision of the database keys
+	ModRevision int64                        `json:"mod-revision"`
+	Keys        int64                        `json:"keys"`
+	Bytes       int64                        `json:"bytes"`
+	Tables      map[string]TableStorageStats `json:"tables"`
+	LargestRows []RowStorageStats            `json:"largest-rows"`
+}
+
+type TableStorageStats struct {
+	Keys  int64 `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+type RowStorageStats struct {
+	Table string `json:"table"`
+	UUID  string `json:"uuid"`
+	Bytes int64  `json:"bytes"`
+}
+
+// StorageStats returns the etcd storage statistics of the databases: keys count, total bytes, the largest rows and the
+// current etcd revision. If the database name is provided, only its statistics are returned.
+// "params": [<db-name>]  <db-name> is optional
+// Returns: "result": {<db-name>: {"revision": <revision>, "mod-revision": <revision>, "keys": <keys>, "bytes": <bytes>,
+// "tables": {<table>: {"keys": <keys>, "bytes": <bytes>}, ...}, "largest-rows": [{"table": <table>, "uuid": <uuid>,
+// "bytes": <bytes>}, ...]}, ...}
+func (a *Admin) StorageStats(ctx context.Context, params []interface{}) (interface{}, error) {
+	a.log.V(5).Info("storage stats request", "params", params)
+	if len(params) > 1 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(params))
+	}
+	var dbNames []string
+	if len(params) == 1 {
+		dbName, ok := params[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("wrong database name %v", params[0])
+		}
+		if a.db.GetSchema(dbName) == nil {
+			return nil, fmt.Errorf("unknown database")
+		}
+		dbNames = append(dbNames, dbName)
+	} else {
+		for dbName := range a.db.GetSchemas() {
+			dbNames = append(dbNames, dbName)
+		}
+	}
+	result := map[string]*DatabaseStorageStats{}
+	for _, dbName := range dbNames {
+		resp, err := a.db.GetKeyData(common.NewDBPrefixKey(dbName), false)
+		if err != nil {
+			a.log.Error(err, "storage stats failed", "dbName", dbName)
+			return nil, err
+		}
+		result[dbName] = storageStats(resp, StorageStatsLargestRows)
+	}
+	return result, nil
+}
+
+func storageStats(resp *clientv3.GetResponse, largest int) *DatabaseStorageStats {
+	stats := &DatabaseStorageStats{
+		Revision:    resp.Header.Revision,
+		Tables:      map[string]TableStorageStats{},
+		LargestRows: []RowStorageStats{},
+	}
+	for _, kv := range resp.Kvs {
+		size := int64(len(kv.Key) + len(kv.Value))
+		stats.Keys++
+		stats.Bytes += size
+		if kv.ModRevision > stats.ModRevision {
+			stats.ModRevision = kv.ModRevision
+		}
+		key, err := common.ParseKey(string(kv.Key))
+		if err != nil {
+			// counted in the database totals only
+			continue
+		}
+		table := stats.Tables[key.TableName]
+		table.Keys++
+		table.Bytes += size
+		stats.Tables[key.TableName] = table
+		stats.LargestRows = append(stats.LargestRows, RowStorageStats{Table: key.TableName, UUID: key.UUID, Bytes: size})
+	}
+	sort.SliceStable(stats.LargestRows, func(i, j int) bool {
+		return stats.LargestRows[i].Bytes > stats.LargestRows[j].Bytes
+	})
+	if len(stats.LargestRows) > largest {
+		stats.LargestRows = stats.LargestRows[:largest]
+	}
+	return stats
+}
+
+// CommitLogSize is the default number of the recent commit comments, which are returned by the commit log
+var CommitLogSize = 20
+
+// CommitLog returns the comments of the recent commits, recorded by the "comment" operations of the transactions, the
+// newest first. If the database name is empty, the comments of all the databases are returned.
+// "params": [<db-name>, <limit>]  <db-name> and <limit> are optional
+// Returns: "result": [{"db-name": <db-name>, "comment": <comment>, "timestamp": <timestamp>, "revision": <revision>},
+// ...]
+func (a *Admin) CommitLog(ctx context.Context, params []interface{}) (interface{}, error) {
+	a.log.V(5).Info("commit log request", "params", params)
+	if len(params) > 2 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(params))
+	}
+	dbName := ""
+	if len(params) > 0 {
+		var ok bool
+		dbName, ok = params[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("wrong database name %v", params[0])
+		}
+		if dbName != "" && a.db.GetSchema(dbName) == nil {
+			return nil, fmt.Errorf("unknown database")
+		}
+	}
+	limit := CommitLogSize
+	if len(params) > 1 {
+		l, ok := params[1].(float64)
+		if !ok || l < 1 {
+			return nil, fmt.Errorf("wrong limit %v", params[1])
+		}
+		limit = int(l)
+	}
+	comments, err := a.db.GetCommitComments(ctx, dbName, limit)
+	if err != nil {
+		a.log.Error(err, "commit log failed", "dbName", dbName)
+		return nil, err
+	}
+	return comments, nil
+}
+
+// ListLocks lists the locks of the connected clients, with the addresses of their owners and waiters.
+// "params": []
+// Returns: "result": [{"lock": <lock-id>, "owner": <client-address>, "waiters": [<client-address>, ...]}, ...]
+func (a *Admin) ListLocks(ctx context.Context, params []interface{}) (interface{}, error) {
+	a.log.V(5).Info("list locks request", "params", params)
+	if len(params) != 0 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(params))
+	}
+	return a.locks.Locks(), nil
+}
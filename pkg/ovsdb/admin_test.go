@@ -0,0 +1,230 @@
+package ovsdb
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func TestAdminFreeze(t *testing.T) {
+	db := &DatabaseEtcd{locks: map[string]*sync.RWMutex{"simple": {}}, frozen: map[string]bool{}}
+	admin := NewAdmin(db, klogr.New())
+	ctx := context.Background()
+
+	resp, err := admin.Freeze(ctx, []interface{}{"simple", true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"frozen": true}, resp)
+	assert.True(t, db.IsFrozen("simple"))
+
+	_, err = admin.Freeze(ctx, []interface{}{"simple", false})
+	assert.Nil(t, err)
+	assert.False(t, db.IsFrozen("simple"))
+
+	_, err = admin.Freeze(ctx, []interface{}{"unknown", true})
+	assert.NotNil(t, err)
+	_, err = admin.Freeze(ctx, []interface{}{"simple"})
+	assert.NotNil(t, err)
+	_, err = admin.Freeze(ctx, []interface{}{"simple", "true"})
+	assert.NotNil(t, err)
+}
+
+func TestAdminReadOnly(t *testing.T) {
+	db := &DatabaseEtcd{locks: map[string]*sync.RWMutex{"simple": {}}, readOnly: map[string]bool{}}
+	admin := NewAdmin(db, klogr.New())
+	ctx := context.Background()
+
+	resp, err := admin.ReadOnly(ctx, []interface{}{"simple", true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"read-only": true}, resp)
+	assert.True(t, db.IsReadOnly("simple"))
+
+	_, err = admin.ReadOnly(ctx, []interface{}{"simple", false})
+	assert.Nil(t, err)
+	assert.False(t, db.IsReadOnly("simple"))
+
+	_, err = admin.ReadOnly(ctx, []interface{}{"unknown", true})
+	assert.NotNil(t, err)
+	_, err = admin.ReadOnly(ctx, []interface{}{"simple", "true"})
+	assert.NotNil(t, err)
+}
+
+func TestIsReadOnlyTransaction(t *testing.T) {
+	req := &libovsdb.Transact{DBName: "simple", Operations: []libovsdb.Operation{{Op: OP_SELECT}, {Op: OP_COMMENT}}}
+	assert.True(t, isReadOnlyTransaction(req))
+	req.Operations = append(req.Operations, libovsdb.Operation{Op: OP_INSERT})
+	assert.False(t, isReadOnlyTransaction(req))
+}
+
+func TestHandlerResync(t *testing.T) {
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T3": {}},
+	}}
+	msg := `["dbName",["monid","update3"], {"T3":[{}]}, "00000000-0000-0000-0000-000000000000"]`
+	handler := initHandler(t, schemas, msg, ovsjson.Update3)
+	row := map[string]interface{}{"c1": "v1"}
+	rowJson := prepareData(t, row, true)
+	handler.db.(*DatabaseMock).Response = &clientv3.TxnResponse{
+		Header: &etcdserverpb.ResponseHeader{Revision: 5},
+		Responses: []*etcdserverpb.ResponseOp{{Response: &etcdserverpb.ResponseOp_ResponseRange{
+			ResponseRange: &etcdserverpb.RangeResponse{Kvs: []*mvccpb.KeyValue{{Key: []byte("ovsdb/nb/dbName/T3/000"), Value: rowJson}}},
+		}}},
+	}
+	hmd := handler.handlerMonitorData[jsonValueToString([]interface{}{"monid", "update3"})]
+	done := make(chan notificationEvent, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			ev, _ := hmd.notifications.next(context.Background())
+			done <- ev
+		}
+	}()
+	n, err := handler.resync(DB_NAME)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	// the rows the client has are deleted before the snapshot, so the rows missing from it don't linger
+	event := <-done
+	assert.NotEqual(t, "", event.txnID)
+	assert.NotEqual(t, ovsjson.ZERO_UUID, event.txnID)
+	assert.Equal(t, ovsjson.TableUpdates{"T3": {ROW_UUID: {Delete: true}}}, event.updates)
+	event = <-done
+	assert.NotEqual(t, "", event.txnID)
+	assert.NotEqual(t, ovsjson.ZERO_UUID, event.txnID)
+	delete(row, COL_UUID)
+	assert.Equal(t, ovsjson.TableUpdates{"T3": {ROW_UUID: {Initial: &row}}}, event.updates)
+
+	n, err = handler.resync("unknown")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestAdminCancelMonitor(t *testing.T) {
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {}},
+	}}
+	db := DatabaseMock{Response: schemas}
+	handler := NewHandler(context.Background(), &db, nil, klogr.New())
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	recorder := &jrpcServerRecorder{}
+	handler.SetConnection(recorder, c1)
+	admin := NewAdmin(&db, klogr.New())
+	admin.AddHandler(handler)
+	for _, id := range []string{"mon1", "mon2"} {
+		_, err := handler.addMonitor([]interface{}{DB_NAME, id, map[string]interface{}{"T1": []interface{}{map[string]interface{}{}}}}, ovsjson.Update2)
+		assert.Nil(t, err)
+	}
+	client := handler.GetClientAddress()
+
+	resp, err := admin.CancelMonitor(context.Background(), []interface{}{client, "mon1"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"canceled": true}, resp)
+	assert.Nil(t, handler.FlushNotifications(context.Background()))
+	recorder.mu.Lock()
+	assert.Equal(t, []string{MONITOR_CANCELED}, recorder.method)
+	assert.JSONEq(t, `["mon1", {"reason": "admin-cancel"}]`, string(recorder.params[0]))
+	recorder.mu.Unlock()
+	_, ok := handler.handlerMonitorData[jsonValueToString("mon1")]
+	assert.False(t, ok)
+	_, ok = handler.handlerMonitorData[jsonValueToString("mon2")]
+	assert.True(t, ok)
+
+	_, err = admin.CancelMonitor(context.Background(), []interface{}{client, "mon1"})
+	assert.NotNil(t, err)
+	_, err = admin.CancelMonitor(context.Background(), []interface{}{"unknown", "mon2"})
+	assert.NotNil(t, err)
+}
+
+func TestAdminStorageStats(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	db, _ := NewDatabaseEtcd(cli)
+	db.(*DatabaseEtcd).Schemas.Add(testSchemaSimple)
+	db.(*DatabaseEtcd).strSchemas["simple"] = map[string]interface{}{}
+	testEtcdPut(t, "simple", "table1", map[string]interface{}{"key1": "small"})
+	testEtcdPut(t, "simple", "table1", map[string]interface{}{"key1": strings.Repeat("large", 100)})
+	admin := NewAdmin(db, klogr.New())
+
+	resp, err := admin.StorageStats(context.Background(), []interface{}{"simple"})
+	assert.Nil(t, err)
+	stats := resp.(map[string]*DatabaseStorageStats)["simple"]
+	assert.Equal(t, int64(2), stats.Keys)
+	assert.Equal(t, int64(2), stats.Tables["table1"].Keys)
+	assert.Equal(t, stats.Bytes, stats.Tables["table1"].Bytes)
+	assert.Equal(t, 2, len(stats.LargestRows))
+	assert.Greater(t, stats.LargestRows[0].Bytes, stats.LargestRows[1].Bytes+int64(400))
+	assert.Equal(t, stats.Bytes, stats.LargestRows[0].Bytes+stats.LargestRows[1].Bytes)
+	assert.GreaterOrEqual(t, stats.Revision, stats.ModRevision)
+	assert.Greater(t, stats.ModRevision, int64(0))
+
+	_, err = admin.StorageStats(context.Background(), []interface{}{"unknown"})
+	assert.NotNil(t, err)
+}
+
+func TestAdminCommitLog(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	db, _ := NewDatabaseEtcd(cli)
+	db.(*DatabaseEtcd).Schemas.Add(testSchemaSimple)
+	db.(*DatabaseEtcd).strSchemas["simple"] = map[string]interface{}{}
+	admin := NewAdmin(db, klogr.New())
+
+	table := "table1"
+	row := map[string]interface{}{"key1": "val1"}
+	revisions := []int64{}
+	for _, comment := range []string{"first", "second", "third"} {
+		comment := comment
+		resp, txn := testTransact(t, &libovsdb.Transact{
+			DBName: "simple",
+			Operations: []libovsdb.Operation{
+				{Op: OP_INSERT, Table: &table, Row: &row},
+				{Op: OP_COMMENT, Comment: &comment},
+			},
+		})
+		assert.Nil(t, resp.Error)
+		revisions = append(revisions, txn.etcd.Res.Header.Revision)
+	}
+
+	resp, err := admin.CommitLog(context.Background(), []interface{}{"simple", float64(2)})
+	assert.Nil(t, err)
+	comments := resp.([]CommitComment)
+	if assert.Equal(t, 2, len(comments)) {
+		assert.Equal(t, "third", comments[0].Comment)
+		assert.Equal(t, revisions[2], comments[0].Revision)
+		assert.Equal(t, "simple", comments[0].DBName)
+		assert.NotEmpty(t, comments[0].Timestamp)
+		assert.Equal(t, "second", comments[1].Comment)
+		assert.Equal(t, revisions[1], comments[1].Revision)
+	}
+	resp, err = admin.CommitLog(context.Background(), []interface{}{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(resp.([]CommitComment)))
+
+	_, err = admin.CommitLog(context.Background(), []interface{}{"unknown"})
+	assert.NotNil(t, err)
+}
+
+func TestHandlerDatabaseChangedWithoutServer(t *testing.T) {
+	handler := NewHandler(context.Background(), &DatabaseMock{}, nil, klogr.New())
+	// the db change unaware client has no connection to close yet
+	assert.NotPanics(t, func() { handler.databaseChanged(DB_NAME, false) })
+}
@@ -0,0 +1,39 @@
+package ovsdb
+
+// Stages of the notification path, whose allocations are recorded by the allocation audit
+const (
+	// the preparation of the table updates from the etcd events
+	ALLOC_STAGE_DIFF = "diff"
+	// the serialization of the notification payload
+	ALLOC_STAGE_ENCODE = "encode"
+	// the notification send, including the jrpc2 encoding and the socket write
+	ALLOC_STAGE_SEND = "send"
+)
+
+// allocSample is a snapshot of the process allocation counters, taken at the beginning of a stage
+type allocSample struct {
+	mallocs uint64
+	bytes   uint64
+}
+
+// AllocStageStats summarizes the allocations of a notification path stage during an audit interval
+type AllocStageStats struct {
+	Stage   string `json:"stage"`
+	Calls   uint64 `json:"calls"`
+	Mallocs uint64 `json:"mallocs"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+func (s AllocStageStats) MallocsPerCall() float64 {
+	if s.Calls == 0 {
+		return 0
+	}
+	return float64(s.Mallocs) / float64(s.Calls)
+}
+
+func (s AllocStageStats) BytesPerCall() float64 {
+	if s.Calls == 0 {
+		return 0
+	}
+	return float64(s.Bytes) / float64(s.Calls)
+}
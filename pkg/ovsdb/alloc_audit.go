@@ -0,0 +1,101 @@
+//go:build allocaudit
+// +build allocaudit
+
+package ovsdb
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// AllocAuditSupported is true if the server is built with the "allocaudit" tag
+const AllocAuditSupported = true
+
+// allocAuditor is nil if the audit is disabled
+var allocAuditor *AllocAuditor
+
+// AllocAuditor records the allocations of the notification path stages. The counters are process wide, so a stage
+// sample includes the allocations of the goroutines running concurrently with it, the numbers are meaningful as
+// averages over many calls and not per a single call. Every sample stops the world, the audit is intended for
+// performance investigations and not for a regular production build.
+type AllocAuditor struct {
+	mu     sync.Mutex
+	stages map[string]*AllocStageStats
+}
+
+func NewAllocAuditor() *AllocAuditor {
+	return &AllocAuditor{stages: map[string]*AllocStageStats{}}
+}
+
+// EnableAllocAudit turns on the allocation audit, the summary of every interval is logged. Should be called before the
+// server starts serving.
+func EnableAllocAudit(ctx context.Context, interval time.Duration, log logr.Logger) error {
+	allocAuditor = NewAllocAuditor()
+	log = log.WithName("alloc-audit")
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, s := range allocAuditor.Reset() {
+					log.Info("notification stage allocations", "stage", s.Stage, "calls", s.Calls,
+						"mallocs-per-call", s.MallocsPerCall(), "bytes-per-call", s.BytesPerCall())
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func allocStart() allocSample {
+	if allocAuditor == nil {
+		return allocSample{}
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return allocSample{mallocs: ms.Mallocs, bytes: ms.TotalAlloc}
+}
+
+func allocEnd(stage string, start allocSample) {
+	if allocAuditor == nil {
+		return
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	allocAuditor.add(stage, ms.Mallocs-start.mallocs, ms.TotalAlloc-start.bytes)
+}
+
+func (a *AllocAuditor) add(stage string, mallocs, bytes uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.stages[stage]
+	if !ok {
+		s = &AllocStageStats{Stage: stage}
+		a.stages[stage] = s
+	}
+	s.Calls++
+	s.Mallocs += mallocs
+	s.Bytes += bytes
+}
+
+// Reset returns the stages statistics ordered by the stage name, and starts a new interval
+func (a *AllocAuditor) Reset() []AllocStageStats {
+	a.mu.Lock()
+	stages := a.stages
+	a.stages = map[string]*AllocStageStats{}
+	a.mu.Unlock()
+	stats := make([]AllocStageStats, 0, len(stages))
+	for _, s := range stages {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Stage < stats[j].Stage })
+	return stats
+}
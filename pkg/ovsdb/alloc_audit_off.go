@@ -0,0 +1,25 @@
+//go:build !allocaudit
+// +build !allocaudit
+
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// AllocAuditSupported is true if the server is built with the "allocaudit" tag
+const AllocAuditSupported = false
+
+func EnableAllocAudit(ctx context.Context, interval time.Duration, log logr.Logger) error {
+	return fmt.Errorf("allocation audit is not supported, the server should be built with the \"allocaudit\" tag")
+}
+
+func allocStart() allocSample {
+	return allocSample{}
+}
+
+func allocEnd(stage string, start allocSample) {}
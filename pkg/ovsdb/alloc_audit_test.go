@@ -0,0 +1,35 @@
+//go:build allocaudit
+// +build allocaudit
+
+package ovsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocAuditor(t *testing.T) {
+	allocAuditor = NewAllocAuditor()
+	defer func() {
+		allocAuditor = nil
+	}()
+	sample := allocStart()
+	buf := make([][]byte, 0)
+	for i := 0; i < 100; i++ {
+		buf = append(buf, make([]byte, 1024))
+	}
+	allocEnd(ALLOC_STAGE_ENCODE, sample)
+	allocAuditor.add(ALLOC_STAGE_DIFF, 4, 40)
+	allocAuditor.add(ALLOC_STAGE_DIFF, 2, 20)
+
+	stats := allocAuditor.Reset()
+	assert.Equal(t, 2, len(stats))
+	assert.Equal(t, AllocStageStats{Stage: ALLOC_STAGE_DIFF, Calls: 2, Mallocs: 6, Bytes: 60}, stats[0])
+	assert.Equal(t, ALLOC_STAGE_ENCODE, stats[1].Stage)
+	assert.Equal(t, uint64(1), stats[1].Calls)
+	assert.True(t, stats[1].Mallocs >= 100)
+	assert.True(t, stats[1].Bytes >= 100*1024)
+	assert.Equal(t, 0, len(allocAuditor.Reset()))
+	assert.Equal(t, 100, len(buf))
+}
@@ -0,0 +1,27 @@
+package ovsdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+)
+
+func TestAllocStageStats(t *testing.T) {
+	s := AllocStageStats{Stage: ALLOC_STAGE_DIFF}
+	assert.Equal(t, float64(0), s.MallocsPerCall())
+	s = AllocStageStats{Stage: ALLOC_STAGE_DIFF, Calls: 4, Mallocs: 10, Bytes: 1000}
+	assert.Equal(t, 2.5, s.MallocsPerCall())
+	assert.Equal(t, float64(250), s.BytesPerCall())
+}
+
+func TestEnableAllocAudit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := EnableAllocAudit(ctx, time.Minute, klogr.New())
+	assert.Equal(t, AllocAuditSupported, err == nil)
+	// the stages are recorded only if the audit is supported, and must not fail otherwise
+	allocEnd(ALLOC_STAGE_SEND, allocStart())
+}
@@ -0,0 +1,263 @@
+package ovsdb
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/creachadair/jrpc2"
+)
+
+const (
+	AUTH_METHOD_NONE      = "none"
+	AUTH_METHOD_UNIX_PEER = "unix-peer"
+	AUTH_METHOD_TLS       = "tls"
+	AUTH_METHOD_TOKEN     = "token"
+
+	ANONYMOUS_IDENTITY = "anonymous"
+	// the role of the authenticated clients, which may call the adminMethods
+	ADMIN_ROLE = "admin"
+)
+
+// Identity describes an authenticated client, it is consumed by the access control and audit subsystems.
+type Identity struct {
+	// the client name, e.g. TLS certificate common name or unix user name
+	Name string `json:"name"`
+	// the role of the client, empty for the default role
+	Role string `json:"role,omitempty"`
+	// the authentication method that produced the identity
+	Method string `json:"method"`
+}
+
+// Authenticator is invoked for every new connection, before the server starts serving its requests. A returned error
+// rejects the connection.
+type Authenticator interface {
+	Authenticate(conn net.Conn) (*Identity, error)
+}
+
+// TokenAuthenticator is implemented by authenticators, which support clients that present a token by the
+// "authenticate" extension method after the connection establishment. The clients, which didn't present their token
+// yet, may call the unauthenticatedMethods only.
+type TokenAuthenticator interface {
+	AuthenticateToken(ctx context.Context, token string) (*Identity, error)
+}
+
+// unauthenticatedMethods are served before the client of a TokenAuthenticator presented its token
+var unauthenticatedMethods = map[string]bool{
+	"authenticate": true,
+	"echo":         true,
+	"get_schema":   true,
+	"list_dbs":     true,
+}
+
+// AuthenticatorFactory creates an authenticator, which assigns the given role to the authenticated clients.
+type AuthenticatorFactory func(role string) (Authenticator, error)
+
+var (
+	authMu        sync.Mutex
+	authFactories = map[string]AuthenticatorFactory{
+		AUTH_METHOD_NONE:      func(role string) (Authenticator, error) { return &AnonymousAuthenticator{Role: role}, nil },
+		AUTH_METHOD_UNIX_PEER: func(role string) (Authenticator, error) { return &UnixPeerAuthenticator{Role: role}, nil },
+		AUTH_METHOD_TLS:       func(role string) (Authenticator, error) { return &TLSAuthenticator{Role: role}, nil },
+		AUTH_METHOD_TOKEN:     func(role string) (Authenticator, error) { return &StaticTokenAuthenticator{Role: role}, nil },
+	}
+)
+
+// RegisterAuthenticator makes an authentication method available by its name, new methods are added without
+// changing the server connection handling.
+func RegisterAuthenticator(method string, factory AuthenticatorFactory) {
+	authMu.Lock()
+	defer authMu.Unlock()
+	authFactories[method] = factory
+}
+
+func NewAuthenticator(method string, role string) (Authenticator, error) {
+	authMu.Lock()
+	factory, ok := authFactories[method]
+	authMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown authentication method %q, supported methods %v", method, AuthMethods())
+	}
+	return factory(role)
+}
+
+// AuthMethods returns the names of the registered authentication methods
+func AuthMethods() []string {
+	authMu.Lock()
+	defer authMu.Unlock()
+	methods := make([]string, 0, len(authFactories))
+	for method := range authFactories {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// AnonymousAuthenticator accepts all the connections
+type AnonymousAuthenticator struct {
+	Role string
+}
+
+func (a *AnonymousAuthenticator) Authenticate(conn net.Conn) (*Identity, error) {
+	return &Identity{Name: ANONYMOUS_IDENTITY, Role: a.Role, Method: AUTH_METHOD_NONE}, nil
+}
+
+// UnixPeerAuthenticator identifies unix socket clients by the credentials of the peer process, connections from
+// other networks are accepted as anonymous.
+type UnixPeerAuthenticator struct {
+	Role string
+}
+
+func (a *UnixPeerAuthenticator) Authenticate(conn net.Conn) (*Identity, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return &Identity{Name: ANONYMOUS_IDENTITY, Role: a.Role, Method: AUTH_METHOD_NONE}, nil
+	}
+	name, err := unixPeerName(unixConn)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{Name: name, Role: a.Role, Method: AUTH_METHOD_UNIX_PEER}, nil
+}
+
+// TLSAuthenticator identifies clients by the common name of their certificate, non TLS connections are rejected.
+// If the allowed names are set, the clients with other common names are rejected too.
+type TLSAuthenticator struct {
+	Role         string
+	AllowedNames []string
+}
+
+func (a *TLSAuthenticator) Authenticate(conn net.Conn) (*Identity, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, fmt.Errorf("not a TLS connection from %s", conn.RemoteAddr())
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no client certificate from %s", conn.RemoteAddr())
+	}
+	name := certs[0].Subject.CommonName
+	if !a.allowed(name) {
+		return nil, fmt.Errorf("client certificate %q from %s is not allowed", name, conn.RemoteAddr())
+	}
+	return &Identity{Name: name, Role: a.Role, Method: AUTH_METHOD_TLS}, nil
+}
+
+func (a *TLSAuthenticator) allowed(name string) bool {
+	if len(a.AllowedNames) == 0 {
+		return true
+	}
+	for _, allowed := range a.AllowedNames {
+		if name == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// StaticTokenAuthenticator accepts the connections as anonymous, the clients identify themselves by presenting one of
+// the configured tokens by the "authenticate" method, and are served the unauthenticatedMethods only till then.
+type StaticTokenAuthenticator struct {
+	Role string
+	// client name -> token
+	Tokens map[string]string
+}
+
+func (a *StaticTokenAuthenticator) Authenticate(conn net.Conn) (*Identity, error) {
+	return &Identity{Name: ANONYMOUS_IDENTITY, Method: AUTH_METHOD_NONE}, nil
+}
+
+func (a *StaticTokenAuthenticator) AuthenticateToken(ctx context.Context, token string) (*Identity, error) {
+	for name, clientToken := range a.Tokens {
+		if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(clientToken)) == 1 {
+			return &Identity{Name: name, Role: a.Role, Method: AUTH_METHOD_TOKEN}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown token")
+}
+
+// LoadTokens reads the tokens of the clients from a JSON file of a {"<client-name>": "<token>"} object
+func LoadTokens(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tokens := map[string]string{}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("tokens file %s: %v", path, err)
+	}
+	return tokens, nil
+}
+
+// adminMethods are served to the authenticated clients of the ADMIN_ROLE only
+var adminMethods = map[string]bool{
+	"quarantine":            true,
+	"repair":                true,
+	"cancel_monitor":        true,
+	"resync":                true,
+	"dump":                  true,
+	"freeze":                true,
+	"read_only":             true,
+	"list_connections":      true,
+	"client_last_delivered": true,
+	"commit_log":            true,
+	"storage_stats":         true,
+	"list_locks":            true,
+}
+
+// authenticated returns true if the identity was established by an authentication method, and not assigned to an
+// anonymous client
+func (identity *Identity) authenticated() bool {
+	return identity != nil && identity.Method != AUTH_METHOD_NONE
+}
+
+// authorizedAssigner rejects the requests of the methods, which the client of the handler is not allowed to call
+type authorizedAssigner struct {
+	assigner jrpc2.Assigner
+	ch       *Handler
+}
+
+// Assign implements the jrpc2.Assigner interface
+func (a *authorizedAssigner) Assign(ctx context.Context, method string) jrpc2.Handler {
+	h := a.assigner.Assign(ctx, method)
+	if h == nil {
+		return nil
+	}
+	return &authorizedHandler{handler: h, method: method, ch: a.ch}
+}
+
+// Names implements the jrpc2.Assigner interface
+func (a *authorizedAssigner) Names() []string {
+	return a.assigner.Names()
+}
+
+type authorizedHandler struct {
+	handler jrpc2.Handler
+	method  string
+	ch      *Handler
+}
+
+func (ah *authorizedHandler) Handle(ctx context.Context, req *jrpc2.Request) (interface{}, error) {
+	if err := ah.ch.authorizeMethod(ah.method); err != nil {
+		return nil, err
+	}
+	return ah.handler.Handle(ctx, req)
+}
+
+// logValues returns the fields, which attribute the log messages to the client
+func (identity *Identity) logValues() []interface{} {
+	values := []interface{}{"identity", identity.Name, "auth-method", identity.Method}
+	if identity.Role != "" {
+		values = append(values, "role", identity.Role)
+	}
+	return values
+}
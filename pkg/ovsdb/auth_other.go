@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package ovsdb
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func unixPeerName(conn *net.UnixConn) (string, error) {
+	return "", fmt.Errorf("unix peer credentials are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,224 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+)
+
+type testTokenAuthenticator struct {
+	AnonymousAuthenticator
+}
+
+func (a *testTokenAuthenticator) AuthenticateToken(ctx context.Context, token string) (*Identity, error) {
+	if token != "secret" {
+		return nil, fmt.Errorf("wrong token")
+	}
+	return &Identity{Name: "operator", Role: a.Role, Method: AUTH_METHOD_TOKEN}, nil
+}
+
+func TestAuthenticatorRegistry(t *testing.T) {
+	_, err := NewAuthenticator("unknown", "")
+	assert.NotNil(t, err)
+
+	RegisterAuthenticator(AUTH_METHOD_TOKEN, func(role string) (Authenticator, error) {
+		return &testTokenAuthenticator{AnonymousAuthenticator{Role: role}}, nil
+	})
+	assert.Contains(t, AuthMethods(), AUTH_METHOD_TOKEN)
+	auth, err := NewAuthenticator(AUTH_METHOD_TOKEN, "admin")
+	assert.Nil(t, err)
+	_, ok := auth.(TokenAuthenticator)
+	assert.True(t, ok)
+
+	auth, err = NewAuthenticator(AUTH_METHOD_NONE, "reader")
+	assert.Nil(t, err)
+	identity, err := auth.Authenticate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, &Identity{Name: ANONYMOUS_IDENTITY, Role: "reader", Method: AUTH_METHOD_NONE}, identity)
+}
+
+func TestTLSAuthenticatorRejectsPlainConnection(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	auth := &TLSAuthenticator{}
+	_, err := auth.Authenticate(c1)
+	assert.NotNil(t, err)
+}
+
+func TestUnixPeerAuthenticator(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are supported on linux only")
+	}
+	dir, err := ioutil.TempDir("", "ovsdb-auth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	lst, err := net.Listen("unix", filepath.Join(dir, "auth.sock"))
+	assert.Nil(t, err)
+	defer lst.Close()
+	go func() {
+		conn, err := net.Dial("unix", lst.Addr().String())
+		if err == nil {
+			defer conn.Close()
+			conn.Read(make([]byte, 1))
+		}
+	}()
+	conn, err := lst.Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	auth := &UnixPeerAuthenticator{Role: "local"}
+	identity, err := auth.Authenticate(conn)
+	assert.Nil(t, err)
+	current, err := user.Current()
+	assert.Nil(t, err)
+	assert.Equal(t, &Identity{Name: current.Username, Role: "local", Method: AUTH_METHOD_UNIX_PEER}, identity)
+
+	// non unix connections are anonymous
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	identity, err = auth.Authenticate(c1)
+	assert.Nil(t, err)
+	assert.Equal(t, ANONYMOUS_IDENTITY, identity.Name)
+}
+
+func TestHandlerAuthenticate(t *testing.T) {
+	handler := NewHandler(context.Background(), &DatabaseMock{}, nil, klogr.New())
+	ctx := context.Background()
+	_, err := handler.Authenticate(ctx, []interface{}{"secret"})
+	assert.NotNil(t, err)
+
+	auth := &testTokenAuthenticator{AnonymousAuthenticator{Role: "admin"}}
+	identity, _ := auth.Authenticate(nil)
+	handler.SetIdentity(identity, auth)
+	assert.Equal(t, ANONYMOUS_IDENTITY, handler.GetIdentity().Name)
+	// the anonymous client may authenticate and discover the databases only
+	for _, method := range []string{"authenticate", "echo", "get_schema", "list_dbs"} {
+		assert.Nil(t, handler.authorizeMethod(method), method)
+	}
+	for _, method := range []string{"transact", "monitor_cond", "lock", "get_server_info"} {
+		assert.NotNil(t, handler.authorizeMethod(method), method)
+	}
+
+	_, err = handler.Authenticate(ctx, []interface{}{"wrong"})
+	assert.NotNil(t, err)
+	assert.Equal(t, ANONYMOUS_IDENTITY, handler.GetIdentity().Name)
+	resp, err := handler.Authenticate(ctx, []interface{}{"secret"})
+	assert.Nil(t, err)
+	assert.Equal(t, &Identity{Name: "operator", Role: "admin", Method: AUTH_METHOD_TOKEN}, resp)
+	assert.Equal(t, "operator", handler.GetIdentity().Name)
+	assert.Nil(t, handler.authorizeMethod("transact"))
+
+	// the clients of authenticators without tokens are served as before
+	anonymous := NewHandler(context.Background(), &DatabaseMock{}, nil, klogr.New())
+	identity, _ = (&AnonymousAuthenticator{}).Authenticate(nil)
+	anonymous.SetIdentity(identity, &AnonymousAuthenticator{})
+	assert.Nil(t, anonymous.authorizeMethod("transact"))
+}
+
+// testValuesLogger records the values of the logger
+type testValuesLogger struct {
+	values []interface{}
+}
+
+func (l *testValuesLogger) Enabled() bool                                             { return false }
+func (l *testValuesLogger) Info(msg string, keysAndValues ...interface{})             {}
+func (l *testValuesLogger) Error(err error, msg string, keysAndValues ...interface{}) {}
+func (l *testValuesLogger) V(level int) logr.Logger                                   { return l }
+func (l *testValuesLogger) WithName(name string) logr.Logger                          { return l }
+
+func (l *testValuesLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	values := append(append([]interface{}{}, l.values...), keysAndValues...)
+	return &testValuesLogger{values: values}
+}
+
+func TestHandlerAuthenticateLogger(t *testing.T) {
+	handler := NewHandler(context.Background(), &DatabaseMock{}, nil, &testValuesLogger{})
+	auth := &testTokenAuthenticator{AnonymousAuthenticator{Role: "admin"}}
+	identity, _ := auth.Authenticate(nil)
+	handler.SetIdentity(identity, auth)
+
+	// the identity is replaced while the requests are logged
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			handler.logger().Info("request")
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		_, err := handler.Authenticate(context.Background(), []interface{}{"secret"})
+		assert.Nil(t, err)
+	}
+	<-done
+	values := handler.logger().(*testValuesLogger).values
+	assert.Equal(t, []interface{}{"identity", "operator", "auth-method", AUTH_METHOD_TOKEN, "role", "admin"}, values[2:])
+}
+
+func TestHandlerAdminMethods(t *testing.T) {
+	for _, test := range []struct {
+		identity *Identity
+		allowed  bool
+	}{
+		{nil, false},
+		// the role of the anonymous clients is not trusted
+		{&Identity{Name: ANONYMOUS_IDENTITY, Role: ADMIN_ROLE, Method: AUTH_METHOD_NONE}, false},
+		{&Identity{Name: "chassis-1", Role: "reader", Method: AUTH_METHOD_TLS}, false},
+		{&Identity{Name: "operator", Role: ADMIN_ROLE, Method: AUTH_METHOD_TLS}, true},
+	} {
+		handler := NewHandler(context.Background(), &DatabaseMock{}, nil, klogr.New())
+		handler.SetIdentity(test.identity, &AnonymousAuthenticator{})
+		for _, method := range []string{"quarantine", "repair", "cancel_monitor", "resync", "dump", "freeze", "read_only",
+			"list_connections", "client_last_delivered", "commit_log", "storage_stats", "list_locks"} {
+			err := handler.authorizeMethod(method)
+			assert.Equal(t, test.allowed, err == nil, "%s %v", method, test.identity)
+			if err != nil {
+				assert.Contains(t, err.Error(), E_PERMISSION_ERROR)
+			}
+		}
+		assert.Nil(t, handler.authorizeMethod("transact"))
+	}
+}
+
+func TestTLSAuthenticatorAllowedNames(t *testing.T) {
+	auth := &TLSAuthenticator{}
+	assert.True(t, auth.allowed("chassis-1"))
+	auth.AllowedNames = []string{"chassis-1", "chassis-2"}
+	assert.True(t, auth.allowed("chassis-2"))
+	assert.False(t, auth.allowed("chassis-3"))
+}
+
+func TestStaticTokenAuthenticator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"operator": "secret", "monitor": "other"}`), 0600))
+	tokens, err := LoadTokens(path)
+	assert.Nil(t, err)
+	auth := &StaticTokenAuthenticator{Role: "admin", Tokens: tokens}
+
+	// the clients are anonymous, without the role, till they present a token
+	identity, err := auth.Authenticate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, &Identity{Name: ANONYMOUS_IDENTITY, Method: AUTH_METHOD_NONE}, identity)
+	identity, err = auth.AuthenticateToken(context.Background(), "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, &Identity{Name: "operator", Role: "admin", Method: AUTH_METHOD_TOKEN}, identity)
+	_, err = auth.AuthenticateToken(context.Background(), "wrong")
+	assert.NotNil(t, err)
+	_, err = auth.AuthenticateToken(context.Background(), "")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`["secret"]`), 0600))
+	_, err = LoadTokens(path)
+	assert.NotNil(t, err)
+}
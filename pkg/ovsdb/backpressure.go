@@ -0,0 +1,207 @@
+package ovsdb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// The policies of a monitor, whose notifications queue is full, since its client does not read the notifications
+// fast enough.
+const (
+	// the monitor waits till the notifier takes the queued notifications
+	SLOW_CLIENT_BLOCK = "block"
+	// the new updates are coalesced with the last queued updates per row, so the client receives only the last state
+	// of every row, which was changed meanwhile
+	SLOW_CLIENT_COALESCE = "coalesce"
+	// the connection of the client is closed, if its queue stays full for the SlowClientTimeout
+	SLOW_CLIENT_DISCONNECT = "disconnect"
+)
+
+// counter of the connections closed by the disconnect policy
+const METRIC_SLOW_CLIENTS_DISCONNECTED = "monitor.slow_clients.disconnected"
+
+// MonitorQueueSize is the number of the notifications of a monitor, which can wait for its notifier. The notifier
+// waits for the connection writer, so the queue is filled after the writer queue is full.
+var MonitorQueueSize = 64
+
+// SlowClientPolicy is applied to the notifications of a monitor, whose queue is full.
+var SlowClientPolicy = SLOW_CLIENT_BLOCK
+
+// SlowClientTimeout is how long the notifications of a monitor wait for its full queue, before the client is
+// disconnected by the SLOW_CLIENT_DISCONNECT policy.
+var SlowClientTimeout = 10 * time.Second
+
+var errSlowClient = errors.New("the client does not read its notifications")
+
+// IsSlowClientPolicy returns true if the policy is known
+func IsSlowClientPolicy(policy string) bool {
+	return policy == SLOW_CLIENT_BLOCK || policy == SLOW_CLIENT_COALESCE || policy == SLOW_CLIENT_DISCONNECT
+}
+
+// notificationQueue holds the notifications of a monitor, till its notifier takes them. The queue is bounded by the
+// MonitorQueueSize, and a full queue is handled by the SlowClientPolicy, so a slow client holds up only its own
+// monitors.
+type notificationQueue struct {
+	mu     sync.Mutex
+	events []notificationEvent
+	// receives a value when events are added to the queue
+	ready chan struct{}
+	// receives a value when events are taken from the queue
+	space chan struct{}
+	// the notifier took an event, and did not pass it to the connection writer yet
+	sending bool
+	// closed when the queue is empty, and the notifier passed all the events to the connection writer
+	drained []chan struct{}
+	// the number of the events, which were written to the client
+	written uint64
+}
+
+func newNotificationQueue() *notificationQueue {
+	return &notificationQueue{ready: make(chan struct{}, 1), space: make(chan struct{}, 1)}
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// push adds the event to the queue. When the queue is full, the event is coalesced with the last queued event by the
+// coalesce policy, if the coalesce function succeeds, otherwise push waits for the notifier. By the disconnect policy
+// the wait is limited by the SlowClientTimeout, and errSlowClient is returned when it expires.
+func (q *notificationQueue) push(ctx context.Context, event notificationEvent, coalesce func(last, event *notificationEvent) bool) error {
+	var timeout <-chan time.Time
+	for {
+		q.mu.Lock()
+		if len(q.events) < MonitorQueueSize || len(q.events) == 0 {
+			q.events = append(q.events, event)
+			q.mu.Unlock()
+			signal(q.ready)
+			return nil
+		}
+		if SlowClientPolicy == SLOW_CLIENT_COALESCE && coalesce != nil {
+			last := &q.events[len(q.events)-1]
+			if coalesce(last, &event) {
+				var dropped *notificationEvent
+				if len(last.updates) == 0 {
+					// the changes of the rows canceled each other
+					dropped = &notificationEvent{wgs: last.wgs}
+					q.events[len(q.events)-1] = notificationEvent{}
+					q.events = q.events[:len(q.events)-1]
+				}
+				q.mu.Unlock()
+				if dropped != nil {
+					dropped.done()
+				}
+				return nil
+			}
+		}
+		q.mu.Unlock()
+		if SlowClientPolicy == SLOW_CLIENT_DISCONNECT && timeout == nil {
+			timer := time.NewTimer(SlowClientTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-q.space:
+		case <-timeout:
+			return errSlowClient
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// pop takes the first event of the queue, it returns false if the queue is empty
+func (q *notificationQueue) pop() (notificationEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.events) == 0 {
+		return notificationEvent{}, false
+	}
+	event := q.events[0]
+	q.events[0] = notificationEvent{}
+	q.events = q.events[1:]
+	if len(q.events) == 0 {
+		q.events = nil
+	}
+	q.sending = true
+	signal(q.space)
+	return event, true
+}
+
+// sent is called by the notifier, after it passed the event, which it took last, to the connection writer
+func (q *notificationQueue) sent() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sending = false
+	if len(q.events) == 0 {
+		for _, drained := range q.drained {
+			close(drained)
+		}
+		q.drained = nil
+	}
+}
+
+// drain waits till the notifier passes all the queued events to the connection writer
+func (q *notificationQueue) drain(ctx context.Context) error {
+	q.mu.Lock()
+	if len(q.events) == 0 && !q.sending {
+		q.mu.Unlock()
+		return nil
+	}
+	drained := make(chan struct{})
+	q.drained = append(q.drained, drained)
+	q.mu.Unlock()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// next waits for the first event of the queue, it returns false if the context is done
+func (q *notificationQueue) next(ctx context.Context) (notificationEvent, bool) {
+	for {
+		if event, ok := q.pop(); ok {
+			return event, true
+		}
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return notificationEvent{}, false
+		}
+	}
+}
+
+// size returns the number of the queued events
+func (q *notificationQueue) size() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.events)
+}
+
+// countWritten is called after an event of the queue is written to the client
+func (q *notificationQueue) countWritten() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.written++
+}
+
+// stats returns the number of the written events, and the number of the queued ones
+func (q *notificationQueue) stats() (uint64, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.written, len(q.events)
+}
+
+// done releases the waiters for the event, after it is sent or dropped
+func (e *notificationEvent) done() {
+	for _, wg := range e.wgs {
+		wg.Done()
+	}
+}
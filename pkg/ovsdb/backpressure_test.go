@@ -0,0 +1,161 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+type stopRecorder struct {
+	stopped chan struct{}
+}
+
+func (s *stopRecorder) Wait() error {
+	return nil
+}
+
+func (s *stopRecorder) Stop() {
+	close(s.stopped)
+}
+
+func (s *stopRecorder) Notify(ctx context.Context, method string, params interface{}) error {
+	return nil
+}
+
+func testSlowClientPolicy(t *testing.T, policy string, size int) {
+	queueSize, slowClientPolicy := MonitorQueueSize, SlowClientPolicy
+	MonitorQueueSize, SlowClientPolicy = size, policy
+	t.Cleanup(func() {
+		MonitorQueueSize, SlowClientPolicy = queueSize, slowClientPolicy
+	})
+}
+
+func testBackpressureHandler(t *testing.T) (*Handler, handlerMonitorData) {
+	columns := map[string]*libovsdb.ColumnSchema{"c1": {Type: libovsdb.TypeString}}
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {Columns: columns}},
+	}}
+	handler := initHandler(t, schemas, `["dbName", "monid", {"T1": [{"columns": ["c1"]}]}]`, ovsjson.Update2)
+	return handler, handler.handlerMonitorData[jsonValueToString("monid")]
+}
+
+func testBackpressureKv(t *testing.T, uuid string, c1 string, created, modified int64) *mvccpb.KeyValue {
+	value, err := json.Marshal(map[string]interface{}{"c1": c1, COL_UUID: libovsdb.UUID{GoUUID: uuid}})
+	assert.Nil(t, err)
+	return &mvccpb.KeyValue{Key: []byte("ovsdb/nb/dbName/T1/" + uuid), Value: value, CreateRevision: created,
+		ModRevision: modified}
+}
+
+func TestNotificationQueueCoalesce(t *testing.T) {
+	testSlowClientPolicy(t, SLOW_CLIENT_COALESCE, 1)
+	handler, hmd := testBackpressureHandler(t)
+	monitor := handler.monitors[DB_NAME]
+
+	var wg sync.WaitGroup
+	wg.Add(5)
+	// u1 is created, the queue is full
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.PUT, Kv: testBackpressureKv(t, "u1", "a", 2, 2)}}, 2, &wg)
+	// u1 is modified, u2 is created and deleted, u3 is modified
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.PUT, Kv: testBackpressureKv(t, "u1", "b", 2, 3),
+		PrevKv: testBackpressureKv(t, "u1", "a", 2, 2)}}, 3, &wg)
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.PUT, Kv: testBackpressureKv(t, "u2", "c", 4, 4)}}, 4, &wg)
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: []byte("ovsdb/nb/dbName/T1/u2"), ModRevision: 5},
+		PrevKv: testBackpressureKv(t, "u2", "c", 4, 4)}}, 5, &wg)
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.PUT, Kv: testBackpressureKv(t, "u3", "e", 1, 6),
+		PrevKv: testBackpressureKv(t, "u3", "d", 1, 1)}}, 6, &wg)
+	assert.Equal(t, 1, hmd.notifications.size())
+
+	event, ok := hmd.notifications.pop()
+	assert.True(t, ok)
+	assert.True(t, event.coalesced)
+	assert.Equal(t, int64(6), event.revision)
+	assert.Len(t, event.wgs, 5)
+	b := map[string]interface{}{"c1": "b"}
+	e := map[string]interface{}{"c1": "e"}
+	assert.Equal(t, ovsjson.TableUpdates{"T1": {"u1": {Insert: &b}, "u3": {Modify: &e}}}, event.updates)
+	event.done()
+	wg.Wait()
+}
+
+func TestNotificationQueueCoalesceCanceled(t *testing.T) {
+	testSlowClientPolicy(t, SLOW_CLIENT_COALESCE, 2)
+	handler, hmd := testBackpressureHandler(t)
+	monitor := handler.monitors[DB_NAME]
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.PUT, Kv: testBackpressureKv(t, "u1", "a", 2, 2)}}, 2, &wg)
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.PUT, Kv: testBackpressureKv(t, "u2", "b", 3, 3)}}, 3, &wg)
+	// the deletion cancels the queued creation of u2, so its event is dropped
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: []byte("ovsdb/nb/dbName/T1/u2"), ModRevision: 4},
+		PrevKv: testBackpressureKv(t, "u2", "b", 3, 3)}}, 4, &wg)
+	assert.Equal(t, 1, hmd.notifications.size())
+	event, ok := hmd.notifications.pop()
+	assert.True(t, ok)
+	assert.False(t, event.coalesced)
+	assert.Equal(t, int64(2), event.revision)
+	event.done()
+	wg.Wait()
+}
+
+func TestNotificationQueueBlock(t *testing.T) {
+	testSlowClientPolicy(t, SLOW_CLIENT_BLOCK, 1)
+	handler, hmd := testBackpressureHandler(t)
+	monitor := handler.monitors[DB_NAME]
+
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.PUT, Kv: testBackpressureKv(t, "u1", "a", 2, 2)}}, 2, nil)
+	notified := make(chan struct{})
+	go func() {
+		monitor.notify([]*clientv3.Event{{Type: mvccpb.PUT, Kv: testBackpressureKv(t, "u2", "b", 3, 3)}}, 3, nil)
+		close(notified)
+	}()
+	select {
+	case <-notified:
+		assert.Fail(t, "the notification was queued to a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	for _, revision := range []int64{2, 3} {
+		event, ok := hmd.notifications.next(context.Background())
+		assert.True(t, ok)
+		assert.Equal(t, revision, event.revision)
+		assert.False(t, event.coalesced)
+	}
+	<-notified
+}
+
+func TestNotificationQueueDisconnect(t *testing.T) {
+	testSlowClientPolicy(t, SLOW_CLIENT_DISCONNECT, 1)
+	timeout := SlowClientTimeout
+	SlowClientTimeout = 20 * time.Millisecond
+	defer func() {
+		SlowClientTimeout = timeout
+	}()
+	handler, hmd := testBackpressureHandler(t)
+	server := &stopRecorder{stopped: make(chan struct{})}
+	handler.jrpcServer = server
+	monitor := handler.monitors[DB_NAME]
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.PUT, Kv: testBackpressureKv(t, "u1", "a", 2, 2)}}, 2, &wg)
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.PUT, Kv: testBackpressureKv(t, "u2", "b", 3, 3)}}, 3, &wg)
+	select {
+	case <-server.stopped:
+	case <-time.After(time.Second):
+		assert.Fail(t, "the slow client was not disconnected")
+	}
+	assert.Equal(t, 1, hmd.notifications.size())
+	event, _ := hmd.notifications.pop()
+	event.done()
+	wg.Wait()
+}
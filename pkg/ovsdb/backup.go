@@ -0,0 +1,310 @@
+package ovsdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+const (
+	// the record header magic of the ovsdb-server standalone database files
+	DB_FILE_MAGIC = "OVSDB JSON"
+	// the record header magic of the ovsdb-server clustered database files
+	DB_FILE_CLUSTER_MAGIC = "OVSDB CLUSTER"
+	// marks the transaction records, whose columns are the differences from the previous values
+	DB_FILE_IS_DIFF = "_is_diff"
+)
+
+// BackupDatabase writes all the rows of the database, which are read at a single etcd revision, to the writer in the
+// standalone database file format of ovsdb-tool: a record of the schema, followed by a record of the rows. Returns the
+// etcd revision of the rows.
+func BackupDatabase(ctx context.Context, cli *clientv3.Client, schemaData []byte, w io.Writer) (int64, error) {
+	dbSchema := &libovsdb.DatabaseSchema{}
+	if err := json.Unmarshal(schemaData, dbSchema); err != nil {
+		return 0, fmt.Errorf("wrong schema: %v", err)
+	}
+	tables := make([]string, 0, len(dbSchema.Tables))
+	for table := range dbSchema.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var revision int64
+	data := map[string]interface{}{}
+	for _, table := range tables {
+		opts := []clientv3.OpOption{clientv3.WithPrefix()}
+		if revision != 0 {
+			opts = append(opts, clientv3.WithRev(revision))
+		}
+		resp, err := cli.Get(ctx, common.NewTableKey(dbSchema.Name, table).String(), opts...)
+		if err != nil {
+			return 0, err
+		}
+		revision = resp.Header.Revision
+		if len(resp.Kvs) == 0 {
+			continue
+		}
+		rows := map[string]interface{}{}
+		for _, kv := range resp.Kvs {
+			row, err := unmarshalData(kv.Value)
+			if err != nil {
+				return 0, fmt.Errorf("key %s: %v", string(kv.Key), err)
+			}
+			uuid, err := getAndDeleteUUID(row)
+			if err != nil {
+				return 0, fmt.Errorf("key %s: %v", string(kv.Key), err)
+			}
+			delete(row, COL_VERSION)
+			rows[uuid] = row
+		}
+		data[table] = rows
+	}
+	data["_date"] = time.Now().UnixNano() / int64(time.Millisecond)
+	data["_comment"] = fmt.Sprintf("ovsdb-etcd backup at etcd revision %d", revision)
+
+	var schema bytes.Buffer
+	if err := json.Compact(&schema, schemaData); err != nil {
+		return 0, err
+	}
+	if err := writeDBRecord(w, schema.Bytes()); err != nil {
+		return 0, err
+	}
+	record, err := json.Marshal(data)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeDBRecord(w, record); err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+// RestoreDatabase loads the rows of a standalone database file, and the entries of the table indexes, into the database
+// of the file schema, and returns the number of the restored rows. The database should be empty, unless force is set,
+// then its rows are replaced. The rows are written by several etcd transactions, so the servers of the database should
+// be stopped, or the database frozen, during the restore.
+func RestoreDatabase(ctx context.Context, cli *clientv3.Client, r io.Reader, force bool) (int, error) {
+	dbSchema, rows, err := readDBFile(r)
+	if err != nil {
+		return 0, err
+	}
+	return restoreRows(ctx, cli, dbSchema, rows, force)
+}
+
+// restoreRows writes the rows of the tables of the database schema, the uuid -> row maps by the table names
+func restoreRows(ctx context.Context, cli *clientv3.Client, dbSchema *libovsdb.DatabaseSchema,
+	rows map[string]map[string]map[string]interface{}, force bool) (int, error) {
+	tables := make([]string, 0, len(dbSchema.Tables))
+	for table := range dbSchema.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	// etcd refuses the puts of the keys of a range deleted by the same transaction, so the stored rows are deleted first
+	deletes := []clientv3.Op{}
+	for _, table := range tables {
+		key := common.NewTableKey(dbSchema.Name, table).String()
+		if !force {
+			resp, err := cli.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithCountOnly())
+			if err != nil {
+				return 0, err
+			}
+			if resp.Count != 0 {
+				return 0, fmt.Errorf("database %s is not empty, table %s has %d rows", dbSchema.Name, table, resp.Count)
+			}
+		}
+		deletes = append(deletes, clientv3.OpDelete(key, clientv3.WithPrefix()),
+			clientv3.OpDelete(common.NewIndexTablePrefix(dbSchema.Name, table), clientv3.WithPrefix()))
+	}
+	puts := []clientv3.Op{}
+	// index key -> uuid of the row
+	indexes := map[string]string{}
+	restored := 0
+	for _, table := range tables {
+		tableSchema := dbSchema.Tables[table]
+		uuids := make([]string, 0, len(rows[table]))
+		for uuid := range rows[table] {
+			uuids = append(uuids, uuid)
+		}
+		sort.Strings(uuids)
+		for _, uuid := range uuids {
+			row := rows[table][uuid]
+			if err := tableSchema.Unmarshal(&row); err != nil {
+				return 0, fmt.Errorf("table %s row %s: %v", table, uuid, err)
+			}
+			tableSchema.Default(&row)
+			setRowUUID(&row, uuid)
+			setRowVersion(&row)
+			value, err := makeValue(&row)
+			if err != nil {
+				return 0, err
+			}
+			puts = append(puts, clientv3.OpPut(common.NewDataKey(dbSchema.Name, table, uuid).String(), value))
+			keys, err := indexKeys(dbSchema.Name, table, tableSchema.Indexes, []byte(value))
+			if err != nil {
+				return 0, err
+			}
+			for indexKey, columns := range keys {
+				if other, ok := indexes[indexKey]; ok {
+					return 0, fmt.Errorf("%s: table %s columns %v rows %s and %s", E_CONSTRAINT_VIOLATION, table, columns,
+						other, uuid)
+				}
+				indexes[indexKey] = uuid
+				puts = append(puts, clientv3.OpPut(indexKey, uuid))
+			}
+			restored++
+		}
+	}
+	for _, ops := range [][]clientv3.Op{deletes, puts} {
+		for len(ops) > 0 {
+			n := len(ops)
+			if n > MigrationBatchSize {
+				n = MigrationBatchSize
+			}
+			if _, err := cli.Txn(ctx).Then(ops[:n]...).Commit(); err != nil {
+				return 0, err
+			}
+			ops = ops[n:]
+		}
+	}
+	return restored, nil
+}
+
+// readDBFile reads the schema and the rows of a standalone database file. Every data record is a transaction, whose
+// rows replace the columns of the stored rows, and a null row deletes the stored row. The columns of the "_is_diff"
+// transactions, which are written by the newer ovsdb-server versions, are the differences from the stored values.
+func readDBFile(r io.Reader) (*libovsdb.DatabaseSchema, map[string]map[string]map[string]interface{}, error) {
+	reader := bufio.NewReader(r)
+	record, err := readDBRecord(reader)
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("empty database file")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	dbSchema := &libovsdb.DatabaseSchema{}
+	if err := json.Unmarshal(record, dbSchema); err != nil {
+		return nil, nil, fmt.Errorf("wrong schema record: %v", err)
+	}
+	rows := map[string]map[string]map[string]interface{}{}
+	for {
+		record, err := readDBRecord(reader)
+		if err == io.EOF {
+			return dbSchema, rows, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		txn := map[string]json.RawMessage{}
+		if err := json.Unmarshal(record, &txn); err != nil {
+			return nil, nil, fmt.Errorf("wrong transaction record: %v", err)
+		}
+		isDiff := false
+		if value, ok := txn[DB_FILE_IS_DIFF]; ok {
+			if err := json.Unmarshal(value, &isDiff); err != nil {
+				return nil, nil, fmt.Errorf("wrong %s of a transaction record: %v", DB_FILE_IS_DIFF, err)
+			}
+		}
+		for table, data := range txn {
+			if strings.HasPrefix(table, "_") {
+				// _date, _comment, _is_diff
+				continue
+			}
+			tableSchema, err := dbSchema.LookupTable(table)
+			if err != nil {
+				return nil, nil, err
+			}
+			tableRows := map[string]map[string]interface{}{}
+			if err := json.Unmarshal(data, &tableRows); err != nil {
+				return nil, nil, fmt.Errorf("table %s: %v", table, err)
+			}
+			if rows[table] == nil {
+				rows[table] = map[string]map[string]interface{}{}
+			}
+			for uuid, row := range tableRows {
+				if row == nil {
+					delete(rows[table], uuid)
+					continue
+				}
+				stored, ok := rows[table][uuid]
+				if !ok {
+					stored = map[string]interface{}{}
+					rows[table][uuid] = stored
+				}
+				for column, value := range row {
+					if column == COL_UUID || column == COL_VERSION {
+						continue
+					}
+					columnSchema, err := tableSchema.LookupColumn(column)
+					if err != nil {
+						return nil, nil, fmt.Errorf("table %s row %s: %v", table, uuid, err)
+					}
+					if old, ok := stored[column]; ok && isDiff {
+						value = applyColumnDiff(columnSchema, old, value)
+					}
+					stored[column] = value
+				}
+			}
+		}
+	}
+}
+
+// writeDBRecord writes a record of a standalone database file: the "OVSDB JSON <length> <sha1>" header line, and the
+// JSON text followed by a new line, which are counted by the length and the sha1 of the header.
+func writeDBRecord(w io.Writer, data []byte) error {
+	content := make([]byte, 0, len(data)+1)
+	content = append(content, data...)
+	content = append(content, '\n')
+	sum := sha1.Sum(content)
+	if _, err := fmt.Fprintf(w, "%s %d %s\n", DB_FILE_MAGIC, len(content), hex.EncodeToString(sum[:])); err != nil {
+		return err
+	}
+	_, err := w.Write(content)
+	return err
+}
+
+// readDBRecord reads the next record of a standalone database file, and returns io.EOF at the end of the file
+func readDBRecord(r *bufio.Reader) ([]byte, error) {
+	header, err := r.ReadString('\n')
+	if err == io.EOF && header == "" {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("truncated record header %q", header)
+	}
+	if strings.HasPrefix(header, DB_FILE_CLUSTER_MAGIC) {
+		return nil, fmt.Errorf("clustered database files are not supported, convert the file by \"ovsdb-tool cluster-to-standalone\"")
+	}
+	fields := strings.Fields(header)
+	if len(fields) < 4 || strings.Join(fields[:len(fields)-2], " ") != DB_FILE_MAGIC {
+		return nil, fmt.Errorf("wrong record header %q, expected a %q standalone database file", strings.TrimSpace(header),
+			DB_FILE_MAGIC)
+	}
+	length, err := strconv.Atoi(fields[len(fields)-2])
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("wrong record length in header %q", strings.TrimSpace(header))
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, fmt.Errorf("truncated record of %d bytes: %v", length, err)
+	}
+	sum := sha1.Sum(content)
+	if hex.EncodeToString(sum[:]) != fields[len(fields)-1] {
+		return nil, fmt.Errorf("record checksum mismatch in header %q", strings.TrimSpace(header))
+	}
+	return content, nil
+}
@@ -0,0 +1,105 @@
+package ovsdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func testReadRows(t *testing.T, cli *clientv3.Client, dbName string) map[string]map[string]interface{} {
+	resp, err := cli.Get(context.Background(), common.NewDBPrefixKey(dbName).String(), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	rows := map[string]map[string]interface{}{}
+	for _, kv := range resp.Kvs {
+		row, err := unmarshalData(kv.Value)
+		assert.Nil(t, err)
+		delete(row, COL_VERSION)
+		rows[string(kv.Key)] = row
+	}
+	return rows
+}
+
+func TestBackupRestore(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	txn := testRBACTransact(t, nil, `[
+		{"op": "insert", "table": "RBAC_Permission", "uuid-name": "perm",
+			"row": {"table": "Chassis", "authorization": ["set", ["name"]], "update": ["set", ["hostname"]]}},
+		{"op": "insert", "table": "RBAC_Role",
+			"row": {"name": "ovn-controller", "permissions": ["map", [["Chassis", ["named-uuid", "perm"]]]]}},
+		{"op": "insert", "table": "Chassis", "row": {"name": "ch1", "external_ids": ["map", [["k", "v"]]]}}
+	]`)
+	assert.Nil(t, txn.response.Error)
+	cli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	ctx := context.Background()
+	stored := testReadRows(t, cli, "rbac")
+	assert.Len(t, stored, 3)
+
+	var buf bytes.Buffer
+	revision, err := BackupDatabase(ctx, cli, []byte(testSchemaRBACJSON), &buf)
+	assert.Nil(t, err)
+	assert.NotZero(t, revision)
+	backup := buf.String()
+	assert.True(t, strings.HasPrefix(backup, DB_FILE_MAGIC+" "))
+
+	// the database is not empty
+	_, err = RestoreDatabase(ctx, cli, strings.NewReader(backup), false)
+	assert.NotNil(t, err)
+
+	_, err = cli.Delete(ctx, common.NewDBPrefixKey("rbac").String(), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	restored, err := RestoreDatabase(ctx, cli, strings.NewReader(backup), false)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, restored)
+	assert.Equal(t, stored, testReadRows(t, cli, "rbac"))
+
+	// the rows are replaced
+	restored, err = RestoreDatabase(ctx, cli, strings.NewReader(backup), true)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, restored)
+	assert.Equal(t, stored, testReadRows(t, cli, "rbac"))
+	resp, err := cli.Get(ctx, common.NewIndexTablePrefix("rbac", "Chassis"), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), resp.Count)
+
+	// the rows violating the table indexes are not restored
+	buf.Reset()
+	assert.Nil(t, writeDBRecord(&buf, []byte(testSchemaRBACJSON)))
+	assert.Nil(t, writeDBRecord(&buf, []byte(`{"Chassis": {"u1": {"name": "ch1"}, "u2": {"name": "ch1"}}}`)))
+	_, err = RestoreDatabase(ctx, cli, &buf, true)
+	assert.NotNil(t, err)
+}
+
+func TestReadDBFile(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, writeDBRecord(&buf, []byte(testSchemaRBACJSON)))
+	assert.Nil(t, writeDBRecord(&buf, []byte(`{"Chassis": {"u1": {"name": "ch1"}, "u2": {"name": "ch2"}}, "_date": 1}`)))
+	assert.Nil(t, writeDBRecord(&buf, []byte(`{"Chassis": {"u1": {"hostname": "h1"}, "u2": null}, "_comment": "c"}`)))
+	dbSchema, rows, err := readDBFile(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, "rbac", dbSchema.Name)
+	assert.Equal(t, map[string]map[string]map[string]interface{}{
+		"Chassis": {"u1": {"name": "ch1", "hostname": "h1"}},
+	}, rows)
+
+	// the record content is verified by its checksum
+	corrupted := bytes.Replace(buf.Bytes(), []byte(`"h1"`), []byte(`"h2"`), 1)
+	_, _, err = readDBFile(bytes.NewReader(corrupted))
+	assert.NotNil(t, err)
+
+	_, err = readDBRecord(bufio.NewReader(strings.NewReader("OVSDB CLUSTER 2 0\n{}")))
+	assert.NotNil(t, err)
+	_, err = readDBRecord(bufio.NewReader(strings.NewReader("OVSDB JSON\n")))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,291 @@
+package ovsdb
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+// CoalesceWindow is how long the notifier of a monitor waits for more updates, after it takes updates from its queue.
+// The updates, which are queued meanwhile, are merged into a single notification, so a burst of changes of the same
+// rows is sent once. 0 disables the coalescing.
+var CoalesceWindow time.Duration
+
+// collectUpdates merges into the event the updates, which are queued during the CoalesceWindow. It returns the first
+// event, which cannot be merged, it should be sent after the merged one.
+func (hm *handlerMonitorData) collectUpdates(ch *Handler, event *notificationEvent) *notificationEvent {
+	if CoalesceWindow <= 0 {
+		return nil
+	}
+	dbSchema, ok := ch.db.GetSchemas()[hm.dataBaseName]
+	if !ok {
+		return nil
+	}
+	timer := time.NewTimer(CoalesceWindow)
+	defer timer.Stop()
+	for {
+		next, ok := hm.notifications.pop()
+		if !ok {
+			select {
+			case <-hm.notifications.ready:
+				continue
+			case <-timer.C:
+				return nil
+			case <-ch.handlerContext.Done():
+				return nil
+			}
+		}
+		if !mergeNotifications(event, &next, dbSchema, hm.notificationType) {
+			return &next
+		}
+		hm.log.V(5).Info("merged notifications", "revision", next.revision)
+	}
+}
+
+// mergeNotifications merges the updates of the next event into the updates of the event, so the merged updates take
+// the client from the state before the event to the state after the next one. It returns false and leaves the event
+// unchanged, if the updates of any row cannot be merged.
+func mergeNotifications(event, next *notificationEvent, dbSchema *libovsdb.DatabaseSchema, notificationType ovsjson.UpdateNotificationType) bool {
+	merged := ovsjson.TableUpdates{}
+	for table, tableUpdate := range event.updates {
+		merged[table] = ovsjson.TableUpdate{}
+		for uuid, rowUpdate := range tableUpdate {
+			merged[table][uuid] = rowUpdate
+		}
+	}
+	for table, tableUpdate := range next.updates {
+		tableSchema, err := dbSchema.LookupTable(table)
+		if err != nil {
+			return false
+		}
+		mergedTable, ok := merged[table]
+		if !ok {
+			mergedTable = ovsjson.TableUpdate{}
+			merged[table] = mergedTable
+		}
+		for uuid, rowUpdate := range tableUpdate {
+			prev, ok := mergedTable[uuid]
+			if !ok {
+				mergedTable[uuid] = rowUpdate
+				continue
+			}
+			// the encoded rows are merged by their columns
+			if prev.DecodeRaw() != nil || rowUpdate.DecodeRaw() != nil {
+				return false
+			}
+			var result *ovsjson.RowUpdate
+			if notificationType == ovsjson.Update {
+				result, ok = mergeRowUpdatesV1(&prev, &rowUpdate)
+			} else {
+				result, ok = mergeRowUpdates2(&prev, &rowUpdate, tableSchema)
+			}
+			if !ok {
+				return false
+			}
+			if result == nil {
+				delete(mergedTable, uuid)
+			} else {
+				mergedTable[uuid] = *result
+			}
+		}
+		if len(mergedTable) == 0 {
+			delete(merged, table)
+		}
+	}
+	event.updates = merged
+	event.revision = next.revision
+	event.txnID = next.txnID
+	event.requestKey = next.requestKey
+	event.events = nil
+	event.coalesced = true
+	event.wgs = append(event.wgs, next.wgs...)
+	return true
+}
+
+// mergeRowUpdatesV1 merges the "update" notification row updates, where "new" holds the row after the change, and "old"
+// holds the row before a deletion, or the previous values of the modified columns. A nil result means the row
+// changes canceled each other.
+func mergeRowUpdatesV1(prev, next *ovsjson.RowUpdate) (*ovsjson.RowUpdate, bool) {
+	switch {
+	case prev.New == nil:
+		// a deleted row is not changed again
+		return nil, false
+	case prev.Old == nil && next.New == nil:
+		// insert and delete
+		return nil, true
+	case prev.Old == nil:
+		// insert and modify
+		return &ovsjson.RowUpdate{New: next.New}, true
+	}
+	// the values of the columns before the previous update
+	old := map[string]interface{}{}
+	for column, value := range *next.Old {
+		old[column] = value
+	}
+	for column, value := range *prev.Old {
+		old[column] = value
+	}
+	if next.New == nil {
+		// modify and delete
+		return &ovsjson.RowUpdate{Old: &old}, true
+	}
+	for column, value := range old {
+		if reflect.DeepEqual(value, (*next.New)[column]) {
+			delete(old, column)
+		}
+	}
+	if len(old) == 0 {
+		return nil, true
+	}
+	return &ovsjson.RowUpdate{New: next.New, Old: &old}, true
+}
+
+// mergeRowUpdates2 merges the "update2" and "update3" notifications row updates, where "modify" holds the difference
+// of the modified columns: the new values of the scalar columns, the symmetric difference of the sets, and the changed
+// pairs of the maps. A nil result means the row changes canceled each other.
+func mergeRowUpdates2(prev, next *ovsjson.RowUpdate, tableSchema *libovsdb.TableSchema) (*ovsjson.RowUpdate, bool) {
+	switch {
+	case prev.Delete || next.Initial != nil || next.Insert != nil || (!next.Delete && next.Modify == nil):
+		// a deleted row is not changed again, and a row is not inserted twice
+		return nil, false
+	case next.Delete && prev.Modify != nil:
+		return &ovsjson.RowUpdate{Delete: true}, true
+	case next.Delete:
+		// insert and delete
+		return nil, true
+	case prev.Modify != nil:
+		modify, ok := mergeModify(*prev.Modify, *next.Modify, tableSchema)
+		if !ok {
+			return nil, false
+		}
+		if len(modify) == 0 {
+			return nil, true
+		}
+		return &ovsjson.RowUpdate{Modify: &modify}, true
+	}
+	// insert and modify
+	row := prev.Insert
+	if prev.Initial != nil {
+		row = prev.Initial
+	}
+	if row == nil {
+		return nil, false
+	}
+	modified, ok := applyModify(*row, *next.Modify, tableSchema)
+	if !ok {
+		return nil, false
+	}
+	if prev.Initial != nil {
+		return &ovsjson.RowUpdate{Initial: &modified}, true
+	}
+	return &ovsjson.RowUpdate{Insert: &modified}, true
+}
+
+// applyModify returns the row after the modification
+func applyModify(row, modify map[string]interface{}, tableSchema *libovsdb.TableSchema) (map[string]interface{}, bool) {
+	result := make(map[string]interface{}, len(row))
+	for column, value := range row {
+		result[column] = value
+	}
+	for column, diff := range modify {
+		columnSchema, err := tableSchema.LookupColumn(column)
+		if err != nil {
+			return nil, false
+		}
+		switch columnSchema.Type {
+		case libovsdb.TypeSet:
+			set, err := columnSchema.UnmarshalSet(row[column])
+			if err != nil {
+				return nil, false
+			}
+			diffSet, err := columnSchema.UnmarshalSet(diff)
+			if err != nil {
+				return nil, false
+			}
+			result[column] = setsDifference(set.(libovsdb.OvsSet), diffSet.(libovsdb.OvsSet))
+		case libovsdb.TypeMap:
+			m, err := columnSchema.UnmarshalMap(row[column])
+			if err != nil {
+				return nil, false
+			}
+			diffMap, err := columnSchema.UnmarshalMap(diff)
+			if err != nil {
+				return nil, false
+			}
+			modified := m.(libovsdb.OvsMap)
+			for key, value := range diffMap.(libovsdb.OvsMap).GoMap {
+				if current, ok := modified.GoMap[key]; ok && reflect.DeepEqual(current, value) {
+					delete(modified.GoMap, key)
+				} else {
+					modified.GoMap[key] = value
+				}
+			}
+			result[column] = modified
+		default:
+			result[column] = diff
+		}
+	}
+	return result, true
+}
+
+// mergeModify returns the difference of two consecutive modifications. The map pairs, which are changed to the same
+// value by both, cannot be merged, since the result depends on the value before the first modification.
+func mergeModify(prev, next map[string]interface{}, tableSchema *libovsdb.TableSchema) (map[string]interface{}, bool) {
+	result := make(map[string]interface{}, len(prev)+len(next))
+	for column, diff := range prev {
+		result[column] = diff
+	}
+	for column, diff := range next {
+		prevDiff, ok := prev[column]
+		if !ok {
+			result[column] = diff
+			continue
+		}
+		columnSchema, err := tableSchema.LookupColumn(column)
+		if err != nil {
+			return nil, false
+		}
+		switch columnSchema.Type {
+		case libovsdb.TypeSet:
+			prevSet, err := columnSchema.UnmarshalSet(prevDiff)
+			if err != nil {
+				return nil, false
+			}
+			set, err := columnSchema.UnmarshalSet(diff)
+			if err != nil {
+				return nil, false
+			}
+			merged := setsDifference(prevSet.(libovsdb.OvsSet), set.(libovsdb.OvsSet))
+			if len(merged.GoSet) == 0 {
+				delete(result, column)
+			} else {
+				result[column] = merged
+			}
+		case libovsdb.TypeMap:
+			prevMap, err := columnSchema.UnmarshalMap(prevDiff)
+			if err != nil {
+				return nil, false
+			}
+			m, err := columnSchema.UnmarshalMap(diff)
+			if err != nil {
+				return nil, false
+			}
+			merged := libovsdb.OvsMap{GoMap: map[interface{}]interface{}{}}
+			for key, value := range prevMap.(libovsdb.OvsMap).GoMap {
+				merged.GoMap[key] = value
+			}
+			for key, value := range m.(libovsdb.OvsMap).GoMap {
+				if prevValue, ok := merged.GoMap[key]; ok && reflect.DeepEqual(prevValue, value) {
+					return nil, false
+				}
+				merged.GoMap[key] = value
+			}
+			result[column] = merged
+		default:
+			result[column] = diff
+		}
+	}
+	return result, true
+}
@@ -0,0 +1,133 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func testCoalesceTableSchema() *libovsdb.TableSchema {
+	return &libovsdb.TableSchema{Columns: map[string]*libovsdb.ColumnSchema{
+		"name": {Type: libovsdb.TypeString},
+		"ports": {Type: libovsdb.TypeSet, TypeObj: &libovsdb.ColumnType{Key: &libovsdb.BaseType{Type: libovsdb.TypeString},
+			Min: 0, Max: libovsdb.Unlimited}},
+		"options": {Type: libovsdb.TypeMap, TypeObj: &libovsdb.ColumnType{Key: &libovsdb.BaseType{Type: libovsdb.TypeString},
+			Value: &libovsdb.BaseType{Type: libovsdb.TypeString}, Min: 0, Max: libovsdb.Unlimited}},
+	}}
+}
+
+func testRowUpdate(t *testing.T, data string) ovsjson.RowUpdate {
+	var rowUpdate ovsjson.RowUpdate
+	assert.Nil(t, json.Unmarshal([]byte(data), &rowUpdate))
+	return rowUpdate
+}
+
+func TestMergeRowUpdates2(t *testing.T) {
+	tableSchema := testCoalesceTableSchema()
+	for _, tc := range []struct {
+		name     string
+		prev     string
+		next     string
+		expected string
+		ok       bool
+	}{
+		{"insert and modify",
+			`{"insert": {"name": "a", "ports": ["set", ["p1", "p2"]], "options": ["map", [["k1", "v1"], ["k2", "v2"]]]}}`,
+			`{"modify": {"name": "b", "ports": ["set", ["p2", "p3"]], "options": ["map", [["k1", "v1"], ["k2", "v3"], ["k4", "v4"]]]}}`,
+			`{"insert": {"name": "b", "ports": ["set", ["p1", "p3"]], "options": ["map", [["k2", "v3"], ["k4", "v4"]]]}}`, true},
+		{"initial and modify", `{"initial": {"name": "a"}}`, `{"modify": {"name": "b"}}`, `{"initial": {"name": "b"}}`, true},
+		{"insert and delete", `{"insert": {"name": "a"}}`, `{"delete": null}`, ``, true},
+		{"modify and delete", `{"modify": {"name": "b"}}`, `{"delete": null}`, `{"delete": null}`, true},
+		{"modify and modify",
+			`{"modify": {"name": "b", "ports": ["set", ["p1", "p2"]], "options": ["map", [["k1", "v1"]]]}}`,
+			`{"modify": {"ports": "p2", "options": ["map", [["k1", "v2"], ["k3", "v3"]]]}}`,
+			`{"modify": {"name": "b", "ports": "p1", "options": ["map", [["k1", "v2"], ["k3", "v3"]]]}}`, true},
+		{"modify and revert", `{"modify": {"ports": "p1"}}`, `{"modify": {"ports": "p1"}}`, ``, true},
+		// the result depends on the value of k1 before the first modification
+		{"modify a map pair twice", `{"modify": {"options": ["map", [["k1", "v1"]]]}}`,
+			`{"modify": {"options": ["map", [["k1", "v1"]]]}}`, ``, false},
+		{"delete and insert", `{"delete": null}`, `{"insert": {"name": "a"}}`, ``, false},
+		{"insert and insert", `{"insert": {"name": "a"}}`, `{"insert": {"name": "a"}}`, ``, false},
+	} {
+		prev, next := testRowUpdate(t, tc.prev), testRowUpdate(t, tc.next)
+		result, ok := mergeRowUpdates2(&prev, &next, tableSchema)
+		assert.Equal(t, tc.ok, ok, tc.name)
+		if tc.expected == "" {
+			assert.Nil(t, result, tc.name)
+			continue
+		}
+		data, err := json.Marshal(result)
+		assert.Nil(t, err)
+		assert.JSONEq(t, tc.expected, string(data), tc.name)
+	}
+}
+
+func TestMergeRowUpdatesV1(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		prev     string
+		next     string
+		expected string
+		ok       bool
+	}{
+		{"insert and modify", `{"new": {"name": "a", "n": 1}}`, `{"new": {"name": "b", "n": 1}, "old": {"name": "a"}}`,
+			`{"new": {"name": "b", "n": 1}}`, true},
+		{"insert and delete", `{"new": {"name": "a"}}`, `{"old": {"name": "a"}}`, ``, true},
+		{"modify and modify", `{"new": {"name": "b", "n": 1}, "old": {"name": "a"}}`,
+			`{"new": {"name": "c", "n": 2}, "old": {"name": "b", "n": 1}}`,
+			`{"new": {"name": "c", "n": 2}, "old": {"name": "a", "n": 1}}`, true},
+		{"modify and revert", `{"new": {"name": "b", "n": 1}, "old": {"name": "a"}}`,
+			`{"new": {"name": "a", "n": 1}, "old": {"name": "b"}}`, ``, true},
+		{"modify and delete", `{"new": {"name": "b", "n": 1}, "old": {"name": "a"}}`, `{"old": {"name": "b", "n": 1}}`,
+			`{"old": {"name": "a", "n": 1}}`, true},
+		{"delete and insert", `{"old": {"name": "a"}}`, `{"new": {"name": "a"}}`, ``, false},
+	} {
+		prev, next := testRowUpdate(t, tc.prev), testRowUpdate(t, tc.next)
+		result, ok := mergeRowUpdatesV1(&prev, &next)
+		assert.Equal(t, tc.ok, ok, tc.name)
+		if tc.expected == "" {
+			assert.Nil(t, result, tc.name)
+			continue
+		}
+		data, err := json.Marshal(result)
+		assert.Nil(t, err)
+		assert.JSONEq(t, tc.expected, string(data), tc.name)
+	}
+}
+
+func TestNotifierCoalesceWindow(t *testing.T) {
+	window := CoalesceWindow
+	CoalesceWindow = 100 * time.Millisecond
+	defer func() {
+		CoalesceWindow = window
+	}()
+	handler := initHandler(t, testMonitorCondSchemas(), `["dbName", "monid", {"T1": [{"columns": ["name"]}]}]`, ovsjson.Update2)
+	recorder := &jrpcServerRecorder{}
+	handler.SetConnection(recorder, nil)
+	handler.startNotifier(jsonValueToString("monid"))
+	monitor := handler.monitors[DB_NAME]
+
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.PUT, Kv: testMonitorCondRow(t, "u1", "a", 1, 2)}}, 2, nil)
+	modified := testMonitorCondRow(t, "u1", "b", 1, 3)
+	modified.CreateRevision = 2
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.PUT, Kv: modified, PrevKv: testMonitorCondRow(t, "u1", "a", 1, 2)}}, 3, nil)
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.PUT, Kv: testMonitorCondRow(t, "u2", "c", 1, 4)}}, 4, nil)
+	monitor.notify([]*clientv3.Event{{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: modified.Key, ModRevision: 5},
+		PrevKv: modified}}, 5, nil)
+	assert.Nil(t, handler.FlushNotifications(context.Background()))
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	assert.Equal(t, []string{UPDATE2}, recorder.method)
+	if len(recorder.params) == 1 {
+		assert.JSONEq(t, `["monid", {"T1": {"u2": {"insert": {"name": "c"}}}}]`, string(recorder.params[0]))
+	}
+}
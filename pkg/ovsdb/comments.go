@@ -0,0 +1,70 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+// CommitComment is a comment of a committed transaction, recorded by its "comment" operation. The comments are kept
+// in the internal comments table, so the recent commits can be listed for debugging, similar to the transaction log
+// of ovsdb-server.
+type CommitComment struct {
+	DBName    string `json:"db-name"`
+	Comment   string `json:"comment"`
+	Timestamp string `json:"timestamp"`
+	// the etcd revision of the commit, it's the mod revision of the comment, so it isn't stored
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// commentKey returns the key of a comment of the database, the comments of a database share the key prefix, which is
+// returned for an empty comment id
+func commentKey(dbName, commentID string) string {
+	return common.NewCommentKey(dbName + common.KEY_DELIMETER + commentID).String()
+}
+
+// commentOp returns the etcd operation, which records the comment of the transaction
+func commentOp(dbName, comment string) (clientv3.Op, error) {
+	now := time.Now()
+	data, err := json.Marshal(CommitComment{DBName: dbName, Comment: comment, Timestamp: now.Format(time.RFC3339Nano)})
+	if err != nil {
+		return clientv3.Op{}, err
+	}
+	// the timestamp orders the keys, and the uuid distinguishes the comments of the same time
+	id := now.UTC().Format("20060102T150405.000000000") + "-" + common.GenerateUUID()
+	return clientv3.OpPut(commentKey(dbName, id), string(data)), nil
+}
+
+// GetCommitComments returns the comments of the recent commits of the database, the newest first. The comments of all
+// the databases are returned if the database name is empty, and all the comments if the limit is not positive.
+func GetCommitComments(ctx context.Context, cli *clientv3.Client, dbName string, limit int) ([]CommitComment, error) {
+	prefix := common.NewCommentTableKey().String()
+	if dbName != "" {
+		prefix = commentKey(dbName, "")
+	}
+	opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByModRevision, clientv3.SortDescend)}
+	if limit > 0 {
+		opts = append(opts, clientv3.WithLimit(int64(limit)))
+	}
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	defer cancel()
+	resp, err := cli.Get(tctx, prefix, opts...)
+	if err != nil {
+		return nil, err
+	}
+	comments := make([]CommitComment, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		comment := CommitComment{}
+		if err := json.Unmarshal(kv.Value, &comment); err != nil {
+			// a comment recorded by an older version
+			comment = CommitComment{Comment: string(kv.Value)}
+		}
+		comment.Revision = kv.ModRevision
+		comments = append(comments, comment)
+	}
+	return comments, nil
+}
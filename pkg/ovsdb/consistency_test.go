@@ -0,0 +1,191 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+// the rows of the replica of a monitoring client, uuid -> column -> value
+type testReplica map[string]map[string]interface{}
+
+// apply applies the initial data or an update2 notification to the replica, and returns the violations of the
+// notifications order, e.g. modification of a row which doesn't exist.
+func (r testReplica) apply(buf []byte) []string {
+	var updates map[string]map[string]map[string]interface{}
+	if err := json.Unmarshal(buf, &updates); err != nil {
+		return []string{err.Error()}
+	}
+	var violations []string
+	for _, rows := range updates {
+		for uuid, rowUpdate := range rows {
+			_, exists := r[uuid]
+			for kind, value := range rowUpdate {
+				columns, _ := value.(map[string]interface{})
+				switch kind {
+				case "initial", "insert":
+					if exists {
+						violations = append(violations, fmt.Sprintf("%s of an existing row %s", kind, uuid))
+					}
+					r[uuid] = columns
+				case "modify":
+					if !exists {
+						violations = append(violations, fmt.Sprintf("modify of a missing row %s", uuid))
+						r[uuid] = map[string]interface{}{}
+					}
+					for column, v := range columns {
+						r[uuid][column] = v
+					}
+				case "delete":
+					if !exists {
+						violations = append(violations, fmt.Sprintf("delete of a missing row %s", uuid))
+					}
+					delete(r, uuid)
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// testConsistencyWriter runs transactions, which insert, update and delete its own rows of simple.table1
+func testConsistencyWriter(t *testing.T, db Databaser, id int, transactions int) {
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	table := "table1"
+	random := rand.New(rand.NewSource(int64(id)))
+	var live []string
+	for i := 0; i < transactions; i++ {
+		var op libovsdb.Operation
+		n := random.Intn(3)
+		switch {
+		case len(live) == 0 || n == 0:
+			name := fmt.Sprintf("writer%d-%d", id, i)
+			row := map[string]interface{}{"key1": name, "key2": i}
+			op = libovsdb.Operation{Op: OP_INSERT, Table: &table, Row: &row}
+			live = append(live, name)
+		case n == 1:
+			name := live[random.Intn(len(live))]
+			row := map[string]interface{}{"key2": i}
+			where := []interface{}{[]interface{}{"key1", FN_EQ, name}}
+			op = libovsdb.Operation{Op: OP_UPDATE, Table: &table, Row: &row, Where: &where}
+		default:
+			k := random.Intn(len(live))
+			where := []interface{}{[]interface{}{"key1", FN_EQ, live[k]}}
+			op = libovsdb.Operation{Op: OP_DELETE, Table: &table, Where: &where}
+			live = append(live[:k], live[k+1:]...)
+		}
+		txn := NewTransaction(cli, klogr.New(), &libovsdb.Transact{DBName: "simple", Operations: []libovsdb.Operation{op}})
+		txn.schemas = db.GetSchemas()
+		db.DbLock("simple")
+		_, err := txn.Commit()
+		db.DbUnlock("simple")
+		assert.Nil(t, err)
+	}
+}
+
+type testConsistencyClient struct {
+	handler  *Handler
+	recorder *jrpcServerRecorder
+	replica  testReplica
+	applied  int
+}
+
+// sync applies the new notifications, and returns true if the replica matches the expected rows
+func (c *testConsistencyClient) sync(t *testing.T, expected testReplica) bool {
+	c.recorder.mu.Lock()
+	params := c.recorder.params[c.applied:]
+	c.applied = len(c.recorder.params)
+	c.recorder.mu.Unlock()
+	for _, buf := range params {
+		var notification []json.RawMessage
+		assert.Nil(t, json.Unmarshal(buf, &notification))
+		assert.Empty(t, c.replica.apply(notification[1]))
+	}
+	return assert.ObjectsAreEqual(expected, c.replica)
+}
+
+// TestMonitorConsistencyConcurrentWriters verifies that the initial snapshot followed by the subsequent updates
+// matches the final database state, for monitors which are registered while the database is being modified.
+func TestMonitorConsistencyConcurrentWriters(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	db, _ := NewDatabaseEtcd(cli)
+	db.(*DatabaseEtcd).Schemas.Add(testSchemaSimple)
+	db.(*DatabaseEtcd).locks["simple"] = &sync.RWMutex{}
+	// the snapshots are read in several pages
+	defer func(pageSize int) { MonitorSnapshotPageSize = pageSize }(MonitorSnapshotPageSize)
+	MonitorSnapshotPageSize = 7
+
+	const writers = 3
+	const transactions = 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			testConsistencyWriter(t, db, id, transactions)
+		}(i)
+	}
+
+	var clients []*testConsistencyClient
+	for i := 0; i < 4; i++ {
+		time.Sleep(time.Duration(rand.Intn(20)) * time.Millisecond)
+		handler := NewHandler(context.Background(), db, cli, klogr.New())
+		recorder := &jrpcServerRecorder{}
+		handler.SetConnection(recorder, nil)
+		defer handler.Cleanup()
+		var params []interface{}
+		err := json.Unmarshal([]byte(`["simple", "mon", {"table1": [{"columns": ["key1", "key2"]}]}]`), &params)
+		assert.Nil(t, err)
+		data, err := handler.MonitorCond(context.Background(), params)
+		assert.Nil(t, err)
+		initial, err := json.Marshal(data.(ovsjson.TableUpdates))
+		assert.Nil(t, err)
+		client := &testConsistencyClient{handler: handler, recorder: recorder, replica: testReplica{}}
+		assert.Empty(t, client.replica.apply(initial))
+		clients = append(clients, client)
+	}
+	wg.Wait()
+
+	table := "table1"
+	resp, _ := testTransact(t, &libovsdb.Transact{
+		DBName:     "simple",
+		Operations: []libovsdb.Operation{{Op: OP_SELECT, Table: &table, Columns: &[]string{COL_UUID, "key1", "key2"}}},
+	})
+	assert.Nil(t, resp.Error)
+	expected := testReplica{}
+	for _, row := range *resp.Result[0].Rows {
+		// the same json representation as in the notifications
+		buf, err := json.Marshal(row)
+		assert.Nil(t, err)
+		var columns map[string]interface{}
+		assert.Nil(t, json.Unmarshal(buf, &columns))
+		uuid := columns[COL_UUID].([]interface{})[1].(string)
+		delete(columns, COL_UUID)
+		expected[uuid] = columns
+	}
+
+	for i, client := range clients {
+		deadline := time.Now().Add(10 * time.Second)
+		for !client.sync(t, expected) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, expected, client.replica, "client %d", i)
+	}
+}
@@ -0,0 +1,491 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// SchemaCheckReport describes the impact of converting a served database to a new schema.
+type SchemaCheckReport struct {
+	Database   string `json:"database"`
+	OldVersion string `json:"old-version"`
+	NewVersion string `json:"new-version"`
+	// Lossless is true if no table or column will be dropped and all the existing data fits the new schema
+	Lossless bool `json:"lossless"`
+	// Compatible is true if all the existing data fits the new schema
+	Compatible     bool                `json:"compatible"`
+	DroppedTables  []string            `json:"dropped-tables,omitempty"`
+	AddedTables    []string            `json:"added-tables,omitempty"`
+	DroppedColumns map[string][]string `json:"dropped-columns,omitempty"`
+	AddedColumns   map[string][]string `json:"added-columns,omitempty"`
+	ChangedColumns map[string][]string `json:"changed-columns,omitempty"`
+	Violations     []string            `json:"violations,omitempty"`
+}
+
+// CompareSchemas returns the structural differences between the current and the new schemas of the same database.
+// The returned report doesn't take the existing data into account, see CheckSchema.
+func CompareSchemas(current, proposed *libovsdb.DatabaseSchema) (*SchemaCheckReport, error) {
+	if current.Name != proposed.Name {
+		return nil, fmt.Errorf("database name mismatch %q != %q", current.Name, proposed.Name)
+	}
+	report := &SchemaCheckReport{
+		Database:       current.Name,
+		OldVersion:     current.Version,
+		NewVersion:     proposed.Version,
+		DroppedColumns: map[string][]string{},
+		AddedColumns:   map[string][]string{},
+		ChangedColumns: map[string][]string{},
+	}
+	for tableName, oldTable := range current.Tables {
+		newTable, ok := proposed.Tables[tableName]
+		if !ok {
+			report.DroppedTables = append(report.DroppedTables, tableName)
+			continue
+		}
+		for columnName, oldColumn := range oldTable.Columns {
+			newColumn, ok := newTable.Columns[columnName]
+			if !ok {
+				report.DroppedColumns[tableName] = append(report.DroppedColumns[tableName], columnName)
+			} else if !reflect.DeepEqual(oldColumn, newColumn) {
+				report.ChangedColumns[tableName] = append(report.ChangedColumns[tableName], columnName)
+			}
+		}
+		for columnName := range newTable.Columns {
+			if _, ok := oldTable.Columns[columnName]; !ok {
+				report.AddedColumns[tableName] = append(report.AddedColumns[tableName], columnName)
+			}
+		}
+	}
+	for tableName := range proposed.Tables {
+		if _, ok := current.Tables[tableName]; !ok {
+			report.AddedTables = append(report.AddedTables, tableName)
+		}
+	}
+	report.sort()
+	report.update()
+	return report, nil
+}
+
+// CheckSchema compares the schemas and verifies that the data currently stored in etcd for the database can be
+// converted to the new schema.
+func CheckSchema(db Databaser, current, proposed *libovsdb.DatabaseSchema) (*SchemaCheckReport, error) {
+	report, err := CompareSchemas(current, proposed)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, 0, len(proposed.Tables))
+	for tableName := range proposed.Tables {
+		if _, ok := current.Tables[tableName]; ok {
+			tables = append(tables, tableName)
+		}
+	}
+	sort.Strings(tables)
+	for _, tableName := range tables {
+		tableSchema := proposed.Tables[tableName]
+		resp, err := db.GetKeyData(common.NewTableKey(proposed.Name, tableName), false)
+		if err != nil {
+			return nil, err
+		}
+		report.checkTableData(tableName, &tableSchema, resp.Kvs)
+	}
+	report.update()
+	return report, nil
+}
+
+// checkTableData verifies that the stored rows of the table fit its new schema, the violations are added to the report
+func (report *SchemaCheckReport) checkTableData(tableName string, tableSchema *libovsdb.TableSchema, kvs []*mvccpb.KeyValue) {
+	if tableSchema.MaxRows > 0 && len(kvs) > tableSchema.MaxRows {
+		report.Violations = append(report.Violations,
+			fmt.Sprintf("[table %s] %d rows exceed maxRows %d", tableName, len(kvs), tableSchema.MaxRows))
+	}
+	for _, kv := range kvs {
+		row := map[string]interface{}{}
+		if err := json.Unmarshal(kv.Value, &row); err != nil {
+			report.Violations = append(report.Violations, fmt.Sprintf("[key %s] %s", string(kv.Key), err))
+			continue
+		}
+		// columns that are going to be dropped are not validated
+		for column := range row {
+			if _, ok := tableSchema.Columns[column]; !ok && !InternalColumns.IsInternal(column) {
+				delete(row, column)
+			}
+		}
+		if err := tableSchema.Unmarshal(&row); err != nil {
+			report.Violations = append(report.Violations, fmt.Sprintf("[key %s] %s", string(kv.Key), err))
+			continue
+		}
+		if err := tableSchema.Validate(&row); err != nil {
+			report.Violations = append(report.Violations, fmt.Sprintf("[key %s] %s", string(kv.Key), err))
+		}
+	}
+}
+
+func (report *SchemaCheckReport) sort() {
+	sort.Strings(report.DroppedTables)
+	sort.Strings(report.AddedTables)
+	for _, columns := range [](map[string][]string){report.DroppedColumns, report.AddedColumns, report.ChangedColumns} {
+		for _, c := range columns {
+			sort.Strings(c)
+		}
+	}
+}
+
+func (report *SchemaCheckReport) update() {
+	report.Compatible = len(report.Violations) == 0
+	report.Lossless = report.Compatible && len(report.DroppedTables) == 0 && len(report.DroppedColumns) == 0
+}
+
+// ValidateSchema verifies that the schema is complete and that the server supports it: the tables and their columns
+// are defined, the column types are supported, and the references and the indexes are consistent. The error lists all
+// the problems of the schema.
+func ValidateSchema(schema *libovsdb.DatabaseSchema) error {
+	problems := schemaProblems(schema)
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// schemaProblems returns the problems of the schema, ordered by the table and column names
+func schemaProblems(schema *libovsdb.DatabaseSchema) []string {
+	problems := []string{}
+	if schema.Name == "" {
+		problems = append(problems, "missing schema name")
+	}
+	if schema.Version == "" {
+		problems = append(problems, fmt.Sprintf("schema %s, missing version", schema.Name))
+	}
+	if len(schema.Tables) == 0 {
+		problems = append(problems, fmt.Sprintf("schema %s, no tables", schema.Name))
+	}
+	tableNames := make([]string, 0, len(schema.Tables))
+	for tableName := range schema.Tables {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	for _, tableName := range tableNames {
+		tableSchema := schema.Tables[tableName]
+		if strings.HasPrefix(tableName, "_") {
+			problems = append(problems, fmt.Sprintf("table %s, the names starting with '_' are reserved", tableName))
+		}
+		if len(tableSchema.Columns) == 0 {
+			problems = append(problems, fmt.Sprintf("table %s, no columns", tableName))
+		}
+		if tableSchema.MaxRows < 0 {
+			problems = append(problems, fmt.Sprintf("table %s, negative maxRows %d", tableName, tableSchema.MaxRows))
+		}
+		columnNames := make([]string, 0, len(tableSchema.Columns))
+		for columnName := range tableSchema.Columns {
+			columnNames = append(columnNames, columnName)
+		}
+		sort.Strings(columnNames)
+		for _, columnName := range columnNames {
+			if strings.HasPrefix(columnName, "_") {
+				problems = append(problems, fmt.Sprintf("[table %s column %s] the names starting with '_' are reserved",
+					tableName, columnName))
+			}
+			if err := validateColumnType(schema, tableSchema.Columns[columnName]); err != nil {
+				problems = append(problems, fmt.Sprintf("[table %s column %s] %s", tableName, columnName, err))
+			}
+		}
+		for _, index := range tableSchema.Indexes {
+			if len(index) == 0 {
+				problems = append(problems, fmt.Sprintf("table %s, empty index", tableName))
+			}
+			for _, columnName := range index {
+				if _, ok := tableSchema.Columns[columnName]; !ok {
+					problems = append(problems, fmt.Sprintf("table %s, index of unknown column %s", tableName, columnName))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+func validateColumnType(schema *libovsdb.DatabaseSchema, columnSchema *libovsdb.ColumnSchema) error {
+	switch columnSchema.Type {
+	case libovsdb.TypeInteger, libovsdb.TypeReal, libovsdb.TypeBoolean, libovsdb.TypeString, libovsdb.TypeUUID,
+		libovsdb.TypeSet, libovsdb.TypeMap:
+	case libovsdb.TypeEnum:
+		if columnSchema.TypeObj == nil || columnSchema.TypeObj.Key == nil ||
+			columnSchema.TypeObj.Key.Type != libovsdb.TypeString {
+			return errors.New("unsupported enum type")
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", columnSchema.Type)
+	}
+	if columnSchema.TypeObj == nil {
+		return nil
+	}
+	typeObj := columnSchema.TypeObj
+	if typeObj.Min < 0 || typeObj.Min > 1 {
+		return fmt.Errorf("min %d, should be 0 or 1", typeObj.Min)
+	}
+	if typeObj.Max != libovsdb.Unlimited && typeObj.Max < 1 {
+		return fmt.Errorf("max %d, should be positive or \"unlimited\"", typeObj.Max)
+	}
+	if typeObj.Max != libovsdb.Unlimited && typeObj.Min > typeObj.Max {
+		return fmt.Errorf("min %d is greater than max %d", typeObj.Min, typeObj.Max)
+	}
+	for _, baseType := range []*libovsdb.BaseType{typeObj.Key, typeObj.Value} {
+		if baseType == nil {
+			continue
+		}
+		if baseType.RefType != "" && baseType.RefType != libovsdb.Strong && baseType.RefType != libovsdb.Weak {
+			return fmt.Errorf("unknown refType %s", baseType.RefType)
+		}
+		if baseType.Enum != nil {
+			for _, value := range baseType.Enum.GoSet {
+				if !isEnumValueOfType(value, baseType.Type) {
+					return fmt.Errorf("enum value %v is not of type %s", value, baseType.Type)
+				}
+			}
+		}
+		if baseType.RefTable == "" {
+			if baseType.RefType != "" {
+				return errors.New("refType without refTable")
+			}
+			continue
+		}
+		if baseType.Type != libovsdb.TypeUUID {
+			return fmt.Errorf("refTable %s of a %s type", baseType.RefTable, baseType.Type)
+		}
+		if _, ok := schema.Tables[baseType.RefTable]; !ok {
+			return fmt.Errorf("reference to unknown table %s", baseType.RefTable)
+		}
+	}
+	return nil
+}
+
+// isEnumValueOfType returns whether the enum value, as it's decoded from JSON, is of the atomic type
+func isEnumValueOfType(value interface{}, atomicType string) bool {
+	switch v := value.(type) {
+	case string:
+		return atomicType == libovsdb.TypeString
+	case bool:
+		return atomicType == libovsdb.TypeBoolean
+	case float64:
+		return atomicType == libovsdb.TypeReal || (atomicType == libovsdb.TypeInteger && v == float64(int64(v)))
+	default:
+		return false
+	}
+}
+
+// Convert converts the stored data of a served database to the new schema, and replaces the schema. The rows of the
+// dropped tables and the values of the dropped columns are deleted, the added columns get their default values, and
+// the index entries are rebuilt. All the rows and the _Server.Database row of the database are written by a single
+// etcd transaction, which fails if the database was modified since it was read, so a database, which needs more than
+// ETCD_MAX_TXN_OPS operations, cannot be converted. If the data doesn't fit the new schema, the returned report lists
+// the violations, and nothing is written.
+func (con *DatabaseEtcd) Convert(ctx context.Context, data []byte) (*SchemaCheckReport, error) {
+	proposed := &libovsdb.DatabaseSchema{}
+	if err := json.Unmarshal(data, proposed); err != nil {
+		return nil, fmt.Errorf("wrong schema: %v", err)
+	}
+	if err := ValidateSchema(proposed); err != nil {
+		return nil, err
+	}
+	strSchema, err := parseSchemaMap(data)
+	if err != nil {
+		return nil, err
+	}
+	dbName := proposed.Name
+	if dbName == INT_SERVER {
+		return nil, fmt.Errorf("the %s database cannot be converted", INT_SERVER)
+	}
+	con.mu.Lock()
+	current, ok := con.Schemas[dbName]
+	con.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown database %s", dbName)
+	}
+	con.DbLock(dbName)
+	defer con.DbUnlock(dbName)
+
+	dbKey := common.NewDBPrefixKey(dbName)
+	serverKey := common.NewDataKey(INT_SERVER, INT_DATABASES, dbName)
+	schemaKey := common.NewSchemaKey(dbName)
+	res, err := con.GetData([]common.Key{dbKey, serverKey, schemaKey})
+	if err != nil {
+		return nil, err
+	}
+	revision := res.Header.Revision
+	// the stored schema is replaced, unless another replica converted the database meanwhile
+	schemaCmp := clientv3.Compare(clientv3.CreateRevision(schemaKey.String()), "=", 0)
+	for _, kv := range res.Responses[2].GetResponseRange().Kvs {
+		if string(kv.Key) == schemaKey.String() {
+			schemaCmp = clientv3.Compare(clientv3.ModRevision(schemaKey.String()), "=", kv.ModRevision)
+		}
+	}
+	var serverKv *mvccpb.KeyValue
+	for _, kv := range res.Responses[1].GetResponseRange().Kvs {
+		if string(kv.Key) == serverKey.String() {
+			serverKv = kv
+		}
+	}
+	if serverKv == nil {
+		return nil, fmt.Errorf("missing %s row of database %s", INT_DATABASES, dbName)
+	}
+	tablesKvs := map[string][]*mvccpb.KeyValue{}
+	for _, kv := range res.Responses[0].GetResponseRange().Kvs {
+		key, err := common.ParseKey(string(kv.Key))
+		if err != nil {
+			continue
+		}
+		// the internal tables of the database, like the journal, are not converted
+		if _, ok := current.Tables[key.TableName]; ok {
+			tablesKvs[key.TableName] = append(tablesKvs[key.TableName], kv)
+		}
+	}
+
+	report, err := CompareSchemas(current, proposed)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, 0, len(tablesKvs))
+	for tableName := range tablesKvs {
+		tables = append(tables, tableName)
+	}
+	sort.Strings(tables)
+	for _, tableName := range tables {
+		if tableSchema, ok := proposed.Tables[tableName]; ok {
+			report.checkTableData(tableName, &tableSchema, tablesKvs[tableName])
+		}
+	}
+	report.update()
+	if !report.Compatible {
+		return report, fmt.Errorf("the data of database %s doesn't fit the new schema", dbName)
+	}
+
+	cmps := []clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(dbKey.DBKeyString()), "<", revision+1).WithPrefix(),
+		clientv3.Compare(clientv3.ModRevision(serverKey.String()), "=", serverKv.ModRevision), schemaCmp}
+	ops := []clientv3.Op{}
+	oldIndexes := map[string]bool{}
+	newIndexes := map[string]string{}
+	violations := []string{}
+	for _, tableName := range tables {
+		oldTable := current.Tables[tableName]
+		newTable, keep := proposed.Tables[tableName]
+		for _, kv := range tablesKvs[tableName] {
+			keys, err := indexKeys(dbName, tableName, oldTable.Indexes, kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			for indexKey := range keys {
+				oldIndexes[indexKey] = true
+			}
+			if !keep {
+				ops = append(ops, clientv3.OpDelete(string(kv.Key)))
+				continue
+			}
+			value, err := convertRow(&newTable, kv.Value)
+			if err != nil {
+				return nil, fmt.Errorf("[key %s] %v", string(kv.Key), err)
+			}
+			ops = append(ops, clientv3.OpPut(string(kv.Key), value))
+			key, err := common.ParseKey(string(kv.Key))
+			if err != nil {
+				return nil, err
+			}
+			if keys, err = indexKeys(dbName, tableName, newTable.Indexes, []byte(value)); err != nil {
+				return nil, err
+			}
+			for indexKey, columns := range keys {
+				if uuid, ok := newIndexes[indexKey]; ok {
+					violations = append(violations, fmt.Sprintf("table %s columns %v rows %s and %s", tableName,
+						columns, uuid, key.UUID))
+				}
+				newIndexes[indexKey] = key.UUID
+			}
+		}
+	}
+	if len(violations) > 0 {
+		report.Violations = append(report.Violations, violations...)
+		report.update()
+		return report, fmt.Errorf("%s: rows with duplicate indexed values", E_CONSTRAINT_VIOLATION)
+	}
+	for indexKey := range oldIndexes {
+		if _, ok := newIndexes[indexKey]; !ok {
+			ops = append(ops, clientv3.OpDelete(indexKey))
+		}
+	}
+	for indexKey, uuid := range newIndexes {
+		if !oldIndexes[indexKey] {
+			ops = append(ops, clientv3.OpPut(indexKey, uuid))
+		}
+	}
+
+	serverRow, err := unmarshalData(serverKv.Value)
+	if err != nil {
+		return nil, err
+	}
+	build, err := libovsdb.NewOvsMap(buildColumn(proposed.Version))
+	if err != nil {
+		return nil, err
+	}
+	serverRow["schema"] = string(data)
+	serverRow["build"] = build
+	setRowVersion(&serverRow)
+	serverValue, err := json.Marshal(serverRow)
+	if err != nil {
+		return nil, err
+	}
+	ops = append(ops, clientv3.OpPut(serverKey.String(), string(serverValue)),
+		clientv3.OpPut(schemaKey.String(), string(data)))
+	// the conversion isn't split into a chained commit, its journal would exceed the etcd request size
+	if len(ops) > ETCD_MAX_TXN_OPS {
+		return report, fmt.Errorf("database %s is too large to be converted by a single etcd transaction, %d operations, the limit is %d",
+			dbName, len(ops), ETCD_MAX_TXN_OPS)
+	}
+
+	etcd := Etcd{Cli: con.cli, Ctx: ctx, If: cmps, Then: ops, Journal: common.NewJournalKey(dbName).String()}
+	if err := etcd.Commit(); err != nil {
+		return nil, err
+	}
+	if !etcd.Res.Succeeded {
+		return nil, fmt.Errorf("database %s was modified during the conversion", dbName)
+	}
+	con.mu.Lock()
+	con.setSchema(proposed)
+	con.strSchemas[dbName] = strSchema
+	con.mu.Unlock()
+	klog.Infof("database %s converted from schema version %s to %s", dbName, report.OldVersion, report.NewVersion)
+	return report, nil
+}
+
+// convertRow returns the stored row in the format of the new table schema: the dropped columns are removed, the added
+// columns get their default values, and the row gets a new version
+func convertRow(tableSchema *libovsdb.TableSchema, value []byte) (string, error) {
+	row := map[string]interface{}{}
+	if err := json.Unmarshal(value, &row); err != nil {
+		return "", err
+	}
+	for column := range row {
+		if _, ok := tableSchema.Columns[column]; !ok && !InternalColumns.IsInternal(column) {
+			delete(row, column)
+		}
+	}
+	if err := tableSchema.Unmarshal(&row); err != nil {
+		return "", err
+	}
+	if uuid, ok := row[COL_UUID]; ok {
+		if id, err := libovsdb.UnmarshalUUID(uuid); err == nil {
+			row[COL_UUID] = id
+		}
+	}
+	tableSchema.Default(&row)
+	setRowVersion(&row)
+	return makeValue(&row)
+}
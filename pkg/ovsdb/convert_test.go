@@ -0,0 +1,229 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+var testSchemaConvertOld *libovsdb.DatabaseSchema = &libovsdb.DatabaseSchema{
+	Name:    "convert",
+	Version: "0.0.0",
+	Tables: map[string]libovsdb.TableSchema{
+		"table1": {
+			Columns: map[string]*libovsdb.ColumnSchema{
+				"key1": {
+					Type: libovsdb.TypeString,
+				},
+				"key2": {
+					Type: libovsdb.TypeString,
+				},
+			},
+		},
+		"table2": {
+			Columns: map[string]*libovsdb.ColumnSchema{
+				"key1": {
+					Type: libovsdb.TypeString,
+				},
+			},
+		},
+	},
+}
+
+var testSchemaConvertNew *libovsdb.DatabaseSchema = &libovsdb.DatabaseSchema{
+	Name:    "convert",
+	Version: "0.0.1",
+	Tables: map[string]libovsdb.TableSchema{
+		"table1": {
+			Columns: map[string]*libovsdb.ColumnSchema{
+				"key1": {
+					Type: libovsdb.TypeInteger,
+				},
+				"key3": {
+					Type: libovsdb.TypeString,
+				},
+			},
+			MaxRows: 1,
+		},
+		"table3": {
+			Columns: map[string]*libovsdb.ColumnSchema{
+				"key1": {
+					Type: libovsdb.TypeString,
+				},
+			},
+		},
+	},
+}
+
+func TestCompareSchemas(t *testing.T) {
+	report, err := CompareSchemas(testSchemaConvertOld, testSchemaConvertNew)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"table2"}, report.DroppedTables)
+	assert.Equal(t, []string{"table3"}, report.AddedTables)
+	assert.Equal(t, map[string][]string{"table1": {"key2"}}, report.DroppedColumns)
+	assert.Equal(t, map[string][]string{"table1": {"key3"}}, report.AddedColumns)
+	assert.Equal(t, map[string][]string{"table1": {"key1"}}, report.ChangedColumns)
+	assert.True(t, report.Compatible)
+	assert.False(t, report.Lossless)
+
+	report, err = CompareSchemas(testSchemaConvertOld, testSchemaConvertOld)
+	assert.Nil(t, err)
+	assert.True(t, report.Lossless)
+
+	_, err = CompareSchemas(testSchemaConvertOld, testSchemaSimple)
+	assert.NotNil(t, err)
+}
+
+func TestCheckSchemaData(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	db, _ := NewDatabaseEtcd(cli)
+
+	report, err := CheckSchema(db, testSchemaConvertOld, testSchemaConvertNew)
+	assert.Nil(t, err)
+	assert.True(t, report.Compatible)
+
+	testEtcdPut(t, "convert", "table1", map[string]interface{}{"key1": "val1", "key2": "val2"})
+	testEtcdPut(t, "convert", "table1", map[string]interface{}{"key1": "val1", "key2": "val2"})
+	report, err = CheckSchema(db, testSchemaConvertOld, testSchemaConvertNew)
+	assert.Nil(t, err)
+	assert.False(t, report.Compatible)
+	assert.False(t, report.Lossless)
+	// maxRows violation plus one type violation per row
+	assert.Equal(t, 3, len(report.Violations))
+}
+
+const testSchemaConvertOldJSON = `{"name": "convert", "version": "0.0.0", "tables": {
+	"table1": {"columns": {"key1": {"type": "string"}, "key2": {"type": "string"}}},
+	"table2": {"columns": {"key1": {"type": "string"}}}}}`
+
+const testSchemaConvertNewJSON = `{"name": "convert", "version": "0.0.1", "tables": {
+	"table1": {"columns": {"key1": {"type": "string"}, "key3": {"type": "integer"}}, "indexes": [["key1"]]},
+	"table3": {"columns": {"key1": {"type": "string"}}}}}`
+
+func TestValidateSchema(t *testing.T) {
+	schema := &libovsdb.DatabaseSchema{}
+	assert.Nil(t, json.Unmarshal([]byte(testSchemaConvertNewJSON), schema))
+	assert.Nil(t, ValidateSchema(schema))
+
+	for _, data := range []string{
+		`{"name": "convert", "tables": {"table1": {"columns": {"key1": {"type": "string"}}}}}`,
+		`{"name": "convert", "version": "0.0.1", "tables": {}}`,
+		`{"name": "convert", "version": "0.0.1", "tables": {"table1": {"columns": {"_key": {"type": "string"}}}}}`,
+		`{"name": "convert", "version": "0.0.1", "tables": {"table1": {"columns": {"key1": {"type": "string"}},
+			"indexes": [["key2"]]}}}`,
+		`{"name": "convert", "version": "0.0.1", "tables": {"table1": {"columns": {"key1":
+			{"type": {"key": {"type": "uuid", "refTable": "table2"}}}}}}}`,
+		`{"name": "convert", "version": "0.0.1", "tables": {"table1": {"columns": {"key1":
+			{"type": {"key": {"type": "string", "refTable": "table1"}}}}}}}`,
+		`{"name": "convert", "version": "0.0.1", "tables": {"table1": {"columns": {"key1":
+			{"type": {"key": {"type": "uuid", "refTable": "table1", "refType": "soft"}}}}}}}`,
+		`{"name": "convert", "version": "0.0.1", "tables": {"table1": {"columns": {"key1":
+			{"type": {"key": "string", "min": 0, "max": 0}}}}}}`,
+		`{"name": "convert", "version": "0.0.1", "tables": {"table1": {"columns": {"key1":
+			{"type": {"key": {"type": "integer", "enum": ["set", [1, "two"]]}, "max": "unlimited"}}}}}}`,
+	} {
+		schema := &libovsdb.DatabaseSchema{}
+		assert.Nil(t, json.Unmarshal([]byte(data), schema))
+		assert.NotNil(t, ValidateSchema(schema), data)
+	}
+
+	// all the problems are reported
+	schema = &libovsdb.DatabaseSchema{}
+	assert.Nil(t, json.Unmarshal([]byte(`{"name": "convert", "version": "0.0.1", "tables": {
+		"table1": {"columns": {"_key": {"type": "string"}}, "indexes": [["key2"]]}}}`), schema))
+	assert.Equal(t, "[table table1 column _key] the names starting with '_' are reserved; "+
+		"table table1, index of unknown column key2", ValidateSchema(schema).Error())
+}
+
+func TestConvert(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	db, _ := NewDatabaseEtcd(cli)
+	schemaFile := filepath.Join(t.TempDir(), "convert.ovsschema")
+	assert.Nil(t, ioutil.WriteFile(schemaFile, []byte(testSchemaConvertOldJSON), 0644))
+	assert.Nil(t, db.AddSchema(schemaFile))
+	serverKey := common.NewDataKey(INT_SERVER, INT_DATABASES, "convert")
+	resp, err := cli.Get(context.Background(), serverKey.String())
+	assert.Nil(t, err)
+	serverRow, err := unmarshalData(resp.Kvs[0].Value)
+	assert.Nil(t, err)
+
+	testEtcdPut(t, "convert", "table1", map[string]interface{}{"key1": "val1", "key2": "val2"})
+	testEtcdPut(t, "convert", "table1", map[string]interface{}{"key1": "val2", "key2": "val2"})
+	testEtcdPut(t, "convert", "table2", map[string]interface{}{"key1": "val1"})
+
+	report, err := db.Convert(context.Background(), []byte(testSchemaConvertNewJSON))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"table2"}, report.DroppedTables)
+	assert.Equal(t, map[string][]string{"table1": {"key2"}}, report.DroppedColumns)
+	assert.Equal(t, "0.0.1", db.GetSchemas()["convert"].Version)
+	assert.Equal(t, "0.0.1", db.GetSchema("convert")["version"])
+
+	resp, err = db.GetKeyData(common.NewTableKey("convert", "table1"), false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		row, err := unmarshalData(kv.Value)
+		assert.Nil(t, err)
+		assert.Equal(t, float64(0), row["key3"])
+		assert.NotContains(t, row, "key2")
+	}
+	resp, err = db.GetKeyData(common.NewTableKey("convert", "table2"), true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(resp.Kvs))
+	resp, err = cli.Get(context.Background(), common.NewIndexTablePrefix("convert", "table1"), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(resp.Kvs))
+
+	resp, err = cli.Get(context.Background(), serverKey.String())
+	assert.Nil(t, err)
+	convertedRow, err := unmarshalData(resp.Kvs[0].Value)
+	assert.Nil(t, err)
+	assert.Equal(t, serverRow[COL_UUID], convertedRow[COL_UUID])
+	assert.NotEqual(t, serverRow[COL_VERSION], convertedRow[COL_VERSION])
+	assert.Equal(t, testSchemaConvertNewJSON, convertedRow["schema"])
+
+	// the rows have the same value of the new index
+	testEtcdPut(t, "convert", "table1", map[string]interface{}{"key1": "val1", "key3": 1})
+	data := strings.Replace(testSchemaConvertNewJSON, `"version": "0.0.1"`, `"version": "0.0.2"`, 1)
+	report, err = db.Convert(context.Background(), []byte(data))
+	assert.NotNil(t, err)
+	assert.False(t, report.Compatible)
+	assert.Equal(t, "0.0.1", db.GetSchemas()["convert"].Version)
+
+	// a database, which doesn't fit a single etcd transaction, is refused
+	testEtcdCleanup(t)
+	db, _ = NewDatabaseEtcd(cli)
+	assert.Nil(t, db.AddSchema(schemaFile))
+	for i := 0; i < ETCD_MAX_TXN_OPS; i++ {
+		testEtcdPut(t, "convert", "table2", map[string]interface{}{"key1": fmt.Sprintf("val%d", i)})
+	}
+	_, err = db.Convert(context.Background(), []byte(testSchemaConvertNewJSON))
+	assert.NotNil(t, err)
+	assert.Equal(t, "0.0.0", db.GetSchemas()["convert"].Version)
+	resp, err = db.GetKeyData(common.NewTableKey("convert", "table2"), true)
+	assert.Nil(t, err)
+	assert.Equal(t, ETCD_MAX_TXN_OPS, len(resp.Kvs))
+
+	// unknown database
+	data = strings.Replace(testSchemaConvertNewJSON, `"name": "convert"`, `"name": "unknown"`, 1)
+	_, err = db.Convert(context.Background(), []byte(data))
+	assert.NotNil(t, err)
+}
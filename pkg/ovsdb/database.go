@@ -3,18 +3,23 @@ package ovsdb
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/ibm/ovsdb-etcd/pkg/types/_Server"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
 	"k8s.io/klog/v2"
 
 	"github.com/ibm/ovsdb-etcd/pkg/common"
 	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
 )
 
 type Databaser interface {
@@ -24,18 +29,70 @@ type Databaser interface {
 	GetSchemas() libovsdb.Schemas
 	GetKeyData(key common.Key, keysOnly bool) (*clientv3.GetResponse, error)
 	GetData(keys []common.Key) (*clientv3.TxnResponse, error)
+	// GetDataAt reads the data of the keys like GetData, but at the given revision, the current one if it's 0
+	GetDataAt(keys []common.Key, revision int64) (*clientv3.TxnResponse, error)
+	// GetDataPages reads the data of the keys like GetData, but in pages of up to pageSize key-values of every key,
+	// the page function is called with every page. All the pages are read at the same revision, which is returned.
+	GetDataPages(keys []common.Key, pageSize int, page func(kvs []*mvccpb.KeyValue)) (int64, error)
 	PutData(ctx context.Context, key common.Key, obj interface{}) error
+	// DeleteData deletes the key if its mod revision is equal to the given one, returns false if the key was modified
+	DeleteData(ctx context.Context, key common.Key, modRevision int64) (bool, error)
 	GetSchema(name string) map[string]interface{}
+	// ReadSchema returns the canonical schema of the database, which is stored in etcd, and whether it replaced the
+	// schema of the server, e.g. after another replica converted the database. Returns nil for an unknown database.
+	ReadSchema(ctx context.Context, name string) (map[string]interface{}, bool, error)
+	// DbLock excludes all the transactions of the database, and DbUnlock admits them again
 	DbLock(dbName string)
 	DbUnlock(dbName string)
+	// BeginTransaction admits a transaction of the client, which modifies the tables of the database, the transactions
+	// of other tables may run concurrently. The returned ticket orders the notifications of the commits of the client.
+	BeginTransaction(dbName string, tables []string, owner *Handler) *txnTicket
+	// SetFrozen freezes or unfreezes write transactions on the given database. Freezing waits for the in-flight
+	// transactions of this server.
+	SetFrozen(dbName string, frozen bool) error
+	IsFrozen(dbName string) bool
+	// SetReadOnly sets the read-only mode of the given database, the client transactions, which modify the database,
+	// are rejected while it's set. Setting the mode waits for the in-flight transactions of this server.
+	SetReadOnly(dbName string, readOnly bool) error
+	IsReadOnly(dbName string) bool
+	// SetSerializable sets the serializable mode of the given database, the write transactions of the database are
+	// serialized with the write transactions of the other server replicas while it's set
+	SetSerializable(dbName string, serializable bool) error
+	IsSerializable(dbName string) bool
+	// GetTxnEpoch returns the epoch of the transaction ids of the database, it is persisted on the first call
+	GetTxnEpoch(dbName string) (string, error)
+	// GetHistory returns the events of the database after the given revision till the current revision, which is
+	// returned as well. Returns rpctypes.ErrCompacted if some of the events were compacted.
+	GetHistory(dbName string, revision int64) ([]*clientv3.Event, int64, error)
+	// GetCommitComments returns the comments of the recent commits of the database, the newest first
+	GetCommitComments(ctx context.Context, dbName string, limit int) ([]CommitComment, error)
+	// Convert converts the database of the schema to it, the returned report lists the violations if the stored data
+	// doesn't fit the schema
+	Convert(ctx context.Context, schema []byte) (*SchemaCheckReport, error)
+	// RowCache returns the cache of the rows, which the transactions of the database read, nil if there is no cache
+	RowCache(dbName string) *rowCache
 }
 
 type DatabaseEtcd struct {
-	cli        *clientv3.Client
-	Schemas    libovsdb.Schemas // dataBaseName -> schema
+	cli *clientv3.Client
+	// dataBaseName -> schema, replaced rather than modified while the databases are served, see setSchema
+	Schemas    libovsdb.Schemas
 	strSchemas map[string]map[string]interface{}
-	locks      map[string]*sync.Mutex
-	mu         sync.Mutex
+	locks      map[string]*sync.RWMutex
+	frozen     map[string]bool
+	readOnly   map[string]bool
+	// the databases, whose write transactions are serialized across the server replicas
+	serializable map[string]bool
+	epochs       map[string]string
+	// the mod revisions of the stored schemas, which the schemas of the databases were read from
+	schemaRevisions map[string]int64
+	// the pipelines of the transactions of the databases, on top of their locks, created by the first transaction
+	pipelines map[string]*txnPipeline
+	// the etcd watches of the databases, shared by the monitors of all the clients
+	watches *watchRegistry
+	// the caches of the rows read by the transactions, nil if they aren't started
+	rowCaches map[string]*rowCache
+	mu        sync.Mutex
 }
 
 type Locker interface {
@@ -43,30 +100,180 @@ type Locker interface {
 	lock() error
 	unlock() error
 	cancel()
+	// steal acquires the lock, even if it is held or waited for by other clients
+	steal() error
+	// onStolen sets the function, which is called when the held lock is stolen by another client
+	onStolen(stolen func())
+	// ownership verifies that the lock is held, and returns the compares, which guard an etcd transaction by the
+	// ownership of the lock
+	ownership(ctx context.Context) ([]clientv3.Cmp, error)
 }
 
+var errNotOwner = errors.New(E_NOT_OWNER)
+
+// errAlreadyAcquired is returned by a lock wait, when the lock was stolen by its own client meanwhile
+var errAlreadyAcquired = errors.New("the lock is already acquired")
+
+// the number of attempts to steal a lock, while other clients keep requesting it
+const stealAttempts = 10
+
 type lock struct {
 	mutex    *concurrency.Mutex
+	session  *concurrency.Session
 	myCancel context.CancelFunc
 	cntx     context.Context
+	// the prefix of the lock keys
+	prefix string
+
+	mu       sync.Mutex
+	acquired bool
+	// generation is incremented on every acquisition, it tells the ownership watches of the previous acquisitions
+	generation int
+	stolen     func()
 }
 
 func (l *lock) tryLock() error {
-	return l.mutex.TryLock(l.cntx)
+	err := l.mutex.TryLock(l.cntx)
+	l.setAcquired(err == nil)
+	return err
 }
 
 func (l *lock) lock() error {
-	return l.mutex.Lock(l.cntx)
+	err := l.mutex.Lock(l.cntx)
+	if err == nil && !l.setAcquired(true) {
+		return errAlreadyAcquired
+	}
+	if err != nil {
+		l.setAcquired(false)
+	}
+	return err
 }
 
 func (l *lock) unlock() error {
+	l.setAcquired(false)
 	return l.mutex.Unlock(l.cntx)
 }
 
+// cancel releases the lock and its session, the revoke of the session lease deletes the lock key, and so wakes up the
+// waiters immediately
 func (l *lock) cancel() {
+	l.setAcquired(false)
+	if err := l.session.Close(); err != nil {
+		klog.V(5).Infof("lock session close: %v", err)
+	}
 	l.myCancel()
 }
 
+// steal deletes the keys of the current owner and of the waiters of the lock, and creates its own key in the same etcd
+// transaction, so it becomes the oldest key of the lock. The waiters are woken up without their keys, and should request
+// the lock again.
+func (l *lock) steal() error {
+	myKey := fmt.Sprintf("%s%x", l.prefix, l.session.Lease())
+	client := l.session.Client()
+	for i := 0; i < stealAttempts; i++ {
+		resp, err := client.Get(l.cntx, l.prefix, clientv3.WithPrefix())
+		if err != nil {
+			return err
+		}
+		// no key was created since the read
+		cmps := []clientv3.Cmp{clientv3.Compare(clientv3.CreateRevision(l.prefix).WithPrefix(), "<", resp.Header.Revision+1)}
+		ops := []clientv3.Op{}
+		exists := false
+		for _, kv := range resp.Kvs {
+			if string(kv.Key) == myKey {
+				exists = true
+				continue
+			}
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(string(kv.Key)), "=", kv.CreateRevision))
+			ops = append(ops, clientv3.OpDelete(string(kv.Key)))
+		}
+		if !exists {
+			ops = append(ops, clientv3.OpPut(myKey, "", clientv3.WithLease(l.session.Lease())))
+		}
+		tresp, err := client.Txn(l.cntx).If(cmps...).Then(ops...).Commit()
+		if err != nil {
+			return err
+		}
+		if !tresp.Succeeded {
+			continue
+		}
+		// the key is the oldest one, the acquisition just updates the state of the mutex
+		if err = l.tryLock(); err != concurrency.ErrLocked {
+			return err
+		}
+	}
+	return concurrency.ErrLocked
+}
+
+func (l *lock) onStolen(stolen func()) {
+	l.mu.Lock()
+	l.stolen = stolen
+	l.mu.Unlock()
+}
+
+// setAcquired sets the acquisition state of the lock, and returns true if the state was changed. A new acquisition
+// starts to watch the lock key, for the case the lock is stolen.
+func (l *lock) setAcquired(acquired bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.acquired == acquired {
+		return false
+	}
+	l.acquired = acquired
+	if acquired {
+		l.generation++
+		go l.watchOwnership(l.generation, l.mutex.Key(), l.mutex.Header().Revision)
+	}
+	return true
+}
+
+// watchOwnership waits for the deletion of the lock key, when it is deleted while the lock is held, the lock was stolen
+func (l *lock) watchOwnership(generation int, key string, revision int64) {
+	wch := l.session.Client().Watch(l.cntx, key, clientv3.WithRev(revision+1), clientv3.WithFilterPut())
+	for resp := range wch {
+		if resp.Err() != nil {
+			return
+		}
+		if len(resp.Events) == 0 {
+			continue
+		}
+		l.mu.Lock()
+		stolen := l.acquired && l.generation == generation
+		if stolen {
+			l.acquired = false
+		}
+		f := l.stolen
+		l.mu.Unlock()
+		if stolen && f != nil {
+			f()
+		}
+		return
+	}
+}
+
+func (l *lock) ownership(ctx context.Context) ([]clientv3.Cmp, error) {
+	l.mu.Lock()
+	acquired := l.acquired
+	key := l.mutex.Key()
+	l.mu.Unlock()
+	if !acquired {
+		return nil, errNotOwner
+	}
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	defer cancel()
+	// the lock is held by the oldest key of its prefix
+	prefix := key[:strings.LastIndex(key, "/")+1]
+	resp, err := l.session.Client().Get(tctx, prefix, clientv3.WithFirstCreate()...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 || string(resp.Kvs[0].Key) != key {
+		return nil, errNotOwner
+	}
+	// an older key cannot be created later, so the lock is held as long as the key exists
+	return []clientv3.Cmp{clientv3.Compare(clientv3.CreateRevision(key), "=", resp.Kvs[0].CreateRevision)}, nil
+}
+
 var EtcdClientTimeout = time.Second
 
 func NewEtcdClient(endpoints []string) (*clientv3.Client, error) {
@@ -84,7 +291,10 @@ func NewEtcdClient(endpoints []string) (*clientv3.Client, error) {
 
 func NewDatabaseEtcd(cli *clientv3.Client) (Databaser, error) {
 	return &DatabaseEtcd{cli: cli,
-		Schemas: libovsdb.Schemas{}, strSchemas: map[string]map[string]interface{}{}, locks: map[string]*sync.Mutex{}}, nil
+		Schemas: libovsdb.Schemas{}, strSchemas: map[string]map[string]interface{}{},
+		schemaRevisions: map[string]int64{}, locks: map[string]*sync.RWMutex{},
+		frozen: map[string]bool{}, readOnly: map[string]bool{}, serializable: map[string]bool{},
+		epochs: map[string]string{}, watches: newWatchRegistry(cli)}, nil
 }
 
 func (con *DatabaseEtcd) DbLock(dbName string) {
@@ -95,16 +305,170 @@ func (con *DatabaseEtcd) DbUnlock(dbName string) {
 	con.locks[dbName].Unlock()
 }
 
+// SetFrozen should be called without holding the database lock, while IsFrozen is called under it, so a transaction
+// cannot commit after its database was frozen.
+func (con *DatabaseEtcd) SetFrozen(dbName string, frozen bool) error {
+	con.mu.Lock()
+	dbLock, ok := con.locks[dbName]
+	con.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown database %s", dbName)
+	}
+	dbLock.Lock()
+	con.mu.Lock()
+	con.frozen[dbName] = frozen
+	con.mu.Unlock()
+	dbLock.Unlock()
+	return nil
+}
+
+func (con *DatabaseEtcd) IsFrozen(dbName string) bool {
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	return con.frozen[dbName]
+}
+
+// SetReadOnly, like SetFrozen, is called without holding the database lock, while IsReadOnly is called under it
+func (con *DatabaseEtcd) SetReadOnly(dbName string, readOnly bool) error {
+	con.mu.Lock()
+	dbLock, ok := con.locks[dbName]
+	con.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown database %s", dbName)
+	}
+	dbLock.Lock()
+	con.mu.Lock()
+	con.readOnly[dbName] = readOnly
+	con.mu.Unlock()
+	dbLock.Unlock()
+	return nil
+}
+
+func (con *DatabaseEtcd) IsReadOnly(dbName string) bool {
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	return con.readOnly[dbName]
+}
+
+// SetSerializable, like SetReadOnly, waits for the in-flight transactions of the database
+func (con *DatabaseEtcd) SetSerializable(dbName string, serializable bool) error {
+	con.mu.Lock()
+	dbLock, ok := con.locks[dbName]
+	con.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown database %s", dbName)
+	}
+	dbLock.Lock()
+	con.mu.Lock()
+	con.serializable[dbName] = serializable
+	con.mu.Unlock()
+	dbLock.Unlock()
+	return nil
+}
+
+func (con *DatabaseEtcd) IsSerializable(dbName string) bool {
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	return con.serializable[dbName]
+}
+
+func (con *DatabaseEtcd) GetTxnEpoch(dbName string) (string, error) {
+	con.mu.Lock()
+	epoch, ok := con.epochs[dbName]
+	con.mu.Unlock()
+	if ok {
+		return epoch, nil
+	}
+	key := common.NewTxnEpochKey(dbName).String()
+	ctx, cancel := context.WithTimeout(context.Background(), EtcdClientTimeout)
+	defer cancel()
+	// the epoch of another server is used if it was created concurrently
+	res, err := con.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, common.GenerateUUID())).
+		Commit()
+	if err != nil {
+		return "", err
+	}
+	resp, err := con.cli.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", fmt.Errorf("txn epoch of %s was deleted", dbName)
+	}
+	epoch = string(resp.Kvs[0].Value)
+	klog.V(5).Infof("txn epoch of %s is %s, created %v", dbName, epoch, res.Succeeded)
+	con.mu.Lock()
+	con.epochs[dbName] = epoch
+	con.mu.Unlock()
+	return epoch, nil
+}
+
+func (con *DatabaseEtcd) GetCommitComments(ctx context.Context, dbName string, limit int) ([]CommitComment, error) {
+	return GetCommitComments(ctx, con.cli, dbName, limit)
+}
+
+func (con *DatabaseEtcd) GetHistory(dbName string, revision int64) ([]*clientv3.Event, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), HistoryTimeout)
+	defer cancel()
+	key := common.NewDBPrefixKey(dbName).String()
+	resp, err := con.cli.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return nil, 0, err
+	}
+	current := resp.Header.Revision
+	if revision >= current {
+		return nil, current, nil
+	}
+	// a progress notification is sent after all the earlier events were delivered, the watch isn't bound to the
+	// leader, so it doesn't share the stream of the monitors watches
+	wch := con.cli.Watch(ctx, key, clientv3.WithPrefix(), clientv3.WithPrevKV(), clientv3.WithRev(revision+1))
+	events := []*clientv3.Event{}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case wresp, ok := <-wch:
+			if !ok {
+				return nil, 0, ctx.Err()
+			}
+			if err := wresp.Err(); err != nil {
+				return nil, 0, err
+			}
+			for _, ev := range wresp.Events {
+				if ev.Kv.ModRevision > current {
+					return events, current, nil
+				}
+				events = append(events, ev)
+			}
+			if wresp.IsProgressNotify() && wresp.Header.Revision >= current {
+				return events, current, nil
+			}
+		case <-ticker.C:
+			if err := con.cli.RequestProgress(ctx); err != nil {
+				return nil, 0, err
+			}
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
 func (con *DatabaseEtcd) GetLock(ctx context.Context, id string) (Locker, error) {
 	ctctx, cancel := context.WithCancel(ctx)
-	session, err := concurrency.NewSession(con.cli, concurrency.WithContext(ctctx))
+	ttl := int(LockLeaseTTL / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+	session, err := concurrency.NewSession(con.cli, concurrency.WithContext(ctctx), concurrency.WithTTL(ttl))
 	if err != nil {
 		cancel()
 		return nil, err
 	}
 	key := common.NewLockKey(id)
 	mutex := concurrency.NewMutex(session, key.String())
-	return &lock{mutex: mutex, myCancel: cancel, cntx: ctctx}, nil
+	return &lock{mutex: mutex, session: session, myCancel: cancel, cntx: ctctx, prefix: key.String() + "/"}, nil
 }
 
 func (con *DatabaseEtcd) AddSchema(schemaFile string) error {
@@ -112,37 +476,83 @@ func (con *DatabaseEtcd) AddSchema(schemaFile string) error {
 	if err != nil {
 		return err
 	}
-	err = con.Schemas.AddFromBytes(data)
-	if err != nil {
-		return err
-	}
 	schemaMap := map[string]interface{}{}
 	err = json.Unmarshal(data, &schemaMap)
 	if err != nil {
 		return err
 	}
-	schemaName := schemaMap["name"].(string)
+	schemaName, ok := schemaMap["name"].(string)
+	if !ok || schemaName == "" {
+		return fmt.Errorf("missing database name in schema %s", schemaFile)
+	}
+	// every database is served once, its data is keyed by its name
+	if _, ok := con.GetSchemas()[schemaName]; ok {
+		return fmt.Errorf("database %s is already served", schemaName)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), EtcdClientTimeout)
+	defer cancel()
+	// the _Server schema is of the server build, while the schemas of the other databases are shared by the replicas
+	var revision int64
+	if schemaName != INT_SERVER {
+		if data, revision, err = negotiateSchema(ctx, con.cli, schemaName, data); err != nil {
+			return fmt.Errorf("schema %s: %v", schemaFile, err)
+		}
+	}
+	if schemaMap, err = parseSchemaMap(data); err != nil {
+		return err
+	}
+	if name, _ := schemaMap["name"].(string); name != schemaName {
+		return fmt.Errorf("schema %s, the stored schema of database %s is of database %q", schemaFile, schemaName, name)
+	}
+	schema := &libovsdb.DatabaseSchema{}
+	if err = json.Unmarshal(data, schema); err != nil {
+		return err
+	}
 	con.mu.Lock()
+	con.setSchema(schema)
 	con.strSchemas[schemaName] = schemaMap
-	con.locks[schemaName] = &sync.Mutex{}
+	con.schemaRevisions[schemaName] = revision
+	con.locks[schemaName] = &sync.RWMutex{}
 	con.mu.Unlock()
 	schemaSet, err := libovsdb.NewOvsSet(string(data))
+	version, _ := schemaMap["version"].(string)
+	build, err := libovsdb.NewOvsMap(buildColumn(version))
+	if err != nil {
+		return err
+	}
+	// the connected, leader and index columns are maintained by DatabaseStatus while the database is served
 	srv := _Server.Database{Model: "standalone", Name: schemaName, Uuid: libovsdb.UUID{GoUUID: uuid.NewString()},
-		Connected: true, Leader: true, Schema: *schemaSet, Version: libovsdb.UUID{GoUUID: uuid.NewString()}}
+		Connected: true, Leader: true, Schema: *schemaSet, Build: *build, Version: libovsdb.UUID{GoUUID: uuid.NewString()}}
 	key := common.NewDataKey("_Server", "Database", schemaName)
-	ctx, cancel := context.WithTimeout(context.Background(), EtcdClientTimeout)
-	defer cancel()
 	if err := (*con).PutData(ctx, key, srv); err != nil {
 		return err
 	}
 	return nil
 }
 
+// GetSchemas returns the schemas of the served databases, the returned map is not modified, a changed schema replaces
+// the map of the database
 func (con *DatabaseEtcd) GetSchemas() libovsdb.Schemas {
+	con.mu.Lock()
+	defer con.mu.Unlock()
 	return con.Schemas
 }
 
+// setSchema replaces the schemas map by a copy with the schema of the database, so the maps returned by GetSchemas can
+// be read without the lock. The caller holds con.mu.
+func (con *DatabaseEtcd) setSchema(schema *libovsdb.DatabaseSchema) {
+	schemas := make(libovsdb.Schemas, len(con.Schemas)+1)
+	for dbName, s := range con.Schemas {
+		schemas[dbName] = s
+	}
+	schemas[schema.Name] = schema
+	con.Schemas = schemas
+}
+
 func (con *DatabaseEtcd) GetKeyData(key common.Key, keysOnly bool) (*clientv3.GetResponse, error) {
+	defer func(start time.Time) {
+		observeLatency(serverMetrics, METRIC_ETCD_LATENCY_PREFIX+ETCD_REQUEST_GET, time.Since(start))
+	}(time.Now())
 	ctx, cancel := context.WithTimeout(context.Background(), EtcdClientTimeout)
 	var resp *clientv3.GetResponse
 	var err error
@@ -165,19 +575,58 @@ func (con *DatabaseEtcd) GetKeyData(key common.Key, keysOnly bool) (*clientv3.Ge
 }
 
 func (con *DatabaseEtcd) GetData(keys []common.Key) (*clientv3.TxnResponse, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), EtcdClientTimeout)
+	return con.getData(keys, clientv3.WithPrefix())
+}
+
+func (con *DatabaseEtcd) GetDataAt(keys []common.Key, revision int64) (*clientv3.TxnResponse, error) {
+	if revision == 0 {
+		return con.GetData(keys)
+	}
+	return con.getData(keys, clientv3.WithPrefix(), clientv3.WithRev(revision))
+}
+
+// getData reads the keys by the get options in a single transaction, which waits for the chained commits of their
+// databases
+func (con *DatabaseEtcd) getData(keys []common.Key, opts ...clientv3.OpOption) (*clientv3.TxnResponse, error) {
+	defer func(start time.Time) {
+		observeLatency(serverMetrics, METRIC_ETCD_LATENCY_PREFIX+ETCD_REQUEST_GET, time.Since(start))
+	}(time.Now())
 	ops := []clientv3.Op{}
+	// the data isn't read during chained commits of its databases
+	cmps := []clientv3.Cmp{}
+	journals := []clientv3.Op{}
+	guarded := map[string]bool{}
 	for _, key := range keys {
-		ops = append(ops, clientv3.OpGet(key.String(), clientv3.WithPrefix()))
+		ops = append(ops, clientv3.OpGet(key.String(), opts...))
+		if key.DBName != "" && !guarded[key.DBName] {
+			guarded[key.DBName] = true
+			journal := common.NewJournalKey(key.DBName).String()
+			cmps = append(cmps, journalAbsent(journal))
+			journals = append(journals, clientv3.OpGet(journal))
+		}
 	}
-	res, err := con.cli.Txn(ctx).Then(ops...).Commit()
-	cancel()
-	if err != nil {
-		klog.Errorf("GetData returned error: %v", err)
-	} else {
-		klog.Infof("GetData succeeded %v revision %d", res.Succeeded, res.Header.Revision)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), EtcdClientTimeout)
+		res, err := con.cli.Txn(ctx).If(cmps...).Then(ops...).Else(journals...).Commit()
+		cancel()
+		if err != nil {
+			klog.Errorf("GetData returned error: %v", err)
+			return res, err
+		}
+		if res.Succeeded {
+			klog.Infof("GetData succeeded %v revision %d", res.Succeeded, res.Header.Revision)
+			return res, nil
+		}
+		for _, r := range res.Responses {
+			if kvs := r.GetResponseRange().Kvs; len(kvs) > 0 {
+				if err := waitJournal(context.Background(), con.cli, kvs[0]); err != nil {
+					klog.Errorf("GetData returned error: %v", err)
+					return nil, err
+				}
+				break
+			}
+		}
 	}
-	return res, err
 }
 
 func (con *DatabaseEtcd) GetSchema(name string) map[string]interface{} {
@@ -196,16 +645,26 @@ func (con *DatabaseEtcd) PutData(ctx context.Context, key common.Key, obj interf
 	return nil
 }
 
+func (con *DatabaseEtcd) DeleteData(ctx context.Context, key common.Key, modRevision int64) (bool, error) {
+	res, err := con.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key.String()), "=", modRevision)).
+		Then(clientv3.OpDelete(key.String())).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return res.Succeeded, nil
+}
+
 func (con *DatabaseEtcd) CreateMonitor(dbName string, handler *Handler, log logr.Logger) *dbMonitor {
 	m := newMonitor(dbName, handler, log)
 	ctxt, cancel := context.WithCancel(context.Background())
-	m.cancel = cancel
-	key := common.NewDBPrefixKey(dbName)
-	wch := con.cli.Watch(clientv3.WithRequireLeader(ctxt), key.String(),
-		clientv3.WithPrefix(),
-		clientv3.WithCreatedNotify(),
-		clientv3.WithPrevKV())
-	m.watchChannel = wch
+	m.watchCtx = ctxt
+	m.subscription = con.watches.subscribe(m)
+	m.cancel = func() {
+		cancel()
+		con.watches.unsubscribe(m, m.subscription)
+	}
 	return m
 }
 
@@ -239,6 +698,16 @@ func (l *LockerMock) cancel() {
 	l.Mu.Unlock()
 }
 
+func (l *LockerMock) steal() error {
+	return l.Error
+}
+
+func (l *LockerMock) onStolen(stolen func()) {}
+
+func (l *LockerMock) ownership(ctx context.Context) ([]clientv3.Cmp, error) {
+	return nil, l.Error
+}
+
 func NewDatabaseMock() (Databaser, error) {
 	return &DatabaseMock{}, nil
 }
@@ -264,14 +733,47 @@ func (con *DatabaseMock) GetData(keys []common.Key) (*clientv3.TxnResponse, erro
 	return con.Response.(*clientv3.TxnResponse), con.Error
 }
 
+func (con *DatabaseMock) GetDataAt(keys []common.Key, revision int64) (*clientv3.TxnResponse, error) {
+	return con.GetData(keys)
+}
+
+func (con *DatabaseMock) GetDataPages(keys []common.Key, pageSize int, page func(kvs []*mvccpb.KeyValue)) (int64, error) {
+	if con.Error != nil {
+		return 0, con.Error
+	}
+	resp := con.Response.(*clientv3.TxnResponse)
+	txnResponsePages(resp, page)
+	return resp.Header.Revision, nil
+}
+
 func (con *DatabaseMock) PutData(ctx context.Context, key common.Key, obj interface{}) error {
 	return con.Error
 }
 
+func (con *DatabaseMock) DeleteData(ctx context.Context, key common.Key, modRevision int64) (bool, error) {
+	return con.Ok, con.Error
+}
+
+func (con *DatabaseMock) GetTxnEpoch(dbName string) (string, error) {
+	return ovsjson.ZERO_UUID, con.Error
+}
+
+func (con *DatabaseMock) GetCommitComments(ctx context.Context, dbName string, limit int) ([]CommitComment, error) {
+	return nil, con.Error
+}
+
+func (con *DatabaseMock) GetHistory(dbName string, revision int64) ([]*clientv3.Event, int64, error) {
+	return nil, revision, con.Error
+}
+
 func (con *DatabaseMock) GetSchema(name string) map[string]interface{} {
 	return nil
 }
 
+func (con *DatabaseMock) ReadSchema(ctx context.Context, name string) (map[string]interface{}, bool, error) {
+	return nil, false, con.Error
+}
+
 func (con *DatabaseMock) GetUUID() string {
 	return con.Response.(string)
 }
@@ -285,3 +787,39 @@ func (con *DatabaseMock) CreateMonitor(dbName string, handler *Handler, log logr
 
 func (con *DatabaseMock) DbLock(dbName string)   {}
 func (con *DatabaseMock) DbUnlock(dbName string) {}
+
+func (con *DatabaseMock) BeginTransaction(dbName string, tables []string, owner *Handler) *txnTicket {
+	return nil
+}
+
+func (con *DatabaseMock) SetFrozen(dbName string, frozen bool) error {
+	return con.Error
+}
+
+func (con *DatabaseMock) Convert(ctx context.Context, schema []byte) (*SchemaCheckReport, error) {
+	return nil, con.Error
+}
+
+func (con *DatabaseMock) RowCache(dbName string) *rowCache {
+	return nil
+}
+
+func (con *DatabaseMock) IsFrozen(dbName string) bool {
+	return false
+}
+
+func (con *DatabaseMock) SetReadOnly(dbName string, readOnly bool) error {
+	return con.Error
+}
+
+func (con *DatabaseMock) IsReadOnly(dbName string) bool {
+	return false
+}
+
+func (con *DatabaseMock) SetSerializable(dbName string, serializable bool) error {
+	return con.Error
+}
+
+func (con *DatabaseMock) IsSerializable(dbName string) bool {
+	return false
+}
@@ -0,0 +1,127 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// ImportDatabase loads a database file of ovsdb-server, whose transactions are replayed, into the database, and returns
+// the number of the imported rows. If the served schema of the database is given, the rows are converted to it: the
+// tables and the columns, which are not in the served schema, are dropped, and the missing columns get their default
+// values. The database should be empty, unless force is set, then its rows are replaced.
+func ImportDatabase(ctx context.Context, cli *clientv3.Client, r io.Reader, served *libovsdb.DatabaseSchema, force bool) (int, error) {
+	dbSchema, rows, err := readDBFile(r)
+	if err != nil {
+		return 0, err
+	}
+	if served != nil {
+		if served.Name != dbSchema.Name {
+			return 0, fmt.Errorf("the file database %s is not the served database %s", dbSchema.Name, served.Name)
+		}
+		if served.Version != dbSchema.Version {
+			klog.Infof("database %s is converted from schema version %s to %s", dbSchema.Name, dbSchema.Version,
+				served.Version)
+		}
+		rows = convertImportedRows(served, rows)
+		dbSchema = served
+	}
+	return restoreRows(ctx, cli, dbSchema, rows, force)
+}
+
+// convertImportedRows returns the rows of the tables and the columns of the served schema
+func convertImportedRows(served *libovsdb.DatabaseSchema, rows map[string]map[string]map[string]interface{}) map[string]map[string]map[string]interface{} {
+	converted := map[string]map[string]map[string]interface{}{}
+	for table, tableRows := range rows {
+		tableSchema, ok := served.Tables[table]
+		if !ok {
+			klog.Infof("the %d rows of table %s, which is not in the served schema, are dropped", len(tableRows), table)
+			continue
+		}
+		converted[table] = map[string]map[string]interface{}{}
+		for uuid, row := range tableRows {
+			convertedRow := map[string]interface{}{}
+			for column, value := range row {
+				if _, ok := tableSchema.Columns[column]; ok {
+					convertedRow[column] = value
+				}
+			}
+			converted[table][uuid] = convertedRow
+		}
+	}
+	return converted
+}
+
+// applyColumnDiff returns the value of a column after the difference of a "_is_diff" transaction of ovsdb-server: the
+// new value of a scalar column, the symmetric difference of the set elements, or the map pairs, which are added if the
+// key is not in the map, removed if the pair is in the map, and replace the value of the key otherwise. The values are
+// in the JSON notation of RFC 7047.
+func applyColumnDiff(columnSchema *libovsdb.ColumnSchema, old, diff interface{}) interface{} {
+	typeObj := columnSchema.TypeObj
+	if typeObj == nil || (typeObj.Max != libovsdb.Unlimited && typeObj.Max <= 1) {
+		return diff
+	}
+	if typeObj.Value == nil {
+		elements := notationElements(old, libovsdb.TypeSet)
+		for _, element := range notationElements(diff, libovsdb.TypeSet) {
+			found := false
+			for i, e := range elements {
+				if reflect.DeepEqual(e, element) {
+					elements = append(elements[:i], elements[i+1:]...)
+					found = true
+					break
+				}
+			}
+			if !found {
+				elements = append(elements, element)
+			}
+		}
+		return []interface{}{libovsdb.TypeSet, elements}
+	}
+	pairs := notationElements(old, libovsdb.TypeMap)
+	for _, p := range notationElements(diff, libovsdb.TypeMap) {
+		pair, ok := p.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		found := false
+		for i, e := range pairs {
+			existing, ok := e.([]interface{})
+			if !ok || len(existing) != 2 || !reflect.DeepEqual(existing[0], pair[0]) {
+				continue
+			}
+			if reflect.DeepEqual(existing[1], pair[1]) {
+				pairs = append(pairs[:i], pairs[i+1:]...)
+			} else {
+				pairs[i] = pair
+			}
+			found = true
+			break
+		}
+		if !found {
+			pairs = append(pairs, pair)
+		}
+	}
+	return []interface{}{libovsdb.TypeMap, pairs}
+}
+
+// notationElements returns the elements of a ["set", [...]] or the pairs of a ["map", [...]] value, a single atom is
+// a set of one element
+func notationElements(value interface{}, notation string) []interface{} {
+	if array, ok := value.([]interface{}); ok && len(array) == 2 && array[0] == notation {
+		if elements, ok := array[1].([]interface{}); ok {
+			return append([]interface{}{}, elements...)
+		}
+		return nil
+	}
+	if value == nil || notation == libovsdb.TypeMap {
+		return nil
+	}
+	return []interface{}{value}
+}
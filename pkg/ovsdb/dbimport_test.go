@@ -0,0 +1,91 @@
+package ovsdb
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+func TestApplyColumnDiff(t *testing.T) {
+	schema := testSchemaRBAC(t)
+	chassis := schema.Tables["Chassis"]
+	permission := schema.Tables["RBAC_Permission"]
+	for _, tc := range []struct {
+		name     string
+		column   *libovsdb.ColumnSchema
+		old      interface{}
+		diff     interface{}
+		expected interface{}
+	}{
+		{"scalar", chassis.Columns["hostname"], "h1", "h2", "h2"},
+		{"set", permission.Columns["update"],
+			[]interface{}{"set", []interface{}{"a", "b"}},
+			[]interface{}{"set", []interface{}{"b", "c"}},
+			[]interface{}{"set", []interface{}{"a", "c"}}},
+		{"set of one element", permission.Columns["update"], "a", "b",
+			[]interface{}{"set", []interface{}{"a", "b"}}},
+		{"map", chassis.Columns["external_ids"],
+			[]interface{}{"map", []interface{}{[]interface{}{"k1", "v1"}, []interface{}{"k2", "v2"}}},
+			[]interface{}{"map", []interface{}{[]interface{}{"k1", "v1"}, []interface{}{"k2", "v3"},
+				[]interface{}{"k3", "v4"}}},
+			[]interface{}{"map", []interface{}{[]interface{}{"k2", "v3"}, []interface{}{"k3", "v4"}}}},
+	} {
+		assert.Equal(t, tc.expected, applyColumnDiff(tc.column, tc.old, tc.diff), tc.name)
+	}
+}
+
+func TestImportDatabase(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	assert.Nil(t, writeDBRecord(&buf, []byte(testSchemaRBACJSON)))
+	assert.Nil(t, writeDBRecord(&buf, []byte(`{
+		"Chassis": {"u1": {"name": "ch1", "external_ids": ["map", [["k1", "v1"], ["k2", "v2"]]]}},
+		"RBAC_Role": {"u2": {"name": "ovn-controller"}}}`)))
+	assert.Nil(t, writeDBRecord(&buf, []byte(`{
+		"Chassis": {"u1": {"hostname": "h1", "external_ids": ["map", [["k1", "v1"], ["k3", "v3"]]]}},
+		"_is_diff": true}`)))
+
+	// the served schema has no RBAC_Role table, and no hostname column
+	served := testSchemaRBAC(t)
+	delete(served.Tables, "RBAC_Role")
+	delete(served.Tables["Chassis"].Columns, "hostname")
+	imported, err := ImportDatabase(ctx, cli, bytes.NewReader(buf.Bytes()), served, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, imported)
+	rows := testReadRows(t, cli, "rbac")
+	if assert.Len(t, rows, 1) {
+		row := rows[common.NewDataKey("rbac", "Chassis", "u1").String()]
+		assert.Equal(t, "ch1", row["name"])
+		assert.Nil(t, row["hostname"])
+		assert.ElementsMatch(t, []interface{}{[]interface{}{"k2", "v2"}, []interface{}{"k3", "v3"}},
+			row["external_ids"].([]interface{})[1])
+	}
+	resp, err := cli.Get(ctx, common.NewIndexTablePrefix("rbac", "Chassis"), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), resp.Count)
+
+	// the database is not empty
+	_, err = ImportDatabase(ctx, cli, bytes.NewReader(buf.Bytes()), served, false)
+	assert.NotNil(t, err)
+	imported, err = ImportDatabase(ctx, cli, bytes.NewReader(buf.Bytes()), nil, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, imported)
+
+	served.Name = "other"
+	_, err = ImportDatabase(ctx, cli, bytes.NewReader(buf.Bytes()), served, true)
+	assert.NotNil(t, err)
+}
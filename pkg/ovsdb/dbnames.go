@@ -0,0 +1,86 @@
+package ovsdb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+var (
+	aliasesMu sync.RWMutex
+	// databaseAliases maps alias names to the served database names, e.g. "nbdb" to "OVN_Northbound". The aliases
+	// are resolved in the client requests only, they are not listed by list_dbs, and have no _Server.Database rows.
+	databaseAliases = map[string]string{}
+)
+
+// CaseInsensitiveDBNames enables case-insensitive lookup of the database names and their aliases, e.g.
+// "ovn_northbound" is served as "OVN_Northbound".
+var CaseInsensitiveDBNames bool
+
+// SetDatabaseAliases sets the database name aliases, should be called before the server starts serving.
+func SetDatabaseAliases(aliases map[string]string) {
+	copied := make(map[string]string, len(aliases))
+	for alias, dbName := range aliases {
+		copied[alias] = dbName
+	}
+	aliasesMu.Lock()
+	databaseAliases = copied
+	aliasesMu.Unlock()
+}
+
+// getDatabaseAliases returns the aliases map, which is replaced and never modified by SetDatabaseAliases
+func getDatabaseAliases() map[string]string {
+	aliasesMu.RLock()
+	defer aliasesMu.RUnlock()
+	return databaseAliases
+}
+
+// ParseDatabaseAliases parses a comma separated list of <alias>=<db-name> aliases, e.g.
+// "nbdb=OVN_Northbound,OVN_NB=OVN_Northbound".
+func ParseDatabaseAliases(aliases string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, str := range strings.Split(aliases, ",") {
+		str = strings.TrimSpace(str)
+		if str == "" {
+			continue
+		}
+		parts := strings.Split(str, "=")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("wrong database alias %q", str)
+		}
+		if _, ok := parsed[parts[0]]; ok {
+			return nil, fmt.Errorf("duplicate database alias %q", parts[0])
+		}
+		parsed[parts[0]] = parts[1]
+	}
+	return parsed, nil
+}
+
+// ResolveDatabaseName returns the name of the served database, which is requested by the given name or alias. If the
+// name is unknown it is returned unchanged, so the callers report the unknown database as before. The schemas should
+// be a snapshot, which isn't modified, as returned by Databaser.GetSchemas.
+func ResolveDatabaseName(schemas libovsdb.Schemas, name string) string {
+	if _, ok := schemas[name]; ok {
+		return name
+	}
+	databaseAliases := getDatabaseAliases()
+	if dbName, ok := databaseAliases[name]; ok {
+		return dbName
+	}
+	if !CaseInsensitiveDBNames {
+		return name
+	}
+	for dbName := range schemas {
+		if strings.EqualFold(dbName, name) {
+			return dbName
+		}
+	}
+	for alias, dbName := range databaseAliases {
+		if strings.EqualFold(alias, name) {
+			return dbName
+		}
+	}
+	return name
+}
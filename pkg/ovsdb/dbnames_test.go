@@ -0,0 +1,61 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func TestParseDatabaseAliases(t *testing.T) {
+	aliases, err := ParseDatabaseAliases(" nbdb=OVN_Northbound, OVN_NB=OVN_Northbound,")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"nbdb": "OVN_Northbound", "OVN_NB": "OVN_Northbound"}, aliases)
+	for _, str := range []string{"nbdb", "nbdb=", "=OVN_Northbound", "a=b=c", "nbdb=a,nbdb=b"} {
+		_, err = ParseDatabaseAliases(str)
+		assert.NotNil(t, err, str)
+	}
+}
+
+func TestResolveDatabaseName(t *testing.T) {
+	SetDatabaseAliases(map[string]string{"nbdb": "OVN_Northbound"})
+	defer SetDatabaseAliases(map[string]string{})
+	schemas := libovsdb.Schemas{"OVN_Northbound": &libovsdb.DatabaseSchema{Name: "OVN_Northbound"}}
+
+	assert.Equal(t, "OVN_Northbound", ResolveDatabaseName(schemas, "OVN_Northbound"))
+	assert.Equal(t, "OVN_Northbound", ResolveDatabaseName(schemas, "nbdb"))
+	assert.Equal(t, "ovn_northbound", ResolveDatabaseName(schemas, "ovn_northbound"))
+	assert.Equal(t, "NBDB", ResolveDatabaseName(schemas, "NBDB"))
+
+	CaseInsensitiveDBNames = true
+	defer func() { CaseInsensitiveDBNames = false }()
+	assert.Equal(t, "OVN_Northbound", ResolveDatabaseName(schemas, "ovn_northbound"))
+	assert.Equal(t, "OVN_Northbound", ResolveDatabaseName(schemas, "NBDB"))
+	assert.Equal(t, "unknown", ResolveDatabaseName(schemas, "unknown"))
+}
+
+func TestMonitorDatabaseAlias(t *testing.T) {
+	SetDatabaseAliases(map[string]string{"alias": DB_NAME})
+	defer SetDatabaseAliases(map[string]string{})
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {}},
+	}}
+	db := DatabaseMock{Response: schemas}
+	handler := NewHandler(context.Background(), &db, nil, klogr.New())
+	var params []interface{}
+	err := json.Unmarshal([]byte(`["alias", null, {"T1": [{}]}]`), &params)
+	assert.Nil(t, err)
+	_, err = handler.addMonitor(params, ovsjson.Update2)
+	assert.Nil(t, err)
+	_, ok := handler.monitors[DB_NAME]
+	assert.True(t, ok)
+	for _, hmd := range handler.handlerMonitorData {
+		assert.Equal(t, DB_NAME, hmd.dataBaseName)
+	}
+}
@@ -0,0 +1,193 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// DatabaseStatus maintains the status columns of the _Server.Database rows, so the clients, which monitor the _Server
+// database, e.g. to wait for a connected leader before they use a database, follow the state of the databases. The
+// rows are shared by the replicas, so their columns describe the database as seen by the replicas:
+//   - connected: the database is served by at least one replica, which is connected to the etcd cluster.
+//   - leader: the database is connected, and its leadership is held, by default a connected database is led.
+//   - index: the etcd revision of the last modification of the database, it is not reported for the _Server database.
+//
+// The rows are updated in etcd, so the monitors of the _Server database are notified by their etcd watches. A replica,
+// which loses the etcd cluster, cannot update the rows, its clients learn about it from their connections.
+type DatabaseStatus struct {
+	log      logr.Logger
+	cli      *clientv3.Client
+	db       Databaser
+	interval time.Duration
+
+	mu sync.Mutex
+	// reports whether the leadership of the database is held
+	leader func(dbName string) bool
+}
+
+func NewDatabaseStatus(cli *clientv3.Client, db Databaser, interval time.Duration, log logr.Logger) *DatabaseStatus {
+	return &DatabaseStatus{
+		log:      log.WithName("db-status"),
+		cli:      cli,
+		db:       db,
+		interval: interval,
+		leader:   func(string) bool { return true },
+	}
+}
+
+// SetLeader sets the function, which reports whether the leadership of a database is held
+func (ds *DatabaseStatus) SetLeader(leader func(dbName string) bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.leader = leader
+}
+
+// Start runs the updates in the background until the given context is done.
+func (ds *DatabaseStatus) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(ds.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := ds.Update(ctx); err != nil {
+					ds.log.Error(err, "update failed")
+				}
+			}
+		}
+	}()
+}
+
+// Update writes the status columns of the _Server.Database rows, which are changed, and returns the names of the
+// updated databases. It fails if the etcd cluster has no leader. A row, which is modified concurrently, e.g. by
+// another replica, is updated by the next call.
+func (ds *DatabaseStatus) Update(ctx context.Context) ([]string, error) {
+	// the requests fail rather than wait, while the etcd cluster has no leader
+	ctx = clientv3.WithRequireLeader(ctx)
+	states, err := GetReplicaStates(ctx, ds.cli)
+	if err != nil {
+		return nil, err
+	}
+	connected := false
+	for _, state := range states {
+		if state == REPLICA_SERVING {
+			connected = true
+			break
+		}
+	}
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	resp, err := ds.cli.Get(tctx, common.NewTableKey(INT_SERVER, INT_DATABASES).String(), clientv3.WithPrefix())
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	ds.mu.Lock()
+	leader := ds.leader
+	ds.mu.Unlock()
+	schemas := ds.db.GetSchemas()
+	updated := []string{}
+	for _, kv := range resp.Kvs {
+		row := map[string]interface{}{}
+		if err := json.Unmarshal(kv.Value, &row); err != nil {
+			return updated, err
+		}
+		dbName, _ := row["name"].(string)
+		if _, ok := schemas[dbName]; !ok {
+			// a database of another replica
+			continue
+		}
+		status := map[string]interface{}{
+			"connected": connected,
+			"leader":    connected && leader(dbName),
+		}
+		if dbName != INT_SERVER {
+			index, err := ds.lastRevision(ctx, dbName)
+			if err != nil {
+				return updated, err
+			}
+			status["index"] = libovsdb.OvsSet{}
+			if index > 0 {
+				status["index"] = index
+			}
+		}
+		changed := false
+		for column, value := range status {
+			if !ds.columnEqual(row[column], value) {
+				row[column] = value
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		row[COL_VERSION] = libovsdb.UUID{GoUUID: uuid.NewString()}
+		data, err := json.Marshal(row)
+		if err != nil {
+			return updated, err
+		}
+		tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+		res, err := ds.cli.Txn(tctx).If(clientv3.Compare(clientv3.ModRevision(string(kv.Key)), "=", kv.ModRevision)).
+			Then(clientv3.OpPut(string(kv.Key), string(data))).Commit()
+		cancel()
+		if err != nil {
+			return updated, err
+		}
+		if !res.Succeeded {
+			ds.log.V(5).Info("database row was modified concurrently", "dbName", dbName)
+			continue
+		}
+		ds.log.V(3).Info("database status", "dbName", dbName, "status", status)
+		updated = append(updated, dbName)
+	}
+	return updated, nil
+}
+
+// lastRevision returns the revision of the last modification of the database keys, or 0 for an empty database
+func (ds *DatabaseStatus) lastRevision(ctx context.Context, dbName string) (int64, error) {
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	defer cancel()
+	resp, err := ds.cli.Get(tctx, common.NewDBPrefixKey(dbName).String(), append(clientv3.WithLastRev(),
+		clientv3.WithPrefix(), clientv3.WithKeysOnly())...)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, nil
+	}
+	return resp.Kvs[0].ModRevision, nil
+}
+
+// columnEqual compares the stored value of a status column with its new value, the stored values are unmarshaled JSON
+func (ds *DatabaseStatus) columnEqual(stored, value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		b, _ := stored.(bool)
+		return b == v
+	case int64:
+		f, ok := stored.(float64)
+		return ok && int64(f) == v
+	case libovsdb.OvsSet:
+		// an empty optional column is stored as an empty set, or omitted
+		if stored == nil {
+			return true
+		}
+		set, ok := stored.([]interface{})
+		if !ok || len(set) != 2 {
+			return false
+		}
+		elements, ok := set[1].([]interface{})
+		return ok && len(elements) == 0
+	}
+	return false
+}
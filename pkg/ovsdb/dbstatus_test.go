@@ -0,0 +1,81 @@
+package ovsdb
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func TestDatabaseStatus(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	ctx := context.Background()
+	db, _ := NewDatabaseEtcd(cli)
+	schemaFile := filepath.Join(t.TempDir(), "rbac.ovsschema")
+	assert.Nil(t, ioutil.WriteFile(schemaFile, []byte(testSchemaRBACJSON), 0644))
+	assert.Nil(t, db.AddSchema(schemaFile))
+	serverKey := common.NewDataKey(INT_SERVER, INT_DATABASES, "rbac")
+	readRow := func() map[string]interface{} {
+		resp, err := cli.Get(ctx, serverKey.String())
+		assert.Nil(t, err)
+		row, err := unmarshalData(resp.Kvs[0].Value)
+		assert.Nil(t, err)
+		return row
+	}
+	version := readRow()[COL_VERSION]
+	ds := NewDatabaseStatus(cli, db, time.Second, klogr.New())
+
+	// no replica serves the database
+	updated, err := ds.Update(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rbac"}, updated)
+	row := readRow()
+	assert.Equal(t, false, row["connected"])
+	assert.Equal(t, false, row["leader"])
+	assert.Equal(t, []interface{}{"set", []interface{}{}}, row["index"])
+	assert.NotEqual(t, version, row[COL_VERSION])
+
+	lr, err := NewLifecycleReporter(ctx, cli, common.GenerateUUID(), klogr.New())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer lr.Close(ctx)
+	assert.Nil(t, lr.SetState(ctx, REPLICA_SERVING))
+	txn := testRBACTransact(t, nil, `[{"op": "insert", "table": "Chassis", "row": {"name": "ch1"}}]`)
+	assert.Nil(t, txn.response.Error)
+	chassis, err := cli.Get(ctx, common.NewTableKey("rbac", "Chassis").String(), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	updated, err = ds.Update(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rbac"}, updated)
+	row = readRow()
+	assert.Equal(t, true, row["connected"])
+	assert.Equal(t, true, row["leader"])
+	assert.Equal(t, float64(chassis.Kvs[0].ModRevision), row["index"])
+
+	// the unchanged rows are not written
+	updated, err = ds.Update(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, updated)
+
+	ds.SetLeader(func(dbName string) bool { return dbName != "rbac" })
+	updated, err = ds.Update(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rbac"}, updated)
+	row = readRow()
+	assert.Equal(t, true, row["connected"])
+	assert.Equal(t, false, row["leader"])
+}
@@ -0,0 +1,108 @@
+package ovsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DeliveredUpdate is the last update delivered to a monitor, the initial data or a notification. Clients watchdogs can
+// compare it with the current revision to detect stalled update streams, note that the revisions are shared by all the
+// databases, so the current revision can advance without any change of the monitored data.
+type DeliveredUpdate struct {
+	LastTxnID       string    `json:"last-txn-id"`
+	Revision        int64     `json:"revision"`
+	CurrentRevision int64     `json:"current-revision"`
+	Delivered       time.Time `json:"delivered"`
+}
+
+// deliveredUpdates holds the last delivered revisions of the monitors of a connection
+type deliveredUpdates struct {
+	mu sync.Mutex
+	// json-value string to the last delivered update
+	updates map[string]DeliveredUpdate
+}
+
+// set records the delivered revision of the monitor, the revision of a new monitor replaces the one of a removed
+// monitor with the same json-value.
+func (du *deliveredUpdates) set(jsonValueString string, revision int64, initial bool) {
+	du.mu.Lock()
+	defer du.mu.Unlock()
+	if du.updates == nil {
+		du.updates = map[string]DeliveredUpdate{}
+	}
+	if last, ok := du.updates[jsonValueString]; ok && !initial && last.Revision > revision {
+		return
+	}
+	du.updates[jsonValueString] = DeliveredUpdate{Revision: revision, Delivered: time.Now()}
+}
+
+func (du *deliveredUpdates) get(jsonValueString string) (DeliveredUpdate, bool) {
+	du.mu.Lock()
+	defer du.mu.Unlock()
+	update, ok := du.updates[jsonValueString]
+	return update, ok
+}
+
+func (du *deliveredUpdates) remove(jsonValueString string) {
+	du.mu.Lock()
+	defer du.mu.Unlock()
+	delete(du.updates, jsonValueString)
+}
+
+// LastDelivered returns the last update delivered to a monitor of the connection.
+// "params": [<json-value>]
+// Returns: "result": {"last-txn-id": <txn-id>, "revision": <revision>, "current-revision": <revision>, "delivered": <time>}
+func (ch *Handler) LastDelivered(ctx context.Context, params []interface{}) (interface{}, error) {
+	ch.logger().V(5).Info("last delivered request", "params", params)
+	if len(params) != 1 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(params))
+	}
+	return ch.lastDelivered(params[0])
+}
+
+func (ch *Handler) lastDelivered(jsonValue interface{}) (*DeliveredUpdate, error) {
+	jsonValueString := jsonValueToString(jsonValue)
+	ch.mu.Lock()
+	monitorData, ok := ch.handlerMonitorData[jsonValueString]
+	ch.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown monitor")
+	}
+	update, ok := ch.delivered.get(jsonValueString)
+	if !ok {
+		return nil, errors.New("the monitor has not delivered any update")
+	}
+	// an empty transaction returns the current revision
+	resp, err := ch.db.GetData(nil)
+	if err != nil {
+		ch.logger().Error(err, "current revision", "jsonValue", jsonValue)
+		return nil, err
+	}
+	update.CurrentRevision = resp.Header.Revision
+	update.LastTxnID = ch.txnID(monitorData.dataBaseName, update.Revision)
+	return &update, nil
+}
+
+// LastDelivered returns the last update delivered to a monitor of a specific client.
+// "params": [<client-address>, <json-value>]
+// Returns: "result": {"last-txn-id": <txn-id>, "revision": <revision>, "current-revision": <revision>, "delivered": <time>}
+func (a *Admin) LastDelivered(ctx context.Context, params []interface{}) (interface{}, error) {
+	a.log.V(5).Info("last delivered request", "params", params)
+	if len(params) != 2 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(params))
+	}
+	client, ok := params[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong client address %v", params[0])
+	}
+	for _, ch := range a.getHandlers() {
+		if ch.GetClientAddress() != client {
+			continue
+		}
+		return ch.lastDelivered(params[1])
+	}
+	return nil, fmt.Errorf("unknown client %s", client)
+}
@@ -0,0 +1,62 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func TestLastDelivered(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	db := &testMonitorDB{schemas: testMonitorCondSchemas(), revision: 10}
+	db.kvs = []*mvccpb.KeyValue{testMonitorCondRow(t, "u1", "a", 1, 1)}
+	handler := NewHandler(context.Background(), db, nil, klogr.New())
+	handler.SetConnection(&jrpcServerRecorder{}, nil)
+	var params []interface{}
+	err := json.Unmarshal([]byte(`["dbName", "monid", {"T1": [{"columns": ["name"]}]}]`), &params)
+	assert.Nil(t, err)
+	_, err = handler.MonitorCond(context.Background(), params)
+	assert.Nil(t, err)
+
+	// the initial data
+	result, err := handler.LastDelivered(context.Background(), []interface{}{"monid"})
+	assert.Nil(t, err)
+	update := result.(*DeliveredUpdate)
+	assert.Equal(t, int64(10), update.Revision)
+	assert.Equal(t, int64(10), update.CurrentRevision)
+	assert.Equal(t, txnIDOf(ovsjson.ZERO_UUID, 10), update.LastTxnID)
+
+	// a notification
+	db.revision = 13
+	prev := testMonitorCondRow(t, "u1", "a", 1, 1)
+	modified := testMonitorCondRow(t, "u1", "b", 1, 12)
+	modified.CreateRevision = 1
+	handler.monitors[DB_NAME].notify([]*clientv3.Event{{Type: mvccpb.PUT, PrevKv: prev, Kv: modified}}, 12, nil)
+	assert.Nil(t, handler.FlushNotifications(context.Background()))
+	update, err = handler.lastDelivered("monid")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), update.Revision)
+	assert.Equal(t, int64(13), update.CurrentRevision)
+
+	// an admin query of the client
+	admin := NewAdmin(db, klogr.New())
+	admin.AddHandler(handler)
+	result, err = admin.LastDelivered(context.Background(), []interface{}{handler.GetClientAddress(), "monid"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), result.(*DeliveredUpdate).Revision)
+
+	_, err = handler.MonitorCancel(context.Background(), "monid")
+	assert.Nil(t, err)
+	_, err = handler.LastDelivered(context.Background(), []interface{}{"monid"})
+	assert.NotNil(t, err)
+	_, err = admin.LastDelivered(context.Background(), []interface{}{"unknown", "monid"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,355 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+const (
+	// the tabular output of "ovsdb-client dump"
+	DUMP_FORMAT_TABLE = "table"
+	// the output of "ovsdb-client --format=json dump"
+	DUMP_FORMAT_JSON = "json"
+)
+
+// the strings, which look like uuids, are quoted by ovsdb-client
+var dumpUUIDRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Dump returns all the tables and the rows of the database, which are read at a single etcd revision, in the output
+// format of ovsdb-client dump. The columns, which are hidden from the requesting client, are not dumped.
+// "params": [<db-name>, <format>]  <format> is optional, "table" (the default) or "json"
+// Returns: "result": {"revision": <revision>, "dump": <the ovsdb-client dump output>}
+func (a *Admin) Dump(ctx context.Context, params []interface{}) (interface{}, error) {
+	a.log.V(5).Info("dump request", "params", params)
+	if len(params) != 1 && len(params) != 2 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(params))
+	}
+	dbName, ok := params[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong database name %v", params[0])
+	}
+	format := DUMP_FORMAT_TABLE
+	if len(params) == 2 {
+		format, ok = params[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("wrong dump format %v", params[1])
+		}
+	}
+	schemas := a.db.GetSchemas()
+	dbName = ResolveDatabaseName(schemas, dbName)
+	dbSchema, ok := schemas[dbName]
+	if !ok {
+		return nil, fmt.Errorf("unknown database")
+	}
+	redacted := map[string]map[string]bool{}
+	if ch := a.requester(ctx); ch != nil {
+		ch.mu.Lock()
+		for table := range dbSchema.Tables {
+			redacted[table] = ch.redactedColumns(dbName, table)
+		}
+		ch.mu.Unlock()
+	}
+	resp, err := a.db.GetKeyData(common.NewDBPrefixKey(dbName), false)
+	if err != nil {
+		a.log.Error(err, "dump failed", "dbName", dbName)
+		return nil, err
+	}
+	var dump strings.Builder
+	if err := dumpRows(dbSchema, resp.Kvs, redacted, format, &dump); err != nil {
+		a.log.Error(err, "dump failed", "dbName", dbName)
+		return nil, err
+	}
+	return map[string]interface{}{"revision": resp.Header.Revision, "dump": dump.String()}, nil
+}
+
+// DumpDatabase writes all the tables and the rows of the database, which are read at a single etcd revision, to the
+// writer in the output format of ovsdb-client dump, and returns the etcd revision of the rows.
+func DumpDatabase(ctx context.Context, cli *clientv3.Client, dbSchema *libovsdb.DatabaseSchema, format string, w io.Writer) (int64, error) {
+	resp, err := cli.Get(ctx, common.NewDBPrefixKey(dbSchema.Name).String(), clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	if err := dumpRows(dbSchema, resp.Kvs, nil, format, w); err != nil {
+		return 0, err
+	}
+	return resp.Header.Revision, nil
+}
+
+// dumpTable is a table of the json dump format
+type dumpTable struct {
+	Caption  string          `json:"caption"`
+	Data     [][]interface{} `json:"data"`
+	Headings []string        `json:"headings"`
+}
+
+// dumpRows writes the tables of the schema, sorted by their names, with the _uuid and the columns sorted by their
+// names, and the rows sorted by their column values. The keys, which are not rows of the schema tables, and the
+// redacted columns (table -> column -> true) are skipped.
+func dumpRows(dbSchema *libovsdb.DatabaseSchema, kvs []*mvccpb.KeyValue, redacted map[string]map[string]bool, format string, w io.Writer) error {
+	if format != DUMP_FORMAT_TABLE && format != DUMP_FORMAT_JSON {
+		return fmt.Errorf("wrong dump format %q, expected %q or %q", format, DUMP_FORMAT_TABLE, DUMP_FORMAT_JSON)
+	}
+	rows := map[string][]map[string]interface{}{}
+	for _, kv := range kvs {
+		key, err := common.ParseKey(string(kv.Key))
+		if err != nil || key.UUID == "" {
+			continue
+		}
+		if _, ok := dbSchema.Tables[key.TableName]; !ok {
+			continue
+		}
+		row, err := unmarshalData(kv.Value)
+		if err != nil {
+			return fmt.Errorf("key %s: %v", string(kv.Key), err)
+		}
+		redactRow(row, redacted[key.TableName])
+		rows[key.TableName] = append(rows[key.TableName], row)
+	}
+	tables := make([]string, 0, len(dbSchema.Tables))
+	for table := range dbSchema.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for i, table := range tables {
+		tableSchema := dbSchema.Tables[table]
+		columns := make([]string, 0, len(tableSchema.Columns))
+		for column := range tableSchema.Columns {
+			if !redacted[table][column] {
+				columns = append(columns, column)
+			}
+		}
+		sort.Strings(columns)
+		// the cells of the _uuid and the columns
+		cells := make([][]string, 0, len(rows[table]))
+		data := make([][]interface{}, 0, len(rows[table]))
+		for _, row := range rows[table] {
+			rowCells := make([]string, 0, len(columns)+1)
+			rowData := make([]interface{}, 0, len(columns)+1)
+			uuid, _ := getUUID(row)
+			rowCells = append(rowCells, uuid)
+			rowData = append(rowData, row[COL_UUID])
+			for _, column := range columns {
+				value, ok := row[column]
+				if !ok {
+					value = dumpDefault(tableSchema.Columns[column])
+				}
+				rowCells = append(rowCells, dumpDatum(tableSchema.Columns[column], value))
+				rowData = append(rowData, value)
+			}
+			cells = append(cells, rowCells)
+			data = append(data, rowData)
+		}
+		order := make([]int, len(cells))
+		for j := range order {
+			order[j] = j
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			x, y := cells[order[a]], cells[order[b]]
+			for c := 1; c <= len(columns); c++ {
+				if x[c] != y[c] {
+					return x[c] < y[c]
+				}
+			}
+			return x[0] < y[0]
+		})
+		headings := append([]string{COL_UUID}, columns...)
+		caption := table + " table"
+
+		if format == DUMP_FORMAT_JSON {
+			jsonTable := dumpTable{Caption: caption, Headings: headings, Data: make([][]interface{}, 0, len(data))}
+			for _, j := range order {
+				jsonTable.Data = append(jsonTable.Data, data[j])
+			}
+			line, err := json.Marshal(jsonTable)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
+				return err
+			}
+			continue
+		}
+		widths := make([]int, len(headings))
+		for c, heading := range headings {
+			widths[c] = len(heading)
+		}
+		for _, rowCells := range cells {
+			for c, cell := range rowCells {
+				if len(cell) > widths[c] {
+					widths[c] = len(cell)
+				}
+			}
+		}
+		var out strings.Builder
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		out.WriteString(caption + "\n")
+		dashes := make([]string, len(widths))
+		for c, width := range widths {
+			dashes[c] = strings.Repeat("-", width)
+		}
+		dumpLine(&out, headings, widths)
+		dumpLine(&out, dashes, widths)
+		for _, j := range order {
+			dumpLine(&out, cells[j], widths)
+		}
+		if _, err := io.WriteString(w, out.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpLine writes a line of the tabular format, the cells are aligned to the column widths
+func dumpLine(out *strings.Builder, cells []string, widths []int) {
+	line := make([]string, len(cells))
+	for c, cell := range cells {
+		line[c] = fmt.Sprintf("%-*s", widths[c], cell)
+	}
+	out.WriteString(strings.TrimRight(strings.Join(line, " "), " ") + "\n")
+}
+
+// dumpDefault returns the default value of a column, which is missing in a stored row, in the JSON notation
+func dumpDefault(columnSchema *libovsdb.ColumnSchema) interface{} {
+	typeObj := columnSchema.TypeObj
+	if typeObj != nil && typeObj.Value != nil {
+		return []interface{}{libovsdb.TypeMap, []interface{}{}}
+	}
+	if typeObj != nil && typeObj.Min == 0 {
+		return []interface{}{libovsdb.TypeSet, []interface{}{}}
+	}
+	atomType := columnSchema.Type
+	if typeObj != nil {
+		atomType = typeObj.Key.Type
+	}
+	switch atomType {
+	case libovsdb.TypeInteger, libovsdb.TypeReal:
+		return float64(0)
+	case libovsdb.TypeBoolean:
+		return false
+	case libovsdb.TypeUUID:
+		return []interface{}{libovsdb.TypeUUID, "00000000-0000-0000-0000-000000000000"}
+	}
+	return ""
+}
+
+// dumpDatum returns the text of a column value as ovsdb-client does: the sets in brackets, unless the column is a
+// scalar, and the maps in braces, with the elements sorted
+func dumpDatum(columnSchema *libovsdb.ColumnSchema, value interface{}) string {
+	keyType, valueType := columnSchema.Type, ""
+	typeObj := columnSchema.TypeObj
+	if typeObj != nil {
+		keyType = typeObj.Key.Type
+		if typeObj.Value != nil {
+			valueType = typeObj.Value.Type
+		}
+	}
+	if valueType != "" {
+		pairs := notationElements(value, libovsdb.TypeMap)
+		sort.SliceStable(pairs, func(i, j int) bool {
+			return dumpAtomLess(dumpPairKey(pairs[i]), dumpPairKey(pairs[j]))
+		})
+		texts := make([]string, 0, len(pairs))
+		for _, p := range pairs {
+			if pair, ok := p.([]interface{}); ok && len(pair) == 2 {
+				texts = append(texts, dumpAtom(keyType, pair[0])+"="+dumpAtom(valueType, pair[1]))
+			}
+		}
+		return "{" + strings.Join(texts, ", ") + "}"
+	}
+	elements := notationElements(value, libovsdb.TypeSet)
+	scalar := typeObj == nil || (typeObj.Max != libovsdb.Unlimited && typeObj.Max <= 1)
+	if scalar && len(elements) == 1 {
+		return dumpAtom(keyType, elements[0])
+	}
+	sort.SliceStable(elements, func(i, j int) bool {
+		return dumpAtomLess(elements[i], elements[j])
+	})
+	texts := make([]string, 0, len(elements))
+	for _, element := range elements {
+		texts = append(texts, dumpAtom(keyType, element))
+	}
+	return "[" + strings.Join(texts, ", ") + "]"
+}
+
+func dumpPairKey(p interface{}) interface{} {
+	if pair, ok := p.([]interface{}); ok && len(pair) == 2 {
+		return pair[0]
+	}
+	return nil
+}
+
+// dumpAtomLess orders the atoms of a set or the keys of a map
+func dumpAtomLess(a, b interface{}) bool {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			return x < y
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			return !x && y
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return x < y
+		}
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// dumpAtom returns the text of an atom: the uuids without their notation, and the strings quoted if they are empty,
+// are not identifiers, or look like booleans or uuids
+func dumpAtom(atomType string, atom interface{}) string {
+	switch value := atom.(type) {
+	case string:
+		if dumpNeedsQuotes(value) {
+			quoted, _ := json.Marshal(value)
+			return string(quoted)
+		}
+		return value
+	case float64:
+		if atomType == libovsdb.TypeInteger {
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	case []interface{}:
+		if len(value) == 2 && (value[0] == libovsdb.TypeUUID || value[0] == "named-uuid") {
+			return fmt.Sprint(value[1])
+		}
+	}
+	text, _ := json.Marshal(atom)
+	return string(text)
+}
+
+func dumpNeedsQuotes(s string) bool {
+	if s == "" || s == "true" || s == "false" || dumpUUIDRegexp.MatchString(s) {
+		return true
+	}
+	for i, c := range s {
+		letter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+		if i == 0 && !letter {
+			return true
+		}
+		if !letter && !(c >= '0' && c <= '9') && c != '-' && c != '.' {
+			return true
+		}
+	}
+	return false
+}
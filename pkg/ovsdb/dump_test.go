@@ -0,0 +1,131 @@
+package ovsdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func TestDumpDatum(t *testing.T) {
+	schema := testSchemaRBAC(t)
+	chassis := schema.Tables["Chassis"]
+	role := schema.Tables["RBAC_Role"]
+	permission := schema.Tables["RBAC_Permission"]
+	uuid := "3b2a40c7-4a4f-4d7e-8f4a-8ce9c8a2f4c1"
+	for _, tc := range []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"identifier", dumpDatum(chassis.Columns["name"], "ch-1.a"), "ch-1.a"},
+		{"empty string", dumpDatum(chassis.Columns["name"], ""), `""`},
+		{"string with spaces", dumpDatum(chassis.Columns["name"], "a b"), `"a b"`},
+		{"boolean string", dumpDatum(chassis.Columns["name"], "true"), `"true"`},
+		{"uuid string", dumpDatum(chassis.Columns["name"], uuid), `"` + uuid + `"`},
+		{"boolean", dumpDatum(permission.Columns["insert_delete"], true), "true"},
+		{"empty set", dumpDatum(permission.Columns["update"], []interface{}{"set", []interface{}{}}), "[]"},
+		{"set of one element", dumpDatum(permission.Columns["update"], "b"), "[b]"},
+		{"set", dumpDatum(permission.Columns["update"], []interface{}{"set", []interface{}{"b", "a"}}), "[a, b]"},
+		{"empty map", dumpDatum(chassis.Columns["external_ids"], []interface{}{"map", []interface{}{}}), "{}"},
+		{"map", dumpDatum(chassis.Columns["external_ids"], []interface{}{"map", []interface{}{
+			[]interface{}{"k2", "v 2"}, []interface{}{"k1", "v1"}}}), `{k1=v1, k2="v 2"}`},
+		{"map of uuids", dumpDatum(role.Columns["permissions"], []interface{}{"map", []interface{}{
+			[]interface{}{"Chassis", []interface{}{"uuid", uuid}}}}), "{Chassis=" + uuid + "}"},
+	} {
+		assert.Equal(t, tc.expected, tc.text, tc.name)
+	}
+}
+
+func TestAdminDump(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	txn := testRBACTransact(t, nil, `[
+		{"op": "insert", "table": "Chassis", "row": {"name": "ch2", "hostname": "host 2"}},
+		{"op": "insert", "table": "Chassis", "row": {"name": "ch1", "external_ids": ["map", [["k", "v"]]]}}
+	]`)
+	assert.Nil(t, txn.response.Error)
+	uuid1 := txn.response.Result[1].UUID.GoUUID
+	uuid2 := txn.response.Result[0].UUID.GoUUID
+	cli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	db, _ := NewDatabaseEtcd(cli)
+	db.(*DatabaseEtcd).Schemas.Add(testSchemaRBAC(t))
+	admin := NewAdmin(db, klogr.New())
+
+	resp, err := admin.Dump(context.Background(), []interface{}{"rbac"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	result := resp.(map[string]interface{})
+	assert.NotZero(t, result["revision"])
+	assert.Equal(t, strings.Join([]string{
+		"Chassis table",
+		"_uuid                                external_ids hostname name",
+		"------------------------------------ ------------ -------- ----",
+		uuid1 + " {k=v}        \"\"       ch1",
+		uuid2 + " {}           \"host 2\" ch2",
+		"",
+		"RBAC_Permission table",
+		"_uuid authorization insert_delete table update",
+		"----- ------------- ------------- ----- ------",
+		"",
+		"RBAC_Role table",
+		"_uuid name permissions",
+		"----- ---- -----------",
+		"",
+	}, "\n"), result["dump"])
+
+	var buf bytes.Buffer
+	_, err = DumpDatabase(context.Background(), cli, testSchemaRBAC(t), DUMP_FORMAT_JSON, &buf)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(t, lines, 3) {
+		table := dumpTable{}
+		assert.Nil(t, json.Unmarshal([]byte(lines[0]), &table))
+		assert.Equal(t, "Chassis table", table.Caption)
+		assert.Equal(t, []string{COL_UUID, "external_ids", "hostname", "name"}, table.Headings)
+		if assert.Len(t, table.Data, 2) {
+			assert.Equal(t, []interface{}{"uuid", uuid1}, table.Data[0][0])
+			assert.Equal(t, "ch1", table.Data[0][3])
+		}
+	}
+
+	// the aliases are resolved
+	SetDatabaseAliases(map[string]string{"rbacdb": "rbac"})
+	defer SetDatabaseAliases(map[string]string{})
+	resp, err = admin.Dump(context.Background(), []interface{}{"rbacdb"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, result["dump"], resp.(map[string]interface{})["dump"])
+	}
+
+	// the redacted columns are not dumped
+	kvs, err := db.GetKeyData(common.NewDBPrefixKey("rbac"), false)
+	if !assert.Nil(t, err) {
+		return
+	}
+	buf.Reset()
+	redacted := map[string]map[string]bool{"Chassis": {"external_ids": true}}
+	assert.Nil(t, dumpRows(testSchemaRBAC(t), kvs.Kvs, redacted, DUMP_FORMAT_TABLE, &buf))
+	assert.Equal(t, strings.Join([]string{
+		"Chassis table",
+		"_uuid                                hostname name",
+		"------------------------------------ -------- ----",
+		uuid1 + " \"\"       ch1",
+		uuid2 + " \"host 2\" ch2",
+	}, "\n"), strings.SplitN(buf.String(), "\n\n", 2)[0])
+
+	_, err = admin.Dump(context.Background(), []interface{}{"rbac", "csv"})
+	assert.NotNil(t, err)
+	_, err = admin.Dump(context.Background(), []interface{}{"unknown"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,272 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/hints"
+)
+
+// LeaderElectionTTL is the time-to-live in seconds of the candidacy of a replica, after a failure of the leader replica
+// another replica is elected when it expires
+var LeaderElectionTTL = 10
+
+// the time a replica waits before it runs again for the leadership, after it failed to connect to etcd
+var electionRetryInterval = time.Second
+
+// LeaderCandidate describes a replica, which runs for the leadership of a database
+type LeaderCandidate struct {
+	Replica string `json:"replica"`
+	// the remote of the replica, which clients can connect to, e.g. "tcp:10.0.0.1:6641", empty if not known
+	Remote string `json:"remote,omitempty"`
+}
+
+// LeaderElection campaigns for the leadership of the served databases, a replica per database is elected by an etcd
+// election, so the replicas, which serve the same databases, can run in the active/standby mode of the clustered
+// ovsdb-server. A leader, which loses its etcd lease, e.g. it was disconnected from etcd, loses the leadership, and runs
+// for it again.
+type LeaderElection struct {
+	log       logr.Logger
+	cli       *clientv3.Client
+	candidate LeaderCandidate
+	// called when the replica is elected or loses the leadership of a database
+	onChange func(dbName string, leader bool)
+
+	// the context of the sessions, it's canceled after the last session is closed, so the lease is revoked on Close
+	sessionCtx    context.Context
+	sessionCancel context.CancelFunc
+
+	mu      sync.Mutex
+	session *concurrency.Session
+	// the databases, whose leadership is run for, to the elections, which were won by the replica, or nil
+	elected map[string]*concurrency.Election
+	cancel  context.CancelFunc
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func NewLeaderElection(cli *clientv3.Client, candidate LeaderCandidate, log logr.Logger) *LeaderElection {
+	sessionCtx, sessionCancel := context.WithCancel(context.Background())
+	return &LeaderElection{
+		log:           log.WithName("election"),
+		cli:           cli,
+		candidate:     candidate,
+		onChange:      func(string, bool) {},
+		sessionCtx:    sessionCtx,
+		sessionCancel: sessionCancel,
+		elected:       map[string]*concurrency.Election{},
+	}
+}
+
+// SetOnChange sets the function, which is called when the replica is elected or loses the leadership of a database,
+// it should be called before Start
+func (le *LeaderElection) SetOnChange(onChange func(dbName string, leader bool)) {
+	le.onChange = onChange
+}
+
+// Start runs for the leadership of the given databases in the background, until Close is called or the given context
+// is done, it should be called once
+func (le *LeaderElection) Start(ctx context.Context, dbNames []string) {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	ctx, le.cancel = context.WithCancel(ctx)
+	for _, dbName := range dbNames {
+		if _, ok := le.elected[dbName]; ok {
+			continue
+		}
+		le.elected[dbName] = nil
+		le.wg.Add(1)
+		go func(dbName string) {
+			defer le.wg.Done()
+			le.campaign(ctx, dbName)
+		}(dbName)
+	}
+}
+
+func (le *LeaderElection) campaign(ctx context.Context, dbName string) {
+	for ctx.Err() == nil {
+		session, err := le.getSession()
+		if err != nil {
+			le.log.Error(err, "failed to create the election session", "dbName", dbName)
+			le.sleep(ctx, electionRetryInterval)
+			continue
+		}
+		value, err := json.Marshal(le.candidate)
+		if err != nil {
+			le.log.Error(err, "failed to marshal the candidate", "dbName", dbName)
+			return
+		}
+		election := concurrency.NewElection(session, common.NewElectionKey(dbName).String())
+		// the campaign is stopped, if the lease expires while the replica waits for the leadership
+		cctx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-session.Done():
+				cancel()
+			case <-cctx.Done():
+			}
+		}()
+		err = election.Campaign(cctx, string(value))
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				le.log.Error(err, "campaign failed", "dbName", dbName)
+				le.sleep(ctx, electionRetryInterval)
+			}
+			continue
+		}
+		le.setElected(dbName, election)
+		le.log.Info("elected the database leader", "dbName", dbName)
+		le.onChange(dbName, true)
+		select {
+		case <-ctx.Done():
+			return
+		case <-session.Done():
+		}
+		le.setElected(dbName, nil)
+		le.log.Info("lost the database leadership", "dbName", dbName)
+		le.onChange(dbName, false)
+	}
+}
+
+// getSession returns the session of the candidacies, a new session is created if the lease of the previous one expired
+func (le *LeaderElection) getSession() (*concurrency.Session, error) {
+	le.mu.Lock()
+	if session := le.liveSession(); session != nil {
+		le.mu.Unlock()
+		return session, nil
+	}
+	le.mu.Unlock()
+	// the lock is not held while the lease is granted, so Close isn't blocked by a disconnected etcd
+	session, err := concurrency.NewSession(le.cli, concurrency.WithTTL(LeaderElectionTTL),
+		concurrency.WithContext(le.sessionCtx))
+	if err != nil {
+		return nil, err
+	}
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	if le.closed {
+		session.Close()
+		return nil, errors.New("the leader election is closed")
+	}
+	// another campaign created a session concurrently
+	if current := le.liveSession(); current != nil {
+		session.Close()
+		return current, nil
+	}
+	le.session = session
+	return session, nil
+}
+
+// liveSession returns the session, if its lease is not expired, it should be called under the lock
+func (le *LeaderElection) liveSession() *concurrency.Session {
+	if le.session == nil {
+		return nil
+	}
+	select {
+	case <-le.session.Done():
+		return nil
+	default:
+		return le.session
+	}
+}
+
+func (le *LeaderElection) setElected(dbName string, election *concurrency.Election) {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	le.elected[dbName] = election
+}
+
+func (le *LeaderElection) sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// IsLeader returns whether the replica leads the database, the databases, which are not run for, e.g. _Server, are led
+// by every replica
+func (le *LeaderElection) IsLeader(dbName string) bool {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	election, ok := le.elected[dbName]
+	return !ok || election != nil
+}
+
+// Leader returns the replica, which leads the database, or nil if the database has no leader
+func (le *LeaderElection) Leader(ctx context.Context, dbName string) (*LeaderCandidate, error) {
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	defer cancel()
+	// the leader is the oldest candidate, per concurrency.Election
+	resp, err := le.cli.Get(tctx, common.NewElectionKey(dbName).String()+common.KEY_DELIMETER,
+		clientv3.WithFirstCreate()...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	leader := &LeaderCandidate{}
+	if err := json.Unmarshal(resp.Kvs[0].Value, leader); err != nil {
+		return nil, err
+	}
+	return leader, nil
+}
+
+// HasLeader returns whether the database is led by any replica, it's false if the leader cannot be read from etcd
+func (le *LeaderElection) HasLeader(dbName string) bool {
+	leader, err := le.Leader(context.Background(), dbName)
+	if err != nil {
+		le.log.V(5).Info("failed to read the database leader", "dbName", dbName, "err", err)
+		return false
+	}
+	return leader != nil
+}
+
+// notLeaderError returns the error of a write transaction, which is sent to a replica, which doesn't lead the database.
+// The error hints the remote of the leader, if it's known.
+func (le *LeaderElection) notLeaderError(ctx context.Context, dbName string) error {
+	hint := hints.NewHint(hints.REASON_NOT_LEADER, 0)
+	if leader, err := le.Leader(ctx, dbName); err == nil && leader != nil {
+		hint.Leader = leader.Remote
+	}
+	return hint.Error(E_NOT_LEADER)
+}
+
+// Close stops the campaigns and gives up the leadership of the databases, so another replica is elected without
+// waiting for the candidacies to expire
+func (le *LeaderElection) Close() error {
+	le.mu.Lock()
+	if le.closed {
+		le.mu.Unlock()
+		return nil
+	}
+	le.closed = true
+	if le.cancel != nil {
+		le.cancel()
+	}
+	session := le.session
+	le.session = nil
+	le.mu.Unlock()
+	var err error
+	if session != nil {
+		// revoking the lease deletes the candidacies
+		err = session.Close()
+	}
+	le.sessionCancel()
+	le.wg.Wait()
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	for dbName := range le.elected {
+		le.elected[dbName] = nil
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package ovsdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/hints"
+)
+
+func TestLeaderElection(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	ctx := context.Background()
+	changes := make(chan string, 10)
+	first := NewLeaderElection(cli, LeaderCandidate{Replica: "r1", Remote: "tcp:10.0.0.1:6641"}, klogr.New())
+	first.SetOnChange(func(dbName string, leader bool) {
+		if leader {
+			changes <- dbName
+		}
+	})
+	defer first.Close()
+	leader, err := first.Leader(ctx, "rbac")
+	assert.Nil(t, err)
+	assert.Nil(t, leader)
+	assert.True(t, first.IsLeader("rbac"), "a database, which is not run for, is led by every replica")
+
+	first.Start(ctx, []string{"rbac"})
+	select {
+	case dbName := <-changes:
+		assert.Equal(t, "rbac", dbName)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the first replica is not elected")
+		return
+	}
+	assert.True(t, first.IsLeader("rbac"))
+	assert.True(t, first.HasLeader("rbac"))
+
+	second := NewLeaderElection(cli, LeaderCandidate{Replica: "r2"}, klogr.New())
+	defer second.Close()
+	second.Start(ctx, []string{"rbac"})
+	assert.False(t, second.IsLeader("rbac"))
+	leader, err = second.Leader(ctx, "rbac")
+	assert.Nil(t, err)
+	assert.Equal(t, &LeaderCandidate{Replica: "r1", Remote: "tcp:10.0.0.1:6641"}, leader)
+	hint, ok := hints.FromError(second.notLeaderError(ctx, "rbac"))
+	if assert.True(t, ok) {
+		assert.Equal(t, hints.REASON_NOT_LEADER, hint.Reason)
+		assert.Equal(t, "tcp:10.0.0.1:6641", hint.Leader)
+	}
+
+	// the second replica takes over when the leader gives up the leadership
+	assert.Nil(t, first.Close())
+	assert.False(t, first.IsLeader("rbac"))
+	assert.Eventually(t, func() bool { return second.IsLeader("rbac") }, 5*time.Second, 10*time.Millisecond)
+	leader, err = first.Leader(ctx, "rbac")
+	assert.Nil(t, err)
+	assert.Equal(t, &LeaderCandidate{Replica: "r2"}, leader)
+}
@@ -0,0 +1,305 @@
+package ovsdb
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// The etcd calls, which faults can be injected into
+const (
+	FAULT_OP_GET    = "get"
+	FAULT_OP_PUT    = "put"
+	FAULT_OP_DELETE = "delete"
+	FAULT_OP_TXN    = "txn"
+	FAULT_OP_WATCH  = "watch"
+)
+
+// Fault is injected into the matching etcd calls of a client
+type Fault struct {
+	// Op is the faulty call, all the calls if empty
+	Op string
+	// KeyPrefix selects the calls of the keys with the prefix, all the keys if empty. A transaction matches if any of
+	// its compares or operations matches.
+	KeyPrefix string
+	// Skip is the number of the matching calls, which pass before the fault is injected
+	Skip int
+	// Times is the number of the faulty calls, unlimited if 0
+	Times int
+	// Delay is added before the call
+	Delay time.Duration
+	// Before is called before the call, e.g. to write a concurrent modification of the read rows
+	Before func()
+	// Err is returned instead of the call response, a watch is closed without events
+	Err error
+	// Committed executes a failed put, delete or transaction before the error is returned, as if its response was lost
+	Committed bool
+	// CompactRevision cancels a watch as compacted at the revision
+	CompactRevision int64
+}
+
+type faultRule struct {
+	Fault
+	matched  int
+	injected int
+}
+
+// FaultInjector injects latency, errors and partial failures into the etcd calls of a client, so tests can
+// deterministically cover the retry, resync and conflict handling.
+type FaultInjector struct {
+	mu     sync.Mutex
+	faults []*faultRule
+	calls  map[string]int
+}
+
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{calls: map[string]int{}}
+}
+
+// Add adds a fault, the first matching fault is injected into a call
+func (fi *FaultInjector) Add(f Fault) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.faults = append(fi.faults, &faultRule{Fault: f})
+}
+
+// Reset removes the faults and the calls counters
+func (fi *FaultInjector) Reset() {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.faults = nil
+	fi.calls = map[string]int{}
+}
+
+// Calls returns the number of the calls of the operation
+func (fi *FaultInjector) Calls(op string) int {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.calls[op]
+}
+
+// Inject replaces the KV and the Watcher of the client with the faulty ones, the returned function restores them
+func (fi *FaultInjector) Inject(cli *clientv3.Client) func() {
+	kv, watcher := cli.KV, cli.Watcher
+	cli.KV = &faultKV{KV: kv, fi: fi}
+	cli.Watcher = &faultWatcher{Watcher: watcher, fi: fi}
+	return func() {
+		cli.KV, cli.Watcher = kv, watcher
+	}
+}
+
+// fault returns the fault of the call, after its delay and hook, nil if no fault matches
+func (fi *FaultInjector) fault(ctx context.Context, op string, keys []string) (*Fault, error) {
+	fi.mu.Lock()
+	fi.calls[op]++
+	var fault *Fault
+	for _, rule := range fi.faults {
+		if !rule.matches(op, keys) {
+			continue
+		}
+		rule.matched++
+		if rule.matched <= rule.Skip || (rule.Times > 0 && rule.injected >= rule.Times) {
+			continue
+		}
+		rule.injected++
+		f := rule.Fault
+		fault = &f
+		break
+	}
+	fi.mu.Unlock()
+	if fault == nil {
+		return nil, nil
+	}
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if fault.Before != nil {
+		fault.Before()
+	}
+	return fault, nil
+}
+
+func (rule *faultRule) matches(op string, keys []string) bool {
+	if rule.Op != "" && rule.Op != op {
+		return false
+	}
+	if rule.KeyPrefix == "" {
+		return true
+	}
+	for _, key := range keys {
+		if strings.HasPrefix(key, rule.KeyPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// call executes the call, unless the fault fails it
+func (f *Fault) call(call func() error) error {
+	if f == nil || f.Err == nil {
+		return call()
+	}
+	if f.Committed {
+		if err := call(); err != nil {
+			return err
+		}
+	}
+	return f.Err
+}
+
+type faultKV struct {
+	clientv3.KV
+	fi *FaultInjector
+}
+
+func (kv *faultKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	f, err := kv.fi.fault(ctx, FAULT_OP_GET, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	var resp *clientv3.GetResponse
+	err = f.call(func() (err error) {
+		resp, err = kv.KV.Get(ctx, key, opts...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (kv *faultKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	f, err := kv.fi.fault(ctx, FAULT_OP_PUT, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	var resp *clientv3.PutResponse
+	err = f.call(func() (err error) {
+		resp, err = kv.KV.Put(ctx, key, val, opts...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (kv *faultKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	f, err := kv.fi.fault(ctx, FAULT_OP_DELETE, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	var resp *clientv3.DeleteResponse
+	err = f.call(func() (err error) {
+		resp, err = kv.KV.Delete(ctx, key, opts...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (kv *faultKV) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	var faultOp string
+	switch {
+	case op.IsGet():
+		faultOp = FAULT_OP_GET
+	case op.IsPut():
+		faultOp = FAULT_OP_PUT
+	case op.IsDelete():
+		faultOp = FAULT_OP_DELETE
+	default:
+		faultOp = FAULT_OP_TXN
+	}
+	f, err := kv.fi.fault(ctx, faultOp, []string{string(op.KeyBytes())})
+	if err != nil {
+		return clientv3.OpResponse{}, err
+	}
+	var resp clientv3.OpResponse
+	err = f.call(func() (err error) {
+		resp, err = kv.KV.Do(ctx, op)
+		return err
+	})
+	if err != nil {
+		return clientv3.OpResponse{}, err
+	}
+	return resp, nil
+}
+
+func (kv *faultKV) Txn(ctx context.Context) clientv3.Txn {
+	return &faultTxn{Txn: kv.KV.Txn(ctx), ctx: ctx, fi: kv.fi}
+}
+
+// faultTxn collects the keys of the transaction, the fault is injected into its commit
+type faultTxn struct {
+	clientv3.Txn
+	ctx  context.Context
+	fi   *FaultInjector
+	keys []string
+}
+
+func (txn *faultTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	for i := range cs {
+		txn.keys = append(txn.keys, string(cs[i].KeyBytes()))
+	}
+	txn.Txn = txn.Txn.If(cs...)
+	return txn
+}
+
+func (txn *faultTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	for _, op := range ops {
+		txn.keys = append(txn.keys, string(op.KeyBytes()))
+	}
+	txn.Txn = txn.Txn.Then(ops...)
+	return txn
+}
+
+func (txn *faultTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	for _, op := range ops {
+		txn.keys = append(txn.keys, string(op.KeyBytes()))
+	}
+	txn.Txn = txn.Txn.Else(ops...)
+	return txn
+}
+
+func (txn *faultTxn) Commit() (*clientv3.TxnResponse, error) {
+	f, err := txn.fi.fault(txn.ctx, FAULT_OP_TXN, txn.keys)
+	if err != nil {
+		return nil, err
+	}
+	var resp *clientv3.TxnResponse
+	err = f.call(func() (err error) {
+		resp, err = txn.Txn.Commit()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+type faultWatcher struct {
+	clientv3.Watcher
+	fi *FaultInjector
+}
+
+func (w *faultWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	f, err := w.fi.fault(ctx, FAULT_OP_WATCH, []string{key})
+	if err == nil && (f == nil || (f.Err == nil && f.CompactRevision == 0)) {
+		return w.Watcher.Watch(ctx, key, opts...)
+	}
+	wch := make(chan clientv3.WatchResponse, 1)
+	if f != nil && f.CompactRevision != 0 {
+		wch <- clientv3.WatchResponse{CompactRevision: f.CompactRevision, Canceled: true}
+	}
+	close(wch)
+	return wch
+}
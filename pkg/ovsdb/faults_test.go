@@ -0,0 +1,268 @@
+package ovsdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+func TestFaultInjector(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	ctx := context.Background()
+	fi := NewFaultInjector()
+	restore := fi.Inject(cli)
+	defer restore()
+	injected := errors.New("injected")
+
+	// the second get of the key fails
+	fi.Add(Fault{Op: FAULT_OP_GET, KeyPrefix: "a", Skip: 1, Times: 1, Err: injected})
+	_, err = cli.Get(ctx, "a")
+	assert.Nil(t, err)
+	_, err = cli.Get(ctx, "b")
+	assert.Nil(t, err)
+	_, err = cli.Get(ctx, "a")
+	assert.Equal(t, injected, err)
+	_, err = cli.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, fi.Calls(FAULT_OP_GET))
+
+	// the response of the committed transaction is lost
+	fi.Reset()
+	fi.Add(Fault{Op: FAULT_OP_TXN, KeyPrefix: "a", Err: injected, Committed: true})
+	_, err = cli.Txn(ctx).Then(clientv3.OpPut("a", "1")).Commit()
+	assert.Equal(t, injected, err)
+	_, err = cli.Txn(ctx).Then(clientv3.OpPut("b", "1")).Commit()
+	assert.Nil(t, err)
+	resp, err := cli.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(resp.Kvs))
+
+	fi.Reset()
+	fi.Add(Fault{Op: FAULT_OP_PUT, Delay: 50 * time.Millisecond, Err: injected})
+	start := time.Now()
+	_, err = cli.Put(ctx, "c", "1")
+	assert.Equal(t, injected, err)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	_, err = cli.Do(ctx, clientv3.OpPut("c", "1"))
+	assert.Equal(t, injected, err)
+	tctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	_, err = cli.Put(tctx, "c", "1")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	fi.Reset()
+	// the first watch is compacted, the next one fails
+	fi.Add(Fault{Op: FAULT_OP_WATCH, Times: 1, CompactRevision: 5})
+	fi.Add(Fault{Op: FAULT_OP_WATCH, Err: injected})
+	wresp, ok := <-cli.Watch(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, rpctypes.ErrCompacted, wresp.Err())
+	_, ok = <-cli.Watch(ctx, "a")
+	assert.False(t, ok)
+
+	// the client calls are restored
+	restore()
+	_, err = cli.Put(ctx, "c", "1")
+	assert.Nil(t, err)
+}
+
+func TestTransactInjectedFaults(t *testing.T) {
+	SetConflictPolicy(CommutativeColumns{"set.table1.string": true})
+	defer SetConflictPolicy(nil)
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	fi := NewFaultInjector()
+	defer fi.Inject(cli)()
+
+	key := common.GenerateDataKey("set", "table1")
+	put := func(values ...interface{}) {
+		row := map[string]interface{}{"string": libovsdb.OvsSet{GoSet: values}}
+		setRowUUID(&row, key.UUID)
+		setRowVersion(&row)
+		val, err := makeValue(&row)
+		assert.Nil(t, err)
+		_, err = cli.KV.Put(context.TODO(), key.String(), val)
+		assert.Nil(t, err)
+	}
+	get := func() []interface{} {
+		res, err := cli.Get(context.TODO(), key.String())
+		assert.Nil(t, err)
+		row, err := unmarshalData(res.Kvs[0].Value)
+		assert.Nil(t, err)
+		assert.Nil(t, testSchemaSet.Unmarshal("table1", &row))
+		return row["string"].(libovsdb.OvsSet).GoSet
+	}
+	transact := func(value string) error {
+		table := "table1"
+		mutations := []interface{}{[]interface{}{"string", MT_INSERT, value}}
+		where := []interface{}{[]interface{}{COL_UUID, "==", []interface{}{"uuid", key.UUID}}}
+		txn := NewTransaction(cli, klogr.New(), &libovsdb.Transact{
+			DBName:     "set",
+			Operations: []libovsdb.Operation{{Op: OP_MUTATE, Table: &table, Where: &where, Mutations: &mutations}},
+		})
+		txn.AddSchema(testSchemaSet)
+		_, err := txn.Commit()
+		return err
+	}
+	put("a")
+
+	// a concurrent modification between the read and the write of the transaction is merged
+	fi.Add(Fault{Op: FAULT_OP_TXN, Skip: 1, Times: 1, Before: func() { put("a", "c") }})
+	assert.Nil(t, transact("b"))
+	assert.ElementsMatch(t, []interface{}{"a", "b", "c"}, get())
+
+	// the concurrent modifications of all the attempts fail the transaction
+	fi.Reset()
+	fi.Add(Fault{Op: FAULT_OP_TXN, Skip: 1, Before: func() { put("a") }})
+	err = transact("d")
+	assert.NotNil(t, err)
+	assert.Equal(t, E_TXN_CONFLICT, err.Error())
+	assert.ElementsMatch(t, []interface{}{"a"}, get())
+
+	// an etcd failure fails the transaction
+	fi.Reset()
+	fi.Add(Fault{Op: FAULT_OP_TXN, Err: rpctypes.ErrTimeout})
+	err = transact("e")
+	assert.NotNil(t, err)
+	assert.Equal(t, E_IO_ERROR, err.Error())
+	assert.ElementsMatch(t, []interface{}{"a"}, get())
+}
+
+func TestTransactConflictRetry(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	fi := NewFaultInjector()
+	defer fi.Inject(cli)()
+
+	key := common.GenerateDataKey("set", "table1")
+	put := func(values ...interface{}) {
+		row := map[string]interface{}{"string": libovsdb.OvsSet{GoSet: values}}
+		setRowUUID(&row, key.UUID)
+		setRowVersion(&row)
+		val, err := makeValue(&row)
+		assert.Nil(t, err)
+		_, err = cli.KV.Put(context.TODO(), key.String(), val)
+		assert.Nil(t, err)
+	}
+	get := func() []interface{} {
+		res, err := cli.Get(context.TODO(), key.String())
+		assert.Nil(t, err)
+		row, err := unmarshalData(res.Kvs[0].Value)
+		assert.Nil(t, err)
+		assert.Nil(t, testSchemaSet.Unmarshal("table1", &row))
+		return row["string"].(libovsdb.OvsSet).GoSet
+	}
+	transact := func(value string) error {
+		table := "table1"
+		mutations := []interface{}{[]interface{}{"string", MT_INSERT, value}}
+		where := []interface{}{[]interface{}{COL_UUID, "==", []interface{}{"uuid", key.UUID}}}
+		txn := NewTransaction(cli, klogr.New(), &libovsdb.Transact{
+			DBName:     "set",
+			Operations: []libovsdb.Operation{{Op: OP_MUTATE, Table: &table, Where: &where, Mutations: &mutations}},
+		})
+		txn.AddSchema(testSchemaSet)
+		_, err := txn.Commit()
+		return err
+	}
+	put("a")
+
+	// the concurrent modification isn't overwritten, the transaction is executed again on the current row
+	fi.Add(Fault{Op: FAULT_OP_TXN, Skip: 1, Times: 1, Before: func() { put("a", "c") }})
+	assert.Nil(t, transact("b"))
+	assert.ElementsMatch(t, []interface{}{"a", "b", "c"}, get())
+
+	// the concurrent modifications of all the executions fail the transaction
+	fi.Reset()
+	fi.Add(Fault{Op: FAULT_OP_TXN, Skip: 1, Before: func() { put("a") }})
+	err = transact("d")
+	assert.NotNil(t, err)
+	assert.Equal(t, E_TXN_CONFLICT, err.Error())
+	assert.ElementsMatch(t, []interface{}{"a"}, get())
+}
+
+func TestTransactReadConflicts(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	fi := NewFaultInjector()
+	defer fi.Inject(cli)()
+
+	table := "table1"
+	put := func(key common.Key, key1 string) {
+		row := map[string]interface{}{"key1": key1}
+		setRowUUID(&row, key.UUID)
+		setRowVersion(&row)
+		val, err := makeValue(&row)
+		assert.Nil(t, err)
+		_, err = cli.KV.Put(context.TODO(), key.String(), val)
+		assert.Nil(t, err)
+	}
+	count := func(key1 string) int {
+		res, err := cli.Get(context.TODO(), common.NewTableKey("simple", table).String(), clientv3.WithPrefix())
+		assert.Nil(t, err)
+		n := 0
+		for _, kv := range res.Kvs {
+			row, err := unmarshalData(kv.Value)
+			assert.Nil(t, err)
+			if row["key1"] == key1 {
+				n++
+			}
+		}
+		return n
+	}
+	transact := func(ops ...libovsdb.Operation) (*Transaction, error) {
+		txn := NewTransaction(cli, klogr.New(), &libovsdb.Transact{DBName: "simple", Operations: ops})
+		txn.AddSchema(testSchemaSimple)
+		_, err := txn.Commit()
+		return txn, err
+	}
+	insert := func(key1 string) libovsdb.Operation {
+		return libovsdb.Operation{Op: OP_INSERT, Table: &table, Row: &map[string]interface{}{"key1": key1}}
+	}
+
+	// the row read by the wait is modified before the commit, the wait fails when the transaction is executed again
+	key := common.GenerateDataKey("simple", table)
+	put(key, "x")
+	timeout := 0
+	until := FN_EQ
+	where := []interface{}{[]interface{}{"key1", FN_EQ, "x"}}
+	wait := libovsdb.Operation{Op: OP_WAIT, Table: &table, Where: &where, Columns: &[]string{"key1"},
+		Until: &until, Timeout: &timeout, Rows: &[]map[string]interface{}{{"key1": "x"}}}
+	fi.Add(Fault{Op: FAULT_OP_TXN, Skip: 1, Times: 1, Before: func() { put(key, "y") }})
+	_, err = transact(wait, insert("after-wait"))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, count("after-wait"))
+
+	// a row matching the update is created before the commit, the transaction is executed again and updates it
+	fi.Reset()
+	where = []interface{}{[]interface{}{"key1", FN_EQ, "z"}}
+	update := libovsdb.Operation{Op: OP_UPDATE, Table: &table, Where: &where, Row: &map[string]interface{}{"key2": 1}}
+	fi.Add(Fault{Op: FAULT_OP_TXN, Skip: 1, Times: 1, Before: func() { put(common.GenerateDataKey("simple", table), "z") }})
+	txn, err := transact(update, insert("after-update"))
+	assert.Nil(t, err)
+	if assert.NotNil(t, txn.response.Result[0].Count) {
+		assert.Equal(t, 1, *txn.response.Result[0].Count)
+	}
+	assert.Equal(t, 1, count("after-update"))
+}
@@ -0,0 +1,334 @@
+package ovsdb
+
+import (
+	"errors"
+	"sort"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// The rows of the non-root tables, which are inserted without a strong reference, or lose their last strong reference
+// by the changes of a transaction, are deleted at the end of the transaction (RFC 7047, section 3.2). As by
+// ovsdb-server, only the rows referenced by the changed rows are collected, so the rows, which reference each other
+// without a reference from the root tables, are kept. The databases without root tables are not collected.
+
+func hasRootTables(databaseSchema *libovsdb.DatabaseSchema) bool {
+	for _, tableSchema := range databaseSchema.Tables {
+		if tableSchema.IsRoot {
+			return true
+		}
+	}
+	return false
+}
+
+// garbageCollector finds the rows, which are left without strong references by the changes of a transaction. The
+// referencing rows of the inserted rows are written by the transaction, the referencing tables of the stored rows are
+// read.
+type garbageCollector struct {
+	txn            *Transaction
+	dbName         string
+	databaseSchema *libovsdb.DatabaseSchema
+	columns        []refColumn
+	// the final values of the rows written by the transaction, nil if deleted, and their stored values
+	written  map[string]map[string]interface{}
+	prev     map[string]map[string]interface{}
+	inserted map[string]bool
+	// the stored rows, which were read, and the tables, which were read entirely
+	stored  map[string]*mvccpb.KeyValue
+	scanned map[string]bool
+	// the referencing rows of the rows after the changes of the transaction
+	referrers map[string]map[string]bool
+	// the existing candidates, which were checked, and the ones found as garbage
+	checked map[string]bool
+	garbage map[string]bool
+}
+
+// collectGarbage deletes the rows, which are left without strong references by the changes of the transaction. The
+// rows inserted by the transaction are not inserted, and the modified ones are deleted instead. The deletion of a
+// stored row is guarded by its revision and by the revisions of its referencing tables, so a concurrent transaction
+// cannot add a reference to the deleted row.
+func (txn *Transaction) collectGarbage() error {
+	databaseSchema, ok := txn.schemas[txn.request.DBName]
+	if !ok || !hasRootTables(databaseSchema) {
+		return nil
+	}
+	gc := &garbageCollector{
+		txn:            txn,
+		dbName:         txn.request.DBName,
+		databaseSchema: databaseSchema,
+		columns:        strongRefColumns(databaseSchema),
+		written:        map[string]map[string]interface{}{},
+		prev:           map[string]map[string]interface{}{},
+		inserted:       map[string]bool{},
+		stored:         map[string]*mvccpb.KeyValue{},
+		scanned:        map[string]bool{},
+		referrers:      map[string]map[string]bool{},
+		checked:        map[string]bool{},
+		garbage:        map[string]bool{},
+	}
+	candidates, err := gc.candidates()
+	if err != nil {
+		return err
+	}
+	for len(candidates) > 0 {
+		if err := gc.read(candidates); err != nil {
+			return err
+		}
+		next := []string{}
+		for _, keyStr := range candidates {
+			next = append(next, gc.collect(keyStr)...)
+		}
+		candidates = next
+	}
+	orphans := make([]string, 0, len(gc.garbage))
+	for keyStr := range gc.garbage {
+		orphans = append(orphans, keyStr)
+	}
+	sort.Strings(orphans)
+	for _, keyStr := range orphans {
+		txn.log.V(5).Info("garbage collection", "key", keyStr)
+		if err := txn.deleteOrphan(keyStr, gc.stored[keyStr]); err != nil {
+			return err
+		}
+	}
+	txn.etcd.Assert()
+	return nil
+}
+
+// candidates returns the rows of the non-root tables, which may be left without strong references: the inserted rows,
+// and the rows whose references were removed by a modification or a deletion of a row.
+func (gc *garbageCollector) candidates() ([]string, error) {
+	candidates := []string{}
+	for _, ev := range gc.txn.etcd.Events {
+		var prevRow, row map[string]interface{}
+		var keyStr string
+		var err error
+		if ev.PrevKv != nil {
+			if prevRow, err = unmarshalData(ev.PrevKv.Value); err != nil {
+				continue
+			}
+		}
+		if ev.Type == clientv3.EventTypeDelete {
+			keyStr = string(ev.PrevKv.Key)
+		} else {
+			keyStr = string(ev.Kv.Key)
+			if row, err = unmarshalData(ev.Kv.Value); err != nil {
+				return nil, errors.New(E_INTERNAL_ERROR)
+			}
+		}
+		key, err := common.ParseKey(keyStr)
+		if err != nil {
+			continue
+		}
+		gc.written[keyStr] = row
+		if ev.PrevKv == nil {
+			gc.inserted[keyStr] = true
+			if !gc.isRoot(key.TableName) {
+				candidates = append(candidates, keyStr)
+			}
+		} else {
+			gc.prev[keyStr] = prevRow
+		}
+		gc.addReferrer(keyStr, key.TableName, row)
+		refs := map[rowRef]bool{}
+		for _, r := range gc.refs(key.TableName, row) {
+			refs[r] = true
+		}
+		for _, r := range gc.refs(key.TableName, prevRow) {
+			if !refs[r] && !gc.isRoot(r.table) {
+				candidates = append(candidates, common.NewDataKey(gc.dbName, r.table, r.uuid).String())
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// read reads the stored candidates, which were not written by the transaction, and the tables, which reference the
+// stored candidates. The referencing tables are guarded by their revisions.
+func (gc *garbageCollector) read(candidates []string) error {
+	rows := []string{}
+	tables := []string{}
+	added := map[string]bool{}
+	for _, keyStr := range candidates {
+		if gc.inserted[keyStr] || gc.garbage[keyStr] {
+			continue
+		}
+		key, err := common.ParseKey(keyStr)
+		if err != nil {
+			continue
+		}
+		if _, ok := gc.written[keyStr]; !ok && !gc.scanned[key.TableName] && !added[keyStr] {
+			added[keyStr] = true
+			rows = append(rows, keyStr)
+		}
+		for i := range gc.columns {
+			ref := &gc.columns[i]
+			if ref.keyRefTable != key.TableName && ref.valueRefTable != key.TableName {
+				continue
+			}
+			if !gc.scanned[ref.table] && !added[ref.table] {
+				added[ref.table] = true
+				tables = append(tables, ref.table)
+			}
+		}
+	}
+	if len(rows) == 0 && len(tables) == 0 {
+		return nil
+	}
+	sort.Strings(rows)
+	sort.Strings(tables)
+	read := NewEtcd(gc.txn.etcd)
+	read.Clear()
+	for _, keyStr := range rows {
+		read.Then = append(read.Then, clientv3.OpGet(keyStr))
+	}
+	for _, table := range tables {
+		read.Then = append(read.Then, clientv3.OpGet(common.NewTableKey(gc.dbName, table).String(), clientv3.WithPrefix()))
+	}
+	if err := read.Commit(); err != nil {
+		err = errors.New(E_IO_ERROR)
+		gc.txn.log.Error(err, "garbage collection reads")
+		return err
+	}
+	for i, resp := range read.Res.Responses {
+		for _, kv := range resp.GetResponseRange().Kvs {
+			keyStr := string(kv.Key)
+			gc.stored[keyStr] = kv
+			if _, ok := gc.written[keyStr]; ok || i < len(rows) {
+				continue
+			}
+			row, err := unmarshalData(kv.Value)
+			if err != nil {
+				continue
+			}
+			gc.addReferrer(keyStr, tables[i-len(rows)], row)
+		}
+	}
+	revision := read.Res.Header.Revision
+	for _, table := range tables {
+		gc.scanned[table] = true
+		// no row of the referencing table is created or modified before the commit
+		tableKey := common.NewTableKey(gc.dbName, table).String()
+		gc.txn.etcd.If = append(gc.txn.etcd.If, clientv3.Compare(clientv3.ModRevision(tableKey), "<", revision+1).WithPrefix())
+	}
+	return nil
+}
+
+// collect marks the candidate as garbage, if it exists after the changes of the transaction, and no other row
+// references it. Returns the rows referenced by the garbage, which may be left without references too.
+func (gc *garbageCollector) collect(keyStr string) []string {
+	if gc.garbage[keyStr] {
+		return nil
+	}
+	key, err := common.ParseKey(keyStr)
+	if err != nil {
+		return nil
+	}
+	row, ok := gc.written[keyStr]
+	if !ok {
+		kv, ok := gc.stored[keyStr]
+		if !ok {
+			return nil
+		}
+		if row, err = unmarshalData(kv.Value); err != nil {
+			return nil
+		}
+	}
+	if row == nil {
+		return nil
+	}
+	gc.checked[keyStr] = true
+	for referrer := range gc.referrers[keyStr] {
+		if !gc.garbage[referrer] {
+			return nil
+		}
+	}
+	gc.garbage[keyStr] = true
+	// the references of the stored row are removed, the other rows referenced by the garbage are rechecked, if they
+	// were kept by its reference
+	storedRefs := map[rowRef]bool{}
+	if !gc.inserted[keyStr] {
+		prevRow, ok := gc.prev[keyStr]
+		if !ok {
+			prevRow = row
+		}
+		for _, r := range gc.refs(key.TableName, prevRow) {
+			storedRefs[r] = true
+		}
+	}
+	candidates := []string{}
+	for _, r := range append(gc.refs(key.TableName, row), gc.refs(key.TableName, gc.prev[keyStr])...) {
+		refKey := common.NewDataKey(gc.dbName, r.table, r.uuid).String()
+		if gc.isRoot(r.table) || gc.garbage[refKey] {
+			continue
+		}
+		if storedRefs[r] || gc.inserted[refKey] || gc.checked[refKey] {
+			candidates = append(candidates, refKey)
+		}
+	}
+	return candidates
+}
+
+// addReferrer records the strong references of the row
+func (gc *garbageCollector) addReferrer(keyStr, table string, row map[string]interface{}) {
+	for _, r := range gc.refs(table, row) {
+		refKey := common.NewDataKey(gc.dbName, r.table, r.uuid).String()
+		if gc.referrers[refKey] == nil {
+			gc.referrers[refKey] = map[string]bool{}
+		}
+		gc.referrers[refKey][keyStr] = true
+	}
+}
+
+// refs returns the strong references of the row of the table
+func (gc *garbageCollector) refs(table string, row map[string]interface{}) []rowRef {
+	if row == nil {
+		return nil
+	}
+	refs := []rowRef{}
+	for i := range gc.columns {
+		if gc.columns[i].table == table {
+			refs = append(refs, gc.columns[i].refs(row)...)
+		}
+	}
+	return refs
+}
+
+func (gc *garbageCollector) isRoot(table string) bool {
+	tableSchema, ok := gc.databaseSchema.Tables[table]
+	return !ok || tableSchema.IsRoot
+}
+
+// deleteOrphan replaces the write of the row by the transaction with its deletion, a row inserted by the transaction
+// is not written at all
+func (txn *Transaction) deleteOrphan(key string, kv *mvccpb.KeyValue) error {
+	for i, ev := range txn.etcd.Events {
+		if ev.Kv == nil || string(ev.Kv.Key) != key {
+			continue
+		}
+		for j, op := range txn.etcd.Then {
+			if etcdOpKey(op) == key {
+				txn.etcd.Then = append(txn.etcd.Then[:j], txn.etcd.Then[j+1:]...)
+				break
+			}
+		}
+		txn.etcd.Events = append(txn.etcd.Events[:i], txn.etcd.Events[i+1:]...)
+		if ev.PrevKv == nil {
+			return nil
+		}
+		txn.etcd.Then = append(txn.etcd.Then, clientv3.OpDelete(key))
+		txn.etcd.Events = append(txn.etcd.Events, etcdEventDelete(key, string(ev.PrevKv.Value)))
+		return nil
+	}
+	if kv == nil {
+		return nil
+	}
+	txn.etcd.Then = append(txn.etcd.Then, clientv3.OpDelete(key))
+	txn.etcd.Events = append(txn.etcd.Events, etcdEventDelete(key, string(kv.Value)))
+	txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision))
+	return nil
+}
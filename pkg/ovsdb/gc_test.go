@@ -0,0 +1,93 @@
+package ovsdb
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func TestGarbageCollection(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	resp, _ := testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Logical_Switch_Port", "uuid-name": "lsp1", "row": {"name": "lsp1"}},
+		{"op": "insert", "table": "Logical_Switch_Port", "uuid-name": "lsp2", "row": {"name": "lsp2"}},
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls1", "ports": ["set", [["named-uuid", "lsp1"], ["named-uuid", "lsp2"]]]}}]`)
+	assert.Nil(t, resp.Error)
+	lsp1UUID := resp.Result[0].UUID.GoUUID
+	assert.Equal(t, 2, len(testOvnSelect(t, "OVN_Northbound", "Logical_Switch_Port", `[]`)))
+
+	// an unreferenced row of a non-root table is not inserted
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Logical_Switch_Port", "row": {"name": "lsp3"}}]`)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, 2, len(testOvnSelect(t, "OVN_Northbound", "Logical_Switch_Port", `[]`)))
+
+	// the port is deleted with its last reference
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "mutate", "table": "Logical_Switch", "where": [["name", "==", "ls1"]],
+		 "mutations": [["ports", "delete", ["set", [["uuid", "`+lsp1UUID+`"]]]]]}]`)
+	assert.Nil(t, resp.Error)
+	rows := testOvnSelect(t, "OVN_Northbound", "Logical_Switch_Port", `[]`)
+	if assert.Equal(t, 1, len(rows)) {
+		assert.Equal(t, "lsp2", rows[0]["name"])
+	}
+
+	// the ports are deleted with the switch
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "delete", "table": "Logical_Switch", "where": [["name", "==", "ls1"]]}]`)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, 0, len(testOvnSelect(t, "OVN_Northbound", "Logical_Switch_Port", `[]`)))
+}
+
+// testPrefixCompares returns the tables of the database, whose rows are guarded by a prefix compare of the transaction
+func testPrefixCompares(txn *Transaction, dbName string) []string {
+	prefix := common.NewDBPrefixKey(dbName).String()
+	tables := map[string]bool{}
+	for _, cmp := range txn.etcd.If {
+		if len(cmp.RangeEnd) > 0 && strings.HasPrefix(string(cmp.Key), prefix) {
+			tables[strings.Trim(strings.TrimPrefix(string(cmp.Key), prefix), "/")] = true
+		}
+	}
+	result := []string{}
+	for table := range tables {
+		result = append(result, table)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestGarbageCollectionReads(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	// the referencing rows of the inserted rows are written by the transaction, nothing is read
+	resp, txn := testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Logical_Switch_Port", "uuid-name": "lsp1", "row": {"name": "lsp1"}},
+		{"op": "insert", "table": "Logical_Switch_Port", "uuid-name": "lsp2", "row": {"name": "lsp2"}},
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls1", "ports": ["set", [["named-uuid", "lsp1"], ["named-uuid", "lsp2"]]]}}]`)
+	assert.Nil(t, resp.Error)
+	lsp1UUID := resp.Result[0].UUID.GoUUID
+	assert.Empty(t, testPrefixCompares(txn, "OVN_Northbound"))
+
+	// the port, which lost a reference, is checked against its referencing table only, the port groups are guarded by
+	// the removal of the weak references
+	resp, txn = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "mutate", "table": "Logical_Switch", "where": [["name", "==", "ls1"]],
+		 "mutations": [["ports", "delete", ["set", [["uuid", "`+lsp1UUID+`"]]]]]}]`)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, []string{"Logical_Switch", "Port_Group"}, testPrefixCompares(txn, "OVN_Northbound"))
+	assert.Equal(t, 1, len(testOvnSelect(t, "OVN_Northbound", "Logical_Switch_Port", `[]`)))
+
+	// a port referenced by another switch is kept
+	rows := testOvnSelect(t, "OVN_Northbound", "Logical_Switch_Port", `[]`)
+	lsp2UUID := rows[0][COL_UUID].([]interface{})[1].(string)
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls2", "ports": ["set", [["uuid", "`+lsp2UUID+`"]]]}},
+		{"op": "delete", "table": "Logical_Switch", "where": [["name", "==", "ls1"]]}]`)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, 1, len(testOvnSelect(t, "OVN_Northbound", "Logical_Switch_Port", `[]`)))
+}
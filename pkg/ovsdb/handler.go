@@ -3,17 +3,22 @@ package ovsdb
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"reflect"
 	"sync"
+	"time"
 
 	"github.com/creachadair/jrpc2"
 	"github.com/go-logr/logr"
 	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/hints"
 	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
 	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
 	"github.com/lithammer/shortuuid/v3"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
 	"k8s.io/klog/v2"
@@ -26,6 +31,14 @@ type JrpcServer interface {
 }
 
 type Handler struct {
+	// the unix time in nanoseconds of the last data received from the client, accessed atomically, so it's the first
+	// field for the 64-bit alignment
+	lastActivity int64
+
+	// the logger of the connection without the client identity
+	baseLog logr.Logger
+	logMu   sync.RWMutex
+	// the logger of the connection with the client identity, derived from baseLog on every identity change
 	log logr.Logger
 
 	db         Databaser
@@ -37,21 +50,54 @@ type Handler struct {
 	closed         bool // false by default
 	mu             sync.Mutex
 
+	// writes the notifications to the connection
+	writer *connWriter
+
 	// dbName->dbMonitor
 	monitors map[string]*dbMonitor
 	// json-value string to handler monitor related data
 	handlerMonitorData map[string]handlerMonitorData
 
 	databaseLocks map[string]Locker
+	// the locks of all the clients of the server
+	locks *LockRegistry
+
+	// the suppression rules of the remote of the client, and the tables (dbName -> tables), whose changes are not
+	// sent to the client according to its role
+	suppression      SuppressionRules
+	remote           string
+	suppressedTables map[string]map[string]bool
+
+	// the authenticated client and the authenticator, which produced the identity
+	identity      *Identity
+	authenticator Authenticator
+
+	// limits the monitors and locks of the client, nil for no limits
+	quota *ResourceQuota
+
+	// refuses the write transactions of the databases, which are led by other replicas, nil if every replica writes
+	election *LeaderElection
+
+	// columns, which are hidden from the client according to its role
+	redaction RedactionPolicy
+
+	// the last updates delivered to the monitors
+	delivered deliveredUpdates
+
+	// JSON-RPC id -> cancels the in-flight transact request
+	transactions map[string]context.CancelFunc
+
+	// the connection isn't closed when a database is changed, set by set_db_change_aware
+	dbChangeAware bool
 }
 
 func (ch *Handler) Transact(ctx context.Context, params []interface{}) (interface{}, error) {
-	req := jrpc2.InboundRequest(ctx)
 	id := ""
-	if !req.IsNotification() {
+	// the request is missing when the handler is called directly, not by the jrpc2 server
+	if req := jrpc2.InboundRequest(ctx); req != nil && !req.IsNotification() {
 		id = req.ID()
 	}
-	log := ch.log.WithValues("id", id)
+	log := ch.logger().WithValues("id", id)
 	log.V(5).Info("transact", "params", params)
 	if ch.closed {
 		log.V(5).Info("transact request, the handler is closed")
@@ -62,15 +108,90 @@ func (ch *Handler) Transact(ctx context.Context, params []interface{}) (interfac
 	if err != nil {
 		return nil, err
 	}
+	ovsReq.DBName = ResolveDatabaseName(ch.db.GetSchemas(), ovsReq.DBName)
+	defer func(start time.Time) {
+		observeLatency(serverMetrics, METRIC_TRANSACT_LATENCY_PREFIX+ovsReq.DBName, time.Since(start))
+	}(time.Now())
+	if err := ch.checkRedactedConditions(ovsReq); err != nil {
+		log.Error(err, "transaction rejected", "dbName", ovsReq.DBName)
+		return nil, err
+	}
+	if ch.election != nil && !isReadOnlyTransaction(ovsReq) && !ch.election.IsLeader(ovsReq.DBName) {
+		err := ch.election.notLeaderError(ctx, ovsReq.DBName)
+		log.Error(err, "transaction rejected", "dbName", ovsReq.DBName)
+		return nil, err
+	}
+	tctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	txn := NewTransaction(ch.etcdClient, log, ovsReq)
 	txn.schemas = ch.db.GetSchemas()
-	// temporary solution to provide consistency
-	ch.db.DbLock(ovsReq.DBName)
-	rev, err := txn.Commit()
-	ch.db.DbUnlock(ovsReq.DBName)
+	txn.etcd.Ctx = tctx
+	txn.rowCache = ch.db.RowCache(ovsReq.DBName)
+	ch.mu.Lock()
+	txn.rbac = newRBACClient(txn.schemas[ovsReq.DBName], ch.identity)
+	txn.locks = make(map[string]Locker, len(ch.databaseLocks))
+	for id, locker := range ch.databaseLocks {
+		txn.locks[id] = locker
+	}
+	if id != "" {
+		ch.transactions[id] = cancel
+		defer func() {
+			ch.mu.Lock()
+			delete(ch.transactions, id)
+			ch.mu.Unlock()
+		}()
+	}
+	ch.mu.Unlock()
+
+	// a transaction failed by a "wait" operation with a timeout is executed again after the waited tables are
+	// modified, until the timeout expires or the request is canceled
+	request := copyTransact(&txn.request)
+	tables := transactionTables(ovsReq)
+	start := time.Now()
+	var rev int64
+	// the ticket of the last execution of the transaction, it orders the notifications of the commits
+	var ticket *txnTicket
+	defer func() { ticket.done() }()
+	for {
+		ticket = ch.db.BeginTransaction(ovsReq.DBName, tables, ch)
+		if ch.db.IsFrozen(ovsReq.DBName) && !isReadOnlyTransaction(ovsReq) {
+			ticket.committed(-1)
+			err = rejectionError(E_FROZEN, hints.REASON_FROZEN, FrozenRetryAfter)
+			log.Error(err, "transaction rejected", "dbName", ovsReq.DBName)
+			return nil, err
+		}
+		txn.readOnly = ch.db.IsReadOnly(ovsReq.DBName)
+		txn.serializable = ch.db.IsSerializable(ovsReq.DBName)
+		rev, err = txn.Commit()
+		ticket.committed(rev)
+		timeout := txn.blockedWait()
+		if timeout == 0 {
+			break
+		}
+		ticket.done()
+		if werr := txn.waitChanges(tctx, start.Add(time.Duration(timeout)*time.Millisecond)); werr != nil {
+			if werr.Error() != E_TIMEOUT {
+				err = werr
+			}
+			break
+		}
+		log.V(5).Info("waited tables modified, executing the transaction again")
+		txn.reset(copyTransact(&request))
+	}
 
 	if err != nil {
-		return nil, err
+		if tctx.Err() != nil && ctx.Err() == nil {
+			log.V(5).Info("transaction canceled")
+			return nil, errors.New(E_CANCELED)
+		}
+		if txn.response.Error == nil {
+			return nil, err
+		}
+		// the failure is reported by the error objects of the results, the rows selected by the preceding operations
+		// are redacted as well
+		ch.redactResults(ovsReq, txn.response.Result)
+		log.V(5).Info("transaction failed", "response", txn.response)
+		return txn.response.Results(len(txn.request.Operations)), nil
 	}
 	monitor, ok := ch.monitors[txn.request.DBName]
 	if ok {
@@ -78,42 +199,75 @@ func (ch *Handler) Transact(ctx context.Context, params []interface{}) (interfac
 		// we have to guarantee that a new monitor call if it runs concurrently with the transaction, returns first
 		var wg sync.WaitGroup
 		wg.Add(1)
+		ticket.waitTurn()
 		monitor.notify(txn.etcd.Events, rev, &wg)
+		ticket.done()
 		wg.Wait()
 	}
 
+	ch.redactResults(ovsReq, txn.response.Result)
 	log.V(5).Info("transact response", "response", txn.response)
-	return txn.response.Result, nil
+	return txn.response.Results(len(txn.request.Operations)), nil
 }
 
+// Cancel cancels the in-flight transact request with the given JSON-RPC id (RFC 7047, section 4.1.4), the canceled
+// request is replied by the "canceled" error, unless it's already committed. The cancel request is a notification, so
+// it has no reply.
 func (ch *Handler) Cancel(ctx context.Context, param interface{}) (interface{}, error) {
-	ch.log.V(5).Info("cancel request", "param", param)
-
-	return "{Cancel}", nil
+	ch.logger().V(5).Info("cancel request", "param", param)
+	params, ok := param.([]interface{})
+	if !ok || len(params) != 1 {
+		err := fmt.Errorf("%s: cancel expects the id of the request", E_SYNTAX_ERROR)
+		ch.logger().Error(err, "cancel request", "param", param)
+		return nil, err
+	}
+	id, err := json.Marshal(params[0])
+	if err != nil {
+		return nil, err
+	}
+	ch.mu.Lock()
+	cancel, ok := ch.transactions[string(id)]
+	ch.mu.Unlock()
+	if ok {
+		cancel()
+	} else {
+		ch.logger().V(5).Info("cancel of unknown request", "id", string(id))
+	}
+	return nil, nil
 }
 
+// DisableMonitorV1 refuses the legacy monitor requests, in deployments where all the clients use the update2 or update3
+// notifications.
+var DisableMonitorV1 = false
+
 func (ch *Handler) Monitor(ctx context.Context, params []interface{}) (interface{}, error) {
-	ch.log.V(5).Info("monitor request", "params", params)
-	updatersMap, err := ch.addMonitor(params, ovsjson.Update)
-	if err != nil {
-		ch.log.Error(err, "monitor rquest failed", "params", params)
+	ch.logger().V(5).Info("monitor request", "params", params)
+	if DisableMonitorV1 {
+		err := fmt.Errorf("%s: the monitor method is disabled, use monitor_cond or monitor_cond_since", E_NOT_SUPPORTED)
+		ch.logger().Error(err, "monitor request refused", "params", params)
 		return nil, err
 	}
-	data, err := ch.getMonitoredData(params[0].(string), updatersMap)
-	ch.log.V(5).Info("monitor response", "jsonValue", params[1], "data", data)
+	var data ovsjson.TableUpdates
+	var revision int64
+	_, err := ch.addMonitorWithState(params, ovsjson.Update, func(dbName string, updatersMap Key2Updaters) (int64, error) {
+		var err error
+		data, revision, err = ch.getMonitoredData(dbName, updatersMap)
+		return revision, err
+	})
 	if err != nil {
-		ch.log.Error(err, "failed to get monitored data")
-		ch.removeMonitor(params[1], false)
+		ch.logger().Error(err, "monitor rquest failed", "params", params)
 		return nil, err
 	}
+	ch.logger().V(5).Info("monitor response", "jsonValue", params[1], "data", data)
 	jsonValueString := jsonValueToString(params[1])
+	ch.delivered.set(jsonValueString, revision, true)
 	ch.startNotifier(jsonValueString)
 	return data, nil
 }
 
 func (ch *Handler) MonitorCancel(ctx context.Context, param interface{}) (interface{}, error) {
-	ch.log.V(5).Info("monitorCancel", "param", param)
-	err := ch.removeMonitor(param, true)
+	ch.logger().V(5).Info("monitorCancel", "param", param)
+	err := ch.removeMonitor(param, CANCEL_REASON_CLIENT_REQUEST)
 	if err != nil {
 		return nil, err
 	}
@@ -121,58 +275,116 @@ func (ch *Handler) MonitorCancel(ctx context.Context, param interface{}) (interf
 }
 
 func (ch *Handler) Lock(ctx context.Context, param interface{}) (interface{}, error) {
-	ch.log.V(5).Info("lock request", "param", param)
+	ch.logger().V(5).Info("lock request", "param", param)
 	id, err := common.ParamsToString(param)
 	if err != nil {
 		return map[string]bool{"locked": false}, err
 	}
-	ch.mu.Lock()
-	myLock, ok := ch.databaseLocks[id]
-	ch.mu.Unlock()
-	if !ok {
-		myLock, err = ch.db.GetLock(ch.handlerContext, id)
-		if err != nil {
-			ch.log.Error(err, "lock failed", "lockid", id)
-			return nil, err
-		}
-		ch.mu.Lock()
-		// validate that no other locks
-		otherLock, ok := ch.databaseLocks[id]
-		if !ok {
-			ch.databaseLocks[id] = myLock
-		} else {
-			// What should we do ?
-			myLock.cancel()
-			myLock = otherLock
-		}
-		ch.mu.Unlock()
+	myLock, err := ch.getLocker(id)
+	if err != nil {
+		return nil, err
 	}
 	err = myLock.tryLock()
 	if err == nil {
+		ch.locks.acquired(id, ch, false)
 		return map[string]bool{"locked": true}, nil
 	} else if err != concurrency.ErrLocked {
-		ch.log.Error(err, "lock failed", "lockid", id)
+		ch.logger().Error(err, "lock failed", "lockid", id)
 		// TOD is it correct?
 		return nil, err
 	}
-	go func() {
-		err = myLock.lock()
-		if err == nil {
-			// Send notification
-			ch.log.V(5).Info("lock succeeded", "lockid", id)
-			if err := ch.jrpcServer.Notify(ch.handlerContext, "locked", []string{id}); err != nil {
-				klog.Errorf("notification %v\n", err)
-				return
+	go ch.waitLock(id, myLock)
+	return map[string]bool{"locked": false}, nil
+}
+
+// getLocker returns the lock of the client with the given id, a new lock is created if the client hasn't requested it
+// before
+func (ch *Handler) getLocker(id string) (Locker, error) {
+	var err error
+	ch.mu.Lock()
+	myLock, ok := ch.databaseLocks[id]
+	identity := ch.identityName()
+	if !ok {
+		err = ch.quota.acquire(QUOTA_LOCKS, identity, len(ch.databaseLocks))
+	}
+	ch.mu.Unlock()
+	if err != nil {
+		ch.logger().Error(err, "locks quota exceeded", "lockid", id)
+		return nil, rejectionError(err.Error(), hints.REASON_QUOTA_EXCEEDED, 0)
+	}
+	if ok {
+		return myLock, nil
+	}
+	myLock, err = ch.db.GetLock(ch.handlerContext, id)
+	if err != nil {
+		ch.logger().Error(err, "lock failed", "lockid", id)
+		ch.quota.release(QUOTA_LOCKS, identity, 1)
+		return nil, err
+	}
+	ch.mu.Lock()
+	// validate that no other locks
+	otherLock, ok := ch.databaseLocks[id]
+	if !ok {
+		ch.databaseLocks[id] = myLock
+	} else {
+		// What should we do ?
+		myLock.cancel()
+		myLock = otherLock
+		ch.quota.release(QUOTA_LOCKS, identity, 1)
+	}
+	ch.mu.Unlock()
+	if !ok {
+		ch.locks.add(id, ch, myLock)
+		lockRef := myLock
+		myLock.onStolen(func() {
+			ch.lockStolen(id, lockRef)
+		})
+	}
+	return myLock, nil
+}
+
+// hasLocker returns true if the given lock is still requested by the client
+func (ch *Handler) hasLocker(id string, myLock Locker) bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	current, ok := ch.databaseLocks[id]
+	return ok && current == myLock
+}
+
+// waitLock waits for the lock, and sends the "locked" notification when it is acquired
+func (ch *Handler) waitLock(id string, myLock Locker) {
+	for {
+		err := myLock.lock()
+		switch {
+		case err == nil:
+			ch.locks.acquired(id, ch, true)
+			return
+		case err == errAlreadyAcquired:
+			// the client stole the lock while waiting for it, and it was notified by the steal response
+			return
+		case err == concurrency.ErrSessionExpired && ch.hasLocker(id, myLock):
+			// the key of the waiter was deleted by a steal, the client keeps waiting for the lock
+			ch.logger().V(5).Info("lock wait was interrupted by a steal", "lockid", id)
+		default:
+			if ch.hasLocker(id, myLock) {
+				ch.logger().Error(err, "lock failed", "lockid", id)
 			}
-		} else {
-			ch.log.Error(err, "lock failed", "lockid", id)
+			return
 		}
-	}()
-	return map[string]bool{"locked": false}, nil
+	}
+}
+
+// lockStolen sends the "stolen" notification to the previous owner of the lock, which still waits for the lock
+// (RFC 7047, section 4.1.10)
+func (ch *Handler) lockStolen(id string, myLock Locker) {
+	if !ch.hasLocker(id, myLock) || !ch.locks.stolen(id, ch) {
+		return
+	}
+	ch.waitLock(id, myLock)
 }
 
 func (ch *Handler) Unlock(ctx context.Context, param interface{}) (interface{}, error) {
-	ch.log.V(5).Info("unlock request", "param", param)
+	ch.logger().V(5).Info("unlock request", "param", param)
 	id, err := common.ParamsToString(param)
 	if err != nil {
 		return ovsjson.EmptyStruct{}, err
@@ -180,45 +392,64 @@ func (ch *Handler) Unlock(ctx context.Context, param interface{}) (interface{},
 	ch.mu.Lock()
 	myLock, ok := ch.databaseLocks[id]
 	delete(ch.databaseLocks, id)
+	if ok {
+		ch.quota.release(QUOTA_LOCKS, ch.identityName(), 1)
+	}
 	ch.mu.Unlock()
+	ch.locks.release(id, ch)
 	if !ok {
-		ch.log.V(4).Info("unlock: can't find lock", "lockid", id)
+		ch.logger().V(4).Info("unlock: can't find lock", "lockid", id)
 		return ovsjson.EmptyStruct{}, nil
 	}
 	myLock.cancel()
 	return ovsjson.EmptyStruct{}, nil
 }
 
+// Steal acquires the lock, even if it is held by another client, which is notified by the "stolen" notification. The
+// other clients, which wait for the lock, keep waiting.
 func (ch *Handler) Steal(ctx context.Context, param interface{}) (interface{}, error) {
-	ch.log.V(5).Info("steal request", "param", param)
-	// TODO
-	return "{Steal}", nil
-}
-
-func (ch *Handler) MonitorCond(ctx context.Context, params []interface{}) (interface{}, error) {
-	ch.log.V(5).Info("monitorCond request", "params", params)
-	updatersMap, err := ch.addMonitor(params, ovsjson.Update2)
+	ch.logger().V(5).Info("steal request", "param", param)
+	id, err := common.ParamsToString(param)
+	if err != nil {
+		return map[string]bool{"locked": false}, err
+	}
+	myLock, err := ch.getLocker(id)
 	if err != nil {
-		ch.log.Error(err, "monitorCond from remote")
 		return nil, err
 	}
-	data, err := ch.getMonitoredData(params[0].(string), updatersMap)
-	ch.log.V(5).Info("monitorCond response", "jsonValue", params[1], "data", data)
+	if err = myLock.steal(); err != nil {
+		ch.logger().Error(err, "steal failed", "lockid", id)
+		return nil, err
+	}
+	ch.locks.acquired(id, ch, false)
+	return map[string]bool{"locked": true}, nil
+}
+
+func (ch *Handler) MonitorCond(ctx context.Context, params []interface{}) (interface{}, error) {
+	ch.logger().V(5).Info("monitorCond request", "params", params)
+	var data ovsjson.TableUpdates
+	var revision int64
+	_, err := ch.addMonitorWithState(params, ovsjson.Update2, func(dbName string, updatersMap Key2Updaters) (int64, error) {
+		var err error
+		data, revision, err = ch.getMonitoredData(dbName, updatersMap)
+		return revision, err
+	})
 	if err != nil {
-		ch.log.Error(err, "failed to get monitored data")
-		ch.removeMonitor(params[1], false)
+		ch.logger().Error(err, "monitorCond from remote")
 		return nil, err
 	}
+	ch.logger().V(5).Info("monitorCond response", "jsonValue", params[1], "data", data)
 	jsonValueString := jsonValueToString(params[1])
+	ch.delivered.set(jsonValueString, revision, true)
 	ch.startNotifier(jsonValueString)
 	return data, nil
 }
 
 func (ch *Handler) MonitorCondChange(ctx context.Context, params []interface{}) (interface{}, error) {
-	ch.log.V(5).Info("monitorCondChange request", "params", params)
+	ch.logger().V(5).Info("monitorCondChange request", "params", params)
 	if len(params) != 3 {
 		err := fmt.Errorf("wrong params length for MonitorCondChange %d , params %v", len(params), params)
-		ch.log.Error(err, "monitorCondChange request")
+		ch.logger().Error(err, "monitorCondChange request")
 		return nil, err
 	}
 	oldJsonValue := params[0]
@@ -226,7 +457,7 @@ func (ch *Handler) MonitorCondChange(ctx context.Context, params []interface{})
 	mcrs := map[string][]ovsjson.MonitorCondRequest{}
 	buf, err := json.Marshal(params[2])
 	if err != nil {
-		ch.log.Error(err, "marshal conditional request returned")
+		ch.logger().Error(err, "marshal conditional request returned")
 		return nil, err
 	}
 	if err := json.Unmarshal(buf, &mcrs); err != nil {
@@ -240,70 +471,62 @@ func (ch *Handler) MonitorCondChange(ctx context.Context, params []interface{})
 		}
 	}
 	if reflect.DeepEqual(oldJsonValue, newJsonValue) {
-		ch.log.V(5).Info("MonitorCondChange, update existing monitor")
-		jsonValueString := jsonValueToString(oldJsonValue)
-		ch.mu.Lock()
-		defer ch.mu.Unlock()
-		monitorData, ok := ch.handlerMonitorData[jsonValueString]
-		if !ok {
-			err := fmt.Errorf("unknown monitor")
-			ch.log.Error(err, "update unexisting dbMonitor", "jsonValue", oldJsonValue)
+		ch.logger().V(5).Info("MonitorCondChange, update existing monitor")
+		if err := ch.changeMonitorConditions(jsonValueToString(oldJsonValue), mcrs); err != nil {
+			ch.logger().Error(err, "monitorCondChange failed", "jsonValue", oldJsonValue)
 			return nil, err
 		}
-		dbName := monitorData.dataBaseName
-		monitor, ok := ch.monitors[dbName]
-		if !ok {
-			ch.log.Info("MonitorCondChange there is no monitor", "dbname", monitorData.dataBaseName)
-		}
-		databaseSchema, ok := ch.db.GetSchemas()[dbName]
-		if !ok {
-			return nil, fmt.Errorf("there is no databaseSchema for %s", dbName)
-		}
-		for tableName, mcrArray := range mcrs {
-			key := common.NewTableKey(dbName, tableName)
-			_, ok := monitor.key2Updaters[key]
-			if !ok {
-				ch.log.V(6).Info("MonitorCondChange", "table", tableName, "mcr", mcrArray)
-				var updaters []updater
-				tableSchema, err := databaseSchema.LookupTable(tableName)
-				if err != nil {
-					return nil, err
-				}
-				for _, mcr := range mcrArray {
-					updater := mcrToUpdater(mcr, jsonValueString, tableSchema, monitorData.notificationType == ovsjson.Update)
-					updaters = append(updaters, *updater)
-				}
-				monitorData.updatersKeys = append(monitorData.updatersKeys, key)
-			} else {
-				// TODO update "existing" updaters, change "where"
-			}
-		}
 	}
 	return ovsjson.EmptyStruct{}, nil
 }
 
 func (ch *Handler) MonitorCondSince(ctx context.Context, params []interface{}) (interface{}, error) {
-	ch.log.V(5).Info("MonitorCondSince request", "params", params)
-	updatersMap, err := ch.addMonitor(params, ovsjson.Update3)
-	if err != nil {
-		ch.log.Error(err, "MonitorCondSince failed")
-		return nil, err
+	ch.logger().V(5).Info("MonitorCondSince request", "params", params)
+	var lastTxnID string
+	if len(params) == 4 {
+		lastTxnID, _ = params[3].(string)
 	}
-
-	data, err := ch.getMonitoredData(params[0].(string), updatersMap)
-	ch.log.V(5).Info("MonitorCondSince response", "jsonValue", params[1], "data", fmt.Sprintf("%v", data))
+	// the client, which presents a known last-txn-id, receives only the changes since it
+	var data ovsjson.TableUpdates
+	var revision int64
+	found := false
+	_, err := ch.addMonitorWithState(params, ovsjson.Update3, func(dbName string, updatersMap Key2Updaters) (int64, error) {
+		var err error
+		if lastTxnID != "" && lastTxnID != ovsjson.ZERO_UUID {
+			data, revision, found, err = ch.getMonitoredChanges(dbName, lastTxnID, updatersMap)
+			if err != nil || found {
+				return revision, err
+			}
+		}
+		data, revision, err = ch.getMonitoredData(dbName, updatersMap)
+		return revision, err
+	})
 	if err != nil {
-		ch.log.Error(err, "failed to get monitored data")
-		ch.removeMonitor(params[1], false)
+		ch.logger().Error(err, "MonitorCondSince failed")
 		return nil, err
 	}
+	dbName := ResolveDatabaseName(ch.db.GetSchemas(), params[0].(string))
+	ch.logger().V(5).Info("MonitorCondSince response", "jsonValue", params[1], "found", found, "revision", revision, "data", fmt.Sprintf("%v", data))
 	jsonValueString := jsonValueToString(params[1])
+	ch.delivered.set(jsonValueString, revision, true)
 	ch.startNotifier(jsonValueString)
-	return []interface{}{false, ovsjson.ZERO_UUID, data}, nil
+	return []interface{}{found, ch.txnID(dbName, revision), data}, nil
 }
 
 func (ch *Handler) SetDbChangeAware(ctx context.Context, param interface{}) interface{} {
-	ch.log.V(5).Info("SetDbChangeAware request", "param", param)
+	ch.logger().V(5).Info("SetDbChangeAware request", "param", param)
+	aware := false
+	switch p := param.(type) {
+	case bool:
+		aware = p
+	case []interface{}:
+		if len(p) > 0 {
+			aware, _ = p[0].(bool)
+		}
+	}
+	ch.mu.Lock()
+	ch.dbChangeAware = aware
+	ch.mu.Unlock()
 	return ovsjson.EmptyStruct{}
 }
 
@@ -312,81 +535,493 @@ func (ch *Handler) SetDbChangeAware(ctx context.Context, param interface{}) inte
 // "params": JSON array with any contents
 // Returns : "result": same as "params"
 func (ch *Handler) Echo(ctx context.Context, param interface{}) interface{} {
-	ch.log.V(5).Info("Echo request", "param", param)
+	ch.logger().V(5).Info("Echo request", "param", param)
 	return param
 }
 
 func NewHandler(tctx context.Context, db Databaser, cli *clientv3.Client, log logr.Logger) *Handler {
-	return &Handler{
+	ch := &Handler{
 		handlerContext:     tctx,
 		db:                 db,
 		databaseLocks:      map[string]Locker{},
 		handlerMonitorData: map[string]handlerMonitorData{},
 		etcdClient:         cli,
 		monitors:           map[string]*dbMonitor{},
-		log:                log.WithValues("hid", shortuuid.New()),
+		transactions:       map[string]context.CancelFunc{},
+		locks:              NewLockRegistry(),
 	}
+	ch.baseLog = log.WithValues("hid", shortuuid.New())
+	ch.log = ch.baseLog
+	return ch
 }
 
 func (ch *Handler) Cleanup() error {
-	ch.log.Info("CLEAN UP do something")
+	ch.logger().Info("CLEAN UP do something")
 	ch.mu.Lock()
-	defer ch.mu.Unlock()
 	ch.closed = true
+	// the registry holds the locks, which were removed from the handler map too, every lock is released by the revoke
+	// of its session lease
+	locks := map[Locker]bool{}
 	for _, m := range ch.databaseLocks {
-		m.unlock()
+		locks[m] = true
 	}
-
+	for _, m := range ch.locks.releaseAll(ch) {
+		locks[m] = true
+	}
+	ch.quota.release(QUOTA_LOCKS, ch.identityName(), len(ch.databaseLocks))
+	ch.databaseLocks = map[string]Locker{}
+	for _, cancel := range ch.transactions {
+		cancel()
+	}
+	monitors := make([]*dbMonitor, 0, len(ch.monitors))
 	for _, monitor := range ch.monitors {
-		monitor.cancelDbMonitor()
+		monitors = append(monitors, monitor)
+	}
+	ch.mu.Unlock()
+
+	for m := range locks {
+		m.cancel()
+	}
+	for _, monitor := range monitors {
+		monitor.cancelDbMonitor(CANCEL_REASON_CONNECTION_CLOSED)
+	}
+	if latencyTracer != nil {
+		latencyTracer.removeClient(ch.GetClientAddress())
+	}
+	return nil
+}
+
+// SetSuppressionRules sets the rules of the tables, whose changes are never sent to this client, which is connected
+// through the given remote. The rules are applied by the role of the client identity. It should be called before the
+// handler starts serving requests.
+func (ch *Handler) SetSuppressionRules(rules SuppressionRules, remote string) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.suppression = rules
+	ch.remote = remote
+	ch.updateSuppressedTables()
+}
+
+// updateSuppressedTables applies the suppression rules by the role of the client, the caller should hold the handler
+// lock.
+func (ch *Handler) updateSuppressedTables() {
+	role := ""
+	if ch.identity != nil {
+		role = ch.identity.Role
+	}
+	ch.suppressedTables = ch.suppression.Tables(ch.remote, role)
+}
+
+// SetRedactionPolicy sets the policy of the columns, which are hidden from the client according to its role, should be
+// called before the handler starts serving requests.
+func (ch *Handler) SetRedactionPolicy(policy RedactionPolicy) {
+	ch.redaction = policy
+}
+
+// redactedColumns returns the columns of the table, which are hidden from the client, the caller should hold the
+// handler lock.
+func (ch *Handler) redactedColumns(dbName, table string) map[string]bool {
+	if len(ch.redaction) == 0 {
+		return nil
+	}
+	role := ""
+	if ch.identity != nil {
+		role = ch.identity.Role
+	}
+	return ch.redaction.Columns(role, dbName, table)
+}
+
+// checkRedactedConditions rejects transactions, whose conditions use the columns hidden from the client, as the
+// conditions results disclose the columns values.
+func (ch *Handler) checkRedactedConditions(req *libovsdb.Transact) error {
+	if len(ch.redaction) == 0 {
+		return nil
+	}
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	for _, op := range req.Operations {
+		if op.Table == nil {
+			continue
+		}
+		redacted := ch.redactedColumns(req.DBName, *op.Table)
+		columns := conditionsColumns(op.Where)
+		if op.Op == OP_WAIT && op.Columns != nil {
+			columns = append(columns, *op.Columns...)
+		}
+		for _, column := range columns {
+			if redacted[column] {
+				return fmt.Errorf("%s: column %s of table %s is redacted", E_PERMISSION_ERROR, column, *op.Table)
+			}
+		}
 	}
 	return nil
 }
 
+// redactResults removes the columns hidden from the client from the select results
+func (ch *Handler) redactResults(req *libovsdb.Transact, results []libovsdb.OperationResult) {
+	if len(ch.redaction) == 0 {
+		return
+	}
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	for i, op := range req.Operations {
+		if op.Op != OP_SELECT || op.Table == nil || i >= len(results) || results[i].Rows == nil {
+			continue
+		}
+		redacted := ch.redactedColumns(req.DBName, *op.Table)
+		for _, row := range *results[i].Rows {
+			redactRow(row, redacted)
+		}
+	}
+}
+
+// SetLockRegistry sets the registry of the locks of all the server clients, should be called before the handler starts
+// serving
+func (ch *Handler) SetLockRegistry(locks *LockRegistry) {
+	ch.locks = locks
+}
+
+// SetQuota sets the limits of the client monitors and locks, should be called before the handler starts serving
+// requests.
+func (ch *Handler) SetQuota(quota *ResourceQuota) {
+	ch.quota = quota
+}
+
+// SetLeaderElection sets the election of the database leaders, the write transactions of the databases, which are
+// not led by the server, are refused. It should be called before the handler starts serving requests.
+func (ch *Handler) SetLeaderElection(election *LeaderElection) {
+	ch.election = election
+}
+
+// SetIdentity sets the client identity, returned by the authenticator on the connection establishment.
+func (ch *Handler) SetIdentity(identity *Identity, authenticator Authenticator) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.setIdentity(identity)
+	ch.authenticator = authenticator
+}
+
+// setIdentity moves the quota usage of the client to the new identity, applies the suppression rules of its role, and
+// attributes the log messages to it, the caller should hold the handler lock.
+func (ch *Handler) setIdentity(identity *Identity) {
+	from := ch.identityName()
+	ch.identity = identity
+	ch.quota.transfer(from, ch.identityName(), len(ch.handlerMonitorData), len(ch.databaseLocks))
+	ch.updateSuppressedTables()
+	ch.updateLogger()
+}
+
+// updateLogger derives the logger of the client identity from the base logger, the caller should hold the handler
+// lock.
+func (ch *Handler) updateLogger() {
+	log := ch.baseLog
+	if ch.identity != nil {
+		log = log.WithValues(ch.identity.logValues()...)
+	}
+	ch.logMu.Lock()
+	ch.log = log
+	ch.logMu.Unlock()
+}
+
+// logger returns the logger of the connection, which is attributed to the current client identity
+func (ch *Handler) logger() logr.Logger {
+	ch.logMu.RLock()
+	defer ch.logMu.RUnlock()
+	return ch.log
+}
+
+// identityName returns the name, which the client resources are accounted by, the caller should hold the handler lock.
+func (ch *Handler) identityName() string {
+	if ch.identity == nil {
+		return ANONYMOUS_IDENTITY
+	}
+	return ch.identity.Name
+}
+
+func (ch *Handler) GetIdentity() *Identity {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.identity
+}
+
+// Authorized returns the assigner of the client methods, which rejects the methods the client isn't allowed to call,
+// e.g. before it presented the token of the TokenAuthenticator.
+func (ch *Handler) Authorized(assigner jrpc2.Assigner) jrpc2.Assigner {
+	return &authorizedAssigner{assigner: assigner, ch: ch}
+}
+
+// authorizeMethod returns the permission error, if the client isn't allowed to call the method
+func (ch *Handler) authorizeMethod(method string) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if _, ok := ch.authenticator.(TokenAuthenticator); ok && !ch.identity.authenticated() && !unauthenticatedMethods[method] {
+		err := fmt.Errorf("%s: %s requires authentication", E_PERMISSION_ERROR, method)
+		ch.logger().Error(err, "unauthenticated request")
+		return err
+	}
+	if adminMethods[method] && (!ch.identity.authenticated() || ch.identity.Role != ADMIN_ROLE) {
+		err := fmt.Errorf("%s: %s requires the %s role", E_PERMISSION_ERROR, method, ADMIN_ROLE)
+		ch.logger().Error(err, "unauthorized request")
+		return err
+	}
+	return nil
+}
+
+// Authenticate is an extension method, which allows clients to present a token after the connection establishment,
+// if the server authenticator supports it.
+// "params": [<token>]
+// Returns: "result": <identity>
+func (ch *Handler) Authenticate(ctx context.Context, param interface{}) (interface{}, error) {
+	ch.logger().V(5).Info("authenticate request")
+	token, err := common.ParamsToString(param)
+	if err != nil {
+		return nil, err
+	}
+	ch.mu.Lock()
+	tokenAuth, ok := ch.authenticator.(TokenAuthenticator)
+	ch.mu.Unlock()
+	if !ok {
+		err := errors.New(E_NOT_SUPPORTED)
+		ch.logger().Error(err, "token authentication is not supported")
+		return nil, err
+	}
+	identity, err := tokenAuth.AuthenticateToken(ctx, token)
+	if err != nil {
+		ch.logger().Error(err, "token authentication failed")
+		return nil, errors.New(E_PERMISSION_ERROR)
+	}
+	ch.mu.Lock()
+	ch.setIdentity(identity)
+	ch.mu.Unlock()
+	ch.logger().Info("client authenticated")
+	return identity, nil
+}
+
 func (ch *Handler) SetConnection(jrpcSerer JrpcServer, clientCon net.Conn) {
 	ch.jrpcServer = jrpcSerer
 	ch.clientCon = clientCon
-	ch.log = ch.log.WithValues("client", ch.GetClientAddress())
+	ch.mu.Lock()
+	ch.baseLog = ch.baseLog.WithValues("client", ch.GetClientAddress())
+	ch.updateLogger()
+	ch.mu.Unlock()
+	ch.writer = newConnWriter(ch.handlerContext, jrpcSerer, ch.logger())
+	go ch.writer.run()
 }
 
-func (ch *Handler) notify(jsonValueString string, updates ovsjson.TableUpdates, wg *sync.WaitGroup) {
+// FlushNotifications waits till all the notifications, which were queued to the connection before the call, are
+// written. The notifications in the monitor queues are passed to the connection writer first.
+func (ch *Handler) FlushNotifications(ctx context.Context) error {
+	ch.mu.Lock()
+	queues := make([]*notificationQueue, 0, len(ch.handlerMonitorData))
+	for _, hmd := range ch.handlerMonitorData {
+		queues = append(queues, hmd.notifications)
+	}
+	ch.mu.Unlock()
+	for _, queue := range queues {
+		if err := queue.drain(ctx); err != nil {
+			return err
+		}
+	}
+	return ch.writer.flush(ctx)
+}
+
+func (ch *Handler) notify(monitor *dbMonitor, jsonValueString string, event notificationEvent) {
 	hmd, ok := ch.handlerMonitorData[jsonValueString]
 	if !ok {
-		ch.log.Info("Unknown jsonValue", "jsonValue", jsonValueString)
+		ch.logger().Info("Unknown jsonValue", "jsonValue", jsonValueString)
+		event.done()
 		return
 	}
 	if klog.V(7).Enabled() {
-		ch.log.V(7).Info("Monitor notification jsonValue", "jsonValue", hmd.jsonValue, "updates", updates)
+		ch.logger().V(7).Info("Monitor notification jsonValue", "jsonValue", hmd.jsonValue, "updates", event.updates)
 	} else {
-		ch.log.V(5).Info("Monitor notification jsonValue", "jsonValue", hmd.jsonValue)
+		ch.logger().V(5).Info("Monitor notification jsonValue", "jsonValue", hmd.jsonValue)
 	}
-	hmd.notificationChain <- notificationEvent{updates: updates, wg: wg}
+	if event.trace != nil {
+		event.trace.enqueued = time.Now()
+	}
+	event.requestKey = hmd.requestKey
+	ch.enqueue(hmd, event, func(last, event *notificationEvent) bool {
+		return monitor.coalesce(jsonValueString, last, event)
+	})
 }
 
-func (ch *Handler) monitorCanceledNotification(jsonValue interface{}) {
-	ch.log.V(5).Info("monitorCanceledNotification", "jsonValue", jsonValue)
-	err := ch.jrpcServer.Notify(ch.handlerContext, MONITOR_CANCELED, jsonValue)
-	if err != nil {
-		// TODO should we do something else
-		ch.log.Error(err, "monitorCanceledNotification failed")
+// enqueue adds the event to the notifications queue of the monitor, by the SLOW_CLIENT_DISCONNECT policy the
+// connection is closed if the queue stays full. The events, which are not queued, are released.
+func (ch *Handler) enqueue(hmd handlerMonitorData, event notificationEvent, coalesce func(last, event *notificationEvent) bool) {
+	err := hmd.notifications.push(ch.handlerContext, event, coalesce)
+	if err == nil {
+		return
+	}
+	event.done()
+	if err == errSlowClient {
+		ch.logger().Info("closing the connection of a slow client", "jsonValue", hmd.jsonValue,
+			"queue", MonitorQueueSize, "timeout", SlowClientTimeout)
+		serverMetrics.Count(METRIC_SLOW_CLIENTS_DISCONNECTED, 1)
+		if ch.jrpcServer != nil {
+			ch.jrpcServer.Stop()
+		}
+	}
+}
+
+// resync sends to every update3 monitor of the given database a full snapshot of the monitored data with its
+// last-txn-id, and returns the number of the resynced monitors. The snapshot is read at the revision the monitor has
+// delivered, and it is preceded by a delete of every row the monitor has delivered till this revision, so the client
+// state is replaced by the snapshot, and the rows, which the client should not have, don't linger.
+func (ch *Handler) resync(dbName string) (int, error) {
+	ch.mu.Lock()
+	monitor, ok := ch.monitors[dbName]
+	hmds := []handlerMonitorData{}
+	for _, hmd := range ch.handlerMonitorData {
+		if hmd.dataBaseName == dbName && hmd.notificationType == ovsjson.Update3 {
+			hmds = append(hmds, hmd)
+		}
+	}
+	ch.mu.Unlock()
+	if !ok {
+		return 0, nil
+	}
+	// no updates are prepared till the snapshots are queued, so the following updates continue from their revision
+	revision := monitor.lockConditions()
+	defer monitor.condMu.Unlock()
+	for _, hmd := range hmds {
+		updatersMap := monitor.getUpdaters(jsonValueToString(hmd.jsonValue))
+		// the events till the initial revision of the monitor were delivered by its initial data
+		delivered := revision
+		for _, updaters := range updatersMap {
+			for _, u := range updaters {
+				if u.initial > delivered {
+					delivered = u.initial
+				}
+			}
+		}
+		resp, err := ch.db.GetDataAt(hmd.updatersKeys, delivered)
+		if err != nil {
+			ch.logger().Error(err, "resync failed", "jsonValue", hmd.jsonValue, "revision", delivered)
+			return 0, err
+		}
+		deletes := ovsjson.TableUpdates{}
+		snapshot := ovsjson.TableUpdates{}
+		for _, opRes := range resp.Responses {
+			for _, kv := range opRes.GetResponseRange().Kvs {
+				key, err := common.ParseKey(string(kv.Key))
+				if err != nil {
+					ch.logger().Error(err, "parse failed", "key", string(kv.Key))
+					return 0, err
+				}
+				row := newRowValue(kv.Value)
+				for _, u := range updatersMap[key.ToTableKey()] {
+					if ok, err := u.selects(row); err != nil || !ok {
+						if err != nil {
+							ch.logger().Error(err, "condition failed", "key", string(kv.Key))
+							return 0, err
+						}
+						continue
+					}
+					data, uuid, err := u.prepareRowValue(row)
+					if err != nil {
+						ch.logger().Error(err, "prepareRow failed", "key", string(kv.Key))
+						return 0, err
+					}
+					if _, ok := snapshot[key.TableName]; !ok {
+						snapshot[key.TableName] = ovsjson.TableUpdate{}
+						deletes[key.TableName] = ovsjson.TableUpdate{}
+					}
+					snapshot[key.TableName][uuid] = ovsjson.RowUpdate{Initial: &data}
+					deletes[key.TableName][uuid] = ovsjson.RowUpdate{Delete: true}
+				}
+			}
+		}
+		if delivered == 0 {
+			delivered = resp.Header.Revision
+		}
+		txnID := ch.txnID(dbName, delivered)
+		ch.logger().Info("resync monitor", "jsonValue", hmd.jsonValue, "last-txn-id", txnID, "revision", delivered)
+		if len(deletes) > 0 {
+			ch.enqueue(hmd, notificationEvent{updates: deletes, txnID: txnID, revision: delivered}, nil)
+		}
+		ch.enqueue(hmd, notificationEvent{updates: snapshot, txnID: txnID, revision: delivered}, nil)
 	}
+	return len(hmds), nil
 }
 
-func (ch *Handler) removeMonitor(jsonValue interface{}, notify bool) error {
-	ch.log.V(5).Info("removeMonitor failed", "jsonValue", jsonValue)
+// monitorsCanceled is called by the dbMonitor, when it cancels all its monitors
+func (ch *Handler) monitorsCanceled(monitor *dbMonitor, jsonValues map[string]string, reason string) {
+	canceled := []interface{}{}
+	ch.mu.Lock()
+	if m, ok := ch.monitors[monitor.dataBaseName]; ok && m == monitor {
+		delete(ch.monitors, monitor.dataBaseName)
+	}
+	for jsonValueString := range jsonValues {
+		hmd, ok := ch.handlerMonitorData[jsonValueString]
+		if !ok {
+			continue
+		}
+		delete(ch.handlerMonitorData, jsonValueString)
+		serverMetrics.Count(METRIC_MONITORS_ACTIVE, -1)
+		ch.delivered.remove(jsonValueString)
+		canceled = append(canceled, hmd.jsonValue)
+	}
+	ch.quota.release(QUOTA_MONITORS, ch.identityName(), len(canceled))
+	closed := ch.closed
+	ch.mu.Unlock()
+	if closed {
+		return
+	}
+	for _, jsonValue := range canceled {
+		ch.monitorCanceledNotification(jsonValue, reason)
+	}
+}
+
+func (ch *Handler) monitorCanceledNotification(jsonValue interface{}, reason string) {
+	ch.logger().V(5).Info("monitorCanceledNotification", "jsonValue", jsonValue, "reason", reason)
+	// queued after the updates of the monitor
+	ch.writer.write(MONITOR_CANCELED, []interface{}{jsonValue, map[string]string{"reason": reason}}, nil)
+}
+
+// databaseChanged is called after the database was converted to a new schema. The connection of a client, which is
+// not db change aware, is closed, per ovsdb-server, so the client reassesses the databases when it reconnects. The
+// monitors of the database of other clients are canceled, and the clients may monitor it again according to its new
+// schema.
+func (ch *Handler) databaseChanged(dbName string, requester bool) {
+	ch.mu.Lock()
+	aware := ch.dbChangeAware
+	monitor, ok := ch.monitors[dbName]
+	ch.mu.Unlock()
+	if !aware && !requester {
+		ch.logger().Info("closing the connection of a db change unaware client", "dbName", dbName)
+		if ch.jrpcServer != nil {
+			ch.jrpcServer.Stop()
+		}
+		return
+	}
+	if ok {
+		monitor.cancelDbMonitor(CANCEL_REASON_SCHEMA_CONVERTED)
+	}
+}
+
+// removeMonitor removes the monitor, if the reason is not empty the client is notified by the monitor_canceled
+// notification.
+func (ch *Handler) removeMonitor(jsonValue interface{}, reason string) error {
+	ch.logger().V(5).Info("removeMonitor failed", "jsonValue", jsonValue)
 
-	jsonValueString := jsonValueToString(jsonValue)
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
+	return ch.deleteMonitor(jsonValue, reason)
+}
+
+// deleteMonitor removes the monitor of the json-value, the handler lock must be held
+func (ch *Handler) deleteMonitor(jsonValue interface{}, reason string) error {
+	jsonValueString := jsonValueToString(jsonValue)
 	monitorData, ok := ch.handlerMonitorData[jsonValueString]
 	if !ok {
-		ch.log.Info("removing unexisting dbMonitor", "jsonValue", jsonValue)
+		ch.logger().Info("removing unexisting dbMonitor", "jsonValue", jsonValue)
 		err := fmt.Errorf("unknown monitor")
 		return err
 	}
 	monitor, ok := ch.monitors[monitorData.dataBaseName]
 	if !ok {
-		ch.log.Info("there is no monitor", "dbname", monitorData.dataBaseName)
+		ch.logger().Info("there is no monitor", "dbname", monitorData.dataBaseName)
 	}
 
 	monitor.removeUpdaters(monitorData.updatersKeys, jsonValueString)
@@ -396,14 +1031,27 @@ func (ch *Handler) removeMonitor(jsonValue interface{}, notify bool) error {
 		delete(ch.monitors, monitorData.dataBaseName)
 	}
 	delete(ch.handlerMonitorData, jsonValueString)
-	if notify {
-		ch.monitorCanceledNotification(jsonValue)
+	serverMetrics.Count(METRIC_MONITORS_ACTIVE, -1)
+	ch.delivered.remove(jsonValueString)
+	ch.quota.release(QUOTA_MONITORS, ch.identityName(), 1)
+	if reason != "" {
+		ch.monitorCanceledNotification(jsonValue, reason)
 	}
 	return nil
 }
 
 func (ch *Handler) addMonitor(params []interface{}, notificationType ovsjson.UpdateNotificationType) (Key2Updaters, error) {
+	return ch.addMonitorWithState(params, notificationType, nil)
+}
 
+// monitorStateReader reads the initial state of a new monitor, and returns the revision of the state
+type monitorStateReader func(dbName string, updatersMap Key2Updaters) (int64, error)
+
+// addMonitorWithState adds the monitor, whose initial state is read by the given reader. The notifications of the
+// database are held while the state is read, and the updaters of the monitor skip the changes up to the revision of the
+// state, so every change is sent to the client exactly once, either with the state or by a notification. The monitor
+// is removed if the state cannot be read.
+func (ch *Handler) addMonitorWithState(params []interface{}, notificationType ovsjson.UpdateNotificationType, read monitorStateReader) (Key2Updaters, error) {
 	cmpr, err := parseCondMonitorParameters(params)
 	if err != nil {
 		return nil, err
@@ -411,6 +1059,7 @@ func (ch *Handler) addMonitor(params []interface{}, notificationType ovsjson.Upd
 	if len(cmpr.DatabaseName) == 0 {
 		return nil, fmt.Errorf("monitored dataBase name is empty")
 	}
+	cmpr.DatabaseName = ResolveDatabaseName(ch.db.GetSchemas(), cmpr.DatabaseName)
 
 	jsonValueString := jsonValueToString(cmpr.JsonValue)
 	ch.mu.Lock()
@@ -431,51 +1080,201 @@ func (ch *Handler) addMonitor(params []interface{}, notificationType ovsjson.Upd
 			klog.Errorf("%v", err)
 			return nil, err
 		}
+		if ch.suppressedTables[cmpr.DatabaseName][tableName] {
+			ch.logger().V(5).Info("table notifications are suppressed", "dbName", cmpr.DatabaseName, "table", tableName)
+			continue
+		}
 		for _, mcr := range mcrs {
 			updater := mcrToUpdater(mcr, jsonValueString, tableSchema, notificationType == ovsjson.Update)
+			updater.redacted = ch.redactedColumns(cmpr.DatabaseName, tableName)
+			if err := updater.compileCondition(ch.logger()); err != nil {
+				return nil, err
+			}
 			updaters = append(updaters, *updater)
 		}
 		key := common.NewTableKey(cmpr.DatabaseName, tableName)
 		updatersMap[key] = updaters
 		updatersKeys = append(updatersKeys, key)
 	}
-	log := ch.log.WithValues("jsonValue", cmpr.JsonValue)
+	log := ch.logger().WithValues("jsonValue", cmpr.JsonValue)
+	if err := ch.quota.acquire(QUOTA_MONITORS, ch.identityName(), len(ch.handlerMonitorData)); err != nil {
+		log.Error(err, "monitors quota exceeded", "dbName", cmpr.DatabaseName)
+		return nil, rejectionError(err.Error(), hints.REASON_QUOTA_EXCEEDED, 0)
+	}
 	monitor, ok := ch.monitors[cmpr.DatabaseName]
 	if !ok {
 		monitor = ch.db.CreateMonitor(cmpr.DatabaseName, ch, log)
 		monitor.start()
 		ch.monitors[cmpr.DatabaseName] = monitor
 	}
-	monitor.addUpdaters(updatersMap)
+	requestKey, err := monitorRequestKey(cmpr.DatabaseName, notificationType, updatersMap)
+	if err != nil {
+		log.Error(err, "monitor request key")
+	}
+	serverMetrics.Count(METRIC_MONITORS_ACTIVE, 1)
 	ch.handlerMonitorData[jsonValueString] = handlerMonitorData{
-		log:               log,
-		dataBaseName:      cmpr.DatabaseName,
-		notificationType:  notificationType,
-		updatersKeys:      updatersKeys,
-		jsonValue:         cmpr.JsonValue,
-		notificationChain: make(chan notificationEvent),
+		requestKey:       requestKey,
+		log:              log,
+		dataBaseName:     cmpr.DatabaseName,
+		notificationType: notificationType,
+		updatersKeys:     updatersKeys,
+		jsonValue:        cmpr.JsonValue,
+		notifications:    newNotificationQueue(),
+	}
+	if read == nil {
+		monitor.addUpdaters(updatersMap)
+		return updatersMap, nil
+	}
+	if err := readMonitorState(monitor, cmpr.DatabaseName, updatersMap, read); err != nil {
+		log.Error(err, "failed to read the monitor state", "dbName", cmpr.DatabaseName)
+		ch.deleteMonitor(cmpr.JsonValue, "")
+		return nil, err
 	}
-
 	return updatersMap, nil
 }
 
+// readMonitorState reads the initial state of the monitor, while the notifications of the database monitor are held,
+// and adds the monitor updaters, which notify the changes after the revision of the state only
+func readMonitorState(monitor *dbMonitor, dbName string, updatersMap Key2Updaters, read monitorStateReader) error {
+	monitor.condMu.Lock()
+	defer monitor.condMu.Unlock()
+	revision, err := read(dbName, updatersMap)
+	if err != nil {
+		return err
+	}
+	for _, updaters := range updatersMap {
+		for i := range updaters {
+			updaters[i].initial = revision
+		}
+	}
+	monitor.addUpdatersAt(updatersMap, revision)
+	return nil
+}
+
+// changeMonitorConditions replaces the monitor requests of the given tables. All the requests are validated before
+// any change, and the updaters of all the tables are swapped at once, so a notification is prepared either with the
+// old or with the new requests of all the tables, and never with a mix of them. The rows, which enter or leave the
+// scope of the new conditions, are sent to the client as inserts and deletes.
+func (ch *Handler) changeMonitorConditions(jsonValueString string, mcrs map[string][]ovsjson.MonitorCondRequest) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	monitorData, ok := ch.handlerMonitorData[jsonValueString]
+	if !ok {
+		return fmt.Errorf("unknown monitor")
+	}
+	dbName := monitorData.dataBaseName
+	monitor, ok := ch.monitors[dbName]
+	if !ok {
+		return fmt.Errorf("there is no monitor for %s", dbName)
+	}
+	databaseSchema, ok := ch.db.GetSchemas()[dbName]
+	if !ok {
+		return fmt.Errorf("there is no databaseSchema for %s", dbName)
+	}
+	current := monitor.getUpdaters(jsonValueString)
+	// the events till the initial revision of the monitor are covered by its initial data for the new updaters too
+	var initial int64
+	for _, updaters := range current {
+		for _, u := range updaters {
+			if u.initial > initial {
+				initial = u.initial
+			}
+		}
+	}
+	updatersMap := Key2Updaters{}
+	for tableName, mcrArray := range mcrs {
+		tableSchema, err := databaseSchema.LookupTable(tableName)
+		if err != nil {
+			return err
+		}
+		if ch.suppressedTables[dbName][tableName] {
+			continue
+		}
+		key := common.NewTableKey(dbName, tableName)
+		var updaters []updater
+		for i, mcr := range mcrArray {
+			// a condition update request carries only "where", the other parts are kept from the original request
+			if existing := current[key]; len(existing) > 0 {
+				orig := existing[0].mcr
+				if i < len(existing) {
+					orig = existing[i].mcr
+				}
+				if mcr.Columns == nil {
+					mcr.Columns = orig.Columns
+				}
+				if mcr.Select == nil {
+					mcr.Select = orig.Select
+				}
+			}
+			updater := mcrToUpdater(mcr, jsonValueString, tableSchema, monitorData.notificationType == ovsjson.Update)
+			updater.redacted = ch.redactedColumns(dbName, tableName)
+			updater.initial = initial
+			if err := updater.compileCondition(ch.logger()); err != nil {
+				return err
+			}
+			updaters = append(updaters, *updater)
+		}
+		updatersMap[key] = updaters
+	}
+	// the rows, which enter or leave the conditions scope, are sent between the notifications prepared with the old
+	// conditions and the notifications prepared with the new ones. The selection of the rows is changed at the
+	// revision, which the client has seen, the later changes are prepared with the new conditions.
+	revision := monitor.lockConditions()
+	defer monitor.condMu.Unlock()
+	if initial > revision {
+		revision = initial
+	}
+	updates, revision, changed, err := ch.conditionChangeUpdates(current, updatersMap, revision)
+	if err != nil {
+		return err
+	}
+	for key, updaters := range updatersMap {
+		for i := range updaters {
+			updaters[i].since = revision
+			updaters[i].snapshotRows = changed[key]
+		}
+	}
+	monitor.replaceUpdaters(updatersMap, jsonValueString)
+	for key := range updatersMap {
+		if _, ok := current[key]; !ok {
+			monitorData.updatersKeys = append(monitorData.updatersKeys, key)
+		}
+	}
+	requestKey, err := monitorRequestKey(dbName, monitorData.notificationType, monitor.getUpdaters(jsonValueString))
+	if err != nil {
+		ch.logger().Error(err, "monitor request key")
+	}
+	monitorData.requestKey = requestKey
+	ch.handlerMonitorData[jsonValueString] = monitorData
+	if len(updates) > 0 {
+		event := notificationEvent{updates: updates, revision: revision}
+		if monitorData.notificationType == ovsjson.Update3 {
+			event.txnID = ch.txnID(dbName, revision)
+		}
+		ch.enqueue(monitorData, event, nil)
+	}
+	return nil
+}
+
 func (ch *Handler) startNotifier(jsonValue string) {
-	ch.log.V(6).Info("start monitor notifier", "jsonValue", jsonValue)
+	ch.logger().V(6).Info("start monitor notifier", "jsonValue", jsonValue)
 	hmd, ok := ch.handlerMonitorData[jsonValue]
 	if !ok {
-		ch.log.Info("there is no notifier", "jsonValue", jsonValue)
+		ch.logger().Info("there is no notifier", "jsonValue", jsonValue)
 	} else {
 		go hmd.notifier(ch)
 	}
 
 }
 
-func (ch *Handler) getMonitoredData(dbName string, updatersMap Key2Updaters) (ovsjson.TableUpdates, error) {
+// getMonitoredData returns the initial data of the monitor and its revision
+func (ch *Handler) getMonitoredData(dbName string, updatersMap Key2Updaters) (ovsjson.TableUpdates, int64, error) {
+	dbName = ResolveDatabaseName(ch.db.GetSchemas(), dbName)
 	keys := []common.Key{}
 	for tableKey, updaters := range updatersMap {
 		if len(updaters) == 0 {
 			// nothing to update
-			ch.log.Info("there is no updaters", "for table", tableKey.String())
+			ch.logger().Info("there is no updaters", "for table", tableKey.String())
 			continue
 		}
 		// validate that Initial is required
@@ -490,49 +1289,64 @@ func (ch *Handler) getMonitoredData(dbName string, updatersMap Key2Updaters) (ov
 			keys = append(keys, tableKey)
 		}
 	}
-	resp, err := ch.db.GetData(keys)
-	if err != nil {
-		return nil, err
+	// the snapshot is read again, if its revision was compacted while the pages were read
+	for attempt := 1; ; attempt++ {
+		returnData := ovsjson.TableUpdates{}
+		revision, err := ch.db.GetDataPages(keys, MonitorSnapshotPageSize, func(kvs []*mvccpb.KeyValue) {
+			ch.addInitialRows(returnData, updatersMap, kvs)
+		})
+		if errors.Is(err, rpctypes.ErrCompacted) && attempt < snapshotAttempts {
+			ch.logger().Info("monitor snapshot revision was compacted, reading it again", "dbName", dbName, "attempt", attempt)
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		ch.logger().V(6).Info("getMonitoredData completed", "revision", revision, "data", returnData)
+		return returnData, revision, nil
 	}
-	returnData := ovsjson.TableUpdates{}
-	for _, opRes := range resp.Responses {
-		rangeResp := opRes.GetResponseRange()
-		for _, kv := range rangeResp.Kvs {
-			key, err := common.ParseKey(string(kv.Key))
+}
+
+// the number of attempts to read a monitor snapshot, whose revision is compacted concurrently
+const snapshotAttempts = 3
+
+// addInitialRows adds the rows of the key-values, which are selected by the updaters of their tables, to the initial
+// data of a monitor
+func (ch *Handler) addInitialRows(returnData ovsjson.TableUpdates, updatersMap Key2Updaters, kvs []*mvccpb.KeyValue) {
+	for _, kv := range kvs {
+		key, err := common.ParseKey(string(kv.Key))
+		if err != nil {
+			quarantine.Add(string(kv.Key), kv.ModRevision, err)
+			continue
+		}
+		tableKey := key.ToTableKey()
+		updaters := updatersMap[tableKey]
+		for _, updater := range updaters {
+			row, uuid, err := updater.prepareCreateRowInitial(&kv.Value)
 			if err != nil {
-				ch.log.Error(err, "parse failed", "key", string(kv.Key))
-				return nil, err
+				quarantine.Add(string(kv.Key), kv.ModRevision, err)
+				break
 			}
-			tableKey := key.ToTableKey()
-			updaters := updatersMap[tableKey]
-			for _, updater := range updaters {
-				row, uuid, err := updater.prepareCreateRowInitial(&kv.Value)
-				if err != nil {
-					ch.log.Error(err, "prepareCreateRowInitial returned")
-					return nil, err
+			if row != nil {
+				tableUpdate, ok := returnData[tableKey.TableName]
+				if !ok {
+					tableUpdate = ovsjson.TableUpdate{}
+					returnData[tableKey.TableName] = tableUpdate
 				}
-				// TODO merge
-				if row != nil {
-					tableUpdate, ok := returnData[tableKey.TableName]
-					if !ok {
-						tableUpdate = ovsjson.TableUpdate{}
-						returnData[tableKey.TableName] = tableUpdate
+				if merged, ok := tableUpdate[uuid]; ok {
+					// another monitor request of the same table
+					if err := mergeRowUpdates(&merged, row); err != nil {
+						quarantine.Add(string(kv.Key), kv.ModRevision, err)
+						break
 					}
-					tableUpdate[uuid] = *row
-				} else {
-					ch.log.Info("row is nil")
+					row = &merged
 				}
+				tableUpdate[uuid] = *row
+			} else {
+				ch.logger().V(5).Info("row is nil")
 			}
 		}
 	}
-	monitor, ok := ch.monitors[dbName]
-	if !ok {
-		err := fmt.Errorf("there is no monitor for %s", dbName)
-		return nil, err
-	}
-	monitor.revChecker.revision = resp.Header.Revision
-	ch.log.V(6).Info("getMonitoredData completed", "revision", resp.Header.Revision, "data", returnData)
-	return returnData, nil
 }
 
 func (ch *Handler) GetClientAddress() string {
@@ -0,0 +1,80 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ibm/ovsdb-etcd/pkg/hints"
+)
+
+// FrozenRetryAfter is the retry-after hint of the write transactions, which are rejected because their database is frozen
+var FrozenRetryAfter = time.Second
+
+// rejectionError returns the error of a rejected request, the error carries a hint, which allows the clients to react
+// programmatically, e.g. to retry after the given time.
+func rejectionError(message string, reason string, retryAfter time.Duration) error {
+	return hints.NewHint(reason, retryAfter).Error(message)
+}
+
+// hintsWriter writes the responses of a connection, and adds the hints of the rejected requests to their error objects
+// as the OVSDB details, as the jrpc2 fork writes only the message of the errors
+type hintsWriter struct {
+	io.WriteCloser
+	mu sync.Mutex
+	// the details of the rejected requests by their JSON ids
+	details map[string]string
+}
+
+func newHintsWriter(writer io.WriteCloser) *hintsWriter {
+	return &hintsWriter{WriteCloser: writer, details: map[string]string{}}
+}
+
+// add records the hint of the request error, it's written with the response of the request
+func (hw *hintsWriter) add(id string, err error) {
+	hint, ok := hints.FromError(err)
+	if !ok || id == "" {
+		return
+	}
+	hw.mu.Lock()
+	hw.details[id] = hint.Details()
+	hw.mu.Unlock()
+}
+
+// Write writes a single JSON-RPC message
+func (hw *hintsWriter) Write(msg []byte) (int, error) {
+	hw.mu.Lock()
+	if len(hw.details) == 0 {
+		hw.mu.Unlock()
+		return hw.WriteCloser.Write(msg)
+	}
+	response := map[string]json.RawMessage{}
+	if err := json.Unmarshal(msg, &response); err != nil {
+		hw.mu.Unlock()
+		return hw.WriteCloser.Write(msg)
+	}
+	details, ok := hw.details[string(response["id"])]
+	if ok {
+		delete(hw.details, string(response["id"]))
+	}
+	hw.mu.Unlock()
+	errObject := map[string]interface{}{}
+	if !ok || json.Unmarshal(response["error"], &errObject) != nil {
+		return hw.WriteCloser.Write(msg)
+	}
+	errObject["details"] = details
+	buf, err := json.Marshal(errObject)
+	if err != nil {
+		return hw.WriteCloser.Write(msg)
+	}
+	response["error"] = buf
+	withDetails, err := json.Marshal(response)
+	if err != nil {
+		return hw.WriteCloser.Write(msg)
+	}
+	if _, err := hw.WriteCloser.Write(withDetails); err != nil {
+		return 0, err
+	}
+	return len(msg), nil
+}
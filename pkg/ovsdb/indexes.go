@@ -0,0 +1,286 @@
+package ovsdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// The "indexes" of the tables are unique constraints (RFC 7047, section 3.2). Every indexed column tuple of a row has
+// an entry in etcd, its key is derived from the table, the index columns and their values, and its value is the uuid of
+// the row. The entries are written by the etcd transaction of the commit, which is guarded by their previous state, so
+// two transactions cannot insert colliding rows concurrently.
+
+// indexID returns the id of the entry of the index columns of the row, the values of the columns are hashed so the
+// length of the key is bounded
+func indexID(columns []string, row map[string]interface{}) (string, error) {
+	tuple := make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		tuple = append(tuple, row[column])
+	}
+	data, err := json.Marshal(tuple)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return strings.Join(columns, ",") + common.KEY_DELIMETER + hex.EncodeToString(sum[:]), nil
+}
+
+// indexKeys returns the keys of the index entries of the stored row, mapped to the columns of their indexes
+func indexKeys(dbName, table string, indexes [][]string, value []byte) (map[string][]string, error) {
+	row, err := unmarshalData(value)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string][]string{}
+	for _, columns := range indexes {
+		id, err := indexID(columns, row)
+		if err != nil {
+			return nil, err
+		}
+		keys[common.NewIndexKey(dbName, table, id).String()] = columns
+	}
+	return keys, nil
+}
+
+func (txn *Transaction) indexViolationError(violations []string) error {
+	err := errors.New(E_CONSTRAINT_VIOLATION)
+	txn.log.Error(err, "index violation", "violations", violations)
+	details := "rows with duplicate indexed values: " + strings.Join(violations, ", ")
+	errStr := err.Error()
+	txn.response.Result = append(txn.response.Result, libovsdb.OperationResult{Error: &errStr, Details: &details})
+	return err
+}
+
+// indexEntry is an index entry added or released by the transaction
+type indexEntry struct {
+	table   string
+	columns []string
+	uuid    string
+}
+
+// maintainIndexes adds the index entries of the rows written by the transaction, and deletes the entries released by
+// them. A row colliding with another row on the values of an index fails the transaction, and the entries are guarded
+// by the etcd transaction, so the check stays valid until the commit.
+func (txn *Transaction) maintainIndexes() error {
+	databaseSchema, ok := txn.schemas[txn.request.DBName]
+	if !ok {
+		return nil
+	}
+	dbName := txn.request.DBName
+
+	added := map[string]indexEntry{}
+	released := map[string]indexEntry{}
+	violations := []string{}
+	for _, ev := range txn.etcd.Events {
+		var key *common.Key
+		var err error
+		if ev.Type == clientv3.EventTypeDelete {
+			key, err = common.ParseKey(string(ev.PrevKv.Key))
+		} else {
+			key, err = common.ParseKey(string(ev.Kv.Key))
+		}
+		if err != nil {
+			continue
+		}
+		tableSchema, ok := databaseSchema.Tables[key.TableName]
+		if !ok || len(tableSchema.Indexes) == 0 {
+			continue
+		}
+		prevKeys := map[string][]string{}
+		if ev.PrevKv != nil {
+			if prevKeys, err = indexKeys(dbName, key.TableName, tableSchema.Indexes, ev.PrevKv.Value); err != nil {
+				return errors.New(E_INTERNAL_ERROR)
+			}
+		}
+		keys := map[string][]string{}
+		if ev.Type != clientv3.EventTypeDelete {
+			if keys, err = indexKeys(dbName, key.TableName, tableSchema.Indexes, ev.Kv.Value); err != nil {
+				return errors.New(E_INTERNAL_ERROR)
+			}
+		}
+		for indexKey, columns := range prevKeys {
+			if _, ok := keys[indexKey]; !ok {
+				released[indexKey] = indexEntry{table: key.TableName, columns: columns, uuid: key.UUID}
+			}
+		}
+		for indexKey, columns := range keys {
+			if _, ok := prevKeys[indexKey]; ok {
+				continue
+			}
+			if other, ok := added[indexKey]; ok {
+				violations = append(violations, fmt.Sprintf("%s (%s) of %s and %s", key.TableName,
+					strings.Join(columns, ", "), other.uuid, key.UUID))
+				continue
+			}
+			added[indexKey] = indexEntry{table: key.TableName, columns: columns, uuid: key.UUID}
+		}
+	}
+	if len(violations) > 0 {
+		return txn.indexViolationError(violations)
+	}
+	if len(added) == 0 && len(released) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(added)+len(released))
+	for indexKey := range added {
+		keys = append(keys, indexKey)
+	}
+	for indexKey := range released {
+		if _, ok := added[indexKey]; !ok {
+			keys = append(keys, indexKey)
+		}
+	}
+	sort.Strings(keys)
+	read := NewEtcd(txn.etcd)
+	read.Clear()
+	for _, indexKey := range keys {
+		read.Then = append(read.Then, clientv3.OpGet(indexKey))
+	}
+	if err := read.Commit(); err != nil {
+		err = errors.New(E_IO_ERROR)
+		txn.log.Error(err, "index reads")
+		return err
+	}
+	owners := map[string]string{}
+	for i, indexKey := range keys {
+		if kvs := read.Res.Responses[i].GetResponseRange().Kvs; len(kvs) > 0 {
+			owners[indexKey] = string(kvs[0].Value)
+		}
+	}
+
+	// the entries owned by the rows, which aren't written by the transaction
+	foreign := []string{}
+	for _, indexKey := range keys {
+		entry, ok := added[indexKey]
+		owner, exists := owners[indexKey]
+		if !ok || !exists || owner == entry.uuid || owner == released[indexKey].uuid {
+			continue
+		}
+		foreign = append(foreign, indexKey)
+	}
+	if len(foreign) > 0 {
+		read.Clear()
+		for _, indexKey := range foreign {
+			rowKey := common.NewDataKey(dbName, added[indexKey].table, owners[indexKey])
+			read.Then = append(read.Then, clientv3.OpGet(rowKey.String()))
+		}
+		if err := read.Commit(); err != nil {
+			err = errors.New(E_IO_ERROR)
+			txn.log.Error(err, "index owners reads")
+			return err
+		}
+		for i, indexKey := range foreign {
+			entry := added[indexKey]
+			rowKey := common.NewDataKey(dbName, entry.table, owners[indexKey]).String()
+			kvs := read.Res.Responses[i].GetResponseRange().Kvs
+			if len(kvs) == 0 {
+				// a stale entry of a deleted row
+				txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.CreateRevision(rowKey), "=", 0))
+				continue
+			}
+			ownerKeys, err := indexKeys(dbName, entry.table, databaseSchema.Tables[entry.table].Indexes, kvs[0].Value)
+			if err != nil {
+				return errors.New(E_INTERNAL_ERROR)
+			}
+			if _, ok := ownerKeys[indexKey]; ok {
+				violations = append(violations, fmt.Sprintf("%s (%s) of %s and %s", entry.table,
+					strings.Join(entry.columns, ", "), owners[indexKey], entry.uuid))
+				continue
+			}
+			// a stale entry of a modified row
+			txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.ModRevision(rowKey), "=", kvs[0].ModRevision))
+		}
+		if len(violations) > 0 {
+			return txn.indexViolationError(violations)
+		}
+	}
+
+	for _, indexKey := range keys {
+		owner, exists := owners[indexKey]
+		if entry, ok := added[indexKey]; ok {
+			if exists {
+				txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.Value(indexKey), "=", owner))
+			} else {
+				txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.CreateRevision(indexKey), "=", 0))
+			}
+			txn.etcd.Then = append(txn.etcd.Then, clientv3.OpPut(indexKey, entry.uuid))
+			txn.etcd.EventsNilCount++
+			continue
+		}
+		// a released entry is deleted, unless it's owned by another row
+		if exists && owner == released[indexKey].uuid {
+			txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.Value(indexKey), "=", owner))
+			txn.etcd.Then = append(txn.etcd.Then, clientv3.OpDelete(indexKey))
+			txn.etcd.EventsNilCount++
+		}
+	}
+	txn.etcd.Assert()
+	return nil
+}
+
+// BuildIndexes adds the missing index entries of the stored rows of the database, it's called at the startup of the
+// server, so the rows written before the indexes were maintained are indexed. Returns the number of the added entries.
+func BuildIndexes(ctx context.Context, cli *clientv3.Client, dbName string, databaseSchema *libovsdb.DatabaseSchema) (int, error) {
+	tables := make([]string, 0, len(databaseSchema.Tables))
+	for table, tableSchema := range databaseSchema.Tables {
+		if len(tableSchema.Indexes) > 0 {
+			tables = append(tables, table)
+		}
+	}
+	sort.Strings(tables)
+	built := 0
+	for _, table := range tables {
+		tableSchema := databaseSchema.Tables[table]
+		tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+		res, err := cli.Txn(tctx).Then(
+			clientv3.OpGet(common.NewTableKey(dbName, table).String(), clientv3.WithPrefix()),
+			clientv3.OpGet(common.NewIndexTablePrefix(dbName, table), clientv3.WithPrefix(), clientv3.WithKeysOnly())).Commit()
+		cancel()
+		if err != nil {
+			return built, err
+		}
+		entries := map[string]bool{}
+		for _, kv := range res.Responses[1].GetResponseRange().Kvs {
+			entries[string(kv.Key)] = true
+		}
+		for _, kv := range res.Responses[0].GetResponseRange().Kvs {
+			key, err := common.ParseKey(string(kv.Key))
+			if err != nil {
+				continue
+			}
+			keys, err := indexKeys(dbName, table, tableSchema.Indexes, kv.Value)
+			if err != nil {
+				return built, err
+			}
+			for indexKey := range keys {
+				if entries[indexKey] {
+					continue
+				}
+				tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+				resp, err := cli.Txn(tctx).If(clientv3.Compare(clientv3.CreateRevision(indexKey), "=", 0)).
+					Then(clientv3.OpPut(indexKey, key.UUID)).Commit()
+				cancel()
+				if err != nil {
+					return built, err
+				}
+				if resp.Succeeded {
+					entries[indexKey] = true
+					built++
+				}
+			}
+		}
+	}
+	return built, nil
+}
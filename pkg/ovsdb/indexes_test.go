@@ -0,0 +1,100 @@
+package ovsdb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func TestIndexes(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	resp, _ := testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Address_Set", "row": {"name": "as1"}},
+		{"op": "insert", "table": "Address_Set", "row": {"name": "as2"}}]`)
+	assert.Nil(t, resp.Error)
+	names := func() []string {
+		rows := testOvnSelect(t, "OVN_Northbound", "Address_Set", `[]`)
+		names := []string{}
+		for _, row := range rows {
+			names = append(names, row["name"].(string))
+		}
+		return names
+	}
+
+	// a collision with a stored row
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Address_Set", "row": {"name": "as1"}}]`)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, E_CONSTRAINT_VIOLATION, *resp.Error)
+	}
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "update", "table": "Address_Set", "where": [["name", "==", "as2"]], "row": {"name": "as1"}}]`)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, E_CONSTRAINT_VIOLATION, *resp.Error)
+	}
+
+	// a collision of the rows of the transaction
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Address_Set", "row": {"name": "as3"}},
+		{"op": "insert", "table": "Address_Set", "row": {"name": "as3"}}]`)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, E_CONSTRAINT_VIOLATION, *resp.Error)
+	}
+	assert.ElementsMatch(t, []string{"as1", "as2"}, names())
+
+	// the rows swap their names
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "update", "table": "Address_Set", "where": [["name", "==", "as1"]], "row": {"name": "tmp"}},
+		{"op": "update", "table": "Address_Set", "where": [["name", "==", "as2"]], "row": {"name": "as1"}},
+		{"op": "update", "table": "Address_Set", "where": [["name", "==", "tmp"]], "row": {"name": "as2"}}]`)
+	assert.Nil(t, resp.Error)
+	assert.ElementsMatch(t, []string{"as1", "as2"}, names())
+
+	// the names are released by renames and deletions
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "update", "table": "Address_Set", "where": [["name", "==", "as1"]], "row": {"name": "as3"}},
+		{"op": "delete", "table": "Address_Set", "where": [["name", "==", "as2"]]}]`)
+	assert.Nil(t, resp.Error)
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Address_Set", "row": {"name": "as1"}},
+		{"op": "insert", "table": "Address_Set", "row": {"name": "as2"}}]`)
+	assert.Nil(t, resp.Error)
+	assert.ElementsMatch(t, []string{"as1", "as2", "as3"}, names())
+
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	res, err := cli.Get(context.Background(), common.NewIndexTablePrefix("OVN_Northbound", "Address_Set"), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(res.Kvs))
+}
+
+func TestBuildIndexes(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	resp, _ := testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Address_Set", "row": {"name": "as1"}}]`)
+	assert.Nil(t, resp.Error)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	ctx := context.Background()
+	prefix := common.NewIndexTablePrefix("OVN_Northbound", "Address_Set")
+	_, err = cli.Delete(ctx, prefix, clientv3.WithPrefix())
+	assert.Nil(t, err)
+
+	built, err := BuildIndexes(ctx, cli, "OVN_Northbound", testOvnSchemas(t)["OVN_Northbound"])
+	assert.Nil(t, err)
+	assert.Equal(t, 1, built)
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Address_Set", "row": {"name": "as1"}}]`)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, E_CONSTRAINT_VIOLATION, *resp.Error)
+	}
+	built, err = BuildIndexes(ctx, cli, "OVN_Northbound", testOvnSchemas(t)["OVN_Northbound"])
+	assert.Nil(t, err)
+	assert.Equal(t, 0, built)
+}
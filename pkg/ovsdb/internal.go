@@ -0,0 +1,82 @@
+package ovsdb
+
+// InternalColumn describes how a column, which is maintained by the server and is not part of the table schema, is
+// handled by the different subsystems.
+type InternalColumn struct {
+	Name string
+	// returned by select, if the columns are not specified
+	Select bool
+	// sent in monitor notifications, if the columns are not specified
+	Monitor bool
+	// compared when a modified row is diffed for update2/update3 notifications
+	Diff bool
+	// can be used in "where" conditions
+	Condition bool
+}
+
+// InternalColumnPolicy is the single place, that defines the internal columns. Internal columns are never written
+// by clients, and their values are not validated against the table schema.
+type InternalColumnPolicy struct {
+	columns map[string]InternalColumn
+}
+
+func NewInternalColumnPolicy(columns ...InternalColumn) *InternalColumnPolicy {
+	policy := &InternalColumnPolicy{columns: map[string]InternalColumn{}}
+	for _, column := range columns {
+		policy.Register(column)
+	}
+	return policy
+}
+
+// InternalColumns is the policy used by the server
+var InternalColumns = NewInternalColumnPolicy(
+	// the row UUID is a part of the key, monitor notifications carry it as the row update key
+	InternalColumn{Name: COL_UUID, Select: true, Condition: true},
+	// changed by every modification, so it is not a part of the modify diff
+	InternalColumn{Name: COL_VERSION, Select: true, Monitor: true},
+)
+
+// Register adds a new internal column or replaces the existing one, should be called before the server starts.
+func (p *InternalColumnPolicy) Register(column InternalColumn) {
+	p.columns[column.Name] = column
+}
+
+func (p *InternalColumnPolicy) IsInternal(column string) bool {
+	_, ok := p.columns[column]
+	return ok
+}
+
+func (p *InternalColumnPolicy) Get(column string) (InternalColumn, bool) {
+	c, ok := p.columns[column]
+	return c, ok
+}
+
+// StripForMonitor removes from the row the internal columns, which are not sent in monitor notifications
+func (p *InternalColumnPolicy) StripForMonitor(row map[string]interface{}) {
+	for name, column := range p.columns {
+		if !column.Monitor {
+			delete(row, name)
+		}
+	}
+}
+
+// StrippedForMonitor returns true if the column is an internal column, which is not sent in monitor notifications
+func (p *InternalColumnPolicy) StrippedForMonitor(column string) bool {
+	c, ok := p.columns[column]
+	return ok && !c.Monitor
+}
+
+// StripForSelect removes from the row the internal columns, which are not returned by select
+func (p *InternalColumnPolicy) StripForSelect(row map[string]interface{}) {
+	for name, column := range p.columns {
+		if !column.Select {
+			delete(row, name)
+		}
+	}
+}
+
+// Diffed returns true if the column changes are reported in modify notifications
+func (p *InternalColumnPolicy) Diffed(column string) bool {
+	c, ok := p.columns[column]
+	return !ok || c.Diff
+}
@@ -0,0 +1,60 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func TestInternalColumnPolicy(t *testing.T) {
+	policy := NewInternalColumnPolicy(
+		InternalColumn{Name: COL_UUID, Select: true, Condition: true},
+		InternalColumn{Name: COL_VERSION, Select: true, Monitor: true},
+	)
+	policy.Register(InternalColumn{Name: "_envelope"})
+	assert.True(t, policy.IsInternal("_envelope"))
+	assert.False(t, policy.IsInternal("name"))
+	assert.False(t, policy.Diffed(COL_VERSION))
+	assert.True(t, policy.Diffed("name"))
+
+	row := map[string]interface{}{COL_UUID: "u", COL_VERSION: "v", "_envelope": "e", "name": "n"}
+	policy.StripForMonitor(row)
+	assert.Equal(t, map[string]interface{}{COL_VERSION: "v", "name": "n"}, row)
+
+	row = map[string]interface{}{COL_UUID: "u", COL_VERSION: "v", "_envelope": "e", "name": "n"}
+	policy.StripForSelect(row)
+	assert.Equal(t, map[string]interface{}{COL_UUID: "u", COL_VERSION: "v", "name": "n"}, row)
+}
+
+func TestReduceRowByColumnsCopiesRow(t *testing.T) {
+	row := map[string]interface{}{COL_UUID: "u", COL_VERSION: "v", "name": "n"}
+	reduced, err := reduceRowByColumns(&libovsdb.TableSchema{}, &row, nil)
+	assert.Nil(t, err)
+	(*reduced)["name"] = "changed"
+	assert.Equal(t, "n", row["name"])
+}
+
+func TestMonitorModifyIgnoresVersion(t *testing.T) {
+	tableSchema := libovsdb.TableSchema{Columns: map[string]*libovsdb.ColumnSchema{"c1": {Type: libovsdb.TypeString}}}
+	row := map[string]interface{}{"c1": "v1"}
+	setRowUUID(&row, ROW_UUID)
+	setRowVersion(&row)
+	prev, err := json.Marshal(row)
+	assert.Nil(t, err)
+	setRowVersion(&row)
+	modified, err := json.Marshal(row)
+	assert.Nil(t, err)
+
+	u := mcrToUpdater(ovsjson.MonitorCondRequest{}, "", &tableSchema, false)
+	update, _, err := u.prepareRowUpdate(&clientv3.Event{Type: mvccpb.PUT,
+		PrevKv: &mvccpb.KeyValue{Key: []byte("key/db/table/000"), Value: prev},
+		Kv:     &mvccpb.KeyValue{Key: []byte("key/db/table/000"), Value: modified, CreateRevision: 1, ModRevision: 2}})
+	assert.Nil(t, err)
+	assert.Nil(t, update)
+}
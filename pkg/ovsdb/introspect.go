@@ -0,0 +1,136 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+// ConnectionInfo describes a client connection and its monitors
+type ConnectionInfo struct {
+	Address  string    `json:"address"`
+	Identity *Identity `json:"identity,omitempty"`
+	// the monitored databases, sorted by their names
+	Databases []string      `json:"databases"`
+	Monitors  []MonitorInfo `json:"monitors"`
+	// the number of the notifications written to the client, and the number of the notifications, which wait for the
+	// connection writer
+	NotificationsSent uint64 `json:"notifications-sent"`
+	QueueLength       int    `json:"queue-length"`
+}
+
+// MonitorInfo describes a monitor of a client
+type MonitorInfo struct {
+	DBName    string      `json:"db-name"`
+	JSONValue interface{} `json:"json-value"`
+	// the request method of the monitor: monitor, monitor_cond or monitor_cond_since
+	Method string `json:"method"`
+	// the monitored tables, sorted by their names
+	Tables []string `json:"tables"`
+	// the number of the notifications of the monitor written to the client, and the number of its updates, which wait
+	// for the monitor notifier
+	NotificationsSent uint64 `json:"notifications-sent"`
+	QueueLength       int    `json:"queue-length"`
+}
+
+// monitorMethods are the request methods of the monitor notification types
+var monitorMethods = map[ovsjson.UpdateNotificationType]string{
+	ovsjson.Update:  "monitor",
+	ovsjson.Update2: "monitor_cond",
+	ovsjson.Update3: "monitor_cond_since",
+}
+
+// Connections returns the connected clients and their monitors, sorted by the client addresses and the monitor
+// json-values
+func (a *Admin) Connections() []ConnectionInfo {
+	connections := []ConnectionInfo{}
+	for _, ch := range a.getHandlers() {
+		connections = append(connections, ch.connectionInfo())
+	}
+	sort.SliceStable(connections, func(i, j int) bool {
+		return connections[i].Address < connections[j].Address
+	})
+	return connections
+}
+
+// ListConnections reports the connected clients, so the clients, which overload the server, can be found. If the
+// database name is provided, only the clients, which monitor the database, are reported.
+// "params": [<db-name>]  <db-name> is optional
+// Returns: "result": [{"address": <client-address>, "identity": <identity>, "databases": [<db-name>, ...],
+// "monitors": [{"db-name": <db-name>, "json-value": <json-value>, "method": <method>, "tables": [<table>, ...],
+// "notifications-sent": <number>, "queue-length": <number>}, ...], "notifications-sent": <number>,
+// "queue-length": <number>}, ...]
+func (a *Admin) ListConnections(ctx context.Context, params []interface{}) (interface{}, error) {
+	a.log.V(5).Info("list connections request", "params", params)
+	if len(params) > 1 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(params))
+	}
+	connections := a.Connections()
+	if len(params) == 0 {
+		return connections, nil
+	}
+	dbName, ok := params[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong database name %v", params[0])
+	}
+	if _, ok := a.db.GetSchemas()[dbName]; !ok {
+		return nil, fmt.Errorf("unknown database")
+	}
+	monitoring := []ConnectionInfo{}
+	for _, connection := range connections {
+		i := sort.SearchStrings(connection.Databases, dbName)
+		if i < len(connection.Databases) && connection.Databases[i] == dbName {
+			monitoring = append(monitoring, connection)
+		}
+	}
+	return monitoring, nil
+}
+
+func (ch *Handler) connectionInfo() ConnectionInfo {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	info := ConnectionInfo{
+		Address:   ch.GetClientAddress(),
+		Identity:  ch.identity,
+		Databases: []string{},
+		Monitors:  []MonitorInfo{},
+	}
+	if ch.writer != nil {
+		info.NotificationsSent, info.QueueLength = ch.writer.stats()
+	}
+	databases := map[string]bool{}
+	jsonValues := make([]string, 0, len(ch.handlerMonitorData))
+	for jsonValue := range ch.handlerMonitorData {
+		jsonValues = append(jsonValues, jsonValue)
+	}
+	sort.Strings(jsonValues)
+	for _, jsonValue := range jsonValues {
+		hmd := ch.handlerMonitorData[jsonValue]
+		tables := map[string]bool{}
+		for _, key := range hmd.updatersKeys {
+			tables[key.TableName] = true
+		}
+		monitor := MonitorInfo{
+			DBName:    hmd.dataBaseName,
+			JSONValue: hmd.jsonValue,
+			Method:    monitorMethods[hmd.notificationType],
+			Tables:    make([]string, 0, len(tables)),
+		}
+		if hmd.notifications != nil {
+			monitor.NotificationsSent, monitor.QueueLength = hmd.notifications.stats()
+		}
+		if !databases[hmd.dataBaseName] {
+			databases[hmd.dataBaseName] = true
+			info.Databases = append(info.Databases, hmd.dataBaseName)
+		}
+		for table := range tables {
+			monitor.Tables = append(monitor.Tables, table)
+		}
+		sort.Strings(monitor.Tables)
+		info.Monitors = append(info.Monitors, monitor)
+	}
+	sort.Strings(info.Databases)
+	return info
+}
@@ -0,0 +1,71 @@
+package ovsdb
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func TestAdminListConnections(t *testing.T) {
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {}, "T2": {}},
+	}}
+	db := DatabaseMock{Response: schemas}
+	handler := NewHandler(context.Background(), &db, nil, klogr.New())
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	handler.SetConnection(&jrpcServerRecorder{}, c1)
+	handler.SetIdentity(&Identity{Name: "ovn-controller", Method: AUTH_METHOD_NONE}, nil)
+	admin := NewAdmin(&db, klogr.New())
+	admin.AddHandler(handler)
+	_, err := handler.addMonitor([]interface{}{DB_NAME, "mon1", map[string]interface{}{
+		"T1": []interface{}{map[string]interface{}{}}, "T2": []interface{}{map[string]interface{}{}}}}, ovsjson.Update2)
+	assert.Nil(t, err)
+	_, err = handler.addMonitor([]interface{}{DB_NAME, "mon2", map[string]interface{}{
+		"T1": []interface{}{map[string]interface{}{}}}}, ovsjson.Update)
+	assert.Nil(t, err)
+
+	// a notification of mon1 is written, while the notification of mon2 waits for its notifier
+	row := map[string]interface{}{"c1": "v1"}
+	updates := ovsjson.TableUpdates{"T1": {ROW_UUID: {New: &row}}}
+	mon1 := handler.handlerMonitorData[jsonValueToString("mon1")]
+	handler.enqueue(mon1, notificationEvent{updates: updates}, nil)
+	handler.startNotifier(jsonValueToString("mon1"))
+	assert.Nil(t, mon1.notifications.drain(context.Background()))
+	assert.Nil(t, handler.FlushNotifications(context.Background()))
+	handler.enqueue(handler.handlerMonitorData[jsonValueToString("mon2")], notificationEvent{updates: updates}, nil)
+
+	resp, err := admin.ListConnections(context.Background(), []interface{}{})
+	assert.Nil(t, err)
+	connections := resp.([]ConnectionInfo)
+	if assert.Len(t, connections, 1) {
+		connection := connections[0]
+		assert.Equal(t, handler.GetClientAddress(), connection.Address)
+		assert.Equal(t, "ovn-controller", connection.Identity.Name)
+		assert.Equal(t, []string{DB_NAME}, connection.Databases)
+		assert.Equal(t, uint64(1), connection.NotificationsSent)
+		assert.Equal(t, 0, connection.QueueLength)
+		assert.Equal(t, []MonitorInfo{
+			{DBName: DB_NAME, JSONValue: "mon1", Method: "monitor_cond", Tables: []string{"T1", "T2"},
+				NotificationsSent: 1, QueueLength: 0},
+			{DBName: DB_NAME, JSONValue: "mon2", Method: "monitor", Tables: []string{"T1"},
+				NotificationsSent: 0, QueueLength: 1},
+		}, connection.Monitors)
+	}
+
+	resp, err = admin.ListConnections(context.Background(), []interface{}{DB_NAME})
+	assert.Nil(t, err)
+	assert.Len(t, resp, 1)
+	_, err = admin.ListConnections(context.Background(), []interface{}{"unknown"})
+	assert.NotNil(t, err)
+	_, err = admin.ListConnections(context.Background(), []interface{}{DB_NAME, "extra"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,491 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"k8s.io/klog/v2"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+// A transaction, which has more compares or operations than etcd allows in a single transaction (ETCD_MAX_TXN_OPS),
+// is committed by a chain of etcd transactions:
+//  1. the journal key of the database is created with the intent of the commit, if it doesn't exist and the first
+//     compares succeed.
+//  2. the rest of the compares are verified, the journal blocks the other writers meanwhile, so the compares are
+//     verified as if they were a part of the first transaction. The intent is marked as applying by the last one.
+//  3. the operations are applied in chunks, each one is guarded by the journal revision, the last chunk deletes the
+//     journal.
+// The other transactions, and the monitors initial data, are guarded by the absence of the journal, they wait until it
+// is deleted. The monitors receive the journal changes in order with the rows changes, and notify the changes of the
+// chained commit together, when the journal is deleted.
+//
+// The journal is a write-ahead intent: it holds the operations of the commit and the lease of its server (the owner).
+// When the owner fails, its lease expires, and the commit is completed by the next server, which reads the journal,
+// or by the owner itself when it restarts (RecoverJournal): an applying commit is rolled forward from the journal,
+// otherwise it is rolled back by deleting the journal, as none of its operations was applied yet.
+// The storage migrations hold the journal as well (StorageMigrator), it's never recovered by the transactions, they
+// wait until the migration completes, or fail if the migration was interrupted.
+
+// JournalTimeout is the time a transaction waits for a chained commit to complete. When it expires, the server of the
+// commit is assumed to have failed, and the commit is rolled forward by the waiting transaction, or aborted if its
+// compares were not verified yet.
+var JournalTimeout = 10 * time.Second
+
+// journalIntent is the value of the journal key
+type journalIntent struct {
+	// all the compares succeeded, and the operations are being applied
+	Applying bool `json:"applying"`
+	// the lease of the server of the commit
+	Owner int64       `json:"owner,omitempty"`
+	Ops   []journalOp `json:"ops,omitempty"`
+	// the target storage format of the storage migration, which holds the journal to fence the transactions
+	Migration int `json:"migration,omitempty"`
+}
+
+type journalOp struct {
+	Key      string `json:"key"`
+	RangeEnd string `json:"range-end,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Delete   bool   `json:"delete,omitempty"`
+}
+
+// newJournalOps returns the puts and the deletes of the operations, the reads are not replayed
+func newJournalOps(ops []clientv3.Op) []journalOp {
+	journalOps := make([]journalOp, 0, len(ops))
+	for _, op := range ops {
+		switch {
+		case op.IsPut():
+			journalOps = append(journalOps, journalOp{Key: string(op.KeyBytes()), Value: string(op.ValueBytes())})
+		case op.IsDelete():
+			journalOps = append(journalOps, journalOp{Key: string(op.KeyBytes()), RangeEnd: string(op.RangeBytes()), Delete: true})
+		}
+	}
+	return journalOps
+}
+
+func (op journalOp) etcdOp() clientv3.Op {
+	if !op.Delete {
+		return clientv3.OpPut(op.Key, op.Value)
+	}
+	if op.RangeEnd != "" {
+		return clientv3.OpDelete(op.Key, clientv3.WithRange(op.RangeEnd))
+	}
+	return clientv3.OpDelete(op.Key)
+}
+
+// journalOwners holds the sessions of the etcd clients, their leases identify the owners of the chained commits
+var journalOwners = struct {
+	sync.Mutex
+	sessions map[*clientv3.Client]*concurrency.Session
+}{sessions: map[*clientv3.Client]*concurrency.Session{}}
+
+// journalOwner returns the lease of the client session, a new session is created if the previous one expired
+func journalOwner(cli *clientv3.Client) (clientv3.LeaseID, error) {
+	journalOwners.Lock()
+	defer journalOwners.Unlock()
+	if session, ok := journalOwners.sessions[cli]; ok {
+		select {
+		case <-session.Done():
+		default:
+			return session.Lease(), nil
+		}
+	}
+	ttl := int(JournalTimeout / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+	session, err := concurrency.NewSession(cli, concurrency.WithTTL(ttl))
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+	journalOwners.sessions[cli] = session
+	go func() {
+		<-session.Done()
+		journalOwners.Lock()
+		if journalOwners.sessions[cli] == session {
+			delete(journalOwners.sessions, cli)
+		}
+		journalOwners.Unlock()
+	}()
+	return session.Lease(), nil
+}
+
+// isOwnerAlive returns false if the lease of the journal owner expired, the journals without an owner are assumed
+// to be alive until JournalTimeout expires
+func isOwnerAlive(ctx context.Context, cli *clientv3.Client, journal *mvccpb.KeyValue) bool {
+	intent := journalIntent{}
+	if err := json.Unmarshal(journal.Value, &intent); err != nil || intent.Owner == 0 {
+		return true
+	}
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	defer cancel()
+	resp, err := cli.TimeToLive(tctx, clientv3.LeaseID(intent.Owner))
+	if err != nil {
+		return true
+	}
+	// etcd returns TTL == -1 for expired or unknown leases
+	return resp.TTL != -1
+}
+
+func journalAbsent(key string) clientv3.Cmp {
+	return clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+}
+
+func journalOwned(key string, revision int64) clientv3.Cmp {
+	return clientv3.Compare(clientv3.ModRevision(key), "=", revision)
+}
+
+// chunkOps splits the operations into chunks of the given size, there is at least one chunk
+func chunkOps(ops []clientv3.Op, size int) [][]clientv3.Op {
+	chunks := [][]clientv3.Op{}
+	for start := 0; start < len(ops); start += size {
+		end := start + size
+		if end > len(ops) {
+			end = len(ops)
+		}
+		chunks = append(chunks, ops[start:end:end])
+	}
+	if len(chunks) == 0 {
+		chunks = append(chunks, []clientv3.Op{})
+	}
+	return chunks
+}
+
+func chunkCmps(cmps []clientv3.Cmp, size int) [][]clientv3.Cmp {
+	chunks := [][]clientv3.Cmp{}
+	for start := 0; start < len(cmps); start += size {
+		end := start + size
+		if end > len(cmps) {
+			end = len(cmps)
+		}
+		chunks = append(chunks, cmps[start:end:end])
+	}
+	return chunks
+}
+
+// journalOf returns the journal of a failed guarded transaction, which reads it by its last else operation, nil if
+// the transaction failed because of its other compares
+func journalOf(res *clientv3.TxnResponse) *mvccpb.KeyValue {
+	if res.Succeeded || len(res.Responses) == 0 {
+		return nil
+	}
+	rangeResp := res.Responses[len(res.Responses)-1].GetResponseRange()
+	if rangeResp == nil || len(rangeResp.Kvs) == 0 {
+		return nil
+	}
+	return rangeResp.Kvs[0]
+}
+
+// exceedsTxnLimit returns true if the transaction cannot be committed by a single etcd transaction, one compare is
+// reserved for the journal guard
+func (etcd *Etcd) exceedsTxnLimit() bool {
+	return len(etcd.Then) > ETCD_MAX_TXN_OPS || len(etcd.If)+1 > ETCD_MAX_TXN_OPS
+}
+
+func (etcd *Etcd) isReadOnly() bool {
+	for _, op := range etcd.Then {
+		if !op.IsGet() {
+			return false
+		}
+	}
+	return true
+}
+
+// commitGuarded commits the transaction if there is no chained commit in progress, otherwise returns its journal
+func (etcd *Etcd) commitGuarded() (*mvccpb.KeyValue, error) {
+	cmps := append([]clientv3.Cmp{journalAbsent(etcd.Journal)}, etcd.If...)
+	elseOps := append(append([]clientv3.Op{}, etcd.Else...), clientv3.OpGet(etcd.Journal))
+	res, err := etcd.Cli.Txn(etcd.Ctx).If(cmps...).Then(etcd.Then...).Else(elseOps...).Commit()
+	if err != nil {
+		return nil, err
+	}
+	if kv := journalOf(res); kv != nil {
+		return kv, nil
+	}
+	if !res.Succeeded {
+		res.Responses = res.Responses[:len(res.Responses)-1]
+	}
+	etcd.Res = res
+	return nil, nil
+}
+
+// commitReads reads the ranges of a read only transaction by several etcd transactions, the later ones read the
+// revision of the first one, so the result is a consistent snapshot
+func (etcd *Etcd) commitReads() (*mvccpb.KeyValue, error) {
+	var combined *clientv3.TxnResponse
+	for i, chunk := range chunkOps(etcd.Then, ETCD_MAX_TXN_OPS) {
+		if i == 0 {
+			cmps := append([]clientv3.Cmp{journalAbsent(etcd.Journal)}, etcd.If...)
+			res, err := etcd.Cli.Txn(etcd.Ctx).If(cmps...).Then(chunk...).Else(clientv3.OpGet(etcd.Journal)).Commit()
+			if err != nil {
+				return nil, err
+			}
+			if kv := journalOf(res); kv != nil {
+				return kv, nil
+			}
+			if !res.Succeeded {
+				res.Responses = nil
+				etcd.Res = res
+				return nil, nil
+			}
+			combined = res
+			continue
+		}
+		pinned := make([]clientv3.Op, 0, len(chunk))
+		for _, op := range chunk {
+			if op.Rev() == 0 {
+				clientv3.WithRev(combined.Header.Revision)(&op)
+			}
+			pinned = append(pinned, op)
+		}
+		res, err := etcd.Cli.Txn(etcd.Ctx).Then(pinned...).Commit()
+		if err != nil {
+			return nil, err
+		}
+		combined.Responses = append(combined.Responses, res.Responses...)
+	}
+	etcd.Res = combined
+	return nil, nil
+}
+
+// commitChained commits the transaction by a chain of etcd transactions, see above. Returns the journal of another
+// chained commit in progress.
+func (etcd *Etcd) commitChained() (*mvccpb.KeyValue, error) {
+	owner, err := journalOwner(etcd.Cli)
+	if err != nil {
+		return nil, err
+	}
+	cmpChunks := chunkCmps(etcd.If, ETCD_MAX_TXN_OPS-1)
+	intent := journalIntent{Applying: len(cmpChunks) <= 1, Owner: int64(owner), Ops: newJournalOps(etcd.Then)}
+	value, err := json.Marshal(intent)
+	if err != nil {
+		return nil, err
+	}
+	cmps := []clientv3.Cmp{journalAbsent(etcd.Journal)}
+	if len(cmpChunks) > 0 {
+		cmps = append(cmps, cmpChunks[0]...)
+	}
+	res, err := etcd.Cli.Txn(etcd.Ctx).If(cmps...).Then(clientv3.OpPut(etcd.Journal, string(value))).
+		Else(clientv3.OpGet(etcd.Journal)).Commit()
+	if err != nil {
+		return nil, err
+	}
+	if kv := journalOf(res); kv != nil {
+		return kv, nil
+	}
+	if !res.Succeeded {
+		res.Responses = nil
+		etcd.Res = res
+		return nil, nil
+	}
+	revision := res.Header.Revision
+
+	for i := 1; i < len(cmpChunks); i++ {
+		then := []clientv3.Op{}
+		if i == len(cmpChunks)-1 {
+			intent.Applying = true
+			if value, err = json.Marshal(intent); err != nil {
+				etcd.releaseJournal(revision)
+				return nil, err
+			}
+			then = append(then, clientv3.OpPut(etcd.Journal, string(value)))
+		}
+		cmps := append([]clientv3.Cmp{journalOwned(etcd.Journal, revision)}, cmpChunks[i]...)
+		res, err = etcd.Cli.Txn(etcd.Ctx).If(cmps...).Then(then...).Commit()
+		if err != nil {
+			etcd.releaseJournal(revision)
+			return nil, err
+		}
+		if !res.Succeeded {
+			etcd.releaseJournal(revision)
+			res.Responses = nil
+			etcd.Res = res
+			return nil, nil
+		}
+		if len(then) > 0 {
+			revision = res.Header.Revision
+		}
+	}
+
+	// if a chunk fails, the journal is left, and the commit is rolled forward by the next transaction of the database
+	combined := &clientv3.TxnResponse{Succeeded: true}
+	chunks := chunkOps(etcd.Then, ETCD_MAX_TXN_OPS-1)
+	for i, chunk := range chunks {
+		ops := chunk
+		if i == len(chunks)-1 {
+			ops = append(append([]clientv3.Op{}, chunk...), clientv3.OpDelete(etcd.Journal))
+		}
+		res, err = etcd.Cli.Txn(etcd.Ctx).If(journalOwned(etcd.Journal, revision)).Then(ops...).Commit()
+		if err != nil {
+			return nil, err
+		}
+		combined.Header = res.Header
+		if !res.Succeeded {
+			klog.Warningf("chained commit %s of revision %d was rolled forward by another transaction", etcd.Journal, revision)
+			break
+		}
+		combined.Responses = append(combined.Responses, res.Responses[:len(chunk)]...)
+	}
+	klog.V(5).Infof("chained commit %s: %d compares, %d operations, %d transactions, revision %d", etcd.Journal,
+		len(etcd.If), len(etcd.Then), len(cmpChunks)+len(chunks), combined.Header.Revision)
+	etcd.Res = combined
+	return nil, nil
+}
+
+// releaseJournal deletes the journal of a chained commit, which compares failed
+func (etcd *Etcd) releaseJournal(revision int64) {
+	_, err := etcd.Cli.Txn(etcd.Ctx).If(journalOwned(etcd.Journal, revision)).Then(clientv3.OpDelete(etcd.Journal)).Commit()
+	if err != nil {
+		klog.Errorf("release journal %s of revision %d: %v", etcd.Journal, revision, err)
+	}
+}
+
+// waitJournal waits until the chained commit of the journal completes, or recovers it if its owner failed or it
+// doesn't complete in JournalTimeout
+func waitJournal(ctx context.Context, cli *clientv3.Client, journal *mvccpb.KeyValue) error {
+	if !isOwnerAlive(ctx, cli, journal) {
+		return recoverJournal(ctx, cli, string(journal.Key))
+	}
+	wctx, cancel := context.WithTimeout(ctx, JournalTimeout)
+	defer cancel()
+	klog.V(5).Infof("waiting for chained commit %s of revision %d", string(journal.Key), journal.ModRevision)
+	for wresp := range cli.Watch(wctx, string(journal.Key), clientv3.WithRev(journal.ModRevision+1)) {
+		if wresp.Err() != nil {
+			// the journal is read again by the retried transaction
+			return nil
+		}
+		for _, ev := range wresp.Events {
+			if ev.Type == clientv3.EventTypeDelete {
+				return nil
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return recoverJournal(ctx, cli, string(journal.Key))
+}
+
+// recoverJournal completes a chained commit of a failed server, it is rolled forward if its compares succeeded,
+// otherwise it is aborted. The recovery is guarded by the journal revision, so it is safe if the server completes the
+// commit concurrently.
+func recoverJournal(ctx context.Context, cli *clientv3.Client, key string) error {
+	resp, err := cli.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil
+	}
+	kv := resp.Kvs[0]
+	intent := journalIntent{}
+	if err := json.Unmarshal(kv.Value, &intent); err != nil {
+		return err
+	}
+	if intent.Migration != 0 {
+		if isOwnerAlive(ctx, cli, kv) {
+			// the transactions wait until the migration completes
+			return nil
+		}
+		return fmt.Errorf("database is fenced by the interrupted migration to storage format %d, it's rolled back by the next storage migration", intent.Migration)
+	}
+	owned := journalOwned(key, kv.ModRevision)
+	if !intent.Applying {
+		klog.Warningf("aborting stale chained commit %s of revision %d", key, kv.ModRevision)
+		_, err = cli.Txn(ctx).If(owned).Then(clientv3.OpDelete(key)).Commit()
+		return err
+	}
+	klog.Warningf("rolling forward stale chained commit %s of revision %d, %d operations", key, kv.ModRevision, len(intent.Ops))
+	ops := make([]clientv3.Op, 0, len(intent.Ops))
+	for _, op := range intent.Ops {
+		ops = append(ops, op.etcdOp())
+	}
+	chunks := chunkOps(ops, ETCD_MAX_TXN_OPS-1)
+	for i, chunk := range chunks {
+		if i == len(chunks)-1 {
+			chunk = append(append([]clientv3.Op{}, chunk...), clientv3.OpDelete(key))
+		}
+		res, err := cli.Txn(ctx).If(owned).Then(chunk...).Commit()
+		if err != nil {
+			return err
+		}
+		if !res.Succeeded {
+			// completed by its server or by another transaction
+			return nil
+		}
+	}
+	return nil
+}
+
+// RecoverJournal completes the chained commit of the database, which was interrupted by a failure of its server. It
+// is called on the server startup, the commits of live servers are left to them. Returns true if a commit was
+// recovered.
+func RecoverJournal(ctx context.Context, cli *clientv3.Client, dbName string) (bool, error) {
+	key := common.NewJournalKey(dbName).String()
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	resp, err := cli.Get(tctx, key)
+	cancel()
+	if err != nil {
+		return false, err
+	}
+	if len(resp.Kvs) == 0 || isOwnerAlive(ctx, cli, resp.Kvs[0]) {
+		return false, nil
+	}
+	return true, recoverJournal(ctx, cli, key)
+}
+
+// journalBuffer holds the watch events of the chained commits in progress, so the monitors notify the changes of a
+// chained commit together
+type journalBuffer struct {
+	key    string
+	open   bool
+	events []*clientv3.Event
+}
+
+// add returns the events, which can be notified, and the revision of the last one. The events of a chained commit are
+// returned when its journal is deleted, the journal events are removed.
+func (jb *journalBuffer) add(events []*clientv3.Event, revision int64) ([]*clientv3.Event, int64) {
+	if !jb.open && !jb.hasJournal(events) {
+		return events, revision
+	}
+	ready := []*clientv3.Event{}
+	var readyRevision int64
+	for _, ev := range events {
+		if ev.Kv != nil && string(ev.Kv.Key) == jb.key {
+			jb.open = ev.Type != clientv3.EventTypeDelete
+			if !jb.open {
+				ready = append(ready, jb.events...)
+				jb.events = nil
+				readyRevision = ev.Kv.ModRevision
+			}
+			continue
+		}
+		if jb.open {
+			jb.events = append(jb.events, ev)
+		} else {
+			ready = append(ready, ev)
+			readyRevision = ev.Kv.ModRevision
+		}
+	}
+	if !jb.open {
+		readyRevision = revision
+	}
+	return ready, readyRevision
+}
+
+func (jb *journalBuffer) hasJournal(events []*clientv3.Event) bool {
+	for _, ev := range events {
+		if ev.Kv != nil && string(ev.Kv.Key) == jb.key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func TestJournalBuffer(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	journal := common.NewJournalKey(DB_NAME).String()
+	event := func(key string, typ mvccpb.Event_EventType, rev int64) *clientv3.Event {
+		return &clientv3.Event{Type: typ, Kv: &mvccpb.KeyValue{Key: []byte(key), ModRevision: rev}}
+	}
+	jb := journalBuffer{key: journal}
+
+	events := []*clientv3.Event{event("a", mvccpb.PUT, 1)}
+	ready, revision := jb.add(events, 1)
+	assert.Equal(t, events, ready)
+	assert.Equal(t, int64(1), revision)
+
+	// the events of the chained commit are buffered until the journal is deleted
+	ready, revision = jb.add([]*clientv3.Event{event("b", mvccpb.PUT, 2), event(journal, mvccpb.PUT, 3), event("c", mvccpb.PUT, 4)}, 4)
+	assert.Equal(t, []*clientv3.Event{event("b", mvccpb.PUT, 2)}, ready)
+	assert.Equal(t, int64(2), revision)
+	ready, _ = jb.add([]*clientv3.Event{event("d", mvccpb.DELETE, 5)}, 5)
+	assert.Empty(t, ready)
+	ready, revision = jb.add([]*clientv3.Event{event("e", mvccpb.PUT, 6), event(journal, mvccpb.DELETE, 6), event("f", mvccpb.PUT, 7)}, 7)
+	assert.Equal(t, []*clientv3.Event{event("c", mvccpb.PUT, 4), event("d", mvccpb.DELETE, 5), event("e", mvccpb.PUT, 6), event("f", mvccpb.PUT, 7)}, ready)
+	assert.Equal(t, int64(7), revision)
+}
+
+func testJournalEtcd(t *testing.T, cli *clientv3.Client, n int) *Etcd {
+	etcd := &Etcd{Cli: cli, Ctx: context.TODO(), Journal: common.NewJournalKey(DB_NAME).String()}
+	etcd.Clear()
+	for i := 0; i < n; i++ {
+		key := common.NewDataKey(DB_NAME, "T1", fmt.Sprintf("u%03d", i)).String()
+		etcd.If = append(etcd.If, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+		etcd.Then = append(etcd.Then, clientv3.OpPut(key, "1"))
+	}
+	return etcd
+}
+
+func testJournalCount(t *testing.T, cli *clientv3.Client) (int64, int64) {
+	res, err := cli.Get(context.TODO(), common.NewTableKey(DB_NAME, "T1").String(), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	journal, err := cli.Get(context.TODO(), common.NewJournalKey(DB_NAME).String(), clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	return res.Count, journal.Count
+}
+
+func TestJournalChainedCommit(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	fi := NewFaultInjector()
+	defer fi.Inject(cli)()
+
+	// the compares and the operations exceed a single etcd transaction
+	etcd := testJournalEtcd(t, cli, 3*ETCD_MAX_TXN_OPS)
+	assert.True(t, etcd.exceedsTxnLimit())
+	assert.Nil(t, etcd.Commit())
+	assert.True(t, etcd.Res.Succeeded)
+	assert.Equal(t, 3*ETCD_MAX_TXN_OPS, len(etcd.Res.Responses))
+	rows, journals := testJournalCount(t, cli)
+	assert.Equal(t, int64(3*ETCD_MAX_TXN_OPS), rows)
+	assert.Equal(t, int64(0), journals)
+
+	// a failed compare of a later chunk aborts the commit before any operation is applied
+	_, err = cli.Delete(context.TODO(), common.NewTableKey(DB_NAME, "T1").String(), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	_, err = cli.Put(context.TODO(), common.NewDataKey(DB_NAME, "T1", fmt.Sprintf("u%03d", 2*ETCD_MAX_TXN_OPS)).String(), "0")
+	assert.Nil(t, err)
+	etcd = testJournalEtcd(t, cli, 3*ETCD_MAX_TXN_OPS)
+	calls := fi.Calls(FAULT_OP_TXN)
+	assert.Nil(t, etcd.Commit())
+	assert.False(t, etcd.Res.Succeeded)
+	assert.Equal(t, 4, fi.Calls(FAULT_OP_TXN)-calls)
+	rows, journals = testJournalCount(t, cli)
+	assert.Equal(t, int64(1), rows)
+	assert.Equal(t, int64(0), journals)
+}
+
+func TestJournalRecovery(t *testing.T) {
+	defer func(timeout time.Duration) { JournalTimeout = timeout }(JournalTimeout)
+	JournalTimeout = 100 * time.Millisecond
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	journal := common.NewJournalKey(DB_NAME).String()
+	putIntent := func(applying bool, n int) {
+		intent := journalIntent{Applying: applying}
+		for i := 0; i < n; i++ {
+			intent.Ops = append(intent.Ops, journalOp{Key: common.NewDataKey(DB_NAME, "T1", fmt.Sprintf("u%03d", i)).String(), Value: "1"})
+		}
+		value, err := json.Marshal(intent)
+		assert.Nil(t, err)
+		_, err = cli.Put(context.TODO(), journal, string(value))
+		assert.Nil(t, err)
+	}
+
+	// the intent of a failed server, which compares were not verified, is aborted
+	putIntent(false, 10)
+	etcd := testJournalEtcd(t, cli, 1)
+	assert.Nil(t, etcd.Commit())
+	assert.True(t, etcd.Res.Succeeded)
+	rows, journals := testJournalCount(t, cli)
+	assert.Equal(t, int64(1), rows)
+	assert.Equal(t, int64(0), journals)
+
+	// the applying intent is rolled forward, before the transaction is committed
+	putIntent(true, 2*ETCD_MAX_TXN_OPS)
+	etcd = testJournalEtcd(t, cli, 1)
+	etcd.If = nil
+	etcd.Then = []clientv3.Op{clientv3.OpPut(common.NewDataKey(DB_NAME, "T1", "u000").String(), "2")}
+	assert.Nil(t, etcd.Commit())
+	assert.True(t, etcd.Res.Succeeded)
+	rows, journals = testJournalCount(t, cli)
+	assert.Equal(t, int64(2*ETCD_MAX_TXN_OPS), rows)
+	assert.Equal(t, int64(0), journals)
+	res, err := cli.Get(context.TODO(), common.NewDataKey(DB_NAME, "T1", "u000").String())
+	assert.Nil(t, err)
+	assert.Equal(t, "2", string(res.Kvs[0].Value))
+
+	// the monitors data is read after the chained commit completes
+	putIntent(true, 3)
+	db, err := NewDatabaseEtcd(cli)
+	assert.Nil(t, err)
+	start := time.Now()
+	_, err = db.GetData([]common.Key{common.NewTableKey(DB_NAME, "T1")})
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) >= JournalTimeout)
+	_, journals = testJournalCount(t, cli)
+	assert.Equal(t, int64(0), journals)
+}
+
+func TestJournalOwnerRecovery(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	ctx := context.TODO()
+	journal := common.NewJournalKey(DB_NAME).String()
+	putIntent := func(owner clientv3.LeaseID, n int) {
+		intent := journalIntent{Applying: true, Owner: int64(owner)}
+		for i := 0; i < n; i++ {
+			intent.Ops = append(intent.Ops, journalOp{Key: common.NewDataKey(DB_NAME, "T1", fmt.Sprintf("u%03d", i)).String(), Value: "1"})
+		}
+		value, err := json.Marshal(intent)
+		assert.Nil(t, err)
+		_, err = cli.Put(ctx, journal, string(value))
+		assert.Nil(t, err)
+	}
+
+	// the commit of a live server is left to it
+	owner, err := journalOwner(cli)
+	assert.Nil(t, err)
+	putIntent(owner, 3)
+	recovered, err := RecoverJournal(ctx, cli, DB_NAME)
+	assert.Nil(t, err)
+	assert.False(t, recovered)
+	_, journals := testJournalCount(t, cli)
+	assert.Equal(t, int64(1), journals)
+
+	// the commit of a failed server is rolled forward on restart
+	lease, err := cli.Grant(ctx, 60)
+	assert.Nil(t, err)
+	_, err = cli.Revoke(ctx, lease.ID)
+	assert.Nil(t, err)
+	putIntent(lease.ID, 3)
+	recovered, err = RecoverJournal(ctx, cli, DB_NAME)
+	assert.Nil(t, err)
+	assert.True(t, recovered)
+	rows, journals := testJournalCount(t, cli)
+	assert.Equal(t, int64(3), rows)
+	assert.Equal(t, int64(0), journals)
+
+	// and by the other servers without waiting for the journal timeout
+	putIntent(lease.ID, 5)
+	db, err := NewDatabaseEtcd(cli)
+	assert.Nil(t, err)
+	start := time.Now()
+	_, err = db.GetData([]common.Key{common.NewTableKey(DB_NAME, "T1")})
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) < JournalTimeout)
+	rows, journals = testJournalCount(t, cli)
+	assert.Equal(t, int64(5), rows)
+	assert.Equal(t, int64(0), journals)
+}
@@ -0,0 +1,98 @@
+package ovsdb
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+)
+
+// The inactivity probe (RFC 7047, section 4.1.11): when nothing is received from the client for the probe interval,
+// the server sends it an "echo" request, and if nothing is received during the probe timeout too, the connection is
+// considered dead and closed, which releases the monitors and the locks of the client.
+
+// jrpcCaller is implemented by the jrpc2 server, it sends requests to the client
+type jrpcCaller interface {
+	Callback(ctx context.Context, method string, params interface{}) (*jrpc2.Response, error)
+}
+
+type activityReader struct {
+	reader  io.Reader
+	handler *Handler
+}
+
+func (ar *activityReader) Read(p []byte) (int, error) {
+	n, err := ar.reader.Read(p)
+	if n > 0 {
+		ar.handler.touch()
+	}
+	return n, err
+}
+
+// TrackActivity returns a reader of the client connection, which records the time of the last received data
+func (ch *Handler) TrackActivity(reader io.Reader) io.Reader {
+	ch.touch()
+	return &activityReader{reader: reader, handler: ch}
+}
+
+func (ch *Handler) touch() {
+	atomic.StoreInt64(&ch.lastActivity, time.Now().UnixNano())
+}
+
+// idle returns the time since the last data received from the client
+func (ch *Handler) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&ch.lastActivity)))
+}
+
+// KeepAlive probes the inactive client by echo requests, and closes its connection if the client doesn't respond within
+// the timeout. The default timeout is the interval, an interval of 0 disables the probes. It returns when the handler
+// context is done or the connection is closed.
+func (ch *Handler) KeepAlive(interval, timeout time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	if timeout <= 0 {
+		timeout = interval
+	}
+	caller, ok := ch.jrpcServer.(jrpcCaller)
+	if !ok {
+		ch.logger().Info("the connection doesn't support echo requests, the inactivity probe is disabled")
+		return
+	}
+	var probed time.Time
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ch.handlerContext.Done():
+			return
+		case <-timer.C:
+		}
+		idle := ch.idle()
+		if !probed.IsZero() {
+			if idle >= time.Since(probed) {
+				ch.logger().Info("inactivity probe failed, closing the connection", "idle", idle)
+				ch.jrpcServer.Stop()
+				return
+			}
+			// the client responded
+			probed = time.Time{}
+		}
+		if idle < interval {
+			timer.Reset(interval - idle)
+			continue
+		}
+		ch.logger().V(5).Info("inactivity probe", "idle", idle)
+		probed = time.Now()
+		go func() {
+			ctx, cancel := context.WithTimeout(ch.handlerContext, timeout)
+			defer cancel()
+			if _, err := caller.Callback(ctx, "echo", []interface{}{}); err != nil {
+				ch.logger().V(5).Info("echo request", "err", err)
+			}
+		}()
+		timer.Reset(timeout)
+	}
+}
@@ -0,0 +1,82 @@
+package ovsdb
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+)
+
+// testKeepAlive serves a handler with the inactivity probe on one side of a pipe, and returns the number of the echo
+// requests received by the client on the other side, and the status channel of the server
+func testKeepAlive(t *testing.T, respond bool) (*int32, chan jrpc2.ServerStatus, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := NewHandler(ctx, &DatabaseMock{}, nil, klogr.New())
+	sconn, cconn := net.Pipe()
+	srv := jrpc2.NewServer(handler.Map{}, &jrpc2.ServerOptions{AllowPush: true, AllowV1: true})
+	ch.SetConnection(srv, sconn)
+	srv.Start(channel.RawJSON(ch.TrackActivity(sconn), sconn))
+	go ch.KeepAlive(50*time.Millisecond, 50*time.Millisecond)
+
+	var echoes int32
+	var cli *jrpc2.Client
+	reader := channel.RawJSON(cconn, cconn)
+	if respond {
+		cli = jrpc2.NewClient(reader, &jrpc2.ClientOptions{AllowV1: true,
+			OnCallback: func(ctx context.Context, req *jrpc2.Request) (interface{}, error) {
+				atomic.AddInt32(&echoes, 1)
+				return []interface{}{}, nil
+			}})
+	} else {
+		// the requests are read and ignored
+		go func() {
+			for {
+				if _, err := reader.Recv(); err != nil {
+					return
+				}
+				atomic.AddInt32(&echoes, 1)
+			}
+		}()
+	}
+	status := make(chan jrpc2.ServerStatus, 1)
+	go func() {
+		status <- srv.WaitStatus()
+	}()
+	return &echoes, status, func() {
+		cancel()
+		srv.Stop()
+		if cli != nil {
+			cli.Close()
+		}
+		cconn.Close()
+	}
+}
+
+func TestKeepAliveResponsiveClient(t *testing.T) {
+	echoes, status, cleanup := testKeepAlive(t, true)
+	defer cleanup()
+	select {
+	case <-status:
+		assert.Fail(t, "the connection of a responsive client was closed")
+	case <-time.After(400 * time.Millisecond):
+	}
+	assert.True(t, atomic.LoadInt32(echoes) > 1)
+}
+
+func TestKeepAliveDeadClient(t *testing.T) {
+	echoes, status, cleanup := testKeepAlive(t, false)
+	defer cleanup()
+	select {
+	case <-status:
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "the connection of a dead client wasn't closed")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(echoes))
+}
@@ -0,0 +1,129 @@
+package ovsdb
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/creachadair/jrpc2/metrics"
+)
+
+// Stages of the notification path, the latency of every stage is aggregated into a histogram
+const (
+	// from the etcd watch event receipt (or the transaction commit) to the prepared table updates
+	LATENCY_STAGE_DIFF = "diff"
+	// from the enqueue to the client notifier, until the notifier picks the updates
+	LATENCY_STAGE_QUEUE = "queue"
+	// the notification encoding and the socket write
+	LATENCY_STAGE_SEND = "send"
+	// from the etcd watch event receipt to the socket write
+	LATENCY_STAGE_TOTAL = "total"
+
+	// histogram counters are "notifications.latency.<stage>.le_<bucket>", "notifications.latency.<stage>.count" and
+	// "notifications.latency.<stage>.sum_us", the per client label is "notifications.latency.p99_ms.<client>"
+	METRIC_LATENCY_PREFIX = "notifications.latency."
+	METRIC_LATENCY_P99    = METRIC_LATENCY_PREFIX + "p99_ms."
+
+	// the number of the recent notifications, which the per client p99 is calculated from
+	latencyWindowSize = 256
+)
+
+// LatencyBuckets are the upper bounds of the latency histograms buckets, the last bucket is unbounded
+var LatencyBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond, time.Second,
+}
+
+// latencyTracer is nil if the tracing is disabled
+var latencyTracer *LatencyTracer
+
+// EnableLatencyTracing turns on the notifications latency tracing, should be called before the server starts serving.
+func EnableLatencyTracing(m *metrics.M) {
+	latencyTracer = NewLatencyTracer(m)
+}
+
+// notificationTrace holds the timestamps of a single notification on its way from etcd to the client
+type notificationTrace struct {
+	watched  time.Time
+	diffed   time.Time
+	enqueued time.Time
+	dequeued time.Time
+	sent     time.Time
+}
+
+type LatencyTracer struct {
+	metrics *metrics.M
+	mu      sync.Mutex
+	// client address -> total latencies of the recent notifications
+	clients map[string]*latencyWindow
+}
+
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+func NewLatencyTracer(m *metrics.M) *LatencyTracer {
+	return &LatencyTracer{metrics: m, clients: map[string]*latencyWindow{}}
+}
+
+// newTrace starts a trace if the tracing is enabled, otherwise returns nil
+func newTrace(watched time.Time) *notificationTrace {
+	if latencyTracer == nil {
+		return nil
+	}
+	return &notificationTrace{watched: watched, diffed: time.Now()}
+}
+
+func (lt *LatencyTracer) observe(client string, trace *notificationTrace) {
+	lt.observeStage(LATENCY_STAGE_DIFF, trace.diffed.Sub(trace.watched))
+	lt.observeStage(LATENCY_STAGE_QUEUE, trace.dequeued.Sub(trace.enqueued))
+	lt.observeStage(LATENCY_STAGE_SEND, trace.sent.Sub(trace.dequeued))
+	total := trace.sent.Sub(trace.watched)
+	lt.observeStage(LATENCY_STAGE_TOTAL, total)
+
+	lt.mu.Lock()
+	window, ok := lt.clients[client]
+	if !ok {
+		window = &latencyWindow{}
+		lt.clients[client] = window
+	}
+	p99 := window.add(total)
+	lt.mu.Unlock()
+	lt.metrics.SetLabel(METRIC_LATENCY_P99+client, float64(p99)/float64(time.Millisecond))
+}
+
+func (lt *LatencyTracer) observeStage(stage string, d time.Duration) {
+	observeLatency(lt.metrics, METRIC_LATENCY_PREFIX+stage, d)
+}
+
+// removeClient removes the per client metric of a closed connection
+func (lt *LatencyTracer) removeClient(client string) {
+	lt.mu.Lock()
+	delete(lt.clients, client)
+	lt.mu.Unlock()
+	lt.metrics.SetLabel(METRIC_LATENCY_P99+client, nil)
+}
+
+func latencyBucket(d time.Duration) string {
+	for _, bound := range LatencyBuckets {
+		if d <= bound {
+			return fmt.Sprintf("le_%s", bound)
+		}
+	}
+	return "le_inf"
+}
+
+// add adds a sample to the window and returns the p99 of the window samples
+func (w *latencyWindow) add(d time.Duration) time.Duration {
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+	} else {
+		w.samples[w.next] = d
+		w.next = (w.next + 1) % latencyWindowSize
+	}
+	sorted := append([]time.Duration{}, w.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(len(sorted)*99-1)/100]
+}
@@ -0,0 +1,60 @@
+package ovsdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyBucket(t *testing.T) {
+	assert.Equal(t, "le_1ms", latencyBucket(500*time.Microsecond))
+	assert.Equal(t, "le_1ms", latencyBucket(time.Millisecond))
+	assert.Equal(t, "le_25ms", latencyBucket(11*time.Millisecond))
+	assert.Equal(t, "le_inf", latencyBucket(2*time.Second))
+}
+
+func TestLatencyWindowP99(t *testing.T) {
+	w := &latencyWindow{}
+	var p99 time.Duration
+	for i := 1; i <= 100; i++ {
+		p99 = w.add(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(t, 99*time.Millisecond, p99)
+	// the window keeps only the recent samples
+	for i := 0; i < latencyWindowSize; i++ {
+		p99 = w.add(time.Millisecond)
+	}
+	assert.Equal(t, latencyWindowSize, len(w.samples))
+	assert.Equal(t, time.Millisecond, p99)
+}
+
+func TestLatencyTracerObserve(t *testing.T) {
+	m := metrics.New()
+	lt := NewLatencyTracer(m)
+	start := time.Now()
+	trace := &notificationTrace{
+		watched:  start,
+		diffed:   start.Add(3 * time.Millisecond),
+		enqueued: start.Add(3 * time.Millisecond),
+		dequeued: start.Add(40 * time.Millisecond),
+		sent:     start.Add(41 * time.Millisecond),
+	}
+	lt.observe("127.0.0.1:5000", trace)
+
+	snap := metrics.Snapshot{Counter: map[string]int64{}, Label: map[string]interface{}{}}
+	m.Snapshot(snap)
+	assert.Equal(t, int64(1), snap.Counter[METRIC_LATENCY_PREFIX+LATENCY_STAGE_DIFF+".le_5ms"])
+	assert.Equal(t, int64(1), snap.Counter[METRIC_LATENCY_PREFIX+LATENCY_STAGE_QUEUE+".le_50ms"])
+	assert.Equal(t, int64(1), snap.Counter[METRIC_LATENCY_PREFIX+LATENCY_STAGE_SEND+".le_1ms"])
+	assert.Equal(t, int64(1), snap.Counter[METRIC_LATENCY_PREFIX+LATENCY_STAGE_TOTAL+".le_50ms"])
+	assert.Equal(t, int64(41000), snap.Counter[METRIC_LATENCY_PREFIX+LATENCY_STAGE_TOTAL+".sum_us"])
+	assert.Equal(t, float64(41), snap.Label[METRIC_LATENCY_P99+"127.0.0.1:5000"])
+
+	lt.removeClient("127.0.0.1:5000")
+	snap.Label = map[string]interface{}{}
+	m.Snapshot(snap)
+	_, ok := snap.Label[METRIC_LATENCY_P99+"127.0.0.1:5000"]
+	assert.False(t, ok)
+}
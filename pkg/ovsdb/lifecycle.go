@@ -0,0 +1,154 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/types/_Server"
+)
+
+// The lifecycle states of a replica, they are reported in the _Server.Replica extension table
+const (
+	INT_REPLICAS = "Replica"
+
+	REPLICA_STARTING = "starting"
+	REPLICA_WARMING  = "warming"
+	REPLICA_SERVING  = "serving"
+	REPLICA_DRAINING = "draining"
+)
+
+// ReplicaTTL is the time-to-live in seconds of the replica row after a failure of its server
+var ReplicaTTL = 10
+
+// LifecycleReporter reports the lifecycle state of the replica in its _Server.Replica row, so tooling and the other
+// replicas can coordinate upgrades and traffic shifting by monitoring the table. The row is attached to a lease of the
+// replica, so it is removed when the replica fails.
+type LifecycleReporter struct {
+	cli *clientv3.Client
+	log logr.Logger
+	key string
+	row _Server.Replica
+
+	mu       sync.Mutex
+	session  *concurrency.Session
+	revision int64
+	state    string
+	closed   bool
+}
+
+// NewLifecycleReporter creates the row of the replica in the starting state, the replica id is its server id
+func NewLifecycleReporter(ctx context.Context, cli *clientv3.Client, replicaID string, log logr.Logger) (*LifecycleReporter, error) {
+	build, err := libovsdb.NewOvsMap(buildColumn(""))
+	if err != nil {
+		return nil, err
+	}
+	delete(build.GoMap, "schema-version")
+	session, err := concurrency.NewSession(cli, concurrency.WithTTL(ReplicaTTL))
+	if err != nil {
+		return nil, err
+	}
+	lr := &LifecycleReporter{
+		cli:     cli,
+		log:     log.WithName("lifecycle"),
+		key:     common.NewDataKey(INT_SERVER, INT_REPLICAS, replicaID).String(),
+		row:     _Server.Replica{Name: replicaID, Build: *build, Uuid: libovsdb.UUID{GoUUID: replicaID}},
+		session: session,
+	}
+	if err := lr.SetState(ctx, REPLICA_STARTING); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return lr, nil
+}
+
+// SetState writes the state of the replica, the write fails if the row was modified or deleted by someone else
+func (lr *LifecycleReporter) SetState(ctx context.Context, state string) error {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if lr.closed {
+		return fmt.Errorf("lifecycle reporter of %s is closed", lr.row.Name)
+	}
+	row := lr.row
+	row.State = state
+	row.Version = libovsdb.UUID{GoUUID: uuid.NewString()}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	defer cancel()
+	res, err := lr.cli.Txn(tctx).If(clientv3.Compare(clientv3.ModRevision(lr.key), "=", lr.revision)).
+		Then(clientv3.OpPut(lr.key, string(data), clientv3.WithLease(lr.session.Lease()))).Commit()
+	if err != nil {
+		return err
+	}
+	if !res.Succeeded {
+		return fmt.Errorf("replica row %s was modified concurrently", lr.key)
+	}
+	lr.revision = res.Header.Revision
+	lr.state = state
+	lr.log.V(3).Info("replica state", "replica", lr.row.Name, "state", state)
+	return nil
+}
+
+// State returns the last reported state of the replica, and an error if the row of the replica was lost with its
+// lease, e.g. the server was disconnected from etcd longer than ReplicaTTL
+func (lr *LifecycleReporter) State() (string, error) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if lr.closed {
+		return lr.state, fmt.Errorf("lifecycle reporter of %s is closed", lr.row.Name)
+	}
+	select {
+	case <-lr.session.Done():
+		return lr.state, fmt.Errorf("the lease of replica %s expired", lr.row.Name)
+	default:
+		return lr.state, nil
+	}
+}
+
+// Close deletes the row of the replica, and releases its lease
+func (lr *LifecycleReporter) Close(ctx context.Context) error {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if lr.closed {
+		return nil
+	}
+	lr.closed = true
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	defer cancel()
+	_, err := lr.cli.Txn(tctx).If(clientv3.Compare(clientv3.ModRevision(lr.key), "=", lr.revision)).
+		Then(clientv3.OpDelete(lr.key)).Commit()
+	if cerr := lr.session.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// GetReplicaStates returns the states of the live replicas by their ids
+func GetReplicaStates(ctx context.Context, cli *clientv3.Client) (map[string]string, error) {
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	defer cancel()
+	resp, err := cli.Get(tctx, common.NewTableKey(INT_SERVER, INT_REPLICAS).String(), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	states := map[string]string{}
+	for _, kv := range resp.Kvs {
+		row := _Server.Replica{}
+		if err := json.Unmarshal(kv.Value, &row); err != nil {
+			return nil, err
+		}
+		states[row.Name] = row.State
+	}
+	return states, nil
+}
@@ -0,0 +1,40 @@
+package ovsdb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func TestLifecycleReporter(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	ctx := context.Background()
+	replicaID := common.GenerateUUID()
+	lr, err := NewLifecycleReporter(ctx, cli, replicaID, klogr.New())
+	assert.Nil(t, err)
+	states, err := GetReplicaStates(ctx, cli)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{replicaID: REPLICA_STARTING}, states)
+
+	for _, state := range []string{REPLICA_WARMING, REPLICA_SERVING, REPLICA_DRAINING} {
+		assert.Nil(t, lr.SetState(ctx, state))
+		states, err = GetReplicaStates(ctx, cli)
+		assert.Nil(t, err)
+		assert.Equal(t, state, states[replicaID])
+	}
+
+	// the row is removed with the replica
+	assert.Nil(t, lr.Close(ctx))
+	states, err = GetReplicaStates(ctx, cli)
+	assert.Nil(t, err)
+	assert.Empty(t, states)
+	assert.NotNil(t, lr.SetState(ctx, REPLICA_SERVING))
+}
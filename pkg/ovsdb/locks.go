@@ -0,0 +1,276 @@
+package ovsdb
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/creachadair/jrpc2/metrics"
+	"github.com/go-logr/logr"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+const (
+	METRIC_LOCKS_SWEEP_RUNS    = "locks.sweeper.runs"
+	METRIC_LOCKS_SWEEP_ERRORS  = "locks.sweeper.errors"
+	METRIC_LOCKS_SWEEP_SCANNED = "locks.sweeper.scanned"
+	METRIC_LOCKS_SWEEP_REMOVED = "locks.sweeper.removed"
+)
+
+// LockLeaseTTL is the time to live of the etcd lease of the client locks. The lease is revoked when the client releases
+// its locks or disconnects, and it expires when the server fails to revoke it, e.g. when the server crashes.
+var LockLeaseTTL = 10 * time.Second
+
+// LockRegistry tracks the locks of the clients connected to the server: the owner and the waiters of every lock. It
+// dispatches the "locked" and "stolen" notifications to the clients, and releases the locks of the disconnected ones.
+type LockRegistry struct {
+	mu    sync.Mutex
+	locks map[string]*lockEntry
+}
+
+type lockEntry struct {
+	owner *Handler
+	// the waiters by their request order
+	waiters []*Handler
+	lockers map[*Handler]Locker
+}
+
+// LockInfo describes a lock of the server clients
+type LockInfo struct {
+	Lock    string   `json:"lock"`
+	Owner   string   `json:"owner,omitempty"`
+	Waiters []string `json:"waiters"`
+}
+
+func NewLockRegistry() *LockRegistry {
+	return &LockRegistry{locks: map[string]*lockEntry{}}
+}
+
+// add registers the lock of a client as a waiter
+func (r *LockRegistry) add(id string, ch *Handler, locker Locker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.locks[id]
+	if !ok {
+		entry = &lockEntry{lockers: map[*Handler]Locker{}}
+		r.locks[id] = entry
+	}
+	if _, ok := entry.lockers[ch]; !ok {
+		entry.lockers[ch] = locker
+		entry.waiters = append(entry.waiters, ch)
+	}
+}
+
+func (entry *lockEntry) removeWaiter(ch *Handler) {
+	for i, waiter := range entry.waiters {
+		if waiter == ch {
+			entry.waiters = append(entry.waiters[:i], entry.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// acquired makes the client the owner of the lock, and notifies it by "locked" if the lock was waited for
+func (r *LockRegistry) acquired(id string, ch *Handler, notify bool) {
+	r.mu.Lock()
+	entry, ok := r.locks[id]
+	registered := ok && entry.lockers[ch] != nil
+	if registered {
+		entry.removeWaiter(ch)
+		entry.owner = ch
+	}
+	r.mu.Unlock()
+	if registered && notify {
+		ch.logger().V(5).Info("lock succeeded", "lockid", id)
+		r.notify(ch, "locked", id)
+	}
+}
+
+// stolen moves the owner of the lock back to the waiters, notifies it by "stolen", and returns false if the client
+// didn't own the lock
+func (r *LockRegistry) stolen(id string, ch *Handler) bool {
+	r.mu.Lock()
+	entry, ok := r.locks[id]
+	owner := ok && entry.owner == ch
+	if owner {
+		entry.owner = nil
+		entry.waiters = append(entry.waiters, ch)
+	}
+	r.mu.Unlock()
+	if owner {
+		ch.logger().V(5).Info("lock stolen", "lockid", id)
+		r.notify(ch, "stolen", id)
+	}
+	return owner
+}
+
+// release removes the client from the owner and the waiters of the lock, and returns its lock
+func (r *LockRegistry) release(id string, ch *Handler) Locker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.locks[id]
+	if !ok {
+		return nil
+	}
+	locker := entry.lockers[ch]
+	delete(entry.lockers, ch)
+	if entry.owner == ch {
+		entry.owner = nil
+	}
+	entry.removeWaiter(ch)
+	if len(entry.lockers) == 0 {
+		delete(r.locks, id)
+	}
+	return locker
+}
+
+// releaseAll removes the client from all its locks, and returns the locks, which should be released
+func (r *LockRegistry) releaseAll(ch *Handler) []Locker {
+	r.mu.Lock()
+	ids := []string{}
+	for id, entry := range r.locks {
+		if _, ok := entry.lockers[ch]; ok {
+			ids = append(ids, id)
+		}
+	}
+	r.mu.Unlock()
+	lockers := make([]Locker, 0, len(ids))
+	for _, id := range ids {
+		if locker := r.release(id, ch); locker != nil {
+			lockers = append(lockers, locker)
+		}
+	}
+	return lockers
+}
+
+// Locks returns the locks of the server clients, ordered by their ids
+func (r *LockRegistry) Locks() []LockInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	infos := make([]LockInfo, 0, len(r.locks))
+	for id, entry := range r.locks {
+		info := LockInfo{Lock: id, Waiters: make([]string, 0, len(entry.waiters))}
+		if entry.owner != nil {
+			info.Owner = entry.owner.GetClientAddress()
+		}
+		for _, waiter := range entry.waiters {
+			info.Waiters = append(info.Waiters, waiter.GetClientAddress())
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Lock < infos[j].Lock
+	})
+	return infos
+}
+
+func (r *LockRegistry) notify(ch *Handler, method string, id string) {
+	if err := ch.jrpcServer.Notify(ch.handlerContext, method, []string{id}); err != nil {
+		ch.logger().Error(err, "lock notification failed", "method", method, "lockid", id)
+	}
+}
+
+// LockSweeper periodically scans the locks table and removes lock entries whose etcd lease does not exist anymore.
+// Such entries can stay behind after an unclean shutdown of a server, and they block other clients from acquiring the
+// lock.
+type LockSweeper struct {
+	log      logr.Logger
+	cli      *clientv3.Client
+	interval time.Duration
+	metrics  *metrics.M
+}
+
+func NewLockSweeper(cli *clientv3.Client, interval time.Duration, m *metrics.M, log logr.Logger) *LockSweeper {
+	return &LockSweeper{
+		log:      log.WithName("lock-sweeper"),
+		cli:      cli,
+		interval: interval,
+		metrics:  m,
+	}
+}
+
+// Start runs the sweeper in the background until the given context is done.
+func (ls *LockSweeper) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(ls.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := ls.Sweep(ctx); err != nil {
+					ls.log.Error(err, "sweep failed")
+				}
+			}
+		}
+	}()
+}
+
+// Sweep performs a single pass over the locks table, and returns the number of removed entries.
+func (ls *LockSweeper) Sweep(ctx context.Context) (int, error) {
+	ls.metrics.Count(METRIC_LOCKS_SWEEP_RUNS, 1)
+	key := common.NewLockTableKey()
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	resp, err := ls.cli.Get(tctx, key.String(), clientv3.WithPrefix())
+	cancel()
+	if err != nil {
+		ls.metrics.Count(METRIC_LOCKS_SWEEP_ERRORS, 1)
+		return 0, err
+	}
+	ls.metrics.Count(METRIC_LOCKS_SWEEP_SCANNED, int64(len(resp.Kvs)))
+	// several lock entries can share the same lease, check every lease only once
+	leaseAlive := map[int64]bool{}
+	removed := 0
+	for _, kv := range resp.Kvs {
+		alive, ok := leaseAlive[kv.Lease]
+		if !ok {
+			alive, err = ls.isLeaseAlive(ctx, kv.Lease)
+			if err != nil {
+				ls.metrics.Count(METRIC_LOCKS_SWEEP_ERRORS, 1)
+				ls.log.Error(err, "lease lookup failed", "key", string(kv.Key), "lease", kv.Lease)
+				continue
+			}
+			leaseAlive[kv.Lease] = alive
+		}
+		if alive {
+			continue
+		}
+		// delete the entry only if nobody has touched it since we read it
+		tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+		txnResp, err := ls.cli.Txn(tctx).
+			If(clientv3.Compare(clientv3.ModRevision(string(kv.Key)), "=", kv.ModRevision)).
+			Then(clientv3.OpDelete(string(kv.Key))).
+			Commit()
+		cancel()
+		if err != nil {
+			ls.metrics.Count(METRIC_LOCKS_SWEEP_ERRORS, 1)
+			ls.log.Error(err, "delete stale lock failed", "key", string(kv.Key))
+			continue
+		}
+		if txnResp.Succeeded {
+			removed++
+			ls.log.Info("removed stale lock", "key", string(kv.Key), "lease", kv.Lease)
+		}
+	}
+	ls.metrics.Count(METRIC_LOCKS_SWEEP_REMOVED, int64(removed))
+	return removed, nil
+}
+
+func (ls *LockSweeper) isLeaseAlive(ctx context.Context, lease int64) (bool, error) {
+	if lease == 0 {
+		// lock entries are always created with a session lease
+		return false, nil
+	}
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	defer cancel()
+	resp, err := ls.cli.TimeToLive(tctx, clientv3.LeaseID(lease))
+	if err != nil {
+		return false, err
+	}
+	// etcd returns TTL == -1 for expired or unknown leases
+	return resp.TTL != -1, nil
+}
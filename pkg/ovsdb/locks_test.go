@@ -0,0 +1,228 @@
+package ovsdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2/metrics"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+func TestLockSweeperRemovesStaleLocks(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	ctx := context.TODO()
+
+	// lock entry without a lease, leftover of an unclean shutdown
+	staleKey := common.NewLockKey("stale").String() + "/1"
+	_, err = cli.Put(ctx, staleKey, "")
+	assert.Nil(t, err)
+
+	// lock entry attached to a live lease
+	lease, err := cli.Grant(ctx, 60)
+	assert.Nil(t, err)
+	liveKey := common.NewLockKey("live").String() + "/2"
+	_, err = cli.Put(ctx, liveKey, "", clientv3.WithLease(lease.ID))
+	assert.Nil(t, err)
+
+	m := metrics.New()
+	sweeper := NewLockSweeper(cli, 0, m, klogr.New())
+	removed, err := sweeper.Sweep(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+
+	resp, err := cli.Get(ctx, staleKey)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(resp.Kvs))
+	resp, err = cli.Get(ctx, liveKey)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(resp.Kvs))
+
+	snap := metrics.Snapshot{Counter: map[string]int64{}}
+	m.Snapshot(snap)
+	assert.Equal(t, int64(2), snap.Counter[METRIC_LOCKS_SWEEP_SCANNED])
+	assert.Equal(t, int64(1), snap.Counter[METRIC_LOCKS_SWEEP_REMOVED])
+
+	_, err = cli.Revoke(ctx, lease.ID)
+	assert.Nil(t, err)
+}
+
+func TestTransactAssertLock(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	ctx := context.TODO()
+	db, err := NewDatabaseEtcd(cli)
+	assert.Nil(t, err)
+	owner, err := db.GetLock(ctx, "lock1")
+	assert.Nil(t, err)
+	defer owner.cancel()
+	other, err := db.GetLock(ctx, "lock1")
+	assert.Nil(t, err)
+	defer other.cancel()
+
+	transact := func(locks map[string]Locker) *libovsdb.TransactResponse {
+		table := "table1"
+		lockID := "lock1"
+		row := map[string]interface{}{"key1": "val1"}
+		txn := NewTransaction(cli, klogr.New(), &libovsdb.Transact{
+			DBName: "simple",
+			Operations: []libovsdb.Operation{
+				{Op: OP_ASSERT, Lock: &lockID},
+				{Op: OP_INSERT, Table: &table, Row: &row},
+			},
+		})
+		txn.AddSchema(testSchemaSimple)
+		txn.locks = locks
+		txn.Commit()
+		return &txn.response
+	}
+	assertNotOwner := func(resp *libovsdb.TransactResponse) {
+		if assert.NotNil(t, resp.Error) {
+			assert.Equal(t, E_NOT_OWNER, *resp.Error)
+		}
+	}
+
+	assert.Nil(t, owner.tryLock())
+	assert.Nil(t, transact(map[string]Locker{"lock1": owner}).Error)
+	// the lock is held by another session
+	assert.Equal(t, concurrency.ErrLocked, other.tryLock())
+	assertNotOwner(transact(map[string]Locker{"lock1": other}))
+	// the lock wasn't requested
+	assertNotOwner(transact(nil))
+	// the lock was released
+	assert.Nil(t, owner.unlock())
+	assertNotOwner(transact(map[string]Locker{"lock1": owner}))
+}
+
+func testLockNotifications(recorder *jrpcServerRecorder, method string) int {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	n := 0
+	for _, m := range recorder.method {
+		if m == method {
+			n++
+		}
+	}
+	return n
+}
+
+func testWaitLockNotification(t *testing.T, recorder *jrpcServerRecorder, method string, count int) {
+	for i := 0; i < 100; i++ {
+		if testLockNotifications(recorder, method) >= count {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Fail(t, "missing notification", "method", method)
+}
+
+func TestHandlerStealLock(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	ctx := context.Background()
+	db, err := NewDatabaseEtcd(cli)
+	assert.Nil(t, err)
+	newHandler := func() (*Handler, *jrpcServerRecorder) {
+		recorder := &jrpcServerRecorder{}
+		ch := NewHandler(ctx, db, cli, klogr.New())
+		ch.SetConnection(recorder, nil)
+		return ch, recorder
+	}
+	owner, ownerRecorder := newHandler()
+	thief, thiefRecorder := newHandler()
+	owns := func(ch *Handler) bool {
+		ch.mu.Lock()
+		myLock := ch.databaseLocks["lock1"]
+		ch.mu.Unlock()
+		_, err := myLock.ownership(ctx)
+		return err == nil
+	}
+
+	resp, err := owner.Lock(ctx, []interface{}{"lock1"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"locked": true}, resp)
+	resp, err = thief.Steal(ctx, []interface{}{"lock1"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"locked": true}, resp)
+	testWaitLockNotification(t, ownerRecorder, "stolen", 1)
+	assert.False(t, owns(owner))
+	assert.True(t, owns(thief))
+	// the lock is kept by a repeated steal
+	resp, err = thief.Steal(ctx, []interface{}{"lock1"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"locked": true}, resp)
+
+	// the previous owner keeps waiting, and gets the lock back when it is released
+	_, err = thief.Unlock(ctx, []interface{}{"lock1"})
+	assert.Nil(t, err)
+	testWaitLockNotification(t, ownerRecorder, "locked", 1)
+	assert.True(t, owns(owner))
+	assert.Equal(t, 0, testLockNotifications(thiefRecorder, "stolen"))
+	assert.Equal(t, 1, testLockNotifications(ownerRecorder, "stolen"))
+	_, err = owner.Unlock(ctx, []interface{}{"lock1"})
+	assert.Nil(t, err)
+}
+
+func TestLockRegistryReleaseOnDisconnect(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	ctx := context.Background()
+	db, err := NewDatabaseEtcd(cli)
+	assert.Nil(t, err)
+	registry := NewLockRegistry()
+	newHandler := func() (*Handler, *jrpcServerRecorder) {
+		recorder := &jrpcServerRecorder{}
+		ch := NewHandler(ctx, db, cli, klogr.New())
+		ch.SetConnection(recorder, nil)
+		ch.SetLockRegistry(registry)
+		return ch, recorder
+	}
+	owner, _ := newHandler()
+	waiter, waiterRecorder := newHandler()
+
+	resp, err := owner.Lock(ctx, []interface{}{"lock1"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"locked": true}, resp)
+	resp, err = waiter.Lock(ctx, []interface{}{"lock1"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"locked": false}, resp)
+	locks := registry.Locks()
+	if assert.Equal(t, 1, len(locks)) {
+		assert.Equal(t, "lock1", locks[0].Lock)
+		assert.Equal(t, 1, len(locks[0].Waiters))
+	}
+
+	// the lock is released by the disconnect, even if it's missing in the map of the handler
+	owner.mu.Lock()
+	owner.databaseLocks = map[string]Locker{}
+	owner.mu.Unlock()
+	assert.Nil(t, owner.Cleanup())
+	testWaitLockNotification(t, waiterRecorder, "locked", 1)
+	locks = registry.Locks()
+	if assert.Equal(t, 1, len(locks)) {
+		assert.Empty(t, locks[0].Waiters)
+	}
+
+	_, err = waiter.Unlock(ctx, []interface{}{"lock1"})
+	assert.Nil(t, err)
+	assert.Empty(t, registry.Locks())
+}
@@ -0,0 +1,292 @@
+package ovsdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// MergeRetries is the maximum number of attempts to merge the commutative mutations of a transaction with the
+// concurrent modifications of the same rows, before the transaction fails with the E_TXN_CONFLICT error.
+var MergeRetries = 5
+
+// ConflictPolicy decides which mutations can be merged with concurrent modifications of the same row. If all the
+// modifications of a row by a transaction are commutative mutations, the row is written only if it was not modified
+// since it was read, otherwise the mutations are applied again on the current row value and the write is retried,
+// instead of failing or overwriting the whole row.
+type ConflictPolicy interface {
+	Commutative(dbName, table, column string, columnSchema *libovsdb.ColumnSchema, mutator string) bool
+}
+
+// conflictPolicy is nil if the merging is disabled
+var conflictPolicy ConflictPolicy
+
+// SetConflictPolicy sets the conflict resolution policy, should be called before the server starts serving.
+func SetConflictPolicy(policy ConflictPolicy) {
+	conflictPolicy = policy
+}
+
+// CommutativeColumns is a ConflictPolicy, which merges set and map insert and delete mutations of the given columns,
+// e.g. Logical_Switch.ports, whose concurrent mutations by parallel CMS workers would conflict otherwise.
+type CommutativeColumns map[string]bool
+
+// ParseCommutativeColumns parses a comma separated list of <db-name>.<table>.<column> columns, e.g.
+// "OVN_Northbound.Logical_Switch.ports,OVN_Northbound.Port_Group.ports".
+func ParseCommutativeColumns(columns string) (CommutativeColumns, error) {
+	parsed := CommutativeColumns{}
+	for _, str := range strings.Split(columns, ",") {
+		str = strings.TrimSpace(str)
+		if str == "" {
+			continue
+		}
+		parts := strings.Split(str, ".")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("wrong commutative column %q", str)
+		}
+		parsed[str] = true
+	}
+	return parsed, nil
+}
+
+func (cc CommutativeColumns) Commutative(dbName, table, column string, columnSchema *libovsdb.ColumnSchema, mutator string) bool {
+	if !cc[dbName+"."+table+"."+column] {
+		return false
+	}
+	if columnSchema.Type != libovsdb.TypeSet && columnSchema.Type != libovsdb.TypeMap {
+		return false
+	}
+	return mutator == MT_INSERT || mutator == MT_DELETE
+}
+
+// rowMerge holds the commutative mutations of a row, which are applied again if the row was concurrently modified
+type rowMerge struct {
+	key         common.Key
+	tableSchema *libovsdb.TableSchema
+	mutations   []*[]interface{}
+}
+
+// isCommutative returns true if all the mutations can be merged with concurrent modifications
+func (txn *Transaction) isCommutative(table string, tableSchema *libovsdb.TableSchema, mutations *[]interface{}) bool {
+	if conflictPolicy == nil || mutations == nil {
+		return false
+	}
+	for _, mt := range *mutations {
+		mutation, ok := mt.([]interface{})
+		if !ok || len(mutation) != 3 {
+			return false
+		}
+		column, ok := mutation[0].(string)
+		if !ok {
+			return false
+		}
+		mutator, ok := mutation[1].(string)
+		if !ok {
+			return false
+		}
+		columnSchema, err := tableSchema.LookupColumn(column)
+		if err != nil {
+			return false
+		}
+		if !conflictPolicy.Commutative(txn.request.DBName, table, column, columnSchema, mutator) {
+			return false
+		}
+	}
+	return true
+}
+
+func (txn *Transaction) addMerge(key common.Key, tableSchema *libovsdb.TableSchema, mutations *[]interface{}) {
+	if txn.merges == nil {
+		txn.merges = map[string]*rowMerge{}
+	}
+	merge, ok := txn.merges[key.String()]
+	if !ok {
+		merge = &rowMerge{key: key, tableSchema: tableSchema}
+		txn.merges[key.String()] = merge
+	}
+	merge.mutations = append(merge.mutations, mutations)
+}
+
+// countRowWrite counts the modifications of the row by the transaction
+func (txn *Transaction) countRowWrite(key string) {
+	if txn.rowWrites == nil {
+		txn.rowWrites = map[string]int{}
+	}
+	txn.rowWrites[key]++
+}
+
+// setReadRevisions keeps the mod revisions of the rows read by the transaction
+func (txn *Transaction) setReadRevisions(res *clientv3.TxnResponse) {
+	if txn.readRevisions == nil {
+		txn.readRevisions = map[string]int64{}
+	}
+	for _, r := range res.Responses {
+		rangeResp := r.GetResponseRange()
+		if rangeResp == nil {
+			continue
+		}
+		for _, kv := range rangeResp.Kvs {
+			txn.readRevisions[string(kv.Key)] = kv.ModRevision
+		}
+	}
+}
+
+// addMergeCompares guards the rows, which are modified only by commutative mutations, by their read mod revisions
+func (txn *Transaction) addMergeCompares() {
+	for key, merge := range txn.merges {
+		revision, ok := txn.readRevisions[key]
+		if !ok || len(merge.mutations) != txn.rowWrites[key] {
+			delete(txn.merges, key)
+			continue
+		}
+		txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.ModRevision(key), "=", revision))
+	}
+}
+
+// addWriteCompares guards the rows modified or deleted by the transaction by the mod revisions they were read at, so a
+// concurrent modification of the rows fails the etcd transaction, instead of being silently overwritten. The rows
+// modified only by commutative mutations are already guarded by addMergeCompares.
+func (txn *Transaction) addWriteCompares() {
+	keys := make([]string, 0, len(txn.rowWrites))
+	for key := range txn.rowWrites {
+		if _, ok := txn.merges[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if revision, ok := txn.readRevisions[key]; ok {
+			txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.ModRevision(key), "=", revision))
+		}
+	}
+}
+
+// readRow records a row matched by an operation of the transaction, see addReadCompares
+func (txn *Transaction) readRow(key common.Key) {
+	if txn.rowReads == nil {
+		txn.rowReads = map[string]bool{}
+	}
+	txn.rowReads[key.String()] = true
+}
+
+// readNone records a read of an operation, which matched no row, the prefix is guarded against the rows created since
+// the read, see addReadCompares
+func (txn *Transaction) readNone(prefix string) {
+	if txn.prefixReads == nil {
+		txn.prefixReads = map[string]bool{}
+	}
+	txn.prefixReads[prefix] = true
+}
+
+// wherePrefix returns the prefix read by the operation, the key of the row if the operation selects it by its uuid
+func (txn *Transaction) wherePrefix(tableSchema *libovsdb.TableSchema, ovsOp *libovsdb.Operation) string {
+	uuid, err := txn.doesWhereContainCondTypeUUID(tableSchema, txn.mapUUID, ovsOp.Where)
+	if err != nil {
+		uuid = ""
+	}
+	return common.NewDataKey(txn.request.DBName, *ovsOp.Table, uuid).String()
+}
+
+// addReadCompares guards the reads of a write transaction: the rows matched by its operations, which it doesn't write,
+// by the mod revisions they were read at, and the reads, which matched no row, by the absence of the modifications of
+// their prefixes since the read revision. So the transaction fails if a concurrent transaction changes what it read.
+// The read-only transactions read a single revision, their reads are not guarded.
+func (txn *Transaction) addReadCompares() {
+	if txn.etcd.isReadOnly() {
+		return
+	}
+	keys := make([]string, 0, len(txn.rowReads))
+	for key := range txn.rowReads {
+		if _, ok := txn.rowWrites[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if revision, ok := txn.readRevisions[key]; ok {
+			txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.ModRevision(key), "=", revision))
+		}
+	}
+	prefixes := make([]string, 0, len(txn.prefixReads))
+	for prefix := range txn.prefixReads {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		txn.etcd.If = append(txn.etcd.If,
+			clientv3.Compare(clientv3.ModRevision(prefix), "<", txn.readRevision+1).WithPrefix())
+	}
+}
+
+// remerge applies the commutative mutations again on the current values of the conflicting rows, and updates the etcd
+// transaction. Returns false if one of the conflicts cannot be merged, e.g. the row was deleted.
+func (txn *Transaction) remerge() bool {
+	conflicts, err := txn.etcd.Conflicts()
+	if err != nil || len(conflicts) == 0 {
+		return false
+	}
+	for _, conflict := range conflicts {
+		merge, ok := txn.merges[conflict.Key]
+		if !ok || conflict.ActualRevision == 0 {
+			return false
+		}
+		res, err := txn.etcd.Cli.Get(txn.etcd.Ctx, conflict.Key)
+		if err != nil || len(res.Kvs) == 0 {
+			return false
+		}
+		kv := res.Kvs[0]
+		row, err := unmarshalData(kv.Value)
+		if err != nil {
+			return false
+		}
+		if err := txn.schemas.Unmarshal(merge.key.DBName, merge.key.TableName, &row); err != nil {
+			return false
+		}
+		prevVal, err := makeValue(&row)
+		if err != nil {
+			return false
+		}
+		for _, mutations := range merge.mutations {
+			mutated, err := txn.RowMutate(merge.tableSchema, txn.mapUUID, &row, mutations)
+			if err != nil {
+				txn.log.V(5).Info("merge failed", "key", conflict.Key, "err", err)
+				return false
+			}
+			row = *mutated
+		}
+		setRowVersion(&row)
+		val, err := makeValue(&row)
+		if err != nil {
+			return false
+		}
+		txn.replaceRowWrite(conflict.Key, val, prevVal, kv.ModRevision)
+		txn.log.V(5).Info("merged concurrent modification", "key", conflict.Key,
+			"expected-revision", conflict.ExpectedRevision, "actual-revision", kv.ModRevision)
+	}
+	return true
+}
+
+func (txn *Transaction) replaceRowWrite(key, val, prevVal string, revision int64) {
+	for i, op := range txn.etcd.Then {
+		if op.IsPut() && etcdOpKey(op) == key {
+			txn.etcd.Then[i] = clientv3.OpPut(key, val)
+		}
+	}
+	for _, ev := range txn.etcd.Events {
+		if ev != nil && etcdEventKey(ev) == key {
+			ev.Kv.Value = []byte(val)
+			if ev.PrevKv != nil {
+				ev.PrevKv.Value = []byte(prevVal)
+			}
+		}
+	}
+	for i, cmp := range txn.etcd.If {
+		if string(cmp.Key) == key {
+			txn.etcd.If[i] = clientv3.Compare(clientv3.ModRevision(key), "=", revision)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package ovsdb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+func TestParseCommutativeColumns(t *testing.T) {
+	cc, err := ParseCommutativeColumns("OVN_Northbound.Logical_Switch.ports, OVN_Northbound.Logical_Switch.external_ids")
+	assert.Nil(t, err)
+	setSchema := &libovsdb.ColumnSchema{Type: libovsdb.TypeSet}
+	mapSchema := &libovsdb.ColumnSchema{Type: libovsdb.TypeMap}
+	assert.True(t, cc.Commutative("OVN_Northbound", "Logical_Switch", "ports", setSchema, MT_INSERT))
+	assert.True(t, cc.Commutative("OVN_Northbound", "Logical_Switch", "ports", setSchema, MT_DELETE))
+	assert.True(t, cc.Commutative("OVN_Northbound", "Logical_Switch", "external_ids", mapSchema, MT_INSERT))
+	assert.False(t, cc.Commutative("OVN_Northbound", "Logical_Switch", "ports", setSchema, MT_SUM))
+	assert.False(t, cc.Commutative("OVN_Northbound", "Logical_Switch", "name", &libovsdb.ColumnSchema{Type: libovsdb.TypeString}, MT_INSERT))
+	assert.False(t, cc.Commutative("OVN_Northbound", "Logical_Router", "ports", setSchema, MT_INSERT))
+
+	for _, wrong := range []string{"Logical_Switch.ports", "OVN_Northbound..ports", "a.b.c.d"} {
+		_, err = ParseCommutativeColumns(wrong)
+		assert.NotNil(t, err, wrong)
+	}
+}
+
+// testMergeTransaction runs the transaction phases, and calls concurrent between the read and the write phases
+func testMergeTransaction(t *testing.T, uuid string, concurrent func()) error {
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	table := "table1"
+	mutations := []interface{}{[]interface{}{"string", MT_INSERT, "b"}}
+	where := []interface{}{[]interface{}{COL_UUID, "==", []interface{}{"uuid", uuid}}}
+	req := &libovsdb.Transact{
+		DBName:     "set",
+		Operations: []libovsdb.Operation{{Op: OP_MUTATE, Table: &table, Where: &where, Mutations: &mutations}},
+	}
+	txn := NewTransaction(cli, klogr.New(), req)
+	txn.AddSchema(testSchemaSet)
+	op := &txn.request.Operations[0]
+
+	txn.etcd.Clear()
+	assert.Nil(t, preMutate(txn, op, &txn.response.Result[0]))
+	_, err = txn.etcdTranaction()
+	assert.Nil(t, err)
+
+	txn.etcd.Clear()
+	assert.Nil(t, doMutate(txn, op, &txn.response.Result[0]))
+	txn.etcdRemoveDup()
+	txn.addMergeCompares()
+	assert.Equal(t, 1, len(txn.etcd.If))
+	concurrent()
+	_, err = txn.etcdTranaction()
+	return err
+}
+
+func TestTransactMergeCommutativeMutations(t *testing.T) {
+	SetConflictPolicy(CommutativeColumns{"set.table1.string": true})
+	defer SetConflictPolicy(nil)
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	key := common.GenerateDataKey("set", "table1")
+	put := func(values ...interface{}) {
+		set := libovsdb.OvsSet{GoSet: values}
+		row := map[string]interface{}{"string": set}
+		setRowUUID(&row, key.UUID)
+		setRowVersion(&row)
+		val, err := makeValue(&row)
+		assert.Nil(t, err)
+		_, err = cli.Put(context.TODO(), key.String(), val)
+		assert.Nil(t, err)
+	}
+	put("a")
+
+	// the concurrent insert is merged with the transaction mutation
+	err = testMergeTransaction(t, key.UUID, func() {
+		put("a", "c")
+	})
+	assert.Nil(t, err)
+	res, err := cli.Get(context.TODO(), key.String())
+	assert.Nil(t, err)
+	row, err := unmarshalData(res.Kvs[0].Value)
+	assert.Nil(t, err)
+	err = testSchemaSet.Unmarshal("table1", &row)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []interface{}{"a", "b", "c"}, row["string"].(libovsdb.OvsSet).GoSet)
+
+	// a deleted row cannot be merged
+	err = testMergeTransaction(t, key.UUID, func() {
+		_, err := cli.Delete(context.TODO(), key.String())
+		assert.Nil(t, err)
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, E_TXN_CONFLICT, err.Error())
+}
@@ -0,0 +1,46 @@
+package ovsdb
+
+import (
+	"time"
+
+	"github.com/creachadair/jrpc2/metrics"
+)
+
+// The server metrics, the names ending with "." are prefixes of per database, operation or method metrics. The
+// latency metrics are histograms, see observeLatency.
+const (
+	// gauge of the monitors of all the clients
+	METRIC_MONITORS_ACTIVE = "monitors.active"
+	// counters of the notifications written to the clients, per notification method
+	METRIC_NOTIFICATIONS_SENT_PREFIX = "notifications.sent."
+	// counters of the etcd watch restarts, per database
+	METRIC_WATCH_RESTARTS_PREFIX = "monitor.watch.restarts."
+	// the transact requests latency, per database
+	METRIC_TRANSACT_LATENCY_PREFIX = "transact.latency."
+	// counters of the executions of the transactions, of their executions again after a conflict, and of the
+	// transactions failed by conflicts after all their executions, per database. The retry rate is the retries by the
+	// executions.
+	METRIC_TRANSACT_EXECUTIONS_PREFIX = "transact.executions."
+	METRIC_TRANSACT_RETRIES_PREFIX    = "transact.retries."
+	METRIC_TRANSACT_CONFLICTS_PREFIX  = "transact.conflicts."
+	// counters of the transactions, whose rows were read from the row cache or from etcd, per database
+	METRIC_ROW_CACHE_HITS_PREFIX   = "transact.rowcache.hits."
+	METRIC_ROW_CACHE_MISSES_PREFIX = "transact.rowcache.misses."
+	// the etcd requests latency, per request kind
+	METRIC_ETCD_LATENCY_PREFIX = "etcd.latency."
+
+	ETCD_REQUEST_GET = "get"
+	ETCD_REQUEST_TXN = "txn"
+)
+
+// serverMetrics collects the metrics of the handlers and the monitors, nil discards them
+var serverMetrics *metrics.M
+
+// observeLatency adds the duration to the histogram of the prefix, its counters are "<prefix>.le_<bucket>",
+// "<prefix>.count" and "<prefix>.sum_us", the buckets are the LatencyBuckets.
+func observeLatency(m *metrics.M, prefix string, d time.Duration) {
+	prefix += "."
+	m.Count(prefix+"count", 1)
+	m.Count(prefix+"sum_us", d.Microseconds())
+	m.Count(prefix+latencyBucket(d), 1)
+}
@@ -0,0 +1,420 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+// STORAGE_FORMAT_BASE is the storage format version of the databases, which were created before the storage format
+// markers, the rows are stored as JSON values under prefix/db/table/uuid keys.
+const STORAGE_FORMAT_BASE = 1
+
+// MigrationBatchSize is the number of the keys, which are migrated by a single etcd transaction. A transaction has up
+// to 3 operations per key, it should not exceed the --max-txn-ops of etcd.
+var MigrationBatchSize = 32
+
+// the interval of the migration progress reports
+const migrationProgressInterval = 5 * time.Second
+
+// StorageMigration upgrades the stored keys of a database from the previous storage format version to Version.
+type StorageMigration struct {
+	Version     int
+	Description string
+	// Migrate returns the new key and value of a stored key, the key and the value are returned unchanged if the key
+	// is not migrated, and a nil value deletes the key. It is called for every key of the database.
+	Migrate func(key string, value []byte) (string, []byte, error)
+}
+
+// storageMigrations are ordered by their versions, starting from STORAGE_FORMAT_BASE+1
+var storageMigrations []StorageMigration
+
+// RegisterStorageMigration adds the migration to the next storage format version, should be called from init
+// functions.
+func RegisterStorageMigration(migration StorageMigration) {
+	if migration.Version != CurrentStorageFormat()+1 {
+		panic(fmt.Sprintf("storage migration to version %d, the current version is %d", migration.Version, CurrentStorageFormat()))
+	}
+	storageMigrations = append(storageMigrations, migration)
+}
+
+// CurrentStorageFormat returns the storage format version, which is written by this server
+func CurrentStorageFormat() int {
+	if len(storageMigrations) == 0 {
+		return STORAGE_FORMAT_BASE
+	}
+	return storageMigrations[len(storageMigrations)-1].Version
+}
+
+// StorageFormat is the storage format marker of a database
+type StorageFormat struct {
+	Version int `json:"version"`
+	// the version of the running migration, 0 if there is no running migration
+	Target int `json:"target,omitempty"`
+}
+
+// migrationUndo is the undo record of a migrated batch, it's written by the transaction of the batch
+type migrationUndo struct {
+	Keys []migrationUndoKey `json:"keys"`
+}
+
+type migrationUndoKey struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// the key written by the migration, empty if the key was deleted
+	Migrated string `json:"migrated,omitempty"`
+}
+
+// StorageMigrator upgrades the storage format of the databases on startup. The migration of a database runs under an
+// etcd lock, so only one server migrates it, and it holds the journal of the database, so the transactions of the
+// other replicas wait until it completes. Every migrated batch writes an undo record, and if the migration fails or is
+// interrupted, the migrated keys are restored from the undo records, except the keys which were modified after the
+// migration. The database is fenced until an interrupted migration is rolled back by the next run.
+type StorageMigrator struct {
+	log logr.Logger
+	cli *clientv3.Client
+	// called after every migrated batch, can be nil
+	Progress func(dbName string, version int, migrated int, total int64)
+}
+
+func NewStorageMigrator(cli *clientv3.Client, log logr.Logger) *StorageMigrator {
+	return &StorageMigrator{
+		log: log.WithName("storage-migrator"),
+		cli: cli,
+	}
+}
+
+// Check returns the storage format of the database, and an error if the database cannot be served without a migration
+func (sm *StorageMigrator) Check(ctx context.Context, dbName string) (*StorageFormat, error) {
+	format, _, err := sm.readFormat(ctx, dbName)
+	if err != nil {
+		return nil, err
+	}
+	if format.Target != 0 {
+		return format, fmt.Errorf("database %s: the migration to storage format %d was interrupted", dbName, format.Target)
+	}
+	if format.Version != CurrentStorageFormat() {
+		return format, fmt.Errorf("database %s: storage format %d, the server supports %d", dbName, format.Version, CurrentStorageFormat())
+	}
+	return format, nil
+}
+
+// Run migrates the database to the current storage format. Databases of a newer storage format are refused, so an
+// older server doesn't corrupt them.
+func (sm *StorageMigrator) Run(ctx context.Context, dbName string) error {
+	log := sm.log.WithValues("dbName", dbName)
+	session, err := concurrency.NewSession(sm.cli, concurrency.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	mutex := concurrency.NewMutex(session, common.NewLockKey(common.STORAGE_FORMAT+common.KEY_DELIMETER+dbName).String())
+	if err := mutex.Lock(ctx); err != nil {
+		return err
+	}
+	defer mutex.Unlock(context.Background())
+
+	format, exists, err := sm.readFormat(ctx, dbName)
+	if err != nil {
+		return err
+	}
+	if format.Version > CurrentStorageFormat() || format.Target > CurrentStorageFormat() {
+		return fmt.Errorf("database %s: storage format %d is newer than the supported storage format %d", dbName, format.Version, CurrentStorageFormat())
+	}
+	if format.Target != 0 {
+		log.Info("rollback of an interrupted migration", "version", format.Version, "target", format.Target)
+		fence, err := sm.fence(ctx, dbName, session.Lease(), format.Target)
+		if err != nil {
+			return err
+		}
+		if err := sm.rollback(ctx, log, dbName, fence); err != nil {
+			return fmt.Errorf("database %s: rollback of the migration to storage format %d failed: %v", dbName, format.Target, err)
+		}
+		format = &StorageFormat{Version: format.Version}
+		if err := sm.complete(ctx, dbName, format, fence); err != nil {
+			return err
+		}
+	}
+	if !exists {
+		empty, err := sm.isEmpty(ctx, dbName)
+		if err != nil {
+			return err
+		}
+		if empty {
+			// a new database is created in the current format
+			format.Version = CurrentStorageFormat()
+		}
+		if err := sm.writeFormat(ctx, dbName, format); err != nil {
+			return err
+		}
+	}
+	for _, migration := range storageMigrations {
+		if migration.Version <= format.Version {
+			continue
+		}
+		if err := sm.migrate(ctx, log, dbName, session.Lease(), format.Version, migration); err != nil {
+			return err
+		}
+		format = &StorageFormat{Version: migration.Version}
+	}
+	log.V(5).Info("storage format is up to date", "version", format.Version)
+	return nil
+}
+
+func (sm *StorageMigrator) migrate(ctx context.Context, log logr.Logger, dbName string, owner clientv3.LeaseID, from int, migration StorageMigration) error {
+	log = log.WithValues("version", migration.Version, "description", migration.Description)
+	fence, err := sm.fence(ctx, dbName, owner, migration.Version)
+	if err != nil {
+		return err
+	}
+	dbKey := common.NewDBPrefixKey(dbName).String()
+	resp, err := sm.cli.Get(ctx, dbKey, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return err
+	}
+	revision := resp.Header.Revision
+	// the journal is not migrated
+	total := resp.Count - 1
+	if err := sm.writeFormat(ctx, dbName, &StorageFormat{Version: from, Target: migration.Version}); err != nil {
+		return err
+	}
+	log.Info("storage migration started", "keys", total, "revision", revision)
+	migrated, err := sm.migrateKeys(ctx, log, dbName, migration, revision, fence, total)
+	if err != nil {
+		log.Error(err, "storage migration failed, rollback", "migrated", migrated)
+		if rerr := sm.rollback(ctx, log, dbName, fence); rerr != nil {
+			// the marker keeps the target, so the rollback is retried by the next run
+			return fmt.Errorf("database %s: migration to storage format %d failed: %v, rollback failed: %v", dbName, migration.Version, err, rerr)
+		}
+		if werr := sm.complete(ctx, dbName, &StorageFormat{Version: from}, fence); werr != nil {
+			return werr
+		}
+		return fmt.Errorf("database %s: migration to storage format %d failed: %v", dbName, migration.Version, err)
+	}
+	if err := sm.complete(ctx, dbName, &StorageFormat{Version: migration.Version}, fence); err != nil {
+		return err
+	}
+	log.Info("storage migration completed", "migrated", migrated)
+	return nil
+}
+
+// fence acquires the journal of the database for the migration to the target version, and returns its revision. A
+// chained commit in progress is waited for, and the journal of an interrupted migration is taken over, as the
+// migrations are serialized by the migration lock.
+func (sm *StorageMigrator) fence(ctx context.Context, dbName string, owner clientv3.LeaseID, target int) (int64, error) {
+	key := common.NewJournalKey(dbName).String()
+	buf, err := json.Marshal(journalIntent{Owner: int64(owner), Migration: target})
+	if err != nil {
+		return 0, err
+	}
+	for {
+		res, err := sm.cli.Txn(ctx).If(journalAbsent(key)).Then(clientv3.OpPut(key, string(buf))).Else(clientv3.OpGet(key)).Commit()
+		if err != nil {
+			return 0, err
+		}
+		if res.Succeeded {
+			return res.Header.Revision, nil
+		}
+		journal := journalOf(res)
+		if journal == nil {
+			continue
+		}
+		intent := journalIntent{}
+		if err := json.Unmarshal(journal.Value, &intent); err != nil {
+			return 0, err
+		}
+		if intent.Migration == 0 {
+			if err := waitJournal(ctx, sm.cli, journal); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		res, err = sm.cli.Txn(ctx).If(journalOwned(key, journal.ModRevision)).Then(clientv3.OpPut(key, string(buf))).Commit()
+		if err != nil {
+			return 0, err
+		}
+		if res.Succeeded {
+			return res.Header.Revision, nil
+		}
+	}
+}
+
+// complete writes the storage format marker, deletes the undo records and releases the journal of the database
+func (sm *StorageMigrator) complete(ctx context.Context, dbName string, format *StorageFormat, fence int64) error {
+	buf, err := json.Marshal(format)
+	if err != nil {
+		return err
+	}
+	journal := common.NewJournalKey(dbName).String()
+	res, err := sm.cli.Txn(ctx).If(journalOwned(journal, fence)).Then(
+		clientv3.OpPut(common.NewStorageFormatKey(dbName).String(), string(buf)),
+		clientv3.OpDelete(common.NewStorageUndoPrefix(dbName), clientv3.WithPrefix()),
+		clientv3.OpDelete(journal)).Commit()
+	if err != nil {
+		return err
+	}
+	if !res.Succeeded {
+		return fmt.Errorf("database %s: the journal of the migration was lost", dbName)
+	}
+	return nil
+}
+
+// migrateKeys migrates the database keys of the given revision, and returns the number of the changed keys
+func (sm *StorageMigrator) migrateKeys(ctx context.Context, log logr.Logger, dbName string, migration StorageMigration, revision int64, fence int64, total int64) (int, error) {
+	dbKey := common.NewDBPrefixKey(dbName).String()
+	journal := common.NewJournalKey(dbName).String()
+	end := clientv3.GetPrefixRangeEnd(dbKey)
+	from := dbKey
+	scanned, migrated, batch := 0, 0, 0
+	lastReport := time.Now()
+	for {
+		resp, err := sm.cli.Get(ctx, from, clientv3.WithRange(end), clientv3.WithRev(revision),
+			clientv3.WithLimit(int64(MigrationBatchSize)), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+		if err != nil {
+			return migrated, err
+		}
+		cmps := []clientv3.Cmp{journalOwned(journal, fence)}
+		ops := []clientv3.Op{}
+		undo := migrationUndo{}
+		for _, kv := range resp.Kvs {
+			if string(kv.Key) == journal {
+				scanned--
+				continue
+			}
+			key, value, err := migration.Migrate(string(kv.Key), kv.Value)
+			if err != nil {
+				return migrated, fmt.Errorf("key %s: %v", string(kv.Key), err)
+			}
+			if key == string(kv.Key) && string(value) == string(kv.Value) {
+				continue
+			}
+			// the migration fails if the key was modified concurrently
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(string(kv.Key)), "=", kv.ModRevision))
+			if key != string(kv.Key) {
+				ops = append(ops, clientv3.OpDelete(string(kv.Key)))
+			}
+			undoKey := migrationUndoKey{Key: string(kv.Key), Value: string(kv.Value)}
+			if value != nil {
+				ops = append(ops, clientv3.OpPut(key, string(value)))
+				undoKey.Migrated = key
+			}
+			undo.Keys = append(undo.Keys, undoKey)
+			migrated++
+		}
+		if len(ops) > 0 {
+			buf, err := json.Marshal(undo)
+			if err != nil {
+				return migrated, err
+			}
+			batch++
+			ops = append(ops, clientv3.OpPut(common.NewStorageUndoKey(dbName, batch).String(), string(buf)))
+			res, err := sm.cli.Txn(ctx).If(cmps...).Then(ops...).Commit()
+			if err != nil {
+				return migrated, err
+			}
+			if !res.Succeeded {
+				return migrated, fmt.Errorf("the database was modified during the migration")
+			}
+		}
+		scanned += len(resp.Kvs)
+		if sm.Progress != nil {
+			sm.Progress(dbName, migration.Version, scanned, total)
+		}
+		if time.Since(lastReport) > migrationProgressInterval {
+			log.Info("storage migration progress", "scanned", scanned, "total", total, "migrated", migrated)
+			lastReport = time.Now()
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			return migrated, nil
+		}
+		from = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// rollback restores the keys of the migrated batches from their undo records, the last batch first. A key is restored
+// only if it wasn't modified after its batch, the keys modified since are kept as they are.
+func (sm *StorageMigrator) rollback(ctx context.Context, log logr.Logger, dbName string, fence int64) error {
+	journal := common.NewJournalKey(dbName).String()
+	resp, err := sm.cli.Get(ctx, common.NewStorageUndoPrefix(dbName), clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByModRevision, clientv3.SortDescend))
+	if err != nil {
+		return err
+	}
+	for _, kv := range resp.Kvs {
+		undo := migrationUndo{}
+		if err := json.Unmarshal(kv.Value, &undo); err != nil {
+			return fmt.Errorf("undo record %s: %v", string(kv.Key), err)
+		}
+		// every key is restored by its own nested transaction, so a modified key doesn't fail the others
+		ops := make([]clientv3.Op, 0, len(undo.Keys)+1)
+		for _, key := range undo.Keys {
+			cmps := []clientv3.Cmp{}
+			restore := []clientv3.Op{}
+			if key.Migrated != "" {
+				cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key.Migrated), "=", kv.ModRevision))
+			}
+			if key.Migrated != key.Key {
+				cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key.Key), "=", 0))
+				if key.Migrated != "" {
+					restore = append(restore, clientv3.OpDelete(key.Migrated))
+				}
+			}
+			restore = append(restore, clientv3.OpPut(key.Key, key.Value))
+			ops = append(ops, clientv3.OpTxn(cmps, restore, nil))
+		}
+		ops = append(ops, clientv3.OpDelete(string(kv.Key)))
+		res, err := sm.cli.Txn(ctx).If(journalOwned(journal, fence)).Then(ops...).Commit()
+		if err != nil {
+			return err
+		}
+		if !res.Succeeded {
+			return fmt.Errorf("database %s: the journal of the migration was lost", dbName)
+		}
+		for i, key := range undo.Keys {
+			if !res.Responses[i].GetResponseTxn().Succeeded {
+				log.Info("the migrated key was modified, it's not restored", "key", key.Key, "migrated", key.Migrated)
+			}
+		}
+	}
+	return nil
+}
+
+// readFormat returns the storage format marker of the database, STORAGE_FORMAT_BASE and false if there is no marker
+func (sm *StorageMigrator) readFormat(ctx context.Context, dbName string) (*StorageFormat, bool, error) {
+	resp, err := sm.cli.Get(ctx, common.NewStorageFormatKey(dbName).String())
+	if err != nil {
+		return nil, false, err
+	}
+	if len(resp.Kvs) == 0 {
+		return &StorageFormat{Version: STORAGE_FORMAT_BASE}, false, nil
+	}
+	format := &StorageFormat{}
+	if err := json.Unmarshal(resp.Kvs[0].Value, format); err != nil {
+		return nil, false, fmt.Errorf("database %s: wrong storage format marker: %v", dbName, err)
+	}
+	return format, true, nil
+}
+
+func (sm *StorageMigrator) writeFormat(ctx context.Context, dbName string, format *StorageFormat) error {
+	buf, err := json.Marshal(format)
+	if err != nil {
+		return err
+	}
+	_, err = sm.cli.Put(ctx, common.NewStorageFormatKey(dbName).String(), string(buf))
+	return err
+}
+
+func (sm *StorageMigrator) isEmpty(ctx context.Context, dbName string) (bool, error) {
+	resp, err := sm.cli.Get(ctx, common.NewDBPrefixKey(dbName).String(), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return false, err
+	}
+	return resp.Count == 0, nil
+}
@@ -0,0 +1,206 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func testMigrationState(t *testing.T, cli *clientv3.Client, dbName string) map[string]string {
+	resp, err := cli.Get(context.Background(), common.NewDBPrefixKey(dbName).String(), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	state := map[string]string{}
+	for _, kv := range resp.Kvs {
+		state[strings.TrimPrefix(string(kv.Key), common.NewDBPrefixKey(dbName).String())] = string(kv.Value)
+	}
+	return state
+}
+
+func testStorageFormat(t *testing.T, cli *clientv3.Client, dbName string) StorageFormat {
+	resp, err := cli.Get(context.Background(), common.NewStorageFormatKey(dbName).String())
+	assert.Nil(t, err)
+	format := StorageFormat{}
+	if assert.Equal(t, 1, len(resp.Kvs)) {
+		assert.Nil(t, json.Unmarshal(resp.Kvs[0].Value, &format))
+	}
+	return format
+}
+
+// testUpperMigration upper cases the values, and moves the keys of table T2 to table T3
+var testUpperMigration = StorageMigration{
+	Version:     STORAGE_FORMAT_BASE + 1,
+	Description: "upper case",
+	Migrate: func(key string, value []byte) (string, []byte, error) {
+		if string(value) == "fail" {
+			return "", nil, fmt.Errorf("cannot migrate")
+		}
+		return strings.Replace(key, "/T2/", "/T3/", 1), []byte(strings.ToUpper(string(value))), nil
+	},
+}
+
+func TestStorageMigration(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	ctx := context.Background()
+	migrator := NewStorageMigrator(cli, klogr.New())
+
+	// a new database is created in the current format
+	assert.Nil(t, migrator.Run(ctx, "empty"))
+	assert.Equal(t, StorageFormat{Version: STORAGE_FORMAT_BASE}, testStorageFormat(t, cli, "empty"))
+
+	for key, value := range map[string]string{"T1/1": "a", "T1/2": "b", "T2/3": "c"} {
+		_, err = cli.Put(ctx, common.NewDBPrefixKey(DB_NAME).String()+key, value)
+		assert.Nil(t, err)
+	}
+	// a database without a marker has the base format
+	assert.Nil(t, migrator.Run(ctx, DB_NAME))
+	assert.Equal(t, StorageFormat{Version: STORAGE_FORMAT_BASE}, testStorageFormat(t, cli, DB_NAME))
+	_, err = migrator.Check(ctx, DB_NAME)
+	assert.Nil(t, err)
+
+	storageMigrations = []StorageMigration{testUpperMigration}
+	defer func() { storageMigrations = nil }()
+	defer func(size int) { MigrationBatchSize = size }(MigrationBatchSize)
+	MigrationBatchSize = 1
+	_, err = migrator.Check(ctx, DB_NAME)
+	assert.NotNil(t, err)
+
+	progress := 0
+	migrator.Progress = func(dbName string, version int, migrated int, total int64) {
+		progress = migrated
+		assert.Equal(t, int64(3), total)
+	}
+	assert.Nil(t, migrator.Run(ctx, DB_NAME))
+	assert.Equal(t, 3, progress)
+	assert.Equal(t, StorageFormat{Version: STORAGE_FORMAT_BASE + 1}, testStorageFormat(t, cli, DB_NAME))
+	assert.Equal(t, map[string]string{"T1/1": "A", "T1/2": "B", "T3/3": "C"}, testMigrationState(t, cli, DB_NAME))
+	// the migration runs once
+	assert.Nil(t, migrator.Run(ctx, DB_NAME))
+	assert.Equal(t, map[string]string{"T1/1": "A", "T1/2": "B", "T3/3": "C"}, testMigrationState(t, cli, DB_NAME))
+
+	// an older server refuses the newer format
+	storageMigrations = nil
+	assert.NotNil(t, migrator.Run(ctx, DB_NAME))
+	_, err = migrator.Check(ctx, DB_NAME)
+	assert.NotNil(t, err)
+}
+
+func TestStorageMigrationRollback(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	ctx := context.Background()
+	migrator := NewStorageMigrator(cli, klogr.New())
+
+	expected := map[string]string{"T1/1": "a", "T2/2": "b", "T2/3": "fail"}
+	for key, value := range expected {
+		_, err = cli.Put(ctx, common.NewDBPrefixKey(DB_NAME).String()+key, value)
+		assert.Nil(t, err)
+	}
+	storageMigrations = []StorageMigration{testUpperMigration}
+	defer func() { storageMigrations = nil }()
+	defer func(size int) { MigrationBatchSize = size }(MigrationBatchSize)
+	MigrationBatchSize = 1
+
+	// the failed migration is rolled back after some of the keys were migrated
+	assert.NotNil(t, migrator.Run(ctx, DB_NAME))
+	assert.Equal(t, StorageFormat{Version: STORAGE_FORMAT_BASE}, testStorageFormat(t, cli, DB_NAME))
+	assert.Equal(t, expected, testMigrationState(t, cli, DB_NAME))
+
+	testMigrationNoUndo(t, cli)
+
+	// an interrupted migration is rolled back by the next run, the keys modified after the migration are kept
+	dbKey := common.NewDBPrefixKey(DB_NAME).String()
+	_, err = cli.Put(ctx, dbKey+"T2/3", "c")
+	assert.Nil(t, err)
+	lease, err := cli.Grant(ctx, 60)
+	assert.Nil(t, err)
+	intent, err := json.Marshal(journalIntent{Owner: int64(lease.ID), Migration: STORAGE_FORMAT_BASE + 1})
+	assert.Nil(t, err)
+	_, err = cli.Put(ctx, common.NewJournalKey(DB_NAME).String(), string(intent))
+	assert.Nil(t, err)
+	format, err := json.Marshal(StorageFormat{Version: STORAGE_FORMAT_BASE, Target: STORAGE_FORMAT_BASE + 1})
+	assert.Nil(t, err)
+	_, err = cli.Put(ctx, common.NewStorageFormatKey(DB_NAME).String(), string(format))
+	assert.Nil(t, err)
+	undo, err := json.Marshal(migrationUndo{Keys: []migrationUndoKey{
+		{Key: dbKey + "T1/1", Value: "a", Migrated: dbKey + "T1/1"},
+		{Key: dbKey + "T2/2", Value: "b", Migrated: dbKey + "T3/2"},
+	}})
+	assert.Nil(t, err)
+	_, err = cli.Txn(ctx).Then(
+		clientv3.OpPut(dbKey+"T1/1", "A"),
+		clientv3.OpDelete(dbKey+"T2/2"),
+		clientv3.OpPut(dbKey+"T3/2", "B"),
+		clientv3.OpPut(common.NewStorageUndoKey(DB_NAME, 1).String(), string(undo))).Commit()
+	assert.Nil(t, err)
+	_, err = cli.Put(ctx, dbKey+"T3/2", "X")
+	assert.Nil(t, err)
+
+	// the transactions wait for the live migration, and fail once it was interrupted
+	resp, err := cli.Get(ctx, common.NewJournalKey(DB_NAME).String())
+	assert.Nil(t, err)
+	assert.Nil(t, recoverJournal(ctx, cli, common.NewJournalKey(DB_NAME).String()))
+	_, err = cli.Revoke(ctx, lease.ID)
+	assert.Nil(t, err)
+	assert.NotNil(t, waitJournal(ctx, cli, resp.Kvs[0]))
+	recovered, err := RecoverJournal(ctx, cli, DB_NAME)
+	assert.True(t, recovered)
+	assert.NotNil(t, err)
+
+	_, err = migrator.Check(ctx, DB_NAME)
+	assert.NotNil(t, err)
+	assert.Nil(t, migrator.Run(ctx, DB_NAME))
+	assert.Equal(t, StorageFormat{Version: STORAGE_FORMAT_BASE + 1}, testStorageFormat(t, cli, DB_NAME))
+	assert.Equal(t, map[string]string{"T1/1": "A", "T3/2": "X", "T3/3": "C"}, testMigrationState(t, cli, DB_NAME))
+	testMigrationNoUndo(t, cli)
+}
+
+func testMigrationNoUndo(t *testing.T, cli *clientv3.Client) {
+	resp, err := cli.Get(context.Background(), common.NewStorageUndoPrefix(DB_NAME), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), resp.Count)
+}
+
+func TestStorageMigrationFence(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	ctx := context.Background()
+	migrator := NewStorageMigrator(cli, klogr.New())
+
+	_, err = cli.Put(ctx, common.NewDBPrefixKey(DB_NAME).String()+"T1/1", "a")
+	assert.Nil(t, err)
+	journal := common.NewJournalKey(DB_NAME).String()
+	fenced := false
+	storageMigrations = []StorageMigration{{
+		Version:     STORAGE_FORMAT_BASE + 1,
+		Description: "fenced",
+		Migrate: func(key string, value []byte) (string, []byte, error) {
+			// the migration holds the journal, so a writer cannot commit
+			res, err := cli.Txn(ctx).If(journalAbsent(journal)).Then(clientv3.OpPut(key, "client")).Commit()
+			assert.Nil(t, err)
+			fenced = !res.Succeeded
+			return key, []byte(strings.ToUpper(string(value))), nil
+		},
+	}}
+	defer func() { storageMigrations = nil }()
+	assert.Nil(t, migrator.Run(ctx, DB_NAME))
+	assert.True(t, fenced)
+	assert.Equal(t, map[string]string{"T1/1": "A"}, testMigrationState(t, cli, DB_NAME))
+}
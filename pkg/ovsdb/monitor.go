@@ -24,12 +24,35 @@ const (
 	UPDATE3          = "update3"
 )
 
+// Reasons of monitor cancellation, sent to clients as {"reason": <reason>} following the <json-value> of the
+// monitor_canceled notification. Clients can use it to choose between immediate reconnect and backoff.
+const (
+	CANCEL_REASON_CLIENT_REQUEST    = "client-request"
+	CANCEL_REASON_WATCH_COMPACTED   = "watch-compacted"
+	CANCEL_REASON_WATCH_FAILED      = "watch-failed"
+	CANCEL_REASON_SERVER_DRAINING   = "server-draining"
+	CANCEL_REASON_ADMIN_CANCEL      = "admin-cancel"
+	CANCEL_REASON_BACKPRESSURE      = "backpressure-eviction"
+	CANCEL_REASON_CONNECTION_CLOSED = "connection-closed"
+	CANCEL_REASON_SCHEMA_CONVERTED  = "schema-converted"
+)
+
 type updater struct {
 	mcr              ovsjson.MonitorCondRequest
 	tableSchema      *libovsdb.TableSchema
 	isV1             bool
 	notificationType ovsjson.UpdateNotificationType
 	jasonValueStr    string
+	// columns, which are hidden from the client
+	redacted map[string]bool
+	// the compiled "where" of the monitor request, nil if all the rows are selected
+	cond *monitorCondition
+	// the rows, whose selection was changed by a conditions change at the since revision, their earlier events are
+	// already reflected by the inserts and deletes sent to the client
+	since        int64
+	snapshotRows map[string]bool
+	// the revision of the initial data sent with the monitor reply, the earlier events are already reflected by it
+	initial int64
 }
 
 type handlerMonitorData struct {
@@ -38,15 +61,30 @@ type handlerMonitorData struct {
 	notificationType ovsjson.UpdateNotificationType
 
 	// updaters from the given json-value, key is the path in the monitor.
-	updatersKeys      []common.Key
-	dataBaseName      string
-	jsonValue         interface{}
-	notificationChain chan notificationEvent
+	updatersKeys  []common.Key
+	dataBaseName  string
+	jsonValue     interface{}
+	notifications *notificationQueue
+	// identifies monitors with identical requests, which share serialized notifications
+	requestKey string
 }
 
 type notificationEvent struct {
 	updates ovsjson.TableUpdates
-	wg      *sync.WaitGroup
+	// last-txn-id of update3 notifications, the id of the revision if empty
+	txnID string
+	// etcd revision of the updates, 0 for initial data
+	revision int64
+	// the requestKey of the monitor when the updates were prepared
+	requestKey string
+	// nil if the latency tracing is disabled
+	trace *notificationTrace
+	// the etcd events of the updates, nil if the updates cannot be coalesced with later updates
+	events []*clientv3.Event
+	// the updates were coalesced from the updates of several revisions, so they are not shared with other monitors
+	coalesced bool
+	// released after the updates are sent or dropped
+	wgs []*sync.WaitGroup
 }
 
 // Map from a key which represents a table paths (prefix/dbname/table) to arrays of updaters
@@ -74,12 +112,20 @@ func (k *Key2Updaters) removeUpdaters(key common.Key, jsonValue string) {
 type dbMonitor struct {
 	log logr.Logger
 
-	// etcd watcher channel
-	watchChannel clientv3.WatchChan
-	// cancel function to close the etcd watcher
+	// the changes of the database watch, which is shared with the monitors of the other clients, nil if the monitor
+	// does not watch the database
+	subscription *watchSubscription
+	// the monitor context, canceled when the monitor is canceled
+	watchCtx context.Context
+	// cancel function to cancel the monitor, and to remove it from the database watch
 	cancel context.CancelFunc
+	// processes the watch events by several goroutines, nil if the watch is not sharded
+	shards *watchShards
 
-	mu sync.Mutex
+	mu sync.RWMutex
+	// serializes the conditions changes with the preparation and the queueing of the notifications, so the updates
+	// of a conditions change are sent between the updates prepared with the old and the new conditions
+	condMu sync.RWMutex
 	// database name that the dbMonitor is watching
 	dataBaseName string
 
@@ -90,6 +136,8 @@ type dbMonitor struct {
 
 	revChecker revisionChecker
 	handler    *Handler
+	// the events of the chained commits in progress
+	journal journalBuffer
 }
 
 type revisionChecker struct {
@@ -107,12 +155,19 @@ func (rc *revisionChecker) isNewRevision(newRevision int64) bool {
 	return false
 }
 
+func (rc *revisionChecker) current() int64 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.revision
+}
+
 func newMonitor(dbName string, handler *Handler, log logr.Logger) *dbMonitor {
 	m := dbMonitor{
 		log:          log,
 		dataBaseName: dbName,
 		handler:      handler,
 		key2Updaters: Key2Updaters{},
+		journal:      journalBuffer{key: common.NewJournalKey(dbName).String()},
 	}
 	return &m
 }
@@ -137,6 +192,18 @@ func (m *dbMonitor) addUpdaters(keyToUpdaters Key2Updaters) {
 	}
 }
 
+// addUpdatersAt adds the updaters of a monitor, whose initial data was read at the revision. The revision checker of a
+// database monitor without other updaters is advanced to the revision, so the earlier events are not prepared at all.
+func (m *dbMonitor) addUpdatersAt(keyToUpdaters Key2Updaters, revision int64) {
+	m.mu.RLock()
+	empty := !m.hasUpdaters()
+	m.mu.RUnlock()
+	if empty {
+		m.revChecker.isNewRevision(revision)
+	}
+	m.addUpdaters(keyToUpdaters)
+}
+
 func (m *dbMonitor) removeUpdaters(keys []common.Key, jsonValue string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -145,65 +212,206 @@ func (m *dbMonitor) removeUpdaters(keys []common.Key, jsonValue string) {
 	}
 }
 
+// replaceUpdaters replaces the updaters of the given json-value for all the given keys under a single lock, so
+// concurrent notifications observe either all the old updaters or all the new ones.
+func (m *dbMonitor) replaceUpdaters(keyToUpdaters Key2Updaters, jsonValue string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, updaters := range keyToUpdaters {
+		m.key2Updaters.removeUpdaters(key, jsonValue)
+		if len(updaters) > 0 {
+			m.key2Updaters[key] = append(m.key2Updaters[key], updaters...)
+		}
+	}
+}
+
+// getUpdaters returns the updaters of the given json-value per key
+func (m *dbMonitor) getUpdaters(jsonValue string) Key2Updaters {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := Key2Updaters{}
+	for key, updaters := range m.key2Updaters {
+		for _, u := range updaters {
+			if u.jasonValueStr == jsonValue {
+				result[key] = append(result[key], u)
+			}
+		}
+	}
+	return result
+}
+
 func (m *dbMonitor) hasUpdaters() bool {
 	return len(m.key2Updaters) > 0
 }
 
 func (m *dbMonitor) start() {
+	if WatchShards > 1 && m.watchCtx != nil {
+		m.shards = newWatchShards(m, WatchShards)
+		m.shards.start()
+	}
+	if m.subscription == nil {
+		return
+	}
 	go func() {
-		for wresp := range m.watchChannel {
-			if wresp.Canceled {
-				m.cancelDbMonitor()
+		for {
+			changes, reason, ok := m.subscription.next(m.watchCtx)
+			if !ok {
+				if reason != "" {
+					m.cancelDbMonitor(reason)
+				}
 				return
 			}
-			m.notify(wresp.Events, wresp.Header.Revision, nil)
+			if changes.gap {
+				// the missed transactions cannot be told apart, their changes are notified together
+				m.dispatchTransaction(changes.events, changes.revision, changes.received)
+			} else {
+				m.dispatchAt(changes.events, changes.revision, changes.received)
+			}
 		}
 	}()
 }
 
+// dispatch sends the watched events to the monitors notifiers. A watch response can hold the events of several etcd
+// transactions, so the events are split by their revisions, and every notification has the changes of a single
+// transaction. The changes of a chained commit are buffered by the journal until the commit completes.
+func (m *dbMonitor) dispatch(watched []*clientv3.Event, watchRevision int64) {
+	m.dispatchAt(watched, watchRevision, time.Now())
+}
+
+// dispatchAt dispatches the events, which were received from etcd at the given time
+func (m *dbMonitor) dispatchAt(watched []*clientv3.Event, watchRevision int64, received time.Time) {
+	for _, group := range splitByRevision(watched, watchRevision) {
+		m.dispatchTransaction(group.events, group.revision, received)
+	}
+}
+
+// dispatchTransaction sends the events of a single transaction to the monitors notifiers
+func (m *dbMonitor) dispatchTransaction(watched []*clientv3.Event, watchRevision int64, received time.Time) {
+	events, revision := m.journal.add(watched, watchRevision)
+	if m.shards != nil {
+		m.shards.dispatch(events, revision, received)
+	} else {
+		m.notifyAt(events, revision, nil, received)
+	}
+}
+
+// revisionEvents are the events of a single etcd transaction
+type revisionEvents struct {
+	events   []*clientv3.Event
+	revision int64
+}
+
+// splitByRevision splits the events by their modification revisions, the events of every etcd transaction share the
+// same revision. The revision of the watch response is used for the events without revisions.
+func splitByRevision(events []*clientv3.Event, watchRevision int64) []revisionEvents {
+	groups := []revisionEvents{}
+	for _, ev := range events {
+		revision := watchRevision
+		if ev.Kv != nil && ev.Kv.ModRevision != 0 {
+			revision = ev.Kv.ModRevision
+		}
+		if n := len(groups); n > 0 && groups[n-1].revision == revision {
+			groups[n-1].events = append(groups[n-1].events, ev)
+			continue
+		}
+		groups = append(groups, revisionEvents{events: []*clientv3.Event{ev}, revision: revision})
+	}
+	return groups
+}
+
 func (hm *handlerMonitorData) notifier(ch *Handler) {
 	// we need some time to allow to the monitor calls return data
 	time.Sleep(5 * time.Millisecond)
+	var carried *notificationEvent
 	for {
-		select {
-		case <-ch.handlerContext.Done():
-			return
-
-		case notificationEvent := <-hm.notificationChain:
-			if ch.handlerContext.Err() != nil {
-				if notificationEvent.wg != nil {
-					notificationEvent.wg.Done()
-				}
+		var notificationEvent notificationEvent
+		if carried != nil {
+			notificationEvent, carried = *carried, nil
+		} else {
+			var ok bool
+			if notificationEvent, ok = hm.notifications.next(ch.handlerContext); !ok {
 				return
 			}
-			if hm.log.V(6).Enabled() {
-				hm.log.V(6).Info("send notification", "updates", notificationEvent.updates)
-			} else {
-				hm.log.V(5).Info("send notification")
+		}
+		carried = hm.collectUpdates(ch, &notificationEvent)
+		if notificationEvent.trace != nil {
+			notificationEvent.trace.dequeued = time.Now()
+		}
+		if ch.handlerContext.Err() != nil {
+			notificationEvent.done()
+			if carried != nil {
+				carried.done()
 			}
+			return
+		}
+		if hm.log.V(6).Enabled() {
+			hm.log.V(6).Info("send notification", "updates", notificationEvent.updates)
+		} else {
+			hm.log.V(5).Info("send notification")
+		}
 
-			var err error
-			switch hm.notificationType {
-			case ovsjson.Update:
-				err = ch.jrpcServer.Notify(ch.handlerContext, UPDATE, []interface{}{hm.jsonValue, notificationEvent.updates})
-			case ovsjson.Update2:
-				err = ch.jrpcServer.Notify(ch.handlerContext, UPDATE2, []interface{}{hm.jsonValue, notificationEvent.updates})
-			case ovsjson.Update3:
-				err = ch.jrpcServer.Notify(ch.handlerContext, UPDATE3, []interface{}{hm.jsonValue, ovsjson.ZERO_UUID, notificationEvent.updates})
+		var updates interface{} = notificationEvent.updates
+		if notificationEvent.revision > 0 && notificationEvent.requestKey != "" && !notificationEvent.coalesced {
+			sample := allocStart()
+			payload, err := sharedPayloads.get(notificationEvent.requestKey, notificationEvent.revision, notificationEvent.updates)
+			allocEnd(ALLOC_STAGE_ENCODE, sample)
+			if err != nil {
+				hm.log.Error(err, "serialize notification failed")
+			} else {
+				updates = payload
 			}
+		}
+		var method string
+		var params []interface{}
+		switch hm.notificationType {
+		case ovsjson.Update:
+			method, params = UPDATE, []interface{}{hm.jsonValue, updates}
+		case ovsjson.Update2:
+			method, params = UPDATE2, []interface{}{hm.jsonValue, updates}
+		case ovsjson.Update3:
+			txnID := notificationEvent.txnID
+			if txnID == "" && notificationEvent.revision > 0 {
+				txnID = ch.txnID(hm.dataBaseName, notificationEvent.revision)
+			}
+			if txnID == "" {
+				txnID = ovsjson.ZERO_UUID
+			}
+			method, params = UPDATE3, []interface{}{hm.jsonValue, txnID, updates}
+		}
+		// the notification is written by the connection writer, so the next updates can be prepared meanwhile
+		event := notificationEvent
+		ch.writer.write(method, params, func(err error) {
 			if err != nil {
 				// TODO should we do something else
 				hm.log.Error(err, "monitor notification failed")
+			} else {
+				hm.notifications.countWritten()
+				if event.revision > 0 {
+					ch.delivered.set(jsonValueToString(hm.jsonValue), event.revision, false)
+				}
+				if event.trace != nil {
+					event.trace.sent = time.Now()
+					latencyTracer.observe(ch.GetClientAddress(), event.trace)
+				}
 			}
-			if notificationEvent.wg != nil {
+			if len(event.wgs) > 0 {
 				hm.log.V(7).Info("sent notification and call wg.done")
-				notificationEvent.wg.Done()
 			}
+			event.done()
+		})
+		if carried == nil {
+			hm.notifications.sent()
 		}
 	}
 }
 
 func (m *dbMonitor) notify(events []*clientv3.Event, revision int64, wg *sync.WaitGroup) {
+	m.notifyAt(events, revision, wg, time.Now())
+}
+
+// notifyAt sends the events to the monitors notifiers, received is the time the events were received from etcd, it is
+// used by the latency tracing.
+func (m *dbMonitor) notifyAt(events []*clientv3.Event, revision int64, wg *sync.WaitGroup, received time.Time) {
 	var sentToNotifier bool
 	defer func() {
 		if wg != nil && !sentToNotifier {
@@ -214,9 +422,13 @@ func (m *dbMonitor) notify(events []*clientv3.Event, revision int64, wg *sync.Wa
 		m.log.V(5).Info("there is no events, return")
 		return
 	}
-	m.log.V(5).Info("notify", "revChecker.revision", m.revChecker.revision, "revision", revision, "wg == nil", wg == nil)
+	m.log.V(5).Info("notify", "revChecker.revision", m.revChecker.current(), "revision", revision, "wg == nil", wg == nil)
+	m.condMu.RLock()
+	defer m.condMu.RUnlock()
 	if m.revChecker.isNewRevision(revision) {
+		sample := allocStart()
 		result, err := m.prepareTableUpdate(events)
+		allocEnd(ALLOC_STAGE_DIFF, sample)
 		if err != nil {
 			m.log.Error(err, "prepareTableUpdate failed")
 		} else {
@@ -230,16 +442,38 @@ func (m *dbMonitor) notify(events []*clientv3.Event, revision int64, wg *sync.Wa
 			for jValue, tableUpdates := range result {
 				sentToNotifier = true
 				m.log.V(7).Info("notify", "table-update", tableUpdates)
-				m.handler.notify(jValue, tableUpdates, wg)
+				event := notificationEvent{updates: tableUpdates, revision: revision, trace: newTrace(received), events: events}
+				if wg != nil {
+					event.wgs = []*sync.WaitGroup{wg}
+				}
+				m.handler.notify(m, jValue, event)
 			}
 		}
 	} else {
-		m.log.V(5).Info("revisionChecker returned false", "old-revision", m.revChecker.revision, "notification-revision", revision)
+		m.log.V(5).Info("revisionChecker returned false", "old-revision", m.revChecker.current(), "notification-revision", revision)
 	}
 
 }
 
-func (m *dbMonitor) cancelDbMonitor() {
+// lockConditions locks the conditions of the monitor once all the dispatched revisions were delivered, and returns
+// the last delivered revision, the client has seen the changes till this revision only. The sharded revisions are
+// prepared after they were dispatched, so the lock is retried while some of them are pending.
+func (m *dbMonitor) lockConditions() int64 {
+	for {
+		m.condMu.Lock()
+		if m.shards == nil {
+			return m.revChecker.current()
+		}
+		revision, ok := m.shards.delivered()
+		if ok || m.watchCtx.Err() != nil {
+			return revision
+		}
+		m.condMu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (m *dbMonitor) cancelDbMonitor(reason string) {
 	m.cancel()
 	jasonValues := map[string]string{}
 	m.mu.Lock()
@@ -250,9 +484,30 @@ func (m *dbMonitor) cancelDbMonitor() {
 	}
 	m.key2Updaters = Key2Updaters{}
 	m.mu.Unlock()
-	for jsonValue := range jasonValues {
-		m.handler.monitorCanceledNotification(jsonValue)
+	m.handler.monitorsCanceled(m, jasonValues, reason)
+}
+
+// coalesce merges the event into the last queued event of the monitor of the json-value. The etcd events of both are
+// collapsed per row, and the updates are prepared again, so the client receives the changes from the state before the
+// last event to the state after the new one. It returns false if the events cannot be coalesced, since the updates of
+// any of them were not prepared from etcd events, or the monitor request was changed between them.
+func (m *dbMonitor) coalesce(jsonValue string, last, event *notificationEvent) bool {
+	if last.events == nil || event.events == nil || last.requestKey != event.requestKey || last.txnID != "" {
+		return false
+	}
+	events := collapseEvents(append(append([]*clientv3.Event{}, last.events...), event.events...))
+	result, err := prepareUpdates(m.log, m.dataBaseName, m.getUpdaters(jsonValue), events)
+	if err != nil {
+		m.log.Error(err, "coalesce updates failed")
+		return false
 	}
+	m.log.V(5).Info("coalesced updates", "jsonValue", jsonValue, "from", last.revision, "to", event.revision)
+	last.updates = result[jsonValue]
+	last.events = events
+	last.revision = event.revision
+	last.coalesced = true
+	last.wgs = append(last.wgs, event.wgs...)
+	return true
 }
 
 func mcrToUpdater(mcr ovsjson.MonitorCondRequest, jsonValue string, tableSchema *libovsdb.TableSchema, isV1 bool) *updater {
@@ -263,39 +518,66 @@ func mcrToUpdater(mcr ovsjson.MonitorCondRequest, jsonValue string, tableSchema
 }
 
 func (m *dbMonitor) prepareTableUpdate(events []*clientv3.Event) (map[string]ovsjson.TableUpdates, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return prepareUpdates(m.log, m.dataBaseName, m.key2Updaters, events)
+}
+
+// prepareUpdates returns the table updates of the events per monitor json-value
+func prepareUpdates(log logr.Logger, dbName string, key2Updaters Key2Updaters, events []*clientv3.Event) (map[string]ovsjson.TableUpdates, error) {
 	result := map[string]ovsjson.TableUpdates{}
-	m.mu.Lock()
-	defer m.mu.Unlock()
 	for _, ev := range events {
 		if ev.Kv == nil {
-			m.log.V(5).Info("empty etcd event", "event", fmt.Sprintf("%+v", ev))
+			log.V(5).Info("empty etcd event", "event", fmt.Sprintf("%+v", ev))
 			continue
 		}
 		key, err := common.ParseKey(string(ev.Kv.Key))
 		if err != nil {
-			m.log.Error(err, "parseKey failed")
+			eventLog.Error(log, err, EVENT_LOG_PARSE_KEY_ERROR, dbName, "parseKey failed", "key", string(ev.Kv.Key))
 			continue
 		}
-		updaters, ok := m.key2Updaters[key.ToTableKey()]
+		tablePath := key.TableKeyString()
+		updaters, ok := key2Updaters[key.ToTableKey()]
 		if !ok {
-			m.log.Info("no monitors for table path", "table-path", key.TableKeyString())
+			eventLog.Info(log, EVENT_LOG_NO_UPDATERS, tablePath, "no monitors for table path", "table-path", tablePath)
 			continue
 		}
+		// the row updates of the event per monitor, the updates of several monitor requests of the same table are
+		// merged into a single row update
+		eventUpdates := map[string]*ovsjson.RowUpdate{}
+		// the rows of the event are decoded once for all the updaters
+		rows := newEventRows(ev)
+		var uuid string
 		for _, updater := range updaters {
-			rowUpdate, uuid, err := updater.prepareRowUpdate(ev)
+			if updater.coveredBySnapshot(key.UUID, ev) {
+				continue
+			}
+			rowUpdate, rowUUID, err := updater.prepareEventRowUpdate(rows)
 			if err != nil {
-				m.log.Error(err, "prepareRowUpdate failed", "updater", updater)
+				eventLog.Error(log, err, EVENT_LOG_ROW_UPDATE_ERROR, tablePath, "prepareRowUpdate failed", "key", key.ShortString(), "updater", updater)
+				quarantine.Add(string(ev.Kv.Key), ev.Kv.ModRevision, err)
 				continue
 			}
 			if rowUpdate == nil {
 				// there is no updates
-				m.log.Info("no updates for table path", "table-path", key.TableKeyString())
+				eventLog.Info(log, EVENT_LOG_NO_ROW_UPDATE, tablePath, "no updates for table path", "table-path", tablePath)
 				continue
 			}
-			tableUpdates, ok := result[updater.jasonValueStr]
+			uuid = rowUUID
+			if merged, ok := eventUpdates[updater.jasonValueStr]; ok {
+				if err := mergeRowUpdates(merged, rowUpdate); err != nil {
+					eventLog.Error(log, err, EVENT_LOG_ROW_UPDATE_ERROR, tablePath, "mergeRowUpdates failed", "key", key.ShortString(), "updater", updater)
+					quarantine.Add(string(ev.Kv.Key), ev.Kv.ModRevision, err)
+				}
+			} else {
+				eventUpdates[updater.jasonValueStr] = rowUpdate
+			}
+		}
+		for jsonValueStr, rowUpdate := range eventUpdates {
+			tableUpdates, ok := result[jsonValueStr]
 			if !ok {
 				tableUpdates = ovsjson.TableUpdates{}
-				result[updater.jasonValueStr] = tableUpdates
+				result[jsonValueStr] = tableUpdates
 			}
 			tableUpdate, ok := tableUpdates[key.TableName]
 			if !ok {
@@ -305,9 +587,9 @@ func (m *dbMonitor) prepareTableUpdate(events []*clientv3.Event) (map[string]ovs
 			// check if there is a rowUpdate for the same uuid
 			_, ok = tableUpdate[uuid]
 			if ok {
-				m.log.Info("duplicate event", "key", key.ShortString(), "table-update", tableUpdate[uuid], "row-update", rowUpdate)
+				eventLog.Info(log, EVENT_LOG_DUPLICATE_EVENT, tablePath, "duplicate event", "key", key.ShortString(), "table-update", tableUpdate[uuid], "row-update", rowUpdate)
 				for n, eLog := range events {
-					m.log.V(7).Info("event", "index", n, "type", eLog.Type.String(), "key", string(eLog.Kv.Key), "value", string(eLog.Kv.Value), "prev-key", string(eLog.PrevKv.Key), "prev-value", string(eLog.PrevKv.Value))
+					log.V(7).Info("event", "index", n, "type", eLog.Type.String(), "key", string(eLog.Kv.Key), "value", string(eLog.Kv.Value), "prev-key", string(eLog.PrevKv.Key), "prev-value", string(eLog.PrevKv.Value))
 				}
 			}
 			tableUpdate[uuid] = *rowUpdate
@@ -316,58 +598,167 @@ func (m *dbMonitor) prepareTableUpdate(events []*clientv3.Event) (map[string]ovs
 	return result, nil
 }
 
+// the kinds of the row updates of an event, ordered by their precedence when the updates are merged
+const (
+	rowUpdateModify = iota
+	rowUpdateInsert
+	rowUpdateDelete
+)
+
+// rowUpdateKind returns the kind of the row update, of both the update and update2 formats
+func rowUpdateKind(u *ovsjson.RowUpdate) int {
+	switch {
+	case u.Delete || (u.Old != nil && u.New == nil):
+		return rowUpdateDelete
+	case u.Insert != nil || u.Initial != nil || (u.Old == nil && u.New != nil):
+		return rowUpdateInsert
+	default:
+		return rowUpdateModify
+	}
+}
+
+// mergeRowUpdates merges the row update of another monitor request of the same table and the same event into dst. The
+// requests with different conditions can see the event as different kinds of the row update, e.g. the row enters the
+// scope of one request, and is modified in the scope of the other one, while the client gets one kind of update per
+// row: a delete supersedes an insert and a modify, and an insert supersedes a modify. The result contains the union of
+// the columns of the requests of the resulting kind.
+func mergeRowUpdates(dst *ovsjson.RowUpdate, src *ovsjson.RowUpdate) error {
+	// the encoded rows are merged by their columns
+	if err := dst.DecodeRaw(); err != nil {
+		return err
+	}
+	if err := src.DecodeRaw(); err != nil {
+		return err
+	}
+	dstKind, srcKind := rowUpdateKind(dst), rowUpdateKind(src)
+	if srcKind > dstKind {
+		*dst = *src
+		return nil
+	}
+	if srcKind < dstKind {
+		return nil
+	}
+	dst.New = mergeRowColumns(dst.New, src.New)
+	dst.Old = mergeRowColumns(dst.Old, src.Old)
+	dst.Initial = mergeRowColumns(dst.Initial, src.Initial)
+	dst.Insert = mergeRowColumns(dst.Insert, src.Insert)
+	dst.Modify = mergeRowColumns(dst.Modify, src.Modify)
+	dst.Delete = dst.Delete || src.Delete
+	return nil
+}
+
+func mergeRowColumns(dst *map[string]interface{}, src *map[string]interface{}) *map[string]interface{} {
+	if src == nil {
+		return dst
+	}
+	merged := make(map[string]interface{}, len(*src))
+	if dst != nil {
+		for column, value := range *dst {
+			merged[column] = value
+		}
+	}
+	for column, value := range *src {
+		merged[column] = value
+	}
+	return &merged
+}
+
 func (u *updater) prepareRowUpdate(event *clientv3.Event) (*ovsjson.RowUpdate, string, error) {
+	return u.prepareEventRowUpdate(newEventRows(event))
+}
+
+// prepareEventRowUpdate returns the row update of the event, the rows of the event are decoded once for all the
+// updaters of the event
+func (u *updater) prepareEventRowUpdate(rows *eventRows) (*ovsjson.RowUpdate, string, error) {
+	event := rows.event
 	if !event.IsModify() { // the create or delete
 		if event.IsCreate() {
 			// Create event
-			return u.prepareCreateRowUpdate(event)
+			if ok, err := u.selects(rows.row); err != nil || !ok {
+				return nil, "", err
+			}
+			return u.prepareInsertRow(rows.row)
 		} else {
 			// Delete event
-			return u.prepareDeleteRowUpdate(event)
+			if ok, err := u.selects(rows.prevRow); err != nil || !ok {
+				return nil, "", err
+			}
+			return u.prepareDeleteRow(rows.prevRow)
 		}
 	}
-	// the event is modify
-	return u.prepareModifyRowUpdate(event)
+	// the event is modify, a row, which enters the conditions scope, is sent as insert, and a row, which leaves it, is
+	// sent as delete
+	selected, err := u.selects(rows.row)
+	if err != nil {
+		return nil, "", err
+	}
+	prevSelected, err := u.selects(rows.prevRow)
+	if err != nil {
+		return nil, "", err
+	}
+	switch {
+	case selected && prevSelected:
+		return u.prepareModifyRowUpdate(rows)
+	case selected:
+		return u.prepareInsertRow(rows.row)
+	case prevSelected:
+		return u.prepareDeleteRow(rows.prevRow)
+	}
+	return nil, "", nil
 }
 
-func (u *updater) prepareDeleteRowUpdate(event *clientv3.Event) (*ovsjson.RowUpdate, string, error) {
-	// Delete event
+// prepareDeleteRow returns the delete update of the row with the given value
+func (u *updater) prepareDeleteRow(row *rowValue) (*ovsjson.RowUpdate, string, error) {
 	if !libovsdb.MSIsTrue(u.mcr.Select.Delete) {
 		return nil, "", nil
 	}
-	value := event.PrevKv.Value
 	if !u.isV1 {
 		// according to https://docs.openvswitch.org/en/latest/ref/ovsdb-server.7/#update2-notification,
 		// "<row> is always a null object for a delete update."
-		_, uuid, err := u.prepareRow(value)
+		data, err := row.decode()
+		if err != nil {
+			return nil, "", err
+		}
+		uuid, err := getUUID(data)
 		if err != nil {
 			return nil, "", err
 		}
 		return &ovsjson.RowUpdate{Delete: true}, uuid, nil
 	}
+	if RawRowEncoding {
+		return u.prepareRawRowUpdate(row, func(ru *ovsjson.RowUpdate, data json.RawMessage) { ru.RawOld = data })
+	}
 
-	data, uuid, err := u.prepareRow(value)
+	data, uuid, err := u.prepareRowValue(row)
 	if err != nil {
 		return nil, "", err
 	}
-	if len(data) > 0 {
+	if len(data) > 0 || u.uuidOnly() {
 		// the delete for !u.isV1 we have returned before
 		return &ovsjson.RowUpdate{Old: &data}, uuid, nil
 	}
 	return nil, uuid, nil
 }
 
-func (u *updater) prepareCreateRowUpdate(event *clientv3.Event) (*ovsjson.RowUpdate, string, error) {
-	// the event is create
+// prepareInsertRow returns the insert update of the row with the given value
+func (u *updater) prepareInsertRow(row *rowValue) (*ovsjson.RowUpdate, string, error) {
 	if !libovsdb.MSIsTrue(u.mcr.Select.Insert) {
 		return nil, "", nil
 	}
-	value := event.Kv.Value
-	data, uuid, err := u.prepareRow(value)
+	if RawRowEncoding {
+		return u.prepareRawRowUpdate(row, func(ru *ovsjson.RowUpdate, data json.RawMessage) {
+			if !u.isV1 {
+				ru.RawInsert = data
+			} else {
+				ru.RawNew = data
+			}
+		})
+	}
+	data, uuid, err := u.prepareRowValue(row)
 	if err != nil {
 		return nil, "", err
 	}
-	if len(data) > 0 {
+	if len(data) > 0 || u.uuidOnly() {
 		if !u.isV1 {
 			return &ovsjson.RowUpdate{Insert: &data}, uuid, nil
 		}
@@ -376,16 +767,21 @@ func (u *updater) prepareCreateRowUpdate(event *clientv3.Event) (*ovsjson.RowUpd
 	return nil, "", nil
 }
 
-func (u *updater) prepareModifyRowUpdate(event *clientv3.Event) (*ovsjson.RowUpdate, string, error) {
+func (u *updater) prepareModifyRowUpdate(rows *eventRows) (*ovsjson.RowUpdate, string, error) {
 	// the event is modify
 	if !libovsdb.MSIsTrue(u.mcr.Select.Modify) {
 		return nil, "", nil
 	}
-	modifiedRow, uuid, err := u.prepareRow(event.Kv.Value)
+	// the rows are not compared, if none of the selected columns was modified
+	changed, err := u.selectedColumnsChanged(rows)
+	if err != nil || !changed {
+		return nil, "", err
+	}
+	modifiedRow, uuid, err := u.prepareRowValue(rows.row)
 	if err != nil {
 		return nil, "", err
 	}
-	prevRow, prevUUID, err := u.prepareRow(event.PrevKv.Value)
+	prevRow, prevUUID, err := u.prepareRowValue(rows.prevRow)
 	if err != nil {
 		return nil, "", err
 	}
@@ -406,6 +802,9 @@ func (u *updater) prepareModifyRowUpdate(event *clientv3.Event) (*ovsjson.RowUpd
 
 func (u *updater) compareModifiedRows(modifiedRow, prevRow, deltaRow map[string]interface{}) error {
 	for column, cValue := range modifiedRow {
+		if !InternalColumns.Diffed(column) {
+			continue
+		}
 		if !reflect.DeepEqual(cValue, prevRow[column]) {
 			columnSchema, err := u.tableSchema.LookupColumn(column)
 			if err != nil {
@@ -486,11 +885,24 @@ func (u *updater) prepareCreateRowInitial(value *[]byte) (*ovsjson.RowUpdate, st
 	if !libovsdb.MSIsTrue(u.mcr.Select.Initial) {
 		return nil, "", nil
 	}
-	data, uuid, err := u.prepareRow(*value)
+	row := newRowValue(*value)
+	if ok, err := u.selects(row); err != nil || !ok {
+		return nil, "", err
+	}
+	if RawRowEncoding {
+		return u.prepareRawRowUpdate(row, func(ru *ovsjson.RowUpdate, data json.RawMessage) {
+			if !u.isV1 {
+				ru.RawInitial = data
+			} else {
+				ru.RawNew = data
+			}
+		})
+	}
+	data, uuid, err := u.prepareRowValue(row)
 	if err != nil {
 		return nil, "", err
 	}
-	if len(data) > 0 {
+	if len(data) > 0 || u.uuidOnly() {
 		if !u.isV1 {
 			return &ovsjson.RowUpdate{Initial: &data}, uuid, nil
 		}
@@ -499,18 +911,32 @@ func (u *updater) prepareCreateRowInitial(value *[]byte) (*ovsjson.RowUpdate, st
 	return nil, uuid, nil
 }
 
-func (u *updater) deleteUnselectedColumns(data map[string]interface{}) map[string]interface{} {
-	if len(u.mcr.Columns) != 0 {
-		newData := map[string]interface{}{}
+// uuidOnly returns true if the client has explicitly requested an empty columns list, it is notified about the rows
+// existence only: insertions and deletions are reported with empty rows, modifications are not reported.
+func (u *updater) uuidOnly() bool {
+	return u.mcr.Columns != nil && len(u.mcr.Columns) == 0
+}
+
+// selectedColumns returns a copy of the row with the selected columns only, without the row uuid
+func (u *updater) selectedColumns(data map[string]interface{}) map[string]interface{} {
+	// nil columns means all the columns
+	if u.mcr.Columns != nil {
+		newData := make(map[string]interface{}, len(u.mcr.Columns))
 		for _, column := range u.mcr.Columns {
 			value, ok := data[column]
-			if ok {
+			if ok && column != COL_UUID {
 				newData[column] = value
 			}
 		}
 		return newData
 	}
-	return data
+	newData := make(map[string]interface{}, len(data))
+	for column, value := range data {
+		if column != COL_UUID {
+			newData[column] = value
+		}
+	}
+	return newData
 }
 
 func unmarshalData(data []byte) (map[string]interface{}, error) {
@@ -522,11 +948,20 @@ func unmarshalData(data []byte) (map[string]interface{}, error) {
 }
 
 func getAndDeleteUUID(data map[string]interface{}) (string, error) {
+	uuid, err := getUUID(data)
+	if err != nil {
+		return "", err
+	}
+	delete(data, COL_UUID)
+	return uuid, nil
+}
+
+// getUUID returns the uuid of the row
+func getUUID(data map[string]interface{}) (string, error) {
 	uuidInt, ok := data[COL_UUID]
 	if !ok {
 		return "", fmt.Errorf("row doesn't contain %s", COL_UUID)
 	}
-	delete(data, COL_UUID)
 	uuid, ok := uuidInt.([]interface{})
 	if !ok {
 		return "", fmt.Errorf("wrong uuid type %T %v", uuidInt, uuidInt)
@@ -543,16 +978,22 @@ func getAndDeleteUUID(data map[string]interface{}) (string, error) {
 }
 
 func (u *updater) prepareRow(value []byte) (map[string]interface{}, string, error) {
-	data, err := unmarshalData(value)
+	return u.prepareRowValue(newRowValue(value))
+}
+
+// prepareRowValue returns the selected columns of the row, the decoded row is shared by the updaters, so it is copied
+func (u *updater) prepareRowValue(row *rowValue) (map[string]interface{}, string, error) {
+	decoded, err := row.decode()
 	if err != nil {
 		return nil, "", err
 	}
-	uuid, err := getAndDeleteUUID(data)
+	uuid, err := getUUID(decoded)
 	if err != nil {
 		return nil, "", err
 	}
-	data = u.deleteUnselectedColumns(data)
-	// TODO handle where
+	data := u.selectedColumns(decoded)
+	InternalColumns.StripForMonitor(data)
+	redactRow(data, u.redacted)
 	return data, uuid, nil
 }
 
@@ -0,0 +1,243 @@
+package ovsdb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+// monitorCondition is the "where" of a monitor condition request. Unlike the conditions of a transaction, which must
+// all be true, a row is selected by a monitor condition if any of its clauses is true. An omitted or empty "where"
+// and a true clause select all the rows, a false clause selects none.
+type monitorCondition struct {
+	all         bool
+	clauses     []*Condition
+	tableSchema *libovsdb.TableSchema
+}
+
+func newMonitorCondition(log logr.Logger, tableSchema *libovsdb.TableSchema, where interface{}) (*monitorCondition, error) {
+	mc := &monitorCondition{tableSchema: tableSchema}
+	switch w := where.(type) {
+	case nil:
+		mc.all = true
+		return mc, nil
+	case bool:
+		mc.all = w
+		return mc, nil
+	case []interface{}:
+		if len(w) == 0 {
+			mc.all = true
+			return mc, nil
+		}
+		// the conditions don't contain named-uuids, the transaction is used for logging only
+		txn := &Transaction{log: log}
+		for _, clause := range w {
+			switch c := clause.(type) {
+			case bool:
+				mc.all = mc.all || c
+			case []interface{}:
+				cond, err := NewCondition(txn, tableSchema, nil, c)
+				if err != nil {
+					return nil, err
+				}
+				mc.clauses = append(mc.clauses, cond)
+			default:
+				return nil, fmt.Errorf("%s: wrong monitor condition %v", E_CONSTRAINT_VIOLATION, clause)
+			}
+		}
+		return mc, nil
+	default:
+		return nil, fmt.Errorf("%s: wrong monitor condition %v", E_CONSTRAINT_VIOLATION, where)
+	}
+}
+
+// selects returns true if the row, the stored etcd value, is selected by the condition
+func (mc *monitorCondition) selects(value []byte) (bool, error) {
+	return mc.selectsRow(newRowValue(value))
+}
+
+// selectsRow returns true if the decoded row is selected by the condition
+func (mc *monitorCondition) selectsRow(value *rowValue) (bool, error) {
+	if mc.all {
+		return true, nil
+	}
+	if len(mc.clauses) == 0 {
+		return false, nil
+	}
+	row, err := value.decodeTyped(mc.tableSchema)
+	if err != nil {
+		return false, err
+	}
+	for _, clause := range mc.clauses {
+		ok, err := clause.Compare(&row)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compileCondition compiles the "where" of the updater monitor request, the condition of an updater, which selects all
+// the rows, is nil
+func (u *updater) compileCondition(log logr.Logger) error {
+	// the rows selected by a condition on a redacted column would reveal its values
+	if where, ok := u.mcr.Where.([]interface{}); ok && len(u.redacted) > 0 {
+		for _, column := range conditionsColumns(&where) {
+			if u.redacted[column] {
+				return fmt.Errorf("%s: column %s is redacted", E_PERMISSION_ERROR, column)
+			}
+		}
+	}
+	mc, err := newMonitorCondition(log, u.tableSchema, u.mcr.Where)
+	if err != nil {
+		return err
+	}
+	u.cond = mc
+	if mc.all {
+		u.cond = nil
+	}
+	return nil
+}
+
+// selects returns true if the row, the stored etcd value, is selected by the updater condition
+func (u *updater) selects(row *rowValue) (bool, error) {
+	if u.cond == nil {
+		return true, nil
+	}
+	return u.cond.selectsRow(row)
+}
+
+// anySelects returns true if any of the updaters selects the row
+func anySelects(updaters []updater, row *rowValue) (bool, error) {
+	for _, u := range updaters {
+		ok, err := u.selects(row)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// conditionChangeUpdates returns the row updates of a monitor conditions change, inserts of the rows, which are
+// selected by the new updaters only, and deletes of the rows, which are selected by the old updaters only. The
+// conditions of the updaters must be compiled. The rows are read at the given revision, or at the current one if it's
+// 0, the read revision is returned with the rows whose selection was changed per table key.
+func (ch *Handler) conditionChangeUpdates(oldUpdaters, newUpdaters Key2Updaters, revision int64) (ovsjson.TableUpdates, int64, map[common.Key]map[string]bool, error) {
+	keys := []common.Key{}
+	for key := range newUpdaters {
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, 0, nil, nil
+	}
+	resp, err := ch.db.GetDataAt(keys, revision)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if revision == 0 {
+		revision = resp.Header.Revision
+	}
+	if len(resp.Responses) != len(keys) {
+		return nil, 0, nil, errors.New(E_INTERNAL_ERROR)
+	}
+	updates := ovsjson.TableUpdates{}
+	changed := map[common.Key]map[string]bool{}
+	for i, opRes := range resp.Responses {
+		tableKey := keys[i]
+		for _, kv := range opRes.GetResponseRange().Kvs {
+			// the row is decoded once for the old and the new updaters
+			row := newRowValue(kv.Value)
+			wasSelected, err := anySelects(oldUpdaters[tableKey], row)
+			if err != nil {
+				return nil, 0, nil, err
+			}
+			isSelected, err := anySelects(newUpdaters[tableKey], row)
+			if err != nil {
+				return nil, 0, nil, err
+			}
+			if wasSelected == isSelected {
+				continue
+			}
+			key, err := common.ParseKey(string(kv.Key))
+			if err != nil {
+				quarantine.Add(string(kv.Key), kv.ModRevision, err)
+				continue
+			}
+			if changed[tableKey] == nil {
+				changed[tableKey] = map[string]bool{}
+			}
+			changed[tableKey][key.UUID] = true
+			var rowUpdate *ovsjson.RowUpdate
+			if isSelected {
+				rowUpdate, err = conditionChangeRowUpdate(newUpdaters[tableKey], true, row)
+			} else {
+				rowUpdate, err = conditionChangeRowUpdate(oldUpdaters[tableKey], false, row)
+			}
+			if err != nil {
+				quarantine.Add(string(kv.Key), kv.ModRevision, err)
+				continue
+			}
+			if rowUpdate == nil {
+				continue
+			}
+			tableUpdate, ok := updates[tableKey.TableName]
+			if !ok {
+				tableUpdate = ovsjson.TableUpdate{}
+				updates[tableKey.TableName] = tableUpdate
+			}
+			tableUpdate[key.UUID] = *rowUpdate
+		}
+	}
+	return updates, revision, changed, nil
+}
+
+// conditionChangeRowUpdate returns the insert of a row, which entered the conditions scope, or the delete of a row,
+// which left it. The row is prepared by the updaters, whose conditions select it, nil if there is nothing to send.
+func conditionChangeRowUpdate(updaters []updater, inserted bool, row *rowValue) (*ovsjson.RowUpdate, error) {
+	var result *ovsjson.RowUpdate
+	for _, u := range updaters {
+		ok, err := u.selects(row)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		var rowUpdate *ovsjson.RowUpdate
+		if inserted {
+			rowUpdate, _, err = u.prepareInsertRow(row)
+		} else {
+			rowUpdate, _, err = u.prepareDeleteRow(row)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rowUpdate == nil {
+			continue
+		}
+		if result == nil {
+			result = rowUpdate
+		} else if err := mergeRowUpdates(result, rowUpdate); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// coveredBySnapshot returns true if the event of the row is already reflected by the initial data of the monitor, or
+// by the snapshot of a conditions change, which were sent to the client.
+func (u *updater) coveredBySnapshot(uuid string, ev *clientv3.Event) bool {
+	if ev.Kv.ModRevision <= u.initial {
+		return true
+	}
+	return ev.Kv.ModRevision <= u.since && u.snapshotRows[uuid]
+}
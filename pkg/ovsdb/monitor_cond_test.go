@@ -0,0 +1,330 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+// testMonitorDB serves the schemas and the rows of a single table
+type testMonitorDB struct {
+	DatabaseMock
+	schemas  libovsdb.Schemas
+	kvs      []*mvccpb.KeyValue
+	revision int64
+	// the rows of the earlier revisions
+	history map[int64][]*mvccpb.KeyValue
+}
+
+func (db *testMonitorDB) GetSchemas() libovsdb.Schemas {
+	return db.schemas
+}
+
+func (db *testMonitorDB) GetData(keys []common.Key) (*clientv3.TxnResponse, error) {
+	resp := &clientv3.TxnResponse{Header: &etcdserverpb.ResponseHeader{Revision: db.revision}}
+	for range keys {
+		resp.Responses = append(resp.Responses, &etcdserverpb.ResponseOp{
+			Response: &etcdserverpb.ResponseOp_ResponseRange{ResponseRange: &etcdserverpb.RangeResponse{Kvs: db.kvs}}})
+	}
+	return resp, nil
+}
+
+func (db *testMonitorDB) GetDataAt(keys []common.Key, revision int64) (*clientv3.TxnResponse, error) {
+	kvs, ok := db.history[revision]
+	if !ok {
+		return db.GetData(keys)
+	}
+	resp, _ := db.GetData(keys)
+	for _, r := range resp.Responses {
+		r.GetResponseRange().Kvs = kvs
+	}
+	return resp, nil
+}
+
+func (db *testMonitorDB) GetDataPages(keys []common.Key, pageSize int, page func(kvs []*mvccpb.KeyValue)) (int64, error) {
+	resp, _ := db.GetData(keys)
+	txnResponsePages(resp, page)
+	return db.revision, nil
+}
+
+func testMonitorCondSchemas() libovsdb.Schemas {
+	return libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name: DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {Columns: map[string]*libovsdb.ColumnSchema{
+			"name": {Type: libovsdb.TypeString},
+			"n":    {Type: libovsdb.TypeInteger},
+		}}},
+	}}
+}
+
+func testMonitorCondRow(t *testing.T, uuid string, name string, n int, revision int64) *mvccpb.KeyValue {
+	value, err := json.Marshal(map[string]interface{}{COL_UUID: libovsdb.UUID{GoUUID: uuid}, "name": name, "n": n})
+	assert.Nil(t, err)
+	return &mvccpb.KeyValue{Key: []byte(common.NewDataKey(DB_NAME, "T1", uuid).String()), Value: value,
+		CreateRevision: revision, ModRevision: revision}
+}
+
+func TestMonitorConditionSelects(t *testing.T) {
+	schemas := testMonitorCondSchemas()
+	tableSchema, err := schemas[DB_NAME].LookupTable("T1")
+	assert.Nil(t, err)
+	row := testMonitorCondRow(t, "u1", "a", 1, 1).Value
+	for where, expected := range map[string]bool{
+		`null`:                                  true,
+		`true`:                                  true,
+		`false`:                                 false,
+		`[]`:                                    true,
+		`[false]`:                               false,
+		`[false, true]`:                         true,
+		`[["n", "==", 2]]`:                      false,
+		`[["n", "==", 2], ["name", "==", "a"]]`: true,
+		`[["n", "<", 2], ["name", "!=", "a"]]`:  true,
+		`[["n", ">", 1], ["name", "includes", "b"]]`: false,
+	} {
+		var w interface{}
+		assert.Nil(t, json.Unmarshal([]byte(where), &w))
+		mc, err := newMonitorCondition(klogr.New(), tableSchema, w)
+		assert.Nil(t, err, where)
+		ok, err := mc.selects(row)
+		assert.Nil(t, err, where)
+		assert.Equal(t, expected, ok, where)
+	}
+	for _, where := range []string{`[["c1", "==", "a"]]`, `[["n", "=="]]`, `"true"`, `[1]`} {
+		var w interface{}
+		assert.Nil(t, json.Unmarshal([]byte(where), &w))
+		_, err := newMonitorCondition(klogr.New(), tableSchema, w)
+		assert.NotNil(t, err, where)
+	}
+}
+
+func TestMonitorCondChangeReevaluation(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	db := &testMonitorDB{schemas: testMonitorCondSchemas(), revision: 10}
+	db.kvs = []*mvccpb.KeyValue{
+		testMonitorCondRow(t, "u1", "a", 1, 1),
+		testMonitorCondRow(t, "u2", "b", 2, 2),
+		testMonitorCondRow(t, "u3", "c", 3, 3),
+	}
+	handler := NewHandler(context.Background(), db, nil, klogr.New())
+	recorder := &jrpcServerRecorder{}
+	handler.SetConnection(recorder, nil)
+	var params []interface{}
+	err := json.Unmarshal([]byte(`["dbName", "monid", {"T1": [{"columns": ["name"], "where": [["n", "<=", 2]]}]}]`), &params)
+	assert.Nil(t, err)
+	_, err = handler.addMonitor(params, ovsjson.Update2)
+	assert.Nil(t, err)
+	handler.startNotifier(jsonValueToString("monid"))
+
+	err = json.Unmarshal([]byte(`["monid", "monid", {"T1": [{"where": [["n", ">=", 2]]}]}]`), &params)
+	assert.Nil(t, err)
+	_, err = handler.MonitorCondChange(context.Background(), params)
+	assert.Nil(t, err)
+	assert.Nil(t, handler.FlushNotifications(context.Background()))
+
+	recorder.mu.Lock()
+	assert.Equal(t, []string{UPDATE2}, recorder.method)
+	var notification []interface{}
+	err = json.Unmarshal(recorder.params[0], &notification)
+	recorder.mu.Unlock()
+	assert.Nil(t, err)
+	assert.Equal(t, "monid", notification[0])
+	// u1 left the conditions scope, u3 entered it, u2 is selected by both conditions
+	expected := map[string]interface{}{
+		"T1": map[string]interface{}{
+			"u1": map[string]interface{}{"delete": nil},
+			"u3": map[string]interface{}{"insert": map[string]interface{}{"name": "c"}},
+		},
+	}
+	assert.Equal(t, expected, notification[1])
+
+	// the events of the changed rows till the snapshot revision are not sent again
+	monitor := handler.monitors[DB_NAME]
+	prev := testMonitorCondRow(t, "u3", "c", 3, 3)
+	modified := testMonitorCondRow(t, "u3", "d", 3, 10)
+	result, err := monitor.prepareTableUpdate([]*clientv3.Event{{Type: mvccpb.PUT, PrevKv: prev, Kv: modified}})
+	assert.Nil(t, err)
+	assert.Empty(t, result)
+	modified.ModRevision = 11
+	result, err = monitor.prepareTableUpdate([]*clientv3.Event{{Type: mvccpb.PUT, PrevKv: prev, Kv: modified}})
+	assert.Nil(t, err)
+	assert.Equal(t, ovsjson.TableUpdates{"T1": {"u3": {Modify: &map[string]interface{}{"name": "d"}}}}, result[jsonValueToString("monid")])
+
+	// a wrong condition doesn't change the monitor
+	err = json.Unmarshal([]byte(`["monid", "monid", {"T1": [{"where": [["c1", "==", "a"]]}]}]`), &params)
+	assert.Nil(t, err)
+	_, err = handler.MonitorCondChange(context.Background(), params)
+	assert.NotNil(t, err)
+	assert.Equal(t, []interface{}{[]interface{}{"n", ">=", float64(2)}}, monitor.key2Updaters[common.NewTableKey(DB_NAME, "T1")][0].mcr.Where)
+}
+
+func TestMonitorCondChangeDeliveredRevision(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	db := &testMonitorDB{schemas: testMonitorCondSchemas(), revision: 10}
+	db.kvs = []*mvccpb.KeyValue{
+		testMonitorCondRow(t, "u1", "a", 1, 1),
+		testMonitorCondRow(t, "u2", "b", 2, 2),
+		testMonitorCondRow(t, "u3", "c", 3, 3),
+	}
+	handler := NewHandler(context.Background(), db, nil, klogr.New())
+	recorder := &jrpcServerRecorder{}
+	handler.SetConnection(recorder, nil)
+	var params []interface{}
+	err := json.Unmarshal([]byte(`["dbName", "monid", {"T1": [{"columns": ["name"], "where": [["n", "<=", 2]]}]}]`), &params)
+	assert.Nil(t, err)
+	// the initial data was sent at revision 10
+	_, err = handler.addMonitorWithState(params, ovsjson.Update3, func(dbName string, updatersMap Key2Updaters) (int64, error) {
+		return 10, nil
+	})
+	assert.Nil(t, err)
+	handler.startNotifier(jsonValueToString("monid"))
+
+	// u1 was deleted at revision 11, the monitor didn't deliver the deletion yet
+	db.history = map[int64][]*mvccpb.KeyValue{10: db.kvs}
+	db.kvs = db.kvs[1:]
+	db.revision = 11
+	err = json.Unmarshal([]byte(`["monid", "monid", {"T1": [{"where": [["n", ">=", 2]]}]}]`), &params)
+	assert.Nil(t, err)
+	_, err = handler.MonitorCondChange(context.Background(), params)
+	assert.Nil(t, err)
+	monitor := handler.monitors[DB_NAME]
+	prev := testMonitorCondRow(t, "u1", "a", 1, 1)
+	deleted := &mvccpb.KeyValue{Key: prev.Key, ModRevision: 11}
+	monitor.notifyAt([]*clientv3.Event{{Type: mvccpb.DELETE, PrevKv: prev, Kv: deleted}}, 11, nil, time.Now())
+	assert.Nil(t, handler.FlushNotifications(context.Background()))
+
+	// the client is told about u1, which it has seen, at the delivered revision
+	recorder.mu.Lock()
+	assert.Equal(t, []string{UPDATE3}, recorder.method)
+	var notification []interface{}
+	err = json.Unmarshal(recorder.params[0], &notification)
+	recorder.mu.Unlock()
+	assert.Nil(t, err)
+	expected := map[string]interface{}{
+		"T1": map[string]interface{}{
+			"u1": map[string]interface{}{"delete": nil},
+			"u3": map[string]interface{}{"insert": map[string]interface{}{"name": "c"}},
+		},
+	}
+	assert.Equal(t, expected, notification[2])
+	assert.Equal(t, handler.txnID(DB_NAME, 10), notification[1])
+}
+
+func TestMonitorConditionSetsAndMaps(t *testing.T) {
+	setTable, err := testSchemaSet.LookupTable("table1")
+	assert.Nil(t, err)
+	mapTable, err := testSchemaMap.LookupTable("table1")
+	assert.Nil(t, err)
+	setRow := []byte(`{"_uuid": ["uuid", "u1"], "string": ["set", ["a", "b"]]}`)
+	mapRow := []byte(`{"_uuid": ["uuid", "u1"], "string": ["map", [["k1", "a"], ["k2", "b"]]]}`)
+	for _, test := range []struct {
+		tableSchema *libovsdb.TableSchema
+		row         []byte
+		where       string
+		expected    bool
+	}{
+		{setTable, setRow, `[["string", "==", ["set", ["a", "b"]]]]`, true},
+		{setTable, setRow, `[["string", "!=", ["set", ["a", "b"]]]]`, false},
+		{setTable, setRow, `[["string", "includes", "a"]]`, true},
+		{setTable, setRow, `[["string", "includes", ["set", ["a", "c"]]]]`, false},
+		{setTable, setRow, `[["string", "excludes", ["set", ["c", "d"]]]]`, true},
+		{setTable, setRow, `[["string", "excludes", ["set", ["b", "c"]]]]`, false},
+		{mapTable, mapRow, `[["string", "includes", ["map", [["k1", "a"]]]]]`, true},
+		{mapTable, mapRow, `[["string", "includes", ["map", [["k1", "b"]]]]]`, false},
+		{mapTable, mapRow, `[["string", "excludes", ["map", [["k1", "b"], ["k3", "c"]]]]]`, true},
+		{mapTable, mapRow, `[["string", "excludes", ["map", [["k2", "b"]]]]]`, false},
+	} {
+		var w interface{}
+		assert.Nil(t, json.Unmarshal([]byte(test.where), &w))
+		mc, err := newMonitorCondition(klogr.New(), test.tableSchema, w)
+		assert.Nil(t, err, test.where)
+		ok, err := mc.selects(test.row)
+		assert.Nil(t, err, test.where)
+		assert.Equal(t, test.expected, ok, test.where)
+	}
+}
+
+func TestMonitorConditionFiltersEvents(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	tableSchema, err := testMonitorCondSchemas()[DB_NAME].LookupTable("T1")
+	assert.Nil(t, err)
+	var where interface{}
+	assert.Nil(t, json.Unmarshal([]byte(`[["n", ">", 1]]`), &where))
+	u := mcrToUpdater(ovsjson.MonitorCondRequest{Columns: []string{"name"}, Where: where}, "", tableSchema, false)
+	assert.Nil(t, u.compileCondition(klogr.New()))
+
+	in := testMonitorCondRow(t, "u1", "a", 2, 1)
+	out := testMonitorCondRow(t, "u1", "a", 1, 2)
+	inModified := testMonitorCondRow(t, "u1", "b", 3, 3)
+	for name, test := range map[string]struct {
+		event    clientv3.Event
+		expected *ovsjson.RowUpdate
+	}{
+		"create-selected":   {clientv3.Event{Type: mvccpb.PUT, Kv: in}, &ovsjson.RowUpdate{Insert: &map[string]interface{}{"name": "a"}}},
+		"create-unselected": {clientv3.Event{Type: mvccpb.PUT, Kv: out}, nil},
+		"delete-selected":   {clientv3.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: in.Key, ModRevision: 4}, PrevKv: in}, &ovsjson.RowUpdate{Delete: true}},
+		"delete-unselected": {clientv3.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: out.Key, ModRevision: 4}, PrevKv: out}, nil},
+		"modify-selected":   {clientv3.Event{Type: mvccpb.PUT, Kv: inModified, PrevKv: in}, &ovsjson.RowUpdate{Modify: &map[string]interface{}{"name": "b"}}},
+		"modify-entered":    {clientv3.Event{Type: mvccpb.PUT, Kv: in, PrevKv: out}, &ovsjson.RowUpdate{Insert: &map[string]interface{}{"name": "a"}}},
+		"modify-left":       {clientv3.Event{Type: mvccpb.PUT, Kv: out, PrevKv: in}, &ovsjson.RowUpdate{Delete: true}},
+		"modify-unselected": {clientv3.Event{Type: mvccpb.PUT, Kv: out, PrevKv: out}, nil},
+	} {
+		ev := test.event
+		if ev.PrevKv != nil && ev.Type == mvccpb.PUT {
+			// the modified row was created before the event
+			kv := *ev.Kv
+			kv.CreateRevision = 0
+			ev.Kv = &kv
+		}
+		rowUpdate, _, err := u.prepareRowUpdate(&ev)
+		assert.Nil(t, err, name)
+		assert.Equal(t, test.expected, rowUpdate, name)
+	}
+
+	// the initial data contains the selected rows only
+	rowUpdate, uuid, err := u.prepareCreateRowInitial(&in.Value)
+	assert.Nil(t, err)
+	assert.Equal(t, "u1", uuid)
+	assert.Equal(t, &ovsjson.RowUpdate{Initial: &map[string]interface{}{"name": "a"}}, rowUpdate)
+	rowUpdate, _, err = u.prepareCreateRowInitial(&out.Value)
+	assert.Nil(t, err)
+	assert.Nil(t, rowUpdate)
+}
+
+func TestMergeRowUpdatesKinds(t *testing.T) {
+	row := func(name string) *map[string]interface{} {
+		return &map[string]interface{}{"name": name}
+	}
+	for name, test := range map[string]struct {
+		dst, src, expected ovsjson.RowUpdate
+	}{
+		"insert-modify": {ovsjson.RowUpdate{Insert: row("a")}, ovsjson.RowUpdate{Modify: row("b")}, ovsjson.RowUpdate{Insert: row("a")}},
+		"modify-insert": {ovsjson.RowUpdate{Modify: row("b")}, ovsjson.RowUpdate{Insert: row("a")}, ovsjson.RowUpdate{Insert: row("a")}},
+		"delete-modify": {ovsjson.RowUpdate{Delete: true}, ovsjson.RowUpdate{Modify: row("b")}, ovsjson.RowUpdate{Delete: true}},
+		"modify-delete": {ovsjson.RowUpdate{Modify: row("b")}, ovsjson.RowUpdate{Delete: true}, ovsjson.RowUpdate{Delete: true}},
+		"insert-delete": {ovsjson.RowUpdate{Insert: row("a")}, ovsjson.RowUpdate{Delete: true}, ovsjson.RowUpdate{Delete: true}},
+		"modify-modify": {ovsjson.RowUpdate{Modify: row("a")}, ovsjson.RowUpdate{Modify: &map[string]interface{}{"n": 1}},
+			ovsjson.RowUpdate{Modify: &map[string]interface{}{"name": "a", "n": 1}}},
+		// the update format
+		"v1-insert-modify": {ovsjson.RowUpdate{New: row("a")}, ovsjson.RowUpdate{New: row("b"), Old: row("a")}, ovsjson.RowUpdate{New: row("a")}},
+		"v1-modify-delete": {ovsjson.RowUpdate{New: row("b"), Old: row("a")}, ovsjson.RowUpdate{Old: row("a")}, ovsjson.RowUpdate{Old: row("a")}},
+	} {
+		dst, src := test.dst, test.src
+		assert.Nil(t, mergeRowUpdates(&dst, &src), name)
+		assert.Equal(t, test.expected, dst, name)
+		if dst.New == nil && dst.Old == nil {
+			ok, msg := dst.ValidateRowUpdate2()
+			assert.True(t, ok, name+": "+msg)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	guuid "github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -124,6 +125,30 @@ func TestMonitorRowUpdate(t *testing.T) {
 					PrevKv: &mvccpb.KeyValue{Key: []byte("key/db/table/000"), Value: data1Json},
 					Kv:     &mvccpb.KeyValue{Key: []byte("key/db/table/000"), Value: data2Json, CreateRevision: 1, ModRevision: 2}},
 					expRowUpdate: &ovsjson.RowUpdate{Modify: &map[string]interface{}{"c2": "v3"}}}}},
+		"UUIDOnly-v2": {updater: *mcrToUpdater(ovsjson.MonitorCondRequest{Columns: []string{}}, "", &tableSchema, false),
+			op: operation{PUT: {event: clientv3.Event{Type: mvccpb.PUT,
+				Kv: &mvccpb.KeyValue{Key: []byte("key/db/table/000"), Value: data1Json, CreateRevision: 1, ModRevision: 1}},
+				expRowUpdate: &ovsjson.RowUpdate{Insert: &map[string]interface{}{}}},
+				DELETE: {event: clientv3.Event{Type: mvccpb.DELETE,
+					PrevKv: &mvccpb.KeyValue{Key: []byte("key/db/table/000"), Value: data1Json},
+					Kv:     &mvccpb.KeyValue{Key: []byte("key/db/table/000")}},
+					expRowUpdate: &ovsjson.RowUpdate{Delete: true}},
+				MODIFY: {event: clientv3.Event{Type: mvccpb.PUT,
+					PrevKv: &mvccpb.KeyValue{Key: []byte("key/db/table/000"), Value: data1Json},
+					Kv:     &mvccpb.KeyValue{Key: []byte("key/db/table/000"), Value: data2Json, CreateRevision: 1, ModRevision: 2}},
+					expRowUpdate: nil}}},
+		"UUIDOnly-v1": {updater: *mcrToUpdater(ovsjson.MonitorCondRequest{Columns: []string{}}, "", &tableSchema, true),
+			op: operation{PUT: {event: clientv3.Event{Type: mvccpb.PUT,
+				Kv: &mvccpb.KeyValue{Key: []byte("key/db/table/000"), Value: data1Json, CreateRevision: 1, ModRevision: 1}},
+				expRowUpdate: &ovsjson.RowUpdate{New: &map[string]interface{}{}}},
+				DELETE: {event: clientv3.Event{Type: mvccpb.DELETE,
+					PrevKv: &mvccpb.KeyValue{Key: []byte("key/db/table/000"), Value: data1Json},
+					Kv:     &mvccpb.KeyValue{Key: []byte("key/db/table/000")}},
+					expRowUpdate: &ovsjson.RowUpdate{Old: &map[string]interface{}{}}},
+				MODIFY: {event: clientv3.Event{Type: mvccpb.PUT,
+					PrevKv: &mvccpb.KeyValue{Key: []byte("key/db/table/000"), Value: data1Json},
+					Kv:     &mvccpb.KeyValue{Key: []byte("key/db/table/000"), Value: data2Json, CreateRevision: 1, ModRevision: 2}},
+					expRowUpdate: nil}}},
 		"ZeroColumn-v2": {updater: *mcrToUpdater(ovsjson.MonitorCondRequest{Columns: []string{"c3"}}, "", &tableSchema, false),
 			op: operation{PUT: {event: clientv3.Event{Type: mvccpb.PUT,
 				Kv: &mvccpb.KeyValue{Key: []byte("key/db/table/000"), Value: data1Json, CreateRevision: 1, ModRevision: 1}},
@@ -310,7 +335,8 @@ func TestMonitorAddRemoveMonitor(t *testing.T) {
 	db := DatabaseMock{Response: schemas}
 	ctx := context.Background()
 	handler := NewHandler(ctx, &db, nil, klogr.New())
-	expMsg, err := json.Marshal([]interface{}{monid, databaseSchemaName})
+	reason := map[string]string{"reason": CANCEL_REASON_CLIENT_REQUEST}
+	expMsg, err := json.Marshal([]interface{}{[]interface{}{monid, databaseSchemaName}, reason})
 	assert.Nil(t, err)
 	jrpcServerMock := jrpcServerMock{
 		expMethod:  MONITOR_CANCELED,
@@ -344,19 +370,39 @@ func TestMonitorAddRemoveMonitor(t *testing.T) {
 	assert.Equal(t, expKey2Updaters, monitor.key2Updaters)
 
 	// remove the second monitor
-	handler.removeMonitor(params[1], true)
+	handler.removeMonitor(params[1], CANCEL_REASON_CLIENT_REQUEST)
+	assert.Nil(t, handler.FlushNotifications(ctx))
 	assert.Equal(t, cloned, monitor.key2Updaters)
 
-	expMsg, err = json.Marshal(nil)
+	expMsg, err = json.Marshal([]interface{}{nil, reason})
 	assert.Nil(t, err)
 	jrpcServerMock.expMessage = expMsg
 
 	// remove the first monitor
-	handler.removeMonitor(nil, true)
+	handler.removeMonitor(nil, CANCEL_REASON_CLIENT_REQUEST)
+	assert.Nil(t, handler.FlushNotifications(ctx))
 	assert.Equal(t, 0, len(monitor.key2Updaters))
 	assert.Equal(t, 0, len(handler.monitors))
 }
 
+func TestMonitorDisableV1(t *testing.T) {
+	DisableMonitorV1 = true
+	defer func() { DisableMonitorV1 = false }()
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {}},
+	}}
+	db := DatabaseMock{Response: schemas}
+	handler := NewHandler(context.Background(), &db, nil, klogr.New())
+	var params []interface{}
+	err := json.Unmarshal([]byte(`["dbName", null, {"T1": {}}]`), &params)
+	assert.Nil(t, err)
+	_, err = handler.Monitor(context.Background(), params)
+	assert.NotNil(t, err)
+	assert.Empty(t, handler.handlerMonitorData)
+	assert.Empty(t, handler.monitors)
+}
+
 func TestMonitorParseCMPJsonValueNilMCRArray(t *testing.T) {
 	msg := `["OVN_Northbound",null,{"Logical_Router":[{"columns":["name"]}],"NB_Global":[{"columns":[]}]},"00000000-0000-0000-0000-000000000000"]`
 	var params []interface{}
@@ -409,7 +455,7 @@ func TestMonitorNotifications1(t *testing.T) {
 	schemas := libovsdb.Schemas{}
 	schemas[databaseSchemaName] = testSchemaSimple
 	jsonValue := `null`
-	msg := `["dbName",` + jsonValue + `,{"T1":[{"columns":[]}]}]`
+	msg := `["dbName",` + jsonValue + `,{"T1":[{}]}]`
 	handler := initHandler(t, schemas, msg, ovsjson.Update)
 	row := map[string]interface{}{"c1": "v1", "c2": "v2"}
 	dataJson := prepareData(t, row, true)
@@ -454,7 +500,7 @@ func TestMonitorNotifications2(t *testing.T) {
 	}
 	schemas := libovsdb.Schemas{}
 	schemas[databaseSchemaName] = testSchemaSimple
-	msg := `["dbName", ["monid","update2"],{"T2":[{"columns":[]}]}]`
+	msg := `["dbName", ["monid","update2"],{"T2":[{}]}]`
 	handler := initHandler(t, schemas, msg, ovsjson.Update2)
 	jsonValue := []interface{}{"monid", "update2"}
 	row := map[string]interface{}{"c1": "v1", "c2": "v2"}
@@ -500,7 +546,7 @@ func TestMonitorNotifications3(t *testing.T) {
 	}
 	schemas := libovsdb.Schemas{}
 	schemas[databaseSchemaName] = testSchemaSimple
-	msg := `["dbName",["monid","update3"], {"T3":[{"columns":[]}]}, "00000000-0000-0000-0000-000000000000"]`
+	msg := `["dbName",["monid","update3"], {"T3":[{}]}, "00000000-0000-0000-0000-000000000000"]`
 	jsonValue := []interface{}{"monid", "update3"}
 	handler := initHandler(t, schemas, msg, ovsjson.Update3)
 	row1 := map[string]interface{}{"c1": "v1", "c2": "v2"}
@@ -631,3 +677,199 @@ func TestSetsDifferenceDifferentSets(t *testing.T) {
 	diff := setsDifference(set1, set2)
 	assert.ElementsMatch(t, expectDiff.GoSet, diff.GoSet)
 }
+
+func TestMonitorCanceledReason(t *testing.T) {
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T3": {}},
+	}}
+	msg := `["dbName",["monid","update3"], {"T3":[{}]}, "00000000-0000-0000-0000-000000000000"]`
+	jsonValue := []interface{}{"monid", "update3"}
+	handler := initHandler(t, schemas, msg, ovsjson.Update3)
+	expMsg, err := json.Marshal([]interface{}{jsonValue, map[string]string{"reason": CANCEL_REASON_WATCH_COMPACTED}})
+	assert.Nil(t, err)
+	recorder := &jrpcServerRecorder{}
+	handler.SetConnection(recorder, nil)
+	monitor := handler.monitors[DB_NAME]
+	monitor.cancelDbMonitor(CANCEL_REASON_WATCH_COMPACTED)
+	assert.Equal(t, 0, len(handler.monitors))
+	assert.Equal(t, 0, len(handler.handlerMonitorData))
+	assert.Nil(t, handler.FlushNotifications(context.Background()))
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	assert.Equal(t, []string{MONITOR_CANCELED}, recorder.method)
+	assert.Equal(t, [][]byte{expMsg}, recorder.params)
+}
+
+func TestMonitorDispatchPerTransaction(t *testing.T) {
+	columns := map[string]*libovsdb.ColumnSchema{"c1": {Type: libovsdb.TypeString}}
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {Columns: columns}},
+	}}
+	handler := initHandler(t, schemas, `["dbName", "monid", {"T1": [{"columns": ["c1"]}]}]`, ovsjson.Update2)
+	monitor := handler.monitors[DB_NAME]
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan notificationEvent, 10)
+	go func() {
+		hmd := handler.handlerMonitorData[jsonValueToString("monid")]
+		for {
+			ev, ok := hmd.notifications.next(ctx)
+			if !ok {
+				return
+			}
+			received <- ev
+		}
+	}()
+	event := func(uuid string, revision int64) *clientv3.Event {
+		value, err := json.Marshal(map[string]interface{}{"c1": uuid, COL_UUID: libovsdb.UUID{GoUUID: uuid}})
+		assert.Nil(t, err)
+		return &clientv3.Event{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{Key: []byte("ovsdb/nb/dbName/T1/" + uuid),
+			Value: value, CreateRevision: revision, ModRevision: revision}}
+	}
+
+	// a watch response with the events of two transactions
+	monitor.dispatch([]*clientv3.Event{event("u1", 5), event("u2", 5), event("u3", 6)}, 7)
+	for _, expected := range []struct {
+		revision int64
+		rows     []string
+	}{{5, []string{"u1", "u2"}}, {6, []string{"u3"}}} {
+		select {
+		case ev := <-received:
+			assert.Equal(t, expected.revision, ev.revision)
+			assert.Equal(t, len(expected.rows), len(ev.updates["T1"]))
+			for _, uuid := range expected.rows {
+				assert.Contains(t, ev.updates["T1"], uuid)
+			}
+		case <-time.After(time.Second):
+			assert.Fail(t, "notification was not delivered", "revision", expected.revision)
+		}
+	}
+}
+
+func TestMonitorCondChangeMultipleTables(t *testing.T) {
+	columns := map[string]*libovsdb.ColumnSchema{"c1": {Type: libovsdb.TypeString}, "c2": {Type: libovsdb.TypeString}}
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {Columns: columns}, "T2": {Columns: columns}},
+	}}
+	msg := `["dbName", "monid", {"T1": [{"columns": ["c1"], "where": [["c1", "==", "a"]]}]}]`
+	handler := initHandler(t, schemas, msg, ovsjson.Update2)
+	// the tables are empty, the conditions change has no row updates
+	handler.db = &testMonitorDB{schemas: schemas}
+	key1 := common.NewTableKey(DB_NAME, "T1")
+	key2 := common.NewTableKey(DB_NAME, "T2")
+
+	var params []interface{}
+	err := json.Unmarshal([]byte(`["monid", "monid", {"T1": [{"where": [["c1", "==", "b"]]}], "T2": [{"columns": ["c2"], "where": true}]}]`), &params)
+	assert.Nil(t, err)
+	_, err = handler.MonitorCondChange(context.Background(), params)
+	assert.Nil(t, err)
+
+	monitor := handler.monitors[DB_NAME]
+	assert.Equal(t, 1, len(monitor.key2Updaters[key1]))
+	// the columns are kept from the original request
+	assert.Equal(t, []string{"c1"}, monitor.key2Updaters[key1][0].mcr.Columns)
+	assert.Equal(t, []interface{}{[]interface{}{"c1", "==", "b"}}, monitor.key2Updaters[key1][0].mcr.Where)
+	assert.Equal(t, 1, len(monitor.key2Updaters[key2]))
+	assert.Equal(t, []string{"c2"}, monitor.key2Updaters[key2][0].mcr.Columns)
+	assert.ElementsMatch(t, []common.Key{key1, key2}, handler.handlerMonitorData[jsonValueToString("monid")].updatersKeys)
+
+	// a wrong table fails the whole request, without changing the other tables
+	err = json.Unmarshal([]byte(`["monid", "monid", {"T1": [{"where": [["c1", "==", "c"]]}], "T3": [{"where": true}]}]`), &params)
+	assert.Nil(t, err)
+	_, err = handler.MonitorCondChange(context.Background(), params)
+	assert.NotNil(t, err)
+	assert.Equal(t, []interface{}{[]interface{}{"c1", "==", "b"}}, monitor.key2Updaters[key1][0].mcr.Where)
+}
+
+func TestMonitorMergeRequests(t *testing.T) {
+	columns := map[string]*libovsdb.ColumnSchema{}
+	for _, column := range []string{"c1", "c2", "c3", "c4"} {
+		columns[column] = &libovsdb.ColumnSchema{Type: libovsdb.TypeString}
+	}
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {Columns: columns}},
+	}}
+	msg := `["dbName", "monid", {"T1": [{"columns": ["c1"]}, {"columns": ["c2"], "select": {"modify": false}}, {"columns": ["c3"]}]}]`
+	handler := initHandler(t, schemas, msg, ovsjson.Update2)
+	monitor := handler.monitors[DB_NAME]
+	jsonValue := jsonValueToString("monid")
+
+	row := map[string]interface{}{"c1": "v1", "c2": "v2", "c3": "v3", "c4": "v4"}
+	dataJson := prepareData(t, row, true)
+	result, err := monitor.prepareTableUpdate([]*clientv3.Event{
+		{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{Key: []byte("ovsdb/nb/dbName/T1/000"),
+			Value: dataJson, CreateRevision: 1, ModRevision: 1}}})
+	assert.Nil(t, err)
+	expected := map[string]interface{}{"c1": "v1", "c2": "v2", "c3": "v3"}
+	assert.Equal(t, ovsjson.TableUpdates{"T1": {ROW_UUID: {Insert: &expected}}}, result[jsonValue])
+
+	modified := map[string]interface{}{"c1": "v11", "c2": "v22", "c3": "v3", "c4": "v44"}
+	modifiedJson := prepareData(t, modified, true)
+	result, err = monitor.prepareTableUpdate([]*clientv3.Event{
+		{Type: mvccpb.PUT,
+			PrevKv: &mvccpb.KeyValue{Key: []byte("ovsdb/nb/dbName/T1/000"), Value: dataJson, CreateRevision: 1, ModRevision: 1},
+			Kv:     &mvccpb.KeyValue{Key: []byte("ovsdb/nb/dbName/T1/000"), Value: modifiedJson, CreateRevision: 1, ModRevision: 2}}})
+	assert.Nil(t, err)
+	// c2 is not reported, because its monitor request doesn't select modifications
+	expected = map[string]interface{}{"c1": "v11"}
+	assert.Equal(t, ovsjson.TableUpdates{"T1": {ROW_UUID: {Modify: &expected}}}, result[jsonValue])
+}
+
+func TestMonitorInitialRevision(t *testing.T) {
+	columns := map[string]*libovsdb.ColumnSchema{"c1": {Type: libovsdb.TypeString}}
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {Columns: columns}},
+	}}
+	handler := initHandler(t, schemas, `["dbName", "mon1", {"T1": [{"columns": ["c1"]}]}]`, ovsjson.Update2)
+	monitor := handler.monitors[DB_NAME]
+	_, err := handler.addMonitorWithState([]interface{}{DB_NAME, "mon2", map[string]interface{}{"T1": []interface{}{map[string]interface{}{}}}},
+		ovsjson.Update2, func(dbName string, updatersMap Key2Updaters) (int64, error) {
+			assert.Equal(t, DB_NAME, dbName)
+			return 5, nil
+		})
+	assert.Nil(t, err)
+	// the revision checker is not advanced, mon1 waits for the earlier events
+	assert.Equal(t, int64(0), monitor.revChecker.revision)
+
+	// a monitor, whose state cannot be read, is removed
+	_, err = handler.addMonitorWithState([]interface{}{DB_NAME, "mon3", map[string]interface{}{"T1": []interface{}{map[string]interface{}{}}}},
+		ovsjson.Update2, func(dbName string, updatersMap Key2Updaters) (int64, error) {
+			return 0, fmt.Errorf("read failed")
+		})
+	assert.NotNil(t, err)
+	assert.NotContains(t, handler.handlerMonitorData, jsonValueToString("mon3"))
+	assert.Equal(t, monitor, handler.monitors[DB_NAME])
+
+	event := func(uuid string, revision int64) []*clientv3.Event {
+		value, err := json.Marshal(map[string]interface{}{"c1": uuid, COL_UUID: libovsdb.UUID{GoUUID: uuid}})
+		assert.Nil(t, err)
+		return []*clientv3.Event{{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{Key: []byte("ovsdb/nb/dbName/T1/" + uuid),
+			Value: value, CreateRevision: revision, ModRevision: revision}}}
+	}
+	// the event of revision 4 is reflected by the initial data of mon2
+	monitor.notify(event("u1", 4), 4, nil)
+	monitor.notify(event("u2", 6), 6, nil)
+	for jsonValue, expected := range map[string][]int64{"mon1": {4, 6}, "mon2": {6}} {
+		hmd := handler.handlerMonitorData[jsonValueToString(jsonValue)]
+		var revisions []int64
+		for hmd.notifications.size() > 0 {
+			ev, _ := hmd.notifications.pop()
+			revisions = append(revisions, ev.revision)
+		}
+		assert.Equal(t, expected, revisions, jsonValue)
+	}
+
+	// the revision checker of a new database monitor starts at the revision of the initial data
+	handler = NewHandler(context.Background(), &DatabaseMock{Response: schemas}, nil, klogr.New())
+	_, err = handler.addMonitorWithState([]interface{}{DB_NAME, "mon1", map[string]interface{}{"T1": []interface{}{map[string]interface{}{}}}},
+		ovsjson.Update2, func(dbName string, updatersMap Key2Updaters) (int64, error) {
+			return 5, nil
+		})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), handler.monitors[DB_NAME].revChecker.revision)
+}
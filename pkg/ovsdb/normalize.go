@@ -0,0 +1,74 @@
+package ovsdb
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// normalizeRow brings the row values to their canonical form before they are stored: UUIDs are lower-cased, set
+// elements are sorted and deduplicated, and map keys and values are normalized. Together with the sorted encoding of
+// columns and map pairs, semantically equal rows are stored as equal bytes.
+func normalizeRow(row *map[string]interface{}) {
+	for column, value := range *row {
+		(*row)[column] = normalizeValue(value)
+	}
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case libovsdb.UUID:
+		return normalizeUUID(v)
+	case libovsdb.OvsSet:
+		return normalizeSet(v)
+	case *libovsdb.OvsSet:
+		if v == nil {
+			return v
+		}
+		set := normalizeSet(*v)
+		return &set
+	case libovsdb.OvsMap:
+		return normalizeMap(v)
+	case *libovsdb.OvsMap:
+		if v == nil {
+			return v
+		}
+		m := normalizeMap(*v)
+		return &m
+	}
+	return value
+}
+
+func normalizeUUID(uuid libovsdb.UUID) libovsdb.UUID {
+	lower := libovsdb.UUID{GoUUID: strings.ToLower(uuid.GoUUID)}
+	if lower.ValidateUUID() != nil {
+		// named-uuid, the name is case sensitive
+		return uuid
+	}
+	return lower
+}
+
+func normalizeSet(set libovsdb.OvsSet) libovsdb.OvsSet {
+	elements := make([]interface{}, 0, len(set.GoSet))
+	for _, e := range set.GoSet {
+		elements = append(elements, normalizeValue(e))
+	}
+	sort.SliceStable(elements, func(i, j int) bool { return libovsdb.CompareAtoms(elements[i], elements[j]) < 0 })
+	unique := elements[:0]
+	for i, e := range elements {
+		if i > 0 && libovsdb.CompareAtoms(unique[len(unique)-1], e) == 0 {
+			continue
+		}
+		unique = append(unique, e)
+	}
+	return libovsdb.OvsSet{GoSet: unique}
+}
+
+func normalizeMap(m libovsdb.OvsMap) libovsdb.OvsMap {
+	normalized := make(map[interface{}]interface{}, len(m.GoMap))
+	for k, v := range m.GoMap {
+		normalized[normalizeValue(k)] = normalizeValue(v)
+	}
+	return libovsdb.OvsMap{GoMap: normalized}
+}
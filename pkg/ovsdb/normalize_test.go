@@ -0,0 +1,48 @@
+package ovsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+func TestNormalizeRow(t *testing.T) {
+	upper := libovsdb.UUID{GoUUID: "2F77B348-9768-4866-B761-89D5177ECDA0"}
+	lower := libovsdb.UUID{GoUUID: "2f77b348-9768-4866-b761-89d5177ecda0"}
+	row := map[string]interface{}{
+		"uuid":  upper,
+		"named": libovsdb.UUID{GoUUID: "Named"},
+		"set":   libovsdb.OvsSet{GoSet: []interface{}{"b", "a", "c", "a"}},
+		"ints":  libovsdb.OvsSet{GoSet: []interface{}{10, 9.0, 1}},
+		"refs":  libovsdb.OvsSet{GoSet: []interface{}{upper, lower}},
+		"map":   libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"k2": upper, "k1": "v1"}},
+		"str":   "Value",
+	}
+	normalizeRow(&row)
+	assert.Equal(t, lower, row["uuid"])
+	assert.Equal(t, libovsdb.UUID{GoUUID: "Named"}, row["named"])
+	assert.Equal(t, libovsdb.OvsSet{GoSet: []interface{}{"a", "b", "c"}}, row["set"])
+	assert.Equal(t, libovsdb.OvsSet{GoSet: []interface{}{1, 9.0, 10}}, row["ints"])
+	assert.Equal(t, libovsdb.OvsSet{GoSet: []interface{}{lower}}, row["refs"])
+	assert.Equal(t, libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"k2": lower, "k1": "v1"}}, row["map"])
+	assert.Equal(t, "Value", row["str"])
+}
+
+func TestMakeValueCanonical(t *testing.T) {
+	row1 := map[string]interface{}{
+		"set": libovsdb.OvsSet{GoSet: []interface{}{"x", "y", "z"}},
+		"map": libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"a": 1, "b": 2, "c": 3, "d": 4}},
+	}
+	row2 := map[string]interface{}{
+		"map": libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"d": 4, "c": 3, "b": 2, "a": 1}},
+		"set": libovsdb.OvsSet{GoSet: []interface{}{"z", "x", "y", "x"}},
+	}
+	v1, err := makeValue(&row1)
+	assert.Nil(t, err)
+	v2, err := makeValue(&row2)
+	assert.Nil(t, err)
+	assert.Equal(t, v1, v2)
+	assert.Equal(t, `{"map":["map",[["a",1],["b",2],["c",3],["d",4]]],"set":["set",["x","y","z"]]}`, v1)
+}
@@ -0,0 +1,260 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+// tests against the real OVN schemas, the requests are built from their json wire format
+
+const (
+	OVN_NB_SCHEMA_FILE = "../../schemas/ovn-nb.ovsschema"
+	OVN_SB_SCHEMA_FILE = "../../schemas/ovn-sb.ovsschema"
+)
+
+func testOvnSchemas(t *testing.T) libovsdb.Schemas {
+	schemas := libovsdb.Schemas{}
+	assert.Nil(t, schemas.AddFromFile(OVN_NB_SCHEMA_FILE))
+	assert.Nil(t, schemas.AddFromFile(OVN_SB_SCHEMA_FILE))
+	return schemas
+}
+
+func testOvnTransact(t *testing.T, msg string) (*libovsdb.TransactResponse, *Transaction) {
+	var params []interface{}
+	err := json.Unmarshal([]byte(msg), &params)
+	assert.Nil(t, err)
+	req, err := libovsdb.NewTransact(params)
+	assert.Nil(t, err)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	txn := NewTransaction(cli, klogr.New(), req)
+	txn.schemas = testOvnSchemas(t)
+	txn.Commit()
+	return &txn.response, txn
+}
+
+func testOvnSelect(t *testing.T, dbName, table string, where string) []libovsdb.ResultRow {
+	resp, _ := testOvnTransact(t, `["`+dbName+`", {"op": "select", "table": "`+table+`", "where": `+where+`}]`)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, 1, len(resp.Result))
+	return *resp.Result[0].Rows
+}
+
+func TestOvnNBLogicalSwitchInsertMutate(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	resp, _ := testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Logical_Switch", "uuid-name": "ls",
+		 "row": {"name": "ls1", "external_ids": ["map", [["owner", "cms"]]], "other_config": ["map", [["subnet", "10.0.0.0/24"]]]}}]`)
+	assert.Nil(t, resp.Error)
+	assert.NotNil(t, resp.Result[0].UUID)
+
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Logical_Switch_Port", "uuid-name": "lsp",
+		 "row": {"name": "lsp1", "addresses": ["set", ["0a:00:00:00:00:01 10.0.0.1"]], "tag_request": 10, "enabled": true}},
+		{"op": "mutate", "table": "Logical_Switch", "where": [["name", "==", "ls1"]],
+		 "mutations": [["ports", "insert", ["set", [["named-uuid", "lsp"]]]]]}]`)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, 1, *resp.Result[1].Count)
+	lspUUID := resp.Result[0].UUID.GoUUID
+
+	rows := testOvnSelect(t, "OVN_Northbound", "Logical_Switch", `[["name", "==", "ls1"]]`)
+	assert.Equal(t, 1, len(rows))
+	ports, ok := rows[0]["ports"].(libovsdb.OvsSet)
+	assert.True(t, ok)
+	assert.Equal(t, []interface{}{libovsdb.UUID{GoUUID: lspUUID}}, ports.GoSet)
+}
+
+func TestOvnSBPortBindingLogicalFlow(t *testing.T) {
+	common.SetPrefix("ovsdb/sb")
+	testEtcdCleanup(t)
+	resp, _ := testOvnTransact(t, `["OVN_Southbound",
+		{"op": "insert", "table": "Datapath_Binding", "uuid-name": "dp",
+		 "row": {"tunnel_key": 1, "external_ids": ["map", [["logical-switch", "ls1"]]]}},
+		{"op": "insert", "table": "Port_Binding",
+		 "row": {"logical_port": "lsp1", "datapath": ["named-uuid", "dp"], "tunnel_key": 1, "mac": ["set", ["0a:00:00:00:00:01 10.0.0.1"]]}},
+		{"op": "insert", "table": "Logical_Flow",
+		 "row": {"logical_datapath": ["named-uuid", "dp"], "pipeline": "ingress", "table_id": 0, "priority": 100,
+		  "match": "eth.src[40]", "actions": "drop;"}}]`)
+	assert.Nil(t, resp.Error)
+	dpUUID := resp.Result[0].UUID.GoUUID
+
+	rows := testOvnSelect(t, "OVN_Southbound", "Port_Binding", `[["logical_port", "==", "lsp1"]]`)
+	assert.Equal(t, 1, len(rows))
+	assert.Equal(t, libovsdb.UUID{GoUUID: dpUUID}, rows[0]["datapath"])
+
+	resp, _ = testOvnTransact(t, `["OVN_Southbound",
+		{"op": "mutate", "table": "Port_Binding", "where": [["logical_port", "==", "lsp1"]],
+		 "mutations": [["options", "insert", ["map", [["requested-chassis", "node1"]]]]]}]`)
+	assert.Nil(t, resp.Error)
+	rows = testOvnSelect(t, "OVN_Southbound", "Port_Binding", `[["logical_port", "==", "lsp1"]]`)
+	options, ok := rows[0]["options"].(libovsdb.OvsMap)
+	assert.True(t, ok)
+	assert.Equal(t, "node1", options.GoMap["requested-chassis"])
+
+	// pipeline is an enum of ingress and egress
+	resp, _ = testOvnTransact(t, `["OVN_Southbound",
+		{"op": "insert", "table": "Logical_Flow",
+		 "row": {"pipeline": "sideways", "table_id": 0, "priority": 100, "match": "1", "actions": "next;"}}]`)
+	assert.NotNil(t, resp.Error)
+}
+
+func TestOvnTransactErrorResults(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	resp, txn := testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Address_Set", "row": {"name": "as1"}},
+		{"op": "update", "table": "Address_Set", "where": [["name", "==", "as1"]], "row": {"addresses": 1}},
+		{"op": "insert", "table": "Address_Set", "row": {"name": "as2"}}]`)
+	assert.NotNil(t, resp.Error)
+	results := resp.Results(len(txn.request.Operations))
+	assert.Equal(t, 3, len(results))
+	assert.NotNil(t, results[0].(libovsdb.OperationResult).UUID)
+	failed := results[1].(libovsdb.OperationResult)
+	if assert.NotNil(t, failed.Error) {
+		assert.Equal(t, E_CONSTRAINT_VIOLATION, *failed.Error)
+	}
+	assert.Nil(t, results[2])
+
+	// a failure of the commit follows the results of the operations
+	resp, txn = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Address_Set", "row": {"name": "as1"}},
+		{"op": "insert", "table": "Address_Set", "row": {"name": "as1"}}]`)
+	assert.NotNil(t, resp.Error)
+	results = resp.Results(len(txn.request.Operations))
+	assert.Equal(t, 3, len(results))
+	assert.NotNil(t, results[1].(libovsdb.OperationResult).UUID)
+	failed = results[2].(libovsdb.OperationResult)
+	if assert.NotNil(t, failed.Error) {
+		assert.Equal(t, E_CONSTRAINT_VIOLATION, *failed.Error)
+	}
+}
+
+func TestOvnSelectColumns(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	resp, _ := testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Logical_Switch_Port", "uuid-name": "lsp", "row": {"name": "lsp1"}},
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls1", "ports": ["set", [["named-uuid", "lsp"]]],
+		 "other_config": ["map", [["subnet", "10.0.0.0/24"]]]}},
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls2"}}]`)
+	assert.Nil(t, resp.Error)
+	lspUUID := resp.Result[0].UUID.GoUUID
+	lsUUID := resp.Result[1].UUID.GoUUID
+
+	// the rows are selected by the conditions, and projected to the requested columns
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "select", "table": "Logical_Switch", "columns": ["_uuid", "name", "ports", "other_config"],
+		 "where": [["ports", "includes", ["set", [["uuid", "`+lspUUID+`"]]]], ["name", "!=", "ls2"]]}]`)
+	assert.Nil(t, resp.Error)
+	rows := *resp.Result[0].Rows
+	if assert.Equal(t, 1, len(rows)) {
+		data, err := json.Marshal(rows[0])
+		assert.Nil(t, err)
+		assert.JSONEq(t, `{"_uuid": ["uuid", "`+lsUUID+`"], "name": "ls1", "ports": ["uuid", "`+lspUUID+`"],
+			"other_config": ["map", [["subnet", "10.0.0.0/24"]]]}`, string(data))
+	}
+
+	// the columns missing in the stored row get their default values
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	key := common.NewDataKey("OVN_Northbound", "Logical_Switch", common.GenerateUUID())
+	_, err = cli.Put(context.TODO(), key.String(), `{"name": "ls3", "_uuid": ["uuid", "`+key.UUID+`"]}`)
+	assert.Nil(t, err)
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "select", "table": "Logical_Switch", "columns": ["name", "ports"], "where": [["name", "==", "ls3"]]}]`)
+	assert.Nil(t, resp.Error)
+	rows = *resp.Result[0].Rows
+	if assert.Equal(t, 1, len(rows)) {
+		data, err := json.Marshal(rows[0])
+		assert.Nil(t, err)
+		assert.JSONEq(t, `{"name": "ls3", "ports": ["set", []]}`, string(data))
+	}
+
+	// an unknown column
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "select", "table": "Logical_Switch", "columns": ["name", "unknown"], "where": []}]`)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, E_CONSTRAINT_VIOLATION, *resp.Error)
+	}
+}
+
+type jrpcServerRecorder struct {
+	mu     sync.Mutex
+	method []string
+	params [][]byte
+}
+
+func (j *jrpcServerRecorder) Wait() error {
+	return nil
+}
+
+func (j *jrpcServerRecorder) Stop() {}
+
+func (j *jrpcServerRecorder) Notify(ctx context.Context, method string, params interface{}) error {
+	buf, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.method = append(j.method, method)
+	j.params = append(j.params, buf)
+	return nil
+}
+
+func TestOvnSBMonitorLogicalFlow(t *testing.T) {
+	common.SetPrefix("ovsdb/sb")
+	testEtcdCleanup(t)
+	schemas := testOvnSchemas(t)
+	db := DatabaseMock{Response: schemas}
+	handler := NewHandler(context.Background(), &db, nil, klogr.New())
+	recorder := &jrpcServerRecorder{}
+	handler.SetConnection(recorder, nil)
+	var params []interface{}
+	err := json.Unmarshal([]byte(`["OVN_Southbound", "lflows", {"Logical_Flow": [{"columns": ["match", "actions", "priority"]}]}]`), &params)
+	assert.Nil(t, err)
+	_, err = handler.addMonitor(params, ovsjson.Update2)
+	assert.Nil(t, err)
+	handler.startNotifier(jsonValueToString("lflows"))
+
+	resp, txn := testOvnTransact(t, `["OVN_Southbound",
+		{"op": "insert", "table": "Logical_Flow",
+		 "row": {"pipeline": "egress", "table_id": 1, "priority": 50, "match": "ip4", "actions": "next;"}},
+		{"op": "insert", "table": "Datapath_Binding", "row": {"tunnel_key": 2}}]`)
+	assert.Nil(t, resp.Error)
+	lflowUUID := resp.Result[0].UUID.GoUUID
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	handler.monitors["OVN_Southbound"].notify(txn.etcd.Events, txn.etcd.Res.Header.Revision, &wg)
+	wg.Wait()
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	assert.Equal(t, []string{UPDATE2}, recorder.method)
+	var notification []interface{}
+	err = json.Unmarshal(recorder.params[0], &notification)
+	assert.Nil(t, err)
+	assert.Equal(t, "lflows", notification[0])
+	// only the monitored table and columns are reported
+	expected := map[string]interface{}{
+		"Logical_Flow": map[string]interface{}{
+			lflowUUID: map[string]interface{}{
+				"insert": map[string]interface{}{"match": "ip4", "actions": "next;", "priority": float64(50)},
+			},
+		},
+	}
+	assert.Equal(t, expected, notification[1])
+}
@@ -0,0 +1,206 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// MaxPageSize is the maximum number of rows, which can be requested by a single page of a paged select
+var MaxPageSize = 10000
+
+// MonitorSnapshotPageSize is the maximum number of rows of a table, which are read from etcd by a single request of
+// the initial data of a monitor, so the snapshots of huge tables are neither returned by a single etcd response nor
+// kept in memory as raw key-values at once, 0 reads every table by a single request
+var MonitorSnapshotPageSize = 1000
+
+// selectPage is a page of a paged select. A paged select reads only the next page-size rows of the table, ordered by
+// their uuids, so management UIs can page through huge tables without the server materializing and serializing the
+// entire result in one response. All the pages are read at the revision of the first page, so the client sees a
+// consistent snapshot of the table, till the revision is compacted.
+type selectPage struct {
+	// index of the etcd get operation in the transaction
+	index    int
+	revision int64
+	read     bool
+	uuids    []string
+	next     *string
+}
+
+// encodePageToken returns an opaque continuation token, which encodes the snapshot revision and the uuid of the last row
+func encodePageToken(revision int64, uuid string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d/%s", revision, uuid)))
+}
+
+func decodePageToken(token string) (int64, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, "", err
+	}
+	parts := strings.SplitN(string(b), "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, "", fmt.Errorf("malformed page token")
+	}
+	revision, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || revision <= 0 {
+		return 0, "", fmt.Errorf("malformed page token")
+	}
+	return revision, parts[1], nil
+}
+
+// etcdGetPage adds the etcd get operation of the next page of a paged select
+func etcdGetPage(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.OperationResult) error {
+	if *ovsOp.PageSize <= 0 || *ovsOp.PageSize > MaxPageSize {
+		err := errors.New(E_CONSTRAINT_VIOLATION)
+		txn.log.Error(err, "wrong page size", "page-size", *ovsOp.PageSize, "max", MaxPageSize)
+		return err
+	}
+	tableKey := common.NewTableKey(txn.request.DBName, *ovsOp.Table)
+	start := tableKey.String()
+	end := clientv3.GetPrefixRangeEnd(start)
+	opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(int64(*ovsOp.PageSize))}
+	page := &selectPage{}
+	if ovsOp.PageToken != nil {
+		revision, uuid, err := decodePageToken(*ovsOp.PageToken)
+		if err != nil {
+			err := errors.New(E_CONSTRAINT_VIOLATION)
+			txn.log.Error(err, "wrong page token", "page-token", *ovsOp.PageToken)
+			return err
+		}
+		// the first key after the last row of the previous page
+		start = common.NewDataKey(txn.request.DBName, *ovsOp.Table, uuid).String() + "\x00"
+		page.revision = revision
+		opts = append(opts, clientv3.WithRev(revision))
+	}
+	page.index = len(txn.etcd.Then)
+	txn.etcd.Then = append(txn.etcd.Then, clientv3.OpGet(start, opts...))
+	if txn.pages == nil {
+		txn.pages = map[*libovsdb.OperationResult]*selectPage{}
+	}
+	txn.pages[ovsResult] = page
+	return nil
+}
+
+// setPages keeps the uuids of the rows of the pages, and the continuation tokens of the next pages
+func (txn *Transaction) setPages(res *clientv3.TxnResponse) {
+	for _, page := range txn.pages {
+		if page.read || page.index >= len(res.Responses) {
+			continue
+		}
+		page.read = true
+		if page.revision == 0 {
+			page.revision = res.Header.Revision
+		}
+		rangeResp := res.Responses[page.index].GetResponseRange()
+		if rangeResp == nil {
+			continue
+		}
+		for _, kv := range rangeResp.Kvs {
+			key, err := common.ParseKey(string(kv.Key))
+			if err != nil {
+				continue
+			}
+			page.uuids = append(page.uuids, key.UUID)
+		}
+		if rangeResp.More && len(page.uuids) > 0 {
+			token := encodePageToken(page.revision, page.uuids[len(page.uuids)-1])
+			page.next = &token
+		}
+	}
+}
+
+// doSelectPage returns the rows of the page, which are selected by the where clause. A page can contain less rows than
+// the page size, or even no rows, if some of the rows of the page are not selected, the client should request the next
+// pages as long as the result contains a continuation token.
+func doSelectPage(txn *Transaction, tableSchema *libovsdb.TableSchema, page *selectPage, ovsOp *libovsdb.Operation,
+	ovsResult *libovsdb.OperationResult) error {
+	table := txn.cache.Table(txn.request.DBName, *ovsOp.Table)
+	uuids := append([]string{}, page.uuids...)
+	sort.Strings(uuids)
+	for _, uuid := range uuids {
+		row, ok := table[uuid]
+		if !ok {
+			// the row was quarantined
+			continue
+		}
+		ok, err := txn.isRowSelectedByWhere(tableSchema, txn.mapUUID, row, ovsOp.Where)
+		if err != nil {
+			txn.log.Error(err, "failed to select row by where", "row", row, "where", ovsOp.Where)
+			return err
+		}
+		if !ok {
+			continue
+		}
+		resultRow, err := reduceRowByColumns(tableSchema, row, ovsOp.Columns)
+		if err != nil {
+			txn.log.Error(err, "failed to reduce row by columns", "row", row, "columns", ovsOp.Columns)
+			return err
+		}
+		ovsResult.AppendRows(*resultRow)
+	}
+	ovsResult.NextPageToken = page.next
+	return nil
+}
+
+// GetDataPages reads the first pages of all the keys by a single transaction, like GetData, and the next pages of every
+// key at the revision of the first pages, the key-values of a page are released after the page function returns. A non
+// positive page size reads every key by a single page. Returns rpctypes.ErrCompacted if the revision was compacted
+// before all the pages were read.
+func (con *DatabaseEtcd) GetDataPages(keys []common.Key, pageSize int, page func(kvs []*mvccpb.KeyValue)) (int64, error) {
+	if pageSize <= 0 {
+		resp, err := con.GetData(keys)
+		if err != nil {
+			return 0, err
+		}
+		txnResponsePages(resp, page)
+		return resp.Header.Revision, nil
+	}
+	resp, err := con.getData(keys, clientv3.WithPrefix(), clientv3.WithLimit(int64(pageSize)))
+	if err != nil {
+		return 0, err
+	}
+	revision := resp.Header.Revision
+	for i := range keys {
+		rangeResp := resp.Responses[i].GetResponseRange()
+		resp.Responses[i] = nil
+		kvs, more := rangeResp.Kvs, rangeResp.More
+		end := clientv3.GetPrefixRangeEnd(keys[i].String())
+		for {
+			page(kvs)
+			if !more || len(kvs) == 0 {
+				break
+			}
+			// the first key after the last key of the previous page
+			start := string(kvs[len(kvs)-1].Key) + "\x00"
+			kvs = nil
+			ctx, cancel := context.WithTimeout(context.Background(), EtcdClientTimeout)
+			getResp, err := con.cli.Get(ctx, start, clientv3.WithRange(end), clientv3.WithLimit(int64(pageSize)),
+				clientv3.WithRev(revision))
+			cancel()
+			if err != nil {
+				return 0, err
+			}
+			kvs, more = getResp.Kvs, getResp.More
+		}
+	}
+	return revision, nil
+}
+
+// txnResponsePages calls the page function with the key-values of every range response of the transaction
+func txnResponsePages(resp *clientv3.TxnResponse, page func(kvs []*mvccpb.KeyValue)) {
+	for _, r := range resp.Responses {
+		if rangeResp := r.GetResponseRange(); rangeResp != nil {
+			page(rangeResp.Kvs)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package ovsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func TestGetDataPages(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	for i := 0; i < 25; i++ {
+		_, err := cli.Put(context.Background(), common.NewDataKey(DB_NAME, "T1", fmt.Sprintf("u%03d", i)).String(), "1")
+		assert.Nil(t, err)
+	}
+	_, err = cli.Put(context.Background(), common.NewDataKey(DB_NAME, "T2", "u000").String(), "1")
+	assert.Nil(t, err)
+	// a table, whose name is prefixed by the name of the paged table
+	_, err = cli.Put(context.Background(), common.NewDataKey(DB_NAME, "T10", "u000").String(), "1")
+	assert.Nil(t, err)
+	db, err := NewDatabaseEtcd(cli)
+	assert.Nil(t, err)
+	keys := []common.Key{common.NewTableKey(DB_NAME, "T1"), common.NewTableKey(DB_NAME, "T2")}
+
+	for _, pageSize := range []int{0, 10, 25, 100} {
+		pages := []int{}
+		read := map[string]bool{}
+		revision, err := db.GetDataPages(keys, pageSize, func(kvs []*mvccpb.KeyValue) {
+			pages = append(pages, len(kvs))
+			for _, kv := range kvs {
+				assert.False(t, read[string(kv.Key)], "key %s is read twice", kv.Key)
+				read[string(kv.Key)] = true
+			}
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 26, len(read), "page size %d", pageSize)
+		assert.False(t, read[common.NewDataKey(DB_NAME, "T10", "u000").String()])
+		resp, err := cli.Get(context.Background(), "x")
+		assert.Nil(t, err)
+		assert.Equal(t, resp.Header.Revision, revision)
+		if pageSize == 10 {
+			assert.Equal(t, []int{10, 10, 5, 1}, pages)
+		}
+	}
+
+	// the next pages are read at the revision of the first pages
+	added := false
+	read := 0
+	_, err = db.GetDataPages(keys, 10, func(kvs []*mvccpb.KeyValue) {
+		read += len(kvs)
+		if !added {
+			added = true
+			_, err := cli.Put(context.Background(), common.NewDataKey(DB_NAME, "T1", "u100").String(), "1")
+			assert.Nil(t, err)
+			_, err = cli.Delete(context.Background(), common.NewDataKey(DB_NAME, "T1", "u020").String())
+			assert.Nil(t, err)
+		}
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 26, read)
+
+	// the compacted revision of the first pages fails the read
+	compacted := false
+	_, err = db.GetDataPages(keys, 10, func(kvs []*mvccpb.KeyValue) {
+		if !compacted {
+			compacted = true
+			_, err := cli.Put(context.Background(), common.NewDataKey(DB_NAME, "T1", "u101").String(), "1")
+			assert.Nil(t, err)
+			resp, err := cli.Get(context.Background(), "x")
+			assert.Nil(t, err)
+			_, err = cli.Compact(context.Background(), resp.Header.Revision)
+			assert.Nil(t, err)
+		}
+	})
+	assert.True(t, errors.Is(err, rpctypes.ErrCompacted), "%v", err)
+}
@@ -0,0 +1,99 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+// PayloadCacheRevisions is the number of the most recent etcd revisions, whose serialized notifications are kept
+var PayloadCacheRevisions int64 = 16
+
+// payloadCache shares serialized table updates between monitors with identical requests. Every handler prepares the
+// updates of the same etcd revision independently, the first one that sends them encodes the payload, and the other
+// handlers reuse the encoded bytes.
+type payloadCache struct {
+	mu          sync.Mutex
+	maxRevision int64
+	// revision -> monitor request key -> payload
+	entries map[int64]map[string]*cachedPayload
+}
+
+type cachedPayload struct {
+	once sync.Once
+	data json.RawMessage
+	err  error
+}
+
+var sharedPayloads = &payloadCache{entries: map[int64]map[string]*cachedPayload{}}
+
+// get returns the serialized updates for the given monitor request and revision, updates are encoded only if they
+// are not in the cache.
+func (pc *payloadCache) get(requestKey string, revision int64, updates ovsjson.TableUpdates) (json.RawMessage, error) {
+	pc.mu.Lock()
+	if revision > pc.maxRevision {
+		pc.maxRevision = revision
+		for rev := range pc.entries {
+			if rev <= revision-PayloadCacheRevisions {
+				delete(pc.entries, rev)
+			}
+		}
+	}
+	if revision <= pc.maxRevision-PayloadCacheRevisions {
+		// too old to be shared
+		pc.mu.Unlock()
+		return json.Marshal(updates)
+	}
+	revEntries, ok := pc.entries[revision]
+	if !ok {
+		revEntries = map[string]*cachedPayload{}
+		pc.entries[revision] = revEntries
+	}
+	entry, ok := revEntries[requestKey]
+	if !ok {
+		entry = &cachedPayload{}
+		revEntries[requestKey] = entry
+	}
+	pc.mu.Unlock()
+	entry.once.Do(func() {
+		entry.data, entry.err = json.Marshal(updates)
+	})
+	return entry.data, entry.err
+}
+
+// monitorRequestKey returns a key, which is equal for monitors that produce identical notifications: the same
+// database, notification type, effective monitor requests and redacted columns.
+func monitorRequestKey(dbName string, notificationType ovsjson.UpdateNotificationType, updatersMap Key2Updaters) (string, error) {
+	requests := map[string][]ovsjson.MonitorCondRequest{}
+	redacted := map[string][]string{}
+	// the updates of the earlier revisions depend on the rows of the conditions change snapshot
+	snapshots := map[string]int64{}
+	for key, updaters := range updatersMap {
+		for _, u := range updaters {
+			if len(u.redacted) > 0 {
+				redacted[key.TableName] = redactedColumnsList(u.redacted)
+			}
+			if len(u.snapshotRows) > 0 {
+				snapshots[key.TableName] = u.since
+			}
+			mcr := u.mcr
+			if mcr.Columns != nil {
+				columns := append([]string{}, mcr.Columns...)
+				sort.Strings(columns)
+				mcr.Columns = columns
+			}
+			requests[key.TableName] = append(requests[key.TableName], mcr)
+		}
+	}
+	key := []interface{}{dbName, notificationType, requests, redacted}
+	if len(snapshots) > 0 {
+		key = append(key, snapshots)
+	}
+	buf, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
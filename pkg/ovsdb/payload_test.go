@@ -0,0 +1,60 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func TestPayloadCacheShared(t *testing.T) {
+	pc := &payloadCache{entries: map[int64]map[string]*cachedPayload{}}
+	row := map[string]interface{}{"c1": "v1"}
+	updates := ovsjson.TableUpdates{"T1": {ROW_UUID: {Insert: &row}}}
+
+	p1, err := pc.get("key", 10, updates)
+	assert.Nil(t, err)
+	expected, _ := json.Marshal(updates)
+	assert.Equal(t, json.RawMessage(expected), p1)
+	// the second request reuses the encoded payload, even if the updates were prepared separately
+	p2, err := pc.get("key", 10, ovsjson.TableUpdates{})
+	assert.Nil(t, err)
+	assert.Equal(t, &p1[0], &p2[0])
+
+	p3, err := pc.get("other-key", 10, ovsjson.TableUpdates{})
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`{}`), p3)
+
+	// old revisions are evicted
+	_, err = pc.get("key", 10+PayloadCacheRevisions, updates)
+	assert.Nil(t, err)
+	_, ok := pc.entries[10]
+	assert.False(t, ok)
+	p4, err := pc.get("key", 10, ovsjson.TableUpdates{})
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`{}`), p4)
+}
+
+func TestMonitorRequestKey(t *testing.T) {
+	tableSchema := &libovsdb.TableSchema{}
+	key := common.NewTableKey(DB_NAME, "T1")
+	u1 := mcrToUpdater(ovsjson.MonitorCondRequest{Columns: []string{"c1", "c2"}}, "mon1", tableSchema, false)
+	u2 := mcrToUpdater(ovsjson.MonitorCondRequest{Columns: []string{"c2", "c1"}}, "mon2", tableSchema, false)
+	k1, err := monitorRequestKey(DB_NAME, ovsjson.Update2, Key2Updaters{key: {*u1}})
+	assert.Nil(t, err)
+	k2, err := monitorRequestKey(DB_NAME, ovsjson.Update2, Key2Updaters{key: {*u2}})
+	assert.Nil(t, err)
+	assert.Equal(t, k1, k2)
+
+	k3, err := monitorRequestKey(DB_NAME, ovsjson.Update3, Key2Updaters{key: {*u2}})
+	assert.Nil(t, err)
+	assert.NotEqual(t, k1, k3)
+	u3 := mcrToUpdater(ovsjson.MonitorCondRequest{Columns: []string{"c1"}}, "mon3", tableSchema, false)
+	k4, err := monitorRequestKey(DB_NAME, ovsjson.Update2, Key2Updaters{key: {*u3}})
+	assert.Nil(t, err)
+	assert.NotEqual(t, k1, k4)
+}
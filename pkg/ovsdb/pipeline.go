@@ -0,0 +1,194 @@
+package ovsdb
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// ConcurrentTransactions executes the write transactions of a database, which modify disjoint tables, concurrently,
+// instead of serializing all the transactions of the database on the server. The transactions of the same tables are
+// still serialized, so they don't fail each other. The concurrent transactions are optimistic, like the transactions
+// of different server replicas: the rows they write are guarded by the revisions they were read at, and a
+// transaction, which conflicts with another one, e.g. by the references between the tables, is executed again.
+var ConcurrentTransactions = false
+
+// txnPipeline admits the transactions of a database, and orders the monitor notifications of the commits of a client by
+// the commit revisions, so a notification of a later commit does not supersede the notification of an earlier commit,
+// which finished later
+type txnPipeline struct {
+	// excludes the transactions of the database, e.g. while it's frozen or converted
+	db *sync.RWMutex
+
+	mu sync.Mutex
+	// signaled when a ticket is committed or done
+	cond *sync.Cond
+	// table -> the lock of the transactions modifying the table
+	tables map[string]*sync.Mutex
+	// the number of the admitted transactions
+	seq      int64
+	inFlight map[*txnTicket]bool
+}
+
+func newTxnPipeline(db *sync.RWMutex) *txnPipeline {
+	p := &txnPipeline{db: db, tables: map[string]*sync.Mutex{}, inFlight: map[*txnTicket]bool{}}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// txnTicket is an admitted transaction of the pipeline
+type txnTicket struct {
+	pipeline *txnPipeline
+	// the handler of the client, whose monitors are notified of the commit
+	owner *Handler
+	seq   int64
+	// releases the database and the tables locks
+	unlock func()
+	// the commit returned, the revision is of the commit, negative if it failed
+	returned bool
+	revision int64
+	// the transactions admitted before the commit returned may have committed before it
+	committedSeq int64
+}
+
+// begin admits a transaction of the tables, it waits for the transactions of the tables, or for all the transactions
+// of the database if they aren't concurrent
+func (p *txnPipeline) begin(tables []string, owner *Handler) *txnTicket {
+	var unlock func()
+	if ConcurrentTransactions {
+		p.db.RLock()
+		locks := make([]*sync.Mutex, 0, len(tables))
+		// the tables are sorted, so the transactions lock them in the same order
+		for _, table := range tables {
+			lock := p.tableLock(table)
+			lock.Lock()
+			locks = append(locks, lock)
+		}
+		unlock = func() {
+			for i := len(locks) - 1; i >= 0; i-- {
+				locks[i].Unlock()
+			}
+			p.db.RUnlock()
+		}
+	} else {
+		p.db.Lock()
+		unlock = p.db.Unlock
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seq++
+	t := &txnTicket{pipeline: p, owner: owner, seq: p.seq, unlock: unlock}
+	p.inFlight[t] = true
+	return t
+}
+
+func (p *txnPipeline) tableLock(table string) *sync.Mutex {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lock, ok := p.tables[table]
+	if !ok {
+		lock = &sync.Mutex{}
+		p.tables[table] = lock
+	}
+	return lock
+}
+
+// committed records the revision of the commit, negative if it failed, and releases the locks of the transaction
+func (t *txnTicket) committed(revision int64) {
+	if t == nil || t.unlock == nil {
+		return
+	}
+	p := t.pipeline
+	p.mu.Lock()
+	t.returned = true
+	t.revision = revision
+	t.committedSeq = p.seq
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	t.unlock()
+	t.unlock = nil
+}
+
+// waitTurn waits till the transactions of the client, which committed before the transaction, are done. The
+// transactions admitted after its commit returned cannot commit before it, and aren't waited for.
+func (t *txnTicket) waitTurn() {
+	if t == nil {
+		return
+	}
+	p := t.pipeline
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.precedes(t) {
+		p.cond.Wait()
+	}
+}
+
+// precedes returns whether a transaction of the client, which may have committed before the transaction, is not done
+func (p *txnPipeline) precedes(t *txnTicket) bool {
+	for u := range p.inFlight {
+		if u == t || u.owner != t.owner || u.seq > t.committedSeq {
+			continue
+		}
+		if !u.returned || (u.revision > 0 && u.revision < t.revision) {
+			return true
+		}
+	}
+	return false
+}
+
+// done removes the transaction from the pipeline, after its monitors were notified
+func (t *txnTicket) done() {
+	if t == nil {
+		return
+	}
+	t.committed(-1)
+	p := t.pipeline
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inFlight[t] {
+		delete(p.inFlight, t)
+		p.cond.Broadcast()
+	}
+}
+
+// transactionTables returns the sorted tables of the operations of a write transaction, the read only transactions
+// don't lock tables
+func transactionTables(request *libovsdb.Transact) []string {
+	if isReadOnlyTransaction(request) {
+		return nil
+	}
+	set := map[string]bool{}
+	for _, ovsOp := range request.Operations {
+		if ovsOp.Table != nil {
+			set[*ovsOp.Table] = true
+		}
+	}
+	tables := make([]string, 0, len(set))
+	for table := range set {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// BeginTransaction admits a transaction of the database, which modifies the tables, see txnPipeline. Returns nil for
+// an unknown database, whose transactions fail.
+func (con *DatabaseEtcd) BeginTransaction(dbName string, tables []string, owner *Handler) *txnTicket {
+	con.mu.Lock()
+	p, ok := con.pipelines[dbName]
+	if !ok {
+		dbLock, ok := con.locks[dbName]
+		if !ok {
+			con.mu.Unlock()
+			return nil
+		}
+		if con.pipelines == nil {
+			con.pipelines = map[string]*txnPipeline{}
+		}
+		p = newTxnPipeline(dbLock)
+		con.pipelines[dbName] = p
+	}
+	con.mu.Unlock()
+	return p.begin(tables, owner)
+}
@@ -0,0 +1,185 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2/metrics"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// testAdmitted returns a channel, which is closed when the function returns
+func testAdmitted(f func()) chan struct{} {
+	admitted := make(chan struct{})
+	go func() {
+		f()
+		close(admitted)
+	}()
+	return admitted
+}
+
+func testIsAdmitted(admitted chan struct{}) bool {
+	select {
+	case <-admitted:
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+func TestTxnPipelineAdmission(t *testing.T) {
+	defer func(concurrent bool) { ConcurrentTransactions = concurrent }(ConcurrentTransactions)
+	owner := &Handler{}
+
+	// the transactions are serialized by default
+	ConcurrentTransactions = false
+	p := newTxnPipeline(&sync.RWMutex{})
+	t1 := p.begin([]string{"A"}, owner)
+	var t2 *txnTicket
+	admitted := testAdmitted(func() { t2 = p.begin([]string{"B"}, owner) })
+	assert.False(t, testIsAdmitted(admitted))
+	t1.committed(10)
+	assert.True(t, testIsAdmitted(admitted))
+	t2.committed(11)
+	t1.done()
+	t2.done()
+
+	// the transactions of disjoint tables are concurrent
+	ConcurrentTransactions = true
+	t1 = p.begin([]string{"A", "B"}, owner)
+	admitted = testAdmitted(func() { t2 = p.begin([]string{"C"}, owner) })
+	assert.True(t, testIsAdmitted(admitted))
+	var t3 *txnTicket
+	admitted = testAdmitted(func() { t3 = p.begin([]string{"B", "D"}, owner) })
+	assert.False(t, testIsAdmitted(admitted))
+	t1.committed(12)
+	assert.True(t, testIsAdmitted(admitted))
+
+	// the database lock excludes the concurrent transactions
+	locked := testAdmitted(p.db.Lock)
+	assert.False(t, testIsAdmitted(locked))
+	t2.committed(13)
+	t3.committed(14)
+	assert.True(t, testIsAdmitted(locked))
+	admitted = testAdmitted(func() { p.begin(nil, owner).done() })
+	assert.False(t, testIsAdmitted(admitted))
+	p.db.Unlock()
+	assert.True(t, testIsAdmitted(admitted))
+	for _, ticket := range []*txnTicket{t1, t2, t3} {
+		ticket.done()
+	}
+	assert.Empty(t, p.inFlight)
+}
+
+func TestTxnPipelineNotificationsOrder(t *testing.T) {
+	defer func(concurrent bool) { ConcurrentTransactions = concurrent }(ConcurrentTransactions)
+	ConcurrentTransactions = true
+	owner := &Handler{}
+	p := newTxnPipeline(&sync.RWMutex{})
+
+	// the commit, which returned first, waits for the earlier commit of the client
+	t1 := p.begin([]string{"A"}, owner)
+	t2 := p.begin([]string{"B"}, owner)
+	other := p.begin([]string{"C"}, &Handler{})
+	t2.committed(11)
+	turn := testAdmitted(t2.waitTurn)
+	assert.False(t, testIsAdmitted(turn))
+	t1.committed(10)
+	assert.False(t, testIsAdmitted(turn))
+	t1.done()
+	// the transactions of other clients are not waited for
+	assert.True(t, testIsAdmitted(turn))
+	t2.done()
+
+	// the later commits, and the failed ones, are not waited for after they returned
+	t1 = p.begin([]string{"A"}, owner)
+	t2 = p.begin([]string{"B"}, owner)
+	t3 := p.begin([]string{"D"}, owner)
+	t2.committed(20)
+	t1.committed(21)
+	t3.committed(-1)
+	assert.True(t, testIsAdmitted(testAdmitted(t2.waitTurn)))
+	turn = testAdmitted(t1.waitTurn)
+	assert.False(t, testIsAdmitted(turn))
+	t2.done()
+	assert.True(t, testIsAdmitted(turn))
+
+	// the transactions admitted after the commit returned are not waited for
+	t4 := p.begin([]string{"A"}, owner)
+	assert.True(t, testIsAdmitted(testAdmitted(t1.waitTurn)))
+	for _, ticket := range []*txnTicket{t1, t3, t4, other} {
+		ticket.done()
+	}
+	assert.Empty(t, p.inFlight)
+}
+
+func TestConcurrentTransactions(t *testing.T) {
+	defer func(concurrent bool) { ConcurrentTransactions = concurrent }(ConcurrentTransactions)
+	ConcurrentTransactions = true
+	defer SetMetrics(nil)
+	m := metrics.New()
+	SetMetrics(m)
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	db := &DatabaseEtcd{cli: cli, locks: map[string]*sync.RWMutex{"rbac": {}}}
+
+	transact := func(operations string) error {
+		var ops []libovsdb.Operation
+		assert.Nil(t, json.Unmarshal([]byte(operations), &ops))
+		request := &libovsdb.Transact{DBName: "rbac", Operations: ops}
+		ticket := db.BeginTransaction("rbac", transactionTables(request), nil)
+		defer ticket.done()
+		txn := NewTransaction(cli, klogr.New(), request)
+		txn.AddSchema(testSchemaRBAC(t))
+		rev, err := txn.Commit()
+		ticket.committed(rev)
+		return err
+	}
+	assert.Nil(t, transact(`[{"op": "insert", "table": "Chassis", "row": {"name": "ch"}}]`))
+
+	// the transactions of the same row are serialized, the transactions of other tables run concurrently with them
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, transact(`[{"op": "mutate", "table": "Chassis", "where": [["name", "==", "ch"]],
+				"mutations": [["external_ids", "insert", ["map", [["k`+fmt.Sprint(i)+`", "v"]]]]]}]`))
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, transact(`[{"op": "insert", "table": "RBAC_Role", "row": {"name": "role`+fmt.Sprint(i)+`"}}]`))
+		}(i)
+	}
+	wg.Wait()
+	resp, err := cli.Get(context.Background(), common.NewTableKey("rbac", "Chassis").String(), clientv3.WithPrefix())
+	if assert.Nil(t, err) && assert.Len(t, resp.Kvs, 1) {
+		row, err := unmarshalData(resp.Kvs[0].Value)
+		assert.Nil(t, err)
+		assert.Len(t, row["external_ids"].([]interface{})[1], 10)
+	}
+	resp, err = cli.Get(context.Background(), common.NewTableKey("rbac", "RBAC_Role").String(), clientv3.WithPrefix(),
+		clientv3.WithCountOnly())
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(10), resp.Count)
+	}
+	snap := metrics.Snapshot{Counter: map[string]int64{}, Label: map[string]interface{}{}}
+	m.Snapshot(snap)
+	assert.Equal(t, int64(21), snap.Counter[METRIC_TRANSACT_EXECUTIONS_PREFIX+"rbac"]-
+		snap.Counter[METRIC_TRANSACT_RETRIES_PREFIX+"rbac"])
+	assert.Zero(t, snap.Counter[METRIC_TRANSACT_CONFLICTS_PREFIX+"rbac"])
+}
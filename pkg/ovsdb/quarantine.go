@@ -0,0 +1,114 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/creachadair/jrpc2/metrics"
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+const (
+	METRIC_QUARANTINE_ADDED = "rows.quarantine.added"
+	METRIC_QUARANTINE_SIZE  = "rows.quarantine.size"
+)
+
+// QuarantinedRow is a stored row, that cannot be unmarshaled or validated against the schema, e.g. because of
+// corruption or a schema version skew. Quarantined rows are skipped by select and monitors, instead of failing the
+// whole request.
+type QuarantinedRow struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+	// the etcd mod revision of the row, 0 if unknown
+	ModRevision int64     `json:"mod-revision,omitempty"`
+	Since       time.Time `json:"since"`
+}
+
+type Quarantine struct {
+	log     logr.Logger
+	metrics *metrics.M
+	mu      sync.Mutex
+	rows    map[string]QuarantinedRow
+}
+
+func NewQuarantine(log logr.Logger) *Quarantine {
+	return &Quarantine{log: log.WithName("quarantine"), rows: map[string]QuarantinedRow{}}
+}
+
+// quarantine is the quarantine of the server
+var quarantine = NewQuarantine(klogr.New())
+
+// SetMetrics sets the metrics, which count the monitor events errors, the quarantined rows, the monitors, the
+// notifications and the requests latency, should be called before the server starts serving.
+func SetMetrics(m *metrics.M) {
+	serverMetrics = m
+	eventLog.metrics = m
+	quarantine.metrics = m
+}
+
+// Add quarantines the row, the row is logged only when it is quarantined the first time.
+func (q *Quarantine) Add(key string, modRevision int64, reason error) {
+	q.mu.Lock()
+	row, ok := q.rows[key]
+	if ok && row.ModRevision == modRevision {
+		q.mu.Unlock()
+		return
+	}
+	q.rows[key] = QuarantinedRow{Key: key, Reason: reason.Error(), ModRevision: modRevision, Since: time.Now()}
+	size := len(q.rows)
+	q.mu.Unlock()
+	q.log.Error(reason, "row quarantined", "key", key, "mod-revision", modRevision)
+	q.metrics.Count(METRIC_QUARANTINE_ADDED, 1)
+	q.metrics.SetLabel(METRIC_QUARANTINE_SIZE, size)
+}
+
+func (q *Quarantine) Remove(key string) bool {
+	q.mu.Lock()
+	_, ok := q.rows[key]
+	delete(q.rows, key)
+	size := len(q.rows)
+	q.mu.Unlock()
+	q.metrics.SetLabel(METRIC_QUARANTINE_SIZE, size)
+	return ok
+}
+
+func (q *Quarantine) Get(key string) (QuarantinedRow, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	row, ok := q.rows[key]
+	return row, ok
+}
+
+// List returns the quarantined rows ordered by their keys
+func (q *Quarantine) List() []QuarantinedRow {
+	q.mu.Lock()
+	rows := make([]QuarantinedRow, 0, len(q.rows))
+	for _, row := range q.rows {
+		rows = append(rows, row)
+	}
+	q.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Key < rows[j].Key })
+	return rows
+}
+
+// validateStoredRow checks that the stored value of the given key can be unmarshaled and validated against the schema
+func validateStoredRow(schemas libovsdb.Schemas, key *common.Key, value []byte) error {
+	row := map[string]interface{}{}
+	if err := json.Unmarshal(value, &row); err != nil {
+		return err
+	}
+	if err := schemas.Unmarshal(key.DBName, key.TableName, &row); err != nil {
+		return err
+	}
+	if err := schemas.Validate(key.DBName, key.TableName, &row); err != nil {
+		return fmt.Errorf("validation failed: %v", err)
+	}
+	return nil
+}
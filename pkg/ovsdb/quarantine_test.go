@@ -0,0 +1,98 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+func TestQuarantineAddRemove(t *testing.T) {
+	q := NewQuarantine(klogr.New())
+	q.Add("ovsdb/nb/db/table/2", 3, fmt.Errorf("bad row"))
+	q.Add("ovsdb/nb/db/table/1", 5, fmt.Errorf("bad row"))
+	first, ok := q.Get("ovsdb/nb/db/table/1")
+	assert.True(t, ok)
+	// the same revision is not quarantined again
+	q.Add("ovsdb/nb/db/table/1", 5, fmt.Errorf("bad row"))
+	row, _ := q.Get("ovsdb/nb/db/table/1")
+	assert.Equal(t, first.Since, row.Since)
+
+	rows := q.List()
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, "ovsdb/nb/db/table/1", rows[0].Key)
+	assert.Equal(t, "bad row", rows[0].Reason)
+	assert.Equal(t, int64(5), rows[0].ModRevision)
+
+	assert.True(t, q.Remove("ovsdb/nb/db/table/1"))
+	assert.False(t, q.Remove("ovsdb/nb/db/table/1"))
+	assert.Equal(t, 1, len(q.List()))
+}
+
+func TestTransactSelectQuarantine(t *testing.T) {
+	table := "table1"
+	req := &libovsdb.Transact{
+		DBName:     "simple",
+		Operations: []libovsdb.Operation{{Op: OP_SELECT, Table: &table}},
+	}
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	testEtcdPut(t, "simple", table, map[string]interface{}{"key1": "val1", "key2": int(3)})
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	corrupted := common.GenerateDataKey("simple", table)
+	_, err = cli.Put(context.TODO(), corrupted.String(), "{corrupted")
+	assert.Nil(t, err)
+	invalid := common.GenerateDataKey("simple", table)
+	_, err = cli.Put(context.TODO(), invalid.String(), `{"key1": 5, "_uuid": ["uuid", "`+invalid.UUID+`"]}`)
+	assert.Nil(t, err)
+
+	resp, _ := testTransact(t, req)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, 1, len(resp.Result))
+	assert.Equal(t, 1, len(*resp.Result[0].Rows))
+	assert.Equal(t, "val1", (*resp.Result[0].Rows)[0]["key1"])
+	_, ok := quarantine.Get(corrupted.String())
+	assert.True(t, ok)
+	_, ok = quarantine.Get(invalid.String())
+	assert.True(t, ok)
+
+	db, _ := NewDatabaseEtcd(cli)
+	db.(*DatabaseEtcd).Schemas.Add(testSchemaSimple)
+	admin := NewAdmin(db, klogr.New())
+	ctx := context.Background()
+
+	// the row is still invalid
+	_, err = admin.Repair(ctx, []interface{}{invalid.String(), "release"})
+	assert.NotNil(t, err)
+	resp2, err := admin.Repair(ctx, []interface{}{invalid.String(), "delete"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"deleted": true, "released": false}, resp2)
+	get, err := cli.Get(ctx, invalid.String())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), get.Count)
+
+	// the row was fixed manually
+	_, err = cli.Put(ctx, corrupted.String(), `{"key1": "val2", "_uuid": ["uuid", "`+corrupted.UUID+`"]}`)
+	assert.Nil(t, err)
+	_, err = admin.Repair(ctx, []interface{}{corrupted.String(), "delete"})
+	assert.NotNil(t, err)
+	resp2, err = admin.Repair(ctx, []interface{}{corrupted.String(), "release"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"deleted": false, "released": true}, resp2)
+
+	list, err := admin.Quarantine(ctx, []interface{}{})
+	assert.Nil(t, err)
+	for _, row := range list.([]QuarantinedRow) {
+		assert.NotEqual(t, corrupted.String(), row.Key)
+		assert.NotEqual(t, invalid.String(), row.Key)
+	}
+	_, err = admin.Repair(ctx, []interface{}{corrupted.String(), "release"})
+	assert.NotNil(t, err)
+}
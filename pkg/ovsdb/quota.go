@@ -0,0 +1,99 @@
+package ovsdb
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	QUOTA_MONITORS = "monitors"
+	QUOTA_LOCKS    = "locks"
+)
+
+// ResourceQuota limits the number of monitors and locks a client can hold, so a buggy client, which registers monitors
+// or locks in a loop, can't exhaust the server memory or the etcd watches. The limits are applied per connection and
+// per client identity, the identity limits are shared by all the connections of the identity. Zero means no limit.
+type ResourceQuota struct {
+	MaxMonitorsPerConnection int
+	MaxLocksPerConnection    int
+	MaxMonitorsPerIdentity   int
+	MaxLocksPerIdentity      int
+
+	mu sync.Mutex
+	// resource kind -> identity name -> number of the held resources
+	used map[string]map[string]int
+}
+
+func NewResourceQuota(maxMonitors, maxLocks, maxIdentityMonitors, maxIdentityLocks int) *ResourceQuota {
+	return &ResourceQuota{
+		MaxMonitorsPerConnection: maxMonitors,
+		MaxLocksPerConnection:    maxLocks,
+		MaxMonitorsPerIdentity:   maxIdentityMonitors,
+		MaxLocksPerIdentity:      maxIdentityLocks,
+		used:                     map[string]map[string]int{QUOTA_MONITORS: {}, QUOTA_LOCKS: {}},
+	}
+}
+
+func (q *ResourceQuota) limits(kind string) (int, int) {
+	if kind == QUOTA_MONITORS {
+		return q.MaxMonitorsPerConnection, q.MaxMonitorsPerIdentity
+	}
+	return q.MaxLocksPerConnection, q.MaxLocksPerIdentity
+}
+
+// acquire accounts a new resource of the identity, connectionUsed is the number of the resources of the same kind,
+// which are already held by the connection. Returns the E_RESOURCES_EXHAUSTED error if one of the limits is reached.
+func (q *ResourceQuota) acquire(kind string, identity string, connectionUsed int) error {
+	if q == nil {
+		return nil
+	}
+	maxConnection, maxIdentity := q.limits(kind)
+	if maxConnection > 0 && connectionUsed >= maxConnection {
+		return errors.New(E_RESOURCES_EXHAUSTED)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if maxIdentity > 0 && q.used[kind][identity] >= maxIdentity {
+		return errors.New(E_RESOURCES_EXHAUSTED)
+	}
+	q.used[kind][identity]++
+	return nil
+}
+
+func (q *ResourceQuota) release(kind string, identity string, n int) {
+	if q == nil || n == 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.used[kind][identity] -= n
+	if q.used[kind][identity] <= 0 {
+		delete(q.used[kind], identity)
+	}
+}
+
+// transfer moves the resources held by a connection, whose identity was changed
+func (q *ResourceQuota) transfer(from, to string, monitors, locks int) {
+	if q == nil || from == to {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for kind, n := range map[string]int{QUOTA_MONITORS: monitors, QUOTA_LOCKS: locks} {
+		if n == 0 {
+			continue
+		}
+		q.used[kind][from] -= n
+		if q.used[kind][from] <= 0 {
+			delete(q.used[kind], from)
+		}
+		q.used[kind][to] += n
+	}
+}
+
+// Used returns the number of the resources of the given kind held by all the connections of the identity
+func (q *ResourceQuota) Used(kind string, identity string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.used[kind][identity]
+}
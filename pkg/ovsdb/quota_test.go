@@ -0,0 +1,80 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/hints"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func TestResourceQuota(t *testing.T) {
+	q := NewResourceQuota(2, 0, 3, 1)
+	assert.Nil(t, q.acquire(QUOTA_MONITORS, "c1", 0))
+	assert.Nil(t, q.acquire(QUOTA_MONITORS, "c1", 1))
+	// connection limit
+	assert.EqualError(t, q.acquire(QUOTA_MONITORS, "c1", 2), E_RESOURCES_EXHAUSTED)
+	// another connection of the same identity
+	assert.Nil(t, q.acquire(QUOTA_MONITORS, "c1", 0))
+	assert.EqualError(t, q.acquire(QUOTA_MONITORS, "c1", 1), E_RESOURCES_EXHAUSTED)
+	assert.Nil(t, q.acquire(QUOTA_MONITORS, "c2", 0))
+	assert.Equal(t, 3, q.Used(QUOTA_MONITORS, "c1"))
+	q.release(QUOTA_MONITORS, "c1", 2)
+	assert.Equal(t, 1, q.Used(QUOTA_MONITORS, "c1"))
+
+	assert.Nil(t, q.acquire(QUOTA_LOCKS, "c1", 10))
+	assert.EqualError(t, q.acquire(QUOTA_LOCKS, "c1", 0), E_RESOURCES_EXHAUSTED)
+	q.transfer("c1", "c3", 1, 1)
+	assert.Equal(t, 0, q.Used(QUOTA_LOCKS, "c1"))
+	assert.Equal(t, 1, q.Used(QUOTA_LOCKS, "c3"))
+	assert.Equal(t, 1, q.Used(QUOTA_MONITORS, "c3"))
+
+	var noQuota *ResourceQuota
+	assert.Nil(t, noQuota.acquire(QUOTA_MONITORS, "c1", 100))
+	noQuota.release(QUOTA_MONITORS, "c1", 1)
+}
+
+func TestMonitorQuota(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {}},
+	}}
+	db := DatabaseMock{Response: schemas}
+	quota := NewResourceQuota(1, 0, 0, 0)
+	handler := NewHandler(context.Background(), &db, nil, klogr.New())
+	handler.SetQuota(quota)
+
+	addMonitor := func(id string) error {
+		var params []interface{}
+		err := json.Unmarshal([]byte(`["dbName", "`+id+`", {"T1": [{}]}]`), &params)
+		assert.Nil(t, err)
+		_, err = handler.addMonitor(params, ovsjson.Update2)
+		return err
+	}
+	assert.Nil(t, addMonitor("mon1"))
+	err := addMonitor("mon2")
+	assert.NotNil(t, err)
+	hint, ok := hints.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, hints.REASON_QUOTA_EXCEEDED, hint.Reason)
+	assert.Equal(t, E_RESOURCES_EXHAUSTED, err.(*jrpc2.Error).Message())
+	assert.Equal(t, 1, quota.Used(QUOTA_MONITORS, ANONYMOUS_IDENTITY))
+
+	handler.mu.Lock()
+	handler.setIdentity(&Identity{Name: "client"})
+	handler.mu.Unlock()
+	assert.Equal(t, 0, quota.Used(QUOTA_MONITORS, ANONYMOUS_IDENTITY))
+	assert.Equal(t, 1, quota.Used(QUOTA_MONITORS, "client"))
+
+	assert.Nil(t, handler.removeMonitor("mon1", ""))
+	assert.Equal(t, 0, quota.Used(QUOTA_MONITORS, "client"))
+	assert.Nil(t, addMonitor("mon2"))
+}
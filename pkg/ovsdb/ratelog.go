@@ -0,0 +1,79 @@
+package ovsdb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/creachadair/jrpc2/metrics"
+	"github.com/go-logr/logr"
+)
+
+// kinds of the rate limited monitor events log records
+const (
+	EVENT_LOG_PARSE_KEY_ERROR  = "parse-key-error"
+	EVENT_LOG_NO_UPDATERS      = "no-updaters"
+	EVENT_LOG_ROW_UPDATE_ERROR = "row-update-error"
+	EVENT_LOG_NO_ROW_UPDATE    = "no-row-update"
+	EVENT_LOG_DUPLICATE_EVENT  = "duplicate-event"
+
+	// the counters are "monitor.events.<kind>"
+	METRIC_EVENT_LOG_PREFIX = "monitor.events."
+)
+
+// EventLogInterval is the minimal interval between two log records of the same kind and table
+var EventLogInterval = 10 * time.Second
+
+// eventLog aggregates the monitor events log records of the hot path
+var eventLog = newRateLimitedLog()
+
+// rateLimitedLog writes at most one record per kind and key during EventLogInterval, the number of the suppressed
+// records is reported by the next written record.
+type rateLimitedLog struct {
+	mu      sync.Mutex
+	metrics *metrics.M
+	entries map[string]*rateLimitedEntry
+}
+
+type rateLimitedEntry struct {
+	last       time.Time
+	suppressed int64
+}
+
+func newRateLimitedLog() *rateLimitedLog {
+	return &rateLimitedLog{entries: map[string]*rateLimitedEntry{}}
+}
+
+// allow counts the record, and returns true with the number of the suppressed records since the last written one,
+// if the record should be written.
+func (rl *rateLimitedLog) allow(kind, key string) (bool, int64) {
+	rl.metrics.Count(METRIC_EVENT_LOG_PREFIX+kind, 1)
+	now := time.Now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	entryKey := kind + "/" + key
+	entry, ok := rl.entries[entryKey]
+	if !ok {
+		rl.entries[entryKey] = &rateLimitedEntry{last: now}
+		return true, 0
+	}
+	if now.Sub(entry.last) < EventLogInterval {
+		entry.suppressed++
+		return false, 0
+	}
+	suppressed := entry.suppressed
+	entry.last = now
+	entry.suppressed = 0
+	return true, suppressed
+}
+
+func (rl *rateLimitedLog) Error(log logr.Logger, err error, kind, key, msg string, keysAndValues ...interface{}) {
+	if ok, suppressed := rl.allow(kind, key); ok {
+		log.Error(err, msg, append(keysAndValues, "kind", kind, "suppressed", suppressed)...)
+	}
+}
+
+func (rl *rateLimitedLog) Info(log logr.Logger, kind, key, msg string, keysAndValues ...interface{}) {
+	if ok, suppressed := rl.allow(kind, key); ok {
+		log.Info(msg, append(keysAndValues, "kind", kind, "suppressed", suppressed)...)
+	}
+}
@@ -0,0 +1,40 @@
+package ovsdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitedLog(t *testing.T) {
+	interval := EventLogInterval
+	defer func() { EventLogInterval = interval }()
+	EventLogInterval = time.Hour
+
+	rl := newRateLimitedLog()
+	rl.metrics = metrics.New()
+	ok, suppressed := rl.allow(EVENT_LOG_DUPLICATE_EVENT, "db/T1")
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), suppressed)
+	for i := 0; i < 5; i++ {
+		ok, _ = rl.allow(EVENT_LOG_DUPLICATE_EVENT, "db/T1")
+		assert.False(t, ok)
+	}
+	// other kinds and tables are limited separately
+	ok, _ = rl.allow(EVENT_LOG_DUPLICATE_EVENT, "db/T2")
+	assert.True(t, ok)
+	ok, _ = rl.allow(EVENT_LOG_NO_UPDATERS, "db/T1")
+	assert.True(t, ok)
+
+	EventLogInterval = 0
+	ok, suppressed = rl.allow(EVENT_LOG_DUPLICATE_EVENT, "db/T1")
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), suppressed)
+
+	snap := metrics.Snapshot{Counter: map[string]int64{}}
+	rl.metrics.Snapshot(snap)
+	assert.Equal(t, int64(8), snap.Counter[METRIC_EVENT_LOG_PREFIX+EVENT_LOG_DUPLICATE_EVENT])
+	assert.Equal(t, int64(1), snap.Counter[METRIC_EVENT_LOG_PREFIX+EVENT_LOG_NO_UPDATERS])
+}
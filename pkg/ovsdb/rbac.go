@@ -0,0 +1,225 @@
+package ovsdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+const (
+	RBAC_ROLE_TABLE       = "RBAC_Role"
+	RBAC_PERMISSION_TABLE = "RBAC_Permission"
+
+	// the role column of the Connection table, and the columns of the RBAC tables
+	COL_ROLE          = "role"
+	COL_NAME          = "name"
+	COL_PERMISSIONS   = "permissions"
+	COL_TABLE         = "table"
+	COL_AUTHORIZATION = "authorization"
+	COL_INSERT_DELETE = "insert_delete"
+	COL_UPDATE        = "update"
+)
+
+// rbacClient authorizes the modifications of a client with a role, by the RBAC_Role and RBAC_Permission tables of the
+// database, like the role based access control of ovsdb-server. A nil client is not restricted.
+type rbacClient struct {
+	// the client id, which is compared with the authorization columns of the rows, the TLS certificate common name
+	id   string
+	role string
+}
+
+// newRBACClient returns the RBAC client of the identity, nil if the client has no role, or the database has no RBAC
+// tables.
+func newRBACClient(dbSchema *libovsdb.DatabaseSchema, identity *Identity) *rbacClient {
+	if dbSchema == nil || identity == nil || identity.Role == "" {
+		return nil
+	}
+	if _, err := dbSchema.LookupTable(RBAC_ROLE_TABLE); err != nil {
+		return nil
+	}
+	if _, err := dbSchema.LookupTable(RBAC_PERMISSION_TABLE); err != nil {
+		return nil
+	}
+	return &rbacClient{id: identity.Name, role: identity.Role}
+}
+
+// rbacError is a modification prohibited by the RBAC rules, the details are returned with the operation error
+type rbacError struct {
+	details string
+}
+
+func (e *rbacError) Error() string {
+	return E_PERMISSION_ERROR
+}
+
+func (c *rbacClient) deny(txn *Transaction, action string, table string) error {
+	err := &rbacError{details: fmt.Sprintf("RBAC rules for client \"%s\" role \"%s\" prohibit %s table \"%s\".", c.id,
+		c.role, action, table)}
+	txn.log.Error(err, "modification prohibited by the RBAC rules", "details", err.details)
+	return err
+}
+
+// fetch adds the reads of the RBAC tables to the transaction
+func (c *rbacClient) fetch(txn *Transaction) {
+	if c == nil {
+		return
+	}
+	for _, table := range []string{RBAC_ROLE_TABLE, RBAC_PERMISSION_TABLE} {
+		key := common.NewTableKey(txn.request.DBName, table)
+		etcdGetData(txn, &key)
+	}
+}
+
+// permission returns the permission row of the client role for the table, nil if the role does not permit the
+// modifications of the table
+func (c *rbacClient) permission(txn *Transaction, table string) *map[string]interface{} {
+	for _, role := range txn.cache.Table(txn.request.DBName, RBAC_ROLE_TABLE) {
+		if name, _ := (*role)[COL_NAME].(string); name != c.role {
+			continue
+		}
+		permissions, ok := (*role)[COL_PERMISSIONS].(libovsdb.OvsMap)
+		if !ok {
+			return nil
+		}
+		uuid, ok := permissions.GoMap[table].(libovsdb.UUID)
+		if !ok {
+			return nil
+		}
+		permission := txn.cache.Table(txn.request.DBName, RBAC_PERMISSION_TABLE)[uuid.GoUUID]
+		if permission == nil {
+			return nil
+		}
+		if name, _ := (*permission)[COL_TABLE].(string); name != table {
+			return nil
+		}
+		return permission
+	}
+	return nil
+}
+
+// authorized returns true if the client is authorized to modify the row: the permission has no authorization columns,
+// or one of the "column" or "column:key" values of the row is the client id.
+func (c *rbacClient) authorized(permission, row *map[string]interface{}) bool {
+	authorization := rbacStrings((*permission)[COL_AUTHORIZATION])
+	if len(authorization) == 0 {
+		return true
+	}
+	for _, name := range authorization {
+		column, key := name, ""
+		if i := strings.Index(name, ":"); i >= 0 {
+			column, key = name[:i], name[i+1:]
+		}
+		value := (*row)[column]
+		if key == "" {
+			for _, element := range rbacStrings(value) {
+				if element == c.id {
+					return true
+				}
+			}
+		} else if m, ok := value.(libovsdb.OvsMap); ok {
+			if element, ok := m.GoMap[key].(string); ok && element == c.id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// updatable returns true if the permission allows the modifications of all the columns
+func (c *rbacClient) updatable(permission *map[string]interface{}, columns []string) bool {
+	allowed := map[string]bool{}
+	for _, column := range rbacStrings((*permission)[COL_UPDATE]) {
+		allowed[column] = true
+	}
+	for _, column := range columns {
+		if !allowed[column] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *rbacClient) checkInsert(txn *Transaction, table string) error {
+	if c == nil {
+		return nil
+	}
+	permission := c.permission(txn, table)
+	if permission == nil {
+		return c.deny(txn, "row insertion into", table)
+	}
+	if insertDelete, _ := (*permission)[COL_INSERT_DELETE].(bool); !insertDelete {
+		return c.deny(txn, "row insertion into", table)
+	}
+	return nil
+}
+
+func (c *rbacClient) checkDelete(txn *Transaction, table string, row *map[string]interface{}) error {
+	if c == nil {
+		return nil
+	}
+	permission := c.permission(txn, table)
+	if permission == nil {
+		return c.deny(txn, "row deletion from", table)
+	}
+	if insertDelete, _ := (*permission)[COL_INSERT_DELETE].(bool); !insertDelete || !c.authorized(permission, row) {
+		return c.deny(txn, "row deletion from", table)
+	}
+	return nil
+}
+
+func (c *rbacClient) checkUpdate(txn *Transaction, table string, update *map[string]interface{}, row *map[string]interface{}) error {
+	if c == nil {
+		return nil
+	}
+	var columns []string
+	if update != nil {
+		for column := range *update {
+			columns = append(columns, column)
+		}
+	}
+	permission := c.permission(txn, table)
+	if permission == nil || !c.updatable(permission, columns) || !c.authorized(permission, row) {
+		return c.deny(txn, "modification of", table)
+	}
+	return nil
+}
+
+func (c *rbacClient) checkMutate(txn *Transaction, table string, mutations *[]interface{}, row *map[string]interface{}) error {
+	if c == nil {
+		return nil
+	}
+	var columns []string
+	if mutations != nil {
+		for _, mt := range *mutations {
+			if mutation, ok := mt.([]interface{}); ok && len(mutation) > 0 {
+				if column, ok := mutation[0].(string); ok {
+					columns = append(columns, column)
+				}
+			}
+		}
+	}
+	permission := c.permission(txn, table)
+	if permission == nil || !c.updatable(permission, columns) || !c.authorized(permission, row) {
+		return c.deny(txn, "mutate operation on", table)
+	}
+	return nil
+}
+
+// rbacStrings returns the strings of a string column, or of a set of strings
+func rbacStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case libovsdb.OvsSet:
+		elements := make([]string, 0, len(v.GoSet))
+		for _, element := range v.GoSet {
+			if s, ok := element.(string); ok {
+				elements = append(elements, s)
+			}
+		}
+		return elements
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+const testSchemaRBACJSON = `{
+	"name": "rbac",
+	"version": "0.0.0",
+	"tables": {
+		"Chassis": {
+			"columns": {
+				"name": {"type": "string"},
+				"hostname": {"type": "string"},
+				"external_ids": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}}
+			},
+			"indexes": [["name"]]
+		},
+		"RBAC_Role": {
+			"columns": {
+				"name": {"type": "string"},
+				"permissions": {"type": {"key": {"type": "string"},
+					"value": {"type": "uuid", "refTable": "RBAC_Permission", "refType": "weak"},
+					"min": 0, "max": "unlimited"}}
+			}
+		},
+		"RBAC_Permission": {
+			"columns": {
+				"table": {"type": "string"},
+				"authorization": {"type": {"key": "string", "min": 0, "max": "unlimited"}},
+				"insert_delete": {"type": "boolean"},
+				"update": {"type": {"key": "string", "min": 0, "max": "unlimited"}}
+			}
+		}
+	}
+}`
+
+func testSchemaRBAC(t *testing.T) *libovsdb.DatabaseSchema {
+	schema := &libovsdb.DatabaseSchema{}
+	assert.Nil(t, json.Unmarshal([]byte(testSchemaRBACJSON), schema))
+	return schema
+}
+
+func testRBACTransact(t *testing.T, rbac *rbacClient, operations string) *Transaction {
+	var ops []libovsdb.Operation
+	assert.Nil(t, json.Unmarshal([]byte(operations), &ops))
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	txn := NewTransaction(cli, klogr.New(), &libovsdb.Transact{DBName: "rbac", Operations: ops})
+	txn.AddSchema(testSchemaRBAC(t))
+	txn.rbac = rbac
+	txn.Commit()
+	return txn
+}
+
+func TestRBACTransact(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	txn := testRBACTransact(t, nil, `[
+		{"op": "insert", "table": "RBAC_Permission", "uuid-name": "perm",
+			"row": {"table": "Chassis", "authorization": ["set", ["name"]], "insert_delete": false,
+				"update": ["set", ["hostname"]]}},
+		{"op": "insert", "table": "RBAC_Role",
+			"row": {"name": "ovn-controller", "permissions": ["map", [["Chassis", ["named-uuid", "perm"]]]]}},
+		{"op": "insert", "table": "Chassis", "row": {"name": "ch1"}},
+		{"op": "insert", "table": "Chassis", "row": {"name": "ch2"}}
+	]`)
+	assert.Nil(t, txn.response.Error)
+
+	client := &rbacClient{id: "ch1", role: "ovn-controller"}
+	for _, tc := range []struct {
+		name       string
+		rbac       *rbacClient
+		operations string
+		count      int
+		denied     bool
+	}{
+		{"update of the own row", client,
+			`[{"op": "update", "table": "Chassis", "where": [["name", "==", "ch1"]], "row": {"hostname": "h1"}}]`, 1, false},
+		{"update of another row", client,
+			`[{"op": "update", "table": "Chassis", "where": [["name", "==", "ch2"]], "row": {"hostname": "h2"}}]`, 0, true},
+		{"update of a column without permission", client,
+			`[{"op": "update", "table": "Chassis", "where": [["name", "==", "ch1"]], "row": {"name": "ch3"}}]`, 0, true},
+		{"mutate of a column without permission", client,
+			`[{"op": "mutate", "table": "Chassis", "where": [["name", "==", "ch1"]],
+				"mutations": [["external_ids", "insert", ["map", [["k", "v"]]]]]}]`, 0, true},
+		{"insert", client, `[{"op": "insert", "table": "Chassis", "row": {"name": "ch1"}}]`, 0, true},
+		{"delete", client, `[{"op": "delete", "table": "Chassis", "where": [["name", "==", "ch1"]]}]`, 0, true},
+		{"unknown role", &rbacClient{id: "ch1", role: "unknown"},
+			`[{"op": "update", "table": "Chassis", "where": [["name", "==", "ch1"]], "row": {"hostname": "h1"}}]`, 0, true},
+		{"no role", nil, `[{"op": "delete", "table": "Chassis", "where": [["name", "==", "ch2"]]}]`, 1, false},
+	} {
+		txn := testRBACTransact(t, tc.rbac, tc.operations)
+		result := txn.response.Result[0]
+		if tc.denied {
+			if assert.NotNil(t, result.Error, tc.name) {
+				assert.Equal(t, E_PERMISSION_ERROR, *result.Error, tc.name)
+				assert.NotNil(t, result.Details, tc.name)
+			}
+			continue
+		}
+		assert.Nil(t, txn.response.Error, tc.name)
+		if assert.NotNil(t, result.Count, tc.name) {
+			assert.Equal(t, tc.count, *result.Count, tc.name)
+		}
+	}
+}
+
+func TestRBACAuthorized(t *testing.T) {
+	client := &rbacClient{id: "ch1", role: "ovn-controller"}
+	for _, tc := range []struct {
+		name          string
+		authorization libovsdb.OvsSet
+		row           map[string]interface{}
+		expected      bool
+	}{
+		{"no authorization", libovsdb.OvsSet{}, map[string]interface{}{"name": "ch2"}, true},
+		{"column", libovsdb.OvsSet{GoSet: []interface{}{"name"}}, map[string]interface{}{"name": "ch1"}, true},
+		{"other column value", libovsdb.OvsSet{GoSet: []interface{}{"name"}}, map[string]interface{}{"name": "ch2"}, false},
+		{"set column", libovsdb.OvsSet{GoSet: []interface{}{"chassis"}},
+			map[string]interface{}{"chassis": libovsdb.OvsSet{GoSet: []interface{}{"ch2", "ch1"}}}, true},
+		{"map key", libovsdb.OvsSet{GoSet: []interface{}{"name", "external_ids:chassis-id"}},
+			map[string]interface{}{"name": "ch2", "external_ids": libovsdb.OvsMap{GoMap: map[interface{}]interface{}{
+				"chassis-id": "ch1"}}}, true},
+		{"other map key", libovsdb.OvsSet{GoSet: []interface{}{"external_ids:chassis-id"}},
+			map[string]interface{}{"external_ids": libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"id": "ch1"}}}, false},
+	} {
+		permission := map[string]interface{}{COL_AUTHORIZATION: tc.authorization}
+		assert.Equal(t, tc.expected, client.authorized(&permission, &tc.row), tc.name)
+	}
+}
@@ -0,0 +1,103 @@
+package ovsdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const REDACT_ANY_ROLE = "*"
+
+// RedactionPolicy defines sensitive columns, e.g. IPSec pre-shared keys, which are never sent to clients of specific
+// roles. The columns are removed from the select results and from the monitor notifications by the server, and they
+// cannot be used in the conditions of the clients, so their values cannot be guessed.
+type RedactionPolicy []redactionRule
+
+type redactionRule struct {
+	dbName string
+	table  string
+	column string
+	// "*" or a role name
+	role string
+}
+
+// ParseRedactionPolicy parses a comma separated list of <db-name>.<table>.<column>@<role> rules, e.g.
+// "OVN_Southbound.Encap.options@read-only,OVN_Northbound.BFD.options". If the role is omitted, the rule applies to all
+// the roles.
+func ParseRedactionPolicy(rules string) (RedactionPolicy, error) {
+	parsed := RedactionPolicy{}
+	for _, str := range strings.Split(rules, ",") {
+		str = strings.TrimSpace(str)
+		if str == "" {
+			continue
+		}
+		rule := redactionRule{role: REDACT_ANY_ROLE}
+		column := str
+		if i := strings.Index(str, "@"); i >= 0 {
+			column = str[:i]
+			rule.role = str[i+1:]
+		}
+		parts := strings.Split(column, ".")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" || rule.role == "" {
+			return nil, fmt.Errorf("wrong redaction rule %q", str)
+		}
+		rule.dbName = parts[0]
+		rule.table = parts[1]
+		rule.column = parts[2]
+		parsed = append(parsed, rule)
+	}
+	return parsed, nil
+}
+
+// Columns returns the redacted columns of the table for the given role, nil if there are no redacted columns
+func (policy RedactionPolicy) Columns(role, dbName, table string) map[string]bool {
+	var columns map[string]bool
+	for _, rule := range policy {
+		if rule.dbName != dbName || rule.table != table || (rule.role != REDACT_ANY_ROLE && rule.role != role) {
+			continue
+		}
+		if columns == nil {
+			columns = map[string]bool{}
+		}
+		columns[rule.column] = true
+	}
+	return columns
+}
+
+// redactRow removes the redacted columns from the row
+func redactRow(row map[string]interface{}, redacted map[string]bool) {
+	for column := range redacted {
+		delete(row, column)
+	}
+}
+
+// redactedColumnsList returns the sorted redacted columns
+func redactedColumnsList(redacted map[string]bool) []string {
+	if len(redacted) == 0 {
+		return nil
+	}
+	columns := make([]string, 0, len(redacted))
+	for column := range redacted {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// conditionsColumns returns the columns, which are used by the where conditions
+func conditionsColumns(where *[]interface{}) []string {
+	if where == nil {
+		return nil
+	}
+	var columns []string
+	for _, cond := range *where {
+		condition, ok := cond.([]interface{})
+		if !ok || len(condition) == 0 {
+			continue
+		}
+		if column, ok := condition[0].(string); ok {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
@@ -0,0 +1,173 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func TestParseRedactionPolicy(t *testing.T) {
+	policy, err := ParseRedactionPolicy("OVN_Southbound.Encap.options@read-only, OVN_Northbound.BFD.options")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(policy))
+	assert.Equal(t, map[string]bool{"options": true}, policy.Columns("read-only", "OVN_Southbound", "Encap"))
+	assert.Nil(t, policy.Columns("admin", "OVN_Southbound", "Encap"))
+	assert.Equal(t, map[string]bool{"options": true}, policy.Columns("admin", "OVN_Northbound", "BFD"))
+	assert.Nil(t, policy.Columns("read-only", "OVN_Northbound", "ACL"))
+
+	policy, err = ParseRedactionPolicy("")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(policy))
+
+	for _, wrong := range []string{"Encap.options", "OVN_Southbound.Encap.@ro", "OVN_Southbound.Encap.options@", "..options"} {
+		_, err = ParseRedactionPolicy(wrong)
+		assert.NotNil(t, err, wrong)
+	}
+}
+
+func testRedactionHandler(t *testing.T, role string) *Handler {
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {}},
+	}}
+	db := DatabaseMock{Response: schemas}
+	handler := NewHandler(context.Background(), &db, nil, klogr.New())
+	policy, err := ParseRedactionPolicy("dbName.T1.psk@read-only")
+	assert.Nil(t, err)
+	handler.SetRedactionPolicy(policy)
+	handler.SetIdentity(&Identity{Name: "client", Role: role, Method: AUTH_METHOD_NONE}, nil)
+	return handler
+}
+
+func TestMonitorRedactedColumns(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	row := map[string]interface{}{"name": "encap1", "psk": "secret"}
+	value := prepareData(t, row, true)
+	for role, expected := range map[string]map[string]interface{}{
+		"read-only": {"name": "encap1"},
+		"admin":     {"name": "encap1", "psk": "secret"},
+	} {
+		handler := testRedactionHandler(t, role)
+		var params []interface{}
+		err := json.Unmarshal([]byte(`["dbName", "monid", {"T1": [{}]}]`), &params)
+		assert.Nil(t, err)
+		updatersMap, err := handler.addMonitor(params, ovsjson.Update2)
+		assert.Nil(t, err)
+		updaters := updatersMap[common.NewTableKey(DB_NAME, "T1")]
+		assert.Equal(t, 1, len(updaters))
+		data, uuid, err := updaters[0].prepareRow(value)
+		assert.Nil(t, err)
+		assert.Equal(t, ROW_UUID, uuid)
+		assert.Equal(t, expected, data, role)
+	}
+	// the notifications of the different roles are not shared
+	k1, err := monitorRequestKey(DB_NAME, ovsjson.Update2, Key2Updaters{common.NewTableKey(DB_NAME, "T1"): {{redacted: map[string]bool{"psk": true}}}})
+	assert.Nil(t, err)
+	k2, err := monitorRequestKey(DB_NAME, ovsjson.Update2, Key2Updaters{common.NewTableKey(DB_NAME, "T1"): {{}}})
+	assert.Nil(t, err)
+	assert.NotEqual(t, k1, k2)
+}
+
+func TestTransactRedactedColumns(t *testing.T) {
+	handler := testRedactionHandler(t, "read-only")
+	table := "T1"
+	where := []interface{}{[]interface{}{"psk", FN_EQ, "secret"}}
+	req := &libovsdb.Transact{DBName: DB_NAME, Operations: []libovsdb.Operation{{Op: OP_SELECT, Table: &table, Where: &where}}}
+	assert.NotNil(t, handler.checkRedactedConditions(req))
+	columns := []string{"psk"}
+	req = &libovsdb.Transact{DBName: DB_NAME, Operations: []libovsdb.Operation{{Op: OP_WAIT, Table: &table, Columns: &columns}}}
+	assert.NotNil(t, handler.checkRedactedConditions(req))
+
+	req = &libovsdb.Transact{DBName: DB_NAME, Operations: []libovsdb.Operation{{Op: OP_SELECT, Table: &table}}}
+	assert.Nil(t, handler.checkRedactedConditions(req))
+	result := libovsdb.OperationResult{}
+	result.InitRows()
+	result.AppendRows(libovsdb.ResultRow{"name": "encap1", "psk": "secret"})
+	results := []libovsdb.OperationResult{result}
+	handler.redactResults(req, results)
+	assert.Equal(t, []libovsdb.ResultRow{{"name": "encap1"}}, *results[0].Rows)
+}
+
+func TestTransactRedactedColumnsFailure(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	db, _ := NewDatabaseEtcd(cli)
+	db.(*DatabaseEtcd).Schemas.Add(testSchemaSimple)
+	db.(*DatabaseEtcd).locks["simple"] = &sync.RWMutex{}
+	handler := NewHandler(context.Background(), db, cli, klogr.New())
+	defer handler.Cleanup()
+	policy, err := ParseRedactionPolicy("simple.table1.key1@read-only")
+	assert.Nil(t, err)
+	handler.SetRedactionPolicy(policy)
+	handler.SetIdentity(&Identity{Name: "client", Role: "read-only", Method: AUTH_METHOD_NONE}, nil)
+	testEtcdPut(t, "simple", "table1", map[string]interface{}{"key1": "secret", "key2": 1})
+
+	transact := func(operations string) string {
+		var params []interface{}
+		assert.Nil(t, json.Unmarshal([]byte(`["simple", `+operations+`]`), &params))
+		reply, err := handler.Transact(context.Background(), params)
+		assert.Nil(t, err)
+		buf, err := json.Marshal(reply)
+		assert.Nil(t, err)
+		return string(buf)
+	}
+	// the select is not executed with the abort
+	reply := transact(`{"op": "select", "table": "table1", "where": []}, {"op": "abort"}`)
+	assert.Contains(t, reply, E_CONSTRAINT_VIOLATION)
+	assert.NotContains(t, reply, "secret")
+	// the rows selected before the transaction failed are redacted
+	fi := NewFaultInjector()
+	defer fi.Inject(cli)()
+	fi.Add(Fault{Op: FAULT_OP_TXN, Skip: 1, Times: 1, Err: errors.New("injected")})
+	reply = transact(`{"op": "select", "table": "table1", "where": []}`)
+	assert.Contains(t, reply, E_IO_ERROR)
+	assert.Contains(t, reply, "key2")
+	assert.NotContains(t, reply, "secret")
+}
+
+func TestMonitorCondRedactedConditions(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	db := &testMonitorDB{schemas: testMonitorCondSchemas(), revision: 10}
+	db.kvs = []*mvccpb.KeyValue{testMonitorCondRow(t, "u1", "a", 1, 1)}
+	handler := NewHandler(context.Background(), db, nil, klogr.New())
+	handler.SetConnection(&jrpcServerRecorder{}, nil)
+	policy, err := ParseRedactionPolicy("dbName.T1.name@read-only")
+	assert.Nil(t, err)
+	handler.SetRedactionPolicy(policy)
+	handler.SetIdentity(&Identity{Name: "client", Role: "read-only", Method: AUTH_METHOD_NONE}, nil)
+
+	// the rows selected by a condition on a redacted column would reveal its values
+	var params []interface{}
+	assert.Nil(t, json.Unmarshal([]byte(`["dbName", "monid", {"T1": [{"where": [["name", "==", "a"]]}]}]`), &params))
+	_, err = handler.addMonitor(params, ovsjson.Update2)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), E_PERMISSION_ERROR)
+	}
+	assert.Nil(t, json.Unmarshal([]byte(`["dbName", "monid", {"T1": [{"where": [["n", "==", 1]]}]}]`), &params))
+	_, err = handler.addMonitor(params, ovsjson.Update2)
+	assert.Nil(t, err)
+	handler.startNotifier(jsonValueToString("monid"))
+
+	assert.Nil(t, json.Unmarshal([]byte(`["monid", "monid", {"T1": [{"where": [["name", "!=", "a"]]}]}]`), &params))
+	_, err = handler.MonitorCondChange(context.Background(), params)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), E_PERMISSION_ERROR)
+	}
+	assert.Equal(t, []interface{}{[]interface{}{"n", "==", float64(1)}},
+		handler.monitors[DB_NAME].key2Updaters[common.NewTableKey(DB_NAME, "T1")][0].mcr.Where)
+}
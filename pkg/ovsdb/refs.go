@@ -0,0 +1,278 @@
+package ovsdb
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// refColumn is a column, which keys or values are references to the rows of another table
+type refColumn struct {
+	table        string
+	column       string
+	columnSchema *libovsdb.ColumnSchema
+	// the references are in the keys, and in the values of a map
+	keyRefTable   string
+	valueRefTable string
+}
+
+// isRefOfType returns true if the base type is a reference of the given type, the default reference type is strong
+func isRefOfType(baseType *libovsdb.BaseType, refType libovsdb.RefType) bool {
+	if baseType == nil || baseType.RefTable == "" {
+		return false
+	}
+	if refType == libovsdb.Weak {
+		return baseType.RefType == libovsdb.Weak
+	}
+	return baseType.RefType != libovsdb.Weak
+}
+
+// strongRefColumns returns the columns with strong references of the database, ordered by their tables and names
+func strongRefColumns(databaseSchema *libovsdb.DatabaseSchema) []refColumn {
+	return refColumnsOf(databaseSchema, libovsdb.Strong)
+}
+
+// weakRefColumns returns the columns with weak references of the database, ordered by their tables and names
+func weakRefColumns(databaseSchema *libovsdb.DatabaseSchema) []refColumn {
+	return refColumnsOf(databaseSchema, libovsdb.Weak)
+}
+
+func refColumnsOf(databaseSchema *libovsdb.DatabaseSchema, refType libovsdb.RefType) []refColumn {
+	columns := []refColumn{}
+	for table, tableSchema := range databaseSchema.Tables {
+		for column, columnSchema := range tableSchema.Columns {
+			if columnSchema.TypeObj == nil {
+				continue
+			}
+			ref := refColumn{table: table, column: column, columnSchema: columnSchema}
+			if isRefOfType(columnSchema.TypeObj.Key, refType) {
+				ref.keyRefTable = columnSchema.TypeObj.Key.RefTable
+			}
+			if isRefOfType(columnSchema.TypeObj.Value, refType) {
+				ref.valueRefTable = columnSchema.TypeObj.Value.RefTable
+			}
+			if ref.keyRefTable != "" || ref.valueRefTable != "" {
+				columns = append(columns, ref)
+			}
+		}
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		if columns[i].table != columns[j].table {
+			return columns[i].table < columns[j].table
+		}
+		return columns[i].column < columns[j].column
+	})
+	return columns
+}
+
+// rowRef is a reference to a row
+type rowRef struct {
+	table string
+	uuid  string
+}
+
+func uuidOf(value interface{}) (string, bool) {
+	uuid, ok := value.(libovsdb.UUID)
+	return uuid.GoUUID, ok
+}
+
+// refs returns the strong references of the column in the stored row
+func (ref *refColumn) refs(row map[string]interface{}) []rowRef {
+	raw, ok := row[ref.column]
+	if !ok {
+		return nil
+	}
+	value, err := ref.columnSchema.Unmarshal(raw)
+	if err != nil {
+		return nil
+	}
+	refs := []rowRef{}
+	add := func(table string, v interface{}) {
+		if uuid, ok := uuidOf(v); ok && table != "" {
+			refs = append(refs, rowRef{table: table, uuid: uuid})
+		}
+	}
+	switch value := value.(type) {
+	case libovsdb.OvsSet:
+		for _, v := range value.GoSet {
+			add(ref.keyRefTable, v)
+		}
+	case libovsdb.OvsMap:
+		for k, v := range value.GoMap {
+			add(ref.keyRefTable, k)
+			add(ref.valueRefTable, v)
+		}
+	default:
+		add(ref.keyRefTable, value)
+	}
+	return refs
+}
+
+// referentialIntegrityError reports the references to missing rows in the transaction response
+func (txn *Transaction) referentialIntegrityError(violations []string) error {
+	err := errors.New(E_INTEGRITY_VIOLATION)
+	txn.log.Error(err, "referential integrity violation", "violations", violations)
+	details := "strong references to missing rows: " + strings.Join(violations, ", ")
+	errStr := err.Error()
+	txn.response.Result = append(txn.response.Result, libovsdb.OperationResult{Error: &errStr, Details: &details})
+	return err
+}
+
+// checkReferentialIntegrity verifies the strong references after the changes of the transaction: the references of
+// the written rows refer to existing rows, and the deleted rows are not referenced by the other rows. The rows, which
+// the check relies on, are guarded by compares of the etcd transaction, so a concurrent transaction cannot break the
+// references before the commit.
+func (txn *Transaction) checkReferentialIntegrity() error {
+	databaseSchema, ok := txn.schemas[txn.request.DBName]
+	if !ok {
+		return nil
+	}
+	columns := strongRefColumns(databaseSchema)
+	if len(columns) == 0 {
+		return nil
+	}
+	dbName := txn.request.DBName
+
+	// the final values of the keys written by the transaction, nil if deleted
+	written := map[string]map[string]interface{}{}
+	deleted := map[rowRef]bool{}
+	for _, ev := range txn.etcd.Events {
+		if ev.Type == clientv3.EventTypeDelete {
+			key, err := common.ParseKey(string(ev.PrevKv.Key))
+			if err != nil {
+				continue
+			}
+			written[string(ev.PrevKv.Key)] = nil
+			deleted[rowRef{table: key.TableName, uuid: key.UUID}] = true
+			continue
+		}
+		row, err := unmarshalData(ev.Kv.Value)
+		if err != nil {
+			return errors.New(E_INTERNAL_ERROR)
+		}
+		written[string(ev.Kv.Key)] = row
+	}
+
+	violations := []string{}
+	// the new references of the written rows
+	required := map[rowRef]bool{}
+	for _, ev := range txn.etcd.Events {
+		if ev.Type == clientv3.EventTypeDelete {
+			continue
+		}
+		key, err := common.ParseKey(string(ev.Kv.Key))
+		if err != nil {
+			continue
+		}
+		row := written[string(ev.Kv.Key)]
+		var prevRow map[string]interface{}
+		if ev.PrevKv != nil {
+			prevRow, _ = unmarshalData(ev.PrevKv.Value)
+		}
+		for i := range columns {
+			ref := &columns[i]
+			if ref.table != key.TableName {
+				continue
+			}
+			prevRefs := map[rowRef]bool{}
+			for _, r := range ref.refs(prevRow) {
+				prevRefs[r] = true
+			}
+			for _, r := range ref.refs(row) {
+				if deleted[r] {
+					violations = append(violations, key.TableName+"."+ref.column+" -> "+r.table+"/"+r.uuid)
+					continue
+				}
+				refKey := common.NewDataKey(dbName, r.table, r.uuid).String()
+				if _, ok := written[refKey]; ok || prevRefs[r] {
+					continue
+				}
+				required[r] = true
+			}
+		}
+	}
+
+	// the referencing tables of the deleted rows
+	referencing := map[string][]*refColumn{}
+	for i := range columns {
+		ref := &columns[i]
+		for r := range deleted {
+			if r.table == ref.keyRefTable || r.table == ref.valueRefTable {
+				referencing[ref.table] = append(referencing[ref.table], ref)
+				break
+			}
+		}
+	}
+
+	requiredKeys := []string{}
+	for r := range required {
+		requiredKeys = append(requiredKeys, common.NewDataKey(dbName, r.table, r.uuid).String())
+	}
+	sort.Strings(requiredKeys)
+	reads := []clientv3.Op{}
+	for _, key := range requiredKeys {
+		reads = append(reads, clientv3.OpGet(key, clientv3.WithCountOnly()))
+	}
+	tables := make([]string, 0, len(referencing))
+	for table := range referencing {
+		tables = append(tables, table)
+		reads = append(reads, clientv3.OpGet(common.NewTableKey(dbName, table).String(), clientv3.WithPrefix()))
+	}
+	if len(reads) == 0 {
+		if len(violations) > 0 {
+			return txn.referentialIntegrityError(violations)
+		}
+		return nil
+	}
+	// the reads are served by the etcd transaction of the commit, so they don't exceed its limits
+	check := NewEtcd(txn.etcd)
+	check.Clear()
+	check.Then = reads
+	if err := check.Commit(); err != nil {
+		err = errors.New(E_IO_ERROR)
+		txn.log.Error(err, "referential integrity reads")
+		return err
+	}
+	revision := check.Res.Header.Revision
+	for i, key := range requiredKeys {
+		if check.Res.Responses[i].GetResponseRange().Count == 0 {
+			violations = append(violations, "-> "+strings.TrimPrefix(key, common.NewDBPrefixKey(dbName).String()))
+			continue
+		}
+		// the referenced row exists at the commit
+		txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.CreateRevision(key), ">", 0))
+	}
+	for i, table := range tables {
+		for _, kv := range check.Res.Responses[len(requiredKeys)+i].GetResponseRange().Kvs {
+			row, ok := written[string(kv.Key)]
+			if !ok {
+				var err error
+				if row, err = unmarshalData(kv.Value); err != nil {
+					continue
+				}
+			}
+			if row == nil {
+				continue
+			}
+			for _, ref := range referencing[table] {
+				for _, r := range ref.refs(row) {
+					if deleted[r] {
+						violations = append(violations, table+"."+ref.column+" -> "+r.table+"/"+r.uuid)
+					}
+				}
+			}
+		}
+		// no row of the referencing table is created or modified before the commit
+		tableKey := common.NewTableKey(dbName, table).String()
+		txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.ModRevision(tableKey), "<", revision+1).WithPrefix())
+	}
+	if len(violations) > 0 {
+		return txn.referentialIntegrityError(violations)
+	}
+	return nil
+}
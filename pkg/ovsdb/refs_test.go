@@ -0,0 +1,96 @@
+package ovsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+func TestStrongRefColumns(t *testing.T) {
+	schemas := testOvnSchemas(t)
+	found := false
+	for _, ref := range strongRefColumns(schemas["OVN_Northbound"]) {
+		if ref.table == "Logical_Switch" && ref.column == "ports" {
+			assert.Equal(t, "Logical_Switch_Port", ref.keyRefTable)
+			found = true
+		}
+		// weak references aren't enforced
+		assert.False(t, ref.table == "Logical_Switch" && ref.column == "load_balancer")
+	}
+	assert.True(t, found)
+}
+
+func TestReferentialIntegrity(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	resp, _ := testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Logical_Switch_Port", "uuid-name": "lsp", "row": {"name": "lsp1"}},
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls1", "ports": ["set", [["named-uuid", "lsp"]]]}}]`)
+	assert.Nil(t, resp.Error)
+	lspUUID := resp.Result[0].UUID.GoUUID
+
+	// the port is referenced by the switch
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "delete", "table": "Logical_Switch_Port", "where": [["name", "==", "lsp1"]]}]`)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, E_INTEGRITY_VIOLATION, *resp.Error)
+	}
+	assert.Equal(t, 1, len(testOvnSelect(t, "OVN_Northbound", "Logical_Switch_Port", `[]`)))
+
+	// a reference to a missing row
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls2", "ports": ["set", [["uuid", "`+common.GenerateUUID()+`"]]]}}]`)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, E_INTEGRITY_VIOLATION, *resp.Error)
+	}
+	assert.Equal(t, 1, len(testOvnSelect(t, "OVN_Northbound", "Logical_Switch", `[]`)))
+
+	// a reference to an existing row
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls2", "ports": ["set", [["uuid", "`+lspUUID+`"]]]}}]`)
+	assert.Nil(t, resp.Error)
+
+	// the references are removed with the port
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "mutate", "table": "Logical_Switch", "where": [],
+		 "mutations": [["ports", "delete", ["set", [["uuid", "`+lspUUID+`"]]]]]},
+		{"op": "delete", "table": "Logical_Switch_Port", "where": [["name", "==", "lsp1"]]}]`)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, 0, len(testOvnSelect(t, "OVN_Northbound", "Logical_Switch_Port", `[]`)))
+}
+
+func TestWeakReferences(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	resp, _ := testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Load_Balancer", "uuid-name": "lb1", "row": {"name": "lb1"}},
+		{"op": "insert", "table": "Load_Balancer", "uuid-name": "lb2", "row": {"name": "lb2"}},
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls1", "load_balancer": ["set", [["named-uuid", "lb1"], ["named-uuid", "lb2"]]]}},
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls2", "load_balancer": ["set", [["named-uuid", "lb1"]]]}}]`)
+	assert.Nil(t, resp.Error)
+	lb2UUID := resp.Result[1].UUID.GoUUID
+	loadBalancers := func(name string) []interface{} {
+		rows := testOvnSelect(t, "OVN_Northbound", "Logical_Switch", `[["name", "==", "`+name+`"]]`)
+		if !assert.Equal(t, 1, len(rows)) {
+			return nil
+		}
+		return rows[0]["load_balancer"].(libovsdb.OvsSet).GoSet
+	}
+
+	// the references are removed from the stored rows
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "delete", "table": "Load_Balancer", "where": [["name", "==", "lb1"]]}]`)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, []interface{}{libovsdb.UUID{GoUUID: lb2UUID}}, loadBalancers("ls1"))
+	assert.Empty(t, loadBalancers("ls2"))
+
+	// and from the rows written by the transaction
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "update", "table": "Logical_Switch", "where": [["name", "==", "ls1"]], "row": {"other_config": ["map", [["k", "v"]]]}},
+		{"op": "delete", "table": "Load_Balancer", "where": [["name", "==", "lb2"]]}]`)
+	assert.Nil(t, resp.Error)
+	assert.Empty(t, loadBalancers("ls1"))
+}
@@ -0,0 +1,339 @@
+package ovsdb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+// watchRegistry holds the etcd watches of the databases. A single watch of a database is shared by the monitors of
+// all the clients, and its responses are fanned out to them, so the etcd load does not grow with the number of the
+// clients.
+type watchRegistry struct {
+	cli *clientv3.Client
+	log logr.Logger
+
+	mu      sync.Mutex
+	watches map[string]*sharedWatch
+}
+
+func newWatchRegistry(cli *clientv3.Client) *watchRegistry {
+	return &watchRegistry{cli: cli, log: klogr.New().WithName("watch"), watches: map[string]*sharedWatch{}}
+}
+
+// subscribe adds the monitor to the watch of its database, the watch is started for its first monitor
+func (r *watchRegistry) subscribe(m *dbMonitor) *watchSubscription {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.watches[m.dataBaseName]
+	if !ok {
+		w = r.newSharedWatch(m.dataBaseName)
+		r.watches[m.dataBaseName] = w
+		w.start()
+	}
+	return w.subscribe(m)
+}
+
+// unsubscribe removes the monitor from the watch of the subscription, the watch is stopped after its last monitor is
+// removed
+func (r *watchRegistry) unsubscribe(m *dbMonitor, s *watchSubscription) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w := s.watch
+	if w.unsubscribe(m) == 0 && r.watches[w.dataBaseName] == w {
+		w.cancel()
+		delete(r.watches, w.dataBaseName)
+	}
+}
+
+// remove removes the failed watch, so the next monitor of its database starts a new one
+func (r *watchRegistry) remove(w *sharedWatch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.watches[w.dataBaseName] == w {
+		delete(r.watches, w.dataBaseName)
+	}
+}
+
+// subscribers returns the number of the monitors of the database watch
+func (r *watchRegistry) subscribers(dbName string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.watches[dbName]
+	if !ok {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.subscriptions)
+}
+
+func (r *watchRegistry) newSharedWatch(dbName string) *sharedWatch {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &sharedWatch{
+		log:           r.log.WithValues("dbName", dbName),
+		dataBaseName:  dbName,
+		ctx:           ctx,
+		cancel:        cancel,
+		registry:      r,
+		subscriptions: map[*dbMonitor]*watchSubscription{},
+	}
+	key := common.NewDBPrefixKey(dbName)
+	w.rewatch = func(revision int64) clientv3.WatchChan {
+		opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithCreatedNotify(), clientv3.WithPrevKV()}
+		if revision > 0 {
+			opts = append(opts, clientv3.WithRev(revision))
+		}
+		return r.cli.Watch(clientv3.WithRequireLeader(ctx), key.String(), opts...)
+	}
+	w.resync = func() (*clientv3.GetResponse, error) {
+		tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+		defer cancel()
+		return r.cli.Get(tctx, key.String(), clientv3.WithPrefix())
+	}
+	var revision int64
+	if DurableMonitors {
+		// the watcher starts after the state of the snapshot
+		if resp, err := w.resync(); err != nil {
+			w.log.Error(err, "database read for the watch snapshot")
+		} else {
+			w.snapshot = newWatchSnapshot(resp.Kvs, resp.Header.Revision, common.NewJournalKey(dbName).String())
+			revision = resp.Header.Revision + 1
+		}
+	} else if current, err := r.currentRevision(ctx, dbName); err != nil {
+		w.log.Error(err, "database revision read for the watch start")
+	} else {
+		revision = current + 1
+	}
+	w.watchChannel = w.rewatch(revision)
+	return w
+}
+
+// currentRevision returns the current revision of the etcd store. The watch starts explicitly after it, and not at the
+// revision the etcd server registers the watcher, which may be later than the initial data of the monitors.
+func (r *watchRegistry) currentRevision(ctx context.Context, dbName string) (int64, error) {
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	defer cancel()
+	resp, err := r.cli.Get(tctx, common.NewJournalKey(dbName).String(), clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+	return resp.Header.Revision, nil
+}
+
+// sharedWatch is the etcd watch of a database, which is shared by the monitors of the database. The watch is restarted
+// when it fails, and the changes missed by a watch canceled by a compaction are reconstructed from its snapshot.
+type sharedWatch struct {
+	log          logr.Logger
+	dataBaseName string
+
+	// etcd watcher channel
+	watchChannel clientv3.WatchChan
+	// re-establishes the etcd watcher from the given revision
+	rewatch func(revision int64) clientv3.WatchChan
+	// reads the current state of the database, nil if the missed changes cannot be reconstructed
+	resync func() (*clientv3.GetResponse, error)
+	// the state of the database observed by the watcher, nil if the monitors aren't durable
+	snapshot *watchSnapshot
+	// the etcd watcher context
+	ctx      context.Context
+	cancel   context.CancelFunc
+	registry *watchRegistry
+
+	mu            sync.Mutex
+	subscriptions map[*dbMonitor]*watchSubscription
+	// the row cache of the database, which is fed by the watch, nil if the transactions aren't cached
+	rowCache *rowCache
+}
+
+func (w *sharedWatch) subscribe(m *dbMonitor) *watchSubscription {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := &watchSubscription{watch: w, ready: make(chan struct{}, 1)}
+	w.subscriptions[m] = s
+	return s
+}
+
+// unsubscribe removes the monitor, and returns the number of the remaining monitors and the row cache
+func (w *sharedWatch) unsubscribe(m *dbMonitor) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if s, ok := w.subscriptions[m]; ok {
+		// the changes, which were not taken yet, are dropped
+		s.mu.Lock()
+		s.changes = nil
+		s.mu.Unlock()
+		s.close("")
+		delete(w.subscriptions, m)
+	}
+	if w.rowCache != nil {
+		return len(w.subscriptions) + 1
+	}
+	return len(w.subscriptions)
+}
+
+// deliver fans the watched changes out to the monitors and the row cache
+func (w *sharedWatch) deliver(changes watchedChanges) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rowCache != nil {
+		w.rowCache.apply(changes.events, changes.revision)
+	}
+	for _, s := range w.subscriptions {
+		s.deliver(changes)
+	}
+}
+
+// fail cancels the monitors of the watch, which cannot be resumed
+func (w *sharedWatch) fail(reason string) {
+	w.registry.remove(w)
+	w.cancel()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rowCache != nil {
+		w.rowCache.invalidate()
+		w.rowCache = nil
+	}
+	for m, s := range w.subscriptions {
+		s.close(reason)
+		delete(w.subscriptions, m)
+	}
+}
+
+func (w *sharedWatch) start() {
+	go func() {
+		var lastRevision int64
+		attempt := 0
+		defer failingWatches.set(w, 0)
+		for {
+			for wresp := range w.watchChannel {
+				if wresp.Canceled {
+					w.log.Info("etcd watch canceled", "err", wresp.Err(), "compact-revision", wresp.CompactRevision)
+					if wresp.CompactRevision != 0 {
+						revision, ok := w.replayGap()
+						if !ok {
+							// the missed events are not available anymore, the monitors cannot be resumed
+							w.fail(CANCEL_REASON_WATCH_COMPACTED)
+							return
+						}
+						lastRevision = revision
+					}
+					break
+				}
+				if attempt > 0 {
+					attempt = 0
+					failingWatches.set(w, 0)
+				}
+				if wresp.Header.Revision > lastRevision {
+					lastRevision = wresp.Header.Revision
+				}
+				if w.snapshot != nil {
+					w.snapshot.apply(wresp.Events, wresp.Header.Revision)
+				}
+				w.deliver(watchedChanges{events: wresp.Events, revision: wresp.Header.Revision, received: time.Now()})
+			}
+			if w.ctx.Err() != nil {
+				return
+			}
+			attempt++
+			failingWatches.set(w, attempt)
+			if !watchRestarts.wait(w.ctx, attempt) {
+				return
+			}
+			w.log.Info("restart etcd watch", "revision", lastRevision+1, "attempt", attempt)
+			serverMetrics.Count(METRIC_WATCH_RESTARTS_PREFIX+w.dataBaseName, 1)
+			w.watchChannel = w.rewatch(lastRevision + 1)
+		}
+	}()
+}
+
+// replayGap reconstructs the changes, which were missed by a watcher canceled by a compaction, from the current state
+// of the database, and sends them to the monitors. Returns the revision of the state, and false if the changes cannot
+// be reconstructed.
+func (w *sharedWatch) replayGap() (int64, bool) {
+	if w.snapshot == nil || w.resync == nil {
+		return 0, false
+	}
+	resp, err := w.resync()
+	if err != nil {
+		w.log.Error(err, "database read after watch compaction")
+		return 0, false
+	}
+	events := w.snapshot.gap(resp.Kvs, resp.Header.Revision)
+	w.log.Info("replay watch gap", "revision", resp.Header.Revision, "events", len(events))
+	// the missed transactions cannot be told apart, their changes are notified together
+	w.deliver(watchedChanges{events: events, revision: resp.Header.Revision, received: time.Now(), gap: true})
+	return resp.Header.Revision, true
+}
+
+// watchedChanges are the events of a watch response, or the changes missed by a watch canceled by a compaction
+type watchedChanges struct {
+	events   []*clientv3.Event
+	revision int64
+	// the time the changes were received from etcd
+	received time.Time
+	// the changes were reconstructed from the watch snapshot, so the transactions of the events cannot be told apart
+	gap bool
+}
+
+// watchSubscription holds the watched changes of a monitor till the monitor takes them, so a monitor, which is slow
+// to prepare its notifications, does not hold up the other monitors of the watch.
+type watchSubscription struct {
+	watch *sharedWatch
+
+	mu      sync.Mutex
+	changes []watchedChanges
+	// receives a value when changes are added, or the subscription is closed
+	ready  chan struct{}
+	closed bool
+	// the reason the monitor is canceled by the watch, empty if the monitor was removed
+	reason string
+}
+
+func (s *watchSubscription) deliver(changes watchedChanges) {
+	s.mu.Lock()
+	if !s.closed {
+		s.changes = append(s.changes, changes)
+	}
+	s.mu.Unlock()
+	signal(s.ready)
+}
+
+func (s *watchSubscription) close(reason string) {
+	s.mu.Lock()
+	s.closed = true
+	s.reason = reason
+	s.mu.Unlock()
+	signal(s.ready)
+}
+
+// next waits for the next watched changes. It returns false with the cancel reason, after the subscription is closed
+// and its changes are taken, or when the context is done.
+func (s *watchSubscription) next(ctx context.Context) (watchedChanges, string, bool) {
+	for {
+		s.mu.Lock()
+		if len(s.changes) > 0 {
+			changes := s.changes[0]
+			s.changes[0] = watchedChanges{}
+			s.changes = s.changes[1:]
+			s.mu.Unlock()
+			return changes, "", true
+		}
+		closed, reason := s.closed, s.reason
+		s.mu.Unlock()
+		if closed {
+			return watchedChanges{}, reason, false
+		}
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return watchedChanges{}, "", false
+		}
+	}
+}
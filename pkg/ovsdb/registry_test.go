@@ -0,0 +1,86 @@
+package ovsdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func TestSharedWatch(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	db, err := NewDatabaseEtcd(cli)
+	assert.Nil(t, err)
+	watches := db.(*DatabaseEtcd).watches
+
+	m1 := db.CreateMonitor(DB_NAME, nil, klogr.New())
+	m2 := db.CreateMonitor(DB_NAME, nil, klogr.New())
+	assert.Equal(t, 2, watches.subscribers(DB_NAME))
+	assert.Equal(t, m1.subscription.watch, m2.subscription.watch)
+
+	// both monitors receive the changes of the single watch
+	testEtcdPut(t, DB_NAME, "T1", map[string]interface{}{"c1": "v1"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, m := range []*dbMonitor{m1, m2} {
+		changes, _, ok := m.subscription.next(ctx)
+		for ok && len(changes.events) == 0 {
+			// the watch creation
+			changes, _, ok = m.subscription.next(ctx)
+		}
+		if assert.True(t, ok) && assert.Len(t, changes.events, 1) {
+			key, err := common.ParseKey(string(changes.events[0].Kv.Key))
+			assert.Nil(t, err)
+			assert.Equal(t, "T1", key.TableName)
+			assert.Equal(t, changes.events[0].Kv.ModRevision, changes.revision)
+		}
+	}
+
+	// the watch is stopped after its last monitor is canceled
+	watch := m1.subscription.watch
+	m1.cancel()
+	_, _, ok := m1.subscription.next(ctx)
+	assert.False(t, ok)
+	assert.Equal(t, 1, watches.subscribers(DB_NAME))
+	assert.Nil(t, watch.ctx.Err())
+	m2.cancel()
+	assert.Equal(t, 0, watches.subscribers(DB_NAME))
+	assert.NotNil(t, watch.ctx.Err())
+
+	// a new monitor starts a new watch
+	m3 := db.CreateMonitor(DB_NAME, nil, klogr.New())
+	defer m3.cancel()
+	assert.NotEqual(t, watch, m3.subscription.watch)
+	assert.Equal(t, 1, watches.subscribers(DB_NAME))
+}
+
+func TestSharedWatchFail(t *testing.T) {
+	registry := newWatchRegistry(nil)
+	watch := &sharedWatch{log: registry.log, dataBaseName: DB_NAME, registry: registry,
+		subscriptions: map[*dbMonitor]*watchSubscription{}}
+	watch.ctx, watch.cancel = context.WithCancel(context.Background())
+	registry.watches[DB_NAME] = watch
+	m := &dbMonitor{dataBaseName: DB_NAME}
+	s := watch.subscribe(m)
+	s.deliver(watchedChanges{revision: 5})
+
+	watch.fail(CANCEL_REASON_WATCH_COMPACTED)
+	assert.Equal(t, 0, registry.subscribers(DB_NAME))
+	// the delivered changes are taken before the cancel reason
+	changes, _, ok := s.next(context.Background())
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), changes.revision)
+	_, reason, ok := s.next(context.Background())
+	assert.False(t, ok)
+	assert.Equal(t, CANCEL_REASON_WATCH_COMPACTED, reason)
+}
@@ -0,0 +1,370 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+const (
+	REMOTE_DB = "db"
+
+	// the column of the referenced rows, which holds the target, and their status columns
+	COL_TARGET       = "target"
+	COL_IS_CONNECTED = "is_connected"
+	COL_STATUS       = "status"
+
+	STATUS_N_CONNECTIONS = "n_connections"
+)
+
+// RemoteStatusInterval is the interval between the writes of the status of the listeners into the rows of their
+// targets
+var RemoteStatusInterval = 5 * time.Second
+
+// RemoteStatus is the state of the listener of a target
+type RemoteStatus struct {
+	Connections int
+}
+
+// DbRemote is a remote of the "db:<db-name>,<table>,<column>" syntax of ovsdb-server, the targets to listen on are
+// configured by the column of the table rows. The column holds either the targets, or the references to the rows with
+// a "target" column, like the Connection table of the OVN databases. The status of the listeners is written to the
+// "is_connected" and "status" columns of the rows of the targets, if their table has them, and the clients of a target
+// are assigned the RBAC role of the "role" column of its row.
+type DbRemote struct {
+	DBName string
+	Table  string
+	Column string
+
+	db  Databaser
+	cli *clientv3.Client
+	log logr.Logger
+	// the table and the column of the targets, they are the remote table and column if the targets are not referenced
+	targetTable  string
+	targetColumn string
+	hasConnected bool
+	hasStatus    bool
+	hasRole      bool
+
+	// monitors the tables of the targets, and calls update when they are changed
+	handler *Handler
+	update  func(targets []string)
+	// serializes the reloads, so the updates are called in order
+	reloadMu sync.Mutex
+
+	mu sync.Mutex
+	// target -> uuid of its row
+	targets map[string]string
+	// target -> the role of its clients
+	roles map[string]string
+	// row uuid -> the last written status
+	status map[string]RemoteStatus
+}
+
+// IsDbRemote returns true if the remote is configured by a database table
+func IsDbRemote(target string) bool {
+	return strings.HasPrefix(target, REMOTE_DB+":")
+}
+
+func NewDbRemote(target string, db Databaser, cli *clientv3.Client, log logr.Logger) (*DbRemote, error) {
+	parts := strings.Split(strings.TrimPrefix(target, REMOTE_DB+":"), ",")
+	if !IsDbRemote(target) || len(parts) != 3 {
+		return nil, fmt.Errorf("wrong remote %q, expected db:<db-name>,<table>,<column>", target)
+	}
+	r := &DbRemote{DBName: parts[0], Table: parts[1], Column: parts[2], db: db, cli: cli,
+		log: log.WithName("db-remote").WithValues("remote", target), targets: map[string]string{},
+		roles: map[string]string{}, status: map[string]RemoteStatus{}}
+	dbSchema, ok := db.GetSchemas()[r.DBName]
+	if !ok {
+		return nil, fmt.Errorf("remote %s, unknown database %s", target, r.DBName)
+	}
+	tableSchema, err := dbSchema.LookupTable(r.Table)
+	if err != nil {
+		return nil, fmt.Errorf("remote %s: %v", target, err)
+	}
+	columnSchema, err := tableSchema.LookupColumn(r.Column)
+	if err != nil {
+		return nil, fmt.Errorf("remote %s: %v", target, err)
+	}
+	r.targetTable, r.targetColumn = r.Table, r.Column
+	keyType := string(columnSchema.Type)
+	if columnSchema.TypeObj != nil && columnSchema.TypeObj.Key != nil {
+		keyType = columnSchema.TypeObj.Key.Type
+		if columnSchema.TypeObj.Value != nil {
+			return nil, fmt.Errorf("remote %s, column %s is a map", target, r.Column)
+		}
+		if refTable := columnSchema.TypeObj.Key.RefTable; refTable != "" {
+			r.targetTable, r.targetColumn = refTable, COL_TARGET
+			keyType = libovsdb.TypeString
+		}
+	}
+	if keyType != libovsdb.TypeString {
+		return nil, fmt.Errorf("remote %s, column %s is not a string or a reference", target, r.Column)
+	}
+	targetSchema, err := dbSchema.LookupTable(r.targetTable)
+	if err != nil {
+		return nil, fmt.Errorf("remote %s: %v", target, err)
+	}
+	if _, err := targetSchema.LookupColumn(r.targetColumn); err != nil {
+		return nil, fmt.Errorf("remote %s: %v", target, err)
+	}
+	_, err = targetSchema.LookupColumn(COL_IS_CONNECTED)
+	r.hasConnected = err == nil
+	_, err = targetSchema.LookupColumn(COL_STATUS)
+	r.hasStatus = err == nil
+	_, err = targetSchema.LookupColumn(COL_ROLE)
+	r.hasRole = err == nil
+	return r, nil
+}
+
+func (r *DbRemote) String() string {
+	return fmt.Sprintf("%s:%s,%s,%s", REMOTE_DB, r.DBName, r.Table, r.Column)
+}
+
+// dbRemoteNotifier receives the notifications of the monitor of the targets tables
+type dbRemoteNotifier struct {
+	remote *DbRemote
+}
+
+func (n *dbRemoteNotifier) Wait() error {
+	return nil
+}
+
+func (n *dbRemoteNotifier) Stop() {}
+
+// Notify reads the targets again, the notification tells that they may have been changed
+func (n *dbRemoteNotifier) Notify(ctx context.Context, method string, params interface{}) error {
+	if err := n.remote.reload(); err != nil {
+		n.remote.log.Error(err, "failed to read the remote targets")
+	}
+	return nil
+}
+
+// Start monitors the tables of the targets, update is called with the current targets before it returns, and again
+// whenever they are changed
+func (r *DbRemote) Start(ctx context.Context, update func(targets []string)) error {
+	r.update = update
+	r.handler = NewHandler(ctx, r.db, r.cli, r.log)
+	r.handler.SetConnection(&dbRemoteNotifier{remote: r}, nil)
+	requests := map[string]interface{}{r.Table: []interface{}{map[string]interface{}{"columns": []string{r.Column}}}}
+	if r.targetTable != r.Table {
+		requests[r.targetTable] = []interface{}{map[string]interface{}{"columns": []string{r.targetColumn}}}
+	}
+	// the parameters are passed as they are received from a client
+	data, err := json.Marshal([]interface{}{r.DBName, r.String(), requests})
+	if err != nil {
+		return err
+	}
+	var params []interface{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return err
+	}
+	if _, err := r.handler.MonitorCond(ctx, params); err != nil {
+		return err
+	}
+	return r.reload()
+}
+
+// Stop cancels the monitor of the targets
+func (r *DbRemote) Stop() {
+	if r.handler != nil {
+		r.handler.Cleanup()
+	}
+}
+
+// reload reads the targets, and calls update if they were changed
+func (r *DbRemote) reload() error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	targets, roles, err := r.readTargets()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.roles = roles
+	changed := len(targets) != len(r.targets)
+	for target, uuid := range targets {
+		if r.targets[target] != uuid {
+			changed = true
+		}
+	}
+	r.targets = targets
+	r.mu.Unlock()
+	if changed {
+		list := make([]string, 0, len(targets))
+		for target := range targets {
+			list = append(list, target)
+		}
+		sort.Strings(list)
+		r.log.V(3).Info("remote targets", "targets", list)
+		r.update(list)
+	}
+	return nil
+}
+
+// readTargets returns the targets and the uuids of their rows, and the roles of the targets
+func (r *DbRemote) readTargets() (map[string]string, map[string]string, error) {
+	rows, err := r.readRows(r.Table)
+	if err != nil {
+		return nil, nil, err
+	}
+	targets := map[string]string{}
+	roles := map[string]string{}
+	if r.targetTable == r.Table {
+		for uuid, row := range rows {
+			for _, element := range columnElements(row[r.Column]) {
+				if target, ok := element.(string); ok {
+					targets[target] = uuid
+					r.setRole(roles, target, row)
+				}
+			}
+		}
+		return targets, roles, nil
+	}
+	targetRows, err := r.readRows(r.targetTable)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, row := range rows {
+		for _, element := range columnElements(row[r.Column]) {
+			ref, ok := element.(libovsdb.UUID)
+			if !ok {
+				continue
+			}
+			if targetRow, ok := targetRows[ref.GoUUID]; ok {
+				if target, ok := targetRow[r.targetColumn].(string); ok {
+					targets[target] = ref.GoUUID
+					r.setRole(roles, target, targetRow)
+				}
+			}
+		}
+	}
+	return targets, roles, nil
+}
+
+// setRole records the role of the target, if its row has one
+func (r *DbRemote) setRole(roles map[string]string, target string, row map[string]interface{}) {
+	if !r.hasRole {
+		return
+	}
+	if role, ok := row[COL_ROLE].(string); ok && role != "" {
+		roles[target] = role
+	}
+}
+
+// Role returns the RBAC role of the clients of the target, empty if the target has no role
+func (r *DbRemote) Role(target string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.roles[target]
+}
+
+// readRows returns the rows of the table by their uuids
+func (r *DbRemote) readRows(table string) (map[string]map[string]interface{}, error) {
+	resp, err := r.db.GetKeyData(common.NewTableKey(r.DBName, table), false)
+	if err != nil {
+		return nil, err
+	}
+	rows := map[string]map[string]interface{}{}
+	for _, kv := range resp.Kvs {
+		row, err := unmarshalData(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		uuid, err := getAndDeleteUUID(row)
+		if err != nil {
+			return nil, err
+		}
+		rows[uuid] = row
+	}
+	return rows, nil
+}
+
+// columnElements returns the atoms of a string or uuid column, or of a set of them
+func columnElements(value interface{}) []interface{} {
+	array, ok := value.([]interface{})
+	if !ok || len(array) != 2 {
+		if value == nil {
+			return nil
+		}
+		return []interface{}{value}
+	}
+	switch array[0] {
+	case libovsdb.TypeUUID:
+		if uuid, ok := array[1].(string); ok {
+			return []interface{}{libovsdb.UUID{GoUUID: uuid}}
+		}
+	case libovsdb.TypeSet:
+		elements := []interface{}{}
+		if set, ok := array[1].([]interface{}); ok {
+			for _, element := range set {
+				elements = append(elements, columnElements(element)...)
+			}
+		}
+		return elements
+	}
+	return nil
+}
+
+// WriteStatus writes the status of the listeners into the rows of their targets, only the changed statuses are
+// written
+func (r *DbRemote) WriteStatus(ctx context.Context, status map[string]RemoteStatus) error {
+	if !r.hasConnected && !r.hasStatus {
+		return nil
+	}
+	r.mu.Lock()
+	written := map[string]RemoteStatus{}
+	params := []interface{}{r.DBName}
+	for target, uuid := range r.targets {
+		st := status[target]
+		written[uuid] = st
+		if prev, ok := r.status[uuid]; ok && prev == st {
+			continue
+		}
+		row := map[string]interface{}{}
+		if r.hasConnected {
+			row[COL_IS_CONNECTED] = st.Connections > 0
+		}
+		if r.hasStatus {
+			row[COL_STATUS] = []interface{}{libovsdb.TypeMap,
+				[]interface{}{[]interface{}{STATUS_N_CONNECTIONS, strconv.Itoa(st.Connections)}}}
+		}
+		params = append(params, map[string]interface{}{
+			"op":    OP_UPDATE,
+			"table": r.targetTable,
+			"where": []interface{}{[]interface{}{COL_UUID, "==", []interface{}{libovsdb.TypeUUID, uuid}}},
+			"row":   row,
+		})
+	}
+	r.mu.Unlock()
+	if len(params) > 1 {
+		request, err := libovsdb.NewTransact(params)
+		if err != nil {
+			return err
+		}
+		txn := NewTransaction(r.cli, r.log, request)
+		txn.schemas = r.db.GetSchemas()
+		txn.etcd.Ctx = ctx
+		r.db.DbLock(r.DBName)
+		_, err = txn.Commit()
+		r.db.DbUnlock(r.DBName)
+		if err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	r.status = written
+	r.mu.Unlock()
+	return nil
+}
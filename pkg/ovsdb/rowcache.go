@@ -0,0 +1,367 @@
+package ovsdb
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+// TransactionRowCache evaluates the operations of the transactions against an in-memory copy of the database rows,
+// instead of reading the rows from etcd, so only the final commit of a transaction hits etcd. The copy is fed by the
+// shared etcd watch of the database, which is kept while the cache is used. The commit is still guarded by the mod
+// revisions of the rows it writes, so a transaction, which read stale rows modified by another server replica, fails
+// with a conflict and is executed again on the rows read from etcd.
+var TransactionRowCache = false
+
+// RowCacheWait is the maximum time a transaction waits for the row cache to observe the last commit of the database by
+// this server, before its rows are read from etcd
+var RowCacheWait = 100 * time.Millisecond
+
+// rowCache is the state of the database keys at the revision of the last watch response, grouped by their tables
+type rowCache struct {
+	log      logr.Logger
+	cli      *clientv3.Client
+	registry *watchRegistry
+	dbName   string
+	// the prefix of the database keys
+	prefix  string
+	journal string
+
+	mu sync.RWMutex
+	// table prefix -> key -> key value
+	tables   map[string]map[string]*mvccpb.KeyValue
+	revision int64
+	// the cache reflects the database, the transactions are not served while it's loaded or after its watch failed
+	synced bool
+	// the watched events are buffered while the cache is loaded
+	loading bool
+	pending []*clientv3.Event
+	// closed and replaced when the revision advances
+	advanced chan struct{}
+	// the revision of the last commit of the database by this server
+	lastCommit int64
+	// receives a value when the watch of the cache failed, and the cache should be loaded again
+	invalidated chan struct{}
+}
+
+func newRowCache(cli *clientv3.Client, registry *watchRegistry, dbName string) *rowCache {
+	return &rowCache{
+		log:         registry.log.WithValues("dbName", dbName, "cache", "rows"),
+		cli:         cli,
+		registry:    registry,
+		dbName:      dbName,
+		prefix:      common.NewDBPrefixKey(dbName).String(),
+		journal:     common.NewJournalKey(dbName).String(),
+		tables:      map[string]map[string]*mvccpb.KeyValue{},
+		advanced:    make(chan struct{}),
+		invalidated: make(chan struct{}, 1),
+	}
+}
+
+// start loads the cache and keeps it attached to the watch of the database until the context is done, the cache is
+// loaded again after its watch failed
+func (c *rowCache) start(ctx context.Context) {
+	go func() {
+		defer func() {
+			c.registry.detachRowCache(c)
+			c.mu.Lock()
+			c.synced = false
+			c.mu.Unlock()
+		}()
+		attempt := 0
+		for ctx.Err() == nil {
+			if err := c.load(ctx); err != nil {
+				c.log.Error(err, "row cache load")
+				attempt++
+				if !watchRestarts.wait(ctx, attempt) {
+					return
+				}
+				continue
+			}
+			attempt = 0
+			select {
+			case <-ctx.Done():
+			case <-c.invalidated:
+				c.log.Info("row cache watch failed, loading the cache again")
+			}
+		}
+	}()
+}
+
+// load reads the database keys, the events watched meanwhile are applied after them
+func (c *rowCache) load(ctx context.Context) error {
+	c.mu.Lock()
+	c.synced = false
+	c.loading = true
+	c.pending = nil
+	c.mu.Unlock()
+	// the events, which are watched after the cache is attached, are not missed by the read
+	c.registry.attachRowCache(c)
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	defer cancel()
+	resp, err := c.cli.Get(tctx, c.prefix, clientv3.WithPrefix())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := c.pending
+	c.loading = false
+	c.pending = nil
+	if err != nil {
+		return err
+	}
+	c.tables = map[string]map[string]*mvccpb.KeyValue{}
+	for _, kv := range resp.Kvs {
+		c.put(kv)
+	}
+	c.revision = resp.Header.Revision
+	c.applyEvents(pending)
+	for _, ev := range pending {
+		if ev.Kv.ModRevision > c.revision {
+			c.revision = ev.Kv.ModRevision
+		}
+	}
+	c.synced = true
+	c.advance()
+	c.log.V(5).Info("row cache loaded", "revision", c.revision, "keys", len(resp.Kvs))
+	return nil
+}
+
+// apply updates the cache by the events of a watch response
+func (c *rowCache) apply(events []*clientv3.Event, revision int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loading {
+		c.pending = append(c.pending, events...)
+		return
+	}
+	if !c.synced {
+		return
+	}
+	c.applyEvents(events)
+	if revision > c.revision {
+		c.revision = revision
+	}
+	c.advance()
+}
+
+// applyEvents applies the events after the revision of the cache, the earlier ones are already reflected by it
+func (c *rowCache) applyEvents(events []*clientv3.Event) {
+	for _, ev := range events {
+		if ev.Kv.ModRevision <= c.revision {
+			continue
+		}
+		if ev.Type == clientv3.EventTypeDelete {
+			key := string(ev.Kv.Key)
+			delete(c.tables[tablePrefix(key)], key)
+		} else {
+			c.put(ev.Kv)
+		}
+	}
+}
+
+func (c *rowCache) put(kv *mvccpb.KeyValue) {
+	key := string(kv.Key)
+	table := tablePrefix(key)
+	rows, ok := c.tables[table]
+	if !ok {
+		rows = map[string]*mvccpb.KeyValue{}
+		c.tables[table] = rows
+	}
+	rows[key] = kv
+}
+
+func (c *rowCache) advance() {
+	close(c.advanced)
+	c.advanced = make(chan struct{})
+}
+
+// invalidate stops serving the transactions till the cache is loaded again, it's called when its watch failed
+func (c *rowCache) invalidate() {
+	c.mu.Lock()
+	c.synced = false
+	c.mu.Unlock()
+	select {
+	case c.invalidated <- struct{}{}:
+	default:
+	}
+}
+
+// committed records the revision of a commit of the database by this server, the following transactions wait for
+// the cache to observe it. Only the commits, which modify the database keys, are observed by the watch.
+func (c *rowCache) committed(revision int64, ops []clientv3.Op) {
+	if c == nil {
+		return
+	}
+	modified := false
+	for _, op := range ops {
+		if (op.IsPut() || op.IsDelete()) && strings.HasPrefix(string(op.KeyBytes()), c.prefix) {
+			modified = true
+			break
+		}
+	}
+	if !modified {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if revision > c.lastCommit {
+		c.lastCommit = revision
+	}
+}
+
+// read returns the response of the read operations from the cache, as they would be read by an etcd transaction at
+// the revision of the cache. Returns false if the cache cannot serve them: an operation is not a plain prefix read of
+// the database keys, the cache is not synced, it didn't observe the last commit of this server in time, or a chained
+// commit of the database is in progress.
+func (c *rowCache) read(ctx context.Context, ops []clientv3.Op) (*clientv3.TxnResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+	for _, op := range ops {
+		if !c.cacheable(op) {
+			serverMetrics.Count(METRIC_ROW_CACHE_MISSES_PREFIX+c.dbName, 1)
+			return nil, false
+		}
+	}
+	timer := time.NewTimer(RowCacheWait)
+	defer timer.Stop()
+	for {
+		c.mu.RLock()
+		if !c.synced || c.tables[tablePrefix(c.journal)][c.journal] != nil {
+			c.mu.RUnlock()
+			serverMetrics.Count(METRIC_ROW_CACHE_MISSES_PREFIX+c.dbName, 1)
+			return nil, false
+		}
+		if c.revision >= c.lastCommit {
+			res := c.response(ops)
+			c.mu.RUnlock()
+			serverMetrics.Count(METRIC_ROW_CACHE_HITS_PREFIX+c.dbName, 1)
+			return res, true
+		}
+		advanced := c.advanced
+		c.mu.RUnlock()
+		select {
+		case <-advanced:
+		case <-timer.C:
+			serverMetrics.Count(METRIC_ROW_CACHE_MISSES_PREFIX+c.dbName, 1)
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// cacheable returns whether the operation reads the keys of a database prefix, like etcdGetData does
+func (c *rowCache) cacheable(op clientv3.Op) bool {
+	key := op.KeyBytes()
+	if !op.IsGet() || op.IsCountOnly() || op.IsKeysOnly() || op.IsSerializable() || op.Rev() != 0 ||
+		op.MinModRev() != 0 || op.MaxModRev() != 0 || op.MinCreateRev() != 0 || op.MaxCreateRev() != 0 {
+		return false
+	}
+	return strings.HasPrefix(string(key), c.prefix) &&
+		bytes.Equal(op.RangeBytes(), []byte(clientv3.GetPrefixRangeEnd(string(key))))
+}
+
+// response returns the key values of the read operations, a table prefix returns the rows of the table ordered by
+// their keys like etcd does. A row key returns the row, the keys of the other rows are not prefixed by it, as the uuids
+// are of the same length, and a shorter uuid in a condition does not select them anyway.
+func (c *rowCache) response(ops []clientv3.Op) *clientv3.TxnResponse {
+	header := &etcdserverpb.ResponseHeader{Revision: c.revision}
+	res := &clientv3.TxnResponse{Header: header, Succeeded: true, Responses: make([]*etcdserverpb.ResponseOp, 0, len(ops))}
+	for _, op := range ops {
+		key := string(op.KeyBytes())
+		table := tablePrefix(key)
+		var kvs []*mvccpb.KeyValue
+		if key == table {
+			kvs = make([]*mvccpb.KeyValue, 0, len(c.tables[table]))
+			for _, kv := range c.tables[table] {
+				kvs = append(kvs, kv)
+			}
+			sort.Slice(kvs, func(i, j int) bool { return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0 })
+		} else if kv, ok := c.tables[table][key]; ok {
+			kvs = []*mvccpb.KeyValue{kv}
+		}
+		res.Responses = append(res.Responses, &etcdserverpb.ResponseOp{
+			Response: &etcdserverpb.ResponseOp_ResponseRange{
+				ResponseRange: &etcdserverpb.RangeResponse{Header: header, Kvs: kvs, Count: int64(len(kvs))},
+			},
+		})
+	}
+	return res
+}
+
+// tablePrefix returns the prefix of the table of the key, the key up to its last delimiter
+func tablePrefix(key string) string {
+	return key[:strings.LastIndex(key, common.KEY_DELIMETER)+1]
+}
+
+// attachRowCache feeds the row cache by the watch of its database, the watch is started if there is no monitor of the
+// database, and it's kept while the cache is attached
+func (r *watchRegistry) attachRowCache(c *rowCache) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.watches[c.dbName]
+	if !ok {
+		w = r.newSharedWatch(c.dbName)
+		r.watches[c.dbName] = w
+		w.start()
+	}
+	w.mu.Lock()
+	w.rowCache = c
+	w.mu.Unlock()
+}
+
+// detachRowCache stops feeding the row cache, the watch is stopped if there is no monitor of its database
+func (r *watchRegistry) detachRowCache(c *rowCache) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.watches[c.dbName]
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rowCache != c {
+		return
+	}
+	w.rowCache = nil
+	if len(w.subscriptions) == 0 {
+		w.cancel()
+		delete(r.watches, c.dbName)
+	}
+}
+
+// StartRowCaches starts the row caches of the served databases, which serve the reads of the transactions, until the
+// context is done
+func (con *DatabaseEtcd) StartRowCaches(ctx context.Context) {
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	if con.rowCaches == nil {
+		con.rowCaches = map[string]*rowCache{}
+	}
+	for dbName := range con.Schemas {
+		// the _Server database is modified by all the replicas, its transactions are rare
+		if _, ok := con.rowCaches[dbName]; ok || dbName == INT_SERVER {
+			continue
+		}
+		c := newRowCache(con.cli, con.watches, dbName)
+		con.rowCaches[dbName] = c
+		c.start(ctx)
+	}
+}
+
+// RowCache returns the row cache of the database, nil if the transactions of the database aren't cached
+func (con *DatabaseEtcd) RowCache(dbName string) *rowCache {
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	return con.rowCaches[dbName]
+}
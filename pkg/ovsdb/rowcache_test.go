@@ -0,0 +1,155 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+func TestRowCacheTransactions(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	// the client of another replica
+	other, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer other.Close()
+	cacheCli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cacheCli.Close()
+	fi := NewFaultInjector()
+	defer fi.Inject(cli)()
+
+	transact := func(cli *clientv3.Client, cache *rowCache, operations string) ([]libovsdb.OperationResult, error) {
+		var ops []libovsdb.Operation
+		assert.Nil(t, json.Unmarshal([]byte(operations), &ops))
+		txn := NewTransaction(cli, klogr.New(), &libovsdb.Transact{DBName: "rbac", Operations: ops})
+		txn.AddSchema(testSchemaRBAC(t))
+		txn.rowCache = cache
+		_, err := txn.Commit()
+		return txn.response.Result, err
+	}
+	hostname := func(cache *rowCache) string {
+		result, err := transact(cli, cache, `[{"op": "select", "table": "Chassis", "where": [["name", "==", "ch1"]],
+			"columns": ["hostname"]}]`)
+		if !assert.Nil(t, err) || !assert.Len(t, *result[0].Rows, 1) {
+			return ""
+		}
+		return (*result[0].Rows)[0]["hostname"].(string)
+	}
+	tableKey := common.NewTableKey("rbac", "Chassis").String()
+	_, err = transact(other, nil, `[{"op": "insert", "table": "Chassis", "row": {"name": "ch1", "hostname": "h1"}}]`)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache := newRowCache(cacheCli, newWatchRegistry(cacheCli), "rbac")
+	cache.start(ctx)
+	assert.Eventually(t, func() bool {
+		_, ok := cache.read(ctx, []clientv3.Op{clientv3.OpGet(tableKey, clientv3.WithPrefix())})
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the cached rows are the rows of etcd
+	resp, err := other.Get(ctx, tableKey, clientv3.WithPrefix())
+	if !assert.Nil(t, err) || !assert.Len(t, resp.Kvs, 1) {
+		return
+	}
+	rowKey := string(resp.Kvs[0].Key)
+	cached, ok := cache.read(ctx, []clientv3.Op{clientv3.OpGet(tableKey, clientv3.WithPrefix()),
+		clientv3.OpGet(rowKey, clientv3.WithPrefix()), clientv3.OpGet(tableKey+"x", clientv3.WithPrefix())})
+	if assert.True(t, ok) && assert.Len(t, cached.Responses, 3) {
+		assert.Equal(t, resp.Kvs, cached.Responses[0].GetResponseRange().Kvs)
+		assert.Equal(t, resp.Kvs, cached.Responses[1].GetResponseRange().Kvs)
+		assert.Empty(t, cached.Responses[2].GetResponseRange().Kvs)
+		assert.GreaterOrEqual(t, cached.Header.Revision, resp.Kvs[0].ModRevision)
+	}
+	// the reads, which differ from the plain prefix reads of the database, are not served
+	for _, op := range []clientv3.Op{clientv3.OpGet(tableKey), clientv3.OpGet(tableKey, clientv3.WithPrefix(),
+		clientv3.WithKeysOnly()), clientv3.OpGet(tableKey, clientv3.WithPrefix(), clientv3.WithRev(1)),
+		clientv3.OpGet(common.NewTableKey("other", "Chassis").String(), clientv3.WithPrefix())} {
+		_, ok := cache.read(ctx, []clientv3.Op{op})
+		assert.False(t, ok)
+	}
+
+	// only the commit of a cached transaction hits etcd
+	fi.Reset()
+	assert.Equal(t, "h1", hostname(cache))
+	assert.Equal(t, 1, fi.Calls(FAULT_OP_TXN))
+	fi.Reset()
+	assert.Equal(t, "h1", hostname(nil))
+	assert.Equal(t, 2, fi.Calls(FAULT_OP_TXN))
+
+	// the transactions read the previous commits of the server
+	for _, h := range []string{"h2", "h3", "h4"} {
+		_, err = transact(cli, cache, `[{"op": "update", "table": "Chassis", "where": [["name", "==", "ch1"]],
+			"row": {"hostname": "`+h+`"}}]`)
+		assert.Nil(t, err)
+		assert.Equal(t, h, hostname(cache))
+	}
+
+	// a transaction, which read a stale cached row, is executed again on the rows of etcd
+	cache.mu.RLock()
+	stale := cache.tables[tableKey][rowKey]
+	cache.mu.RUnlock()
+	_, err = transact(other, nil, `[{"op": "update", "table": "Chassis", "where": [["name", "==", "ch1"]],
+		"row": {"hostname": "h5"}}]`)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		cache.mu.RLock()
+		defer cache.mu.RUnlock()
+		return cache.tables[tableKey][rowKey].ModRevision > stale.ModRevision
+	}, 5*time.Second, 10*time.Millisecond)
+	cache.mu.Lock()
+	cache.tables[tableKey][rowKey] = stale
+	cache.mu.Unlock()
+	fi.Reset()
+	_, err = transact(cli, cache, `[{"op": "mutate", "table": "Chassis", "where": [["name", "==", "ch1"]],
+		"mutations": [["external_ids", "insert", ["map", [["k", "v"]]]]]}]`)
+	assert.Nil(t, err)
+	// the failed commit, the two reads of its conflicts by the merge and by the conflict report, the read of the rows
+	// and the commit
+	assert.Equal(t, 5, fi.Calls(FAULT_OP_TXN))
+	assert.Equal(t, "h5", hostname(nil))
+
+	// the cache is not read while a chained commit is in progress
+	journal := common.NewJournalKey("rbac").String()
+	_, err = other.Put(ctx, journal, "{}")
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		_, ok := cache.read(ctx, []clientv3.Op{clientv3.OpGet(tableKey, clientv3.WithPrefix())})
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = other.Delete(ctx, journal)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		_, ok := cache.read(ctx, []clientv3.Op{clientv3.OpGet(tableKey, clientv3.WithPrefix())})
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the cache is loaded again after its watch failed
+	cache.invalidate()
+	_, ok = cache.read(ctx, []clientv3.Op{clientv3.OpGet(tableKey, clientv3.WithPrefix())})
+	assert.False(t, ok)
+	assert.Eventually(t, func() bool {
+		res, ok := cache.read(ctx, []clientv3.Op{clientv3.OpGet(tableKey, clientv3.WithPrefix())})
+		return ok && len(res.Responses[0].GetResponseRange().Kvs) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "h5", hostname(cache))
+}
@@ -0,0 +1,167 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+// RawRowEncoding encodes the inserted, the initial and the deleted rows of the monitor updates from the etcd values of
+// the rows, which are filtered by the selected columns without decoding them, so the rows are spliced into the
+// notifications as they are stored. The modified rows are diffed by their decoded columns.
+var RawRowEncoding = false
+
+// rowValue is the value of an etcd row, which is decoded once and shared by all the updaters of the row, so a change
+// of a table monitored by many clients is not decoded again per client. The decoded rows are shared, and must not be
+// modified.
+type rowValue struct {
+	value []byte
+
+	decoded bool
+	data    map[string]interface{}
+	err     error
+
+	// the row unmarshaled by the table schema, for the monitor conditions
+	typedSchema *libovsdb.TableSchema
+	typed       map[string]interface{}
+	typedErr    error
+}
+
+func newRowValue(value []byte) *rowValue {
+	return &rowValue{value: value}
+}
+
+// decode returns the row decoded from the etcd value
+func (r *rowValue) decode() (map[string]interface{}, error) {
+	if !r.decoded {
+		r.data, r.err = unmarshalData(r.value)
+		r.decoded = true
+	}
+	return r.data, r.err
+}
+
+// decodeTyped returns the row with the column values unmarshaled by the table schema
+func (r *rowValue) decodeTyped(tableSchema *libovsdb.TableSchema) (map[string]interface{}, error) {
+	if r.typedSchema == tableSchema && (r.typed != nil || r.typedErr != nil) {
+		return r.typed, r.typedErr
+	}
+	data, err := r.decode()
+	if err != nil {
+		return nil, err
+	}
+	typed := make(map[string]interface{}, len(data))
+	for column, value := range data {
+		typed[column] = value
+	}
+	r.typedSchema = tableSchema
+	if r.typedErr = tableSchema.Unmarshal(&typed); r.typedErr != nil {
+		r.typed = nil
+	} else {
+		r.typed = typed
+	}
+	return r.typed, r.typedErr
+}
+
+// eventRows holds the row values of an etcd event, the row is nil for a deletion, and the previous row is nil for a
+// creation.
+type eventRows struct {
+	event   *clientv3.Event
+	row     *rowValue
+	prevRow *rowValue
+}
+
+func newEventRows(event *clientv3.Event) *eventRows {
+	rows := &eventRows{event: event}
+	if event.Type != clientv3.EventTypeDelete {
+		rows.row = newRowValue(event.Kv.Value)
+	}
+	if event.PrevKv != nil {
+		rows.prevRow = newRowValue(event.PrevKv.Value)
+	}
+	return rows
+}
+
+// selectedColumnsChanged returns false if none of the columns selected by the updater was modified by the event, so
+// there is no delta to compute. An updater of all the columns always compares the whole rows.
+func (u *updater) selectedColumnsChanged(rows *eventRows) (bool, error) {
+	if u.mcr.Columns == nil {
+		return true, nil
+	}
+	data, err := rows.row.decode()
+	if err != nil {
+		return false, err
+	}
+	prevData, err := rows.prevRow.decode()
+	if err != nil {
+		return false, err
+	}
+	for _, column := range u.mcr.Columns {
+		if u.redacted[column] {
+			continue
+		}
+		if !reflect.DeepEqual(data[column], prevData[column]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// prepareRawRowUpdate returns the row update, whose row is set by the set function to the encoded selected columns of
+// the row, nil if none of the columns is selected
+func (u *updater) prepareRawRowUpdate(row *rowValue, set func(ru *ovsjson.RowUpdate, data json.RawMessage)) (*ovsjson.RowUpdate, string, error) {
+	data, columns, uuid, err := u.prepareRawRow(row)
+	if err != nil {
+		return nil, "", err
+	}
+	if columns == 0 && !u.uuidOnly() {
+		return nil, uuid, nil
+	}
+	rowUpdate := &ovsjson.RowUpdate{}
+	set(rowUpdate, data)
+	return rowUpdate, uuid, nil
+}
+
+// prepareRawRow returns the encoded selected columns of the row, their number and the row uuid, the columns are
+// filtered like by prepareRowValue, but on the token level of the etcd value
+func (u *updater) prepareRawRow(row *rowValue) (json.RawMessage, int, string, error) {
+	var uuidValue []byte
+	data, columns, err := ovsjson.FilterObject(row.value, func(column string, value []byte) bool {
+		if column == COL_UUID {
+			uuidValue = value
+			return false
+		}
+		return u.rawColumnSelected(column)
+	})
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if uuidValue == nil {
+		return nil, 0, "", fmt.Errorf("row doesn't contain %s", COL_UUID)
+	}
+	var uuid []string
+	if err := json.Unmarshal(uuidValue, &uuid); err != nil || len(uuid) != 2 {
+		return nil, 0, "", fmt.Errorf("wrong uuid type %s", uuidValue)
+	}
+	return data, columns, uuid[1], nil
+}
+
+func (u *updater) rawColumnSelected(column string) bool {
+	if u.redacted[column] || InternalColumns.StrippedForMonitor(column) {
+		return false
+	}
+	// nil columns means all the columns
+	if u.mcr.Columns == nil {
+		return true
+	}
+	for _, c := range u.mcr.Columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
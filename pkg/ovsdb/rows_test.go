@@ -0,0 +1,130 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func testModifyEvent(t *testing.T, name string, n int, prevName string, prevN int) *clientv3.Event {
+	kv := testMonitorCondRow(t, "u1", name, n, 2)
+	kv.CreateRevision = 1
+	return &clientv3.Event{Type: mvccpb.PUT, Kv: kv, PrevKv: testMonitorCondRow(t, "u1", prevName, prevN, 1)}
+}
+
+func TestEventRowsSharedByUpdaters(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	tableSchema, err := testMonitorCondSchemas()[DB_NAME].LookupTable("T1")
+	assert.Nil(t, err)
+	var where interface{}
+	assert.Nil(t, json.Unmarshal([]byte(`[["n", ">", 1]]`), &where))
+	names := mcrToUpdater(ovsjson.MonitorCondRequest{Columns: []string{"name"}, Where: where}, "names", tableSchema, false)
+	assert.Nil(t, names.compileCondition(klogr.New()))
+	all := mcrToUpdater(ovsjson.MonitorCondRequest{Where: where}, "all", tableSchema, true)
+	assert.Nil(t, all.compileCondition(klogr.New()))
+
+	rows := newEventRows(testModifyEvent(t, "a", 3, "a", 2))
+	rowUpdate, _, err := names.prepareEventRowUpdate(rows)
+	assert.Nil(t, err)
+	assert.Nil(t, rowUpdate)
+	rowUpdate, uuid, err := all.prepareEventRowUpdate(rows)
+	assert.Nil(t, err)
+	assert.Equal(t, "u1", uuid)
+	assert.Equal(t, &ovsjson.RowUpdate{New: &map[string]interface{}{"name": "a", "n": float64(3)},
+		Old: &map[string]interface{}{"n": float64(2)}}, rowUpdate)
+
+	// the decoded rows are not modified by the updaters
+	data, err := rows.row.decode()
+	assert.Nil(t, err)
+	assert.Len(t, data, 3)
+	assert.Contains(t, data, COL_UUID)
+	typed, err := rows.prevRow.decodeTyped(tableSchema)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, typed["n"])
+}
+
+func TestSelectedColumnsChanged(t *testing.T) {
+	tableSchema, err := testMonitorCondSchemas()[DB_NAME].LookupTable("T1")
+	assert.Nil(t, err)
+	for _, tc := range []struct {
+		name     string
+		columns  []string
+		redacted map[string]bool
+		event    *clientv3.Event
+		expected bool
+	}{
+		{"all columns", nil, nil, testModifyEvent(t, "a", 1, "a", 1), true},
+		{"selected changed", []string{"name", "n"}, nil, testModifyEvent(t, "a", 2, "a", 1), true},
+		{"unselected changed", []string{"name"}, nil, testModifyEvent(t, "a", 2, "a", 1), false},
+		{"redacted changed", []string{"name", "n"}, map[string]bool{"n": true}, testModifyEvent(t, "a", 2, "a", 1), false},
+		{"no columns", []string{}, nil, testModifyEvent(t, "b", 2, "a", 1), false},
+	} {
+		u := mcrToUpdater(ovsjson.MonitorCondRequest{Columns: tc.columns, Select: &libovsdb.MonitorSelect{}}, "",
+			tableSchema, false)
+		u.redacted = tc.redacted
+		changed, err := u.selectedColumnsChanged(newEventRows(tc.event))
+		assert.Nil(t, err, tc.name)
+		assert.Equal(t, tc.expected, changed, tc.name)
+	}
+}
+
+func TestRawRowEncoding(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	defer func(raw bool) { RawRowEncoding = raw }(RawRowEncoding)
+	tableSchema, err := testMonitorCondSchemas()[DB_NAME].LookupTable("T1")
+	assert.Nil(t, err)
+	tableKey := common.NewTableKey(DB_NAME, "T1")
+	updater := func(jsonValue string, columns []string, isV1 bool, redacted map[string]bool) updater {
+		u := mcrToUpdater(ovsjson.MonitorCondRequest{Columns: columns}, jsonValue, tableSchema, isV1)
+		u.redacted = redacted
+		return *u
+	}
+	key2Updaters := Key2Updaters{tableKey: {
+		updater("all", nil, false, nil),
+		updater("v1", []string{"name", COL_UUID}, true, nil),
+		updater("uuids", []string{}, false, nil),
+		updater("redacted", nil, false, map[string]bool{"n": true}),
+		// the updates of the two requests of the same monitor are merged
+		updater("merged", []string{"name"}, false, nil),
+		updater("merged", []string{"n"}, false, nil),
+	}}
+	versioned := testMonitorCondRow(t, "u2", "b", 2, 3)
+	versioned.Value = []byte(`{"_uuid": ["uuid", "u2"], "name": "b\"\\", "_version": ["uuid", "v1"], "n": 2}`)
+	events := []*clientv3.Event{
+		{Type: mvccpb.PUT, Kv: testMonitorCondRow(t, "u4", "a", 1, 2)},
+		{Type: mvccpb.PUT, Kv: versioned},
+		{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: []byte(common.NewDataKey(DB_NAME, "T1", "u3").String()), ModRevision: 3},
+			PrevKv: testMonitorCondRow(t, "u3", "c", 3, 1)},
+		testModifyEvent(t, "a", 2, "a", 1),
+	}
+	encode := func(raw bool) map[string]interface{} {
+		RawRowEncoding = raw
+		result, err := prepareUpdates(klogr.New(), DB_NAME, key2Updaters, events)
+		assert.Nil(t, err)
+		buf, err := json.Marshal(result)
+		assert.Nil(t, err)
+		decoded := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal(buf, &decoded))
+		return decoded
+	}
+	expected := encode(false)
+	assert.Equal(t, expected, encode(true))
+
+	RawRowEncoding = true
+	result, err := prepareUpdates(klogr.New(), DB_NAME, key2Updaters, events[1:2])
+	assert.Nil(t, err)
+	// the columns are spliced as they are stored
+	assert.Equal(t, `{"name":"b\"\\","_version":["uuid", "v1"],"n":2}`, string(result["all"]["T1"]["u2"].RawInsert))
+	assert.Equal(t, `{"name":"b\"\\"}`, string(result["v1"]["T1"]["u2"].RawNew))
+	assert.Equal(t, `{}`, string(result["uuids"]["T1"]["u2"].RawInsert))
+	assert.Nil(t, result["merged"]["T1"]["u2"].RawInsert)
+	assert.Equal(t, &map[string]interface{}{"name": "b\"\\", "n": float64(2)}, result["merged"]["T1"]["u2"].Insert)
+}
@@ -0,0 +1,100 @@
+package ovsdb
+
+import (
+	"context"
+	"io"
+
+	"github.com/creachadair/jrpc2"
+)
+
+// Scheduling classes of the client requests
+const (
+	// transactions and database conversions, which can run for a long time
+	SCHED_CLASS_TRANSACT = "transact"
+	// all the other requests: echo, monitors, locks, etc.
+	SCHED_CLASS_CONTROL = "control"
+)
+
+// MaxPendingRequests is the maximal number of the connection requests, which wait for a worker
+var MaxPendingRequests = 1024
+
+// transactMethods are the methods of the SCHED_CLASS_TRANSACT class
+var transactMethods = map[string]bool{
+	"transact": true,
+	"convert":  true,
+}
+
+// RequestScheduler assigns the requests of a single connection to separate worker pools by their class, so a client
+// issuing a huge transaction still gets timely echo and monitor responses on the same connection, e.g. its inactivity
+// probe doesn't fail. The requests of each class are executed by at most the given number of concurrent workers. The
+// jrpc2 server acquires its concurrency slot before the request waits for a worker, so the server concurrency should be
+// set by SchedulerConcurrency, otherwise waiting transactions can block the control requests.
+type RequestScheduler struct {
+	assigner jrpc2.Assigner
+	slots    map[string]chan struct{}
+	hints    *hintsWriter
+}
+
+func NewRequestScheduler(assigner jrpc2.Assigner, transactWorkers, controlWorkers int) *RequestScheduler {
+	return &RequestScheduler{
+		assigner: assigner,
+		slots: map[string]chan struct{}{
+			SCHED_CLASS_TRANSACT: make(chan struct{}, transactWorkers),
+			SCHED_CLASS_CONTROL:  make(chan struct{}, controlWorkers),
+		},
+	}
+}
+
+// SchedulerConcurrency returns the jrpc2 server concurrency of the connections served by a RequestScheduler
+func SchedulerConcurrency(transactWorkers, controlWorkers int) int {
+	return transactWorkers + controlWorkers + MaxPendingRequests
+}
+
+// SchedulingClass returns the scheduling class of the method
+func SchedulingClass(method string) string {
+	if transactMethods[method] {
+		return SCHED_CLASS_TRANSACT
+	}
+	return SCHED_CLASS_CONTROL
+}
+
+// Assign implements the jrpc2.Assigner interface
+func (s *RequestScheduler) Assign(ctx context.Context, method string) jrpc2.Handler {
+	h := s.assigner.Assign(ctx, method)
+	if h == nil {
+		return nil
+	}
+	return &scheduledHandler{handler: h, slots: s.slots[SchedulingClass(method)], hints: s.hints}
+}
+
+// HintsWriter returns the writer of the connection responses, which adds the hints of the rejected requests to their
+// errors (see hints.Details). It should be called before the connection is served.
+func (s *RequestScheduler) HintsWriter(writer io.WriteCloser) io.WriteCloser {
+	s.hints = newHintsWriter(writer)
+	return s.hints
+}
+
+// Names implements the jrpc2.Assigner interface
+func (s *RequestScheduler) Names() []string {
+	return s.assigner.Names()
+}
+
+type scheduledHandler struct {
+	handler jrpc2.Handler
+	slots   chan struct{}
+	hints   *hintsWriter
+}
+
+func (sh *scheduledHandler) Handle(ctx context.Context, req *jrpc2.Request) (interface{}, error) {
+	select {
+	case sh.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-sh.slots }()
+	result, err := sh.handler.Handle(ctx, req)
+	if err != nil && sh.hints != nil && !req.IsNotification() {
+		sh.hints.add(req.ID(), err)
+	}
+	return result, err
+}
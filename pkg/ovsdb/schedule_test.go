@@ -0,0 +1,69 @@
+package ovsdb
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulingClass(t *testing.T) {
+	assert.Equal(t, SCHED_CLASS_TRANSACT, SchedulingClass("transact"))
+	assert.Equal(t, SCHED_CLASS_TRANSACT, SchedulingClass("convert"))
+	assert.Equal(t, SCHED_CLASS_CONTROL, SchedulingClass("echo"))
+	assert.Equal(t, SCHED_CLASS_CONTROL, SchedulingClass("monitor_cond"))
+}
+
+func TestRequestSchedulerFairness(t *testing.T) {
+	release := make(chan struct{})
+	var running int32
+	var maxRunning int32
+	assigner := handler.Map{
+		"transact": handler.New(func(ctx context.Context) (string, error) {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			if n > atomic.LoadInt32(&maxRunning) {
+				atomic.StoreInt32(&maxRunning, n)
+			}
+			<-release
+			return "transact", nil
+		}),
+		"echo": handler.New(func(ctx context.Context) (string, error) {
+			return "echo", nil
+		}),
+	}
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(NewRequestScheduler(assigner, 1, 1), &jrpc2.ServerOptions{Concurrency: SchedulerConcurrency(1, 1), AllowV1: true}).Start(sch)
+	defer srv.Stop()
+	cli := jrpc2.NewClient(cch, &jrpc2.ClientOptions{AllowV1: true})
+	defer cli.Close()
+	ctx := context.Background()
+
+	done := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := cli.Call(ctx, "transact", nil)
+			done <- err
+		}()
+	}
+	// wait for the transactions to take the server concurrency slots
+	time.Sleep(50 * time.Millisecond)
+	// the echo is served while the transactions are running or waiting
+	echoCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var result string
+	assert.Nil(t, cli.CallResult(echoCtx, "echo", nil, &result))
+	assert.Equal(t, "echo", result)
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, <-done)
+	}
+	// the transactions don't exceed their workers
+	assert.Equal(t, int32(1), atomic.LoadInt32(&maxRunning))
+}
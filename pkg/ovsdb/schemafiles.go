@@ -0,0 +1,116 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// the version of a schema, <x>.<y>.<z> per RFC 7047
+var schemaVersionRegexp = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+
+// the cksum line of a schema file, which is excluded from the checksum, like the ovsdb build does
+var schemaCksumLineRegexp = regexp.MustCompile(`(?m)^[^\n]*"cksum": *"[0-9]+ [0-9]+",[^\n]*\n`)
+
+// SchemaValidationError lists the problems of the schema files, so all of them can be fixed at once
+type SchemaValidationError struct {
+	// every problem is prefixed by its file name
+	Problems []string
+}
+
+func (e *SchemaValidationError) Error() string {
+	return fmt.Sprintf("schema validation failed with %d problems:\n\t%s", len(e.Problems),
+		strings.Join(e.Problems, "\n\t"))
+}
+
+// ValidateSchemaFiles validates the schema files of the served databases: the files are valid JSON, their versions are
+// of the <x>.<y>.<z> format, their checksums, if they are present, match their content, the column types are
+// correct, and every database is defined once. The returned SchemaValidationError lists all the problems of all the
+// files, nil if they are valid.
+func ValidateSchemaFiles(files []string) error {
+	problems := []string{}
+	dbFiles := map[string]string{}
+	for _, file := range files {
+		schema, fileProblems := validateSchemaFile(file)
+		for _, problem := range fileProblems {
+			problems = append(problems, file+": "+problem)
+		}
+		if schema == nil || schema.Name == "" {
+			continue
+		}
+		if other, ok := dbFiles[schema.Name]; ok {
+			problems = append(problems, fmt.Sprintf("%s: database %s is already defined by %s", file, schema.Name, other))
+			continue
+		}
+		dbFiles[schema.Name] = file
+	}
+	if len(problems) > 0 {
+		return &SchemaValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validateSchemaFile returns the schema of the file, nil if it cannot be parsed, and its problems
+func validateSchemaFile(file string) (*libovsdb.DatabaseSchema, []string) {
+	data, err := common.ReadFile(file)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	problems := []string{}
+	header := struct {
+		Cksum string `json:"cksum"`
+	}{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, []string{fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	schema := &libovsdb.DatabaseSchema{}
+	if err := json.Unmarshal(data, schema); err != nil {
+		// the column types are parsed by the schema decoder
+		return nil, []string{fmt.Sprintf("invalid schema: %v", err)}
+	}
+	if schema.Version != "" && !schemaVersionRegexp.MatchString(schema.Version) {
+		problems = append(problems, fmt.Sprintf("version %q, should be of the <x>.<y>.<z> format", schema.Version))
+	}
+	if header.Cksum != "" {
+		if expected := SchemaChecksum(data); header.Cksum != expected {
+			problems = append(problems, fmt.Sprintf("cksum %q doesn't match the schema content, whose cksum is %q",
+				header.Cksum, expected))
+		}
+	}
+	return schema, append(problems, schemaProblems(schema)...)
+}
+
+// SchemaChecksum returns the checksum of the schema file content, in the "<crc> <length>" format of the POSIX cksum
+// utility, which is computed without the cksum line, like the ovsdb build verifies the schema files
+func SchemaChecksum(data []byte) string {
+	data = schemaCksumLineRegexp.ReplaceAll(data, nil)
+	return strconv.FormatUint(uint64(posixCksum(data)), 10) + " " + strconv.Itoa(len(data))
+}
+
+// posixCksum returns the CRC of the POSIX cksum utility: CRC-32 of the data followed by its length, without
+// reflection, and complemented
+func posixCksum(data []byte) uint32 {
+	var crc uint32
+	update := func(b byte) {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	for _, b := range data {
+		update(b)
+	}
+	for n := len(data); n > 0; n >>= 8 {
+		update(byte(n))
+	}
+	return ^crc
+}
@@ -0,0 +1,70 @@
+package ovsdb
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaChecksum(t *testing.T) {
+	// the checksums of the ovn schemas are computed by the ovsdb build
+	for file, cksum := range map[string]string{
+		"../../schemas/ovn-nb.ovsschema": "2352750632 28701",
+		"../../schemas/ovn-sb.ovsschema": "669123379 26536",
+	} {
+		data, err := ioutil.ReadFile(file)
+		if assert.Nil(t, err) {
+			assert.Equal(t, cksum, SchemaChecksum(data), file)
+		}
+	}
+}
+
+func TestValidateSchemaFiles(t *testing.T) {
+	assert.Nil(t, ValidateSchemaFiles([]string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema",
+		"../../schemas/ovn-sb.ovsschema"}))
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		assert.Nil(t, ioutil.WriteFile(file, []byte(content), 0644))
+		return file
+	}
+	valid := write("valid.ovsschema", testSchemaConvertOldJSON)
+	files := []string{
+		valid,
+		write("duplicate.ovsschema", testSchemaConvertNewJSON),
+		write("version.ovsschema", `{"name": "version", "version": "1.0", "tables": {
+			"table1": {"columns": {"key1": {"type": {"key": "string", "min": 2}}}}}}`),
+		write("cksum.ovsschema", `{"name": "cksum", "version": "1.0.0", "cksum": "1 2",
+			"tables": {"table1": {"columns": {"key1": {"type": "string"}}}}}`),
+		write("type.ovsschema", `{"name": "type", "version": "1.0.0", "tables": {
+			"table1": {"columns": {"key1": {"type": "text"}}}}}`),
+		write("json.ovsschema", `{"name": "json",`),
+		filepath.Join(dir, "missing.ovsschema"),
+	}
+	err := ValidateSchemaFiles(files)
+	if !assert.NotNil(t, err) {
+		return
+	}
+	validationErr, ok := err.(*SchemaValidationError)
+	if !assert.True(t, ok) {
+		return
+	}
+	// all the problems of all the files are reported
+	assert.Equal(t, 7, len(validationErr.Problems), err.Error())
+	for i, contains := range []string{
+		"duplicate.ovsschema: database convert is already defined by " + valid,
+		"version.ovsschema: version \"1.0\", should be of the <x>.<y>.<z> format",
+		"version.ovsschema: [table table1 column key1] min 2, should be 0 or 1",
+		"cksum.ovsschema: cksum \"1 2\" doesn't match the schema content",
+		"type.ovsschema: invalid schema",
+		"json.ovsschema: invalid JSON",
+		"missing.ovsschema",
+	} {
+		if i < len(validationErr.Problems) {
+			assert.Contains(t, validationErr.Problems[i], contains)
+		}
+	}
+}
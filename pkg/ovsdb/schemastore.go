@@ -0,0 +1,260 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// The canonical schema of a database is stored in etcd, so all the server replicas, which serve the database, serve
+// the same schema, and a conversion of the database by one replica is adopted by the others without a restart. On
+// startup a replica negotiates the schema of its schema file with the stored schema by their versions: a newer schema
+// file replaces the stored schema, while an older or equal one is superseded by the stored schema, e.g. after the
+// database was converted. The stored schema is read again by get_schema, and the replicas watch the stored schemas.
+
+// the number of attempts to store a schema, while other replicas store it concurrently
+const storeSchemaAttempts = 3
+
+// negotiateSchema returns the canonical schema of the database and its mod revision, the given schema of the schema
+// file is stored, if there is no stored schema, or if its version is newer than the version of the stored schema
+func negotiateSchema(ctx context.Context, cli *clientv3.Client, dbName string, data []byte) ([]byte, int64, error) {
+	key := common.NewSchemaKey(dbName).String()
+	for attempt := 0; attempt < storeSchemaAttempts; attempt++ {
+		resp, err := cli.Get(ctx, key)
+		if err != nil {
+			return nil, 0, err
+		}
+		var cmp clientv3.Cmp
+		if len(resp.Kvs) == 0 {
+			cmp = clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+		} else {
+			stored := resp.Kvs[0]
+			newer, err := isNewerSchema(data, stored.Value)
+			if err != nil {
+				return nil, 0, err
+			}
+			if !newer {
+				if fileCksum, storedCksum := schemaCksum(data), schemaCksum(stored.Value); fileCksum != storedCksum {
+					klog.Infof("database %s, the stored schema %s (cksum %s) is served instead of the schema file %s "+
+						"(cksum %s)", dbName, schemaVersion(stored.Value), storedCksum, schemaVersion(data), fileCksum)
+				}
+				return stored.Value, stored.ModRevision, nil
+			}
+			klog.Infof("database %s, the schema file %s replaces the stored schema %s", dbName, schemaVersion(data),
+				schemaVersion(stored.Value))
+			cmp = clientv3.Compare(clientv3.ModRevision(key), "=", stored.ModRevision)
+		}
+		txnResp, err := cli.Txn(ctx).If(cmp).Then(clientv3.OpPut(key, string(data))).Commit()
+		if err != nil {
+			return nil, 0, err
+		}
+		if txnResp.Succeeded {
+			return data, txnResp.Header.Revision, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("the schema of database %s is modified concurrently", dbName)
+}
+
+// isNewerSchema returns whether the version of the schema is newer than the version of the stored schema
+func isNewerSchema(data, stored []byte) (bool, error) {
+	version, err := parseSchemaVersion(schemaVersion(data))
+	if err != nil {
+		return false, err
+	}
+	storedVersion, err := parseSchemaVersion(schemaVersion(stored))
+	if err != nil {
+		return false, fmt.Errorf("stored schema: %v", err)
+	}
+	for i := range version {
+		if version[i] != storedVersion[i] {
+			return version[i] > storedVersion[i], nil
+		}
+	}
+	return false, nil
+}
+
+func parseSchemaVersion(version string) ([3]int, error) {
+	var parsed [3]int
+	if !schemaVersionRegexp.MatchString(version) {
+		return parsed, fmt.Errorf("wrong schema version %q", version)
+	}
+	for i, part := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return parsed, fmt.Errorf("wrong schema version %q: %v", version, err)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+func schemaVersion(data []byte) string {
+	header := struct {
+		Version string `json:"version"`
+	}{}
+	json.Unmarshal(data, &header)
+	return header.Version
+}
+
+// schemaCksum returns the cksum of the schema, its checksum if it has no cksum
+func schemaCksum(data []byte) string {
+	header := struct {
+		Cksum string `json:"cksum"`
+	}{}
+	json.Unmarshal(data, &header)
+	if header.Cksum == "" {
+		return SchemaChecksum(data)
+	}
+	return header.Cksum
+}
+
+// parseSchemaMap returns the schema, as it's returned by get_schema, with its version and cksum
+func parseSchemaMap(data []byte) (map[string]interface{}, error) {
+	schemaMap := map[string]interface{}{}
+	if err := json.Unmarshal(data, &schemaMap); err != nil {
+		return nil, err
+	}
+	if _, ok := schemaMap["cksum"]; !ok {
+		schemaMap["cksum"] = SchemaChecksum(data)
+	}
+	return schemaMap, nil
+}
+
+// ReadSchema returns the canonical schema of the database from etcd, the schema, which was stored by another replica,
+// e.g. by a conversion of the database, replaces the schema of the replica. Returns whether the schema was replaced.
+func (con *DatabaseEtcd) ReadSchema(ctx context.Context, dbName string) (map[string]interface{}, bool, error) {
+	con.mu.Lock()
+	current, ok := con.strSchemas[dbName]
+	con.mu.Unlock()
+	// the _Server schema is not stored
+	if !ok || dbName == INT_SERVER {
+		return current, false, nil
+	}
+	tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+	defer cancel()
+	resp, err := con.cli.Get(tctx, common.NewSchemaKey(dbName).String())
+	if err != nil {
+		return nil, false, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, false, fmt.Errorf("missing stored schema of database %s", dbName)
+	}
+	return con.adoptSchema(dbName, resp.Kvs[0].Value, resp.Kvs[0].ModRevision)
+}
+
+// adoptSchema replaces the schema of the database by the stored schema, unless it's already adopted
+func (con *DatabaseEtcd) adoptSchema(dbName string, data []byte, revision int64) (map[string]interface{}, bool, error) {
+	con.mu.Lock()
+	current, currentRevision := con.strSchemas[dbName], con.schemaRevisions[dbName]
+	con.mu.Unlock()
+	if revision <= currentRevision {
+		return current, false, nil
+	}
+	schemaMap, err := parseSchemaMap(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("stored schema of database %s: %v", dbName, err)
+	}
+	schema := &libovsdb.DatabaseSchema{}
+	if err := json.Unmarshal(data, schema); err != nil {
+		return nil, false, fmt.Errorf("stored schema of database %s: %v", dbName, err)
+	}
+	if schema.Name != dbName {
+		return nil, false, fmt.Errorf("stored schema of database %s, wrong name %s", dbName, schema.Name)
+	}
+	// waits for the in-flight transactions and conversion of the database on this replica
+	con.DbLock(dbName)
+	defer con.DbUnlock(dbName)
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	if revision <= con.schemaRevisions[dbName] {
+		return con.strSchemas[dbName], false, nil
+	}
+	con.schemaRevisions[dbName] = revision
+	// e.g. the schema was stored by the conversion of this replica
+	if reflect.DeepEqual(schemaMap, con.strSchemas[dbName]) {
+		return con.strSchemas[dbName], false, nil
+	}
+	con.setSchema(schema)
+	con.strSchemas[dbName] = schemaMap
+	klog.Infof("database %s, adopted the stored schema %s", dbName, schema.Version)
+	return schemaMap, true, nil
+}
+
+// WatchSchemas adopts the schemas, which are stored by the other replicas, in the background until the context is
+// done, the changed function is called with the name of the database, whose schema was replaced
+func (con *DatabaseEtcd) WatchSchemas(ctx context.Context, changed func(dbName string)) {
+	prefix := common.NewTableKey(common.INTERNAL_DB, common.SCHEMAS).String()
+	go func() {
+		for ctx.Err() == nil {
+			wctx, wcancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+			wch := con.cli.Watch(wctx, prefix, clientv3.WithPrefix())
+			// the schemas, which were stored before the watch started, are read
+			con.readSchemas(ctx, changed)
+			for wresp := range wch {
+				if err := wresp.Err(); err != nil {
+					klog.Errorf("schemas watch: %v", err)
+					break
+				}
+				for _, ev := range wresp.Events {
+					if ev.Type != clientv3.EventTypePut {
+						continue
+					}
+					key, err := common.ParseKey(string(ev.Kv.Key))
+					if err != nil {
+						continue
+					}
+					dbName := common.UnescapeKeyID(key.UUID)
+					con.mu.Lock()
+					_, served := con.strSchemas[dbName]
+					con.mu.Unlock()
+					if !served {
+						continue
+					}
+					_, replaced, err := con.adoptSchema(dbName, ev.Kv.Value, ev.Kv.ModRevision)
+					if err != nil {
+						klog.Errorf("schemas watch: %v", err)
+						continue
+					}
+					if replaced {
+						changed(dbName)
+					}
+				}
+			}
+			wcancel()
+			// the watch is restarted, e.g. after etcd lost its leader
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+}
+
+func (con *DatabaseEtcd) readSchemas(ctx context.Context, changed func(dbName string)) {
+	con.mu.Lock()
+	dbNames := make([]string, 0, len(con.strSchemas))
+	for dbName := range con.strSchemas {
+		dbNames = append(dbNames, dbName)
+	}
+	con.mu.Unlock()
+	for _, dbName := range dbNames {
+		_, replaced, err := con.ReadSchema(ctx, dbName)
+		if err != nil {
+			klog.Errorf("read schema of database %s: %v", dbName, err)
+			continue
+		}
+		if replaced {
+			changed(dbName)
+		}
+	}
+}
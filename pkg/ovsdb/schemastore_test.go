@@ -0,0 +1,80 @@
+package ovsdb
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func TestSchemaNegotiation(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeSchema := func(version string) string {
+		file := filepath.Join(dir, version+".ovsschema")
+		schema := strings.Replace(testSchemaConvertOldJSON, `"version": "0.0.0"`, `"version": "`+version+`"`, 1)
+		assert.Nil(t, ioutil.WriteFile(file, []byte(schema), 0644))
+		return file
+	}
+	addSchema := func(file string) *DatabaseEtcd {
+		db, _ := NewDatabaseEtcd(cli)
+		assert.Nil(t, db.AddSchema(file))
+		return db.(*DatabaseEtcd)
+	}
+
+	// the schema of the first replica is stored
+	first := addSchema(writeSchema("1.1.0"))
+	schema, replaced, err := first.ReadSchema(ctx, "convert")
+	assert.Nil(t, err)
+	assert.False(t, replaced)
+	assert.Equal(t, "1.1.0", schema["version"])
+	assert.Equal(t, SchemaChecksum([]byte(strings.Replace(testSchemaConvertOldJSON, "0.0.0", "1.1.0", 1))),
+		schema["cksum"])
+
+	// a replica of an older schema file serves the stored schema
+	second := addSchema(writeSchema("1.0.9"))
+	assert.Equal(t, "1.1.0", second.GetSchemas()["convert"].Version)
+	assert.Equal(t, "1.1.0", second.GetSchema("convert")["version"])
+
+	// a newer schema file replaces the stored schema, which is adopted by the other replicas
+	third := addSchema(writeSchema("1.10.0"))
+	assert.Equal(t, "1.10.0", third.GetSchemas()["convert"].Version)
+	schema, replaced, err = first.ReadSchema(ctx, "convert")
+	assert.Nil(t, err)
+	assert.True(t, replaced)
+	assert.Equal(t, "1.10.0", schema["version"])
+	assert.Equal(t, "1.10.0", first.GetSchemas()["convert"].Version)
+	_, replaced, err = first.ReadSchema(ctx, "convert")
+	assert.Nil(t, err)
+	assert.False(t, replaced)
+
+	// the watch adopts the stored schema
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	changed := make(chan string, 1)
+	second.WatchSchemas(wctx, func(dbName string) { changed <- dbName })
+	select {
+	case dbName := <-changed:
+		assert.Equal(t, "convert", dbName)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the stored schema is not adopted")
+	}
+	assert.Equal(t, "1.10.0", second.GetSchemas()["convert"].Version)
+
+	schema, _, err = first.ReadSchema(ctx, "unknown")
+	assert.Nil(t, err)
+	assert.Nil(t, schema)
+}
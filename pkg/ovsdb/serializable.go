@@ -0,0 +1,28 @@
+package ovsdb
+
+import (
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+// The write transactions of a database are serialized by the database lock of their server, while the transactions of
+// several server replicas, which serve the same database, are guarded only by the revisions of the rows matched by
+// their operations, and by the absence of the rows, which would match the operations that matched none. So a
+// transaction can commit, although another replica concurrently modified a row to match one of its operations, which
+// matched other rows, or a row read by its referential integrity checks. In the serializable mode, every write
+// transaction of the database modifies its commit counter key, and is guarded by the counter revision: it commits only
+// if no other write transaction of the database committed after the transaction read its rows, otherwise it fails with
+// the transaction conflict error, and is executed again on the current rows, as the transactions, which conflict on
+// their modified rows.
+
+// addCommitCounter guards a write transaction of a serializable database by its commit counter, and modifies the
+// counter
+func (txn *Transaction) addCommitCounter() {
+	if !txn.serializable || txn.readRevision == 0 || txn.etcd.isReadOnly() {
+		return
+	}
+	key := common.NewCommitCounterKey(txn.request.DBName).String()
+	txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.ModRevision(key), "<", txn.readRevision+1))
+	txn.etcd.Then = append(txn.etcd.Then, clientv3.OpPut(key, ""))
+}
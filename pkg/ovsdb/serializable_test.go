@@ -0,0 +1,107 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+func TestSerializableTransactions(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	// the client of another replica, its calls are not faulty
+	other, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer other.Close()
+	fi := NewFaultInjector()
+	defer fi.Inject(cli)()
+
+	transact := func(cli *clientv3.Client, serializable bool, operations string) error {
+		var ops []libovsdb.Operation
+		assert.Nil(t, json.Unmarshal([]byte(operations), &ops))
+		txn := NewTransaction(cli, klogr.New(), &libovsdb.Transact{DBName: "rbac", Operations: ops})
+		txn.AddSchema(testSchemaRBAC(t))
+		txn.serializable = serializable
+		_, err := txn.Commit()
+		return err
+	}
+	setHostname := func(serializable bool, hostname string) {
+		assert.Nil(t, transact(other, serializable, `[{"op": "update", "table": "Chassis",
+			"where": [["name", "==", "ch1"]], "row": {"hostname": "`+hostname+`"}}]`))
+	}
+	// inserts a chassis, if ch1 is still on h1
+	insertChassis := func(serializable bool, name string) error {
+		return transact(cli, serializable, `[
+			{"op": "wait", "table": "Chassis", "timeout": 0, "where": [["name", "==", "ch1"]], "columns": ["hostname"],
+				"until": "==", "rows": [{"hostname": "h1"}]},
+			{"op": "insert", "table": "Chassis", "row": {"name": "`+name+`"}}]`)
+	}
+	chassis := func() int64 {
+		resp, err := other.Get(context.Background(), common.NewTableKey("rbac", "Chassis").String(),
+			clientv3.WithPrefix(), clientv3.WithCountOnly())
+		assert.Nil(t, err)
+		return resp.Count
+	}
+	counterVersion := func() int64 {
+		resp, err := other.Get(context.Background(), common.NewCommitCounterKey("rbac").String())
+		assert.Nil(t, err)
+		if len(resp.Kvs) == 0 {
+			return 0
+		}
+		return resp.Kvs[0].Version
+	}
+	assert.Nil(t, transact(other, true, `[{"op": "insert", "table": "Chassis",
+		"row": {"name": "ch1", "hostname": "h1"}}]`))
+	assert.Equal(t, int64(1), counterVersion())
+
+	// the rows matched by the wait are guarded without the serializable mode as well, but the counter isn't modified
+	fi.Add(Fault{Op: FAULT_OP_TXN, Skip: 1, Times: 1, Before: func() { setHostname(false, "h2") }})
+	err = insertChassis(false, "ch2")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, E_TIMEOUT, err.Error())
+	}
+	assert.Equal(t, int64(1), chassis())
+	assert.Equal(t, int64(1), counterVersion())
+	setHostname(false, "h1")
+
+	// a serializable transaction is executed again after a concurrent serializable transaction, and its wait fails
+	fi.Reset()
+	fi.Add(Fault{Op: FAULT_OP_TXN, Skip: 1, Times: 1, Before: func() { setHostname(true, "h2") }})
+	err = insertChassis(true, "ch3")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, E_TIMEOUT, err.Error())
+	}
+	assert.Equal(t, int64(1), chassis())
+	assert.Equal(t, int64(2), counterVersion())
+
+	// the read only transactions don't modify the counter
+	fi.Reset()
+	setHostname(true, "h1")
+	assert.Nil(t, transact(cli, true, `[{"op": "select", "table": "Chassis", "where": []}]`))
+	assert.Equal(t, int64(3), counterVersion())
+	assert.Nil(t, insertChassis(true, "ch3"))
+	assert.Equal(t, int64(2), chassis())
+	assert.Equal(t, int64(4), counterVersion())
+
+	// the concurrent transactions of all the executions fail the transaction
+	fi.Add(Fault{Op: FAULT_OP_TXN, Skip: 1, Before: func() { setHostname(true, "h1") }})
+	err = insertChassis(true, "ch4")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, E_TXN_CONFLICT, err.Error())
+	}
+	assert.Equal(t, int64(2), chassis())
+}
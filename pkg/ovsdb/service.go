@@ -2,7 +2,10 @@ package ovsdb
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
+
 	"github.com/google/uuid"
 	"github.com/ibm/ovsdb-etcd/pkg/common"
 	"k8s.io/klog/v2"
@@ -37,6 +40,7 @@ type Servicer interface {
 	// 		"result": null
 	//      "error": "unknown database"
 	//      "id": same "id" as request
+	// The schema is the canonical schema of the database, which is stored in etcd, including its version and cksum.
 	GetSchema(ctx context.Context, param interface{}) (interface{}, error)
 
 	// RFC 7047 section 4.1.3
@@ -202,7 +206,15 @@ type Servicer interface {
 	// Converts an online database from one schema to another. The request contains the following members:
 	//
 	// 		"params": [<db-name>, <database-schema>]
-	Convert(ctx context.Context, param interface{}) (interface{}, error)
+	Convert(ctx context.Context, param []interface{}) (interface{}, error)
+
+	// ovsdb-etcd extension
+	// Returns the server build information and the versions of the served schemas.
+	// "params": []
+	// The response object contains the following members:
+	//		"result": {"server-id": <server_id>, "version": <string>, "git-commit": <string>, "go-version": <string>,
+	//			"schemas": {<db-name>: <schema-version>, ...}}
+	GetServerInfo(ctx context.Context) (interface{}, error)
 }
 
 const (
@@ -213,6 +225,8 @@ const (
 type Service struct {
 	db   Databaser
 	uuid string
+	// called after a database was converted to a new schema
+	databaseChanged func(ctx context.Context, dbName string)
 }
 
 func (s *Service) ListDbs(ctx context.Context, param interface{}) ([]string, error) {
@@ -222,12 +236,16 @@ func (s *Service) ListDbs(ctx context.Context, param interface{}) ([]string, err
 		return nil, err
 	}
 	dbs := []string{}
+	schemas := s.db.GetSchemas()
 	for _, kv := range resp.Kvs {
 		key, err := common.ParseKey(string(kv.Key))
 		if err != nil {
 			return nil, err
 		}
-		dbs = append(dbs, key.UUID)
+		// the _Server rows of the databases, which are served by other servers of the same prefix, are skipped
+		if _, ok := schemas[key.UUID]; ok {
+			dbs = append(dbs, key.UUID)
+		}
 	}
 	klog.V(5).Infof("ListDbs returned %v", dbs)
 	return dbs, nil
@@ -248,10 +266,20 @@ func (s *Service) GetSchema(ctx context.Context, param interface{}) (interface{}
 		// probably is a bad idea
 		schemaName = fmt.Sprintf("%s", param)
 	}
-	schema := s.db.GetSchema(schemaName)
+	dbName := ResolveDatabaseName(s.db.GetSchemas(), schemaName)
+	// the schema is read from etcd, so all the replicas serve the same schema
+	schema, replaced, err := s.db.ReadSchema(ctx, dbName)
+	if err != nil {
+		klog.Errorf("GetSchema of %s failed to read the stored schema: %v", dbName, err)
+		schema = s.db.GetSchema(dbName)
+	}
 	if schema == nil {
 		return nil, fmt.Errorf("unknown database")
 	}
+	// the database was converted by another replica
+	if replaced && s.databaseChanged != nil {
+		s.databaseChanged(ctx, dbName)
+	}
 	return schema, nil
 }
 
@@ -260,9 +288,54 @@ func (s *Service) GetServerId(ctx context.Context) string {
 	return s.uuid
 }
 
-func (s *Service) Convert(ctx context.Context, param interface{}) (interface{}, error) {
+func (s *Service) GetServerInfo(ctx context.Context) (interface{}, error) {
+	klog.V(5).Infof("GetServerInfo request")
+	info := ServerInfo{ServerID: s.uuid, BuildInfo: GetBuildInfo(), Schemas: map[string]string{}}
+	for name, schema := range s.db.GetSchemas() {
+		info.Schemas[name] = schema.Version
+	}
+	return info, nil
+}
+
+func (s *Service) Convert(ctx context.Context, param []interface{}) (interface{}, error) {
 	klog.V(5).Infof("Convert request, parameters %v", param)
-	return "{Convert}", nil
+	if len(param) != 2 {
+		return nil, fmt.Errorf("wrong number of parameters %d", len(param))
+	}
+	dbName, ok := param[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong database name %v", param[0])
+	}
+	data, err := json.Marshal(param[1])
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("wrong schema: %v", err)
+	}
+	if header.Name != dbName {
+		return nil, fmt.Errorf("database name mismatch %q != %q", dbName, header.Name)
+	}
+	report, err := s.db.Convert(ctx, data)
+	if err != nil {
+		if report != nil && len(report.Violations) > 0 {
+			err = fmt.Errorf("%v: %s", err, strings.Join(report.Violations, "; "))
+		}
+		klog.Errorf("Convert database %s: %v", dbName, err)
+		return nil, err
+	}
+	if s.databaseChanged != nil {
+		s.databaseChanged(ctx, dbName)
+	}
+	return ovsjson.EmptyStruct{}, nil
+}
+
+// SetDatabaseChangeHandler sets the function, which is called after a database was converted to a new schema
+func (s *Service) SetDatabaseChangeHandler(databaseChanged func(ctx context.Context, dbName string)) {
+	s.databaseChanged = databaseChanged
 }
 
 func NewService(db Databaser) *Service {
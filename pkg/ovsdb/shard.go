@@ -0,0 +1,202 @@
+package ovsdb
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+// WatchShards is the number of goroutines, which process the events of a database watch. The events are assigned to
+// the shards by the hash of their table name, so the updates of a giant table are computed in parallel with the
+// updates of the other tables, the updates are still delivered in revision order. 1 disables the sharding.
+var WatchShards = 1
+
+// the capacity of the events queue of a shard
+const shardQueueSize = 1024
+
+// shardRevision holds the results of the shards, which process the events of a single revision
+type shardRevision struct {
+	revision int64
+	received time.Time
+	// the shards, which process events of the revision
+	shards map[int]bool
+	// number of the shards, which didn't finish yet
+	remaining int
+	result    map[string]ovsjson.TableUpdates
+	// the events of the revision, the updates are coalesced with them
+	events []*clientv3.Event
+}
+
+type shardBatch struct {
+	rev    *shardRevision
+	events []*clientv3.Event
+}
+
+// watchShards dispatches the events of a watch response to the shards, and merges their results back in revision
+// order. A revision is delivered after all its shards finished, and after all the earlier revisions were delivered,
+// so the updates are sent in revision order, and the updates of a transaction are sent together. The revisions, which
+// complete before an earlier one, are held back until it completes.
+type watchShards struct {
+	m      *dbMonitor
+	queues []chan shardBatch
+
+	mu sync.Mutex
+	// undelivered revisions, ordered by revision
+	pending []*shardRevision
+	// a shard goroutine delivers the ready revisions, the others leave their revisions to it
+	delivering bool
+}
+
+func newWatchShards(m *dbMonitor, n int) *watchShards {
+	ws := &watchShards{m: m, queues: make([]chan shardBatch, n)}
+	for i := range ws.queues {
+		ws.queues[i] = make(chan shardBatch, shardQueueSize)
+	}
+	return ws
+}
+
+func (ws *watchShards) start() {
+	for i := range ws.queues {
+		go ws.run(ws.queues[i])
+	}
+}
+
+func shardOf(table string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(table))
+	return int(h.Sum32() % uint32(n))
+}
+
+// dispatch splits the events between the shards, it should be called by the watch goroutine in revision order
+func (ws *watchShards) dispatch(events []*clientv3.Event, revision int64, received time.Time) {
+	if len(events) == 0 {
+		return
+	}
+	batches := map[int][]*clientv3.Event{}
+	for _, ev := range events {
+		if ev.Kv == nil {
+			continue
+		}
+		key, err := common.ParseKey(string(ev.Kv.Key))
+		if err != nil {
+			eventLog.Error(ws.m.log, err, EVENT_LOG_PARSE_KEY_ERROR, ws.m.dataBaseName, "parseKey failed", "key", string(ev.Kv.Key))
+			continue
+		}
+		shard := shardOf(key.TableName, len(ws.queues))
+		batches[shard] = append(batches[shard], ev)
+	}
+	rev := &shardRevision{
+		revision:  revision,
+		received:  received,
+		shards:    map[int]bool{},
+		remaining: len(batches),
+		result:    map[string]ovsjson.TableUpdates{},
+		events:    events,
+	}
+	for shard := range batches {
+		rev.shards[shard] = true
+	}
+	// the revision is checked and added to the pending ones at once, so a checked revision is either delivered or
+	// pending
+	ws.mu.Lock()
+	if !ws.m.revChecker.isNewRevision(revision) || len(batches) == 0 {
+		ws.mu.Unlock()
+		return
+	}
+	ws.pending = append(ws.pending, rev)
+	ws.mu.Unlock()
+	for shard, batch := range batches {
+		select {
+		case ws.queues[shard] <- shardBatch{rev: rev, events: batch}:
+		case <-ws.m.watchCtx.Done():
+			return
+		}
+	}
+}
+
+// delivered returns the last dispatched revision, and whether all the dispatched revisions were delivered
+func (ws *watchShards) delivered() (int64, bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.m.revChecker.current(), len(ws.pending) == 0
+}
+
+func (ws *watchShards) run(queue chan shardBatch) {
+	for {
+		select {
+		case <-ws.m.watchCtx.Done():
+			return
+		case batch := <-queue:
+			ws.m.condMu.RLock()
+			sample := allocStart()
+			result, err := ws.m.prepareTableUpdate(batch.events)
+			allocEnd(ALLOC_STAGE_DIFF, sample)
+			if err != nil {
+				ws.m.log.Error(err, "prepareTableUpdate failed")
+			}
+			ws.done(batch.rev, result)
+			ws.m.condMu.RUnlock()
+		}
+	}
+}
+
+// done merges the result of a shard, and delivers the revisions, which are ready
+func (ws *watchShards) done(rev *shardRevision, result map[string]ovsjson.TableUpdates) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	// the shards process different tables, so their table updates don't overlap
+	for jsonValue, tableUpdates := range result {
+		merged, ok := rev.result[jsonValue]
+		if !ok {
+			rev.result[jsonValue] = tableUpdates
+			continue
+		}
+		for table, tableUpdate := range tableUpdates {
+			merged[table] = tableUpdate
+		}
+	}
+	rev.remaining--
+	if ws.delivering {
+		return
+	}
+	ws.delivering = true
+	// the revisions are delivered without the lock, as the notification can block on a slow client
+	for {
+		ready := 0
+		for ready < len(ws.pending) && ws.pending[ready].remaining == 0 {
+			ready++
+		}
+		if ready == 0 {
+			ws.delivering = false
+			return
+		}
+		revs := append([]*shardRevision{}, ws.pending[:ready]...)
+		n := copy(ws.pending, ws.pending[ready:])
+		for i := n; i < len(ws.pending); i++ {
+			ws.pending[i] = nil
+		}
+		ws.pending = ws.pending[:n]
+		ws.mu.Unlock()
+		for _, r := range revs {
+			ws.deliver(r)
+		}
+		ws.mu.Lock()
+	}
+}
+
+func (ws *watchShards) deliver(rev *shardRevision) {
+	if len(rev.result) == 0 {
+		ws.m.log.V(5).Info("there is nothing to notify", "revision", rev.revision)
+		return
+	}
+	for jsonValue, tableUpdates := range rev.result {
+		ws.m.log.V(7).Info("notify", "table-update", tableUpdates)
+		event := notificationEvent{updates: tableUpdates, revision: rev.revision, trace: newTrace(rev.received), events: rev.events}
+		ws.m.handler.notify(ws.m, jsonValue, event)
+	}
+}
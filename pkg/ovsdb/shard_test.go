@@ -0,0 +1,89 @@
+package ovsdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func TestWatchShardsOrderedMerge(t *testing.T) {
+	// T1 and T2 are processed by different shards
+	assert.NotEqual(t, shardOf("T1", 2), shardOf("T2", 2))
+	columns := map[string]*libovsdb.ColumnSchema{"c1": {Type: libovsdb.TypeString}}
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {Columns: columns}, "T2": {Columns: columns}},
+	}}
+	msg := `["dbName", "monid", {"T1": [{"columns": ["c1"]}], "T2": [{"columns": ["c1"]}]}]`
+	handler := initHandler(t, schemas, msg, ovsjson.Update2)
+	monitor := handler.monitors[DB_NAME]
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	monitor.watchCtx = ctx
+	ws := newWatchShards(monitor, 2)
+
+	received := make(chan notificationEvent, 10)
+	go func() {
+		hmd := handler.handlerMonitorData[jsonValueToString("monid")]
+		for {
+			ev, ok := hmd.notifications.next(ctx)
+			if !ok {
+				return
+			}
+			received <- ev
+		}
+	}()
+	event := func(table string, revision int64) *clientv3.Event {
+		value := prepareData(t, map[string]interface{}{"c1": table}, true)
+		return &clientv3.Event{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{Key: []byte("ovsdb/nb/dbName/" + table + "/000"),
+			Value: value, CreateRevision: revision, ModRevision: revision}}
+	}
+	process := func(table string) {
+		batch := <-ws.queues[shardOf(table, 2)]
+		result, err := monitor.prepareTableUpdate(batch.events)
+		assert.Nil(t, err)
+		ws.done(batch.rev, result)
+	}
+	expect := func(revision int64, tables ...string) {
+		select {
+		case ev := <-received:
+			assert.Equal(t, revision, ev.revision)
+			assert.Equal(t, len(tables), len(ev.updates))
+			for _, table := range tables {
+				assert.Contains(t, ev.updates, table)
+			}
+		case <-time.After(time.Second):
+			assert.Fail(t, "notification was not delivered", "revision", revision)
+		}
+	}
+
+	ws.dispatch([]*clientv3.Event{event("T1", 10)}, 10, time.Now())
+	ws.dispatch([]*clientv3.Event{event("T2", 11)}, 11, time.Now())
+	ws.dispatch([]*clientv3.Event{event("T1", 12), event("T2", 12)}, 12, time.Now())
+	// an old revision is ignored
+	ws.dispatch([]*clientv3.Event{event("T1", 9)}, 9, time.Now())
+
+	// revision 11 is held back until revision 10 is delivered
+	process("T2")
+	select {
+	case ev := <-received:
+		assert.Fail(t, "notification was delivered out of order", "revision", ev.revision)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// revision 12 waits for its second shard
+	process("T2")
+	process("T1")
+	expect(10, "T1")
+	expect(11, "T2")
+	process("T1")
+	expect(12, "T1", "T2")
+	assert.Equal(t, 0, len(ws.pending))
+	assert.Equal(t, 0, len(ws.queues[0]))
+}
@@ -0,0 +1,91 @@
+package ovsdb
+
+import (
+	"sort"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// DurableMonitors keeps in every database monitor the state of the database as it was observed by its etcd watch. When
+// the watch is canceled because the revisions it missed were compacted, the missed changes are reconstructed from the
+// current state of the database, and the monitors are resumed instead of being canceled. The state is a copy of the
+// database keys per monitor.
+var DurableMonitors = true
+
+// watchSnapshot is the state of the database keys at the revision of the last watch response
+type watchSnapshot struct {
+	kvs      map[string]*mvccpb.KeyValue
+	revision int64
+	// the journal key of the database, its events are ordered after the events of the rows
+	journal string
+}
+
+func newWatchSnapshot(kvs []*mvccpb.KeyValue, revision int64, journal string) *watchSnapshot {
+	s := &watchSnapshot{kvs: make(map[string]*mvccpb.KeyValue, len(kvs)), revision: revision, journal: journal}
+	for _, kv := range kvs {
+		s.kvs[string(kv.Key)] = kv
+	}
+	return s
+}
+
+// apply updates the snapshot by the events of a watch response
+func (s *watchSnapshot) apply(events []*clientv3.Event, revision int64) {
+	for _, ev := range events {
+		if ev.Type == clientv3.EventTypeDelete {
+			delete(s.kvs, string(ev.Kv.Key))
+		} else {
+			s.kvs[string(ev.Kv.Key)] = ev.Kv
+		}
+	}
+	if revision > s.revision {
+		s.revision = revision
+	}
+}
+
+// gap returns the events, which transform the snapshot into the given state of the database, and replaces the snapshot
+// by the state. The events of a key, which was deleted and created again, or created and modified, are reported as
+// a single modification or creation of the key.
+func (s *watchSnapshot) gap(kvs []*mvccpb.KeyValue, revision int64) []*clientv3.Event {
+	current := newWatchSnapshot(kvs, revision, s.journal)
+	keys := make([]string, 0, len(s.kvs)+len(current.kvs))
+	for key := range s.kvs {
+		keys = append(keys, key)
+	}
+	for key := range current.kvs {
+		if _, ok := s.kvs[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if (keys[i] == s.journal) != (keys[j] == s.journal) {
+			return keys[j] == s.journal
+		}
+		return keys[i] < keys[j]
+	})
+	events := []*clientv3.Event{}
+	for _, key := range keys {
+		prev, existed := s.kvs[key]
+		kv, exists := current.kvs[key]
+		switch {
+		case !exists:
+			events = append(events, &clientv3.Event{
+				Type:   mvccpb.DELETE,
+				Kv:     &mvccpb.KeyValue{Key: prev.Key, ModRevision: revision},
+				PrevKv: prev,
+			})
+		case !existed:
+			created := *kv
+			created.CreateRevision = created.ModRevision
+			events = append(events, &clientv3.Event{Type: mvccpb.PUT, Kv: &created})
+		case kv.ModRevision != prev.ModRevision:
+			modified := *kv
+			if modified.CreateRevision == modified.ModRevision {
+				modified.CreateRevision = prev.CreateRevision
+			}
+			events = append(events, &clientv3.Event{Type: mvccpb.PUT, Kv: &modified, PrevKv: prev})
+		}
+	}
+	*s = *current
+	return events
+}
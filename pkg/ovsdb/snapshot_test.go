@@ -0,0 +1,109 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func testSnapshotKv(t *testing.T, uuid, c1 string, createRevision, modRevision int64) *mvccpb.KeyValue {
+	value, err := json.Marshal(map[string]interface{}{"c1": c1, COL_UUID: libovsdb.UUID{GoUUID: uuid}})
+	assert.Nil(t, err)
+	return &mvccpb.KeyValue{Key: []byte("ovsdb/nb/dbName/T1/" + uuid), Value: value,
+		CreateRevision: createRevision, ModRevision: modRevision}
+}
+
+func TestWatchSnapshotGap(t *testing.T) {
+	const journal = "ovsdb/nb/dbName/_journal/journal"
+	unchanged := testSnapshotKv(t, "u1", "a", 2, 2)
+	modified := testSnapshotKv(t, "u2", "b", 3, 3)
+	deleted := testSnapshotKv(t, "u3", "c", 4, 4)
+	recreated := testSnapshotKv(t, "u4", "d", 5, 5)
+	snapshot := newWatchSnapshot([]*mvccpb.KeyValue{unchanged, modified, deleted, recreated}, 5, journal)
+
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte(journal), Value: []byte("{}"), CreateRevision: 9, ModRevision: 9},
+		unchanged,
+		testSnapshotKv(t, "u2", "bb", 3, 7),
+		testSnapshotKv(t, "u4", "dd", 8, 8),
+		testSnapshotKv(t, "u5", "e", 6, 8),
+	}
+	events := snapshot.gap(kvs, 10)
+	if assert.Equal(t, 5, len(events)) {
+		// the modified row
+		assert.True(t, events[0].IsModify())
+		assert.Equal(t, modified, events[0].PrevKv)
+		// the deleted row
+		assert.Equal(t, clientv3.EventTypeDelete, events[1].Type)
+		assert.Equal(t, deleted, events[1].PrevKv)
+		// the row deleted and created again is modified
+		assert.True(t, events[2].IsModify())
+		assert.Equal(t, recreated, events[2].PrevKv)
+		// the row created and modified is created
+		assert.True(t, events[3].IsCreate())
+		// the journal is the last
+		assert.Equal(t, journal, string(events[4].Kv.Key))
+	}
+	assert.Equal(t, int64(10), snapshot.revision)
+	assert.Equal(t, 5, len(snapshot.kvs))
+	assert.Empty(t, snapshot.gap(kvs, 11))
+}
+
+func TestMonitorReplayGap(t *testing.T) {
+	columns := map[string]*libovsdb.ColumnSchema{"c1": {Type: libovsdb.TypeString}}
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {Columns: columns}},
+	}}
+	msg := `["dbName", "monid", {"T1": [{"columns": ["c1"]}]}]`
+	handler := initHandler(t, schemas, msg, ovsjson.Update2)
+	monitor := handler.monitors[DB_NAME]
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch := &sharedWatch{log: monitor.log, dataBaseName: DB_NAME, subscriptions: map[*dbMonitor]*watchSubscription{}}
+	monitor.watchCtx = ctx
+	monitor.subscription = watch.subscribe(monitor)
+	monitor.start()
+
+	// the monitor cannot be resumed without the snapshot
+	_, ok := watch.replayGap()
+	assert.False(t, ok)
+
+	watch.snapshot = newWatchSnapshot([]*mvccpb.KeyValue{testSnapshotKv(t, "u1", "a", 2, 2),
+		testSnapshotKv(t, "u2", "b", 3, 3)}, 3, monitor.journal.key)
+	watch.resync = func() (*clientv3.GetResponse, error) {
+		return &clientv3.GetResponse{
+			Header: &etcdserverpb.ResponseHeader{Revision: 10},
+			Kvs:    []*mvccpb.KeyValue{testSnapshotKv(t, "u1", "aa", 2, 8), testSnapshotKv(t, "u3", "c", 9, 9)},
+		}, nil
+	}
+	received := make(chan notificationEvent, 1)
+	go func() {
+		hmd := handler.handlerMonitorData[jsonValueToString("monid")]
+		if ev, ok := hmd.notifications.next(ctx); ok {
+			received <- ev
+		}
+	}()
+	revision, ok := watch.replayGap()
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), revision)
+	select {
+	case ev := <-received:
+		assert.Equal(t, int64(10), ev.revision)
+		updates := ev.updates["T1"]
+		assert.Equal(t, map[string]interface{}{"c1": "aa"}, *updates["u1"].Modify)
+		assert.True(t, updates["u2"].Delete)
+		assert.Equal(t, map[string]interface{}{"c1": "c"}, *updates["u3"].Insert)
+	case <-time.After(time.Second):
+		assert.Fail(t, "the gap was not notified")
+	}
+}
@@ -0,0 +1,96 @@
+package ovsdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/creachadair/jrpc2/metrics"
+	"github.com/go-logr/logr"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+const (
+	METRIC_TABLE_STATS_RUNS   = "tables.stats.runs"
+	METRIC_TABLE_STATS_ERRORS = "tables.stats.errors"
+	// per table labels, "tables.rows.<db-name>.<table>" and "tables.growth.<db-name>.<table>"
+	METRIC_TABLE_ROWS_PREFIX   = "tables.rows."
+	METRIC_TABLE_GROWTH_PREFIX = "tables.growth."
+)
+
+// TableStats periodically counts the rows of every table of the served databases, and exports the counts and the
+// growth rates (rows per minute since the previous collection) as metrics labels.
+type TableStats struct {
+	log      logr.Logger
+	cli      *clientv3.Client
+	db       Databaser
+	interval time.Duration
+	metrics  *metrics.M
+
+	// table key -> rows count of the previous collection
+	lastCounts map[string]int64
+	lastTime   time.Time
+}
+
+func NewTableStats(cli *clientv3.Client, db Databaser, interval time.Duration, m *metrics.M, log logr.Logger) *TableStats {
+	return &TableStats{
+		log:        log.WithName("table-stats"),
+		cli:        cli,
+		db:         db,
+		interval:   interval,
+		metrics:    m,
+		lastCounts: map[string]int64{},
+	}
+}
+
+// Start runs the collection in the background until the given context is done.
+func (ts *TableStats) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(ts.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := ts.Collect(ctx); err != nil {
+					ts.log.Error(err, "collect failed")
+				}
+			}
+		}
+	}()
+}
+
+// Collect counts the rows of all the tables, updates the metrics and returns the counts per db and table. The
+// growth rates are reported from the second collection on.
+func (ts *TableStats) Collect(ctx context.Context) (map[string]map[string]int64, error) {
+	ts.metrics.Count(METRIC_TABLE_STATS_RUNS, 1)
+	now := time.Now()
+	counts := map[string]map[string]int64{}
+	for dbName, dbSchema := range ts.db.GetSchemas() {
+		counts[dbName] = map[string]int64{}
+		for tableName := range dbSchema.Tables {
+			key := common.NewTableKey(dbName, tableName)
+			tctx, cancel := context.WithTimeout(ctx, EtcdClientTimeout)
+			resp, err := ts.cli.Get(tctx, key.String(), clientv3.WithPrefix(), clientv3.WithCountOnly())
+			cancel()
+			if err != nil {
+				ts.metrics.Count(METRIC_TABLE_STATS_ERRORS, 1)
+				return nil, err
+			}
+			counts[dbName][tableName] = resp.Count
+			name := dbName + "." + tableName
+			ts.metrics.SetLabel(METRIC_TABLE_ROWS_PREFIX+name, resp.Count)
+			if last, ok := ts.lastCounts[key.String()]; ok {
+				if elapsed := now.Sub(ts.lastTime); elapsed > 0 {
+					ts.metrics.SetLabel(METRIC_TABLE_GROWTH_PREFIX+name, float64(resp.Count-last)/elapsed.Minutes())
+				}
+			}
+			ts.lastCounts[key.String()] = resp.Count
+		}
+	}
+	ts.lastTime = now
+	ts.log.V(7).Info("tables stats", "counts", counts)
+	return counts, nil
+}
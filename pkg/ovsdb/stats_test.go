@@ -0,0 +1,54 @@
+package ovsdb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/creachadair/jrpc2/metrics"
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+func TestTableStatsCollect(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	ctx := context.TODO()
+
+	schemas := libovsdb.Schemas{"simple": &libovsdb.DatabaseSchema{
+		Name:   "simple",
+		Tables: map[string]libovsdb.TableSchema{"T": {}, "T2": {}},
+	}}
+	testEtcdPut(t, "simple", "T", map[string]interface{}{"c": "v1"})
+	testEtcdPut(t, "simple", "T", map[string]interface{}{"c": "v2"})
+	testEtcdPut(t, "simple", "T2", map[string]interface{}{"c": "v3"})
+
+	m := metrics.New()
+	stats := NewTableStats(cli, &DatabaseMock{Response: schemas}, 0, m, klogr.New())
+	counts, err := stats.Collect(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]map[string]int64{"simple": {"T": 2, "T2": 1}}, counts)
+
+	snap := metrics.Snapshot{Label: map[string]interface{}{}, Counter: map[string]int64{}}
+	m.Snapshot(snap)
+	assert.Equal(t, int64(2), snap.Label[METRIC_TABLE_ROWS_PREFIX+"simple.T"])
+	assert.Equal(t, int64(1), snap.Label[METRIC_TABLE_ROWS_PREFIX+"simple.T2"])
+	_, ok := snap.Label[METRIC_TABLE_GROWTH_PREFIX+"simple.T"]
+	assert.False(t, ok)
+
+	testEtcdPut(t, "simple", "T", map[string]interface{}{"c": "v4"})
+	counts, err = stats.Collect(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), counts["simple"]["T"])
+	m.Snapshot(snap)
+	assert.Equal(t, int64(2), snap.Counter[METRIC_TABLE_STATS_RUNS])
+	growth, ok := snap.Label[METRIC_TABLE_GROWTH_PREFIX+"simple.T"].(float64)
+	assert.True(t, ok)
+	assert.True(t, growth > 0)
+	assert.Equal(t, float64(0), snap.Label[METRIC_TABLE_GROWTH_PREFIX+"simple.T2"])
+}
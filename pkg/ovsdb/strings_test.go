@@ -0,0 +1,165 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+// testStringRunes are the runes of the random strings: JSON special and control characters, non-ASCII, and astral plane
+// characters, which are escaped by surrogate pairs in JSON
+var testStringRunes = []rune{'a', 'Z', ' ', '"', '\\', '/', '%', ',', ':', '<', '&', '\x00', '\x01', '\t', '\n', '\x1f',
+	'\x7f', '\u00e9', '\u00df', '\u0416', '\u4e2d', '\u00a0', '\u2028', '\ufeff', '\U0001f600', '\U0010ffff'}
+
+func testRandomString(r *rand.Rand) string {
+	s := make([]rune, r.Intn(10))
+	for i := range s {
+		s[i] = testStringRunes[r.Intn(len(testStringRunes))]
+	}
+	return string(s)
+}
+
+func testRandomStringMap(r *rand.Rand) libovsdb.OvsMap {
+	m := libovsdb.OvsMap{GoMap: map[interface{}]interface{}{}}
+	for i := r.Intn(5); i > 0; i-- {
+		m.GoMap[testRandomString(r)] = testRandomString(r)
+	}
+	return m
+}
+
+func testStringsConfig(count int, generate func(r *rand.Rand) []interface{}) *quick.Config {
+	return &quick.Config{MaxCount: count, Values: func(values []reflect.Value, r *rand.Rand) {
+		for i, v := range generate(r) {
+			values[i] = reflect.ValueOf(v)
+		}
+	}}
+}
+
+// testWireTransact sends the operations through their JSON wire encoding
+func testWireTransact(t *testing.T, dbName string, operations ...libovsdb.Operation) *libovsdb.TransactResponse {
+	buf, err := json.Marshal(append([]interface{}{dbName}, toInterfaces(operations)...))
+	assert.Nil(t, err)
+	var params []interface{}
+	assert.Nil(t, json.Unmarshal(buf, &params))
+	req, err := libovsdb.NewTransact(params)
+	assert.Nil(t, err)
+	resp, _ := testTransact(t, req)
+	return resp
+}
+
+func toInterfaces(operations []libovsdb.Operation) []interface{} {
+	result := make([]interface{}, len(operations))
+	for i, op := range operations {
+		result[i] = op
+	}
+	return result
+}
+
+func TestTransactArbitraryStrings(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	table := "table1"
+	property := func(str string, m libovsdb.OvsMap) bool {
+		testEtcdCleanup(t)
+		row1 := map[string]interface{}{"key1": str}
+		row2 := map[string]interface{}{"string": m}
+		resp := testWireTransact(t, "simple", libovsdb.Operation{Op: OP_INSERT, Table: &table, Row: &row1})
+		if resp.Error != nil {
+			return false
+		}
+		resp = testWireTransact(t, "map", libovsdb.Operation{Op: OP_INSERT, Table: &table, Row: &row2})
+		if resp.Error != nil {
+			return false
+		}
+		// the string is used in a condition as well
+		where := []interface{}{[]interface{}{"key1", FN_EQ, str}}
+		resp = testWireTransact(t, "simple", libovsdb.Operation{Op: OP_SELECT, Table: &table, Where: &where})
+		if resp.Error != nil || len(*resp.Result[0].Rows) != 1 || (*resp.Result[0].Rows)[0]["key1"] != str {
+			return false
+		}
+		resp = testWireTransact(t, "map", libovsdb.Operation{Op: OP_SELECT, Table: &table})
+		if resp.Error != nil || len(*resp.Result[0].Rows) != 1 {
+			return false
+		}
+		stored, ok := (*resp.Result[0].Rows)[0]["string"].(libovsdb.OvsMap)
+		return ok && reflect.DeepEqual(m.GoMap, stored.GoMap)
+	}
+	assert.Nil(t, quick.Check(property, testStringsConfig(20, func(r *rand.Rand) []interface{} {
+		return []interface{}{testRandomString(r), testRandomStringMap(r)}
+	})))
+}
+
+func TestMonitorArbitraryStringsDiff(t *testing.T) {
+	tableSchema := testSchemaMap.Tables["table1"]
+	u := mcrToUpdater(ovsjson.MonitorCondRequest{}, "", &tableSchema, false)
+	value := func(m libovsdb.OvsMap) []byte {
+		row := map[string]interface{}{"string": m}
+		setRowUUID(&row, ROW_UUID)
+		val, err := makeValue(&row)
+		assert.Nil(t, err)
+		return []byte(val)
+	}
+	property := func(prev, modified libovsdb.OvsMap) bool {
+		ev := &clientv3.Event{Type: mvccpb.PUT,
+			PrevKv: &mvccpb.KeyValue{Key: []byte("ovsdb/nb/map/table1/000"), Value: value(prev), CreateRevision: 1, ModRevision: 1},
+			Kv:     &mvccpb.KeyValue{Key: []byte("ovsdb/nb/map/table1/000"), Value: value(modified), CreateRevision: 1, ModRevision: 2}}
+		rowUpdate, _, err := u.prepareRowUpdate(ev)
+		if err != nil {
+			return false
+		}
+		if rowUpdate == nil {
+			return reflect.DeepEqual(prev.GoMap, modified.GoMap)
+		}
+		// the notification round trip
+		buf, err := json.Marshal(rowUpdate)
+		if err != nil {
+			return false
+		}
+		var received ovsjson.RowUpdate
+		if err := json.Unmarshal(buf, &received); err != nil || received.Modify == nil {
+			return false
+		}
+		var delta libovsdb.OvsMap
+		deltaBuf, _ := json.Marshal((*received.Modify)["string"])
+		if err := json.Unmarshal(deltaBuf, &delta); err != nil {
+			return false
+		}
+		// apply the update2 map difference on the previous map
+		result := map[interface{}]interface{}{}
+		for k, v := range prev.GoMap {
+			result[k] = v
+		}
+		for k, v := range delta.GoMap {
+			if pv, ok := result[k]; ok && pv == v {
+				delete(result, k)
+			} else {
+				result[k] = v
+			}
+		}
+		return reflect.DeepEqual(modified.GoMap, result)
+	}
+	assert.Nil(t, quick.Check(property, testStringsConfig(500, func(r *rand.Rand) []interface{} {
+		return []interface{}{testRandomStringMap(r), testRandomStringMap(r)}
+	})))
+}
+
+func TestSurrogateEscapedStrings(t *testing.T) {
+	// an astral plane character sent as an escaped surrogate pair is stored and sent back as the same character
+	var row map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(`{"key1": "\ud83d\ude00 \u00e9\u0000"}`), &row))
+	setRowUUID(&row, ROW_UUID)
+	val, err := makeValue(&row)
+	assert.Nil(t, err)
+	stored, err := unmarshalData([]byte(val))
+	assert.Nil(t, err)
+	assert.Equal(t, "\U0001f600 \u00e9\x00", stored["key1"])
+}
@@ -0,0 +1,78 @@
+package ovsdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	SUPPRESS_ANY_REMOTE = "*"
+	SUPPRESS_ANY_ROLE   = "*"
+)
+
+// SuppressionRules defines tables, whose changes are never sent to clients connected through specific remotes, or
+// with specific roles, even if the clients monitor them.
+type SuppressionRules []suppressionRule
+
+type suppressionRule struct {
+	dbName string
+	table  string
+	// "*", a network name, e.g. "tcp" or "unix", or a full remote, e.g. "unix:/var/run/ovsdb-ro.sock"
+	remote string
+	// "*" or the role of the client identity
+	role string
+}
+
+// ParseSuppressionRules parses a comma separated list of <db-name>.<table>@<remote>#<role> rules, e.g.
+// "OVN_Northbound.ACL@tcp,OVN_Southbound.Chassis_Private@unix:/var/run/ovsdb-ro.sock#reader". If the remote or the
+// role is omitted, the rule applies to all the remotes or the roles.
+func ParseSuppressionRules(rules string) (SuppressionRules, error) {
+	parsed := SuppressionRules{}
+	for _, str := range strings.Split(rules, ",") {
+		str = strings.TrimSpace(str)
+		if str == "" {
+			continue
+		}
+		rule := suppressionRule{remote: SUPPRESS_ANY_REMOTE, role: SUPPRESS_ANY_ROLE}
+		table := str
+		if i := strings.LastIndex(table, "#"); i >= 0 {
+			rule.role = table[i+1:]
+			table = table[:i]
+		}
+		if i := strings.Index(table, "@"); i >= 0 {
+			rule.remote = table[i+1:]
+			table = table[:i]
+		}
+		parts := strings.Split(table, ".")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" || rule.remote == "" || rule.role == "" {
+			return nil, fmt.Errorf("wrong suppression rule %q", str)
+		}
+		rule.dbName = parts[0]
+		rule.table = parts[1]
+		parsed = append(parsed, rule)
+	}
+	return parsed, nil
+}
+
+// Tables returns the suppressed tables (dbName -> table -> true) for the clients of the given remote and role, the
+// remote has the "<network>:<address>" format.
+func (rules SuppressionRules) Tables(remote string, role string) map[string]map[string]bool {
+	network := remote
+	if i := strings.Index(remote, ":"); i >= 0 {
+		network = remote[:i]
+	}
+	tables := map[string]map[string]bool{}
+	for _, rule := range rules {
+		if rule.remote != SUPPRESS_ANY_REMOTE && rule.remote != remote && rule.remote != network {
+			continue
+		}
+		if rule.role != SUPPRESS_ANY_ROLE && rule.role != role {
+			continue
+		}
+		if _, ok := tables[rule.dbName]; !ok {
+			tables[rule.dbName] = map[string]bool{}
+		}
+		tables[rule.dbName][rule.table] = true
+	}
+	return tables
+}
@@ -0,0 +1,78 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func TestParseSuppressionRules(t *testing.T) {
+	rules, err := ParseSuppressionRules("OVN_Northbound.ACL@tcp, OVN_Northbound.NB_Global@unix:/tmp/ro.sock,OVN_Southbound.Chassis,OVN_Southbound.Port_Binding@tcp#reader")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(rules))
+
+	assert.Equal(t, map[string]map[string]bool{
+		"OVN_Northbound": {"ACL": true},
+		"OVN_Southbound": {"Chassis": true},
+	}, rules.Tables("tcp:127.0.0.1:6641", ""))
+	assert.Equal(t, map[string]map[string]bool{
+		"OVN_Northbound": {"ACL": true},
+		"OVN_Southbound": {"Chassis": true, "Port_Binding": true},
+	}, rules.Tables("tcp:127.0.0.1:6641", "reader"))
+	assert.Equal(t, map[string]map[string]bool{
+		"OVN_Northbound": {"NB_Global": true},
+		"OVN_Southbound": {"Chassis": true},
+	}, rules.Tables("unix:/tmp/ro.sock", "reader"))
+	assert.Equal(t, map[string]map[string]bool{
+		"OVN_Southbound": {"Chassis": true},
+	}, rules.Tables("unix:/tmp/rw.sock", ""))
+
+	rules, err = ParseSuppressionRules("")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(rules))
+
+	for _, wrong := range []string{"ACL", "OVN_Northbound.@tcp", "OVN_Northbound.ACL@", ".ACL", "OVN_Northbound.ACL#", "OVN_Northbound.ACL@tcp#"} {
+		_, err = ParseSuppressionRules(wrong)
+		assert.NotNil(t, err, wrong)
+	}
+}
+
+func TestMonitorSuppressedTables(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	schemas := libovsdb.Schemas{DB_NAME: &libovsdb.DatabaseSchema{
+		Name:   DB_NAME,
+		Tables: map[string]libovsdb.TableSchema{"T1": {}, "T2": {}},
+	}}
+	db := DatabaseMock{Response: schemas}
+	handler := NewHandler(context.Background(), &db, nil, klogr.New())
+	rules, err := ParseSuppressionRules(DB_NAME + ".T1@tcp#reader," + DB_NAME + ".T2@tcp")
+	assert.Nil(t, err)
+	handler.SetSuppressionRules(rules, "tcp:127.0.0.1:6641")
+
+	var params []interface{}
+	err = json.Unmarshal([]byte(`["dbName", "monid", {"T1": [{"columns": []}], "T2": [{"columns": []}]}]`), &params)
+	assert.Nil(t, err)
+	updatersMap, err := handler.addMonitor(params, ovsjson.Update2)
+	assert.Nil(t, err)
+	_, ok := updatersMap[common.NewTableKey(DB_NAME, "T1")]
+	assert.True(t, ok)
+	_, ok = updatersMap[common.NewTableKey(DB_NAME, "T2")]
+	assert.False(t, ok)
+	_, ok = handler.monitors[DB_NAME].key2Updaters[common.NewTableKey(DB_NAME, "T2")]
+	assert.False(t, ok)
+
+	// the rules of the role apply once the client has it
+	handler.SetIdentity(&Identity{Name: "client", Role: "reader", Method: AUTH_METHOD_NONE}, nil)
+	err = json.Unmarshal([]byte(`["dbName", "monid2", {"T1": [{"columns": []}], "T2": [{"columns": []}]}]`), &params)
+	assert.Nil(t, err)
+	updatersMap, err = handler.addMonitor(params, ovsjson.Update2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(updatersMap))
+}
@@ -1,11 +1,11 @@
 package ovsdb
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"sort"
 	"strings"
@@ -34,6 +34,7 @@ const (
 	E_NOT_SUPPORTED        = "not supported"
 	E_ABORTED              = "aborted"
 	E_NOT_OWNER            = "not owner"
+	E_CANCELED             = "canceled"
 
 	/* ovsdb transaction */
 	E_INTEGRITY_VIOLATION = "referential integrity violation"
@@ -46,6 +47,14 @@ const (
 	E_OVSDB_ERROR      = "ovsdb error"
 	E_PERMISSION_ERROR = "permission error"
 	E_SYNTAX_ERROR     = "syntax error or unknown column"
+	// the database is temporarily frozen for writes, the transaction can be retried later
+	E_FROZEN = "database frozen"
+	// rows read by the transaction were modified concurrently, the transaction can be retried
+	E_TXN_CONFLICT = "transaction conflict"
+	// the operation modifies a read-only database, per ovsdb-server
+	E_NOT_ALLOWED = "not allowed"
+	// the transaction modifies a database, which is led by another replica, per clustered ovsdb-server
+	E_NOT_LEADER = "not leader"
 )
 
 func isEqualSet(expected, actual interface{}) bool {
@@ -69,6 +78,19 @@ func isIncludesSet(expected, actual interface{}) bool {
 	return true
 }
 
+func isExcludesSet(expected, actual interface{}) bool {
+	expectedSet := expected.(libovsdb.OvsSet)
+	actualSet := actual.(libovsdb.OvsSet)
+	for _, expectedVal := range expectedSet.GoSet {
+		for _, actualVal := range actualSet.GoSet {
+			if isEqualValue(expectedVal, actualVal) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 type Alphabetic []string
 
 func (list Alphabetic) Len() int { return len(list) }
@@ -91,12 +113,8 @@ func splitAndSort(s string) string {
 
 	sort.Sort(Alphabetic(list))
 
-	var buffer bytes.Buffer
-	for _, val := range list {
-		buffer.WriteString(val)
-	}
-
-	return buffer.String()
+	// the elements are joined with the separator, otherwise different strings, e.g. "a,bc" and "ab,c", are equal
+	return strings.Join(list, ",")
 }
 
 func splitAndSortStrings(expectedVal, actualVal *interface{}) {
@@ -135,6 +153,22 @@ func isIncludesMap(expected, actual interface{}) bool {
 	return true
 }
 
+func isExcludesMap(expected, actual interface{}) bool {
+	expectedMap := expected.(libovsdb.OvsMap)
+	actualMap := actual.(libovsdb.OvsMap)
+	for key, expectedVal := range expectedMap.GoMap {
+		actualVal, ok := actualMap.GoMap[key]
+		if !ok {
+			continue
+		}
+		splitAndSortStrings(&expectedVal, &actualVal)
+		if isEqualValue(expectedVal, actualVal) {
+			return false
+		}
+	}
+	return true
+}
+
 func isEqualValue(expected, actual interface{}) bool {
 	return reflect.DeepEqual(expected, actual)
 }
@@ -185,8 +219,29 @@ const (
 	OP_ABORT   = "abort"
 	OP_COMMENT = "comment"
 	OP_ASSERT  = "assert"
+	// ovsdb-etcd extension, insert or update of rows matched by "where" or by a table index
+	OP_UPSERT = "upsert"
 )
 
+// returns true if the operations of the transaction don't modify the database
+func isReadOnlyTransaction(req *libovsdb.Transact) bool {
+	for _, ovsOp := range req.Operations {
+		if !isReadOnlyOperation(ovsOp.Op) {
+			return false
+		}
+	}
+	return true
+}
+
+func isReadOnlyOperation(op string) bool {
+	switch op {
+	case OP_SELECT, OP_WAIT, OP_ABORT, OP_ASSERT, OP_COMMENT:
+		return true
+	default:
+		return false
+	}
+}
+
 func etcdOpKey(op clientv3.Op) string {
 	v := reflect.ValueOf(op)
 	f := v.FieldByName("key")
@@ -256,6 +311,9 @@ func (txn *Transaction) etcdRemoveDupEvents() {
 			prev := prevEvents[prevIndex]
 			if etcdEventIsModify(curr) && etcdEventIsCreate(prev) {
 				newEvents[i] = etcdEventCreateFromModify(curr)
+			} else if prev.PrevKv != nil {
+				// the previous value is the stored one, rather than the one written by the transaction
+				curr.PrevKv = prev.PrevKv
 			}
 			txn.log.V(6).Info("[event] removing key", "key", key, "index", prevIndex)
 			newEvents[prevIndex] = nil
@@ -280,12 +338,42 @@ func (txn *Transaction) etcdRemoveDup() {
 
 func (txn *Transaction) etcdTranaction() (*clientv3.TxnResponse, error) {
 	txn.log.V(6).Info("etcd transaction", "etcd", txn.etcd.String())
-	errInternal := txn.etcd.Commit()
-	if errInternal != nil {
-		err := errors.New(E_IO_ERROR)
-		txn.log.Error(err, "etcd transaction", "err", errInternal)
-		return nil, err
+	for attempt := 1; ; attempt++ {
+		errInternal := txn.etcd.Commit()
+		if errInternal != nil {
+			err := errors.New(E_IO_ERROR)
+			txn.log.Error(err, "etcd transaction", "err", errInternal)
+			return nil, err
+		}
+		if txn.etcd.Res.Succeeded {
+			break
+		}
+		if attempt > MergeRetries || !txn.remerge() {
+			return nil, txn.conflictError()
+		}
+	}
+	return txn.setResponse()
+}
+
+// readTransaction reads the rows of the operations, from the row cache of the database if it can serve them, the
+// transactions with paged selects, and the transactions executed again after a conflict read them from etcd
+func (txn *Transaction) readTransaction() (*clientv3.TxnResponse, error) {
+	if txn.rowCacheBypass || len(txn.pages) > 0 || len(txn.etcd.If) > 0 {
+		return txn.etcdTranaction()
 	}
+	res, ok := txn.rowCache.read(txn.etcd.Ctx, txn.etcd.Then)
+	if !ok {
+		return txn.etcdTranaction()
+	}
+	txn.log.V(6).Info("row cache read", "etcd", txn.etcd.String(), "revision", res.Header.Revision)
+	txn.etcd.Res = res
+	return txn.setResponse()
+}
+
+// setResponse adds the rows of the etcd response to the cache of the transaction
+func (txn *Transaction) setResponse() (*clientv3.TxnResponse, error) {
+	txn.setReadRevisions(txn.etcd.Res)
+	txn.setPages(txn.etcd.Res)
 	txn.cache.GetFromEtcd(txn.etcd.Res)
 
 	err := txn.cache.Unmarshal(txn, txn.schemas)
@@ -294,12 +382,7 @@ func (txn *Transaction) etcdTranaction() (*clientv3.TxnResponse, error) {
 		return nil, err
 	}
 
-	err = txn.cache.Validate(txn, txn.schemas)
-	if err != nil {
-		xErr := errors.New(E_CONSTRAINT_VIOLATION)
-		txn.log.Error(err, "cache validate")
-		return nil, xErr
-	}
+	txn.cache.QuarantineInvalid(txn, txn.schemas)
 
 	return txn.etcd.Res, nil
 }
@@ -337,6 +420,23 @@ type Cache map[string]DatabaseCache
 type DatabaseCache map[string]TableCache
 type TableCache map[string]*map[string]interface{}
 
+// DeterministicOrder makes the select results reproducible, the rows are ordered by their uuids. The tables of the
+// get_schema response, and the tables and the rows of the monitor data, are always ordered lexically by the table names
+// and by the uuids, as they are encoded as JSON objects.
+var DeterministicOrder = false
+
+// uuids returns the uuids of the table rows, ordered if DeterministicOrder is set
+func (t TableCache) uuids() []string {
+	uuids := make([]string, 0, len(t))
+	for uuid := range t {
+		uuids = append(uuids, uuid)
+	}
+	if DeterministicOrder {
+		sort.Strings(uuids)
+	}
+	return uuids
+}
+
 func (c *Cache) Database(dbname string) DatabaseCache {
 	db, ok := (*c)[dbname]
 	if !ok {
@@ -365,11 +465,13 @@ func (c *Cache) Row(key common.Key) *map[string]interface{} {
 	return tb[key.UUID]
 }
 
+// GetFromEtcdKV adds the key values to the cache, rows that cannot be parsed are quarantined and skipped
 func (c *Cache) GetFromEtcdKV(kvs []*mvccpb.KeyValue) error {
 	for _, x := range kvs {
 		kv, err := NewKeyValue(x)
 		if err != nil {
-			return err
+			quarantine.Add(string(x.Key), x.ModRevision, err)
+			continue
 		}
 		row := c.Row(kv.Key)
 		(*row) = kv.Value
@@ -386,14 +488,17 @@ func (cache *Cache) GetFromEtcd(res *clientv3.TxnResponse) {
 	}
 }
 
+// Unmarshal unmarshals the cached rows according to the schemas, rows that fail are quarantined and removed from the
+// cache.
 func (cache *Cache) Unmarshal(txn *Transaction, schemas libovsdb.Schemas) error {
 	for database, databaseCache := range *cache {
 		for table, tableCache := range databaseCache {
-			for _, row := range tableCache {
+			for uuid, row := range tableCache {
 				err := schemas.Unmarshal(database, table, row)
 				if err != nil {
-					txn.log.Error(err, "failed schema unmarshal")
-					return err
+					key := common.NewDataKey(database, table, uuid)
+					quarantine.Add(key.String(), 0, err)
+					delete(tableCache, uuid)
 				}
 			}
 		}
@@ -401,6 +506,23 @@ func (cache *Cache) Unmarshal(txn *Transaction, schemas libovsdb.Schemas) error
 	return nil
 }
 
+// QuarantineInvalid validates the cached rows, rows that fail the validation are quarantined and removed from the
+// cache.
+func (cache *Cache) QuarantineInvalid(txn *Transaction, schemas libovsdb.Schemas) {
+	for database, databaseCache := range *cache {
+		for table, tableCache := range databaseCache {
+			for uuid, row := range tableCache {
+				err := schemas.Validate(database, table, row)
+				if err != nil {
+					key := common.NewDataKey(database, table, uuid)
+					quarantine.Add(key.String(), 0, err)
+					delete(tableCache, uuid)
+				}
+			}
+		}
+	}
+}
+
 func (cache *Cache) Validate(txn *Transaction, schemas libovsdb.Schemas) error {
 	for database, databaseCache := range *cache {
 		for table, tableCache := range databaseCache {
@@ -416,6 +538,9 @@ func (cache *Cache) Validate(txn *Transaction, schemas libovsdb.Schemas) error {
 	return nil
 }
 
+// MapUUID is the symbol table of the named-uuids of a transaction, it maps the uuid-name of every row inserted by the
+// transaction to the uuid of the row. The uuids are assigned before the operations are executed, so the operations can
+// refer to the rows inserted by the following operations as well.
 type MapUUID map[string]string
 
 func (mapUUID MapUUID) Set(txn *Transaction, uuidName, uuid string) {
@@ -458,15 +583,20 @@ func (mapUUID MapUUID) ResolvSet(txn *Transaction, value interface{}) (interface
 	return newset, nil
 }
 
+// ResolvMap resolves the named-uuids of both the keys and the values of the map
 func (mapUUID MapUUID) ResolvMap(txn *Transaction, value interface{}) (interface{}, error) {
 	oldmap, _ := value.(libovsdb.OvsMap)
 	newmap := libovsdb.OvsMap{GoMap: map[interface{}]interface{}{}}
-	for key, oldval := range oldmap.GoMap {
+	for oldkey, oldval := range oldmap.GoMap {
+		newkey, err := mapUUID.ResolvUUID(txn, oldkey)
+		if err != nil {
+			return nil, err
+		}
 		newval, err := mapUUID.ResolvUUID(txn, oldval)
 		if err != nil {
 			return nil, err
 		}
-		newmap.GoMap[key] = newval
+		newmap.GoMap[newkey] = newval
 	}
 	return newmap, nil
 }
@@ -504,6 +634,9 @@ type Etcd struct {
 	Res            *clientv3.TxnResponse
 	EventsNilCount int
 	Events         []*clientv3.Event
+	// the journal key of the database, the transaction is guarded by its absence, and a transaction exceeding the
+	// etcd limits is committed by a chain of etcd transactions. Empty if the transaction isn't guarded.
+	Journal string
 }
 
 func (etcd *Etcd) Assert() {
@@ -568,8 +701,9 @@ func (evList EventList) String() string {
 
 func NewEtcd(parent *Etcd) *Etcd {
 	return &Etcd{
-		Ctx: parent.Ctx,
-		Cli: parent.Cli,
+		Ctx:     parent.Ctx,
+		Cli:     parent.Cli,
+		Journal: parent.Journal,
 	}
 }
 func (etcd *Etcd) Clear() {
@@ -587,12 +721,126 @@ func (etcd Etcd) String() string {
 }
 
 func (etcd *Etcd) Commit() error {
-	res, err := etcd.Cli.Txn(etcd.Ctx).If(etcd.If...).Then(etcd.Then...).Else(etcd.Else...).Commit()
+	defer func(start time.Time) {
+		observeLatency(serverMetrics, METRIC_ETCD_LATENCY_PREFIX+ETCD_REQUEST_TXN, time.Since(start))
+	}(time.Now())
+	if etcd.Journal == "" {
+		res, err := etcd.Cli.Txn(etcd.Ctx).If(etcd.If...).Then(etcd.Then...).Else(etcd.Else...).Commit()
+		if err != nil {
+			return err
+		}
+		etcd.Res = res
+		return nil
+	}
+	for {
+		var journal *mvccpb.KeyValue
+		var err error
+		switch {
+		case !etcd.exceedsTxnLimit():
+			journal, err = etcd.commitGuarded()
+		case etcd.isReadOnly():
+			journal, err = etcd.commitReads()
+		default:
+			journal, err = etcd.commitChained()
+		}
+		if err != nil || journal == nil {
+			return err
+		}
+		// a chained commit is in progress
+		if err := waitJournal(etcd.Ctx, etcd.Cli, journal); err != nil {
+			return err
+		}
+	}
+}
+
+// TxnConflict describes a row that was modified after the transaction read it
+type TxnConflict struct {
+	Key              string `json:"key"`
+	ExpectedRevision int64  `json:"expected-revision"`
+	// the revision of the competing modification, 0 if the row was deleted
+	ActualRevision int64 `json:"actual-revision"`
+	// the row version written by the competing transaction
+	CompetingVersion string `json:"competing-version,omitempty"`
+}
+
+func (c TxnConflict) String() string {
+	return fmt.Sprintf("%s (expected revision %d, actual revision %d, competing version %q)",
+		c.Key, c.ExpectedRevision, c.ActualRevision, c.CompetingVersion)
+}
+
+// Conflicts re-reads the keys of the failed mod revision comparisons of the transaction, and returns their details
+func (etcd *Etcd) Conflicts() ([]TxnConflict, error) {
+	cmps := []clientv3.Cmp{}
+	ops := []clientv3.Op{}
+	for _, cmp := range etcd.If {
+		// the range compares guard the absence of changes, and not the revisions of specific rows. The "less" compare
+		// of a single key is the commit counter of a serializable database.
+		if cmp.Target != etcdserverpb.Compare_MOD || len(cmp.RangeEnd) > 0 ||
+			(cmp.Result != etcdserverpb.Compare_EQUAL && cmp.Result != etcdserverpb.Compare_LESS) {
+			continue
+		}
+		cmps = append(cmps, cmp)
+		ops = append(ops, clientv3.OpGet(string(cmp.Key)))
+	}
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	res, err := etcd.Cli.Txn(etcd.Ctx).Then(ops...).Commit()
+	if err != nil {
+		return nil, err
+	}
+	conflicts := []TxnConflict{}
+	for i, cmp := range cmps {
+		conflict := TxnConflict{Key: string(cmp.Key), ExpectedRevision: cmp.TargetUnion.(*etcdserverpb.Compare_ModRevision).ModRevision}
+		kvs := res.Responses[i].GetResponseRange().Kvs
+		if len(kvs) > 0 {
+			conflict.ActualRevision = kvs[0].ModRevision
+			conflict.CompetingVersion = rowVersion(kvs[0].Value)
+		}
+		if cmp.Result == etcdserverpb.Compare_LESS {
+			// the counter is expected to be modified at the read revision or before it
+			conflict.ExpectedRevision--
+			if conflict.ActualRevision > conflict.ExpectedRevision {
+				conflicts = append(conflicts, conflict)
+			}
+		} else if conflict.ActualRevision != conflict.ExpectedRevision {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	return conflicts, nil
+}
+
+// returns the _version of the stored row, or an empty string if it cannot be parsed
+func rowVersion(value []byte) string {
+	row, err := unmarshalData(value)
 	if err != nil {
+		return ""
+	}
+	version, err := libovsdb.UnmarshalUUID(row[COL_VERSION])
+	if err != nil {
+		return ""
+	}
+	return version.(libovsdb.UUID).GoUUID
+}
+
+// conflictError logs the conflicting rows of a failed etcd transaction, and reports them in the transaction
+// response as an additional result, following the operations results.
+func (txn *Transaction) conflictError() error {
+	err := errors.New(E_TXN_CONFLICT)
+	conflicts, errInternal := txn.etcd.Conflicts()
+	if errInternal != nil {
+		txn.log.Error(err, "etcd transaction failed, cannot read conflicts", "err", errInternal)
 		return err
 	}
-	etcd.Res = res
-	return nil
+	details := make([]string, 0, len(conflicts))
+	for _, c := range conflicts {
+		details = append(details, c.String())
+	}
+	txn.log.Error(err, "etcd transaction failed", "conflicts", conflicts)
+	detailsStr := "conflicting rows: " + strings.Join(details, ", ")
+	errStr := err.Error()
+	txn.response.Result = append(txn.response.Result, libovsdb.OperationResult{Error: &errStr, Details: &detailsStr})
+	return err
 }
 
 type TxnLock struct {
@@ -618,6 +866,45 @@ type Transaction struct {
 
 	/* etcd */
 	etcd *Etcd
+	// the prefixes read by the transaction, every prefix is read once
+	reads map[string]bool
+
+	/* optimistic concurrency and commutative merges */
+	// key -> mod revision of the rows read by the transaction
+	readRevisions map[string]int64
+	// key -> number of modifications of the row
+	rowWrites map[string]int
+	// the rows matched by the operations, and the prefixes read by the operations, which matched no row
+	rowReads    map[string]bool
+	prefixReads map[string]bool
+	// key -> commutative mutations of the row
+	merges map[string]*rowMerge
+
+	/* paged selects */
+	pages map[*libovsdb.OperationResult]*selectPage
+
+	/* blocking waits */
+	// the revision of the rows read by the transaction
+	readRevision int64
+
+	/* locks */
+	// lock id -> the lock of the session
+	locks map[string]Locker
+	// lock id -> the compares of the asserted lock ownership
+	lockCompares map[string][]clientv3.Cmp
+
+	// the database is read-only, the operations which modify it fail
+	readOnly bool
+	// the write transactions of the database are serialized across the server replicas by its commit counter
+	serializable bool
+	// authorizes the modifications of the client role, nil if the client is not restricted
+	rbac *rbacClient
+
+	/* row cache */
+	// the cache of the database rows, nil if the rows are read from etcd
+	rowCache *rowCache
+	// the rows are read from etcd, e.g. after the cached rows were stale
+	rowCacheBypass bool
 }
 
 func NewTransaction(cli *clientv3.Client, log logr.Logger, request *libovsdb.Transact) *Transaction {
@@ -632,6 +919,9 @@ func NewTransaction(cli *clientv3.Client, log logr.Logger, request *libovsdb.Tra
 	txn.etcd = new(Etcd)
 	txn.etcd.Ctx = context.TODO()
 	txn.etcd.Cli = cli
+	if request.DBName != "" {
+		txn.etcd.Journal = common.NewJournalKey(request.DBName).String()
+	}
 	return txn
 }
 
@@ -648,6 +938,7 @@ var ovsOpCallbackMap = map[string][2]ovsOpCallback{
 	OP_ABORT:   {preAbort, doAbort},
 	OP_COMMENT: {preComment, doComment},
 	OP_ASSERT:  {preAssert, doAssert},
+	OP_UPSERT:  {preUpsert, doUpsert},
 }
 
 func (txn *Transaction) AddSchemaFromFile(path string) error {
@@ -658,7 +949,105 @@ func (txn *Transaction) AddSchema(databaseSchema *libovsdb.DatabaseSchema) {
 	txn.schemas.Add(databaseSchema)
 }
 
+// CommitRetries is the maximum number of times a transaction is executed again, after its etcd transaction failed
+// because of a concurrent modification of the rows it read
+var CommitRetries = 3
+
+// Commit executes the transaction, the rows it reads and writes are guarded by the revisions they were read at, and if
+// one of them is modified concurrently, the whole transaction is executed again on the current rows.
 func (txn *Transaction) Commit() (int64, error) {
+	request := copyTransact(&txn.request)
+	for attempt := 1; ; attempt++ {
+		serverMetrics.Count(METRIC_TRANSACT_EXECUTIONS_PREFIX+txn.request.DBName, 1)
+		revision, err := txn.commit()
+		if err == nil || err.Error() != E_TXN_CONFLICT {
+			return revision, err
+		}
+		if attempt > CommitRetries {
+			serverMetrics.Count(METRIC_TRANSACT_CONFLICTS_PREFIX+txn.request.DBName, 1)
+			return revision, err
+		}
+		serverMetrics.Count(METRIC_TRANSACT_RETRIES_PREFIX+txn.request.DBName, 1)
+		txn.log.V(3).Info("transaction conflict, executing the transaction again", "attempt", attempt)
+		txn.rowCacheBypass = true
+		txn.reset(copyTransact(&request))
+	}
+}
+
+// reset clears the state of a failed execution of the transaction, the operations of the request are replaced by
+// their unmodified copy
+func (txn *Transaction) reset(request libovsdb.Transact) {
+	txn.request = request
+	txn.response = libovsdb.TransactResponse{Result: make([]libovsdb.OperationResult, len(request.Operations))}
+	txn.cache = Cache{}
+	txn.mapUUID = MapUUID{}
+	txn.etcd.Clear()
+	txn.readRevisions = nil
+	txn.rowWrites = nil
+	txn.rowReads = nil
+	txn.prefixReads = nil
+	txn.merges = nil
+	txn.pages = nil
+	txn.lockCompares = nil
+	txn.readRevision = 0
+}
+
+// copyTransact returns a deep copy of the request, the execution of the operations modifies their rows, mutations and
+// conditions in place
+func copyTransact(request *libovsdb.Transact) libovsdb.Transact {
+	copied := *request
+	copied.Operations = make([]libovsdb.Operation, len(request.Operations))
+	for i, op := range request.Operations {
+		if op.Row != nil {
+			row := copyValue(*op.Row).(map[string]interface{})
+			op.Row = &row
+		}
+		if op.Rows != nil {
+			rows := make([]map[string]interface{}, len(*op.Rows))
+			for j, row := range *op.Rows {
+				rows[j] = copyValue(row).(map[string]interface{})
+			}
+			op.Rows = &rows
+		}
+		if op.Mutations != nil {
+			mutations := copyValue(*op.Mutations).([]interface{})
+			op.Mutations = &mutations
+		}
+		if op.Where != nil {
+			where := copyValue(*op.Where).([]interface{})
+			op.Where = &where
+		}
+		copied.Operations[i] = op
+	}
+	return copied
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		copied := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			copied[key] = copyValue(val)
+		}
+		return copied
+	case []interface{}:
+		if v == nil {
+			return v
+		}
+		copied := make([]interface{}, len(v))
+		for i, val := range v {
+			copied[i] = copyValue(val)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+func (txn *Transaction) commit() (int64, error) {
 	var err error
 
 	/* verify that select is not intermixed with other operations */
@@ -679,8 +1068,29 @@ func (txn *Transaction) Commit() (int64, error) {
 		return -1, err
 	}
 
+	if txn.readOnly {
+		for i, ovsOp := range txn.request.Operations {
+			if isReadOnlyOperation(ovsOp.Op) {
+				continue
+			}
+			err := errors.New(E_NOT_ALLOWED)
+			txn.log.Error(err, "operation of a read-only database", "op", ovsOp.Op)
+			errStr := err.Error()
+			details := fmt.Sprintf("%s operation not allowed when database %s is read-only", ovsOp.Op,
+				txn.request.DBName)
+			txn.response.Result[i].SetError(errStr)
+			txn.response.Result[i].Details = &details
+			txn.response.Error = &errStr
+			return -1, err
+		}
+	}
+
 	/* fetch needed data from database needed to perform the operation */
 	txn.etcd.Clear()
+	txn.reads = map[string]bool{}
+	if !isReadOnlyTransaction(&txn.request) {
+		txn.rbac.fetch(txn)
+	}
 	for i, ovsOp := range txn.request.Operations {
 		err := ovsOpCallbackMap[ovsOp.Op][0](txn, &ovsOp, &txn.response.Result[i])
 		if err != nil {
@@ -694,12 +1104,13 @@ func (txn *Transaction) Commit() (int64, error) {
 			panic(fmt.Sprintf("validation of %s failed: %s", ovsOp, err.Error()))
 		}
 	}
-	_, err = txn.etcdTranaction()
+	readResponse, err := txn.readTransaction()
 	if err != nil {
 		errStr := err.Error()
 		txn.response.Error = &errStr
 		return -1, err
 	}
+	txn.readRevision = readResponse.Header.Revision
 
 	/* commit actual transactional changes to database */
 	txn.etcd.Clear()
@@ -708,6 +1119,10 @@ func (txn *Transaction) Commit() (int64, error) {
 		if err != nil {
 			errStr := err.Error()
 			txn.response.Result[i].SetError(errStr)
+			var rbacErr *rbacError
+			if errors.As(err, &rbacErr) {
+				txn.response.Result[i].Details = &rbacErr.details
+			}
 			txn.response.Error = &errStr
 			return -1, err
 		}
@@ -718,6 +1133,30 @@ func (txn *Transaction) Commit() (int64, error) {
 	}
 
 	txn.etcdRemoveDup()
+	if err = txn.collectGarbage(); err != nil {
+		errStr := err.Error()
+		txn.response.Error = &errStr
+		return -1, err
+	}
+	if err = txn.removeWeakReferences(); err != nil {
+		errStr := err.Error()
+		txn.response.Error = &errStr
+		return -1, err
+	}
+	if err = txn.maintainIndexes(); err != nil {
+		errStr := err.Error()
+		txn.response.Error = &errStr
+		return -1, err
+	}
+	if err = txn.checkReferentialIntegrity(); err != nil {
+		errStr := err.Error()
+		txn.response.Error = &errStr
+		return -1, err
+	}
+	txn.addMergeCompares()
+	txn.addWriteCompares()
+	txn.addReadCompares()
+	txn.addCommitCounter()
 	txn.log.Info("events transaction", "events", NewEventList(txn.etcd.Events))
 	trResponse, err := txn.etcdTranaction()
 	if err != nil {
@@ -725,6 +1164,7 @@ func (txn *Transaction) Commit() (int64, error) {
 		txn.response.Error = &errStr
 		return -1, err
 	}
+	txn.rowCache.committed(trResponse.Header.Revision, txn.etcd.Then)
 
 	txn.log.V(5).Info("commit transaction", "response", txn.response)
 	return trResponse.Header.Revision, nil
@@ -732,6 +1172,7 @@ func (txn *Transaction) Commit() (int64, error) {
 
 // XXX: move to db
 func makeValue(row *map[string]interface{}) (string, error) {
+	normalizeRow(row)
 	b, err := json.Marshal(*row)
 	if err != nil {
 		return "", err
@@ -783,7 +1224,13 @@ func NewCondition(txn *Transaction, tableSchema *libovsdb.TableSchema, mapUUID M
 	}
 
 	var columnSchema *libovsdb.ColumnSchema
-	if column != COL_UUID && column != COL_VERSION {
+	if internal, ok := InternalColumns.Get(column); ok {
+		if !internal.Condition {
+			err = errors.New(E_CONSTRAINT_VIOLATION)
+			txn.log.Error(err, "unsupported internal column condition", "column", column)
+			return nil, err
+		}
+	} else {
 		columnSchema, err = tableSchema.LookupColumn(column)
 		if err != nil {
 			err = errors.New(E_CONSTRAINT_VIOLATION)
@@ -1017,11 +1464,17 @@ func (c *Condition) CompareSet(row *map[string]interface{}) (bool, error) {
 		return false, err
 	}
 
-	if (fn == FN_EQ || fn == FN_IN) && isEqualSet(actual, expected) {
-		return true, nil
-	}
-	if (fn == FN_NE || fn == FN_EX) && !isEqualSet(actual, expected) {
-		return true, nil
+	switch fn {
+	case FN_EQ:
+		return isEqualSet(actual, expected), nil
+	case FN_NE:
+		return !isEqualSet(actual, expected), nil
+	case FN_IN:
+		// every element of the condition value is an element of the column
+		return isIncludesSet(expected, actual), nil
+	case FN_EX:
+		// no element of the condition value is an element of the column
+		return isExcludesSet(expected, actual), nil
 	}
 	return false, nil
 }
@@ -1042,23 +1495,29 @@ func (c *Condition) CompareMap(row *map[string]interface{}) (bool, error) {
 		return false, err
 	}
 
-	if (fn == FN_EQ || fn == FN_IN) && isEqualMap(actual, expected) {
-		return true, nil
-	}
-	if (fn == FN_NE || fn == FN_EX) && !isEqualMap(actual, expected) {
-		return true, nil
+	switch fn {
+	case FN_EQ:
+		return isEqualMap(actual, expected), nil
+	case FN_NE:
+		return !isEqualMap(actual, expected), nil
+	case FN_IN:
+		// every key-value pair of the condition value is a pair of the column
+		return isIncludesMap(expected, actual), nil
+	case FN_EX:
+		// no key-value pair of the condition value is a pair of the column
+		return isExcludesMap(expected, actual), nil
 	}
 	return false, nil
 }
 
 func (c *Condition) Compare(row *map[string]interface{}) (bool, error) {
 	var err error
-	switch c.Column {
-	case COL_UUID:
+	if c.Column == COL_UUID {
 		return c.CompareUUID(row)
-	case COL_VERSION:
+	}
+	if InternalColumns.IsInternal(c.Column) {
 		err = errors.New(E_CONSTRAINT_VIOLATION)
-		c.txn.log.Error(err, "unsupported field comparison", "column", COL_VERSION)
+		c.txn.log.Error(err, "unsupported field comparison", "column", c.Column)
 		return false, err
 	}
 
@@ -1174,17 +1633,51 @@ func (txn *Transaction) isRowSelectedByCond(tableSchema *libovsdb.TableSchema, m
 	return condition.Compare(row)
 }
 
-func reduceRowByColumns(row *map[string]interface{}, columns *[]string) (*map[string]interface{}, error) {
+// reduceRowByColumns returns a copy of the row with the requested columns, or with all the columns if the columns are
+// not specified. The columns, which are missing in the stored row, get their default values.
+func reduceRowByColumns(tableSchema *libovsdb.TableSchema, row *map[string]interface{}, columns *[]string) (*map[string]interface{}, error) {
 	if columns == nil {
-		return row, nil
+		newRow := make(map[string]interface{}, len(*row))
+		for column, value := range *row {
+			newRow[column] = value
+		}
+		InternalColumns.StripForSelect(newRow)
+		tableSchema.Default(&newRow)
+		return &newRow, nil
 	}
 	newRow := map[string]interface{}{}
 	for _, column := range *columns {
-		newRow[column] = (*row)[column]
+		value, ok := (*row)[column]
+		if !ok {
+			columnSchema, err := tableSchema.LookupColumn(column)
+			if err != nil {
+				return nil, errors.New(E_CONSTRAINT_VIOLATION)
+			}
+			value = columnSchema.Default()
+		}
+		newRow[column] = value
 	}
 	return &newRow, nil
 }
 
+// checkColumns validates the columns of the operation, which are either the table columns or the internal columns
+func (txn *Transaction) checkColumns(tableSchema *libovsdb.TableSchema, columns *[]string) error {
+	if columns == nil {
+		return nil
+	}
+	for _, column := range *columns {
+		if InternalColumns.IsInternal(column) {
+			continue
+		}
+		if _, err := tableSchema.LookupColumn(column); err != nil {
+			err = errors.New(E_CONSTRAINT_VIOLATION)
+			txn.log.Error(err, "unknown column", "column", column)
+			return err
+		}
+	}
+	return nil
+}
+
 const (
 	MT_SUM        = "+="
 	MT_DIFFERENCE = "-="
@@ -1234,7 +1727,7 @@ func NewMutation(txn *Transaction, tableSchema *libovsdb.TableSchema, mapUUID Ma
 
 	value := mutation[2]
 
-	value, err = columnSchema.Unmarshal(value)
+	value, err = unmarshalMutationValue(columnSchema, mt, value)
 	if err != nil {
 		err = errors.New(E_CONSTRAINT_VIOLATION)
 		txn.log.Error(err, "failed unmarshal of column", "column", column)
@@ -1248,7 +1741,7 @@ func NewMutation(txn *Transaction, tableSchema *libovsdb.TableSchema, mapUUID Ma
 		return nil, err
 	}
 
-	err = columnSchema.Validate(value)
+	err = validateMutationValue(columnSchema, value)
 	if err != nil {
 		err = errors.New(E_CONSTRAINT_VIOLATION)
 		txn.log.Error(err, "failed validate of column", "column", column)
@@ -1264,76 +1757,190 @@ func NewMutation(txn *Transaction, tableSchema *libovsdb.TableSchema, mapUUID Ma
 	}, nil
 }
 
-func (m *Mutation) MutateInteger(row *map[string]interface{}) error {
-	var err error
-	original := (*row)[m.Column].(int)
-	value, ok := m.Value.(int)
-	if !ok {
-		err = errors.New(E_CONSTRAINT_VIOLATION)
-		m.txn.log.Error(err, "can't convert mutation value", "value", m.Value)
-		return err
+// unmarshalMutationValue unmarshals the value of the mutator. The arithmetic mutators of a set take a scalar of its
+// elements type, the insert and delete mutators take a set or a map of any size, and the delete of a map takes a set
+// of keys as well.
+func unmarshalMutationValue(columnSchema *libovsdb.ColumnSchema, mutator string, value interface{}) (interface{}, error) {
+	if isArithmeticMutator(mutator) && columnSchema.Type == libovsdb.TypeSet {
+		return columnSchema.TypeObj.Key.Unmarshal(value)
+	}
+	if mutator == MT_DELETE && columnSchema.Type == libovsdb.TypeMap && !isMapValue(value) {
+		return columnSchema.UnmarshalSet(value)
+	}
+	return columnSchema.Unmarshal(value)
+}
+
+// isMapValue returns true if the value is a map, or its json notation ["map", [[<key>, <value>], ...]]
+func isMapValue(value interface{}) bool {
+	switch value := value.(type) {
+	case libovsdb.OvsMap:
+		return true
+	case []interface{}:
+		return len(value) == 2 && value[0] == "map"
 	}
-	mutated := original
+	return false
+}
+
+// validateMutationValue validates the type of the value elements, the set sizes are validated on the mutated value
+func validateMutationValue(columnSchema *libovsdb.ColumnSchema, value interface{}) error {
+	switch value := value.(type) {
+	case libovsdb.OvsSet:
+		if columnSchema.TypeObj == nil {
+			return fmt.Errorf("unexpected set: %+v", value)
+		}
+		for _, v := range value.GoSet {
+			if err := columnSchema.TypeObj.Key.Validate(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case libovsdb.OvsMap:
+		if columnSchema.TypeObj == nil || columnSchema.TypeObj.Value == nil {
+			return fmt.Errorf("unexpected map: %+v", value)
+		}
+		for k, v := range value.GoMap {
+			if err := columnSchema.TypeObj.Key.Validate(k); err != nil {
+				return err
+			}
+			if err := columnSchema.TypeObj.Value.Validate(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		if columnSchema.TypeObj != nil {
+			return columnSchema.TypeObj.Key.Validate(value)
+		}
+		return columnSchema.Validate(value)
+	}
+}
+
+func isArithmeticMutator(mutator string) bool {
+	switch mutator {
+	case MT_SUM, MT_DIFFERENCE, MT_PRODUCT, MT_QUOTIENT, MT_REMAINDER:
+		return true
+	}
+	return false
+}
+
+const (
+	maxInt = int(^uint(0) >> 1)
+	minInt = -maxInt - 1
+)
+
+// mutateInteger applies the arithmetic mutator, an overflow of the result is a range error
+func (m *Mutation) mutateInteger(original int, value int) (int, error) {
+	var err error
+	var mutated int
+	overflow := false
 	switch m.Mutator {
 	case MT_SUM:
-		mutated += value
+		mutated = original + value
+		overflow = (value > 0 && mutated < original) || (value < 0 && mutated > original)
 	case MT_DIFFERENCE:
-		mutated -= value
+		mutated = original - value
+		overflow = (value > 0 && mutated > original) || (value < 0 && mutated < original)
 	case MT_PRODUCT:
-		mutated *= value
+		mutated = original * value
+		overflow = (value != 0 && mutated/value != original) || (original == minInt && value == -1) || (value == minInt && original == -1)
 	case MT_QUOTIENT:
-		if value != 0 {
-			mutated /= value
-		} else {
+		if value == 0 {
 			err = errors.New(E_DOMAIN_ERROR)
 			m.txn.log.Error(err, "can't devide by 0")
-			return err
+			return 0, err
 		}
+		mutated = original / value
+		overflow = original == minInt && value == -1
 	case MT_REMAINDER:
-		if value != 0 {
-			mutated %= value
-		} else {
+		if value == 0 {
 			err = errors.New(E_DOMAIN_ERROR)
 			m.txn.log.Error(err, "can't modulo by 0")
-			return err
+			return 0, err
 		}
+		mutated = original % value
 	default:
 		err = errors.New(E_CONSTRAINT_VIOLATION)
 		m.txn.log.Error(err, "unsupported mutator", "mutator", m.Mutator)
-		return err
+		return 0, err
 	}
-	(*row)[m.Column] = mutated
-	return nil
+	if overflow {
+		err = errors.New(E_RANGE_ERROR)
+		m.txn.log.Error(err, "integer overflow", "mutator", m.Mutator, "original", original, "value", value)
+		return 0, err
+	}
+	return mutated, nil
 }
 
-func (m *Mutation) MutateReal(row *map[string]interface{}) error {
+// mutateReal applies the arithmetic mutator, an infinite result is a range error
+func (m *Mutation) mutateReal(original float64, value float64) (float64, error) {
 	var err error
-	original := (*row)[m.Column].(float64)
-	value, ok := m.Value.(float64)
-	if !ok {
-		err = errors.New(E_CONSTRAINT_VIOLATION)
-		m.txn.log.Error(err, "failed to convert mutation value", "value", m.Value)
-		return err
-	}
-	mutated := original
+	var mutated float64
 	switch m.Mutator {
 	case MT_SUM:
-		mutated += value
+		mutated = original + value
 	case MT_DIFFERENCE:
-		mutated -= value
+		mutated = original - value
 	case MT_PRODUCT:
-		mutated *= value
+		mutated = original * value
 	case MT_QUOTIENT:
-		if value != 0 {
-			mutated /= value
-		} else {
+		if value == 0 {
 			err = errors.New(E_DOMAIN_ERROR)
 			m.txn.log.Error(err, "can't devide by 0")
-			return err
+			return 0, err
 		}
+		mutated = original / value
 	default:
 		err = errors.New(E_CONSTRAINT_VIOLATION)
 		m.txn.log.Error(err, "unsupported mutator", "mutator", m.Mutator)
+		return 0, err
+	}
+	if math.IsInf(mutated, 0) || math.IsNaN(mutated) {
+		err = errors.New(E_RANGE_ERROR)
+		m.txn.log.Error(err, "real out of range", "mutator", m.Mutator, "original", original, "value", value)
+		return 0, err
+	}
+	return mutated, nil
+}
+
+// mutateAtom applies the arithmetic mutator to an integer or a real
+func (m *Mutation) mutateAtom(original interface{}) (interface{}, error) {
+	var err error
+	switch original := original.(type) {
+	case int:
+		value, ok := m.Value.(int)
+		if !ok {
+			err = errors.New(E_CONSTRAINT_VIOLATION)
+			m.txn.log.Error(err, "can't convert mutation value", "value", m.Value)
+			return nil, err
+		}
+		return m.mutateInteger(original, value)
+	case float64:
+		value, ok := m.Value.(float64)
+		if !ok {
+			err = errors.New(E_CONSTRAINT_VIOLATION)
+			m.txn.log.Error(err, "failed to convert mutation value", "value", m.Value)
+			return nil, err
+		}
+		return m.mutateReal(original, value)
+	default:
+		err = errors.New(E_CONSTRAINT_VIOLATION)
+		m.txn.log.Error(err, "unsupported arithmetic mutation", "column", m.Column, "value", original)
+		return nil, err
+	}
+}
+
+func (m *Mutation) MutateInteger(row *map[string]interface{}) error {
+	mutated, err := m.mutateAtom((*row)[m.Column].(int))
+	if err != nil {
+		return err
+	}
+	(*row)[m.Column] = mutated
+	return nil
+}
+
+func (m *Mutation) MutateReal(row *map[string]interface{}) error {
+	mutated, err := m.mutateAtom((*row)[m.Column].(float64))
+	if err != nil {
 		return err
 	}
 	(*row)[m.Column] = mutated
@@ -1357,10 +1964,10 @@ func (m *Mutation) insertToSet(original *libovsdb.OvsSet, toInsert interface{})
 		m.txn.log.Error(err, "failed to convert mutation value", "value", toInsert)
 		return nil, err
 	}
-	mutated := new(libovsdb.OvsSet)
-	copier.Copy(mutated, original)
+	mutated := &libovsdb.OvsSet{GoSet: make([]interface{}, 0, len(original.GoSet)+len(toInsertSet.GoSet))}
+	mutated.GoSet = append(mutated.GoSet, original.GoSet...)
 	for _, v := range toInsertSet.GoSet {
-		if !inSet(original, v) {
+		if !inSet(mutated, v) {
 			mutated.GoSet = append(mutated.GoSet, v)
 		}
 	}
@@ -1375,22 +1982,40 @@ func (m *Mutation) deleteFromSet(original *libovsdb.OvsSet, toDelete interface{}
 		m.txn.log.Error(err, "failed to convert mutation value", "value", toDelete)
 		return nil, err
 	}
-	mutated := new(libovsdb.OvsSet)
+	mutated := &libovsdb.OvsSet{GoSet: []interface{}{}}
 	for _, current := range original.GoSet {
-		found := false
-		for _, v := range toDeleteSet.GoSet {
-			if isEqualValue(current, v) {
-				found = true
-				break
-			}
-		}
-		if !found {
+		if !inSet(&toDeleteSet, current) {
 			mutated.GoSet = append(mutated.GoSet, current)
 		}
 	}
 	return mutated, nil
 }
 
+// arithmeticSet applies the arithmetic mutator to every element of the set, the result must not contain duplicates
+func (m *Mutation) arithmeticSet(original *libovsdb.OvsSet) (*libovsdb.OvsSet, error) {
+	var err error
+	keyType := m.ColumnSchema.TypeObj.Key.Type
+	if keyType != libovsdb.TypeInteger && keyType != libovsdb.TypeReal {
+		err = errors.New(E_CONSTRAINT_VIOLATION)
+		m.txn.log.Error(err, "unsupported arithmetic mutation of set", "column", m.Column, "type", keyType)
+		return nil, err
+	}
+	mutated := &libovsdb.OvsSet{GoSet: make([]interface{}, 0, len(original.GoSet))}
+	for _, current := range original.GoSet {
+		v, err := m.mutateAtom(current)
+		if err != nil {
+			return nil, err
+		}
+		if inSet(mutated, v) {
+			err = errors.New(E_CONSTRAINT_VIOLATION)
+			m.txn.log.Error(err, "result of mutation contains duplicate values", "column", m.Column, "mutator", m.Mutator)
+			return nil, err
+		}
+		mutated.GoSet = append(mutated.GoSet, v)
+	}
+	return mutated, nil
+}
+
 func (m *Mutation) MutateSet(row *map[string]interface{}) error {
 	original := (*row)[m.Column].(libovsdb.OvsSet)
 	var mutated *libovsdb.OvsSet
@@ -1400,6 +2025,8 @@ func (m *Mutation) MutateSet(row *map[string]interface{}) error {
 		mutated, err = m.insertToSet(&original, m.Value)
 	case MT_DELETE:
 		mutated, err = m.deleteFromSet(&original, m.Value)
+	case MT_SUM, MT_DIFFERENCE, MT_PRODUCT, MT_QUOTIENT, MT_REMAINDER:
+		mutated, err = m.arithmeticSet(&original)
 	default:
 		err = errors.New(E_CONSTRAINT_VIOLATION)
 		m.txn.log.Error(err, "unsupported mutation mutator:", "mutator", m.Mutator)
@@ -1411,13 +2038,18 @@ func (m *Mutation) MutateSet(row *map[string]interface{}) error {
 	return nil
 }
 
+// insertToMap inserts the pairs, which keys aren't in the map, the values of the existing keys are not replaced
 func (m *Mutation) insertToMap(original *libovsdb.OvsMap, toInsert interface{}) (*libovsdb.OvsMap, error) {
-	mutated := new(libovsdb.OvsMap)
-	copier.Copy(&mutated, &original)
+	mutated := &libovsdb.OvsMap{GoMap: make(map[interface{}]interface{}, len(original.GoMap))}
+	for k, v := range original.GoMap {
+		mutated.GoMap[k] = v
+	}
 	switch toInsert := toInsert.(type) {
 	case libovsdb.OvsMap:
 		for k, v := range toInsert.GoMap {
-			mutated.GoMap[k] = v
+			if _, ok := mutated.GoMap[k]; !ok {
+				mutated.GoMap[k] = v
+			}
 		}
 	default:
 		err := errors.New(E_CONSTRAINT_VIOLATION)
@@ -1427,13 +2059,16 @@ func (m *Mutation) insertToMap(original *libovsdb.OvsMap, toInsert interface{})
 	return mutated, nil
 }
 
+// deleteFromMap deletes the pairs of the given map, or the given set of keys
 func (m *Mutation) deleteFromMap(original *libovsdb.OvsMap, toDelete interface{}) (*libovsdb.OvsMap, error) {
-	mutated := new(libovsdb.OvsMap)
-	copier.Copy(&mutated, &original)
+	mutated := &libovsdb.OvsMap{GoMap: make(map[interface{}]interface{}, len(original.GoMap))}
+	for k, v := range original.GoMap {
+		mutated.GoMap[k] = v
+	}
 	switch toDelete := toDelete.(type) {
 	case libovsdb.OvsMap:
 		for k, v := range toDelete.GoMap {
-			if mutated.GoMap[k] == v {
+			if current, ok := mutated.GoMap[k]; ok && isEqualValue(current, v) {
 				delete(mutated.GoMap, k)
 			}
 		}
@@ -1441,13 +2076,17 @@ func (m *Mutation) deleteFromMap(original *libovsdb.OvsMap, toDelete interface{}
 		for _, k := range toDelete.GoSet {
 			delete(mutated.GoMap, k)
 		}
+	default:
+		err := errors.New(E_CONSTRAINT_VIOLATION)
+		m.txn.log.Error(err, "unsupported mutator value type", "value", toDelete)
+		return nil, err
 	}
 	return mutated, nil
 }
 
 func (m *Mutation) MutateMap(row *map[string]interface{}) error {
 	original := (*row)[m.Column].(libovsdb.OvsMap)
-	mutated := new(libovsdb.OvsMap)
+	var mutated *libovsdb.OvsMap
 	var err error
 	switch m.Mutator {
 	case MT_INSERT:
@@ -1466,10 +2105,24 @@ func (m *Mutation) MutateMap(row *map[string]interface{}) error {
 	return nil
 }
 
+// validateMutated validates the mutated value of the column, the sizes of sets and maps and the constraints of their
+// elements
+func (m *Mutation) validateMutated(row *map[string]interface{}) error {
+	value := (*row)[m.Column]
+	err := m.ColumnSchema.Validate(value)
+	if err == nil {
+		err = validateColumnConstraints(m.ColumnSchema, value)
+	}
+	if err != nil {
+		m.txn.log.Error(err, "mutated value violates the column constraints", "column", m.Column, "mutator", m.Mutator)
+		return errors.New(E_CONSTRAINT_VIOLATION)
+	}
+	return nil
+}
+
 func (m *Mutation) Mutate(row *map[string]interface{}) error {
 	var err error
-	switch m.Column {
-	case COL_UUID, COL_VERSION:
+	if InternalColumns.IsInternal(m.Column) {
 		err = errors.New(E_CONSTRAINT_VIOLATION)
 		m.txn.log.Error(err, "can't mutate column", "column", m.Column)
 		return err
@@ -1481,18 +2134,21 @@ func (m *Mutation) Mutate(row *map[string]interface{}) error {
 	}
 	switch m.ColumnSchema.Type {
 	case libovsdb.TypeInteger:
-		return m.MutateInteger(row)
+		err = m.MutateInteger(row)
 	case libovsdb.TypeReal:
-		return m.MutateReal(row)
+		err = m.MutateReal(row)
 	case libovsdb.TypeSet:
-		return m.MutateSet(row)
+		err = m.MutateSet(row)
 	case libovsdb.TypeMap:
-		return m.MutateMap(row)
+		err = m.MutateMap(row)
 	default:
 		err = errors.New(E_CONSTRAINT_VIOLATION)
 		m.txn.log.Error(err, "unsupported column schema type", "type", m.ColumnSchema.Type)
+	}
+	if err != nil {
 		return err
 	}
+	return m.validateMutated(row)
 }
 
 func (txn *Transaction) RowMutate(tableSchema *libovsdb.TableSchema, mapUUID MapUUID, original *map[string]interface{}, mutations *[]interface{}) (*map[string]interface{}, error) {
@@ -1558,8 +2214,7 @@ func (txn *Transaction) RowUpdate(tableSchema *libovsdb.TableSchema, mapUUID Map
 			txn.log.Error(err, "failed column schema lookup", "column", column)
 			return nil, err
 		}
-		switch column {
-		case COL_UUID, COL_VERSION:
+		if InternalColumns.IsInternal(column) {
 			err = errors.New(E_CONSTRAINT_VIOLATION)
 			txn.log.Error(err, "failed update of column", "column", column)
 			return nil, err
@@ -1576,8 +2231,16 @@ func (txn *Transaction) RowUpdate(tableSchema *libovsdb.TableSchema, mapUUID Map
 }
 
 func etcdGetData(txn *Transaction, key *common.Key) {
-	etcdOp := clientv3.OpGet(key.String(), clientv3.WithPrefix())
-	// XXX: eliminate duplicate GETs
+	// the inserts of many rows into a table read the table once
+	prefix := key.String()
+	if txn.reads[prefix] {
+		return
+	}
+	if txn.reads == nil {
+		txn.reads = map[string]bool{}
+	}
+	txn.reads[prefix] = true
+	etcdOp := clientv3.OpGet(prefix, clientv3.WithPrefix())
 	txn.etcd.Then = append(txn.etcd.Then, etcdOp)
 }
 
@@ -1684,6 +2347,7 @@ func etcdModifyRow(txn *Transaction, k *common.Key, row *map[string]interface{})
 
 	etcdOp := clientv3.OpPut(key, val)
 	txn.etcd.Then = append(txn.etcd.Then, etcdOp)
+	txn.countRowWrite(key)
 
 	prevRow := txn.cache.Row(*k)
 	prevVal, err := makeValue(prevRow)
@@ -1702,6 +2366,7 @@ func etcdDeleteRow(txn *Transaction, k *common.Key) error {
 	key := k.String()
 	etcdOp := clientv3.OpDelete(key)
 	txn.etcd.Then = append(txn.etcd.Then, etcdOp)
+	txn.countRowWrite(key)
 
 	prevVal, err := makeValue(txn.cache.Row(*k))
 	if err != nil {
@@ -1732,9 +2397,12 @@ func (txn *Transaction) RowPrepare(tableSchema *libovsdb.TableSchema, mapUUID Ma
 	}
 
 	err = tableSchema.Validate(row)
+	if err == nil {
+		err = validateRowConstraints(tableSchema, row)
+	}
 	if err != nil {
-		err = errors.New(E_CONSTRAINT_VIOLATION)
 		txn.log.Error(err, "failed schema validation of row")
+		err = errors.New(E_CONSTRAINT_VIOLATION)
 		return err
 	}
 	return nil
@@ -1793,9 +2461,17 @@ func doInsert(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.O
 		}
 	}
 
+	if err = txn.rbac.checkInsert(txn, *ovsOp.Table); err != nil {
+		return err
+	}
+
 	ovsResult.InitUUID(uuid)
 
 	key := common.NewDataKey(txn.request.DBName, *ovsOp.Table, uuid)
+	if ovsOp.UUID != nil {
+		// the requested uuid is not created concurrently
+		txn.readNone(key.String())
+	}
 	row := &map[string]interface{}{}
 
 	*row = *ovsOp.Row
@@ -1816,8 +2492,205 @@ func doInsert(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.O
 	return err
 }
 
+/* upsert */
+
+// upsertWhere returns the conditions matching the rows to update: the operation "where", or equality of the columns
+// of the first table index, whose all columns are specified in the row. It also returns the index columns, nil if the
+// operation "where" is used.
+func upsertWhere(txn *Transaction, tableSchema *libovsdb.TableSchema, ovsOp *libovsdb.Operation) (*[]interface{}, []string, error) {
+	if ovsOp.Where != nil {
+		return ovsOp.Where, nil, nil
+	}
+	if ovsOp.Row != nil {
+		for _, index := range tableSchema.Indexes {
+			where := []interface{}{}
+			for _, column := range index {
+				value, ok := (*ovsOp.Row)[column]
+				if !ok {
+					break
+				}
+				where = append(where, []interface{}{column, FN_EQ, value})
+			}
+			if len(where) == len(index) {
+				return &where, index, nil
+			}
+		}
+	}
+	err := errors.New(E_CONSTRAINT_VIOLATION)
+	txn.log.Error(err, "upsert requires where or values of all the columns of a table index", "table", *ovsOp.Table)
+	return nil, nil, err
+}
+
+// refersUUIDName returns true if the value, in the JSON or in the parsed notation, contains the named-uuid
+func refersUUIDName(value interface{}, uuidName string) bool {
+	switch v := value.(type) {
+	case libovsdb.UUID:
+		return v.GoUUID == uuidName
+	case libovsdb.OvsSet:
+		return refersUUIDName(v.GoSet, uuidName)
+	case libovsdb.OvsMap:
+		for key, value := range v.GoMap {
+			if refersUUIDName(key, uuidName) || refersUUIDName(value, uuidName) {
+				return true
+			}
+		}
+	case []interface{}:
+		if len(v) == 2 && v[0] == "named-uuid" {
+			return v[1] == uuidName
+		}
+		for _, element := range v {
+			if refersUUIDName(element, uuidName) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, value := range v {
+			if refersUUIDName(value, uuidName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkUpsertUUIDName rejects the operations, which precede the upsert and refer to its uuid-name, as the uuid-name
+// is resolved to the matched row by the execution of the upsert only, after the preceding operations were executed.
+func checkUpsertUUIDName(txn *Transaction, uuidName string) error {
+	for _, op := range txn.request.Operations {
+		if op.Op == OP_UPSERT && op.UUIDName != nil && *op.UUIDName == uuidName {
+			return nil
+		}
+		var refers bool
+		if op.Row != nil {
+			refers = refers || refersUUIDName(*op.Row, uuidName)
+		}
+		if op.Rows != nil {
+			for _, row := range *op.Rows {
+				refers = refers || refersUUIDName(row, uuidName)
+			}
+		}
+		if op.Where != nil {
+			refers = refers || refersUUIDName(*op.Where, uuidName)
+		}
+		if op.Mutations != nil {
+			refers = refers || refersUUIDName(*op.Mutations, uuidName)
+		}
+		if refers {
+			err := errors.New(E_CONSTRAINT_VIOLATION)
+			txn.log.Error(err, "the uuid-name of an upsert is referred by a preceding operation", "uuid-name", uuidName,
+				"op", op.Op)
+			return err
+		}
+	}
+	return nil
+}
+
+func preUpsert(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.OperationResult) error {
+	tableSchema, err := txn.schemas.LookupTable(txn.request.DBName, *ovsOp.Table)
+	if err != nil {
+		return errors.New(E_INTERNAL_ERROR)
+	}
+	if ovsOp.Row == nil {
+		err := errors.New(E_CONSTRAINT_VIOLATION)
+		txn.log.Error(err, "upsert requires a row", "table", *ovsOp.Table)
+		return err
+	}
+	if _, _, err := upsertWhere(txn, tableSchema, ovsOp); err != nil {
+		return err
+	}
+	if ovsOp.UUIDName != nil {
+		if _, ok := txn.mapUUID[*ovsOp.UUIDName]; ok {
+			err = errors.New(E_DUP_UUIDNAME)
+			txn.log.Error(err, "duplicate uuid-name", "uuid-name", *ovsOp.UUIDName)
+			return err
+		}
+		if err := checkUpsertUUIDName(txn, *ovsOp.UUIDName); err != nil {
+			return err
+		}
+		// replaced by the uuid of the updated row, if a single row is updated
+		txn.mapUUID.Set(txn, *ovsOp.UUIDName, common.GenerateUUID())
+	}
+	key := common.NewTableKey(txn.request.DBName, *ovsOp.Table)
+	etcdGetData(txn, &key)
+	return nil
+}
+
+// doUpsert inserts the row if no row is matched, otherwise it updates the matched rows. The result contains the uuid
+// of the inserted row, or the count of the updated rows and the uuid of the updated row, if it is a single one.
+func doUpsert(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.OperationResult) error {
+	tableSchema, err := txn.schemas.LookupTable(txn.request.DBName, *ovsOp.Table)
+	if err != nil {
+		return errors.New(E_INTERNAL_ERROR)
+	}
+	where, index, err := upsertWhere(txn, tableSchema, ovsOp)
+	if err != nil {
+		return err
+	}
+	matched := []string{}
+	for uuid, row := range txn.cache.Table(txn.request.DBName, *ovsOp.Table) {
+		ok, err := txn.isRowSelectedByWhere(tableSchema, txn.mapUUID, row, where)
+		if err != nil {
+			txn.log.Error(err, "failed to select row by where", "row", row, "where", where)
+			return err
+		}
+		if ok {
+			matched = append(matched, uuid)
+		}
+	}
+	if len(matched) == 0 {
+		if index == nil {
+			// an upsert by an index is guarded by the index entries of the inserted row, otherwise no row of the table
+			// is created or modified before the commit, so concurrent upserts don't insert matching rows
+			txn.readNone(common.NewTableKey(txn.request.DBName, *ovsOp.Table).String())
+		}
+		insertOp := *ovsOp
+		insertOp.Op = OP_INSERT
+		insertOp.Where = nil
+		return doInsert(txn, &insertOp, ovsResult)
+	}
+	if len(matched) > 1 && ovsOp.UUIDName != nil {
+		err := errors.New(E_CONSTRAINT_VIOLATION)
+		txn.log.Error(err, "the uuid-name of the upsert refers to several rows", "uuid-name", *ovsOp.UUIDName,
+			"rows", len(matched))
+		return err
+	}
+
+	// the index columns are equal in the matched rows, and they can be immutable
+	updateRow := map[string]interface{}{}
+	for column, value := range *ovsOp.Row {
+		updateRow[column] = value
+	}
+	for _, column := range index {
+		delete(updateRow, column)
+	}
+	updateOp := *ovsOp
+	updateOp.Op = OP_UPDATE
+	updateOp.Where = where
+	updateOp.Row = &updateRow
+	if err := doUpdate(txn, &updateOp, ovsResult); err != nil {
+		return err
+	}
+	if len(matched) == 1 {
+		ovsResult.InitUUID(matched[0])
+		if ovsOp.UUIDName != nil {
+			txn.mapUUID.Set(txn, *ovsOp.UUIDName, matched[0])
+		}
+	}
+	return nil
+}
+
 /* select */
 func preSelect(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.OperationResult) error {
+	tableSchema, err := txn.schemas.LookupTable(txn.request.DBName, *ovsOp.Table)
+	if err != nil {
+		return errors.New(E_INTERNAL_ERROR)
+	}
+	if err := txn.checkColumns(tableSchema, ovsOp.Columns); err != nil {
+		return err
+	}
+	if ovsOp.PageSize != nil {
+		return etcdGetPage(txn, ovsOp, ovsResult)
+	}
 	return etcdGetByWhere(txn, ovsOp, ovsResult)
 }
 
@@ -1827,8 +2700,13 @@ func doSelect(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.O
 	if err != nil {
 		return errors.New(E_INTERNAL_ERROR)
 	}
+	if page, ok := txn.pages[ovsResult]; ok {
+		return doSelectPage(txn, tableSchema, page, ovsOp, ovsResult)
+	}
 
-	for _, row := range txn.cache.Table(txn.request.DBName, *ovsOp.Table) {
+	table := txn.cache.Table(txn.request.DBName, *ovsOp.Table)
+	for _, uuid := range table.uuids() {
+		row := table[uuid]
 		ok, err := txn.isRowSelectedByWhere(tableSchema, txn.mapUUID, row, ovsOp.Where)
 		if err != nil {
 			txn.log.Error(err, "failed to select row by where", "row", row, "where", ovsOp.Where)
@@ -1837,13 +2715,17 @@ func doSelect(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.O
 		if !ok {
 			continue
 		}
-		resultRow, err := reduceRowByColumns(row, ovsOp.Columns)
+		txn.readRow(common.NewDataKey(txn.request.DBName, *ovsOp.Table, uuid))
+		resultRow, err := reduceRowByColumns(tableSchema, row, ovsOp.Columns)
 		if err != nil {
 			txn.log.Error(err, "failed to reduce row by columns", "row", row, "columns", ovsOp.Columns)
 			return err
 		}
 		ovsResult.AppendRows(*resultRow)
 	}
+	if len(*ovsResult.Rows) == 0 {
+		txn.readNone(txn.wherePrefix(tableSchema, ovsOp))
+	}
 	return nil
 }
 
@@ -1867,6 +2749,9 @@ func doUpdate(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.O
 		if !ok {
 			continue
 		}
+		if err = txn.rbac.checkUpdate(txn, *ovsOp.Table, ovsOp.Row, row); err != nil {
+			return err
+		}
 
 		err = txn.RowPrepare(tableSchema, txn.mapUUID, ovsOp.Row)
 		if err != nil {
@@ -1886,6 +2771,9 @@ func doUpdate(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.O
 		*(txn.cache.Row(key)) = *newRow
 		ovsResult.IncrementCount()
 	}
+	if *ovsResult.Count == 0 {
+		txn.readNone(txn.wherePrefix(tableSchema, ovsOp))
+	}
 	return nil
 }
 
@@ -1909,6 +2797,9 @@ func doMutate(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.O
 		if !ok {
 			continue
 		}
+		if err = txn.rbac.checkMutate(txn, *ovsOp.Table, ovsOp.Mutations, row); err != nil {
+			return err
+		}
 		newRow, err := txn.RowMutate(tableSchema, txn.mapUUID, row, ovsOp.Mutations)
 		if err != nil {
 			txn.log.Error(err, "failed to row mutate", "row", row, "mutations", ovsOp.Mutations)
@@ -1918,9 +2809,15 @@ func doMutate(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.O
 		setRowVersion(newRow)
 		key := common.NewDataKey(txn.request.DBName, *ovsOp.Table, uuid)
 		etcdModifyRow(txn, &key, newRow)
+		if txn.isCommutative(*ovsOp.Table, tableSchema, ovsOp.Mutations) {
+			txn.addMerge(key, tableSchema, ovsOp.Mutations)
+		}
 		*(txn.cache.Row(key)) = *newRow
 		ovsResult.IncrementCount()
 	}
+	if *ovsResult.Count == 0 {
+		txn.readNone(txn.wherePrefix(tableSchema, ovsOp))
+	}
 	return nil
 }
 
@@ -1944,10 +2841,16 @@ func doDelete(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.O
 		if !ok {
 			continue
 		}
+		if err = txn.rbac.checkDelete(txn, *ovsOp.Table, row); err != nil {
+			return err
+		}
 		key := common.NewDataKey(txn.request.DBName, *ovsOp.Table, uuid)
 		etcdDeleteRow(txn, &key)
 		ovsResult.IncrementCount()
 	}
+	if *ovsResult.Count == 0 {
+		txn.readNone(txn.wherePrefix(tableSchema, ovsOp))
+	}
 	return nil
 }
 
@@ -1959,9 +2862,6 @@ func preWait(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.Op
 		txn.log.Error(err, "missing timeout parameter")
 		return err
 	}
-	if *ovsOp.Timeout != 0 {
-		txn.log.Info("ignoring non-zero wait timeout", "timeout", *ovsOp.Timeout)
-	}
 	return etcdGetByWhere(txn, ovsOp, ovsResult)
 }
 
@@ -2009,7 +2909,7 @@ func doWait(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.Ope
 		return err
 	}
 
-	for _, actual := range txn.cache.Table(txn.request.DBName, *ovsOp.Table) {
+	for uuid, actual := range txn.cache.Table(txn.request.DBName, *ovsOp.Table) {
 		log := txn.log.WithValues("row", actual)
 		ok, err := txn.isRowSelectedByWhere(tableSchema, txn.mapUUID, actual, ovsOp.Where)
 		if err != nil {
@@ -2019,9 +2919,10 @@ func doWait(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.Ope
 		if !ok {
 			continue
 		}
+		txn.readRow(common.NewDataKey(txn.request.DBName, *ovsOp.Table, uuid))
 
 		if ovsOp.Columns != nil {
-			actual, err = reduceRowByColumns(actual, ovsOp.Columns)
+			actual, err = reduceRowByColumns(tableSchema, actual, ovsOp.Columns)
 			if err != nil {
 				log.Error(err, "failed column reduction")
 				return err
@@ -2052,6 +2953,8 @@ func doWait(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.Ope
 	}
 
 	if !equal {
+		// a row equal to the expected rows is not created concurrently
+		txn.readNone(txn.wherePrefix(tableSchema, ovsOp))
 		return nil
 	}
 
@@ -2091,14 +2994,19 @@ func doAbort(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.Op
 
 /* comment */
 func preComment(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.OperationResult) error {
+	if ovsOp.Comment == nil {
+		err := errors.New(E_CONSTRAINT_VIOLATION)
+		txn.log.Error(err, "missing comment parameter")
+		return err
+	}
 	return nil
 }
 
 func doComment(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.OperationResult) error {
-	timestamp := time.Now().Format(time.RFC3339)
-	key := common.NewCommentKey(timestamp)
-	comment := *ovsOp.Comment
-	etcdOp := clientv3.OpPut(key.String(), comment)
+	etcdOp, err := commentOp(txn.request.DBName, *ovsOp.Comment)
+	if err != nil {
+		return errors.New(E_INTERNAL_ERROR)
+	}
 	txn.etcd.Then = append(txn.etcd.Then, etcdOp)
 	txn.etcd.Events = append(txn.etcd.Events, nil) /* so that events are aligned with then operations */
 	txn.etcd.Assert()
@@ -2108,9 +3016,36 @@ func doComment(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.
 
 /* assert */
 func preAssert(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.OperationResult) error {
+	if ovsOp.Lock == nil {
+		err := errors.New(E_CONSTRAINT_VIOLATION)
+		txn.log.Error(err, "missing lock of assert")
+		return err
+	}
+	locker, ok := txn.locks[*ovsOp.Lock]
+	if !ok {
+		err := errors.New(E_NOT_OWNER)
+		txn.log.Error(err, "assert of a lock, which wasn't requested", "lock", *ovsOp.Lock)
+		return err
+	}
+	cmps, err := locker.ownership(txn.etcd.Ctx)
+	if err == errNotOwner {
+		txn.log.Error(err, "assert of a lock, which isn't held", "lock", *ovsOp.Lock)
+		return err
+	}
+	if err != nil {
+		txn.log.Error(err, "lock ownership", "lock", *ovsOp.Lock)
+		return errors.New(E_IO_ERROR)
+	}
+	if txn.lockCompares == nil {
+		txn.lockCompares = map[string][]clientv3.Cmp{}
+	}
+	txn.lockCompares[*ovsOp.Lock] = cmps
 	return nil
 }
 
+// doAssert guards the etcd transaction by the ownership of the lock, so the changes aren't committed if the lock is lost
+// after it was verified
 func doAssert(txn *Transaction, ovsOp *libovsdb.Operation, ovsResult *libovsdb.OperationResult) error {
+	txn.etcd.If = append(txn.etcd.If, txn.lockCompares[*ovsOp.Lock]...)
 	return nil
 }
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -177,6 +179,74 @@ var testSchemaMap *libovsdb.DatabaseSchema = &libovsdb.DatabaseSchema{
 	},
 }
 
+var testSchemaMutate *libovsdb.DatabaseSchema = &libovsdb.DatabaseSchema{
+	Name:    "mutate",
+	Version: "0.0.0.0",
+	Tables: map[string]libovsdb.TableSchema{
+		"table1": {
+			Columns: map[string]*libovsdb.ColumnSchema{
+				"counter": {
+					Type: libovsdb.TypeInteger,
+					TypeObj: &libovsdb.ColumnType{
+						Key: &libovsdb.BaseType{
+							Type:       libovsdb.TypeInteger,
+							MaxInteger: 10,
+						},
+						Min: 1,
+						Max: 1,
+					},
+				},
+				"real": {
+					Type: libovsdb.TypeReal,
+				},
+				"optional": {
+					Type: libovsdb.TypeSet,
+					TypeObj: &libovsdb.ColumnType{
+						Key: &libovsdb.BaseType{
+							Type: libovsdb.TypeInteger,
+						},
+						Min: 0,
+						Max: 1,
+					},
+				},
+				"integers": {
+					Type: libovsdb.TypeSet,
+					TypeObj: &libovsdb.ColumnType{
+						Key: &libovsdb.BaseType{
+							Type: libovsdb.TypeInteger,
+						},
+						Min: 0,
+						Max: libovsdb.Unlimited,
+					},
+				},
+				"strings": {
+					Type: libovsdb.TypeSet,
+					TypeObj: &libovsdb.ColumnType{
+						Key: &libovsdb.BaseType{
+							Type: libovsdb.TypeString,
+						},
+						Min: 1,
+						Max: 2,
+					},
+				},
+				"map": {
+					Type: libovsdb.TypeMap,
+					TypeObj: &libovsdb.ColumnType{
+						Key: &libovsdb.BaseType{
+							Type: libovsdb.TypeString,
+						},
+						Value: &libovsdb.BaseType{
+							Type: libovsdb.TypeString,
+						},
+						Min: 0,
+						Max: libovsdb.Unlimited,
+					},
+				},
+			},
+		},
+	},
+}
+
 var testSchemaUUID *libovsdb.DatabaseSchema = &libovsdb.DatabaseSchema{
 	Name:    "uuid",
 	Version: "0.0.0.0",
@@ -273,6 +343,7 @@ func testTransact(t *testing.T, req *libovsdb.Transact) (*libovsdb.TransactRespo
 	txn.AddSchema(testSchemaSet)
 	txn.AddSchema(testSchemaMap)
 	txn.AddSchema(testSchemaUUID)
+	txn.AddSchema(testSchemaMutate)
 	txn.Commit()
 	return &txn.response, txn
 }
@@ -318,6 +389,46 @@ func TestTransactInsertSimple(t *testing.T) {
 	assert.Equal(t, int(0), dump["key2"])
 }
 
+func TestTransactReadOnly(t *testing.T) {
+	table := "table1"
+	row := map[string]interface{}{
+		"key1": "val1",
+	}
+	comment := "read-only"
+	req := &libovsdb.Transact{
+		DBName: "simple",
+		Operations: []libovsdb.Operation{
+			{
+				Op:      OP_COMMENT,
+				Comment: &comment,
+			},
+			{
+				Op:    OP_INSERT,
+				Table: &table,
+				Row:   &row,
+			},
+		},
+	}
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	txn := NewTransaction(cli, klogr.New(), req)
+	txn.AddSchema(testSchemaSimple)
+	txn.readOnly = true
+	_, err = txn.Commit()
+	assert.NotNil(t, err)
+	if assert.Equal(t, 2, len(txn.response.Result)) {
+		assert.Nil(t, txn.response.Result[0].Error)
+		assert.Equal(t, E_NOT_ALLOWED, *txn.response.Result[1].Error)
+		assert.NotNil(t, txn.response.Result[1].Details)
+	}
+	resp, err := cli.Get(context.Background(), common.NewTableKey("simple", table).String(), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(resp.Kvs))
+}
+
 func testTransactInsertSimpleScale(t *testing.T, n int) {
 	table := "table1"
 	row := map[string]interface{}{
@@ -353,22 +464,47 @@ func TestTransactInsertSimpleScale100(t *testing.T) {
 	testTransactInsertSimpleScale(t, 100)
 }
 
-/*
-XXX: If run with default setting then it will fail with message:
-XXX:
-XXX:   etcdserver: too many operations in txn request
-XXX:
-XXX: To enable the test to pass:
-XXX: 1. use etcd version 3.4.x and above
-XXX: 2. configure in /etc/default/etcd:
-XXX:
-XXX:   ETCD_MAX_TXN_OPS=100000
-XXX:   ETCD_MAX_REQUEST_BYTES=100000000
-XXX:
+// the transaction exceeds the etcd limit of operations per transaction, and is committed by a chain of transactions
 func TestTransactInsertSimpleScale1000(t *testing.T) {
 	testTransactInsertSimpleScale(t, 1000)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	res, err := cli.Get(context.TODO(), common.NewTableKey("simple", "table1").String(), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), res.Count)
+	res, err = cli.Get(context.TODO(), common.NewJournalKey("simple").String())
+	assert.Nil(t, err)
+	assert.Empty(t, res.Kvs)
+}
+
+// the inserts of a transaction read the table once, and their rows are written by a single chained commit
+func TestTransactInsertNamedRowsBatched(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	fi := NewFaultInjector()
+	defer fi.Inject(cli)()
+
+	table := "table1"
+	req := &libovsdb.Transact{DBName: "simple"}
+	for i := 0; i < 200; i++ {
+		uuidName := fmt.Sprintf("row%d", i)
+		row := map[string]interface{}{"key1": uuidName}
+		req.Operations = append(req.Operations, libovsdb.Operation{Op: OP_INSERT, Table: &table, UUIDName: &uuidName, Row: &row})
+	}
+	txn := NewTransaction(cli, klogr.New(), req)
+	txn.AddSchema(testSchemaSimple)
+	_, err = txn.Commit()
+	assert.Nil(t, err)
+	// the read of the table, and the chain of etcd transactions of the commit, which exceeds a single transaction
+	assert.Equal(t, 4, fi.Calls(FAULT_OP_TXN))
+	res, err := cli.Get(context.TODO(), common.NewTableKey("simple", table).String(), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(200), res.Count)
 }
-*/
 
 func TestTransactInsertSimpleWithUUID(t *testing.T) {
 	table := "table1"
@@ -656,6 +792,90 @@ func TestTransactSelect(t *testing.T) {
 	assert.Equal(t, int(3), dump["key2"])
 }
 
+func TestTransactSelectPaging(t *testing.T) {
+	table := "table1"
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	for i := 0; i < 5; i++ {
+		testEtcdPut(t, "simple", table, map[string]interface{}{"key1": "val", "key2": i})
+	}
+	pageSize := 2
+	selectPage := func(token *string) *libovsdb.OperationResult {
+		req := &libovsdb.Transact{
+			DBName:     "simple",
+			Operations: []libovsdb.Operation{{Op: OP_SELECT, Table: &table, PageSize: &pageSize, PageToken: token}},
+		}
+		resp, _ := testTransact(t, req)
+		assert.Nil(t, resp.Error)
+		return &resp.Result[0]
+	}
+
+	res := selectPage(nil)
+	assert.Equal(t, 2, len(*res.Rows))
+	assert.NotNil(t, res.NextPageToken)
+	seen := map[interface{}]bool{}
+	for _, row := range *res.Rows {
+		seen[row["key2"]] = true
+	}
+	// the next pages are read from the snapshot of the first page
+	testEtcdPut(t, "simple", table, map[string]interface{}{"key1": "val", "key2": 5})
+	pages := 1
+	for res.NextPageToken != nil {
+		res = selectPage(res.NextPageToken)
+		pages++
+		for _, row := range *res.Rows {
+			seen[row["key2"]] = true
+		}
+	}
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, 5, len(seen))
+	assert.False(t, seen[5])
+	assert.True(t, seen[4])
+
+	wrong := "wrong"
+	req := &libovsdb.Transact{
+		DBName:     "simple",
+		Operations: []libovsdb.Operation{{Op: OP_SELECT, Table: &table, PageSize: &pageSize, PageToken: &wrong}},
+	}
+	resp, _ := testTransact(t, req)
+	assert.NotNil(t, resp.Error)
+	assert.Equal(t, E_CONSTRAINT_VIOLATION, *resp.Error)
+}
+
+func TestTransactSelectDeterministicOrder(t *testing.T) {
+	DeterministicOrder = true
+	defer func() { DeterministicOrder = false }()
+	table := "table1"
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	for i := 0; i < 10; i++ {
+		testEtcdPut(t, "simple", table, map[string]interface{}{"key1": "val", "key2": i})
+	}
+	req := &libovsdb.Transact{
+		DBName:     "simple",
+		Operations: []libovsdb.Operation{{Op: OP_SELECT, Table: &table}},
+	}
+	var encoded []byte
+	for i := 0; i < 3; i++ {
+		resp, _ := testTransact(t, req)
+		assert.Nil(t, resp.Error)
+		buf, err := json.Marshal(resp.Result[0].Rows)
+		assert.Nil(t, err)
+		if encoded != nil {
+			assert.Equal(t, string(encoded), string(buf))
+		}
+		encoded = buf
+	}
+	var rows []map[string]interface{}
+	assert.Nil(t, json.Unmarshal(encoded, &rows))
+	assert.Equal(t, 10, len(rows))
+	uuids := []string{}
+	for _, row := range rows {
+		uuids = append(uuids, row[COL_UUID].([]interface{})[1].(string))
+	}
+	assert.True(t, sort.StringsAreSorted(uuids))
+}
+
 func TestTransactUpdateSimple(t *testing.T) {
 	table := "table1"
 	row1 := map[string]interface{}{
@@ -978,6 +1198,55 @@ func TestTransactMutateMapNamedUUID(t *testing.T) {
 	assert.Nil(t, resp.Error)
 }
 
+func TestTransactNamedUUIDMapKeys(t *testing.T) {
+	schema := &libovsdb.DatabaseSchema{
+		Name:    "uuidkeys",
+		Version: "0.0.0.0",
+		Tables: map[string]libovsdb.TableSchema{
+			"table1": {Columns: map[string]*libovsdb.ColumnSchema{}},
+			"table2": {
+				Columns: map[string]*libovsdb.ColumnSchema{
+					"map": {
+						Type: libovsdb.TypeMap,
+						TypeObj: &libovsdb.ColumnType{
+							Key:   &libovsdb.BaseType{Type: libovsdb.TypeUUID},
+							Value: &libovsdb.BaseType{Type: libovsdb.TypeString},
+							Min:   0,
+							Max:   libovsdb.Unlimited,
+						},
+					},
+				},
+			},
+		},
+	}
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	var params []interface{}
+	// the map refers to the row inserted by the following operation
+	err = json.Unmarshal([]byte(`["uuidkeys",
+		{"op": "insert", "table": "table2", "row": {"map": ["map", [[["named-uuid", "row1"], "first"]]]}},
+		{"op": "insert", "table": "table1", "uuid-name": "row1", "row": {}}]`), &params)
+	assert.Nil(t, err)
+	req, err := libovsdb.NewTransact(params)
+	assert.Nil(t, err)
+	txn := NewTransaction(cli, klogr.New(), req)
+	txn.AddSchema(schema)
+	_, err = txn.Commit()
+	assert.Nil(t, err)
+	row1UUID := txn.response.Result[1].UUID.GoUUID
+
+	res, err := cli.Get(context.TODO(), common.NewTableKey("uuidkeys", "table2").String(), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(res.Kvs))
+	row, err := unmarshalData(res.Kvs[0].Value)
+	assert.Nil(t, err)
+	assert.Nil(t, schema.Unmarshal("table2", &row))
+	assert.Equal(t, map[interface{}]interface{}{libovsdb.UUID{GoUUID: row1UUID}: "first"}, row["map"].(libovsdb.OvsMap).GoMap)
+}
+
 func TestTransactMutateSet(t *testing.T) {
 	table := "table1"
 	row1 := map[string]interface{}{
@@ -1039,6 +1308,110 @@ func TestTransactMutateSet(t *testing.T) {
 	assert.Equal(t, expected, dump["uuid"])
 }
 
+func testTransactMutate(t *testing.T, mutations ...interface{}) (*libovsdb.TransactResponse, map[string]interface{}) {
+	table := "table1"
+	row := map[string]interface{}{
+		"counter":  int(1),
+		"real":     float64(1.5),
+		"optional": libovsdb.OvsSet{GoSet: []interface{}{int(3)}},
+		"integers": libovsdb.OvsSet{GoSet: []interface{}{int(1), int(2)}},
+		"strings":  libovsdb.OvsSet{GoSet: []interface{}{"a"}},
+		"map":      libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"a": "1", "b": "2"}},
+	}
+	req := &libovsdb.Transact{
+		DBName: "mutate",
+		Operations: []libovsdb.Operation{
+			{
+				Op:    OP_INSERT,
+				Table: &table,
+				Row:   &row,
+			},
+			{
+				Op:        OP_MUTATE,
+				Table:     &table,
+				Mutations: &mutations,
+			},
+		},
+	}
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	resp, txn := testTransact(t, req)
+	if resp.Error != nil {
+		return resp, nil
+	}
+	return resp, testTransactDump(t, txn, "mutate", "table1")
+}
+
+func TestTransactMutateArithmetic(t *testing.T) {
+	resp, dump := testTransactMutate(t,
+		[]interface{}{"counter", MT_PRODUCT, int(7)},
+		[]interface{}{"counter", MT_REMAINDER, int(4)},
+		[]interface{}{"real", MT_QUOTIENT, float64(0.5)},
+		[]interface{}{"optional", MT_DIFFERENCE, int(5)},
+		[]interface{}{"integers", MT_SUM, int(10)},
+	)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, int(3), dump["counter"])
+	assert.Equal(t, float64(3), dump["real"])
+	assert.Equal(t, libovsdb.OvsSet{GoSet: []interface{}{int(-2)}}, dump["optional"])
+	assert.Equal(t, libovsdb.OvsSet{GoSet: []interface{}{int(11), int(12)}}, dump["integers"])
+}
+
+func TestTransactMutateArithmeticErrors(t *testing.T) {
+	for _, tc := range []struct {
+		mutation []interface{}
+		err      string
+	}{
+		{[]interface{}{"counter", MT_QUOTIENT, int(0)}, E_DOMAIN_ERROR},
+		{[]interface{}{"integers", MT_REMAINDER, int(0)}, E_DOMAIN_ERROR},
+		{[]interface{}{"real", MT_REMAINDER, float64(2)}, E_CONSTRAINT_VIOLATION},
+		{[]interface{}{"counter", MT_SUM, int(10)}, E_CONSTRAINT_VIOLATION},
+		{[]interface{}{"counter", MT_SUM, maxInt}, E_RANGE_ERROR},
+		{[]interface{}{"integers", MT_PRODUCT, int(0)}, E_CONSTRAINT_VIOLATION},
+		{[]interface{}{"strings", MT_SUM, "a"}, E_CONSTRAINT_VIOLATION},
+		{[]interface{}{"map", MT_SUM, int(1)}, E_CONSTRAINT_VIOLATION},
+	} {
+		resp, _ := testTransactMutate(t, tc.mutation)
+		if assert.NotNil(t, resp.Error, tc.mutation) {
+			assert.Equal(t, tc.err, *resp.Error, tc.mutation)
+		}
+	}
+}
+
+func TestTransactMutateSetSize(t *testing.T) {
+	// the mutation value isn't limited by the column size, the mutated value is
+	resp, dump := testTransactMutate(t,
+		[]interface{}{"strings", MT_INSERT, libovsdb.OvsSet{GoSet: []interface{}{"a", "b"}}},
+		[]interface{}{"strings", MT_DELETE, libovsdb.OvsSet{GoSet: []interface{}{"a", "c", "d"}}},
+	)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, libovsdb.OvsSet{GoSet: []interface{}{"b"}}, dump["strings"])
+
+	resp, _ = testTransactMutate(t, []interface{}{"strings", MT_INSERT, libovsdb.OvsSet{GoSet: []interface{}{"b", "c"}}})
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, E_CONSTRAINT_VIOLATION, *resp.Error)
+	}
+	resp, _ = testTransactMutate(t, []interface{}{"strings", MT_DELETE, "a"})
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, E_CONSTRAINT_VIOLATION, *resp.Error)
+	}
+}
+
+func TestTransactMutateMap(t *testing.T) {
+	// the values of the existing keys are not replaced
+	resp, dump := testTransactMutate(t,
+		[]interface{}{"map", MT_INSERT, libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"a": "10", "c": "3"}}},
+		[]interface{}{"map", MT_DELETE, libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"a": "10", "b": "2"}}},
+	)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"a": "1", "c": "3"}}, dump["map"])
+
+	// the keys are deleted regardless of their values
+	resp, dump = testTransactMutate(t, []interface{}{"map", MT_DELETE, libovsdb.OvsSet{GoSet: []interface{}{"a", "c"}}})
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"b": "2"}}, dump["map"])
+}
+
 func TestTransactMutateUnmutableError(t *testing.T) {
 	table := "table1"
 	row := map[string]interface{}{
@@ -1468,3 +1841,146 @@ func TestTransactComment(t *testing.T) {
 
 func TestTransactAssert(t *testing.T) {
 }
+
+func TestTransactConflictDiagnostics(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+
+	key := common.GenerateDataKey("simple", "table1")
+	row := map[string]interface{}{"key1": "val1"}
+	setRowUUID(&row, key.UUID)
+	setRowVersion(&row)
+	val, err := makeValue(&row)
+	assert.Nil(t, err)
+	putResp, err := cli.Put(context.TODO(), key.String(), val)
+	assert.Nil(t, err)
+
+	txn := NewTransaction(cli, klogr.New(), &libovsdb.Transact{DBName: "simple"})
+	txn.AddSchema(testSchemaSimple)
+	txn.etcd.Clear()
+	// the transaction expects an older revision of the row
+	txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.ModRevision(key.String()), "=", putResp.Header.Revision-1))
+	txn.etcd.Then = append(txn.etcd.Then, clientv3.OpGet(key.String()))
+	_, err = txn.etcdTranaction()
+	assert.NotNil(t, err)
+	assert.Equal(t, E_TXN_CONFLICT, err.Error())
+
+	conflicts, err := txn.etcd.Conflicts()
+	assert.Nil(t, err)
+	assert.Equal(t, []TxnConflict{{
+		Key:              key.String(),
+		ExpectedRevision: putResp.Header.Revision - 1,
+		ActualRevision:   putResp.Header.Revision,
+		CompetingVersion: row[COL_VERSION].(libovsdb.UUID).GoUUID,
+	}}, conflicts)
+
+	assert.Equal(t, 1, len(txn.response.Result))
+	assert.Equal(t, E_TXN_CONFLICT, *txn.response.Result[0].Error)
+	assert.Contains(t, *txn.response.Result[0].Details, key.String())
+}
+
+func TestTransactUpsert(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	// Logical_Switch_Port is indexed by name
+	resp, _ := testOvnTransact(t, `["OVN_Northbound",
+		{"op": "upsert", "table": "Logical_Switch_Port", "uuid-name": "lsp", "row": {"name": "lsp1", "tag_request": 1}},
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls1", "ports": ["named-uuid", "lsp"]}}]`)
+	assert.Nil(t, resp.Error)
+	assert.NotNil(t, resp.Result[0].UUID)
+	assert.Nil(t, resp.Result[0].Count)
+	lspUUID := resp.Result[0].UUID.GoUUID
+
+	// the second upsert updates the same row, and the uuid-name refers to it
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "upsert", "table": "Logical_Switch_Port", "uuid-name": "lsp", "row": {"name": "lsp1", "tag_request": 2}},
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls2", "ports": ["named-uuid", "lsp"]}}]`)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, 1, *resp.Result[0].Count)
+	assert.Equal(t, lspUUID, resp.Result[0].UUID.GoUUID)
+
+	rows := testOvnSelect(t, "OVN_Northbound", "Logical_Switch_Port", `[]`)
+	assert.Equal(t, 1, len(rows))
+	assert.Equal(t, libovsdb.OvsSet{GoSet: []interface{}{2}}, rows[0]["tag_request"])
+	rows = testOvnSelect(t, "OVN_Northbound", "Logical_Switch", `[["name", "==", "ls2"]]`)
+	assert.Equal(t, libovsdb.OvsSet{GoSet: []interface{}{libovsdb.UUID{GoUUID: lspUUID}}}, rows[0]["ports"])
+
+	// the uuid-name cannot be referred before the upsert, which resolves it to the updated row
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls6", "ports": ["named-uuid", "lsp"]}},
+		{"op": "upsert", "table": "Logical_Switch_Port", "uuid-name": "lsp", "row": {"name": "lsp1", "tag_request": 3}}]`)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, E_CONSTRAINT_VIOLATION, *resp.Error)
+	}
+	rows = testOvnSelect(t, "OVN_Northbound", "Logical_Switch", `[["name", "==", "ls6"]]`)
+	assert.Equal(t, 0, len(rows))
+
+	// explicit where
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "upsert", "table": "Logical_Switch", "where": [["name", "==", "ls3"]], "row": {"name": "ls3"}}]`)
+	assert.Nil(t, resp.Error)
+	assert.NotNil(t, resp.Result[0].UUID)
+
+	// Logical_Switch has no indexes
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "upsert", "table": "Logical_Switch", "row": {"name": "ls4"}}]`)
+	assert.NotNil(t, resp.Error)
+
+	// the row is required, even if it's not inserted
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "upsert", "table": "Logical_Switch", "where": [["name", "==", "ls3"]]}]`)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, E_CONSTRAINT_VIOLATION, *resp.Error)
+	}
+
+	// the uuid-name cannot refer to several updated rows
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls5"}},
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls5"}}]`)
+	assert.Nil(t, resp.Error)
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "upsert", "table": "Logical_Switch", "uuid-name": "ls", "where": [["name", "==", "ls5"]],
+			"row": {"name": "ls5"}}]`)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, E_CONSTRAINT_VIOLATION, *resp.Error)
+	}
+	resp, _ = testOvnTransact(t, `["OVN_Northbound",
+		{"op": "upsert", "table": "Logical_Switch", "where": [["name", "==", "ls5"]], "row": {"name": "ls5"}}]`)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, 2, *resp.Result[0].Count)
+}
+
+func TestTransactUpsertConcurrentInsert(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	fi := NewFaultInjector()
+	defer fi.Inject(cli)()
+	upsert := `["OVN_Northbound", {"op": "upsert", "table": "Logical_Switch", "where": [["name", "==", "ls1"]],
+		"row": {"name": "ls1", "other_config": ["map", [["k", "v"]]]}}]`
+
+	// the matching row inserted by another upsert before the commit is updated by the execution again
+	fi.Add(Fault{Op: FAULT_OP_TXN, Skip: 1, Times: 1, Before: func() {
+		resp, _ := testOvnTransact(t, upsert)
+		assert.Nil(t, resp.Error)
+	}})
+	var params []interface{}
+	assert.Nil(t, json.Unmarshal([]byte(upsert), &params))
+	req, err := libovsdb.NewTransact(params)
+	assert.Nil(t, err)
+	txn := NewTransaction(cli, klogr.New(), req)
+	txn.schemas = testOvnSchemas(t)
+	_, err = txn.Commit()
+	assert.Nil(t, err)
+	if assert.NotNil(t, txn.response.Result[0].Count) {
+		assert.Equal(t, 1, *txn.response.Result[0].Count)
+	}
+	assert.Equal(t, 1, len(testOvnSelect(t, "OVN_Northbound", "Logical_Switch", `[]`)))
+}
@@ -0,0 +1,129 @@
+package ovsdb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+// HistoryTimeout is the maximum time of reading the changes of a database since the last-txn-id of a monitor_cond_since
+// request
+var HistoryTimeout = 10 * time.Second
+
+// the last 12 hex digits of a transaction id are the etcd revision, the first ones are the epoch of the database
+const txnIDRevisionDigits = 12
+
+// txnIDOf returns the transaction id of the database revision. The epoch is created once per database and persisted,
+// so the ids are valid across the servers and their restarts, while the ids of a recreated or restored database are not.
+func txnIDOf(epoch string, revision int64) string {
+	return fmt.Sprintf("%s%0*x", epoch[:len(epoch)-txnIDRevisionDigits], txnIDRevisionDigits, revision)
+}
+
+// revisionOf returns the revision of the transaction id, false if the id isn't of the given epoch
+func revisionOf(epoch string, txnID string) (int64, bool) {
+	if len(txnID) != len(epoch) || !strings.HasPrefix(txnID, epoch[:len(epoch)-txnIDRevisionDigits]) {
+		return 0, false
+	}
+	revision, err := strconv.ParseInt(txnID[len(epoch)-txnIDRevisionDigits:], 16, 64)
+	if err != nil || revision <= 0 {
+		return 0, false
+	}
+	return revision, true
+}
+
+// txnID returns the transaction id of the database revision, ZERO_UUID if the database epoch isn't available
+func (ch *Handler) txnID(dbName string, revision int64) string {
+	epoch, err := ch.db.GetTxnEpoch(dbName)
+	if err != nil || len(epoch) != len(ovsjson.ZERO_UUID) {
+		ch.logger().Error(err, "txn epoch", "dbName", dbName, "epoch", epoch)
+		return ovsjson.ZERO_UUID
+	}
+	return txnIDOf(epoch, revision)
+}
+
+// getMonitoredChanges returns the changes of the monitored data since the last transaction id, and the revision of
+// the returned changes. Returns false if the transaction id is unknown or its changes were compacted, then the client
+// should receive the whole monitored data.
+func (ch *Handler) getMonitoredChanges(dbName string, lastTxnID string, updatersMap Key2Updaters) (ovsjson.TableUpdates, int64, bool, error) {
+	epoch, err := ch.db.GetTxnEpoch(dbName)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	revision, ok := revisionOf(epoch, lastTxnID)
+	if !ok {
+		ch.logger().V(5).Info("unknown last-txn-id", "dbName", dbName, "last-txn-id", lastTxnID)
+		return nil, 0, false, nil
+	}
+	events, current, err := ch.db.GetHistory(dbName, revision)
+	if errors.Is(err, rpctypes.ErrCompacted) {
+		ch.logger().Info("last-txn-id was compacted", "dbName", dbName, "last-txn-id", lastTxnID, "revision", revision)
+		return nil, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if revision > current {
+		// the id is of a later revision of a restored database
+		ch.logger().Info("last-txn-id is newer than the database", "dbName", dbName, "last-txn-id", lastTxnID, "revision", revision, "current", current)
+		return nil, 0, false, nil
+	}
+	result, err := prepareUpdates(ch.logger(), dbName, updatersMap, collapseEvents(events))
+	if err != nil {
+		return nil, 0, false, err
+	}
+	updates := ovsjson.TableUpdates{}
+	for _, tableUpdates := range result {
+		for table, tableUpdate := range tableUpdates {
+			updates[table] = tableUpdate
+		}
+	}
+	ch.logger().V(5).Info("monitored changes", "dbName", dbName, "since", revision, "revision", current, "events", len(events))
+	return updates, current, true, nil
+}
+
+// collapseEvents replaces the events of every key by a single event from its first previous value to its last value,
+// the keys, which were created and deleted, are removed.
+func collapseEvents(events []*clientv3.Event) []*clientv3.Event {
+	first := map[string]*clientv3.Event{}
+	last := map[string]*clientv3.Event{}
+	keys := []string{}
+	for _, ev := range events {
+		key := string(ev.Kv.Key)
+		if _, ok := first[key]; !ok {
+			first[key] = ev
+			keys = append(keys, key)
+		}
+		last[key] = ev
+	}
+	collapsed := make([]*clientv3.Event, 0, len(keys))
+	for _, key := range keys {
+		f, l := first[key], last[key]
+		if f == l || (!f.IsCreate() && f.PrevKv == nil) {
+			collapsed = append(collapsed, l)
+			continue
+		}
+		switch {
+		case f.IsCreate() && l.Type == clientv3.EventTypeDelete:
+			continue
+		case f.IsCreate():
+			kv := *l.Kv
+			kv.CreateRevision = kv.ModRevision
+			collapsed = append(collapsed, &clientv3.Event{Type: l.Type, Kv: &kv})
+		default:
+			kv := *l.Kv
+			if l.Type != clientv3.EventTypeDelete {
+				// the key existed before the first event, so the last event is a modification
+				kv.CreateRevision = f.PrevKv.CreateRevision
+			}
+			collapsed = append(collapsed, &clientv3.Event{Type: l.Type, Kv: &kv, PrevKv: f.PrevKv})
+		}
+	}
+	return collapsed
+}
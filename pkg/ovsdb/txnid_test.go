@@ -0,0 +1,164 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsjson"
+)
+
+func TestTxnIDRevision(t *testing.T) {
+	epoch := "4d7cb1b4-6ad8-4c19-9b1e-3a4f5e6d7c8b"
+	txnID := txnIDOf(epoch, 0x1234)
+	assert.Equal(t, "4d7cb1b4-6ad8-4c19-9b1e-000000001234", txnID)
+	revision, ok := revisionOf(epoch, txnID)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0x1234), revision)
+
+	for _, wrong := range []string{"", ovsjson.ZERO_UUID, "5d7cb1b4-6ad8-4c19-9b1e-000000001234", "4d7cb1b4-6ad8-4c19-9b1e-00000000123x"} {
+		_, ok = revisionOf(epoch, wrong)
+		assert.False(t, ok, wrong)
+	}
+}
+
+func TestCollapseEvents(t *testing.T) {
+	kv := func(key, value string, create, mod int64) *mvccpb.KeyValue {
+		return &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), CreateRevision: create, ModRevision: mod}
+	}
+	events := []*clientv3.Event{
+		{Type: mvccpb.PUT, Kv: kv("a", "1", 2, 2)},
+		{Type: mvccpb.PUT, Kv: kv("b", "2", 1, 3), PrevKv: kv("b", "1", 1, 1)},
+		{Type: mvccpb.PUT, Kv: kv("a", "2", 2, 4), PrevKv: kv("a", "1", 2, 2)},
+		{Type: mvccpb.PUT, Kv: kv("c", "1", 5, 5)},
+		{Type: mvccpb.PUT, Kv: kv("b", "3", 1, 6), PrevKv: kv("b", "2", 1, 3)},
+		{Type: mvccpb.DELETE, Kv: kv("c", "", 0, 7), PrevKv: kv("c", "1", 5, 5)},
+		{Type: mvccpb.DELETE, Kv: kv("d", "", 0, 8), PrevKv: kv("d", "1", 1, 1)},
+	}
+	collapsed := collapseEvents(events)
+	assert.Equal(t, 3, len(collapsed))
+	// created and modified
+	assert.True(t, collapsed[0].IsCreate())
+	assert.Equal(t, "2", string(collapsed[0].Kv.Value))
+	// modified twice
+	assert.True(t, collapsed[1].IsModify())
+	assert.Equal(t, "1", string(collapsed[1].PrevKv.Value))
+	assert.Equal(t, "3", string(collapsed[1].Kv.Value))
+	// created and deleted keys are removed, the deleted one is kept
+	assert.Equal(t, mvccpb.DELETE, collapsed[2].Type)
+	assert.Equal(t, "d", string(collapsed[2].Kv.Key))
+}
+
+func TestDatabaseHistory(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	ctx := context.Background()
+	db, err := NewDatabaseEtcd(cli)
+	assert.Nil(t, err)
+
+	// the epoch is persisted
+	epoch, err := db.GetTxnEpoch(DB_NAME)
+	assert.Nil(t, err)
+	assert.Equal(t, len(ovsjson.ZERO_UUID), len(epoch))
+	other, err := NewDatabaseEtcd(cli)
+	assert.Nil(t, err)
+	otherEpoch, err := other.GetTxnEpoch(DB_NAME)
+	assert.Nil(t, err)
+	assert.Equal(t, epoch, otherEpoch)
+
+	resp, err := cli.Put(ctx, common.NewDataKey(DB_NAME, "T1", "u1").String(), "1")
+	assert.Nil(t, err)
+	since := resp.Header.Revision
+	_, err = cli.Put(ctx, common.NewDataKey(DB_NAME, "T1", "u1").String(), "2")
+	assert.Nil(t, err)
+	_, err = cli.Put(ctx, common.NewDataKey("other", "T1", "u1").String(), "1")
+	assert.Nil(t, err)
+	resp, err = cli.Put(ctx, common.NewDataKey(DB_NAME, "T1", "u2").String(), "1")
+	assert.Nil(t, err)
+
+	events, revision, err := db.GetHistory(DB_NAME, since)
+	assert.Nil(t, err)
+	assert.Equal(t, resp.Header.Revision, revision)
+	if assert.Equal(t, 2, len(events)) {
+		assert.Equal(t, "1", string(events[0].PrevKv.Value))
+		assert.Equal(t, "2", string(events[0].Kv.Value))
+		assert.Equal(t, common.NewDataKey(DB_NAME, "T1", "u2").String(), string(events[1].Kv.Key))
+	}
+	events, revision, err = db.GetHistory(DB_NAME, revision)
+	assert.Nil(t, err)
+	assert.Equal(t, resp.Header.Revision, revision)
+	assert.Empty(t, events)
+
+	_, err = cli.Compact(ctx, resp.Header.Revision)
+	assert.Nil(t, err)
+	_, _, err = db.GetHistory(DB_NAME, since)
+	assert.Equal(t, rpctypes.ErrCompacted, err)
+}
+
+func TestMonitorCondSinceLastTxnID(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	defer cli.Close()
+	ctx := context.Background()
+	db, err := NewDatabaseEtcd(cli)
+	assert.Nil(t, err)
+	db.(*DatabaseEtcd).Schemas = testMonitorCondSchemas()
+	put := func(uuid string, name string, n int) {
+		_, err := cli.Put(ctx, string(testMonitorCondRow(t, uuid, name, n, 0).Key), string(testMonitorCondRow(t, uuid, name, n, 0).Value))
+		assert.Nil(t, err)
+	}
+	monitorCondSince := func(jsonValue string, lastTxnID string) []interface{} {
+		handler := NewHandler(ctx, db, cli, klogr.New())
+		defer handler.Cleanup()
+		var params []interface{}
+		err := json.Unmarshal([]byte(`["dbName", "`+jsonValue+`", {"T1": [{"columns": ["name"], "where": [["n", ">=", 2]]}]}, "`+lastTxnID+`"]`), &params)
+		assert.Nil(t, err)
+		result, err := handler.MonitorCondSince(ctx, params)
+		assert.Nil(t, err)
+		buf, err := json.Marshal(result)
+		assert.Nil(t, err)
+		var response []interface{}
+		assert.Nil(t, json.Unmarshal(buf, &response))
+		return response
+	}
+	put("u1", "a", 1)
+	put("u2", "b", 3)
+
+	// an unknown last-txn-id receives the whole data
+	response := monitorCondSince("m1", ovsjson.ZERO_UUID)
+	assert.Equal(t, false, response[0])
+	lastTxnID := response[1].(string)
+	assert.NotEqual(t, ovsjson.ZERO_UUID, lastTxnID)
+	assert.Equal(t, map[string]interface{}{"T1": map[string]interface{}{"u2": map[string]interface{}{"initial": map[string]interface{}{"name": "b"}}}}, response[2])
+
+	put("u1", "a", 5)
+	_, err = cli.Delete(ctx, common.NewDataKey(DB_NAME, "T1", "u2").String())
+	assert.Nil(t, err)
+	put("u3", "c", 0)
+
+	// a known last-txn-id receives the changes since it
+	response = monitorCondSince("m2", lastTxnID)
+	assert.Equal(t, true, response[0])
+	assert.NotEqual(t, lastTxnID, response[1])
+	assert.Equal(t, map[string]interface{}{"T1": map[string]interface{}{
+		"u1": map[string]interface{}{"insert": map[string]interface{}{"name": "a"}},
+		"u2": map[string]interface{}{"delete": nil},
+	}}, response[2])
+
+	// no changes since the last response
+	response = monitorCondSince("m3", response[1].(string))
+	assert.Equal(t, true, response[0])
+	assert.Empty(t, response[2])
+}
@@ -0,0 +1,141 @@
+package ovsdb
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// The libovsdb validation checks the types of the values, and the sizes of sets and maps. The functions below check the
+// constraints of the base types (RFC 7047, section 3.2): the ranges of integers and reals, the lengths of strings, and
+// the enumerations of the atomic types.
+
+// validateRange validates an integer or a real against the bounds of its base type. The schema omits the zero bounds,
+// so a zero maximum is unlimited, and a zero minimum applies only together with a maximum.
+func validateRange(baseType *libovsdb.BaseType, value interface{}) error {
+	switch value := value.(type) {
+	case int:
+		if (baseType.MinInteger != 0 || baseType.MaxInteger != 0) && value < baseType.MinInteger {
+			return fmt.Errorf("%d is less than minimum %d", value, baseType.MinInteger)
+		}
+		if baseType.MaxInteger != 0 && value > baseType.MaxInteger {
+			return fmt.Errorf("%d is greater than maximum %d", value, baseType.MaxInteger)
+		}
+	case float64:
+		if (baseType.MinReal != 0 || baseType.MaxReal != 0) && value < baseType.MinReal {
+			return fmt.Errorf("%g is less than minimum %g", value, baseType.MinReal)
+		}
+		if baseType.MaxReal != 0 && value > baseType.MaxReal {
+			return fmt.Errorf("%g is greater than maximum %g", value, baseType.MaxReal)
+		}
+	}
+	return nil
+}
+
+// validateLength validates the length of a string in unicode characters, a zero maximum is unlimited
+func validateLength(baseType *libovsdb.BaseType, value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return nil
+	}
+	length := utf8.RuneCountInString(str)
+	if length < baseType.MinLength {
+		return fmt.Errorf("%q is shorter than %d characters", str, baseType.MinLength)
+	}
+	if baseType.MaxLength != 0 && length > baseType.MaxLength {
+		return fmt.Errorf("%q is longer than %d characters", str, baseType.MaxLength)
+	}
+	return nil
+}
+
+// validateEnum validates that a number or a boolean is one of the enumerated values, the strings are validated by
+// libovsdb
+func validateEnum(baseType *libovsdb.BaseType, value interface{}) error {
+	if baseType.Enum == nil || len(baseType.Enum.GoSet) == 0 {
+		return nil
+	}
+	var number float64
+	switch v := value.(type) {
+	case int:
+		number = float64(v)
+	case float64:
+		number = v
+	case bool:
+		for _, e := range baseType.Enum.GoSet {
+			if e == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v is not one of %v", value, baseType.Enum.GoSet)
+	default:
+		return nil
+	}
+	for _, e := range baseType.Enum.GoSet {
+		switch e := e.(type) {
+		case int:
+			if float64(e) == number {
+				return nil
+			}
+		case float64:
+			if e == number {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%v is not one of %v", value, baseType.Enum.GoSet)
+}
+
+func validateBaseConstraints(baseType *libovsdb.BaseType, value interface{}) error {
+	if baseType == nil {
+		return nil
+	}
+	if err := validateRange(baseType, value); err != nil {
+		return err
+	}
+	if err := validateLength(baseType, value); err != nil {
+		return err
+	}
+	return validateEnum(baseType, value)
+}
+
+// validateColumnConstraints validates the elements of the column value against the constraints of their base types
+func validateColumnConstraints(columnSchema *libovsdb.ColumnSchema, value interface{}) error {
+	if columnSchema.TypeObj == nil {
+		return nil
+	}
+	switch value := value.(type) {
+	case libovsdb.OvsSet:
+		for _, v := range value.GoSet {
+			if err := validateBaseConstraints(columnSchema.TypeObj.Key, v); err != nil {
+				return err
+			}
+		}
+	case libovsdb.OvsMap:
+		for k, v := range value.GoMap {
+			if err := validateBaseConstraints(columnSchema.TypeObj.Key, k); err != nil {
+				return fmt.Errorf("map key: %s", err)
+			}
+			if err := validateBaseConstraints(columnSchema.TypeObj.Value, v); err != nil {
+				return fmt.Errorf("map value: %s", err)
+			}
+		}
+	default:
+		return validateBaseConstraints(columnSchema.TypeObj.Key, value)
+	}
+	return nil
+}
+
+// validateRowConstraints validates the columns of an unmarshaled row against the constraints of their base types
+func validateRowConstraints(tableSchema *libovsdb.TableSchema, row *map[string]interface{}) error {
+	for column, value := range *row {
+		columnSchema, ok := tableSchema.Columns[column]
+		if !ok {
+			continue
+		}
+		if err := validateColumnConstraints(columnSchema, value); err != nil {
+			return fmt.Errorf("[column %s] %s", column, err)
+		}
+	}
+	return nil
+}
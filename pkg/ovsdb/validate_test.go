@@ -0,0 +1,57 @@
+package ovsdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+func TestValidateColumnConstraints(t *testing.T) {
+	integer := &libovsdb.ColumnSchema{Type: libovsdb.TypeInteger, TypeObj: &libovsdb.ColumnType{
+		Key: &libovsdb.BaseType{Type: libovsdb.TypeInteger, MinInteger: 1, MaxInteger: 10}, Min: 1, Max: 1}}
+	assert.Nil(t, validateColumnConstraints(integer, 10))
+	assert.NotNil(t, validateColumnConstraints(integer, 0))
+	assert.NotNil(t, validateColumnConstraints(integer, 11))
+
+	enum := &libovsdb.ColumnSchema{Type: libovsdb.TypeSet, TypeObj: &libovsdb.ColumnType{
+		Key: &libovsdb.BaseType{Type: libovsdb.TypeInteger, Enum: &libovsdb.OvsSet{GoSet: []interface{}{float64(1), float64(2)}}},
+		Min: 0, Max: libovsdb.Unlimited}}
+	assert.Nil(t, validateColumnConstraints(enum, libovsdb.OvsSet{GoSet: []interface{}{1, 2}}))
+	assert.NotNil(t, validateColumnConstraints(enum, libovsdb.OvsSet{GoSet: []interface{}{1, 3}}))
+
+	str := &libovsdb.ColumnSchema{Type: libovsdb.TypeMap, TypeObj: &libovsdb.ColumnType{
+		Key:   &libovsdb.BaseType{Type: libovsdb.TypeString, MinLength: 1},
+		Value: &libovsdb.BaseType{Type: libovsdb.TypeString, MaxLength: 3},
+		Min:   0, Max: libovsdb.Unlimited}}
+	assert.Nil(t, validateColumnConstraints(str, libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"k": "äöü"}}))
+	assert.NotNil(t, validateColumnConstraints(str, libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"": "v"}}))
+	assert.NotNil(t, validateColumnConstraints(str, libovsdb.OvsMap{GoMap: map[interface{}]interface{}{"k": "long"}}))
+}
+
+func TestTransactValidateConstraints(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	for _, row := range []string{
+		`{"name": "lsp", "tag_request": 4096}`,
+		`{"name": "lsp", "tag_request": -1}`,
+	} {
+		resp, _ := testOvnTransact(t, `["OVN_Northbound",
+			{"op": "insert", "table": "Logical_Switch_Port", "uuid-name": "lsp", "row": `+row+`},
+			{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls", "ports": ["set", [["named-uuid", "lsp"]]]}}]`)
+		if assert.NotNil(t, resp.Error, row) {
+			assert.Equal(t, E_CONSTRAINT_VIOLATION, *resp.Error)
+		}
+	}
+	resp, _ := testOvnTransact(t, `["OVN_Northbound",
+		{"op": "insert", "table": "ACL", "row": {"name": "`+strings.Repeat("a", 64)+`", "priority": 1, "direction": "to-lport", "match": "ip", "action": "drop"}},
+		{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls"}}]`)
+	if assert.NotNil(t, resp.Error) {
+		assert.Equal(t, E_CONSTRAINT_VIOLATION, *resp.Error)
+	}
+	// nothing is written
+	assert.Empty(t, testOvnSelect(t, "OVN_Northbound", "Logical_Switch", `[]`))
+}
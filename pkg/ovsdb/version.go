@@ -0,0 +1,55 @@
+package ovsdb
+
+import (
+	"runtime"
+	"sync"
+)
+
+// BuildInfo identifies the server build, it allows fleet tooling to verify which build every replica runs, before
+// triggering schema conversions or upgrades.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git-commit"`
+	GoVersion string `json:"go-version"`
+}
+
+// ServerInfo is returned by the "get_server_info" extension method
+type ServerInfo struct {
+	ServerID string `json:"server-id"`
+	BuildInfo
+	// database name -> schema version
+	Schemas map[string]string `json:"schemas"`
+}
+
+var (
+	buildMu   sync.Mutex
+	buildInfo = BuildInfo{Version: "dev", GoVersion: runtime.Version()}
+)
+
+// SetBuildInfo sets the server version and git commit, they are usually injected by the linker flags. Should be called
+// before the schemas are added, because the build info is stored in the _Server database.
+func SetBuildInfo(version, gitCommit string) {
+	buildMu.Lock()
+	defer buildMu.Unlock()
+	if version != "" {
+		buildInfo.Version = version
+	}
+	buildInfo.GitCommit = gitCommit
+}
+
+func GetBuildInfo() BuildInfo {
+	buildMu.Lock()
+	defer buildMu.Unlock()
+	return buildInfo
+}
+
+// buildColumn returns the value of the _Server.Database "build" extension column
+func buildColumn(schemaVersion string) map[string]string {
+	info := GetBuildInfo()
+	return map[string]string{
+		"version":        info.Version,
+		"git-commit":     info.GitCommit,
+		"go-version":     info.GoVersion,
+		"schema-version": schemaVersion,
+	}
+}
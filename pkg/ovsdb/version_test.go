@@ -0,0 +1,32 @@
+package ovsdb
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+func TestGetServerInfo(t *testing.T) {
+	SetBuildInfo("v1.2.3", "abcdef")
+	defer SetBuildInfo("dev", "")
+	db := DatabaseMock{Response: libovsdb.Schemas{
+		"OVN_Northbound": &libovsdb.DatabaseSchema{Name: "OVN_Northbound", Version: "5.31.0"},
+		INT_SERVER:       &libovsdb.DatabaseSchema{Name: INT_SERVER, Version: "1.1.0"},
+	}}
+	service := NewService(&db)
+	resp, err := service.GetServerInfo(context.Background())
+	assert.Nil(t, err)
+	info := resp.(ServerInfo)
+	assert.Equal(t, service.GetServerId(context.Background()), info.ServerID)
+	assert.Equal(t, "v1.2.3", info.Version)
+	assert.Equal(t, "abcdef", info.GitCommit)
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+	assert.Equal(t, map[string]string{"OVN_Northbound": "5.31.0", INT_SERVER: "1.1.0"}, info.Schemas)
+
+	assert.Equal(t, map[string]string{"version": "v1.2.3", "git-commit": "abcdef", "go-version": runtime.Version(),
+		"schema-version": "5.31.0"}, buildColumn("5.31.0"))
+}
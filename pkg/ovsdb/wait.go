@@ -0,0 +1,67 @@
+package ovsdb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+// A "wait" operation with a non-zero timeout blocks the transaction until its condition is satisfied (RFC 7047, section
+// 5.2.6). The transaction is executed, and if the wait fails, the handler waits for a modification of the waited tables
+// after the revision the transaction read, and executes the transaction again, until the timeout expires.
+
+// blockedWait returns the timeout in milliseconds of the "wait" operation, which failed the transaction because its
+// condition wasn't satisfied, or 0 if the transaction wasn't failed by a wait operation with a non-zero timeout
+func (txn *Transaction) blockedWait() int {
+	for i, ovsOp := range txn.request.Operations {
+		result := txn.response.Result[i]
+		if ovsOp.Op != OP_WAIT || result.Error == nil || *result.Error != E_TIMEOUT || ovsOp.Timeout == nil {
+			continue
+		}
+		return *ovsOp.Timeout
+	}
+	return 0
+}
+
+// waitChanges blocks until a row of the tables of the "wait" operations is modified after the transaction read them,
+// so the transaction can be executed again. Returns the E_TIMEOUT error if the deadline expires, and the E_CANCELED
+// error if the context is canceled.
+func (txn *Transaction) waitChanges(ctx context.Context, deadline time.Time) error {
+	tables := map[string]bool{}
+	for _, ovsOp := range txn.request.Operations {
+		if ovsOp.Op == OP_WAIT && ovsOp.Table != nil {
+			tables[*ovsOp.Table] = true
+		}
+	}
+	wctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	dbKey := common.NewDBPrefixKey(txn.request.DBName)
+	watch := txn.etcd.Cli.Watch(clientv3.WithRequireLeader(wctx), dbKey.DBKeyString(), clientv3.WithPrefix(),
+		clientv3.WithRev(txn.readRevision+1))
+	// the transaction reads the modified rows from etcd, the row cache may not observe them yet
+	txn.rowCacheBypass = true
+	for resp := range watch {
+		if resp.CompactRevision != 0 {
+			// the modifications were compacted, the transaction reads the current rows
+			return nil
+		}
+		if err := resp.Err(); err != nil {
+			txn.log.Error(err, "wait watch")
+			return errors.New(E_IO_ERROR)
+		}
+		for _, ev := range resp.Events {
+			key, err := common.ParseKey(string(ev.Kv.Key))
+			if err == nil && tables[key.TableName] {
+				return nil
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return errors.New(E_CANCELED)
+	}
+	return errors.New(E_TIMEOUT)
+}
@@ -0,0 +1,140 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func testWaitClient(t *testing.T) (*Handler, *jrpc2.Client, func()) {
+	cli, err := testEtcdNewCli()
+	assert.Nil(t, err)
+	db := DatabaseMock{Response: testOvnSchemas(t)}
+	ch := NewHandler(context.Background(), &db, cli, klogr.New())
+	assigner := handler.Map{
+		"transact": handler.New(ch.Transact),
+		"cancel":   handler.New(ch.Cancel),
+	}
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(assigner, &jrpc2.ServerOptions{Concurrency: 4, AllowV1: true}).Start(sch)
+	client := jrpc2.NewClient(cch, &jrpc2.ClientOptions{AllowV1: true})
+	return ch, client, func() {
+		client.Close()
+		srv.Stop()
+		cli.Close()
+	}
+}
+
+func testWaitCall(client *jrpc2.Client, msg string) chan error {
+	done := make(chan error, 1)
+	go func() {
+		var params []interface{}
+		if err := json.Unmarshal([]byte(msg), &params); err != nil {
+			done <- err
+			return
+		}
+		_, err := client.Call(context.Background(), "transact", params)
+		done <- err
+	}()
+	return done
+}
+
+// testWaitInFlight returns the id of the single in-flight transaction of the handler
+func testWaitInFlight(t *testing.T, ch *Handler) string {
+	for i := 0; i < 100; i++ {
+		ch.mu.Lock()
+		for id := range ch.transactions {
+			ch.mu.Unlock()
+			return id
+		}
+		ch.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Fail(t, "no in-flight transaction")
+	return ""
+}
+
+const testWaitRequest = `["OVN_Northbound",
+	{"op": "wait", "table": "Logical_Switch", "timeout": 5000, "where": [["name", "==", "ls1"]],
+	 "columns": ["name"], "until": "==", "rows": [{"name": "ls1"}]},
+	{"op": "insert", "table": "Logical_Switch", "row": {"name": "ls2"}}]`
+
+func TestTransactWaitBlocking(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	ch, client, cleanup := testWaitClient(t)
+	defer cleanup()
+
+	done := testWaitCall(client, testWaitRequest)
+	testWaitInFlight(t, ch)
+	select {
+	case err := <-done:
+		assert.Fail(t, "the wait didn't block", "err", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the insert satisfies the wait condition
+	resp, _ := testOvnTransact(t, `["OVN_Northbound", {"op": "insert", "table": "Logical_Switch", "row": {"name": "ls1"}}]`)
+	assert.Nil(t, resp.Error)
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the wait wasn't released")
+	}
+	assert.Equal(t, 1, len(testOvnSelect(t, "OVN_Northbound", "Logical_Switch", `[["name", "==", "ls2"]]`)))
+}
+
+func TestTransactWaitTimeout(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	ch, client, cleanup := testWaitClient(t)
+	defer cleanup()
+
+	var params []interface{}
+	assert.Nil(t, json.Unmarshal([]byte(`["OVN_Northbound",
+		{"op": "wait", "table": "Logical_Switch", "timeout": 100, "where": [],
+		 "columns": ["name"], "until": "==", "rows": [{"name": "ls1"}]}]`), &params))
+	start := time.Now()
+	rsp, err := client.Call(context.Background(), "transact", params)
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	var results []map[string]interface{}
+	assert.Nil(t, rsp.UnmarshalResult(&results))
+	if assert.Equal(t, 1, len(results)) {
+		assert.Equal(t, E_TIMEOUT, results[0]["error"])
+	}
+	assert.Empty(t, ch.transactions)
+}
+
+func TestTransactCancel(t *testing.T) {
+	common.SetPrefix("ovsdb/nb")
+	testEtcdCleanup(t)
+	ch, client, cleanup := testWaitClient(t)
+	defer cleanup()
+
+	done := testWaitCall(client, testWaitRequest)
+	id := testWaitInFlight(t, ch)
+	var requestID interface{}
+	assert.Nil(t, json.Unmarshal([]byte(id), &requestID))
+	assert.Nil(t, client.Notify(context.Background(), "cancel", []interface{}{requestID}))
+	select {
+	case err := <-done:
+		if assert.NotNil(t, err) {
+			assert.Equal(t, E_CANCELED, err.(*jrpc2.Error).Message())
+		}
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the transaction wasn't canceled")
+	}
+	// the canceled transaction isn't committed
+	assert.Equal(t, 0, len(testOvnSelect(t, "OVN_Northbound", "Logical_Switch", `[]`)))
+}
@@ -0,0 +1,106 @@
+package ovsdb
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// the first re-watch delay, doubled on every consequent failure
+	WatchRestartMinBackoff = 100 * time.Millisecond
+	// the maximal re-watch delay
+	WatchRestartMaxBackoff = 10 * time.Second
+	// minimal interval between two watch restarts of this server, all the restarts share the same limiter
+	WatchRestartInterval = 10 * time.Millisecond
+	// the number of the consequent restarts of a watch, after which the watch is reported as failed by the health
+	// checks
+	WatchFailureAttempts = 5
+)
+
+// watchRestartLimiter spreads watch re-establishments over time. When etcd leadership changes, all the watches of
+// all the replicas are canceled together, restarting them at once would hammer the etcd cluster.
+type watchRestartLimiter struct {
+	mu   sync.Mutex
+	next time.Time
+	rand *rand.Rand
+}
+
+var watchRestarts = newWatchRestartLimiter()
+
+func newWatchRestartLimiter() *watchRestartLimiter {
+	return &watchRestartLimiter{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// watchFailures tracks the consequent restart attempts of the watches, which are being restarted
+type watchFailures struct {
+	mu       sync.Mutex
+	attempts map[*sharedWatch]int
+}
+
+var failingWatches = &watchFailures{attempts: map[*sharedWatch]int{}}
+
+// set sets the restart attempts of the database watch, 0 if the watch receives responses or it's closed
+func (w *watchFailures) set(sw *sharedWatch, attempt int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if attempt == 0 {
+		delete(w.attempts, sw)
+	} else {
+		w.attempts[sw] = attempt
+	}
+}
+
+// FailedWatches returns the databases of the watches, which were restarted WatchFailureAttempts times without
+// receiving a response
+func FailedWatches() []string {
+	failingWatches.mu.Lock()
+	defer failingWatches.mu.Unlock()
+	dbs := []string{}
+	for sw, attempt := range failingWatches.attempts {
+		if attempt >= WatchFailureAttempts {
+			dbs = append(dbs, sw.dataBaseName)
+		}
+	}
+	return dbs
+}
+
+// backoff returns a jittered exponential delay for the given attempt (starting from 1), the returned value is in
+// the range [d/2, d), where d is min(WatchRestartMaxBackoff, WatchRestartMinBackoff * 2^(attempt-1)).
+func (l *watchRestartLimiter) backoff(attempt int) time.Duration {
+	d := WatchRestartMaxBackoff
+	if attempt < 32 {
+		if exp := WatchRestartMinBackoff << uint(attempt-1); exp > 0 && exp < d {
+			d = exp
+		}
+	}
+	l.mu.Lock()
+	jitter := time.Duration(l.rand.Int63n(int64(d/2) + 1))
+	l.mu.Unlock()
+	return d/2 + jitter
+}
+
+// reserve returns the time when the caller is allowed to restart its watch.
+func (l *watchRestartLimiter) reserve(attempt int) time.Time {
+	at := time.Now().Add(l.backoff(attempt))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if at.Before(l.next) {
+		at = l.next
+	}
+	l.next = at.Add(WatchRestartInterval)
+	return at
+}
+
+// wait blocks until the caller is allowed to restart its watch, returns false if the context is done before.
+func (l *watchRestartLimiter) wait(ctx context.Context, attempt int) bool {
+	timer := time.NewTimer(time.Until(l.reserve(attempt)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
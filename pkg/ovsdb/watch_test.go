@@ -0,0 +1,50 @@
+package ovsdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchRestartBackoff(t *testing.T) {
+	l := newWatchRestartLimiter()
+	for attempt := 1; attempt < 40; attempt++ {
+		d := l.backoff(attempt)
+		expected := WatchRestartMaxBackoff
+		if attempt < 10 && WatchRestartMinBackoff<<uint(attempt-1) < expected {
+			expected = WatchRestartMinBackoff << uint(attempt-1)
+		}
+		assert.True(t, d >= expected/2, "attempt %d delay %v", attempt, d)
+		assert.True(t, d <= expected, "attempt %d delay %v", attempt, d)
+	}
+}
+
+func TestWatchRestartLimiterSpreadsRestarts(t *testing.T) {
+	l := newWatchRestartLimiter()
+	var prev time.Time
+	for i := 0; i < 10; i++ {
+		at := l.reserve(1)
+		if i > 0 {
+			assert.True(t, at.Sub(prev) >= WatchRestartInterval, "restart %d is too close to the previous one", i)
+		}
+		prev = at
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, l.wait(ctx, 1))
+}
+
+func TestFailedWatches(t *testing.T) {
+	m := &sharedWatch{dataBaseName: "OVN_Northbound"}
+	defer failingWatches.set(m, 0)
+	failingWatches.set(m, WatchFailureAttempts-1)
+	assert.Empty(t, FailedWatches())
+	failingWatches.set(m, WatchFailureAttempts)
+	assert.Equal(t, []string{"OVN_Northbound"}, FailedWatches())
+	// a response of the restarted watch resets its attempts
+	failingWatches.set(m, 0)
+	assert.Empty(t, FailedWatches())
+}
@@ -0,0 +1,213 @@
+package ovsdb
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
+)
+
+// weakRefIndex maps the tables to the columns with weak references to their rows
+type weakRefIndex map[string][]*refColumn
+
+func newWeakRefIndex(databaseSchema *libovsdb.DatabaseSchema) weakRefIndex {
+	index := weakRefIndex{}
+	columns := weakRefColumns(databaseSchema)
+	for i := range columns {
+		ref := &columns[i]
+		if ref.keyRefTable != "" {
+			index[ref.keyRefTable] = append(index[ref.keyRefTable], ref)
+		}
+		if ref.valueRefTable != "" && ref.valueRefTable != ref.keyRefTable {
+			index[ref.valueRefTable] = append(index[ref.valueRefTable], ref)
+		}
+	}
+	return index
+}
+
+// referencing returns the columns with weak references to the rows of the tables, grouped by their tables
+func (index weakRefIndex) referencing(tables map[string]bool) map[string][]*refColumn {
+	columns := map[string][]*refColumn{}
+	added := map[*refColumn]bool{}
+	for table := range tables {
+		for _, ref := range index[table] {
+			if !added[ref] {
+				added[ref] = true
+				columns[ref.table] = append(columns[ref.table], ref)
+			}
+		}
+	}
+	return columns
+}
+
+// removeWeakRefs removes the references to the deleted rows from the column of the unmarshaled row, returns true if
+// the column was modified
+func (ref *refColumn) removeWeakRefs(row map[string]interface{}, deleted map[rowRef]bool) (bool, error) {
+	isDeleted := func(table string, v interface{}) bool {
+		uuid, ok := uuidOf(v)
+		return ok && table != "" && deleted[rowRef{table: table, uuid: uuid}]
+	}
+	var size int
+	switch value := row[ref.column].(type) {
+	case libovsdb.OvsSet:
+		set := []interface{}{}
+		for _, v := range value.GoSet {
+			if !isDeleted(ref.keyRefTable, v) {
+				set = append(set, v)
+			}
+		}
+		if len(set) == len(value.GoSet) {
+			return false, nil
+		}
+		row[ref.column] = libovsdb.OvsSet{GoSet: set}
+		size = len(set)
+	case libovsdb.OvsMap:
+		m := map[interface{}]interface{}{}
+		for k, v := range value.GoMap {
+			if !isDeleted(ref.keyRefTable, k) && !isDeleted(ref.valueRefTable, v) {
+				m[k] = v
+			}
+		}
+		if len(m) == len(value.GoMap) {
+			return false, nil
+		}
+		row[ref.column] = libovsdb.OvsMap{GoMap: m}
+		size = len(m)
+	default:
+		if !isDeleted(ref.keyRefTable, value) {
+			return false, nil
+		}
+		row[ref.column] = libovsdb.OvsSet{GoSet: []interface{}{}}
+	}
+	if size < ref.columnSchema.TypeObj.Min {
+		return true, errors.New(E_CONSTRAINT_VIOLATION)
+	}
+	return true, nil
+}
+
+// removeWeakReferences removes the weak references to the rows deleted by the transaction from the other rows. The rows
+// written by the transaction are patched, and the other referencing rows are modified by the transaction, so the
+// monitors are notified about the removed references. The modification of the referencing tables is guarded, so a
+// concurrent transaction cannot add a reference to a deleted row.
+func (txn *Transaction) removeWeakReferences() error {
+	databaseSchema, ok := txn.schemas[txn.request.DBName]
+	if !ok {
+		return nil
+	}
+	index := newWeakRefIndex(databaseSchema)
+	if len(index) == 0 {
+		return nil
+	}
+	dbName := txn.request.DBName
+
+	deleted := map[rowRef]bool{}
+	deletedTables := map[string]bool{}
+	written := map[string]*clientv3.Event{}
+	for _, ev := range txn.etcd.Events {
+		if ev.Type != clientv3.EventTypeDelete {
+			written[string(ev.Kv.Key)] = ev
+			continue
+		}
+		key, err := common.ParseKey(string(ev.PrevKv.Key))
+		if err != nil {
+			continue
+		}
+		deleted[rowRef{table: key.TableName, uuid: key.UUID}] = true
+		deletedTables[key.TableName] = true
+	}
+	referencing := index.referencing(deletedTables)
+	if len(referencing) == 0 {
+		return nil
+	}
+
+	tables := make([]string, 0, len(referencing))
+	for table := range referencing {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	read := NewEtcd(txn.etcd)
+	read.Clear()
+	for _, table := range tables {
+		read.Then = append(read.Then, clientv3.OpGet(common.NewTableKey(dbName, table).String(), clientv3.WithPrefix()))
+	}
+	if err := read.Commit(); err != nil {
+		err = errors.New(E_IO_ERROR)
+		txn.log.Error(err, "weak references reads")
+		return err
+	}
+	revision := read.Res.Header.Revision
+
+	for i, table := range tables {
+		kvs := read.Res.Responses[i].GetResponseRange().Kvs
+		// the rows inserted by the transaction
+		tablePrefix := common.NewTableKey(dbName, table).String()
+		for key, ev := range written {
+			if ev.PrevKv == nil && strings.HasPrefix(key, tablePrefix) {
+				kvs = append(kvs, ev.Kv)
+			}
+		}
+		for _, kv := range kvs {
+			if ev, ok := written[string(kv.Key)]; ok {
+				// the value written by the transaction replaces the stored one
+				kv = ev.Kv
+			} else if key, err := common.ParseKey(string(kv.Key)); err != nil || deleted[rowRef{table: table, uuid: key.UUID}] {
+				continue
+			}
+			if err := txn.patchWeakRefs(table, kv, referencing[table], deleted, written); err != nil {
+				return err
+			}
+		}
+		txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.ModRevision(tablePrefix), "<", revision+1).WithPrefix())
+	}
+	txn.etcd.Assert()
+	return nil
+}
+
+// patchWeakRefs removes the references to the deleted rows from the row, and writes it if it was modified
+func (txn *Transaction) patchWeakRefs(table string, kv *mvccpb.KeyValue, columns []*refColumn, deleted map[rowRef]bool,
+	written map[string]*clientv3.Event) error {
+	row, err := unmarshalData(kv.Value)
+	if err != nil {
+		return nil
+	}
+	if err := txn.schemas.Unmarshal(txn.request.DBName, table, &row); err != nil {
+		return nil
+	}
+	modified := false
+	for _, ref := range columns {
+		changed, err := ref.removeWeakRefs(row, deleted)
+		if err != nil {
+			txn.log.Error(err, "weak references removal", "key", string(kv.Key), "column", ref.column)
+			return err
+		}
+		modified = modified || changed
+	}
+	if !modified {
+		return nil
+	}
+	key := string(kv.Key)
+	txn.log.V(5).Info("removed weak references", "key", key)
+	setRowVersion(&row)
+	val, err := makeValue(&row)
+	if err != nil {
+		return err
+	}
+	if ev, ok := written[key]; ok {
+		for i, op := range txn.etcd.Then {
+			if op.IsPut() && etcdOpKey(op) == key {
+				txn.etcd.Then[i] = clientv3.OpPut(key, val)
+			}
+		}
+		ev.Kv.Value = []byte(val)
+		return nil
+	}
+	txn.etcd.Then = append(txn.etcd.Then, clientv3.OpPut(key, val))
+	txn.etcd.Events = append(txn.etcd.Events, etcdEventModify(key, val, string(kv.Value)))
+	txn.etcd.If = append(txn.etcd.If, clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision))
+	return nil
+}
@@ -0,0 +1,149 @@
+package ovsdb
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/go-logr/logr"
+)
+
+// NotificationQueueSize is the number of notifications of a connection, which can wait for the connection writer.
+// When the queue is full, the monitor notifiers of the connection wait, but the other connections are not affected.
+var NotificationQueueSize = 256
+
+// NotificationBatchSize is the maximum number of queued notifications, which are written in one batch
+var NotificationBatchSize = 64
+
+type writerMessage struct {
+	method string
+	params interface{}
+	// called after the message is written, with the write error
+	done func(error)
+	// not nil for flush requests, closed after all the previous messages were written
+	flushed chan struct{}
+}
+
+// connWriter writes the notifications of a single connection. The notifications are prepared by the monitor
+// notifiers and queued to the writer, so a slow socket write blocks only the writer of the connection, and not the
+// preparation of the updates. The messages are written in the queue order.
+type connWriter struct {
+	// the number of the written notifications, accessed atomically, so it's the first field for the 64-bit alignment
+	written uint64
+
+	log    logr.Logger
+	ctx    context.Context
+	server JrpcServer
+	queue  chan writerMessage
+}
+
+func newConnWriter(ctx context.Context, server JrpcServer, log logr.Logger) *connWriter {
+	return &connWriter{
+		log:    log,
+		ctx:    ctx,
+		server: server,
+		queue:  make(chan writerMessage, NotificationQueueSize),
+	}
+}
+
+// write queues the notification, done is called after the notification is written or dropped
+func (w *connWriter) write(method string, params interface{}, done func(error)) {
+	select {
+	case w.queue <- writerMessage{method: method, params: params, done: done}:
+	case <-w.ctx.Done():
+		if done != nil {
+			done(w.ctx.Err())
+		}
+	}
+}
+
+// flush waits till all the notifications, which were queued before the call, are written
+func (w *connWriter) flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case w.queue <- writerMessage{flushed: flushed}:
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *connWriter) run() {
+	batch := make([]writerMessage, 0, NotificationBatchSize)
+	for {
+		select {
+		case <-w.ctx.Done():
+			w.drop()
+			return
+		case msg := <-w.queue:
+			batch = append(batch[:0], msg)
+		}
+		// take the other queued messages, without waiting for new ones
+	collect:
+		for len(batch) < NotificationBatchSize {
+			select {
+			case msg := <-w.queue:
+				batch = append(batch, msg)
+			default:
+				break collect
+			}
+		}
+		w.log.V(7).Info("write notifications", "batch", len(batch))
+		for i := range batch {
+			w.send(&batch[i])
+			batch[i] = writerMessage{}
+		}
+	}
+}
+
+func (w *connWriter) send(msg *writerMessage) {
+	if msg.flushed != nil {
+		close(msg.flushed)
+		return
+	}
+	var err error
+	if w.ctx.Err() != nil {
+		err = w.ctx.Err()
+	} else {
+		sample := allocStart()
+		err = w.server.Notify(w.ctx, msg.method, msg.params)
+		allocEnd(ALLOC_STAGE_SEND, sample)
+		if err == nil {
+			atomic.AddUint64(&w.written, 1)
+			serverMetrics.Count(METRIC_NOTIFICATIONS_SENT_PREFIX+msg.method, 1)
+		}
+	}
+	if err != nil {
+		w.log.Error(err, "write notification failed", "method", msg.method)
+	}
+	if msg.done != nil {
+		msg.done(err)
+	}
+}
+
+// stats returns the number of the written notifications, and the number of the queued ones
+func (w *connWriter) stats() (uint64, int) {
+	return atomic.LoadUint64(&w.written), len(w.queue)
+}
+
+// drop releases the waiters of the queued messages, after the connection context is done
+func (w *connWriter) drop() {
+	for {
+		select {
+		case msg := <-w.queue:
+			if msg.done != nil {
+				msg.done(w.ctx.Err())
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package ovsdb
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	klogr "k8s.io/klog/v2/klogr"
+)
+
+// jrpcServerBlocked blocks the notifications till it is released
+type jrpcServerBlocked struct {
+	jrpcServerRecorder
+	release chan struct{}
+}
+
+func (j *jrpcServerBlocked) Notify(ctx context.Context, method string, params interface{}) error {
+	select {
+	case <-j.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return j.jrpcServerRecorder.Notify(ctx, method, params)
+}
+
+func TestConnWriterSlowConnection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := &jrpcServerBlocked{release: make(chan struct{})}
+	w := newConnWriter(ctx, server, klogr.New())
+	go w.run()
+
+	// the writes of the blocked connection don't block the producer
+	var wg sync.WaitGroup
+	methods := []string{UPDATE, UPDATE2, UPDATE3, MONITOR_CANCELED}
+	wg.Add(len(methods))
+	done := make(chan struct{})
+	go func() {
+		for _, method := range methods {
+			w.write(method, []interface{}{method}, func(err error) {
+				assert.Nil(t, err)
+				wg.Done()
+			})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "write is blocked by the connection")
+	}
+
+	flushCtx, flushCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	assert.NotNil(t, w.flush(flushCtx))
+	flushCancel()
+
+	close(server.release)
+	assert.Nil(t, w.flush(ctx))
+	wg.Wait()
+	server.mu.Lock()
+	assert.Equal(t, methods, server.method)
+	server.mu.Unlock()
+}
+
+func TestConnWriterClosedConnection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := &jrpcServerBlocked{release: make(chan struct{})}
+	w := newConnWriter(ctx, server, klogr.New())
+	go w.run()
+	var wg sync.WaitGroup
+	wg.Add(3)
+	for i := 0; i < 3; i++ {
+		w.write(UPDATE2, nil, func(err error) {
+			assert.NotNil(t, err)
+			wg.Done()
+		})
+	}
+	// the waiters of the queued notifications are released
+	cancel()
+	wg.Wait()
+	assert.NotNil(t, w.flush(context.Background()))
+	assert.Empty(t, server.method)
+}
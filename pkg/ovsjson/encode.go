@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
 )
 
 func (u Uuid) MarshalJSON() ([]byte, error) {
@@ -15,27 +16,34 @@ func (u NamedUuid) MarshalJSON() ([]byte, error) {
 }
 
 func (m Map) MarshalJSON() ([]byte, error) {
-	var buf bytes.Buffer
-	buf.WriteString(`["map",[`)
-	if m != nil {
-		for k, v := range m {
-			buf.WriteString(fmt.Sprintf(`["%s","%s"],`, k, v))
-		}
-		buf.Truncate(buf.Len() - 1)
-	}
-	buf.WriteString(`]]`)
-	return buf.Bytes(), nil
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	// the keys and values are arbitrary strings, they are encoded by json.Marshal to escape quotes and control
+	// characters
+	pairs := make([][]string, 0, len(m))
+	for _, k := range keys {
+		pairs = append(pairs, []string{k, m[k]})
+	}
+	return json.Marshal([]interface{}{"map", pairs})
 }
 
 func (s Set) MarshalJSON() ([]byte, error) {
 	var buf bytes.Buffer
 	buf.WriteString(`["set",[`)
 	if s != nil {
-		for _, v := range s {
-			x, _ := json.Marshal(v)
-			buf.WriteString(fmt.Sprintf(`%s,`, x))
+		for i, v := range s {
+			x, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			buf.Write(x)
 		}
-		buf.Truncate(buf.Len() - 1)
 	}
 	buf.WriteString(`]]`)
 	return buf.Bytes(), nil
@@ -87,7 +95,19 @@ func (un UpdateNotification) MarshalJSON() ([]byte, error) {
 }
 
 func (ru RowUpdate) MarshalJSON() ([]byte, error) {
+	if raw, ok := ru.rawOnly(); ok {
+		// the row is spliced without building the generic object of the update
+		buf := make([]byte, 0, len(raw.name)+len(raw.row)+5)
+		buf = append(buf, `{"`...)
+		buf = append(buf, raw.name...)
+		buf = append(buf, `":`...)
+		buf = append(buf, raw.row...)
+		return append(buf, '}'), nil
+	}
 	obj := map[string]interface{}{}
+	for _, raw := range ru.rawRows() {
+		obj[raw.name] = raw.row
+	}
 	if ru.New != nil {
 		obj["new"] = *ru.New
 	}
@@ -109,6 +129,53 @@ func (ru RowUpdate) MarshalJSON() ([]byte, error) {
 	return json.Marshal(obj)
 }
 
+type rawRow struct {
+	name string
+	row  json.RawMessage
+}
+
+func (ru *RowUpdate) rawRows() []rawRow {
+	rows := []rawRow{}
+	for _, raw := range []rawRow{{"new", ru.RawNew}, {"old", ru.RawOld}, {"initial", ru.RawInitial}, {"insert", ru.RawInsert}} {
+		if raw.row != nil {
+			rows = append(rows, raw)
+		}
+	}
+	return rows
+}
+
+// rawOnly returns the encoded row, if it is the only row of the row update
+func (ru *RowUpdate) rawOnly() (rawRow, bool) {
+	if ru.New != nil || ru.Old != nil || ru.Initial != nil || ru.Insert != nil || ru.Modify != nil || ru.Delete {
+		return rawRow{}, false
+	}
+	rows := ru.rawRows()
+	if len(rows) != 1 {
+		return rawRow{}, false
+	}
+	return rows[0], true
+}
+
+// DecodeRaw decodes the encoded rows of the row update into its decoded rows, so the row update can be merged with
+// other row updates or modified
+func (ru *RowUpdate) DecodeRaw() error {
+	for _, f := range []struct {
+		raw *json.RawMessage
+		row **map[string]interface{}
+	}{{&ru.RawNew, &ru.New}, {&ru.RawOld, &ru.Old}, {&ru.RawInitial, &ru.Initial}, {&ru.RawInsert, &ru.Insert}} {
+		if *f.raw == nil {
+			continue
+		}
+		row := map[string]interface{}{}
+		if err := json.Unmarshal(*f.raw, &row); err != nil {
+			return err
+		}
+		*f.row = &row
+		*f.raw = nil
+	}
+	return nil
+}
+
 func (ru *RowUpdate) UnmarshalJSON(p []byte) error {
 	obj := map[string]interface{}{}
 	err := json.Unmarshal(p, &obj)
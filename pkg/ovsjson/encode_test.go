@@ -3,7 +3,10 @@ package ovsjson
 import (
 	"encoding/json"
 	"fmt"
+	"math/rand"
+	"reflect"
 	"testing"
+	"testing/quick"
 
 	"github.com/ibm/ovsdb-etcd/pkg/libovsdb"
 	"github.com/stretchr/testify/assert"
@@ -135,3 +138,103 @@ func TestCondMonitorParameters(t *testing.T) {
 		fmt.Sprintf("actual  : %v\n", actualCMP))
 
 }
+
+func TestMapSetEncodingArbitraryStrings(t *testing.T) {
+	// quotes, backslashes, control characters, non-ASCII and astral plane characters
+	runes := []rune{'a', '"', '\\', '/', ',', '\x00', '\x1f', '\t', '\n', '\u007f', 'é', '中', '\u2028', '\ufeff', '😀'}
+	randomString := func(r *rand.Rand) string {
+		s := make([]rune, r.Intn(8))
+		for i := range s {
+			s[i] = runes[r.Intn(len(runes))]
+		}
+		return string(s)
+	}
+	property := func(m Map, s Set) bool {
+		buf, err := json.Marshal(m)
+		if err != nil {
+			return false
+		}
+		var ovsMap libovsdb.OvsMap
+		if err := json.Unmarshal(buf, &ovsMap); err != nil || len(ovsMap.GoMap) != len(m) {
+			return false
+		}
+		for k, v := range m {
+			if ovsMap.GoMap[k] != v {
+				return false
+			}
+		}
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return false
+		}
+		var decoded []interface{}
+		if err := json.Unmarshal(buf, &decoded); err != nil || len(decoded) != 2 {
+			return false
+		}
+		return reflect.DeepEqual([]interface{}(s), decoded[1]) || len(s) == 0 && len(decoded[1].([]interface{})) == 0
+	}
+	assert.Nil(t, quick.Check(property, &quick.Config{MaxCount: 500, Values: func(values []reflect.Value, r *rand.Rand) {
+		m := Map{}
+		s := Set{}
+		for i := r.Intn(5); i > 0; i-- {
+			m[randomString(r)] = randomString(r)
+			s = append(s, randomString(r))
+		}
+		values[0] = reflect.ValueOf(m)
+		values[1] = reflect.ValueOf(s)
+	}}))
+}
+
+func TestFilterObject(t *testing.T) {
+	dropB := func(key string, value []byte) bool { return key != "b" }
+	for _, tc := range []struct {
+		data     string
+		expected string
+		kept     int
+	}{
+		{`{}`, `{}`, 0},
+		{` { } `, `{}`, 0},
+		{`{"a":1,"b":2}`, `{"a":1}`, 1},
+		{`{"b":2,"a":1}`, `{"a":1}`, 1},
+		{`{ "a" : ["set", [1, 2]] , "b" : {"x": "}"}, "c": "\"b\"" }`, `{"a":["set", [1, 2]],"c":"\"b\""}`, 2},
+		{`{"b":["map",[["k","]"]]],"c":true,"d":null,"e":-1.5e3}`, `{"c":true,"d":null,"e":-1.5e3}`, 3},
+	} {
+		actual, kept, err := FilterObject([]byte(tc.data), dropB)
+		assert.Nil(t, err, tc.data)
+		assert.Equal(t, tc.expected, string(actual), tc.data)
+		assert.Equal(t, tc.kept, kept, tc.data)
+		assert.True(t, json.Valid(actual), tc.data)
+	}
+	for _, data := range []string{``, `[]`, `{"a"}`, `{"a":}`, `{"a":1`, `{"a":[1}`, `{"a":"x}`, `{"a":1} x`, `{"a":1,}`} {
+		_, _, err := FilterObject([]byte(data), dropB)
+		assert.NotNil(t, err, data)
+	}
+	// the values of the members are passed as they are encoded
+	values := map[string]string{}
+	_, _, err := FilterObject([]byte(`{"_uuid": ["uuid", "u1"], "a": "x"}`), func(key string, value []byte) bool {
+		values[key] = string(value)
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"_uuid": `["uuid", "u1"]`, "a": `"x"`}, values)
+}
+
+func TestRowUpdateRawRows(t *testing.T) {
+	buf, err := json.Marshal(RowUpdate{RawInsert: json.RawMessage(`{"a": 1}`)})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"insert":{"a":1}}`, string(buf))
+	buf, err = json.Marshal(RowUpdate{RawNew: json.RawMessage(`{"a":1}`), Old: &map[string]interface{}{"a": 2}})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"new":{"a":1},"old":{"a":2}}`, string(buf))
+	ok, _ := (&RowUpdate{RawInitial: json.RawMessage(`{}`)}).ValidateRowUpdate2()
+	assert.True(t, ok)
+	ok, _ = (&RowUpdate{RawInitial: json.RawMessage(`{}`)}).ValidateRowUpdate()
+	assert.False(t, ok)
+
+	ru := RowUpdate{RawInitial: json.RawMessage(`{"a":["set",[1]]}`)}
+	assert.Nil(t, ru.DecodeRaw())
+	assert.Nil(t, ru.RawInitial)
+	assert.Equal(t, &map[string]interface{}{"a": []interface{}{"set", []interface{}{float64(1)}}}, ru.Initial)
+	ru = RowUpdate{RawOld: json.RawMessage(`[]`)}
+	assert.NotNil(t, ru.DecodeRaw())
+}
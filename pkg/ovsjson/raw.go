@@ -0,0 +1,151 @@
+package ovsjson
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// FilterObject returns the encoded JSON object with the members, which are kept by the keep function, and the number
+// of the kept members. The object is filtered on the token level: the kept keys and values are copied as they are
+// encoded, without decoding them, and keep is called with the encoded value of every member.
+func FilterObject(data []byte, keep func(key string, value []byte) bool) ([]byte, int, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, '{')
+	kept := 0
+	i := skipSpace(data, 0)
+	if i >= len(data) || data[i] != '{' {
+		return nil, 0, fmt.Errorf("encoded value is not a JSON object")
+	}
+	i = skipSpace(data, i+1)
+	if i < len(data) && data[i] == '}' {
+		i++
+	} else {
+		for {
+			keyStart := i
+			keyEnd, err := skipString(data, i)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, err := decodeKey(data[keyStart:keyEnd])
+			if err != nil {
+				return nil, 0, err
+			}
+			i = skipSpace(data, keyEnd)
+			if i >= len(data) || data[i] != ':' {
+				return nil, 0, fmt.Errorf("missing colon after the key %q at offset %d", key, i)
+			}
+			valueStart := skipSpace(data, i+1)
+			valueEnd, err := skipValue(data, valueStart)
+			if err != nil {
+				return nil, 0, err
+			}
+			if keep(key, data[valueStart:valueEnd]) {
+				if kept > 0 {
+					out = append(out, ',')
+				}
+				out = append(out, data[keyStart:keyEnd]...)
+				out = append(out, ':')
+				out = append(out, data[valueStart:valueEnd]...)
+				kept++
+			}
+			i = skipSpace(data, valueEnd)
+			if i >= len(data) {
+				return nil, 0, fmt.Errorf("unterminated JSON object")
+			}
+			if data[i] == '}' {
+				i++
+				break
+			}
+			if data[i] != ',' {
+				return nil, 0, fmt.Errorf("unexpected character %q at offset %d", data[i], i)
+			}
+			i = skipSpace(data, i+1)
+		}
+	}
+	if i = skipSpace(data, i); i != len(data) {
+		return nil, 0, fmt.Errorf("unexpected data after the JSON object at offset %d", i)
+	}
+	return append(out, '}'), kept, nil
+}
+
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\n' || data[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// skipString returns the offset after the encoded string, which starts at the offset
+func skipString(data []byte, i int) (int, error) {
+	if i >= len(data) || data[i] != '"' {
+		return 0, fmt.Errorf("expected a JSON string at offset %d", i)
+	}
+	for i++; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated JSON string")
+}
+
+// skipValue returns the offset after the encoded value, which starts at the offset, the nested objects and arrays are
+// skipped by their brackets, their content is validated by the encoder of the notification
+func skipValue(data []byte, i int) (int, error) {
+	if i >= len(data) {
+		return 0, fmt.Errorf("missing JSON value")
+	}
+	switch data[i] {
+	case '"':
+		return skipString(data, i)
+	case '{', '[':
+		depth := 0
+		for i < len(data) {
+			switch data[i] {
+			case '"':
+				end, err := skipString(data, i)
+				if err != nil {
+					return 0, err
+				}
+				i = end
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1, nil
+				}
+			}
+			i++
+		}
+		return 0, fmt.Errorf("unterminated JSON value")
+	default:
+		// a number, true, false or null
+		start := i
+		for i < len(data) && data[i] != ',' && data[i] != '}' && data[i] != ']' && data[i] != ' ' &&
+			data[i] != '\t' && data[i] != '\n' && data[i] != '\r' {
+			i++
+		}
+		if i == start {
+			return 0, fmt.Errorf("unexpected character %q at offset %d", data[i], i)
+		}
+		return i, nil
+	}
+}
+
+// decodeKey returns the key of the encoded string, only the keys with escape sequences are decoded
+func decodeKey(encoded []byte) (string, error) {
+	for _, b := range encoded {
+		if b == '\\' {
+			var key string
+			if err := json.Unmarshal(encoded, &key); err != nil {
+				return "", err
+			}
+			return key, nil
+		}
+	}
+	return string(encoded[1 : len(encoded)-1]), nil
+}
@@ -35,6 +35,12 @@ type RowUpdate struct {
 	Insert  *map[string]interface{}
 	Delete  bool
 	Modify  *map[string]interface{}
+	// the encoded rows, which are spliced as they are into the encoded row update instead of the decoded rows above,
+	// they are decoded by DecodeRaw before the row update is merged with other row updates
+	RawNew     json.RawMessage
+	RawOld     json.RawMessage
+	RawInitial json.RawMessage
+	RawInsert  json.RawMessage
 }
 
 // String, serialize Operation TableUpdate
@@ -60,10 +66,10 @@ func (ru RowUpdate) String() string {
 // If the RowUpdate object is not valid, the method returns <false> and an explanation message
 func (ru *RowUpdate) ValidateRowUpdate() (bool, string) {
 	i := 0
-	if ru.Initial != nil {
+	if ru.Initial != nil || ru.RawInitial != nil {
 		i++
 	}
-	if ru.Insert != nil {
+	if ru.Insert != nil || ru.RawInsert != nil {
 		i++
 	}
 	if ru.Delete {
@@ -75,7 +81,7 @@ func (ru *RowUpdate) ValidateRowUpdate() (bool, string) {
 	if i != 0 {
 		return false, "Contains RowUpdate2 entries"
 	}
-	if (ru.New == nil) && (ru.Old == nil) {
+	if (ru.New == nil) && (ru.Old == nil) && (ru.RawNew == nil) && (ru.RawOld == nil) {
 		return false, "Empty RowUpdate"
 	}
 	return true, ""
@@ -86,10 +92,10 @@ func (ru *RowUpdate) ValidateRowUpdate() (bool, string) {
 // If the RowUpdate object is not valid, the method returns <false> and an explanation message
 func (ru *RowUpdate) ValidateRowUpdate2() (bool, string) {
 	i := 0
-	if ru.Initial != nil {
+	if ru.Initial != nil || ru.RawInitial != nil {
 		i++
 	}
-	if ru.Insert != nil {
+	if ru.Insert != nil || ru.RawInsert != nil {
 		i++
 	}
 	if ru.Delete {
@@ -101,7 +107,7 @@ func (ru *RowUpdate) ValidateRowUpdate2() (bool, string) {
 	if i > 1 {
 		return false, "Contains several RowUpdate2 entries"
 	}
-	if (ru.New != nil) || (ru.Old != nil) {
+	if (ru.New != nil) || (ru.Old != nil) || (ru.RawNew != nil) || (ru.RawOld != nil) {
 		return false, "Contains RowUpdate entries"
 	}
 	return true, ""
@@ -122,7 +128,8 @@ const (
 )
 
 type MonitorCondRequest struct {
-	Columns []string                `json:"columns,omitempty"`
+	// nil for all the columns, an empty list for the rows UUIDs only
+	Columns []string                `json:"columns"`
 	Where   interface{}             `json:"where,omitempty"` // TODO fix type (should be []string, or [][]string, but sometimes it is boolean
 	Select  *libovsdb.MonitorSelect `json:"select,omitempty"`
 }
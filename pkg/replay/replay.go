@@ -0,0 +1,289 @@
+// Package replay reads the history of an ovsdb-etcd deployment, from etcd or from an export file, and replays it into
+// another prefix or database. It is used to reproduce bugs from production history and to migrate prefixes.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+const (
+	EVENT_PUT    = "put"
+	EVENT_DELETE = "delete"
+)
+
+// MaxTxnOps is the maximal number of operations of an etcd transaction, the --max-txn-ops of the target etcd. Batches
+// with more events, e.g. the initial snapshot, are applied by several transactions.
+var MaxTxnOps = 128
+
+// the interval of the watch progress requests, which detect the end of the history
+const progressInterval = 100 * time.Millisecond
+
+// Event is a single key modification, the events of an export file are stored as JSON lines
+type Event struct {
+	Revision int64  `json:"revision"`
+	Type     string `json:"type"`
+	Key      string `json:"key"`
+	Value    []byte `json:"value,omitempty"`
+}
+
+// Batch holds the events of a single etcd revision, which were committed by a single transaction
+type Batch struct {
+	Revision int64
+	Events   []Event
+}
+
+// BatchFunc is called for every batch in revision order, a returned error stops the reading
+type BatchFunc func(batch Batch) error
+
+// ReadHistory reads the history of the keys under the prefix between the start and the end revisions, inclusive. The
+// first batch holds the snapshot of the keys at the revision before the start revision, so the replayed state is
+// complete. endRev 0 means the current revision. The revisions must not be compacted.
+func ReadHistory(ctx context.Context, cli *clientv3.Client, prefix string, startRev, endRev int64, fn BatchFunc) error {
+	if startRev < 1 {
+		return fmt.Errorf("wrong start revision %d", startRev)
+	}
+	resp, err := cli.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return err
+	}
+	if endRev == 0 {
+		endRev = resp.Header.Revision
+	}
+	if endRev < startRev || endRev > resp.Header.Revision {
+		return fmt.Errorf("wrong end revision %d, start revision %d, current revision %d", endRev, startRev, resp.Header.Revision)
+	}
+	if startRev > 1 {
+		snapshot, err := cli.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(startRev-1))
+		if err != nil {
+			return err
+		}
+		batch := Batch{Revision: startRev - 1}
+		for _, kv := range snapshot.Kvs {
+			batch.Events = append(batch.Events, Event{Revision: startRev - 1, Type: EVENT_PUT, Key: string(kv.Key), Value: kv.Value})
+		}
+		if len(batch.Events) > 0 {
+			if err := fn(batch); err != nil {
+				return err
+			}
+		}
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watcher := clientv3.NewWatcher(cli)
+	defer watcher.Close()
+	wch := watcher.Watch(wctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(startRev))
+	var batch *Batch
+	flush := func() error {
+		if batch == nil {
+			return nil
+		}
+		b := *batch
+		batch = nil
+		return fn(b)
+	}
+	// the progress notification is sent after the watch has caught up with the history
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		var wresp clientv3.WatchResponse
+		var ok bool
+		select {
+		case <-ticker.C:
+			if err := watcher.RequestProgress(wctx); err != nil {
+				return err
+			}
+			continue
+		case wresp, ok = <-wch:
+		}
+		if !ok {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("watch closed")
+		}
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		if wresp.IsProgressNotify() && wresp.Header.Revision >= endRev {
+			// the watch has sent all the history
+			return flush()
+		}
+		for _, ev := range wresp.Events {
+			revision := ev.Kv.ModRevision
+			if revision > endRev {
+				return flush()
+			}
+			if batch != nil && batch.Revision != revision {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			if batch == nil {
+				batch = &Batch{Revision: revision}
+			}
+			event := Event{Revision: revision, Type: EVENT_PUT, Key: string(ev.Kv.Key), Value: ev.Kv.Value}
+			if ev.Type == mvccpb.DELETE {
+				event.Type = EVENT_DELETE
+				event.Value = nil
+			}
+			batch.Events = append(batch.Events, event)
+		}
+		if batch != nil && batch.Revision == endRev {
+			return flush()
+		}
+	}
+}
+
+// ReadExport reads the events of an export file, the events of each revision are passed as a single batch
+func ReadExport(r io.Reader, fn BatchFunc) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var batch *Batch
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if event.Type != EVENT_PUT && event.Type != EVENT_DELETE {
+			return fmt.Errorf("line %d: wrong event type %q", line, event.Type)
+		}
+		if batch != nil && event.Revision < batch.Revision {
+			return fmt.Errorf("line %d: revision %d is lower than the previous revision %d", line, event.Revision, batch.Revision)
+		}
+		if batch != nil && batch.Revision != event.Revision {
+			if err := fn(*batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+		if batch == nil {
+			batch = &Batch{Revision: event.Revision}
+		}
+		batch.Events = append(batch.Events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if batch != nil {
+		return fn(*batch)
+	}
+	return nil
+}
+
+// Exporter writes the batches into an export file
+func Exporter(w io.Writer) BatchFunc {
+	encoder := json.NewEncoder(w)
+	return func(batch Batch) error {
+		for _, event := range batch.Events {
+			if err := encoder.Encode(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Rewriter maps the keys of the source deployment to the keys of the target deployment
+type Rewriter struct {
+	FromPrefix string
+	ToPrefix   string
+	// if set, only the keys of the database are replayed, and they are moved to the ToDB database
+	FromDB string
+	ToDB   string
+}
+
+// Rewrite returns the target key, false if the key is not replayed
+func (rw *Rewriter) Rewrite(key string) (string, bool) {
+	if !strings.HasPrefix(key, rw.FromPrefix+common.KEY_DELIMETER) {
+		return "", false
+	}
+	rest := strings.TrimPrefix(key, rw.FromPrefix+common.KEY_DELIMETER)
+	if rw.FromDB != "" {
+		if !strings.HasPrefix(rest, rw.FromDB+common.KEY_DELIMETER) {
+			return "", false
+		}
+		rest = rw.ToDB + strings.TrimPrefix(rest, rw.FromDB)
+	}
+	return rw.ToPrefix + common.KEY_DELIMETER + rest, true
+}
+
+// Replayer applies the batches to the target deployment, every batch is applied by a single etcd transaction, so the
+// target history has the same transactions as the source one. Batches larger than MaxTxnOps are split.
+type Replayer struct {
+	cli      *clientv3.Client
+	rewriter *Rewriter
+	// number of the applied batches and events
+	Batches int
+	Events  int
+}
+
+func NewReplayer(cli *clientv3.Client, rewriter *Rewriter) *Replayer {
+	return &Replayer{cli: cli, rewriter: rewriter}
+}
+
+// CheckTarget returns an error if the target prefix is not empty
+func (r *Replayer) CheckTarget(ctx context.Context) error {
+	target := r.rewriter.ToPrefix + common.KEY_DELIMETER
+	if r.rewriter.FromDB != "" {
+		target += r.rewriter.ToDB + common.KEY_DELIMETER
+	}
+	resp, err := r.cli.Get(ctx, target, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return err
+	}
+	if resp.Count > 0 {
+		return fmt.Errorf("the target %s is not empty, it has %d keys", target, resp.Count)
+	}
+	return nil
+}
+
+// Apply is a BatchFunc, which applies the batch to the target
+func (r *Replayer) Apply(ctx context.Context) BatchFunc {
+	return func(batch Batch) error {
+		ops := []clientv3.Op{}
+		for _, event := range batch.Events {
+			key, ok := r.rewriter.Rewrite(event.Key)
+			if !ok {
+				continue
+			}
+			if event.Type == EVENT_DELETE {
+				ops = append(ops, clientv3.OpDelete(key))
+			} else {
+				ops = append(ops, clientv3.OpPut(key, string(event.Value)))
+			}
+		}
+		if len(ops) == 0 {
+			return nil
+		}
+		for len(ops) > 0 {
+			n := len(ops)
+			if n > MaxTxnOps {
+				n = MaxTxnOps
+			}
+			if _, err := r.cli.Txn(ctx).Then(ops[:n]...).Commit(); err != nil {
+				return fmt.Errorf("replay of revision %d failed: %v", batch.Revision, err)
+			}
+			r.Events += n
+			ops = ops[n:]
+		}
+		r.Batches++
+		return nil
+	}
+}
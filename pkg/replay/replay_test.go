@@ -0,0 +1,103 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+func testEtcdNewCli(t *testing.T) *clientv3.Client {
+	cli, err := ovsdb.NewEtcdClient([]string{"http://127.0.0.1:2379"})
+	assert.Nil(t, err)
+	_, err = cli.Delete(context.Background(), "replay/", clientv3.WithPrefix())
+	assert.Nil(t, err)
+	return cli
+}
+
+func testState(t *testing.T, cli *clientv3.Client, prefix string) map[string]string {
+	resp, err := cli.Get(context.Background(), prefix+"/", clientv3.WithPrefix())
+	assert.Nil(t, err)
+	state := map[string]string{}
+	for _, kv := range resp.Kvs {
+		state[strings.TrimPrefix(string(kv.Key), prefix)] = string(kv.Value)
+	}
+	return state
+}
+
+func TestRewriter(t *testing.T) {
+	rw := &Rewriter{FromPrefix: "ovsdb/nb", ToPrefix: "debug/nb"}
+	key, ok := rw.Rewrite("ovsdb/nb/OVN_Northbound/ACL/1")
+	assert.True(t, ok)
+	assert.Equal(t, "debug/nb/OVN_Northbound/ACL/1", key)
+	_, ok = rw.Rewrite("ovsdb/nb2/OVN_Northbound/ACL/1")
+	assert.False(t, ok)
+
+	rw = &Rewriter{FromPrefix: "ovsdb/nb", ToPrefix: "ovsdb/nb", FromDB: "OVN_Northbound", ToDB: "NB_Copy"}
+	key, ok = rw.Rewrite("ovsdb/nb/OVN_Northbound/ACL/1")
+	assert.True(t, ok)
+	assert.Equal(t, "ovsdb/nb/NB_Copy/ACL/1", key)
+	_, ok = rw.Rewrite("ovsdb/nb/OVN_Northbound2/ACL/1")
+	assert.False(t, ok)
+	_, ok = rw.Rewrite("ovsdb/nb/_/_locks/1")
+	assert.False(t, ok)
+}
+
+func TestReplayHistory(t *testing.T) {
+	cli := testEtcdNewCli(t)
+	defer cli.Close()
+	ctx := context.Background()
+	src := "replay/src"
+	_, err := cli.Put(ctx, src+"/db/T1/1", "a")
+	assert.Nil(t, err)
+	resp, err := cli.Put(ctx, src+"/db/T1/2", "b")
+	assert.Nil(t, err)
+	start := resp.Header.Revision + 1
+	_, err = cli.Txn(ctx).Then(clientv3.OpPut(src+"/db/T1/1", "a2"), clientv3.OpPut(src+"/db/T2/3", "c")).Commit()
+	assert.Nil(t, err)
+	// keys of other prefixes are not replayed
+	_, err = cli.Put(ctx, "replay/srcx/db/T1/1", "x")
+	assert.Nil(t, err)
+	_, err = cli.Delete(ctx, src+"/db/T1/2")
+	assert.Nil(t, err)
+	resp, err = cli.Put(ctx, src+"/db/T1/4", "d")
+	assert.Nil(t, err)
+	end := resp.Header.Revision
+	// not replayed, after the end revision
+	_, err = cli.Put(ctx, src+"/db/T1/5", "e")
+	assert.Nil(t, err)
+
+	var export bytes.Buffer
+	assert.Nil(t, ReadHistory(ctx, cli, src+"/", start, end, Exporter(&export)))
+
+	replayer := NewReplayer(cli, &Rewriter{FromPrefix: src, ToPrefix: "replay/dst"})
+	assert.Nil(t, replayer.CheckTarget(ctx))
+	assert.Nil(t, ReadExport(&export, replayer.Apply(ctx)))
+	// the snapshot, the transaction, the delete and the last put
+	assert.Equal(t, 4, replayer.Batches)
+	expected := map[string]string{"/db/T1/1": "a2", "/db/T2/3": "c", "/db/T1/4": "d"}
+	assert.Equal(t, expected, testState(t, cli, "replay/dst"))
+	assert.NotNil(t, replayer.CheckTarget(ctx))
+
+	// directly from the etcd history, till the current revision
+	replayer = NewReplayer(cli, &Rewriter{FromPrefix: src, ToPrefix: "replay/dst2"})
+	assert.Nil(t, ReadHistory(ctx, cli, src+"/", start, 0, replayer.Apply(ctx)))
+	expected["/db/T1/5"] = "e"
+	assert.Equal(t, expected, testState(t, cli, "replay/dst2"))
+
+	assert.NotNil(t, ReadHistory(ctx, cli, src+"/", end, start, replayer.Apply(ctx)))
+}
+
+func TestReadExportWrongOrder(t *testing.T) {
+	export := `{"revision": 5, "type": "put", "key": "a"}
+{"revision": 4, "type": "put", "key": "b"}`
+	err := ReadExport(strings.NewReader(export), func(batch Batch) error { return nil })
+	assert.NotNil(t, err)
+	err = ReadExport(strings.NewReader(`{"revision": 5, "type": "get", "key": "a"}`), func(batch Batch) error { return nil })
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ConfigFileExtensions are the supported formats of the configuration file, by the file extension
+var ConfigFileExtensions = []string{"yaml", "yml", "toml", "json"}
+
+// Config is the content of a configuration file of the server, its keys are the names of the command line flags, e.g.
+// "remote", "etcd-members", "ssl-cert", "schema-file", "v" or "notification-queue", and a value is a list of the flag
+// values, every value is set as a repeated flag.
+type Config map[string][]string
+
+// ReadConfigFile reads a configuration file, whose format is YAML, TOML or JSON by the file extension. A value of the
+// file is a scalar or a list of scalars, e.g.
+//
+//	remote:
+//	  - ptcp:6641
+//	  - punix:/run/ovnnb_db.sock
+//	etcd-members: etcd-0:2379,etcd-1:2379
+//	notification-queue: 512
+func ReadConfigFile(file string) (Config, error) {
+	ext := strings.TrimPrefix(filepath.Ext(file), ".")
+	if !isConfigFileExtension(ext) {
+		return nil, fmt.Errorf("config file %s, unsupported format, the file extension should be one of %s", file,
+			strings.Join(ConfigFileExtensions, ", "))
+	}
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read the config file %s: %v", file, err)
+	}
+	config := Config{}
+	for key, value := range v.AllSettings() {
+		values, err := configValues(value)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s, key %s: %v", file, key, err)
+		}
+		config[key] = values
+	}
+	return config, nil
+}
+
+func isConfigFileExtension(ext string) bool {
+	for _, e := range ConfigFileExtensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func configValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, fmt.Errorf("missing value")
+	case map[string]interface{}:
+		return nil, fmt.Errorf("unsupported value %v, the keys are the flag names", v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case nil, map[string]interface{}, []interface{}:
+				return nil, fmt.Errorf("unsupported list item %v", item)
+			}
+			values = append(values, fmt.Sprint(item))
+		}
+		return values, nil
+	default:
+		return []string{fmt.Sprint(v)}, nil
+	}
+}
+
+// Apply sets the flags of the flag set to the values of the configuration, except the flags in skip, e.g. the flags,
+// which were set on the command line and override the configuration file. The unknown keys are an error.
+func (c Config) Apply(fs *flag.FlagSet, skip map[string]bool) error {
+	for _, key := range c.Keys() {
+		f := fs.Lookup(key)
+		if f == nil {
+			return fmt.Errorf("unknown config key %s", key)
+		}
+		if skip[key] {
+			continue
+		}
+		for _, value := range c[key] {
+			if err := f.Value.Set(value); err != nil {
+				return fmt.Errorf("invalid value %q of config key %s: %v", value, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Keys returns the sorted keys of the configuration
+func (c Config) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Changed returns the sorted keys, whose values differ from the previous configuration, including the added and the
+// removed keys
+func (c Config) Changed(previous Config) []string {
+	changed := []string{}
+	for key, values := range c {
+		if !equalValues(values, previous[key]) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range previous {
+		if _, ok := c[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
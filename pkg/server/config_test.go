@@ -0,0 +1,128 @@
+package server
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"server.yaml": `
+remote:
+  - ptcp:6641
+  - punix:/run/ovnnb_db.sock
+etcd-members: etcd-0:2379,etcd-1:2379
+notification-queue: 512
+durable-monitors: false
+coalesce-window: 10ms
+`,
+		"server.toml": `
+remote = ["ptcp:6641", "punix:/run/ovnnb_db.sock"]
+etcd-members = "etcd-0:2379,etcd-1:2379"
+notification-queue = 512
+durable-monitors = false
+coalesce-window = "10ms"
+`,
+		"server.json": `{"remote": ["ptcp:6641", "punix:/run/ovnnb_db.sock"], "etcd-members": "etcd-0:2379,etcd-1:2379",
+			"notification-queue": 512, "durable-monitors": false, "coalesce-window": "10ms"}`,
+	}
+	expected := Config{
+		"remote":             {"ptcp:6641", "punix:/run/ovnnb_db.sock"},
+		"etcd-members":       {"etcd-0:2379,etcd-1:2379"},
+		"notification-queue": {"512"},
+		"durable-monitors":   {"false"},
+		"coalesce-window":    {"10ms"},
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		assert.Nil(t, ioutil.WriteFile(file, []byte(content), 0644))
+		config, err := ReadConfigFile(file)
+		if assert.Nil(t, err, name) {
+			assert.Equal(t, expected, config, name)
+		}
+	}
+	invalid := map[string]string{
+		"server.ini":       "remote=ptcp:6641",
+		"nested.yaml":      "tls:\n  ssl-cert: cert.pem\n",
+		"broken.yaml":      "remote: [ptcp:6641",
+		"missing.yaml":     "",
+		"nested-list.json": `{"remote": [["ptcp:6641"]]}`,
+	}
+	for name, content := range invalid {
+		file := filepath.Join(dir, name)
+		if name != "missing.yaml" {
+			assert.Nil(t, ioutil.WriteFile(file, []byte(content), 0644))
+		}
+		_, err := ReadConfigFile(file)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	var remotes testRepeatedFlag
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&remotes, "remote", "")
+	members := fs.String("etcd-members", "localhost:2379", "")
+	window := fs.Duration("coalesce-window", 0, "")
+	queue := fs.Int("notification-queue", 256, "")
+	assert.Nil(t, fs.Parse([]string{"--etcd-members", "etcd-2:2379"}))
+	cmdLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { cmdLine[f.Name] = true })
+
+	config := Config{
+		"remote":          {"ptcp:6641", "punix:/run/ovnnb_db.sock"},
+		"etcd-members":    {"etcd-0:2379"},
+		"coalesce-window": {"10ms"},
+	}
+	// the command line flags override the config file
+	assert.Nil(t, config.Apply(fs, cmdLine))
+	assert.Equal(t, testRepeatedFlag{"ptcp:6641", "punix:/run/ovnnb_db.sock"}, remotes)
+	assert.Equal(t, "etcd-2:2379", *members)
+	assert.Equal(t, 10*time.Millisecond, *window)
+	assert.Equal(t, 256, *queue)
+
+	err := Config{"notification-queue": {"many"}}.Apply(fs, nil)
+	if assert.NotNil(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "notification-queue"), err.Error())
+	}
+	err = Config{"no-such-flag": {"1"}}.Apply(fs, nil)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "unknown config key no-such-flag", err.Error())
+	}
+}
+
+func TestConfigChanged(t *testing.T) {
+	previous := Config{
+		"remote":       {"ptcp:6641"},
+		"v":            {"3"},
+		"etcd-members": {"etcd-0:2379"},
+		"readonly":     {"OVN_Northbound"},
+	}
+	config := Config{
+		"remote":       {"ptcp:6641", "punix:/run/ovnnb_db.sock"},
+		"v":            {"5"},
+		"etcd-members": {"etcd-0:2379"},
+		"serializable": {"OVN_Northbound"},
+	}
+	assert.Equal(t, []string{"readonly", "remote", "serializable", "v"}, config.Changed(previous))
+	assert.Empty(t, config.Changed(config))
+	assert.Equal(t, []string{"etcd-members", "remote", "serializable", "v"}, config.Changed(nil))
+}
+
+type testRepeatedFlag []string
+
+func (f *testRepeatedFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *testRepeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
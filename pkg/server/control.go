@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	klog "k8s.io/klog/v2"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+// The control socket serves the runtime commands of the server in the protocol of the ovsdb-server control socket, so
+// they can be sent by ovs-appctl, e.g. "ovs-appctl -t /run/ovsdb-etcd.ctl connections/list". The requests are
+// JSON-RPC 1.0 requests, whose method is the command and whose params are its string arguments, and the result of a
+// reply is the output text of the command, or its error is the failure message.
+
+// the highest klog verbosity, which is reported by vlog/list
+const maxVerbosity = 10
+
+type controlRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	ID     interface{}     `json:"id"`
+}
+
+type controlReply struct {
+	Result interface{} `json:"result"`
+	Error  interface{} `json:"error"`
+	ID     interface{} `json:"id"`
+}
+
+// controlCommand is a command of the control socket, which is called with its arguments
+type controlCommand struct {
+	usage   string
+	minArgs int
+	maxArgs int
+	run     func(ctx context.Context, args []string) (string, error)
+}
+
+func (s *Server) controlCommands() map[string]controlCommand {
+	return map[string]controlCommand{
+		"list-commands":             {"", 0, 0, s.listCommands},
+		"connections/list":          {"", 0, 0, s.listConnections},
+		"monitors/list":             {"[address]", 0, 1, s.listMonitors},
+		"ovsdb-server/list-remotes": {"", 0, 0, s.listRemotes},
+		"ovsdb-server/compact":      {"[retained-revisions]", 0, 1, s.compact},
+		"memory/gc":                 {"", 0, 0, collectGarbage},
+		"vlog/list":                 {"", 0, 0, listVerbosity},
+		"vlog/set":                  {"level", 1, 1, setVerbosity},
+	}
+}
+
+// serveControl listens on the control socket, and serves its connections until the server is shut down
+func (s *Server) serveControl() error {
+	if runtime.GOOS != "linux" {
+		return errors.New("the control socket is supported on linux only")
+	}
+	if err := os.RemoveAll(s.options.ControlSocket); err != nil {
+		return err
+	}
+	lst, err := net.Listen("unix", s.options.ControlSocket)
+	if err != nil {
+		return err
+	}
+	s.controlLst = lst
+	s.log.Info("serving control commands", "on", s.options.ControlSocket)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := lst.Accept()
+			if err != nil {
+				if s.ctx.Err() == nil {
+					s.log.Error(err, "control socket failed")
+				}
+				return
+			}
+			s.mu.Lock()
+			if s.stopped {
+				s.mu.Unlock()
+				conn.Close()
+				return
+			}
+			s.controlConns[conn] = true
+			s.mu.Unlock()
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serveControlConn(conn)
+				s.mu.Lock()
+				delete(s.controlConns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+		}
+	}()
+	return nil
+}
+
+// serveControlConn runs the commands of a control connection, one by one, until the connection is closed
+func (s *Server) serveControlConn(conn net.Conn) {
+	commands := s.controlCommands()
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+	for {
+		var req controlRequest
+		if err := decoder.Decode(&req); err != nil {
+			s.log.V(5).Info("control connection closed", "err", err)
+			return
+		}
+		reply := controlReply{ID: req.ID}
+		output, err := s.runControlCommand(commands, req)
+		if err != nil {
+			reply.Error = err.Error()
+			s.log.V(5).Info("control command failed", "command", req.Method, "err", err)
+		} else {
+			reply.Result = output
+			s.log.V(5).Info("control command", "command", req.Method)
+		}
+		if err := encoder.Encode(reply); err != nil {
+			s.log.V(5).Info("control connection closed", "err", err)
+			return
+		}
+	}
+}
+
+func (s *Server) runControlCommand(commands map[string]controlCommand, req controlRequest) (string, error) {
+	command, ok := commands[req.Method]
+	if !ok {
+		return "", fmt.Errorf("%q is not a valid command (use \"list-commands\" to see a list of valid commands)", req.Method)
+	}
+	args := []string{}
+	if len(req.Params) > 0 && string(req.Params) != "null" {
+		if err := json.Unmarshal(req.Params, &args); err != nil {
+			return "", fmt.Errorf("command arguments are not strings: %v", err)
+		}
+	}
+	if len(args) < command.minArgs || len(args) > command.maxArgs {
+		return "", fmt.Errorf("%q command takes %d to %d arguments", req.Method, command.minArgs, command.maxArgs)
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, ovsdb.EtcdClientTimeout)
+	defer cancel()
+	return command.run(ctx, args)
+}
+
+func (s *Server) listCommands(ctx context.Context, args []string) (string, error) {
+	commands := s.controlCommands()
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var out strings.Builder
+	out.WriteString("The available commands are:\n")
+	for _, name := range names {
+		fmt.Fprintf(&out, "  %-23s %s\n", name, commands[name].usage)
+	}
+	return out.String(), nil
+}
+
+// listConnections prints a line per client: its address, identity, role, number of monitors, number of the written
+// notifications and number of the queued ones
+func (s *Server) listConnections(ctx context.Context, args []string) (string, error) {
+	var out strings.Builder
+	for _, conn := range s.admin.Connections() {
+		identity := &ovsdb.Identity{Name: ovsdb.ANONYMOUS_IDENTITY}
+		if conn.Identity != nil {
+			identity = conn.Identity
+		}
+		fmt.Fprintf(&out, "%s identity=%s", conn.Address, identity.Name)
+		if identity.Role != "" {
+			fmt.Fprintf(&out, " role=%s", identity.Role)
+		}
+		fmt.Fprintf(&out, " monitors=%d sent=%d queued=%d\n", len(conn.Monitors), conn.NotificationsSent, conn.QueueLength)
+	}
+	return out.String(), nil
+}
+
+// listMonitors prints a line per monitor of the clients, or of the client of the given address: the client address,
+// the database, the monitor method, its json-value, the monitored tables, and the numbers of the written and the queued
+// notifications
+func (s *Server) listMonitors(ctx context.Context, args []string) (string, error) {
+	var out strings.Builder
+	for _, conn := range s.admin.Connections() {
+		if len(args) == 1 && conn.Address != args[0] {
+			continue
+		}
+		for _, monitor := range conn.Monitors {
+			jsonValue, err := json.Marshal(monitor.JSONValue)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&out, "%s %s %s %s tables=%s sent=%d queued=%d\n", conn.Address, monitor.DBName, monitor.Method,
+				jsonValue, strings.Join(monitor.Tables, ","), monitor.NotificationsSent, monitor.QueueLength)
+		}
+	}
+	return out.String(), nil
+}
+
+func (s *Server) listRemotes(ctx context.Context, args []string) (string, error) {
+	var out strings.Builder
+	for _, remote := range s.Remotes() {
+		out.WriteString(remote + "\n")
+	}
+	return out.String(), nil
+}
+
+// compact compacts the etcd revisions, except the given number of the latest ones. The monitors, whose etcd watches
+// miss the compacted revisions, are resumed from their snapshots.
+func (s *Server) compact(ctx context.Context, args []string) (string, error) {
+	retained := int64(0)
+	if len(args) == 1 {
+		var err error
+		if retained, err = strconv.ParseInt(args[0], 10, 64); err != nil || retained < 0 {
+			return "", fmt.Errorf("wrong number of retained revisions %q", args[0])
+		}
+	}
+	resp, err := s.cli.Get(ctx, common.NewDBPrefixKey(ovsdb.INT_SERVER).String(), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return "", err
+	}
+	revision := resp.Header.Revision - retained
+	if revision < 1 {
+		return "", fmt.Errorf("nothing to compact, the current revision is %d", resp.Header.Revision)
+	}
+	if _, err := s.cli.Compact(ctx, revision); err != nil {
+		return "", err
+	}
+	s.log.Info("compacted etcd revisions", "revision", revision)
+	return fmt.Sprintf("compacted the etcd revisions up to %d\n", revision), nil
+}
+
+// collectGarbage runs the Go garbage collector, and returns the freed memory to the operating system
+func collectGarbage(ctx context.Context, args []string) (string, error) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	debug.FreeOSMemory()
+	runtime.ReadMemStats(&after)
+	return fmt.Sprintf("heap in use: %d bytes before, %d bytes after\n", before.HeapInuse, after.HeapInuse), nil
+}
+
+func listVerbosity(ctx context.Context, args []string) (string, error) {
+	verbosity := 0
+	for verbosity < maxVerbosity && klog.V(klog.Level(verbosity+1)).Enabled() {
+		verbosity++
+	}
+	return fmt.Sprintf("verbosity: %d\n", verbosity), nil
+}
+
+// setVerbosity sets the klog verbosity of the server, as the -v flag does
+func setVerbosity(ctx context.Context, args []string) (string, error) {
+	level, err := strconv.Atoi(args[0])
+	if err != nil || level < 0 {
+		return "", fmt.Errorf("wrong verbosity level %q", args[0])
+	}
+	var verbosity klog.Level
+	if err := verbosity.Set(args[0]); err != nil {
+		return "", err
+	}
+	klog.Infof("log verbosity set to %d", level)
+	return "", nil
+}
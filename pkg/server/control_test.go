@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func TestServerControlSocket(t *testing.T) {
+	common.SetPrefix("ovsdb/embedded")
+	dir := t.TempDir()
+	control := filepath.Join(dir, "ovsdb-etcd.ctl")
+	srv, err := NewServer(Options{
+		Remotes:          []string{"punix:" + filepath.Join(dir, "db.sock")},
+		EtcdMembers:      []string{"http://127.0.0.1:2379"},
+		SchemaFiles:      []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema"},
+		StorageMigration: true,
+		ControlSocket:    control,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, srv.Start())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer srv.Shutdown(ctx)
+
+	conn, err := net.Dial("unix", filepath.Join(dir, "db.sock"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	cli := jrpc2.NewClient(channel.RawJSON(conn, conn), &jrpc2.ClientOptions{AllowV1: true})
+	defer cli.Close()
+	_, err = cli.Call(ctx, "monitor_cond", []interface{}{"_Server", "m1",
+		map[string]interface{}{"Database": []interface{}{map[string]interface{}{"columns": []string{"name"}}}}})
+	assert.Nil(t, err)
+
+	ctl, err := net.Dial("unix", control)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer ctl.Close()
+	decoder := json.NewDecoder(ctl)
+	id := 0
+	command := func(method string, params ...string) controlReply {
+		id++
+		assert.Nil(t, json.NewEncoder(ctl).Encode(map[string]interface{}{"method": method, "params": params, "id": id}))
+		var reply controlReply
+		assert.Nil(t, decoder.Decode(&reply))
+		assert.Equal(t, float64(id), reply.ID)
+		return reply
+	}
+
+	reply := command("list-commands")
+	assert.Nil(t, reply.Error)
+	assert.Contains(t, reply.Result, "connections/list")
+	reply = command("connections/list")
+	assert.Nil(t, reply.Error)
+	assert.Contains(t, reply.Result, "identity=anonymous monitors=1 sent=")
+	reply = command("monitors/list")
+	assert.Nil(t, reply.Error)
+	assert.Contains(t, reply.Result, `_Server monitor_cond "m1" tables=Database sent=`)
+	reply = command("ovsdb-server/list-remotes")
+	assert.Nil(t, reply.Error)
+	assert.Contains(t, reply.Result, "db.sock")
+
+	reply = command("vlog/list")
+	assert.Nil(t, reply.Error)
+	verbosity := reply.Result.(string)
+	reply = command("vlog/set", "7")
+	assert.Nil(t, reply.Error)
+	assert.Equal(t, "verbosity: 7\n", command("vlog/list").Result)
+	// restore the verbosity
+	assert.Nil(t, command("vlog/set", strings.TrimSpace(strings.TrimPrefix(verbosity, "verbosity:"))).Error)
+	assert.Equal(t, verbosity, command("vlog/list").Result)
+
+	reply = command("memory/gc")
+	assert.Nil(t, reply.Error)
+	assert.Contains(t, reply.Result, "heap in use")
+
+	for _, tc := range []struct {
+		method string
+		params []string
+	}{
+		{"unknown", nil},
+		{"vlog/set", nil},
+		{"vlog/set", []string{"high"}},
+		{"ovsdb-server/compact", []string{"-1"}},
+		{"ovsdb-server/compact", []string{"9223372036854775807"}},
+	} {
+		reply = command(tc.method, tc.params...)
+		assert.NotNil(t, reply.Error, tc.method)
+		assert.Nil(t, reply.Result, tc.method)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+const (
+	HEALTH_PATH = "/healthz"
+	READY_PATH  = "/readyz"
+
+	HEALTH_OK     = "ok"
+	HEALTH_FAILED = "failed"
+
+	// the names of the checks
+	CHECK_ETCD      = "etcd"
+	CHECK_SCHEMAS   = "schemas"
+	CHECK_WATCHES   = "watches"
+	CHECK_LIFECYCLE = "lifecycle"
+	CHECK_LISTENERS = "listeners"
+)
+
+// HealthCheck is the result of a single check, the error is empty if the check passed
+type HealthCheck struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// HealthStatus is the result of the health or the readiness probe, its status is failed if any of the checks failed
+type HealthStatus struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// Health checks that the server is alive: etcd is reachable, the schemas are loaded, and the etcd watches of the
+// monitors are not failing. A server, which fails the check, should be restarted.
+func (s *Server) Health(ctx context.Context) HealthStatus {
+	return newHealthStatus(map[string]error{
+		CHECK_ETCD:    s.checkEtcd(ctx),
+		CHECK_SCHEMAS: s.checkSchemas(),
+		CHECK_WATCHES: checkWatches(),
+	})
+}
+
+// Readiness checks that the server is alive and serves its clients: in addition to the health checks, the server was
+// started and not shut down, its replica state is reported, and it listens on at least one remote.
+func (s *Server) Readiness(ctx context.Context) HealthStatus {
+	return newHealthStatus(map[string]error{
+		CHECK_ETCD:      s.checkEtcd(ctx),
+		CHECK_SCHEMAS:   s.checkSchemas(),
+		CHECK_WATCHES:   checkWatches(),
+		CHECK_LIFECYCLE: s.checkLifecycle(),
+		CHECK_LISTENERS: s.checkListeners(),
+	})
+}
+
+func newHealthStatus(checks map[string]error) HealthStatus {
+	status := HealthStatus{Status: HEALTH_OK, Checks: []HealthCheck{}}
+	for _, name := range []string{CHECK_ETCD, CHECK_SCHEMAS, CHECK_WATCHES, CHECK_LIFECYCLE, CHECK_LISTENERS} {
+		err, ok := checks[name]
+		if !ok {
+			continue
+		}
+		check := HealthCheck{Name: name}
+		if err != nil {
+			check.Error = err.Error()
+			status.Status = HEALTH_FAILED
+		}
+		status.Checks = append(status.Checks, check)
+	}
+	return status
+}
+
+// checkEtcd reads a single key of the _Server database, the read is linearizable, so it fails without the quorum
+func (s *Server) checkEtcd(ctx context.Context) error {
+	tctx, cancel := context.WithTimeout(ctx, ovsdb.EtcdClientTimeout)
+	defer cancel()
+	_, err := s.cli.Get(tctx, common.NewDBPrefixKey(ovsdb.INT_SERVER).String(), clientv3.WithPrefix(),
+		clientv3.WithCountOnly())
+	return err
+}
+
+func (s *Server) checkSchemas() error {
+	if s.db == nil {
+		return errors.New("the databases are not loaded")
+	}
+	schemas := s.db.GetSchemas()
+	if len(schemas) < len(s.options.SchemaFiles) {
+		return fmt.Errorf("%d of %d schemas are loaded", len(schemas), len(s.options.SchemaFiles))
+	}
+	if _, ok := schemas[ovsdb.INT_SERVER]; !ok {
+		return fmt.Errorf("the %s schema is not loaded", ovsdb.INT_SERVER)
+	}
+	return nil
+}
+
+func checkWatches() error {
+	if dbs := ovsdb.FailedWatches(); len(dbs) > 0 {
+		return fmt.Errorf("the etcd watches of %s are failing", strings.Join(dbs, ", "))
+	}
+	return nil
+}
+
+func (s *Server) checkLifecycle() error {
+	state, err := s.lifecycle.State()
+	if err != nil {
+		return err
+	}
+	if state != ovsdb.REPLICA_SERVING {
+		return fmt.Errorf("the replica is %s", state)
+	}
+	return nil
+}
+
+func (s *Server) checkListeners() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.listeners) == 0 {
+		return errors.New("no listener")
+	}
+	return nil
+}
+
+// healthHandler serves a probe, the response is the JSON status, and its code is 503 if the probe failed
+func healthHandler(probe func(ctx context.Context) HealthStatus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := probe(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if status.Status != HEALTH_OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+}
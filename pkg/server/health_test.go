@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+func TestServerHealthProbes(t *testing.T) {
+	common.SetPrefix("ovsdb/embedded")
+	remote := "punix:" + filepath.Join(t.TempDir(), "db.sock")
+	srv, err := NewServer(Options{
+		Remotes:          []string{remote},
+		EtcdMembers:      []string{"http://127.0.0.1:2379"},
+		SchemaFiles:      []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema"},
+		StorageMigration: true,
+		HTTPAddress:      "127.0.0.1:0",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, srv.Start())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer srv.Shutdown(ctx)
+
+	probe := func(path string) (int, HealthStatus) {
+		var status HealthStatus
+		resp, err := http.Get("http://" + srv.HTTPAddr().String() + path)
+		if !assert.Nil(t, err) {
+			return 0, status
+		}
+		defer resp.Body.Close()
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&status))
+		return resp.StatusCode, status
+	}
+	code, status := probe(HEALTH_PATH)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, HealthStatus{Status: HEALTH_OK, Checks: []HealthCheck{{Name: CHECK_ETCD}, {Name: CHECK_SCHEMAS},
+		{Name: CHECK_WATCHES}}}, status)
+	code, status = probe(READY_PATH)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, HEALTH_OK, status.Status)
+	assert.Equal(t, 5, len(status.Checks))
+
+	// a server without listeners is alive, but not ready
+	assert.Nil(t, srv.RemoveRemote(remote))
+	code, _ = probe(HEALTH_PATH)
+	assert.Equal(t, http.StatusOK, code)
+	code, status = probe(READY_PATH)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, HEALTH_FAILED, status.Status)
+	assert.Contains(t, status.Checks, HealthCheck{Name: CHECK_LISTENERS, Error: "no listener"})
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/jrpc2/metrics"
+
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+const (
+	// gauge of the client connections of all the listeners
+	METRIC_CONNECTIONS_ACTIVE = "connections.active"
+
+	// the prefix of the exported metric names
+	PROMETHEUS_NAMESPACE = "ovsdb"
+	PROMETHEUS_PATH      = "/metrics"
+)
+
+// labeledMetric maps the metrics of a prefix to a single metric family, the rest of a metric name holds the values of
+// the labels, separated by dots. The last label gets the rest of the name, so it may contain dots, e.g. an address.
+type labeledMetric struct {
+	prefix string
+	labels []string
+}
+
+var labeledMetrics = []labeledMetric{
+	{ovsdb.METRIC_TABLE_ROWS_PREFIX, []string{"db", "table"}},
+	{ovsdb.METRIC_TABLE_GROWTH_PREFIX, []string{"db", "table"}},
+	{ovsdb.METRIC_LATENCY_P99, []string{"client"}},
+	{ovsdb.METRIC_LATENCY_PREFIX, []string{"stage"}},
+	{ovsdb.METRIC_EVENT_LOG_PREFIX, []string{"kind"}},
+	{ovsdb.METRIC_NOTIFICATIONS_SENT_PREFIX, []string{"method"}},
+	{ovsdb.METRIC_WATCH_RESTARTS_PREFIX, []string{"db"}},
+	{ovsdb.METRIC_TRANSACT_LATENCY_PREFIX, []string{"db"}},
+	{ovsdb.METRIC_ETCD_LATENCY_PREFIX, []string{"request"}},
+}
+
+// gaugeCounters are the counters, which are decremented as well
+var gaugeCounters = map[string]bool{
+	METRIC_CONNECTIONS_ACTIVE:    true,
+	ovsdb.METRIC_MONITORS_ACTIVE: true,
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricFamily is a Prometheus metric with its samples
+type metricFamily struct {
+	name    string
+	kind    string
+	samples []string
+}
+
+// PrometheusHandler exports the metrics in the Prometheus text format. The counters and the numeric labels of the
+// metrics are exported as counters and gauges, the maximum values as gauges with the "_max" suffix, and the latency
+// histograms counters as histograms.
+func PrometheusHandler(m *metrics.M) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writePrometheus(w, m)
+	})
+}
+
+func writePrometheus(w io.Writer, m *metrics.M) {
+	snap := metrics.Snapshot{Counter: map[string]int64{}, MaxValue: map[string]int64{}, Label: map[string]interface{}{}}
+	m.Snapshot(snap)
+	families := map[string]*metricFamily{}
+	// the histogram samples are named by their families, while the other samples have the family name
+	add := func(name, kind, sample string, value float64) {
+		family, ok := families[name]
+		if !ok {
+			family = &metricFamily{name: name, kind: kind}
+			families[name] = family
+		}
+		family.samples = append(family.samples, sample+" "+strconv.FormatFloat(value, 'g', -1, 64))
+	}
+
+	histograms := map[string]bool{}
+	for name := range snap.Counter {
+		if base := strings.TrimSuffix(name, ".count"); base != name {
+			if _, ok := snap.Counter[base+".sum_us"]; ok {
+				histograms[base] = true
+			}
+		}
+	}
+	bases := make([]string, 0, len(histograms))
+	for base := range histograms {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+	for _, base := range bases {
+		name, labels := prometheusName(base)
+		name += "_seconds"
+		var cumulative int64
+		for _, bound := range ovsdb.LatencyBuckets {
+			cumulative += snap.Counter[fmt.Sprintf("%s.le_%s", base, bound)]
+			le := strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)
+			add(name, "histogram", name+"_bucket"+withLabel(labels, "le", le), float64(cumulative))
+		}
+		count := float64(snap.Counter[base+".count"])
+		add(name, "histogram", name+"_bucket"+withLabel(labels, "le", "+Inf"), count)
+		add(name, "histogram", name+"_sum"+formatLabels(labels), float64(snap.Counter[base+".sum_us"])/1e6)
+		add(name, "histogram", name+"_count"+formatLabels(labels), count)
+	}
+	for key, value := range snap.Counter {
+		if i := strings.LastIndex(key, "."); i > 0 && histograms[key[:i]] {
+			continue
+		}
+		name, labels := prometheusName(key)
+		if gaugeCounters[key] {
+			add(name, "gauge", name+formatLabels(labels), float64(value))
+		} else {
+			name += "_total"
+			add(name, "counter", name+formatLabels(labels), float64(value))
+		}
+	}
+	for key, value := range snap.MaxValue {
+		name, labels := prometheusName(key)
+		name += "_max"
+		add(name, "gauge", name+formatLabels(labels), float64(value))
+	}
+	for key, value := range snap.Label {
+		number, ok := numericValue(value)
+		if !ok {
+			continue
+		}
+		name, labels := prometheusName(key)
+		add(name, "gauge", name+formatLabels(labels), number)
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		family := families[name]
+		if family.kind != "histogram" {
+			sort.Strings(family.samples)
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", family.name, family.kind)
+		for _, sample := range family.samples {
+			fmt.Fprintln(w, sample)
+		}
+	}
+}
+
+// prometheusName returns the metric family name and the label pairs of a metric
+func prometheusName(key string) (string, [][2]string) {
+	var labels [][2]string
+	for _, lm := range labeledMetrics {
+		if !strings.HasPrefix(key, lm.prefix) || len(key) == len(lm.prefix) {
+			continue
+		}
+		values := strings.SplitN(key[len(lm.prefix):], ".", len(lm.labels))
+		if len(values) != len(lm.labels) {
+			continue
+		}
+		for i, label := range lm.labels {
+			labels = append(labels, [2]string{label, values[i]})
+		}
+		key = lm.prefix
+		break
+	}
+	var name strings.Builder
+	name.WriteString(PROMETHEUS_NAMESPACE + "_")
+	for _, r := range strings.TrimSuffix(key, ".") {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			name.WriteRune(r)
+		} else {
+			name.WriteRune('_')
+		}
+	}
+	return name.String(), labels
+}
+
+func withLabel(labels [][2]string, name, value string) string {
+	return formatLabels(append(append([][2]string{}, labels...), [2]string{name, value}))
+}
+
+func formatLabels(labels [][2]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		value := labelEscaper.Replace(label[1])
+		pairs = append(pairs, label[0]+`="`+value+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/metrics"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	m := metrics.New()
+	m.Count(METRIC_CONNECTIONS_ACTIVE, 2)
+	m.Count(METRIC_CONNECTIONS_ACTIVE, -1)
+	m.CountAndSetMax("rpc.bytesRead", 100)
+	m.Count(ovsdb.METRIC_NOTIFICATIONS_SENT_PREFIX+"update3", 5)
+	m.SetLabel(ovsdb.METRIC_TABLE_ROWS_PREFIX+"OVN_Northbound.Logical_Switch", int64(7))
+	m.SetLabel(ovsdb.METRIC_LATENCY_P99+"127.0.0.1:5000", 1.5)
+	m.SetLabel("version", "not a number")
+	// a histogram of two observations, 2ms and 20ms
+	prefix := ovsdb.METRIC_TRANSACT_LATENCY_PREFIX + "OVN_Northbound."
+	m.Count(prefix+"count", 2)
+	m.Count(prefix+"sum_us", 22000)
+	m.Count(prefix+"le_2ms", 1)
+	m.Count(prefix+"le_25ms", 1)
+
+	var buf bytes.Buffer
+	writePrometheus(&buf, m)
+	out := buf.String()
+	expected := []string{
+		"# TYPE ovsdb_connections_active gauge\novsdb_connections_active 1\n",
+		"# TYPE ovsdb_rpc_bytesRead_total counter\novsdb_rpc_bytesRead_total 100\n",
+		"# TYPE ovsdb_rpc_bytesRead_max gauge\novsdb_rpc_bytesRead_max 100\n",
+		"ovsdb_notifications_sent_total{method=\"update3\"} 5\n",
+		"ovsdb_tables_rows{db=\"OVN_Northbound\",table=\"Logical_Switch\"} 7\n",
+		"ovsdb_notifications_latency_p99_ms{client=\"127.0.0.1:5000\"} 1.5\n",
+		"# TYPE ovsdb_transact_latency_seconds histogram\n",
+		"ovsdb_transact_latency_seconds_bucket{db=\"OVN_Northbound\",le=\"0.001\"} 0\n",
+		"ovsdb_transact_latency_seconds_bucket{db=\"OVN_Northbound\",le=\"0.002\"} 1\n",
+		"ovsdb_transact_latency_seconds_bucket{db=\"OVN_Northbound\",le=\"0.025\"} 2\n",
+		"ovsdb_transact_latency_seconds_bucket{db=\"OVN_Northbound\",le=\"+Inf\"} 2\n",
+		"ovsdb_transact_latency_seconds_sum{db=\"OVN_Northbound\"} 0.022\n",
+		"ovsdb_transact_latency_seconds_count{db=\"OVN_Northbound\"} 2\n",
+	}
+	for _, e := range expected {
+		assert.Contains(t, out, e)
+	}
+	assert.NotContains(t, out, "version")
+	assert.NotContains(t, out, "le_")
+}
+
+func TestServerMetricsExport(t *testing.T) {
+	common.SetPrefix("ovsdb/embedded")
+	srv, err := NewServer(Options{
+		Remotes:          []string{"punix:" + filepath.Join(t.TempDir(), "db.sock")},
+		EtcdMembers:      []string{"http://127.0.0.1:2379"},
+		SchemaFiles:      []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema"},
+		StorageMigration: true,
+		HTTPAddress:      "127.0.0.1:0",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, srv.Start())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer srv.Shutdown(ctx)
+
+	conn, err := net.Dial("unix", srv.Addrs()[0].String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	cli := jrpc2.NewClient(channel.RawJSON(conn, conn), &jrpc2.ClientOptions{AllowV1: true})
+	defer cli.Close()
+	var result interface{}
+	assert.Nil(t, cli.CallResult(ctx, "transact", []interface{}{"OVN_Northbound",
+		map[string]interface{}{"op": "select", "table": "NB_Global", "where": []interface{}{}}}, &result))
+
+	resp, err := http.Get("http://" + srv.HTTPAddr().String() + PROMETHEUS_PATH)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	out := string(body)
+	assert.Contains(t, out, "ovsdb_connections_active 1\n")
+	assert.Contains(t, out, "ovsdb_transact_latency_seconds_count{db=\"OVN_Northbound\"} 1\n")
+	assert.True(t, strings.Contains(out, "ovsdb_rpc_requests_total "), out)
+}
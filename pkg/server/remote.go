@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The passive connection methods of the ovsdb-server remotes
+const (
+	REMOTE_PTCP  = "ptcp"
+	REMOTE_PSSL  = "pssl"
+	REMOTE_PUNIX = "punix"
+)
+
+// Remote is a connection target, on which the server listens for clients, in the ovsdb-server syntax:
+// ptcp:<port>[:<ip>], pssl:<port>[:<ip>] or punix:<path>. The TCP remotes listen on all the addresses if the ip is
+// omitted, an IPv6 address is enclosed in square brackets.
+type Remote struct {
+	Method string
+	// the network and the address of the listener, e.g. "tcp" and "127.0.0.1:6641"
+	Network string
+	Address string
+}
+
+// ParseRemote parses a passive connection target, the active targets (tcp:, ssl:, unix:) are not supported
+func ParseRemote(target string) (Remote, error) {
+	i := strings.Index(target, ":")
+	if i < 0 {
+		return Remote{}, fmt.Errorf("wrong remote %q, no connection method", target)
+	}
+	method, rest := target[:i], target[i+1:]
+	switch method {
+	case REMOTE_PTCP, REMOTE_PSSL:
+		port, ip := rest, ""
+		if j := strings.Index(rest, ":"); j >= 0 {
+			port, ip = rest[:j], rest[j+1:]
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			return Remote{}, fmt.Errorf("wrong remote %q, illegal port %q", target, port)
+		}
+		ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+		if len(ip) > 0 && net.ParseIP(ip) == nil {
+			return Remote{}, fmt.Errorf("wrong remote %q, illegal ip %q", target, ip)
+		}
+		return Remote{Method: method, Network: "tcp", Address: net.JoinHostPort(ip, port)}, nil
+	case REMOTE_PUNIX:
+		if len(rest) == 0 {
+			return Remote{}, fmt.Errorf("wrong remote %q, no socket path", target)
+		}
+		return Remote{Method: method, Network: "unix", Address: rest}, nil
+	default:
+		return Remote{}, fmt.Errorf("wrong remote %q, unsupported connection method %q", target, method)
+	}
+}
+
+// String returns the remote in the ovsdb-server syntax
+func (r Remote) String() string {
+	if r.Network == "unix" {
+		return r.Method + ":" + r.Address
+	}
+	host, port, err := net.SplitHostPort(r.Address)
+	if err != nil {
+		return r.Method + ":" + r.Address
+	}
+	if len(host) == 0 {
+		return r.Method + ":" + port
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return r.Method + ":" + port + ":" + host
+}
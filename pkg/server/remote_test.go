@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+func TestParseRemote(t *testing.T) {
+	valid := map[string]Remote{
+		"ptcp:6641":           {Method: REMOTE_PTCP, Network: "tcp", Address: ":6641"},
+		"ptcp:6641:127.0.0.1": {Method: REMOTE_PTCP, Network: "tcp", Address: "127.0.0.1:6641"},
+		"pssl:6641:[::1]":     {Method: REMOTE_PSSL, Network: "tcp", Address: "[::1]:6641"},
+		"punix:/run/db.sock":  {Method: REMOTE_PUNIX, Network: "unix", Address: "/run/db.sock"},
+	}
+	for target, expected := range valid {
+		remote, err := ParseRemote(target)
+		assert.Nil(t, err, target)
+		assert.Equal(t, expected, remote)
+		assert.Equal(t, target, remote.String())
+	}
+	for _, target := range []string{"6641", "tcp:127.0.0.1:6641", "ptcp:port", "ptcp:70000", "ptcp:6641:host", "punix:"} {
+		_, err := ParseRemote(target)
+		assert.NotNil(t, err, target)
+	}
+}
+
+func TestServerRemotes(t *testing.T) {
+	common.SetPrefix("ovsdb/embedded")
+	unixRemote := "punix:" + filepath.Join(t.TempDir(), "db.sock")
+	srv, err := NewServer(Options{
+		Remotes:          []string{"ptcp:0:127.0.0.1", unixRemote},
+		EtcdMembers:      []string{"http://127.0.0.1:2379"},
+		SchemaFiles:      []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema"},
+		StorageMigration: true,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, srv.Start())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer srv.Shutdown(ctx)
+	assert.Equal(t, []string{"ptcp:0:127.0.0.1", unixRemote}, srv.Remotes())
+	addrs := srv.Addrs()
+	if !assert.Equal(t, 2, len(addrs)) {
+		return
+	}
+	connect := func(addr net.Addr) *jrpc2.Client {
+		conn, err := net.Dial(addr.Network(), addr.String())
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		return jrpc2.NewClient(channel.RawJSON(conn, conn), &jrpc2.ClientOptions{AllowV1: true})
+	}
+	tcpCli := connect(addrs[0])
+	unixCli := connect(addrs[1])
+	if tcpCli == nil || unixCli == nil {
+		return
+	}
+	defer tcpCli.Close()
+	defer unixCli.Close()
+	var dbs []string
+	assert.Nil(t, tcpCli.CallResult(ctx, "list_dbs", nil, &dbs))
+	assert.Nil(t, unixCli.CallResult(ctx, "list_dbs", nil, &dbs))
+
+	// the removal of a remote closes its listener and connections, while the other remote keeps serving
+	assert.Nil(t, srv.RemoveRemote("ptcp:0:127.0.0.1"))
+	assert.NotNil(t, srv.RemoveRemote("ptcp:0:127.0.0.1"))
+	_, err = tcpCli.Call(ctx, "echo", []string{"echo"})
+	assert.NotNil(t, err)
+	_, err = net.Dial("tcp", addrs[0].String())
+	assert.NotNil(t, err)
+	assert.Nil(t, unixCli.CallResult(ctx, "list_dbs", nil, &dbs))
+	assert.Equal(t, []string{unixRemote}, srv.Remotes())
+
+	// a remote is added to the running server
+	assert.NotNil(t, srv.AddRemote(unixRemote))
+	assert.Nil(t, srv.AddRemote("ptcp:0:127.0.0.1"))
+	addrs = srv.Addrs()
+	if assert.Equal(t, 2, len(addrs)) {
+		tcpCli = connect(addrs[1])
+		if tcpCli != nil {
+			assert.Nil(t, tcpCli.CallResult(ctx, "list_dbs", nil, &dbs))
+			tcpCli.Close()
+		}
+	}
+
+	// the static remotes are updated, e.g. on a config reload, the unchanged remotes keep serving
+	assert.Nil(t, srv.SetRemotes([]string{unixRemote, "ptcp:0:127.0.0.1"}))
+	assert.Equal(t, []string{unixRemote, "ptcp:0:127.0.0.1"}, srv.Remotes())
+	assert.Nil(t, unixCli.CallResult(ctx, "list_dbs", nil, &dbs))
+	assert.Nil(t, srv.SetRemotes([]string{unixRemote}))
+	assert.Equal(t, []string{unixRemote}, srv.Remotes())
+	assert.Nil(t, unixCli.CallResult(ctx, "list_dbs", nil, &dbs))
+	assert.NotNil(t, srv.SetRemotes([]string{"db:OVN_Northbound,NB_Global,connections"}))
+	assert.NotNil(t, srv.SetRemotes(nil))
+	assert.Equal(t, []string{unixRemote}, srv.Remotes())
+}
+
+func TestServerDbRemote(t *testing.T) {
+	common.SetPrefix("ovsdb/embedded")
+	cli, err := ovsdb.NewEtcdClient([]string{"http://127.0.0.1:2379"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cli.Close()
+	_, err = cli.Delete(context.Background(), common.NewDBPrefixKey("OVN_Northbound").String(), clientv3.WithPrefix())
+	assert.Nil(t, err)
+	defer func(interval time.Duration) { ovsdb.RemoteStatusInterval = interval }(ovsdb.RemoteStatusInterval)
+	ovsdb.RemoteStatusInterval = 100 * time.Millisecond
+
+	unixRemote := "punix:" + filepath.Join(t.TempDir(), "db.sock")
+	dbRemote := "db:OVN_Northbound,NB_Global,connections"
+	srv, err := NewServer(Options{
+		Remotes:          []string{unixRemote, dbRemote},
+		Cli:              cli,
+		SchemaFiles:      []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema"},
+		StorageMigration: true,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, srv.Start())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	defer srv.Shutdown(ctx)
+	assert.Equal(t, []string{unixRemote}, srv.Remotes())
+
+	conn, err := net.Dial("unix", srv.Addrs()[0].String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	ctlCli := jrpc2.NewClient(channel.RawJSON(conn, conn), &jrpc2.ClientOptions{AllowV1: true})
+	defer ctlCli.Close()
+	transact := func(ops ...interface{}) []map[string]interface{} {
+		var result []map[string]interface{}
+		assert.Nil(t, ctlCli.CallResult(ctx, "transact", append([]interface{}{"OVN_Northbound"}, ops...), &result))
+		return result
+	}
+	transact(map[string]interface{}{"op": "insert", "table": "Connection", "uuid-name": "conn",
+		"row": map[string]interface{}{"target": "ptcp:0:127.0.0.1"}},
+		map[string]interface{}{"op": "insert", "table": "NB_Global",
+			"row": map[string]interface{}{"connections": []interface{}{"named-uuid", "conn"}}})
+
+	// the listener of the configured target is added
+	assert.Eventually(t, func() bool { return len(srv.Remotes()) == 2 }, 5*time.Second, 50*time.Millisecond)
+	addrs := srv.Addrs()
+	if !assert.Equal(t, 2, len(addrs)) {
+		return
+	}
+	tcpConn, err := net.Dial("tcp", addrs[1].String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	tcpCli := jrpc2.NewClient(channel.RawJSON(tcpConn, tcpConn), &jrpc2.ClientOptions{AllowV1: true})
+	defer tcpCli.Close()
+	var dbs []string
+	assert.Nil(t, tcpCli.CallResult(ctx, "list_dbs", nil, &dbs))
+
+	// the status of the listener is written to the Connection row
+	assert.Eventually(t, func() bool {
+		result := transact(map[string]interface{}{"op": "select", "table": "Connection", "where": []interface{}{},
+			"columns": []string{"is_connected", "status"}})
+		if len(result) != 1 {
+			return false
+		}
+		rows, _ := json.Marshal(result[0]["rows"])
+		return string(rows) == `[{"is_connected":true,"status":["map",[["n_connections","1"]]]}]`
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// the removal of the reference removes the listener
+	transact(map[string]interface{}{"op": "update", "table": "NB_Global", "where": []interface{}{},
+		"row": map[string]interface{}{"connections": []interface{}{"set", []interface{}{}}}})
+	assert.Eventually(t, func() bool { return len(srv.Remotes()) == 1 }, 5*time.Second, 50*time.Millisecond)
+	_, err = net.Dial("tcp", addrs[1].String())
+	assert.NotNil(t, err)
+}
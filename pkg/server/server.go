@@ -0,0 +1,890 @@
+// Package server runs the ovsdb-etcd service, it is used by the ovsdb-etcd server command, and can be embedded by
+// other programs, e.g. test harnesses.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/creachadair/jrpc2/metrics"
+	"github.com/go-logr/logr"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2/klogr"
+
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+// Options of the server, the global settings of the ovsdb package, e.g. the keys prefix, are not a part of them.
+type Options struct {
+	// the remotes to listen on, in the ovsdb-server syntax, e.g. "ptcp:6641:127.0.0.1" or "punix:/run/ovnnb_db.sock",
+	// at least one is required. A "db:<db-name>,<table>,<column>" remote listens on the targets configured in the
+	// database.
+	Remotes []string
+	// the certificate and the private key files of the pssl remotes, and the CA certificate file, which verifies the
+	// client certificates. The pssl remotes require the certificate, and the client certificates are not required if
+	// the CA certificate is empty.
+	SSLCert string
+	SSLKey  string
+	SSLCA   string
+	// etcd client of the server, if nil a client to the EtcdMembers is created and closed by Shutdown
+	Cli         *clientv3.Client
+	EtcdMembers []string
+	// schema files of the served databases, including the _Server database schema
+	SchemaFiles []string
+	// maximum concurrent transactions and non transaction requests of a connection, the defaults are 1
+	MaxTasks        int
+	MaxControlTasks int
+	// upgrade the storage format of the databases, otherwise the databases of an old storage format are refused
+	StorageMigration bool
+	// the databases, whose client transactions cannot modify them, e.g. of a standby server
+	ReadOnlyDatabases []string
+	// the databases, whose write transactions are serialized with the transactions of the other replicas
+	SerializableDatabases []string
+	// intervals of the background tasks, 0 disables the task
+	LockSweepInterval  time.Duration
+	TableStatsInterval time.Duration
+	// interval of the updates of the status columns of the _Server.Database rows, 0 disables the updates
+	DatabaseStatusInterval time.Duration
+	// run for the leadership of the served databases with the other replicas, the replicas, which don't lead a
+	// database, refuse its write transactions if LeaderOnlyTransactions is set. The refused clients are referred to the
+	// advertised remote of the leader, e.g. "tcp:10.0.0.1:6641".
+	LeaderElection         bool
+	LeaderOnlyTransactions bool
+	AdvertisedRemote       string
+	// the idle time of a connection before it's probed by an echo request, 0 disables the probes, and the time the
+	// server waits for the response before it closes the connection, the default is the probe interval
+	InactivityProbe   time.Duration
+	InactivityTimeout time.Duration
+	// the defaults are no authentication, suppression, redaction and quotas
+	Authenticator    ovsdb.Authenticator
+	SuppressionRules ovsdb.SuppressionRules
+	RedactionPolicy  ovsdb.RedactionPolicy
+	Quota            *ovsdb.ResourceQuota
+	// the default is a new metrics collection
+	Metrics *metrics.M
+	// the address of the HTTP listener, which exports the metrics in the Prometheus format and serves the health and
+	// readiness probes, e.g. ":9310", empty disables the listener
+	HTTPAddress string
+	// the path of the unix control socket, which serves the runtime commands of ovs-appctl, e.g.
+	// "/run/ovsdb-etcd.ctl", empty disables the control socket
+	ControlSocket string
+	// the default is a klog logger
+	Log logr.Logger
+}
+
+// Server serves the OVSDB protocol on its listeners
+type Server struct {
+	options Options
+	log     logr.Logger
+	cli     *clientv3.Client
+	ownCli  bool
+	db      ovsdb.Databaser
+	service *ovsdb.Service
+	admin   *ovsdb.Admin
+	// reports the lifecycle state of the server in the _Server.Replica table
+	lifecycle *ovsdb.LifecycleReporter
+	// maintains the status columns of the _Server.Database rows, nil if the updates are disabled
+	dbStatus *ovsdb.DatabaseStatus
+	// runs for the leadership of the databases, nil if the leader election is disabled
+	election *ovsdb.LeaderElection
+	// the certificates of the pssl remotes, nil if they are not configured
+	certs *certificates
+	// the remotes configured in the database tables
+	dbRemotes []*ovsdb.DbRemote
+	// exports the metrics and serves the probes, nil if the HTTP listener is disabled
+	httpLst    net.Listener
+	httpServer *http.Server
+	// serves the control commands, nil if the control socket is disabled
+	controlLst   net.Listener
+	controlConns map[net.Conn]bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// by their creation order
+	listeners []*listener
+	started   bool
+	stopped   bool
+	wg        sync.WaitGroup
+}
+
+// listener serves the clients of a remote, the listeners are added and removed independently
+type listener struct {
+	remote Remote
+	// the target of the remote, as it's configured
+	target string
+	// the db remote, which configured the target, empty for the static remotes
+	source string
+	lst    net.Listener
+	conns  map[*jrpc2.Server]bool
+}
+
+// NewServer connects to etcd, loads the schemas, and prepares the stored databases to be served: their storage format
+// is checked or migrated, and their interrupted commits are recovered.
+func NewServer(options Options) (*Server, error) {
+	if options.Log == nil {
+		options.Log = klogr.New()
+	}
+	if options.MaxTasks == 0 {
+		options.MaxTasks = 1
+	}
+	if options.MaxControlTasks == 0 {
+		options.MaxControlTasks = 1
+	}
+	if options.MaxTasks < 1 || options.MaxControlTasks < 1 {
+		return nil, fmt.Errorf("illegal max concurrent tasks %d and control tasks %d", options.MaxTasks, options.MaxControlTasks)
+	}
+	if options.Authenticator == nil {
+		authenticator, err := ovsdb.NewAuthenticator(ovsdb.AUTH_METHOD_NONE, "")
+		if err != nil {
+			return nil, err
+		}
+		options.Authenticator = authenticator
+	}
+	if options.Quota == nil {
+		options.Quota = ovsdb.NewResourceQuota(0, 0, 0, 0)
+	}
+	if options.LeaderOnlyTransactions && !options.LeaderElection {
+		return nil, errors.New("the leader only transactions require the leader election")
+	}
+	if options.Metrics == nil {
+		options.Metrics = metrics.New()
+	}
+	remotes := make([]Remote, 0, len(options.Remotes))
+	for _, target := range options.Remotes {
+		if ovsdb.IsDbRemote(target) {
+			// validated against the schemas, when the databases are loaded
+			continue
+		}
+		remote, err := ParseRemote(target)
+		if err != nil {
+			return nil, err
+		}
+		remotes = append(remotes, remote)
+	}
+	s := &Server{options: options, log: options.Log, cli: options.Cli, controlConns: map[net.Conn]bool{}}
+	if len(options.SSLCert) > 0 || len(options.SSLKey) > 0 {
+		certs, err := newCertificates(options.SSLCert, options.SSLKey, options.SSLCA)
+		if err != nil {
+			return nil, err
+		}
+		s.certs = certs
+	} else if len(options.SSLCA) > 0 {
+		return nil, errors.New("the CA certificate requires the TLS certificate and private key")
+	}
+	for _, remote := range remotes {
+		if remote.Method == REMOTE_PSSL && s.certs == nil {
+			return nil, fmt.Errorf("remote %s requires the TLS certificate and private key", remote)
+		}
+	}
+	if s.cli == nil {
+		if len(options.EtcdMembers) == 0 {
+			return nil, errors.New("no etcd client and members")
+		}
+		cli, err := ovsdb.NewEtcdClient(options.EtcdMembers)
+		if err != nil {
+			return nil, err
+		}
+		s.cli = cli
+		s.ownCli = true
+	}
+	if err := s.init(); err != nil {
+		if s.lifecycle != nil {
+			s.lifecycle.Close(context.Background())
+		}
+		if s.ownCli {
+			s.cli.Close()
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Server) init() error {
+	// all the problems of the schema files are reported, before any of them is served
+	if err := ovsdb.ValidateSchemaFiles(s.options.SchemaFiles); err != nil {
+		return err
+	}
+	db, _ := ovsdb.NewDatabaseEtcd(s.cli)
+	s.service = ovsdb.NewService(db)
+	lifecycle, err := ovsdb.NewLifecycleReporter(context.Background(), s.cli, s.service.GetServerId(context.Background()), s.log)
+	if err != nil {
+		return fmt.Errorf("failed to report the replica state: %v", err)
+	}
+	s.lifecycle = lifecycle
+	for _, schemaFile := range s.options.SchemaFiles {
+		if err := db.AddSchema(schemaFile); err != nil {
+			return fmt.Errorf("failed to add schema %s: %v", schemaFile, err)
+		}
+	}
+	migrator := ovsdb.NewStorageMigrator(s.cli, s.log)
+	for dbName := range db.GetSchemas() {
+		if dbName == ovsdb.INT_SERVER {
+			// the _Server database is rewritten on every startup
+			continue
+		}
+		var err error
+		if s.options.StorageMigration {
+			err = migrator.Run(context.Background(), dbName)
+		} else {
+			_, err = migrator.Check(context.Background(), dbName)
+		}
+		if err != nil {
+			return fmt.Errorf("storage format check of %s failed: %v", dbName, err)
+		}
+		// complete a chained commit interrupted by a failure of its server
+		recovered, err := ovsdb.RecoverJournal(context.Background(), s.cli, dbName)
+		if err != nil {
+			return fmt.Errorf("journal recovery of %s failed: %v", dbName, err)
+		}
+		if recovered {
+			s.log.Info("recovered an interrupted commit", "dbName", dbName)
+		}
+		// index the rows written before the indexes were maintained
+		built, err := ovsdb.BuildIndexes(context.Background(), s.cli, dbName, db.GetSchemas()[dbName])
+		if err != nil {
+			return fmt.Errorf("indexes build of %s failed: %v", dbName, err)
+		}
+		if built > 0 {
+			s.log.Info("built index entries", "dbName", dbName, "entries", built)
+		}
+	}
+	for _, dbName := range s.options.ReadOnlyDatabases {
+		if err := db.SetReadOnly(dbName, true); err != nil {
+			return fmt.Errorf("read-only database: %v", err)
+		}
+	}
+	for _, dbName := range s.options.SerializableDatabases {
+		if err := db.SetSerializable(dbName, true); err != nil {
+			return fmt.Errorf("serializable database: %v", err)
+		}
+	}
+	s.db = db
+	s.admin = ovsdb.NewAdmin(db, s.log)
+	if s.options.LeaderElection {
+		candidate := ovsdb.LeaderCandidate{Replica: s.service.GetServerId(context.Background()),
+			Remote: s.options.AdvertisedRemote}
+		s.election = ovsdb.NewLeaderElection(s.cli, candidate, s.log)
+	}
+	s.service.SetDatabaseChangeHandler(s.admin.DatabaseChanged)
+	for _, target := range s.options.Remotes {
+		if ovsdb.IsDbRemote(target) {
+			dbRemote, err := ovsdb.NewDbRemote(target, db, s.cli, s.log)
+			if err != nil {
+				return err
+			}
+			s.dbRemotes = append(s.dbRemotes, dbRemote)
+		}
+	}
+	return nil
+}
+
+// Database returns the served databases
+func (s *Server) Database() ovsdb.Databaser {
+	return s.db
+}
+
+// Start starts the background tasks, and serves the clients on the listeners until Shutdown is called
+func (s *Server) Start() error {
+	if len(s.options.Remotes) == 0 {
+		return errors.New("no remote to listen on")
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if err := s.lifecycle.SetState(s.ctx, ovsdb.REPLICA_WARMING); err != nil {
+		return err
+	}
+	ovsdb.SetMetrics(s.options.Metrics)
+	if s.options.LockSweepInterval > 0 {
+		ovsdb.NewLockSweeper(s.cli, s.options.LockSweepInterval, s.options.Metrics, s.log).Start(s.ctx)
+	}
+	// the conversions of the databases by the other replicas are adopted
+	if db, ok := s.db.(*ovsdb.DatabaseEtcd); ok {
+		db.WatchSchemas(s.ctx, func(dbName string) { s.admin.DatabaseChanged(s.ctx, dbName) })
+		if ovsdb.TransactionRowCache {
+			db.StartRowCaches(s.ctx)
+		}
+	}
+	if s.options.TableStatsInterval > 0 {
+		ovsdb.NewTableStats(s.cli, s.db, s.options.TableStatsInterval, s.options.Metrics, s.log).Start(s.ctx)
+	}
+	if len(s.options.HTTPAddress) > 0 {
+		if err := s.serveHTTP(); err != nil {
+			return fmt.Errorf("failed to listen on the HTTP address: %v", err)
+		}
+	}
+	if len(s.options.ControlSocket) > 0 {
+		if err := s.serveControl(); err != nil {
+			s.Shutdown(context.Background())
+			return fmt.Errorf("failed to listen on the control socket: %v", err)
+		}
+	}
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+	for _, target := range s.options.Remotes {
+		if ovsdb.IsDbRemote(target) {
+			continue
+		}
+		if err := s.AddRemote(target); err != nil {
+			s.Shutdown(context.Background())
+			return err
+		}
+	}
+	for _, dbRemote := range s.dbRemotes {
+		source := dbRemote.String()
+		if err := dbRemote.Start(s.ctx, func(targets []string) { s.setDbTargets(source, targets) }); err != nil {
+			s.Shutdown(context.Background())
+			return fmt.Errorf("failed to monitor the remote %s: %v", source, err)
+		}
+		s.writeRemoteStatus(dbRemote)
+	}
+	if err := s.lifecycle.SetState(s.ctx, ovsdb.REPLICA_SERVING); err != nil {
+		return err
+	}
+	if s.options.DatabaseStatusInterval > 0 {
+		s.dbStatus = ovsdb.NewDatabaseStatus(s.cli, s.db, s.options.DatabaseStatusInterval, s.log)
+		if s.election != nil {
+			s.dbStatus.SetLeader(s.election.HasLeader)
+		}
+		s.updateDatabaseStatus(s.ctx)
+		s.dbStatus.Start(s.ctx)
+	}
+	if s.election != nil {
+		dbNames := []string{}
+		for dbName := range s.db.GetSchemas() {
+			if dbName != ovsdb.INT_SERVER {
+				dbNames = append(dbNames, dbName)
+			}
+		}
+		// the new leader reports the leadership without waiting for the next status update
+		s.election.SetOnChange(func(dbName string, leader bool) {
+			s.updateDatabaseStatus(s.ctx)
+		})
+		s.election.Start(s.ctx, dbNames)
+	}
+	return nil
+}
+
+func (s *Server) updateDatabaseStatus(ctx context.Context) {
+	if s.dbStatus == nil {
+		return
+	}
+	if _, err := s.dbStatus.Update(ctx); err != nil {
+		s.log.Error(err, "failed to update the databases status")
+	}
+}
+
+// Election returns the leader election of the databases, nil if it's disabled
+func (s *Server) Election() *ovsdb.LeaderElection {
+	return s.election
+}
+
+// DatabaseStatus returns the maintainer of the _Server.Database status columns, nil if it's disabled
+func (s *Server) DatabaseStatus() *ovsdb.DatabaseStatus {
+	return s.dbStatus
+}
+
+// AddRemote starts to listen on the remote, while the other listeners are not affected
+func (s *Server) AddRemote(target string) error {
+	if ovsdb.IsDbRemote(target) {
+		return fmt.Errorf("remote %s, the db remotes are configured on the server startup", target)
+	}
+	return s.addRemote(target, "")
+}
+
+func (s *Server) addRemote(target, source string) error {
+	remote, err := ParseRemote(target)
+	if err != nil {
+		return err
+	}
+	if remote.Method == REMOTE_PSSL && s.certs == nil {
+		return fmt.Errorf("remote %s requires the TLS certificate and private key", remote)
+	}
+	if remote.Network == "unix" && runtime.GOOS != "linux" {
+		return fmt.Errorf("remote %s, unix sockets are supported on linux only", remote)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started || s.stopped {
+		return errors.New("the server is not running")
+	}
+	for _, l := range s.listeners {
+		if l.remote == remote {
+			return fmt.Errorf("remote %s already exists", remote)
+		}
+	}
+	if remote.Network == "unix" {
+		if err := os.RemoveAll(remote.Address); err != nil {
+			return err
+		}
+	}
+	lst, err := net.Listen(remote.Network, remote.Address)
+	if err != nil {
+		return err
+	}
+	if remote.Method == REMOTE_PSSL {
+		lst = tls.NewListener(lst, s.certs.config())
+	}
+	l := &listener{remote: remote, target: target, source: source, lst: lst, conns: map[*jrpc2.Server]bool{}}
+	s.listeners = append(s.listeners, l)
+	s.serve(l)
+	return nil
+}
+
+// RemoveRemote stops to listen on the remote, and closes the connections of its clients
+func (s *Server) RemoveRemote(target string) error {
+	remote, err := ParseRemote(target)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.listeners {
+		if l.remote == remote {
+			s.removeListener(l)
+			l.close()
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown remote %s", remote)
+}
+
+// Remotes returns the remotes the server listens on
+func (s *Server) Remotes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	remotes := make([]string, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		remotes = append(remotes, l.remote.String())
+	}
+	return remotes
+}
+
+// SetRemotes updates the listeners of the static remotes to the targets, e.g. when the configuration file is reloaded,
+// the listeners of the unchanged remotes are not affected. The remotes, which were added by AddRemote and are not in
+// the targets, are removed as well.
+func (s *Server) SetRemotes(targets []string) error {
+	for _, target := range targets {
+		if ovsdb.IsDbRemote(target) {
+			return fmt.Errorf("remote %s, the db remotes are configured on the server startup", target)
+		}
+		if _, err := ParseRemote(target); err != nil {
+			return err
+		}
+	}
+	if len(targets) == 0 && len(s.dbRemotes) == 0 {
+		return errors.New("no remote to listen on")
+	}
+	s.setDbTargets("", targets)
+	return nil
+}
+
+// setDbTargets updates the listeners of the db remote to the targets, which are read from the database, or of the
+// static remotes, if the source is empty. The invalid targets are ignored, like ovsdb-server does.
+func (s *Server) setDbTargets(source string, targets []string) {
+	wanted := map[string]bool{}
+	for _, target := range targets {
+		wanted[target] = true
+	}
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	for _, l := range append([]*listener{}, s.listeners...) {
+		if l.source != source {
+			continue
+		}
+		if wanted[l.target] {
+			delete(wanted, l.target)
+			continue
+		}
+		s.log.Info("removed remote", "remote", l.remote, "source", source)
+		s.removeListener(l)
+		l.close()
+	}
+	s.mu.Unlock()
+	for _, target := range targets {
+		if !wanted[target] {
+			continue
+		}
+		if err := s.addRemote(target, source); err != nil {
+			s.log.Error(err, "failed to add remote", "target", target, "source", source)
+		}
+	}
+}
+
+// writeRemoteStatus periodically writes the number of the connections of the db remote listeners into the database
+func (s *Server) writeRemoteStatus(dbRemote *ovsdb.DbRemote) {
+	source := dbRemote.String()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(ovsdb.RemoteStatusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			status := map[string]ovsdb.RemoteStatus{}
+			s.mu.Lock()
+			for _, l := range s.listeners {
+				if l.source == source {
+					status[l.target] = ovsdb.RemoteStatus{Connections: len(l.conns)}
+				}
+			}
+			s.mu.Unlock()
+			if err := dbRemote.WriteStatus(s.ctx, status); err != nil && s.ctx.Err() == nil {
+				s.log.Error(err, "failed to write the remotes status", "source", source)
+			}
+		}
+	}()
+}
+
+// removeListener should be called under the server lock
+func (s *Server) removeListener(l *listener) {
+	for i, other := range s.listeners {
+		if other == l {
+			s.listeners = append(s.listeners[:i], s.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// close closes the listener and the connections of its clients, should be called under the server lock
+func (l *listener) close() {
+	l.lst.Close()
+	for srv := range l.conns {
+		srv.Stop()
+	}
+}
+
+// ReloadCertificates reloads the TLS certificate files, the new connections are served with the new certificates,
+// while the established connections are not affected.
+func (s *Server) ReloadCertificates() error {
+	if s.certs == nil {
+		return errors.New("TLS is not configured")
+	}
+	if err := s.certs.load(); err != nil {
+		return err
+	}
+	s.log.Info("reloaded the TLS certificates", "cert", s.options.SSLCert, "ca", s.options.SSLCA)
+	return nil
+}
+
+// TLSEnabled returns true if the TLS certificates of the pssl remotes are configured
+func (s *Server) TLSEnabled() bool {
+	return s.certs != nil
+}
+
+// serveHTTP exports the metrics and serves the health and readiness probes over HTTP
+func (s *Server) serveHTTP() error {
+	lst, err := net.Listen("tcp", s.options.HTTPAddress)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(PROMETHEUS_PATH, PrometheusHandler(s.options.Metrics))
+	mux.Handle(HEALTH_PATH, healthHandler(s.Health))
+	mux.Handle(READY_PATH, healthHandler(s.Readiness))
+	s.httpLst = lst
+	s.httpServer = &http.Server{Handler: mux}
+	s.log.Info("serving metrics and probes", "on", lst.Addr())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.httpServer.Serve(lst); err != nil && err != http.ErrServerClosed {
+			s.log.Error(err, "HTTP listener failed")
+		}
+	}()
+	return nil
+}
+
+// HTTPAddr returns the address of the HTTP listener, nil if it's disabled
+func (s *Server) HTTPAddr() net.Addr {
+	if s.httpLst == nil {
+		return nil
+	}
+	return s.httpLst.Addr()
+}
+
+// Addrs returns the addresses of the listeners, e.g. the TCP port chosen for the address "127.0.0.1:0"
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.lst.Addr())
+	}
+	return addrs
+}
+
+// Shutdown closes the listeners and the client connections, and waits until the connections are cleaned up or the
+// context is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	if err := s.lifecycle.SetState(ctx, ovsdb.REPLICA_DRAINING); err != nil {
+		s.log.Error(err, "failed to report the draining state")
+	}
+	// another replica is elected without waiting for the candidacies of the server to expire
+	if s.election != nil {
+		if err := s.election.Close(); err != nil {
+			s.log.Error(err, "failed to give up the leadership")
+		}
+	}
+	// the clients learn that the databases are disconnected, if the server is the last serving replica
+	s.updateDatabaseStatus(ctx)
+	for _, l := range s.listeners {
+		l.close()
+	}
+	if s.controlLst != nil {
+		s.controlLst.Close()
+		for conn := range s.controlConns {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	for _, dbRemote := range s.dbRemotes {
+		dbRemote.Stop()
+	}
+	if s.httpServer != nil {
+		s.httpServer.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if lerr := s.lifecycle.Close(ctx); lerr != nil {
+		s.log.Error(lerr, "failed to remove the replica state")
+	}
+	if s.ownCli {
+		s.cli.Close()
+	}
+	return err
+}
+
+// serve accepts the clients of the listener, a listener, which fails to accept, is removed while the other listeners
+// keep serving. It should be called under the server lock.
+func (s *Server) serve(l *listener) {
+	s.log.Info("listening", "remote", l.remote, "on", l.lst.Addr())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.loop(l); err != nil {
+			s.log.Error(err, "failed accepting new connection", "remote", l.remote)
+			s.mu.Lock()
+			s.removeListener(l)
+			l.close()
+			s.mu.Unlock()
+		}
+	}()
+}
+
+func (s *Server) loop(l *listener) error {
+	lst := l.lst
+	remote := lst.Addr().Network() + ":" + lst.Addr().String()
+	servOptions := &jrpc2.ServerOptions{
+		Concurrency: ovsdb.SchedulerConcurrency(s.options.MaxTasks, s.options.MaxControlTasks),
+		Metrics:     s.options.Metrics,
+		AllowPush:   true,
+		AllowV1:     true,
+	}
+	for {
+		rawConn, err := lst.Accept()
+		if err != nil {
+			s.log.V(5).Info("accept", "on", lst.Addr(), "error", err, "is-closing", channel.IsErrClosing(err))
+			if channel.IsErrClosing(err) {
+				err = nil
+			}
+			return err
+		}
+		conn := ConnWrapper{intConn: rawConn, log: s.log}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(l, rawConn, conn, remote, servOptions)
+		}()
+	}
+}
+
+func (s *Server) serveConn(l *listener, rawConn net.Conn, conn ConnWrapper, remote string, servOptions *jrpc2.ServerOptions) {
+	identity, err := s.options.Authenticator.Authenticate(rawConn)
+	if err != nil {
+		s.log.Error(err, "authentication failed", "from", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	if role := s.connectionRole(l); role != "" && identity != nil {
+		// the role of the Connection row of the target overrides the role of the authenticator
+		withRole := *identity
+		withRole.Role = role
+		identity = &withRole
+	}
+	tctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := ovsdb.NewHandler(tctx, s.db, s.cli, s.log)
+	handler.SetSuppressionRules(s.options.SuppressionRules, remote)
+	handler.SetRedactionPolicy(s.options.RedactionPolicy)
+	handler.SetQuota(s.options.Quota)
+	handler.SetLockRegistry(s.admin.LockRegistry())
+	if s.options.LeaderOnlyTransactions {
+		handler.SetLeaderElection(s.election)
+	}
+	handler.SetIdentity(identity, s.options.Authenticator)
+	s.log.V(5).Info("new connection", "from", conn.RemoteAddr())
+	assigner := ovsdb.NewRequestScheduler(handler.Authorized(createServicesMap(s.service, s.admin, handler)), s.options.MaxTasks, s.options.MaxControlTasks)
+	srv := jrpc2.NewServer(assigner, servOptions)
+	handler.SetConnection(srv, conn)
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		conn.Close()
+		handler.Cleanup()
+		return
+	}
+	l.conns[srv] = true
+	s.mu.Unlock()
+	s.options.Metrics.Count(METRIC_CONNECTIONS_ACTIVE, 1)
+
+	s.admin.AddHandler(handler)
+	srv.Start(channel.RawJSON(handler.TrackActivity(conn), assigner.HintsWriter(conn)))
+	go handler.KeepAlive(s.options.InactivityProbe, s.options.InactivityTimeout)
+	stat := srv.WaitStatus()
+	s.log.V(5).Info("connection", "from", conn.RemoteAddr(), "stopped", stat.Stopped(), "closed", stat.Closed(), "success", stat.Success(), "err", stat.Err)
+	if stat.Err != nil {
+		s.log.Error(stat.Err, "Server exit")
+	}
+	s.mu.Lock()
+	delete(l.conns, srv)
+	s.mu.Unlock()
+	s.options.Metrics.Count(METRIC_CONNECTIONS_ACTIVE, -1)
+	s.admin.RemoveHandler(handler)
+	handler.Cleanup()
+}
+
+// connectionRole returns the RBAC role of the clients of the listener, which is configured by the row of its target,
+// empty for the static remotes and the targets without a role
+func (s *Server) connectionRole(l *listener) string {
+	if l.source == "" {
+		return ""
+	}
+	for _, dbRemote := range s.dbRemotes {
+		if dbRemote.String() == l.source {
+			return dbRemote.Role(l.target)
+		}
+	}
+	return ""
+}
+
+// we pass handlerMap by value, so the function gets a proprietary copy of it.
+func createServicesMap(sharedService *ovsdb.Service, admin *ovsdb.Admin, clientHandler *ovsdb.Handler) *handler.Map {
+	handlerMap := make(handler.Map)
+	handlerMap["list_dbs"] = handler.New(sharedService.ListDbs)
+	handlerMap["get_schema"] = handler.New(sharedService.GetSchema)
+	handlerMap["get_server_id"] = handler.New(sharedService.GetServerId)
+	handlerMap["convert"] = handler.New(sharedService.Convert)
+
+	handlerMap["transact"] = handler.New(clientHandler.Transact)
+	handlerMap["cancel"] = handler.New(clientHandler.Cancel)
+	handlerMap["monitor"] = handler.New(clientHandler.Monitor)
+	handlerMap["monitor_cancel"] = handler.New(clientHandler.MonitorCancel)
+	handlerMap["lock"] = handler.New(clientHandler.Lock)
+	handlerMap["steal"] = handler.New(clientHandler.Steal)
+	handlerMap["unlock"] = handler.New(clientHandler.Unlock)
+	handlerMap["monitor_cond"] = handler.New(clientHandler.MonitorCond)
+	handlerMap["monitor_cond_since"] = handler.New(clientHandler.MonitorCondSince)
+	handlerMap["monitor_cond_change"] = handler.New(clientHandler.MonitorCondChange)
+	handlerMap["set_db_change_aware"] = handler.New(clientHandler.SetDbChangeAware)
+	handlerMap["echo"] = handler.New(clientHandler.Echo)
+
+	// ovsdb-etcd extensions
+	handlerMap["get_server_info"] = handler.New(sharedService.GetServerInfo)
+	handlerMap["freeze"] = handler.New(admin.Freeze)
+	handlerMap["read_only"] = handler.New(admin.ReadOnly)
+	handlerMap["resync"] = handler.New(admin.Resync)
+	handlerMap["cancel_monitor"] = handler.New(admin.CancelMonitor)
+	handlerMap["quarantine"] = handler.New(admin.Quarantine)
+	handlerMap["repair"] = handler.New(admin.Repair)
+	handlerMap["storage_stats"] = handler.New(admin.StorageStats)
+	handlerMap["commit_log"] = handler.New(admin.CommitLog)
+	handlerMap["list_locks"] = handler.New(admin.ListLocks)
+	handlerMap["list_connections"] = handler.New(admin.ListConnections)
+	handlerMap["dump"] = handler.New(admin.Dump)
+	handlerMap["authenticate"] = handler.New(clientHandler.Authenticate)
+	handlerMap["last_delivered"] = handler.New(clientHandler.LastDelivered)
+	handlerMap["client_last_delivered"] = handler.New(admin.LastDelivered)
+	return &handlerMap
+}
+
+// temporary for development purpose wrapper
+type ConnWrapper struct {
+	intConn net.Conn
+	log     logr.Logger
+}
+
+func (cw ConnWrapper) Read(b []byte) (n int, err error) {
+	n, err = cw.intConn.Read(b)
+	cw.log.V(7).Info("read", "from", cw.intConn.RemoteAddr(), "bytes", n, "error", err)
+	return
+}
+
+func (cw ConnWrapper) Write(b []byte) (n int, err error) {
+	n, err = cw.intConn.Write(b)
+	cw.log.V(7).Info("write", "from", cw.intConn.RemoteAddr(), "bytes", n, "error", err)
+	return
+}
+
+func (cw ConnWrapper) Close() error {
+	cw.log.V(5).Info("close", "from", cw.intConn.RemoteAddr())
+	return cw.intConn.Close()
+}
+
+func (cw ConnWrapper) LocalAddr() net.Addr {
+	return cw.intConn.LocalAddr()
+}
+
+func (cw ConnWrapper) RemoteAddr() net.Addr {
+	return cw.intConn.RemoteAddr()
+}
+
+func (cw ConnWrapper) SetDeadline(t time.Time) error {
+	return cw.intConn.SetDeadline(t)
+}
+
+func (cw ConnWrapper) SetReadDeadline(t time.Time) error {
+	return cw.intConn.SetReadDeadline(t)
+}
+
+func (cw ConnWrapper) SetWriteDeadline(t time.Time) error {
+	return cw.intConn.SetWriteDeadline(t)
+}
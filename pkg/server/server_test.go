@@ -0,0 +1,491 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+	"github.com/ibm/ovsdb-etcd/pkg/hints"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+func TestServerEmbedded(t *testing.T) {
+	common.SetPrefix("ovsdb/embedded")
+	srv, err := NewServer(Options{
+		Remotes:          []string{"ptcp:0:127.0.0.1"},
+		EtcdMembers:      []string{"http://127.0.0.1:2379"},
+		SchemaFiles:      []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema"},
+		StorageMigration: true,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, srv.Start())
+	addrs := srv.Addrs()
+	if !assert.Equal(t, 1, len(addrs)) {
+		srv.Shutdown(context.Background())
+		return
+	}
+
+	conn, err := net.Dial("tcp", addrs[0].String())
+	assert.Nil(t, err)
+	cli := jrpc2.NewClient(channel.RawJSON(conn, conn), &jrpc2.ClientOptions{AllowV1: true})
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var dbs []string
+	assert.Nil(t, cli.CallResult(ctx, "list_dbs", nil, &dbs))
+	assert.ElementsMatch(t, []string{"_Server", "OVN_Northbound"}, dbs)
+
+	// the replica state is reported in the _Server database
+	var serverID string
+	assert.Nil(t, cli.CallResult(ctx, "get_server_id", nil, &serverID))
+	var result []map[string][]map[string]interface{}
+	assert.Nil(t, cli.CallResult(ctx, "transact", []interface{}{"_Server",
+		map[string]interface{}{"op": "select", "table": "Replica", "where": []interface{}{[]interface{}{"name", "==", serverID}}, "columns": []string{"state"}}}, &result))
+	if assert.Equal(t, 1, len(result)) && assert.Equal(t, 1, len(result[0]["rows"])) {
+		assert.Equal(t, ovsdb.REPLICA_SERVING, result[0]["rows"][0]["state"])
+	}
+
+	// the connections are closed by the shutdown
+	assert.Nil(t, srv.Shutdown(ctx))
+	_, err = cli.Call(ctx, "echo", []string{"echo"})
+	assert.NotNil(t, err)
+	_, err = net.Dial("tcp", addrs[0].String())
+	assert.NotNil(t, err)
+	// the shutdown is idempotent
+	assert.Nil(t, srv.Shutdown(ctx))
+	cli2, err := ovsdb.NewEtcdClient([]string{"http://127.0.0.1:2379"})
+	assert.Nil(t, err)
+	defer cli2.Close()
+	states, err := ovsdb.GetReplicaStates(ctx, cli2)
+	assert.Nil(t, err)
+	assert.NotContains(t, states, serverID)
+}
+
+func TestServerConvert(t *testing.T) {
+	common.SetPrefix("ovsdb/embedded")
+	srv, err := NewServer(Options{
+		Remotes:          []string{"ptcp:0:127.0.0.1"},
+		EtcdMembers:      []string{"http://127.0.0.1:2379"},
+		SchemaFiles:      []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema"},
+		StorageMigration: true,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, srv.Start())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer srv.Shutdown(ctx)
+
+	conn, err := net.Dial("tcp", srv.Addrs()[0].String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	canceled := make(chan []interface{}, 1)
+	cli := jrpc2.NewClient(channel.RawJSON(conn, conn), &jrpc2.ClientOptions{AllowV1: true,
+		OnNotify: func(req *jrpc2.Request) {
+			if req.Method() == "monitor_canceled" {
+				var params []interface{}
+				req.UnmarshalParams(&params)
+				canceled <- params
+			}
+		}})
+	defer cli.Close()
+	var result interface{}
+	assert.Nil(t, cli.CallResult(ctx, "monitor_cond", []interface{}{"OVN_Northbound", "nb",
+		map[string]interface{}{"NB_Global": []interface{}{map[string]interface{}{}}}}, &result))
+
+	// a db change aware client gets the notifications of the conversion, and the connection of an unaware client is
+	// closed
+	awareConn, err := net.Dial("tcp", srv.Addrs()[0].String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	awareNotifications := make(chan string, 10)
+	aware := jrpc2.NewClient(channel.RawJSON(awareConn, awareConn), &jrpc2.ClientOptions{AllowV1: true,
+		OnNotify: func(req *jrpc2.Request) {
+			awareNotifications <- req.Method()
+		}})
+	defer aware.Close()
+	assert.Nil(t, aware.CallResult(ctx, "set_db_change_aware", []interface{}{true}, &result))
+	assert.Nil(t, aware.CallResult(ctx, "monitor_cond", []interface{}{"OVN_Northbound", "nb",
+		map[string]interface{}{"NB_Global": []interface{}{map[string]interface{}{}}}}, &result))
+	assert.Nil(t, aware.CallResult(ctx, "monitor_cond", []interface{}{"_Server", "server",
+		map[string]interface{}{"Database": []interface{}{map[string]interface{}{}}}}, &result))
+	unawareConn, err := net.Dial("tcp", srv.Addrs()[0].String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	unaware := jrpc2.NewClient(channel.RawJSON(unawareConn, unawareConn), &jrpc2.ClientOptions{AllowV1: true})
+	defer unaware.Close()
+	assert.Nil(t, unaware.CallResult(ctx, "set_db_change_aware", []interface{}{false}, &result))
+
+	data, err := ioutil.ReadFile("../../schemas/ovn-nb.ovsschema")
+	assert.Nil(t, err)
+	schema := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(data, &schema))
+	schema["version"] = "100.0.0"
+	columns := schema["tables"].(map[string]interface{})["NB_Global"].(map[string]interface{})["columns"].(map[string]interface{})
+	columns["test_column"] = map[string]interface{}{"type": "string"}
+	assert.Nil(t, cli.CallResult(ctx, "convert", []interface{}{"OVN_Northbound", schema}, &result))
+	select {
+	case params := <-canceled:
+		assert.Equal(t, "nb", params[0])
+		assert.Equal(t, map[string]interface{}{"reason": ovsdb.CANCEL_REASON_SCHEMA_CONVERTED}, params[1])
+	case <-ctx.Done():
+		t.Error("monitor_canceled was not received")
+	}
+	received := map[string]bool{}
+	for !received["monitor_canceled"] || !received["update2"] {
+		select {
+		case method := <-awareNotifications:
+			received[method] = true
+		case <-ctx.Done():
+			t.Fatalf("the db change aware client received %v", received)
+		}
+	}
+	_, err = unaware.Call(ctx, "echo", []string{"echo"})
+	assert.NotNil(t, err)
+	var newSchema map[string]interface{}
+	assert.Nil(t, cli.CallResult(ctx, "get_schema", []string{"OVN_Northbound"}, &newSchema))
+	assert.Equal(t, "100.0.0", newSchema["version"])
+
+	// a schema of another database
+	_, err = cli.Call(ctx, "convert", []interface{}{"_Server", schema})
+	assert.NotNil(t, err)
+
+	// the original schema is restored for the other tests
+	assert.Nil(t, cli.CallResult(ctx, "convert", []interface{}{"OVN_Northbound", json.RawMessage(data)}, &result))
+}
+
+func TestServerMultipleDatabases(t *testing.T) {
+	common.SetPrefix("ovsdb/embedded")
+	srv, err := NewServer(Options{
+		Remotes:     []string{"ptcp:0:127.0.0.1"},
+		EtcdMembers: []string{"http://127.0.0.1:2379"},
+		SchemaFiles: []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema",
+			"../../schemas/ovn-sb.ovsschema"},
+		StorageMigration: true,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, srv.Start())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer srv.Shutdown(ctx)
+
+	conn, err := net.Dial("tcp", srv.Addrs()[0].String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	cli := jrpc2.NewClient(channel.RawJSON(conn, conn), &jrpc2.ClientOptions{AllowV1: true})
+	defer cli.Close()
+	var dbs []string
+	assert.Nil(t, cli.CallResult(ctx, "list_dbs", nil, &dbs))
+	assert.ElementsMatch(t, []string{"_Server", "OVN_Northbound", "OVN_Southbound"}, dbs)
+	for _, dbName := range []string{"OVN_Northbound", "OVN_Southbound"} {
+		var schema map[string]interface{}
+		assert.Nil(t, cli.CallResult(ctx, "get_schema", []string{dbName}, &schema))
+		assert.Equal(t, dbName, schema["name"])
+	}
+
+	// the databases are served by the same connection concurrently
+	var result interface{}
+	assert.Nil(t, cli.CallResult(ctx, "monitor_cond", []interface{}{"OVN_Northbound", "nb",
+		map[string]interface{}{"NB_Global": []interface{}{map[string]interface{}{}}}}, &result))
+	assert.Nil(t, cli.CallResult(ctx, "monitor_cond", []interface{}{"OVN_Southbound", "sb",
+		map[string]interface{}{"SB_Global": []interface{}{map[string]interface{}{}}}}, &result))
+	var rows []map[string]interface{}
+	assert.Nil(t, cli.CallResult(ctx, "transact", []interface{}{"OVN_Southbound",
+		map[string]interface{}{"op": "select", "table": "SB_Global", "where": []interface{}{}}}, &rows))
+	if assert.Equal(t, 1, len(rows)) {
+		assert.Nil(t, rows[0]["error"])
+	}
+	// the tables are resolved by the database of the request
+	assert.Nil(t, cli.CallResult(ctx, "transact", []interface{}{"OVN_Southbound",
+		map[string]interface{}{"op": "select", "table": "NB_Global", "where": []interface{}{}}}, &rows))
+	if assert.NotEqual(t, 0, len(rows)) {
+		assert.NotNil(t, rows[len(rows)-1]["error"])
+	}
+	assert.Nil(t, srv.Shutdown(ctx))
+
+	// a database is served once
+	_, err = NewServer(Options{
+		Remotes:     []string{"ptcp:0:127.0.0.1"},
+		EtcdMembers: []string{"http://127.0.0.1:2379"},
+		SchemaFiles: []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema",
+			"../../schemas/ovn-nb.ovsschema"},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestServerReadOnlyDatabase(t *testing.T) {
+	common.SetPrefix("ovsdb/embedded")
+	srv, err := NewServer(Options{
+		Remotes:           []string{"ptcp:0:127.0.0.1"},
+		EtcdMembers:       []string{"http://127.0.0.1:2379"},
+		SchemaFiles:       []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema"},
+		StorageMigration:  true,
+		ReadOnlyDatabases: []string{"OVN_Northbound"},
+		// read_only is served to the admin clients only
+		Authenticator: &ovsdb.StaticTokenAuthenticator{Role: ovsdb.ADMIN_ROLE, Tokens: map[string]string{"operator": "secret"}},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, srv.Start())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer srv.Shutdown(ctx)
+
+	conn, err := net.Dial("tcp", srv.Addrs()[0].String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	cli := jrpc2.NewClient(channel.RawJSON(conn, conn), &jrpc2.ClientOptions{AllowV1: true})
+	defer cli.Close()
+	var result interface{}
+	assert.Nil(t, cli.CallResult(ctx, "authenticate", []interface{}{"secret"}, &result))
+	assert.Nil(t, cli.CallResult(ctx, "monitor_cond", []interface{}{"OVN_Northbound", "nb",
+		map[string]interface{}{"Logical_Switch": []interface{}{map[string]interface{}{}}}}, &result))
+	insert := []interface{}{"OVN_Northbound", map[string]interface{}{"op": "insert", "table": "Logical_Switch",
+		"row": map[string]interface{}{"name": "read-only"}}}
+	var rows []map[string]interface{}
+	assert.Nil(t, cli.CallResult(ctx, "transact", insert, &rows))
+	if assert.Equal(t, 1, len(rows)) {
+		assert.Equal(t, ovsdb.E_NOT_ALLOWED, rows[0]["error"])
+	}
+
+	// the mode is changed at runtime
+	assert.Nil(t, cli.CallResult(ctx, "read_only", []interface{}{"OVN_Northbound", false}, &result))
+	rows = nil
+	assert.Nil(t, cli.CallResult(ctx, "transact", insert, &rows))
+	if assert.Equal(t, 1, len(rows)) {
+		assert.Nil(t, rows[0]["error"])
+	}
+	assert.Nil(t, cli.CallResult(ctx, "transact", []interface{}{"OVN_Northbound", map[string]interface{}{
+		"op": "delete", "table": "Logical_Switch", "where": []interface{}{[]interface{}{"name", "==", "read-only"}}}},
+		&rows))
+}
+
+func TestServerRejectionHints(t *testing.T) {
+	common.SetPrefix("ovsdb/embedded")
+	srv, err := NewServer(Options{
+		Remotes:          []string{"ptcp:0:127.0.0.1"},
+		EtcdMembers:      []string{"http://127.0.0.1:2379"},
+		SchemaFiles:      []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema"},
+		StorageMigration: true,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, srv.Start())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer srv.Shutdown(ctx)
+
+	conn, err := net.Dial("tcp", srv.Addrs()[0].String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	decoder := json.NewDecoder(conn)
+	call := func(id int, method string, params ...interface{}) map[string]interface{} {
+		buf, err := json.Marshal(map[string]interface{}{"id": id, "method": method, "params": params})
+		assert.Nil(t, err)
+		_, err = conn.Write(buf)
+		assert.Nil(t, err)
+		response := map[string]interface{}{}
+		assert.Nil(t, decoder.Decode(&response))
+		assert.Equal(t, float64(id), response["id"])
+		return response
+	}
+	assert.Nil(t, srv.db.SetFrozen("OVN_Northbound", true))
+	defer srv.db.SetFrozen("OVN_Northbound", false)
+
+	// the hint of the rejected transaction is sent in the details of the error object
+	response := call(2, "transact", "OVN_Northbound", map[string]interface{}{"op": "insert", "table": "Logical_Switch",
+		"row": map[string]interface{}{"name": "frozen"}})
+	errObject, ok := response["error"].(map[string]interface{})
+	if assert.True(t, ok, "error object %v", response["error"]) {
+		assert.Equal(t, ovsdb.E_FROZEN, errObject["error"])
+		details, _ := errObject["details"].(string)
+		hint, ok := hints.FromDetails(details)
+		if assert.True(t, ok, "details %v", errObject["details"]) {
+			assert.Equal(t, hints.REASON_FROZEN, hint.Reason)
+			assert.Equal(t, ovsdb.FrozenRetryAfter, hint.RetryAfterDuration())
+		}
+	}
+	// the errors without hints have no details
+	response = call(3, "get_schema", "unknown")
+	errObject, ok = response["error"].(map[string]interface{})
+	if assert.True(t, ok, "error object %v", response["error"]) {
+		assert.NotContains(t, errObject, "details")
+	}
+}
+
+func TestServerLeaderElection(t *testing.T) {
+	common.SetPrefix("ovsdb/embedded")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	newServer := func(advertised string) *Server {
+		srv, err := NewServer(Options{
+			Remotes:                []string{"ptcp:0:127.0.0.1"},
+			EtcdMembers:            []string{"http://127.0.0.1:2379"},
+			SchemaFiles:            []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema"},
+			StorageMigration:       true,
+			DatabaseStatusInterval: time.Second,
+			LeaderElection:         true,
+			LeaderOnlyTransactions: true,
+			AdvertisedRemote:       advertised,
+		})
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		assert.Nil(t, srv.Start())
+		return srv
+	}
+	leader := newServer("tcp:leader:6641")
+	if leader == nil {
+		return
+	}
+	defer leader.Shutdown(ctx)
+	assert.Eventually(t, func() bool { return leader.Election().IsLeader("OVN_Northbound") }, 5*time.Second,
+		10*time.Millisecond)
+	standby := newServer("tcp:standby:6641")
+	if standby == nil {
+		return
+	}
+	defer standby.Shutdown(ctx)
+	assert.False(t, standby.Election().IsLeader("OVN_Northbound"))
+
+	conn, err := net.Dial("tcp", standby.Addrs()[0].String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	cli := jrpc2.NewClient(channel.RawJSON(conn, conn), &jrpc2.ClientOptions{AllowV1: true})
+	defer cli.Close()
+	// the standby refuses the write transactions, and serves the read ones
+	insert := []interface{}{"OVN_Northbound", map[string]interface{}{"op": "insert", "table": "Logical_Switch",
+		"row": map[string]interface{}{"name": "standby"}}}
+	var rows []map[string]interface{}
+	err = cli.CallResult(ctx, "transact", insert, &rows)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), ovsdb.E_NOT_LEADER)
+	}
+	selectDatabase := []interface{}{"_Server", map[string]interface{}{"op": "select", "table": "Database",
+		"where": []interface{}{[]interface{}{"name", "==", "OVN_Northbound"}}, "columns": []string{"connected", "leader"}}}
+	assert.Nil(t, cli.CallResult(ctx, "transact", selectDatabase, &rows))
+	if assert.Equal(t, 1, len(rows)) {
+		assert.Equal(t, []interface{}{map[string]interface{}{"connected": true, "leader": true}}, rows[0]["rows"])
+	}
+
+	// the standby is elected when the leader shuts down
+	assert.Nil(t, leader.Shutdown(ctx))
+	assert.Eventually(t, func() bool { return standby.Election().IsLeader("OVN_Northbound") }, 5*time.Second,
+		10*time.Millisecond)
+	rows = nil
+	assert.Nil(t, cli.CallResult(ctx, "transact", insert, &rows))
+	if assert.Equal(t, 1, len(rows)) {
+		assert.Nil(t, rows[0]["error"])
+	}
+	assert.Nil(t, cli.CallResult(ctx, "transact", []interface{}{"OVN_Northbound", map[string]interface{}{
+		"op": "delete", "table": "Logical_Switch", "where": []interface{}{[]interface{}{"name", "==", "standby"}}}},
+		&rows))
+}
+
+func TestServerSchemaReplicas(t *testing.T) {
+	common.SetPrefix("ovsdb/embedded")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	newServer := func() *Server {
+		srv, err := NewServer(Options{
+			Remotes:          []string{"ptcp:0:127.0.0.1"},
+			EtcdMembers:      []string{"http://127.0.0.1:2379"},
+			SchemaFiles:      []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema"},
+			StorageMigration: true,
+		})
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		assert.Nil(t, srv.Start())
+		return srv
+	}
+	connect := func(srv *Server, onNotify func(req *jrpc2.Request)) *jrpc2.Client {
+		conn, err := net.Dial("tcp", srv.Addrs()[0].String())
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		return jrpc2.NewClient(channel.RawJSON(conn, conn), &jrpc2.ClientOptions{AllowV1: true, OnNotify: onNotify})
+	}
+	first := newServer()
+	if first == nil {
+		return
+	}
+	defer first.Shutdown(ctx)
+	second := newServer()
+	if second == nil {
+		return
+	}
+	defer second.Shutdown(ctx)
+	firstCli := connect(first, nil)
+	canceled := make(chan []interface{}, 1)
+	secondCli := connect(second, func(req *jrpc2.Request) {
+		if req.Method() == "monitor_canceled" {
+			var params []interface{}
+			req.UnmarshalParams(&params)
+			canceled <- params
+		}
+	})
+	if firstCli == nil || secondCli == nil {
+		return
+	}
+	defer firstCli.Close()
+	defer secondCli.Close()
+
+	var schema map[string]interface{}
+	assert.Nil(t, secondCli.CallResult(ctx, "get_schema", []string{"OVN_Northbound"}, &schema))
+	assert.Equal(t, "5.31.0", schema["version"])
+	assert.Equal(t, "2352750632 28701", schema["cksum"])
+	var result interface{}
+	assert.Nil(t, secondCli.CallResult(ctx, "set_db_change_aware", []interface{}{true}, &result))
+	assert.Nil(t, secondCli.CallResult(ctx, "monitor_cond", []interface{}{"OVN_Northbound", "nb",
+		map[string]interface{}{"NB_Global": []interface{}{map[string]interface{}{}}}}, &result))
+
+	// the conversion by the first replica is adopted by the second one
+	data, err := ioutil.ReadFile("../../schemas/ovn-nb.ovsschema")
+	assert.Nil(t, err)
+	converted := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(data, &converted))
+	converted["version"] = "100.0.0"
+	delete(converted, "cksum")
+	assert.Nil(t, firstCli.CallResult(ctx, "convert", []interface{}{"OVN_Northbound", converted}, &result))
+	select {
+	case params := <-canceled:
+		assert.Equal(t, map[string]interface{}{"reason": ovsdb.CANCEL_REASON_SCHEMA_CONVERTED}, params[1])
+	case <-ctx.Done():
+		t.Error("monitor_canceled was not received from the second replica")
+	}
+	schema = nil
+	assert.Nil(t, secondCli.CallResult(ctx, "get_schema", []string{"OVN_Northbound"}, &schema))
+	assert.Equal(t, "100.0.0", schema["version"])
+	assert.NotEmpty(t, schema["cksum"])
+	assert.NotEqual(t, "2352750632 28701", schema["cksum"])
+	assert.Equal(t, "100.0.0", second.Database().GetSchemas()["OVN_Northbound"].Version)
+
+	// the original schema is restored for the other tests
+	assert.Nil(t, firstCli.CallResult(ctx, "convert", []interface{}{"OVN_Northbound", json.RawMessage(data)}, &result))
+	assert.Eventually(t, func() bool {
+		return second.Database().GetSchemas()["OVN_Northbound"].Version == "5.31.0"
+	}, 5*time.Second, 10*time.Millisecond)
+}
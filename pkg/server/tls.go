@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// certificates holds the TLS certificate of the server and the CA certificates, which verify the client certificates.
+// They are reloaded on demand, e.g. on SIGHUP, and the new certificates are used by the new connections, like the
+// pssl: remotes of ovsdb-server.
+type certificates struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// nil if the client certificates are not verified
+	clientCAs *x509.CertPool
+}
+
+func newCertificates(certFile, keyFile, caFile string) (*certificates, error) {
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return nil, fmt.Errorf("TLS requires both the certificate and the private key")
+	}
+	c := &certificates{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the certificate files, the current certificates are kept if any of the files is invalid
+func (c *certificates) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the certificate %s: %v", c.certFile, err)
+	}
+	var clientCAs *x509.CertPool
+	if len(c.caFile) > 0 {
+		data, err := ioutil.ReadFile(c.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the CA certificate %s: %v", c.caFile, err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no CA certificate in %s", c.caFile)
+		}
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.clientCAs = clientCAs
+	c.mu.Unlock()
+	return nil
+}
+
+// config returns the TLS configuration of the listener, every connection is configured by the current certificates
+func (c *certificates) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*c.cert},
+			}
+			if c.clientCAs != nil {
+				config.ClientCAs = c.clientCAs
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return config, nil
+		},
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ibm/ovsdb-etcd/pkg/common"
+)
+
+// testCertificate creates a certificate of the given common name signed by the parent, or a self-signed CA if the
+// parent is nil
+func testCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent = template
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestServerTLS(t *testing.T) {
+	common.SetPrefix("ovsdb/embedded")
+	dir := t.TempDir()
+	ca, caKey, caPEM, _ := testCertificate(t, "ca", nil, nil)
+	_, _, serverPEM, serverKeyPEM := testCertificate(t, "server1", ca, caKey)
+	_, _, clientPEM, clientKeyPEM := testCertificate(t, "client", ca, caKey)
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.pem")
+	assert.Nil(t, ioutil.WriteFile(certFile, serverPEM, 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, serverKeyPEM, 0600))
+	assert.Nil(t, ioutil.WriteFile(caFile, caPEM, 0600))
+
+	srv, err := NewServer(Options{
+		Remotes:          []string{"pssl:0:127.0.0.1"},
+		SSLCert:          certFile,
+		SSLKey:           keyFile,
+		SSLCA:            caFile,
+		EtcdMembers:      []string{"http://127.0.0.1:2379"},
+		SchemaFiles:      []string{"../../schemas/_server.ovsschema", "../../schemas/ovn-nb.ovsschema"},
+		StorageMigration: true,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, srv.Start())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer srv.Shutdown(ctx)
+	addr := srv.Addrs()[0].String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKeyPEM)
+	assert.Nil(t, err)
+	dial := func(certs []tls.Certificate) (*tls.Conn, error) {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, Certificates: certs})
+		if err != nil {
+			return nil, err
+		}
+		return conn, conn.Handshake()
+	}
+
+	conn, err := dial([]tls.Certificate{clientCert})
+	if assert.Nil(t, err) {
+		assert.Equal(t, "server1", conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+		cli := jrpc2.NewClient(channel.RawJSON(conn, conn), &jrpc2.ClientOptions{AllowV1: true})
+		var dbs []string
+		assert.Nil(t, cli.CallResult(ctx, "list_dbs", nil, &dbs))
+		assert.ElementsMatch(t, []string{"_Server", "OVN_Northbound"}, dbs)
+		cli.Close()
+	}
+
+	// the client certificate is required
+	conn, err = dial(nil)
+	if err == nil {
+		// TLS 1.3 reports the rejected client certificate on the first read
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	assert.NotNil(t, err)
+
+	// the reloaded certificate is used by the new connections
+	_, _, serverPEM, serverKeyPEM = testCertificate(t, "server2", ca, caKey)
+	assert.Nil(t, ioutil.WriteFile(certFile, serverPEM, 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, serverKeyPEM, 0600))
+	assert.Nil(t, srv.ReloadCertificates())
+	conn, err = dial([]tls.Certificate{clientCert})
+	if assert.Nil(t, err) {
+		assert.Equal(t, "server2", conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+		conn.Close()
+	}
+
+	// an invalid certificate doesn't replace the current one
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte("invalid"), 0600))
+	assert.NotNil(t, srv.ReloadCertificates())
+	conn, err = dial([]tls.Certificate{clientCert})
+	if assert.Nil(t, err) {
+		assert.Equal(t, "server2", conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+		conn.Close()
+	}
+}
@@ -3,6 +3,7 @@ package _Server
 import "github.com/ibm/ovsdb-etcd/pkg/libovsdb"
 
 type Database struct {
+	Build     libovsdb.OvsMap `json:"build,omitempty"`
 	Cid       libovsdb.OvsSet `json:"cid,omitempty"`
 	Connected bool            `json:"connected,omitempty"`
 	Index     libovsdb.OvsSet `json:"index,omitempty"`
@@ -14,3 +15,11 @@ type Database struct {
 	Version   libovsdb.UUID   `json:"_version,omitempty"`
 	Uuid      libovsdb.UUID   `json:"_uuid,omitempty"`
 }
+
+type Replica struct {
+	Build   libovsdb.OvsMap `json:"build,omitempty"`
+	Name    string          `json:"name,omitempty"`
+	State   string          `json:"state,omitempty"`
+	Version libovsdb.UUID   `json:"_version,omitempty"`
+	Uuid    libovsdb.UUID   `json:"_uuid,omitempty"`
+}